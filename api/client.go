@@ -370,6 +370,39 @@ func (c *Client) ListRunning(ctx context.Context) (*ProcessResponse, error) {
 	return &lr, nil
 }
 
+// CreateSession creates a chat session whose history is stored by the
+// server. Pass its ID in [ChatRequest.SessionID] to continue the chat.
+func (c *Client) CreateSession(ctx context.Context, req *CreateSessionRequest) (*Session, error) {
+	var resp Session
+	if err := c.do(ctx, http.MethodPost, "/api/sessions", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// ListSessions lists chat sessions, without their history.
+func (c *Client) ListSessions(ctx context.Context) (*ListSessionsResponse, error) {
+	var resp ListSessionsResponse
+	if err := c.do(ctx, http.MethodGet, "/api/sessions", nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// ShowSession obtains a chat session with its history.
+func (c *Client) ShowSession(ctx context.Context, id string) (*Session, error) {
+	var resp Session
+	if err := c.do(ctx, http.MethodGet, "/api/sessions/"+url.PathEscape(id), nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// DeleteSession deletes a chat session and its history.
+func (c *Client) DeleteSession(ctx context.Context, id string) error {
+	return c.do(ctx, http.MethodDelete, "/api/sessions/"+url.PathEscape(id), nil, nil)
+}
+
 // Copy copies a model - creating a model with another name from an existing
 // model.
 func (c *Client) Copy(ctx context.Context, req *CopyRequest) error {
@@ -1,6 +1,7 @@
 package api
 
 import (
+	"crypto/sha256"
 	"encoding/json"
 	"fmt"
 	"log/slog"
@@ -84,7 +85,9 @@ type GenerateRequest struct {
 	// Raw set to true means that no formatting will be applied to the prompt.
 	Raw bool `json:"raw,omitempty"`
 
-	// Format specifies the format to return a response in.
+	// Format specifies the format to return a response in: "json", a JSON
+	// schema, {"type": "regex", "pattern": ...} or
+	// {"type": "choice", "values": [...]}.
 	Format json.RawMessage `json:"format,omitempty"`
 
 	// KeepAlive controls how long the model will stay loaded in memory following
@@ -130,7 +133,13 @@ type ChatRequest struct {
 	// Stream enables streaming of returned responses; true by default.
 	Stream *bool `json:"stream,omitempty"`
 
-	// Format is the format to return the response in (e.g. "json").
+	// SessionID is the ID of a session created with [Client.CreateSession].
+	// The session's history is put before Messages, and Messages and the
+	// response are added to the session.
+	SessionID string `json:"session_id,omitempty"`
+
+	// Format is the format to return the response in (e.g. "json"). It
+	// takes the same values as GenerateRequest.Format.
 	Format json.RawMessage `json:"format,omitempty"`
 
 	// KeepAlive controls how long the model will stay loaded into memory
@@ -140,6 +149,10 @@ type ChatRequest struct {
 	// Tools is an optional list of tools the model has access to.
 	Tools `json:"tools,omitempty"`
 
+	// ToolChoice controls whether the model must call one of Tools. It can
+	// be "auto" (the default), "none", "required", or a specific function.
+	ToolChoice *ToolChoice `json:"tool_choice,omitempty"`
+
 	// Options lists model-specific options.
 	Options map[string]any `json:"options"`
 
@@ -156,11 +169,66 @@ type ChatRequest struct {
 	// when hitting the context length limit instead of erroring.
 	Shift *bool `json:"shift,omitempty"`
 
+	// Compaction controls how the chat history is shortened when Truncate
+	// is set and it exceeds the context length. By default the oldest
+	// messages are dropped.
+	Compaction *Compaction `json:"compaction,omitempty"`
+
 	// DebugRenderOnly is a debug option that, when set to true, returns the rendered
 	// template instead of calling the model.
 	DebugRenderOnly bool `json:"_debug_render_only,omitempty"`
 }
 
+// Compaction is the strategy used to shorten chat history that exceeds the
+// context length.
+type Compaction struct {
+	// Strategy is "truncate" to drop the oldest messages, or "summarize" to
+	// replace them with a summary.
+	Strategy string `json:"strategy"`
+
+	// Model is the model that writes the summary. It defaults to the chat's
+	// model; a smaller model is faster.
+	Model string `json:"model,omitempty"`
+}
+
+// CompactionResult reports how the history of a chat was shortened, so
+// clients that keep the history can shorten it the same way with
+// [CompactionResult.Apply].
+type CompactionResult struct {
+	Strategy string `json:"strategy"`
+
+	// Messages is the number of messages, not counting system messages,
+	// from the start of the request's messages that were left out of the
+	// prompt. System messages are always kept.
+	Messages int `json:"messages"`
+
+	// Summary is the content of the system message that replaced them, if
+	// they were summarized.
+	Summary string `json:"summary,omitempty"`
+}
+
+// Apply returns msgs, the messages of the request, shortened the way the
+// server shortened them: the first r.Messages messages that aren't system
+// messages are removed, and the summary is added after the system messages
+// among them.
+func (r CompactionResult) Apply(msgs []Message) []Message {
+	compacted := make([]Message, 0, len(msgs))
+	i, n := 0, r.Messages
+	for ; i < len(msgs) && n > 0; i++ {
+		if msgs[i].Role == "system" {
+			compacted = append(compacted, msgs[i])
+		} else {
+			n--
+		}
+	}
+
+	if r.Summary != "" {
+		compacted = append(compacted, Message{Role: "system", Content: r.Summary})
+	}
+
+	return append(compacted, msgs[i:]...)
+}
+
 type Tools []Tool
 
 func (t Tools) String() string {
@@ -186,6 +254,11 @@ type Message struct {
 	ToolCalls  []ToolCall  `json:"tool_calls,omitempty"`
 	ToolName   string      `json:"tool_name,omitempty"`
 	ToolCallID string      `json:"tool_call_id,omitempty"`
+
+	// ToolCallDeltas contains fragments of tool calls that are still being
+	// generated when streaming. Each call is also sent in full in ToolCalls
+	// once it's complete.
+	ToolCallDeltas []ToolCallDelta `json:"tool_call_deltas,omitempty"`
 }
 
 func (m *Message) UnmarshalJSON(b []byte) error {
@@ -203,6 +276,19 @@ func (m *Message) UnmarshalJSON(b []byte) error {
 type ToolCall struct {
 	ID       string           `json:"id,omitempty"`
 	Function ToolCallFunction `json:"function"`
+
+	// Errors lists the arguments that don't match the parameters of a
+	// strict function, if any.
+	Errors []ToolCallError `json:"errors,omitempty"`
+}
+
+// ToolCallError describes an argument of a tool call that doesn't match the
+// function's parameters.
+type ToolCallError struct {
+	// Path is a JSON pointer to the argument, such as "/location" or
+	// "/dates/0", or empty for the arguments as a whole.
+	Path    string `json:"path"`
+	Message string `json:"message"`
 }
 
 type ToolCallFunction struct {
@@ -213,6 +299,17 @@ type ToolCallFunction struct {
 
 type ToolCallFunctionArguments map[string]any
 
+// ToolCallDelta is a fragment of a tool call streamed while the model is
+// generating it. Deltas for the same call share an ID and Index, and the
+// first one has the function's name. Concatenating their Arguments gives the
+// call's arguments as JSON.
+type ToolCallDelta struct {
+	ID        string `json:"id,omitempty"`
+	Index     int    `json:"index"`
+	Name      string `json:"name,omitempty"`
+	Arguments string `json:"arguments,omitempty"`
+}
+
 func (t *ToolCallFunctionArguments) String() string {
 	bts, _ := json.Marshal(t)
 	return string(bts)
@@ -336,6 +433,10 @@ type ToolFunction struct {
 	Name        string                 `json:"name"`
 	Description string                 `json:"description,omitempty"`
 	Parameters  ToolFunctionParameters `json:"parameters"`
+
+	// Strict validates the arguments of calls to the function against
+	// Parameters, which then can't have properties that aren't declared.
+	Strict bool `json:"strict,omitempty"`
 }
 
 func (t *ToolFunction) String() string {
@@ -367,6 +468,10 @@ type ChatResponse struct {
 	// DoneReason is the reason the model stopped generating text.
 	DoneReason string `json:"done_reason,omitempty"`
 
+	// Compaction reports how the chat history was shortened to fit the
+	// context length, in the final response of a request with Compaction.
+	Compaction *CompactionResult `json:"compaction,omitempty"`
+
 	DebugInfo *DebugInfo `json:"_debug_info,omitempty"`
 
 	Metrics
@@ -385,6 +490,14 @@ type Metrics struct {
 	PromptEvalDuration time.Duration `json:"prompt_eval_duration,omitempty"`
 	EvalCount          int           `json:"eval_count,omitempty"`
 	EvalDuration       time.Duration `json:"eval_duration,omitempty"`
+
+	// TimeToFirstToken is the time from when the request reached the runner
+	// until the first token was generated, including any time spent waiting
+	// for other requests.
+	TimeToFirstToken time.Duration `json:"time_to_first_token,omitempty"`
+
+	// InterTokenLatency is the average time between generated tokens.
+	InterTokenLatency time.Duration `json:"inter_token_latency,omitempty"`
 }
 
 // Options specified in [GenerateRequest].  If you add a new option here, also
@@ -406,6 +519,10 @@ type Options struct {
 	PresencePenalty  float32  `json:"presence_penalty,omitempty"`
 	FrequencyPenalty float32  `json:"frequency_penalty,omitempty"`
 	Stop             []string `json:"stop,omitempty"`
+
+	// NumDraft is the maximum number of tokens proposed per step by prompt
+	// lookup speculative decoding. 0 disables speculation.
+	NumDraft int `json:"num_draft,omitempty"`
 }
 
 // Runner options which must be set when the model is loaded into memory
@@ -416,6 +533,25 @@ type Runner struct {
 	MainGPU   int   `json:"main_gpu,omitempty"`
 	UseMMap   *bool `json:"use_mmap,omitempty"`
 	NumThread int   `json:"num_thread,omitempty"`
+
+	// KvCacheType is the data type of the K/V cache, optionally with a
+	// per-layer policy such as "q8_0,first=2,last=2". It overrides
+	// OLLAMA_KV_CACHE_TYPE for this model.
+	KvCacheType string `json:"kv_cache_type,omitempty"`
+
+	// RopeScalingType extends the context length of the model beyond what it
+	// was trained on. It is one of "none", "linear", "yarn" or "static-ntk".
+	// Dynamic NTK scaling is not supported. Which types are supported depends
+	// on the model architecture.
+	RopeScalingType string `json:"rope_scaling_type,omitempty"`
+
+	// RopeScalingFactor is the ratio of the extended context length to the
+	// original context length. If unset, it is derived from num_ctx.
+	RopeScalingFactor float32 `json:"rope_scaling_factor,omitempty"`
+
+	// RopeOriginalContext is the context length the model was trained on. If
+	// unset, it is read from the model.
+	RopeOriginalContext int `json:"rope_original_context,omitempty"`
 }
 
 // EmbedRequest is the request passed to [Client.Embed].
@@ -482,6 +618,10 @@ type CreateRequest struct {
 	// Quantize is the quantization format for the model; leave blank to not change the quantization level.
 	Quantize string `json:"quantize,omitempty"`
 
+	// QuantizeRules override the type of tensors matching their pattern
+	// when quantizing. The first matching rule applies.
+	QuantizeRules []QuantizeRule `json:"quantize_rules,omitempty"`
+
 	// From is the name of the model or file to use as the source.
 	From string `json:"from,omitempty"`
 
@@ -494,6 +634,14 @@ type CreateRequest struct {
 	// Adapters is a map of LoRA adapters to include when creating the model.
 	Adapters map[string]string `json:"adapters,omitempty"`
 
+	// MergeAdapters folds the adapters into the weights of the model
+	// instead of adding them as separate layers.
+	MergeAdapters bool `json:"merge_adapters,omitempty"`
+
+	// Imatrix is a map containing an importance matrix, or calibration text
+	// to compute one from, to guide quantization.
+	Imatrix map[string]string `json:"imatrix,omitempty"`
+
 	// Template is the template used when constructing a request to the model.
 	Template string `json:"template,omitempty"`
 
@@ -521,6 +669,32 @@ type CreateRequest struct {
 	Quantization string `json:"quantization,omitempty"`
 }
 
+// QuantizeRule sets the type of the tensors whose names match Pattern when
+// quantizing a model.
+type QuantizeRule struct {
+	// Pattern is a glob matched against tensor names, such as
+	// "blk.*.attn_v.weight". See [path.Match] for the syntax.
+	Pattern string `json:"pattern"`
+
+	// Type is the tensor type, such as "q6_K" or "f16".
+	Type string `json:"type"`
+}
+
+// SourceDigest returns a digest of the inputs of r. It is recorded with the
+// created model so clients can tell whether creating it again would make any
+// changes. Changes to the model r is created from are not reflected in the
+// digest.
+func (r CreateRequest) SourceDigest() (string, error) {
+	r.Model, r.Name, r.Stream = "", "", nil
+
+	b, err := json.Marshal(r)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("sha256:%x", sha256.Sum256(b)), nil
+}
+
 // DeleteRequest is the request passed to [Client.Delete].
 type DeleteRequest struct {
 	Model string `json:"model"`
@@ -562,6 +736,21 @@ type ShowResponse struct {
 	Tensors       []Tensor           `json:"tensors,omitempty"`
 	Capabilities  []model.Capability `json:"capabilities,omitempty"`
 	ModifiedAt    time.Time          `json:"modified_at,omitempty"`
+	Signer        *ModelSigner       `json:"signer,omitempty"`
+	SourceDigest  string             `json:"source_digest,omitempty"`
+}
+
+// ModelSigner describes the key a model was signed with.
+type ModelSigner struct {
+	// PublicKey is the signer's public key in authorized_keys format.
+	PublicKey string `json:"public_key"`
+
+	// Fingerprint is the SHA256 fingerprint of PublicKey.
+	Fingerprint string `json:"fingerprint"`
+
+	// Trusted reports whether PublicKey is trusted to sign models in the
+	// model's namespace.
+	Trusted bool `json:"trusted"`
 }
 
 // CopyRequest is the request passed to [Client.Copy].
@@ -599,6 +788,10 @@ type PushRequest struct {
 	Password string `json:"password"`
 	Stream   *bool  `json:"stream,omitempty"`
 
+	// Sign attaches a signature made with the server's key to the pushed
+	// model so that it can be verified when it is pulled.
+	Sign bool `json:"sign,omitempty"`
+
 	// Deprecated: set the model name with Model instead
 	Name string `json:"name"`
 }
@@ -608,6 +801,28 @@ type ListResponse struct {
 	Models []ListModelResponse `json:"models"`
 }
 
+// Session is a chat whose history is stored by the server.
+type Session struct {
+	ID        string    `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	// Messages is the session's history. It's empty when listing sessions.
+	Messages []Message `json:"messages,omitempty"`
+}
+
+// CreateSessionRequest is the request passed to [Client.CreateSession].
+type CreateSessionRequest struct {
+	// Messages is the initial history of the session, such as a system
+	// message.
+	Messages []Message `json:"messages,omitempty"`
+}
+
+// ListSessionsResponse is the response from [Client.ListSessions].
+type ListSessionsResponse struct {
+	Sessions []Session `json:"sessions"`
+}
+
 // ProcessResponse is the response from [Client.Process].
 type ProcessResponse struct {
 	Models []ProcessModelResponse `json:"models"`
@@ -635,6 +850,12 @@ type ProcessModelResponse struct {
 	ExpiresAt     time.Time    `json:"expires_at"`
 	SizeVRAM      int64        `json:"size_vram"`
 	ContextLength int          `json:"context_length"`
+
+	// KVCacheType is the layout of the K/V cache, such as "q8_0,first=2"
+	KVCacheType string `json:"kv_cache_type,omitempty"`
+
+	// SizeKVCache is the memory used by the K/V cache
+	SizeKVCache int64 `json:"size_kv_cache,omitempty"`
 }
 
 type TokenResponse struct {
@@ -734,6 +955,14 @@ func (m *Metrics) Summary() {
 		fmt.Fprintf(os.Stderr, "eval duration:        %s\n", m.EvalDuration)
 		fmt.Fprintf(os.Stderr, "eval rate:            %.2f tokens/s\n", float64(m.EvalCount)/m.EvalDuration.Seconds())
 	}
+
+	if m.TimeToFirstToken > 0 {
+		fmt.Fprintf(os.Stderr, "time to first token:  %s\n", m.TimeToFirstToken)
+	}
+
+	if m.InterTokenLatency > 0 {
+		fmt.Fprintf(os.Stderr, "inter-token latency:  %s\n", m.InterTokenLatency)
+	}
 }
 
 func (opts *Options) FromMap(m map[string]any) error {
@@ -965,6 +1194,69 @@ func (t *ThinkValue) MarshalJSON() ([]byte, error) {
 	return json.Marshal(t.Value)
 }
 
+// ToolChoice controls which tools a model calls. In JSON it's "auto", "none",
+// "required", or an object naming a function the model must call:
+//
+//	{"type": "function", "function": {"name": "get_weather"}}
+type ToolChoice struct {
+	// Mode is "auto", "none" or "required", or empty when Function is set
+	Mode string
+
+	// Function is the name of the function the model must call
+	Function string
+}
+
+// IsNone returns true if the model must not call any tools
+func (t *ToolChoice) IsNone() bool {
+	return t != nil && t.Mode == "none"
+}
+
+// IsRequired returns true if the model must call a tool, either any tool or
+// Function
+func (t *ToolChoice) IsRequired() bool {
+	return t != nil && (t.Mode == "required" || t.Function != "")
+}
+
+// UnmarshalJSON implements json.Unmarshaler
+func (t *ToolChoice) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		if s != "auto" && s != "none" && s != "required" {
+			return fmt.Errorf("invalid tool_choice value: %q (must be \"auto\", \"none\", \"required\" or a function)", s)
+		}
+		*t = ToolChoice{Mode: s}
+		return nil
+	}
+
+	var f struct {
+		Type     string `json:"type"`
+		Function struct {
+			Name string `json:"name"`
+		} `json:"function"`
+	}
+	if err := json.Unmarshal(data, &f); err != nil {
+		return fmt.Errorf("tool_choice must be a string or an object with a function name")
+	}
+
+	if f.Type != "function" || f.Function.Name == "" {
+		return fmt.Errorf(`tool_choice object must have type "function" and a function name`)
+	}
+
+	*t = ToolChoice{Function: f.Function.Name}
+	return nil
+}
+
+// MarshalJSON implements json.Marshaler
+func (t ToolChoice) MarshalJSON() ([]byte, error) {
+	if t.Function != "" {
+		return json.Marshal(map[string]any{
+			"type":     "function",
+			"function": map[string]string{"name": t.Function},
+		})
+	}
+	return json.Marshal(t.Mode)
+}
+
 type Duration struct {
 	time.Duration
 }
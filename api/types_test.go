@@ -550,3 +550,86 @@ func TestToolFunctionParameters_String(t *testing.T) {
 		})
 	}
 }
+
+func TestToolChoice_UnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected *ToolChoice
+		err      bool
+	}{
+		{name: "unset", input: `{}`},
+		{name: "auto", input: `{"tool_choice": "auto"}`, expected: &ToolChoice{Mode: "auto"}},
+		{name: "none", input: `{"tool_choice": "none"}`, expected: &ToolChoice{Mode: "none"}},
+		{name: "required", input: `{"tool_choice": "required"}`, expected: &ToolChoice{Mode: "required"}},
+		{name: "function", input: `{"tool_choice": {"type": "function", "function": {"name": "get_weather"}}}`, expected: &ToolChoice{Function: "get_weather"}},
+		{name: "invalid string", input: `{"tool_choice": "always"}`, err: true},
+		{name: "missing name", input: `{"tool_choice": {"type": "function", "function": {}}}`, err: true},
+		{name: "invalid type", input: `{"tool_choice": true}`, err: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var req ChatRequest
+			err := json.Unmarshal([]byte(test.input), &req)
+			if test.err {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, req.ToolChoice)
+
+			if test.expected != nil {
+				bts, err := json.Marshal(req.ToolChoice)
+				require.NoError(t, err)
+
+				var roundTrip ToolChoice
+				require.NoError(t, json.Unmarshal(bts, &roundTrip))
+				assert.Equal(t, *test.expected, roundTrip)
+			}
+		})
+	}
+}
+
+func TestCompactionResultApply(t *testing.T) {
+	msgs := []Message{
+		{Role: "system", Content: "You are a pirate."},
+		{Role: "user", Content: "Hello!"},
+		{Role: "system", Content: "Speak like a pirate."},
+		{Role: "assistant", Content: "Ahoy!"},
+		{Role: "user", Content: "What's the weather?"},
+	}
+
+	cases := []struct {
+		name     string
+		result   CompactionResult
+		expected []Message
+	}{
+		{
+			name:     "nothing compacted",
+			result:   CompactionResult{Strategy: "truncate"},
+			expected: msgs,
+		},
+		{
+			name:     "truncated",
+			result:   CompactionResult{Strategy: "truncate", Messages: 1},
+			expected: []Message{msgs[0], msgs[2], msgs[3], msgs[4]},
+		},
+		{
+			name:   "summarized",
+			result: CompactionResult{Strategy: "summarize", Messages: 2, Summary: "The user greeted the assistant."},
+			expected: []Message{
+				msgs[0],
+				msgs[2],
+				{Role: "system", Content: "The user greeted the assistant."},
+				msgs[4],
+			},
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, tt.result.Apply(msgs))
+		})
+	}
+}
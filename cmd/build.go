@@ -0,0 +1,323 @@
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pelletier/go-toml/v2"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/parser"
+	"github.com/ollama/ollama/progress"
+	"github.com/ollama/ollama/types/model"
+)
+
+// defaultBuildFiles are the names of the build files looked for when none is
+// given
+var defaultBuildFiles = []string{"ollama.yaml", "ollama.yml", "ollama.toml"}
+
+// buildFile lists the models built by `ollama build`
+type buildFile struct {
+	// BuildArgs sets Modelfile ARGs for all models
+	BuildArgs map[string]string `yaml:"build_args" toml:"build_args"`
+	Models    []buildTarget     `yaml:"models" toml:"models"`
+}
+
+type buildTarget struct {
+	Name string `yaml:"name" toml:"name"`
+
+	// File is the path of the Modelfile, relative to the build file
+	File string `yaml:"file" toml:"file"`
+
+	// Modelfile is the contents of the Modelfile. It is used instead of
+	// File when set.
+	Modelfile string `yaml:"modelfile" toml:"modelfile"`
+
+	BuildArgs map[string]string `yaml:"build_args" toml:"build_args"`
+	Quantize  string            `yaml:"quantize" toml:"quantize"`
+}
+
+func parseBuildFile(filename string, r io.Reader) (*buildFile, error) {
+	var f buildFile
+	switch ext := strings.ToLower(filepath.Ext(filename)); ext {
+	case ".yaml", ".yml":
+		d := yaml.NewDecoder(r)
+		d.KnownFields(true)
+		if err := d.Decode(&f); err != nil && !errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("%s: %w", filename, err)
+		}
+	case ".toml":
+		d := toml.NewDecoder(r)
+		d.DisallowUnknownFields()
+		if err := d.Decode(&f); err != nil {
+			return nil, fmt.Errorf("%s: %w", filename, err)
+		}
+	default:
+		return nil, fmt.Errorf("%s: unsupported build file type %q, must be .yaml, .yml or .toml", filename, ext)
+	}
+
+	if len(f.Models) == 0 {
+		return nil, fmt.Errorf("%s: no models", filename)
+	}
+
+	seen := make(map[string]bool)
+	for i, t := range f.Models {
+		n := model.ParseName(t.Name)
+		if !n.IsValid() {
+			return nil, fmt.Errorf("%s: model %d: invalid model name %q", filename, i+1, t.Name)
+		}
+
+		if (t.File == "") == (t.Modelfile == "") {
+			return nil, fmt.Errorf("%s: %s: exactly one of file or modelfile is required", filename, t.Name)
+		}
+
+		key := strings.ToLower(n.String())
+		if seen[key] {
+			return nil, fmt.Errorf("%s: %s: model is listed more than once", filename, t.Name)
+		}
+		seen[key] = true
+	}
+
+	return &f, nil
+}
+
+type buildAction int
+
+const (
+	buildUnchanged buildAction = iota
+	buildCreate
+	buildUpdate
+)
+
+func (a buildAction) String() string {
+	switch a {
+	case buildCreate:
+		return "create"
+	case buildUpdate:
+		return "update"
+	default:
+		return "unchanged"
+	}
+}
+
+// buildStep is the planned action for a model in a build file
+type buildStep struct {
+	name   string
+	req    *api.CreateRequest
+	action buildAction
+	reason string
+}
+
+// planBuild resolves the create request of each model in f and compares it
+// with the source digest of the existing model to decide what needs to be
+// created or updated. Paths are relative to the directory of the build file filename
+// and buildArgs override the ARGs set in f.
+func planBuild(cmd *cobra.Command, client *api.Client, f *buildFile, filename string, buildArgs map[string]string) ([]buildStep, error) {
+	dir := filepath.Dir(filename)
+
+	list, err := client.List(cmd.Context())
+	if err != nil {
+		return nil, err
+	}
+
+	steps := make([]buildStep, 0, len(f.Models))
+	for _, t := range f.Models {
+		args := maps.Clone(f.BuildArgs)
+		if args == nil {
+			args = make(map[string]string)
+		}
+		maps.Copy(args, t.BuildArgs)
+		maps.Copy(args, buildArgs)
+
+		opts := parser.ParseOptions{Filename: filename, BuildArgs: args}
+		var r io.Reader = strings.NewReader(t.Modelfile)
+		if t.File != "" {
+			opts.Filename = t.File
+			if !filepath.IsAbs(opts.Filename) {
+				opts.Filename = filepath.Join(dir, opts.Filename)
+			}
+
+			b, err := os.ReadFile(opts.Filename)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", t.Name, err)
+			}
+
+			r = bytes.NewReader(b)
+		}
+
+		modelfile, err := parser.ParseFileWithOptions(r, opts)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", t.Name, err)
+		}
+
+		req, err := modelfile.CreateRequest(filepath.Dir(opts.Filename))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", t.Name, err)
+		}
+
+		req.Model = t.Name
+		if t.Quantize != "" {
+			req.Quantize = t.Quantize
+		}
+
+		step := buildStep{name: t.Name, req: req}
+
+		info, err := client.Show(cmd.Context(), &api.ShowRequest{Model: t.Name})
+		var se api.StatusError
+		if errors.As(err, &se) && se.StatusCode == http.StatusNotFound {
+			step.action = buildCreate
+			steps = append(steps, step)
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("%s: %w", t.Name, err)
+		}
+
+		digest, err := sourceDigest(req)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", t.Name, err)
+		}
+
+		if info.SourceDigest != digest {
+			step.action = buildUpdate
+		} else if req.From != "" {
+			// the source digest doesn't cover the model this one is created
+			// from, so also check whether it has changed since
+			from := model.ParseName(req.From)
+			for _, s := range steps {
+				if s.action != buildUnchanged && model.ParseName(s.name).EqualFold(from) {
+					step.action = buildUpdate
+					step.reason = fmt.Sprintf("%s changes", s.name)
+					break
+				}
+			}
+
+			for _, m := range list.Models {
+				if step.action == buildUnchanged && model.ParseName(m.Model).EqualFold(from) && m.ModifiedAt.After(info.ModifiedAt) {
+					step.action = buildUpdate
+					step.reason = fmt.Sprintf("%s changed", req.From)
+					break
+				}
+			}
+		}
+
+		steps = append(steps, step)
+	}
+
+	return steps, nil
+}
+
+// sourceDigest returns the source digest the server records when creating
+// the model with req. Files are sent to the server by their base name, see
+// createModel.
+func sourceDigest(req *api.CreateRequest) (string, error) {
+	baseNames := func(files map[string]string) map[string]string {
+		m := make(map[string]string, len(files))
+		for f, digest := range files {
+			m[filepath.Base(f)] = digest
+		}
+		return m
+	}
+
+	r := *req
+	r.Files = baseNames(req.Files)
+	r.Adapters = baseNames(req.Adapters)
+	r.Imatrix = baseNames(req.Imatrix)
+	return r.SourceDigest()
+}
+
+func getBuildFileName(cmd *cobra.Command) (string, error) {
+	filename, _ := cmd.Flags().GetString("file")
+	if filename != "" {
+		return filepath.Abs(filename)
+	}
+
+	for _, name := range defaultBuildFiles {
+		if _, err := os.Stat(name); err == nil {
+			return filepath.Abs(name)
+		}
+	}
+
+	return "", fmt.Errorf("no build file found, looked for %s", strings.Join(defaultBuildFiles, ", "))
+}
+
+func BuildHandler(cmd *cobra.Command, args []string) error {
+	filename, err := getBuildFileName(cmd)
+	if err != nil {
+		return err
+	}
+
+	r, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	f, err := parseBuildFile(filename, r)
+	if err != nil {
+		return err
+	}
+
+	buildArgs, err := buildArgsFromFlags(cmd)
+	if err != nil {
+		return err
+	}
+
+	client, err := api.ClientFromEnvironment()
+	if err != nil {
+		return err
+	}
+
+	steps, err := planBuild(cmd, client, f, filename, buildArgs)
+	if err != nil {
+		return err
+	}
+
+	var changes int
+	for _, s := range steps {
+		line := fmt.Sprintf("  %-9s %s", s.action, s.name)
+		if s.reason != "" {
+			line += fmt.Sprintf(" (%s)", s.reason)
+		}
+		fmt.Println(line)
+
+		if s.action != buildUnchanged {
+			changes++
+		}
+	}
+
+	fmt.Printf("%d to create or update, %d unchanged\n", changes, len(steps)-changes)
+
+	if dryRun, _ := cmd.Flags().GetBool("dry-run"); dryRun || changes == 0 {
+		return nil
+	}
+
+	for _, s := range steps {
+		if s.action == buildUnchanged {
+			continue
+		}
+
+		verb := "creating"
+		if s.action == buildUpdate {
+			verb = "updating"
+		}
+		fmt.Fprintf(os.Stderr, "%s %s\n", verb, s.name)
+
+		p := progress.NewProgress(os.Stderr)
+		err := createModel(cmd, s.req, p)
+		p.Stop()
+		if err != nil {
+			return fmt.Errorf("%s: %w", s.name, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,247 @@
+package cmd
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/spf13/cobra"
+
+	"github.com/ollama/ollama/api"
+)
+
+func TestParseBuildFile(t *testing.T) {
+	want := &buildFile{
+		BuildArgs: map[string]string{"BASE": "llama3.2"},
+		Models: []buildTarget{
+			{Name: "mario", File: "mario.Modelfile", BuildArgs: map[string]string{"NAME": "Mario"}},
+			{Name: "luigi", Modelfile: "FROM ${BASE}\n", Quantize: "q4_K_M"},
+		},
+	}
+
+	cases := map[string]string{
+		"ollama.yaml": `
+build_args:
+  BASE: llama3.2
+models:
+  - name: mario
+    file: mario.Modelfile
+    build_args:
+      NAME: Mario
+  - name: luigi
+    quantize: q4_K_M
+    modelfile: |
+      FROM ${BASE}
+`,
+		"ollama.toml": `
+[build_args]
+BASE = "llama3.2"
+
+[[models]]
+name = "mario"
+file = "mario.Modelfile"
+build_args = { NAME = "Mario" }
+
+[[models]]
+name = "luigi"
+quantize = "q4_K_M"
+modelfile = """
+FROM ${BASE}
+"""
+`,
+	}
+
+	for name, content := range cases {
+		t.Run(name, func(t *testing.T) {
+			f, err := parseBuildFile(name, strings.NewReader(content))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if diff := cmp.Diff(want, f); diff != "" {
+				t.Errorf("mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+
+	for _, bad := range []string{
+		"",
+		"models:\n  - name: mario\n",
+		"models:\n  - name: mario\n    file: a\n    modelfile: FROM b\n",
+		"models:\n  - name: mario\n    file: a\n  - name: Mario\n    file: b\n",
+		"models:\n  - name: mario\n    file: a\n    unknown: true\n",
+		"models:\n  - name: ':invalid'\n    file: a\n",
+	} {
+		if _, err := parseBuildFile("ollama.yaml", strings.NewReader(bad)); err == nil {
+			t.Errorf("parseBuildFile(%q) succeeded; want error", bad)
+		}
+	}
+}
+
+func TestBuildHandler(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "derived.Modelfile"), []byte("FROM base\nSYSTEM derived\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "ollama.yaml"), []byte(`
+models:
+  - name: base
+    modelfile: |
+      FROM llama3.2
+      SYSTEM ${SYSTEM}
+    build_args:
+      SYSTEM: base
+  - name: derived
+    file: derived.Modelfile
+  - name: same
+    modelfile: |
+      ARG SYSTEM
+      FROM llama3.2
+      SYSTEM ${SYSTEM}
+  - name: new
+    modelfile: FROM llama3.2
+  - name: stale
+    modelfile: FROM qwen3
+`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	digest := func(req api.CreateRequest) string {
+		d, err := req.SourceDigest()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+
+	now := time.Now()
+	existing := map[string]api.ShowResponse{
+		"base":    {SourceDigest: "sha256:old", ModifiedAt: now},
+		"derived": {SourceDigest: digest(api.CreateRequest{From: "base", System: "derived"}), ModifiedAt: now},
+		"same":    {SourceDigest: digest(api.CreateRequest{From: "llama3.2", System: "same"}), ModifiedAt: now},
+		"stale":   {SourceDigest: digest(api.CreateRequest{From: "qwen3"}), ModifiedAt: now},
+	}
+
+	var mu sync.Mutex
+	var created []string
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/tags":
+			json.NewEncoder(w).Encode(api.ListResponse{Models: []api.ListModelResponse{
+				{Model: "llama3.2:latest", ModifiedAt: now.Add(-time.Hour)},
+				{Model: "qwen3:latest", ModifiedAt: now.Add(time.Hour)},
+				{Model: "base:latest", ModifiedAt: now},
+			}})
+		case "/api/show":
+			var req api.ShowRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			resp, ok := existing[req.Model]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				json.NewEncoder(w).Encode(map[string]string{"error": "model not found"})
+				return
+			}
+
+			json.NewEncoder(w).Encode(resp)
+		case "/api/create":
+			var req api.CreateRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			mu.Lock()
+			created = append(created, req.Model)
+			mu.Unlock()
+
+			json.NewEncoder(w).Encode(api.ProgressResponse{Status: "success"})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(mockServer.Close)
+
+	t.Setenv("OLLAMA_HOST", mockServer.URL)
+	t.Setenv("SYSTEM", "same")
+
+	cmd := &cobra.Command{}
+	cmd.Flags().String("file", "", "")
+	cmd.Flags().StringArray("build-arg", nil, "")
+	cmd.Flags().Bool("dry-run", false, "")
+	cmd.SetContext(t.Context())
+	cmd.Flags().Set("file", filepath.Join(dir, "ollama.yaml"))
+
+	f, err := os.Open(filepath.Join(dir, "ollama.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	bf, err := parseBuildFile(f.Name(), f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := api.ClientFromEnvironment()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	steps, err := planBuild(cmd, client, bf, f.Name(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	actions := make(map[string]string)
+	for _, s := range steps {
+		actions[s.name] = s.action.String()
+		if s.reason != "" {
+			actions[s.name] += " (" + s.reason + ")"
+		}
+	}
+
+	if diff := cmp.Diff(map[string]string{
+		"base":    "update",
+		"derived": "update (base changes)",
+		"same":    "unchanged",
+		"new":     "create",
+		"stale":   "update (qwen3 changed)",
+	}, actions); diff != "" {
+		t.Errorf("plan mismatch (-want +got):\n%s", diff)
+	}
+
+	t.Run("dry run", func(t *testing.T) {
+		cmd.Flags().Set("dry-run", "true")
+		defer cmd.Flags().Set("dry-run", "false")
+
+		if err := BuildHandler(cmd, nil); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(created) > 0 {
+			t.Errorf("created %v; want none", created)
+		}
+	})
+
+	t.Run("apply", func(t *testing.T) {
+		if err := BuildHandler(cmd, nil); err != nil {
+			t.Fatal(err)
+		}
+
+		if diff := cmp.Diff([]string{"base", "derived", "new", "stale"}, created); diff != "" {
+			t.Errorf("created mismatch (-want +got):\n%s", diff)
+		}
+	})
+}
@@ -2,6 +2,7 @@ package cmd
 
 import (
 	"bufio"
+	"cmp"
 	"context"
 	"crypto/ed25519"
 	"crypto/rand"
@@ -11,6 +12,7 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"maps"
 	"math"
 	"net"
 	"net/http"
@@ -111,11 +113,25 @@ func CreateHandler(cmd *cobra.Command, args []string) error {
 		defer f.Close()
 	}
 
-	modelfile, err := parser.ParseFile(reader)
+	buildArgs, err := buildArgsFromFlags(cmd)
 	if err != nil {
 		return err
 	}
 
+	modelfile, err := parser.ParseFileWithOptions(reader, parser.ParseOptions{Filename: filename, BuildArgs: buildArgs})
+	if err != nil {
+		return err
+	}
+
+	if imatrix, _ := cmd.Flags().GetString("imatrix"); imatrix != "" {
+		imatrix, err := filepath.Abs(imatrix)
+		if err != nil {
+			return err
+		}
+
+		modelfile.Commands = append(modelfile.Commands, parser.Command{Name: "imatrix", Args: imatrix})
+	}
+
 	status := "gathering model components"
 	spinner := progress.NewSpinner(status)
 	p.Add(status, spinner)
@@ -132,6 +148,31 @@ func CreateHandler(cmd *cobra.Command, args []string) error {
 		req.Quantize = quantize
 	}
 
+	if merge, _ := cmd.Flags().GetBool("merge"); merge {
+		req.MergeAdapters = true
+	}
+
+	return createModel(cmd, req, p)
+}
+
+// buildArgsFromFlags returns the values of the --build-arg flags
+func buildArgsFromFlags(cmd *cobra.Command) (map[string]string, error) {
+	buildArgs := make(map[string]string)
+	flagBuildArgs, _ := cmd.Flags().GetStringArray("build-arg")
+	for _, arg := range flagBuildArgs {
+		k, v, ok := strings.Cut(arg, "=")
+		if !ok || k == "" {
+			return nil, fmt.Errorf("invalid build arg %q: must be KEY=VALUE", arg)
+		}
+
+		buildArgs[k] = v
+	}
+
+	return buildArgs, nil
+}
+
+// createModel copies the files of req to the server and creates the model
+func createModel(cmd *cobra.Command, req *api.CreateRequest, p *progress.Progress) error {
 	client, err := api.ClientFromEnvironment()
 	if err != nil {
 		return err
@@ -168,13 +209,28 @@ func CreateHandler(cmd *cobra.Command, args []string) error {
 		})
 	}
 
+	imatrix := syncmap.NewSyncMap[string, string]()
+	for f, digest := range req.Imatrix {
+		g.Go(func() error {
+			if _, err := createBlob(cmd, client, f, digest, p); err != nil {
+				return err
+			}
+
+			imatrix.Store(filepath.Base(f), digest)
+			return nil
+		})
+	}
+
 	if err := g.Wait(); err != nil {
 		return err
 	}
 
 	req.Files = files.Items()
 	req.Adapters = adapters.Items()
+	req.Imatrix = imatrix.Items()
 
+	var status string
+	var spinner *progress.Spinner
 	bars := make(map[string]*progress.Bar)
 	fn := func(resp api.ProgressResponse) error {
 		if resp.Digest != "" {
@@ -191,7 +247,9 @@ func CreateHandler(cmd *cobra.Command, args []string) error {
 
 			bar.Set(resp.Completed)
 		} else if status != resp.Status {
-			spinner.Stop()
+			if spinner != nil {
+				spinner.Stop()
+			}
 
 			status = resp.Status
 			spinner = progress.NewSpinner(status)
@@ -612,6 +670,11 @@ func PushHandler(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
+	sign, err := cmd.Flags().GetBool("sign")
+	if err != nil {
+		return err
+	}
+
 	n := model.ParseName(args[0])
 	if strings.HasSuffix(n.Host, ".ollama.ai") || strings.HasSuffix(n.Host, ".ollama.com") {
 		_, err := client.Whoami(cmd.Context())
@@ -665,7 +728,7 @@ func PushHandler(cmd *cobra.Command, args []string) error {
 		return nil
 	}
 
-	request := api.PushRequest{Name: args[0], Insecure: insecure}
+	request := api.PushRequest{Name: args[0], Insecure: insecure, Sign: sign}
 
 	if err := client.Push(cmd.Context(), &request, fn); err != nil {
 		if spinner != nil {
@@ -966,6 +1029,18 @@ func showInfo(resp *api.ShowResponse, verbose bool, w io.Writer) error {
 		})
 	}
 
+	if resp.Signer != nil {
+		tableRender("Signature", func() (rows [][]string) {
+			trusted := "no"
+			if resp.Signer.Trusted {
+				trusted = "yes"
+			}
+			rows = append(rows, []string{"", "fingerprint", resp.Signer.Fingerprint})
+			rows = append(rows, []string{"", "trusted", trusted})
+			return
+		})
+	}
+
 	if resp.Parameters != "" {
 		tableRender("Parameters", func() (rows [][]string) {
 			scanner := bufio.NewScanner(strings.NewReader(resp.Parameters))
@@ -1038,6 +1113,23 @@ func showInfo(resp *api.ShowResponse, verbose bool, w io.Writer) error {
 	}
 
 	if len(resp.Tensors) > 0 && verbose {
+		tableRender("Tensor types", func() (rows [][]string) {
+			counts := make(map[string]int)
+			for _, t := range resp.Tensors {
+				counts[t.Type]++
+			}
+
+			types := slices.Collect(maps.Keys(counts))
+			slices.SortFunc(types, func(a, b string) int {
+				return cmp.Or(cmp.Compare(counts[b], counts[a]), cmp.Compare(a, b))
+			})
+
+			for _, t := range types {
+				rows = append(rows, []string{"", t, strconv.Itoa(counts[t])})
+			}
+			return
+		})
+
 		tableRender("Tensors", func() (rows [][]string) {
 			for _, t := range resp.Tensors {
 				rows = append(rows, []string{"", t.Name, t.Type, fmt.Sprint(t.Shape)})
@@ -1582,6 +1674,98 @@ func RunServer(_ *cobra.Command, _ []string) error {
 	return err
 }
 
+func ServeRegistryHandler(cmd *cobra.Command, _ []string) error {
+	addr, err := cmd.Flags().GetString("addr")
+	if err != nil {
+		return err
+	}
+
+	upstream, err := cmd.Flags().GetString("upstream")
+	if err != nil {
+		return err
+	}
+
+	if upstream != "" {
+		if err := initializeKeypair(); err != nil {
+			return err
+		}
+	}
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	return server.ServeRegistry(ln, upstream)
+}
+
+func ExportHandler(cmd *cobra.Command, args []string) error {
+	output, err := cmd.Flags().GetString("output")
+	if err != nil {
+		return err
+	}
+
+	var names []model.Name
+	for _, arg := range args {
+		n := model.ParseName(arg)
+		if !n.IsValid() {
+			return fmt.Errorf("invalid model name: %s", arg)
+		}
+		names = append(names, n)
+	}
+
+	w := os.Stdout
+	if output == "" {
+		if term.IsTerminal(int(os.Stdout.Fd())) {
+			return errors.New("refusing to write a model bundle to a terminal, use --output or redirect stdout")
+		}
+	} else {
+		f, err := os.Create(output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	bw := bufio.NewWriter(w)
+	if err := server.ExportModels(bw, names...); err != nil {
+		if output != "" {
+			os.Remove(output)
+		}
+		return err
+	}
+
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+
+	if output != "" {
+		return w.Close()
+	}
+
+	return nil
+}
+
+func ImportHandler(cmd *cobra.Command, args []string) error {
+	f, err := os.Open(args[0])
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	names, err := server.ImportModels(bufio.NewReader(f))
+	if err != nil {
+		return err
+	}
+
+	for _, n := range names {
+		fmt.Printf("imported '%s'\n", n.DisplayShortest())
+	}
+
+	return nil
+}
+
 func initializeKeypair() error {
 	home, err := os.UserHomeDir()
 	if err != nil {
@@ -1717,6 +1901,21 @@ func NewCLI() *cobra.Command {
 
 	createCmd.Flags().StringP("file", "f", "", "Name of the Modelfile (default \"Modelfile\")")
 	createCmd.Flags().StringP("quantize", "q", "", "Quantize model to this level (e.g. q4_K_M)")
+	createCmd.Flags().String("imatrix", "", "Importance matrix or calibration text to guide quantization")
+	createCmd.Flags().Bool("merge", false, "Merge adapters into the model weights")
+	createCmd.Flags().StringArray("build-arg", nil, "Set a Modelfile ARG (e.g. NAME=value)")
+
+	buildCmd := &cobra.Command{
+		Use:     "build",
+		Short:   "Create the models listed in a build file",
+		Args:    cobra.ExactArgs(0),
+		PreRunE: checkServerHeartbeat,
+		RunE:    BuildHandler,
+	}
+
+	buildCmd.Flags().StringP("file", "f", "", "Name of the build file (default \"ollama.yaml\", \"ollama.yml\" or \"ollama.toml\")")
+	buildCmd.Flags().StringArray("build-arg", nil, "Set a Modelfile ARG for all models (e.g. NAME=value)")
+	buildCmd.Flags().Bool("dry-run", false, "Show the models that would be created without creating them")
 
 	showCmd := &cobra.Command{
 		Use:     "show MODEL",
@@ -1787,6 +1986,7 @@ func NewCLI() *cobra.Command {
 	}
 
 	pushCmd.Flags().Bool("insecure", false, "Use an insecure registry")
+	pushCmd.Flags().Bool("sign", false, "Sign the model with your Ollama key")
 
 	signinCmd := &cobra.Command{
 		Use:     "signin",
@@ -1834,6 +2034,32 @@ func NewCLI() *cobra.Command {
 		RunE:    DeleteHandler,
 	}
 
+	serveRegistryCmd := &cobra.Command{
+		Use:   "serve-registry",
+		Short: "Serve local models to other machines as a registry",
+		Args:  cobra.ExactArgs(0),
+		RunE:  ServeRegistryHandler,
+	}
+
+	serveRegistryCmd.Flags().String("addr", "0.0.0.0:11435", "Address to listen on")
+	serveRegistryCmd.Flags().String("upstream", "", "Registry to pull and cache models from when they are not available locally, e.g. https://registry.ollama.ai")
+
+	exportCmd := &cobra.Command{
+		Use:   "export MODEL [MODEL...]",
+		Short: "Export models to a file",
+		Args:  cobra.MinimumNArgs(1),
+		RunE:  ExportHandler,
+	}
+
+	exportCmd.Flags().StringP("output", "o", "", "File to write to instead of stdout")
+
+	importCmd := &cobra.Command{
+		Use:   "import FILE",
+		Short: "Import models from a file created with export",
+		Args:  cobra.ExactArgs(1),
+		RunE:  ImportHandler,
+	}
+
 	runnerCmd := &cobra.Command{
 		Use:    "runner",
 		Hidden: true,
@@ -1852,6 +2078,7 @@ func NewCLI() *cobra.Command {
 
 	for _, cmd := range []*cobra.Command{
 		createCmd,
+		buildCmd,
 		showCmd,
 		runCmd,
 		stopCmd,
@@ -1862,6 +2089,9 @@ func NewCLI() *cobra.Command {
 		copyCmd,
 		deleteCmd,
 		serveCmd,
+		serveRegistryCmd,
+		exportCmd,
+		importCmd,
 	} {
 		switch cmd {
 		case runCmd:
@@ -1885,6 +2115,13 @@ func NewCLI() *cobra.Command {
 				envVars["OLLAMA_GPU_OVERHEAD"],
 				envVars["OLLAMA_LOAD_TIMEOUT"],
 			})
+		case serveRegistryCmd:
+			appendEnvDocs(cmd, []envconfig.EnvVar{
+				envVars["OLLAMA_DEBUG"],
+				envVars["OLLAMA_MODELS"],
+			})
+		case exportCmd, importCmd:
+			appendEnvDocs(cmd, []envconfig.EnvVar{envVars["OLLAMA_MODELS"]})
 		default:
 			appendEnvDocs(cmd, envs)
 		}
@@ -1893,6 +2130,7 @@ func NewCLI() *cobra.Command {
 	rootCmd.AddCommand(
 		serveCmd,
 		createCmd,
+		buildCmd,
 		showCmd,
 		runCmd,
 		stopCmd,
@@ -1904,6 +2142,10 @@ func NewCLI() *cobra.Command {
 		psCmd,
 		copyCmd,
 		deleteCmd,
+		serveRegistryCmd,
+		exportCmd,
+		importCmd,
+		newGGUFCmd(),
 		runnerCmd,
 	)
 
@@ -120,6 +120,10 @@ func TestShowInfo(t *testing.T) {
     test.context_length        1000     
     test.embedding_length      11434    
 
+  Tensor types
+    BF16    1    
+    FP16    1    
+
   Tensors
     blk.0.attn_k.weight    BF16    [42 3117]    
     blk.0.attn_q.weight    FP16    [3117 42]    
@@ -291,6 +295,34 @@ Weigh anchor!
 			t.Errorf("unexpected output (-want +got):\n%s", diff)
 		}
 	})
+
+	t.Run("signer", func(t *testing.T) {
+		var b bytes.Buffer
+		if err := showInfo(&api.ShowResponse{
+			Details: api.ModelDetails{
+				Family:            "test",
+				ParameterSize:     "7B",
+				QuantizationLevel: "FP16",
+			},
+			Signer: &api.ModelSigner{Fingerprint: "SHA256:abc", Trusted: true},
+		}, false, &b); err != nil {
+			t.Fatal(err)
+		}
+
+		expect := "  Model\n" +
+			"    architecture    test    \n" +
+			"    parameters      7B      \n" +
+			"    quantization    FP16    \n" +
+			"\n" +
+			"  Signature\n" +
+			"    fingerprint    SHA256:abc    \n" +
+			"    trusted        yes           \n" +
+			"\n"
+
+		if diff := cmp.Diff(expect, b.String()); diff != "" {
+			t.Errorf("unexpected output (-want +got):\n%s", diff)
+		}
+	})
 }
 
 func TestDeleteHandler(t *testing.T) {
@@ -888,6 +920,7 @@ func TestPushHandler(t *testing.T) {
 
 			cmd := &cobra.Command{}
 			cmd.Flags().Bool("insecure", false, "")
+			cmd.Flags().Bool("sign", false, "")
 			cmd.SetContext(t.Context())
 
 			// Redirect stderr to capture progress output
@@ -0,0 +1,425 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/olekukonko/tablewriter"
+	"github.com/spf13/cobra"
+
+	fsggml "github.com/ollama/ollama/fs/ggml"
+	"github.com/ollama/ollama/fs/gguf"
+	"github.com/ollama/ollama/server"
+)
+
+// ggufMaxArrayValues is the number of array elements shown without --all
+const ggufMaxArrayValues = 8
+
+// ggufPath returns the path of the GGUF file named by arg, which is either a
+// path or the name of a local model
+func ggufPath(arg string) (string, error) {
+	if _, err := os.Stat(arg); err == nil {
+		return arg, nil
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return "", err
+	}
+
+	m, err := server.GetModel(arg)
+	if err != nil {
+		return "", fmt.Errorf("%s is neither a file nor a model: %w", arg, err)
+	}
+
+	return m.ModelPath, nil
+}
+
+func openGGUF(arg string) (*gguf.File, error) {
+	p, err := ggufPath(arg)
+	if err != nil {
+		return nil, err
+	}
+
+	return gguf.Open(p)
+}
+
+// formatGGUFValue formats v for display, eliding all but the first elements
+// of arrays unless all is set
+func formatGGUFValue(v any, all bool) string {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Slice {
+		if s, ok := v.(string); ok {
+			return strconv.Quote(s)
+		}
+		return fmt.Sprint(v)
+	}
+
+	n := rv.Len()
+	if !all {
+		n = min(n, ggufMaxArrayValues)
+	}
+
+	values := make([]string, n)
+	for i := range n {
+		values[i] = formatGGUFValue(rv.Index(i).Interface(), all)
+	}
+
+	if n < rv.Len() {
+		values = append(values, fmt.Sprintf("... (%d total)", rv.Len()))
+	}
+
+	return "[" + strings.Join(values, " ") + "]"
+}
+
+func formatGGUFShape(shape []uint64) string {
+	dims := make([]string, len(shape))
+	for i, d := range shape {
+		dims[i] = strconv.FormatUint(d, 10)
+	}
+	return "[" + strings.Join(dims, " ") + "]"
+}
+
+func ggufTable(w io.Writer, header []string, rows [][]string) {
+	table := tablewriter.NewWriter(w)
+	table.SetHeader(header)
+	table.SetHeaderAlignment(tablewriter.ALIGN_LEFT)
+	table.SetAlignment(tablewriter.ALIGN_LEFT)
+	table.SetAutoFormatHeaders(false)
+	table.SetAutoWrapText(false)
+	table.SetHeaderLine(false)
+	table.SetBorder(false)
+	table.SetNoWhiteSpace(true)
+	table.SetTablePadding("    ")
+	table.AppendBulk(rows)
+	table.Render()
+}
+
+func showGGUF(f *gguf.File, showKV, showTensors, all bool, w io.Writer) {
+	if showKV {
+		var rows [][]string
+		for _, kv := range f.KeyValues() {
+			rows = append(rows, []string{kv.Key, fmt.Sprintf("%T", kv.Any()), formatGGUFValue(kv.Any(), all)})
+		}
+		ggufTable(w, []string{"KEY", "TYPE", "VALUE"}, rows)
+	}
+
+	if showKV && showTensors {
+		fmt.Fprintln(w)
+	}
+
+	if showTensors {
+		var rows [][]string
+		for _, t := range f.TensorInfos() {
+			rows = append(rows, []string{t.Name, strings.ToUpper(t.Type.String()), formatGGUFShape(t.Shape), strconv.FormatUint(t.Offset, 10)})
+		}
+		ggufTable(w, []string{"NAME", "TYPE", "SHAPE", "OFFSET"}, rows)
+	}
+}
+
+func GGUFShowHandler(cmd *cobra.Command, args []string) error {
+	f, err := openGGUF(args[0])
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	kvOnly, _ := cmd.Flags().GetBool("kv")
+	tensorsOnly, _ := cmd.Flags().GetBool("tensors")
+	all, _ := cmd.Flags().GetBool("all")
+
+	showGGUF(f, !tensorsOnly || kvOnly, !kvOnly || tensorsOnly, all, os.Stdout)
+	return nil
+}
+
+// diffGGUF writes the key values and tensors which differ between a and b.
+// Lines prefixed with - are only in a and lines prefixed with + are only in
+// b. It reports whether there are any differences.
+func diffGGUF(a, b *gguf.File, all bool, w io.Writer) bool {
+	var changed bool
+	diff := func(a, b map[string]any, format func(v any, all bool) string) {
+		keys := slices.Concat(slices.Collect(maps.Keys(a)), slices.Collect(maps.Keys(b)))
+		slices.Sort(keys)
+		for _, k := range slices.Compact(keys) {
+			va, oka := a[k]
+			vb, okb := b[k]
+			if oka && okb && reflect.DeepEqual(va, vb) {
+				continue
+			}
+
+			// show values in full if they only differ in elided elements
+			all := all || (oka && okb && format(va, all) == format(vb, all))
+
+			changed = true
+			if oka {
+				fmt.Fprintf(w, "- %s %s\n", k, format(va, all))
+			}
+			if okb {
+				fmt.Fprintf(w, "+ %s %s\n", k, format(vb, all))
+			}
+		}
+	}
+
+	kvs := func(f *gguf.File) map[string]any {
+		m := make(map[string]any)
+		for _, kv := range f.KeyValues() {
+			m[kv.Key] = kv.Any()
+		}
+		return m
+	}
+
+	diff(kvs(a), kvs(b), func(v any, all bool) string {
+		return fmt.Sprintf("%T %s", v, formatGGUFValue(v, all))
+	})
+
+	tensors := func(f *gguf.File) map[string]any {
+		m := make(map[string]any)
+		for _, t := range f.TensorInfos() {
+			m[t.Name] = strings.ToUpper(t.Type.String()) + " " + formatGGUFShape(t.Shape)
+		}
+		return m
+	}
+
+	diff(tensors(a), tensors(b), func(v any, _ bool) string {
+		return v.(string)
+	})
+
+	return changed
+}
+
+func GGUFDiffHandler(cmd *cobra.Command, args []string) error {
+	a, err := openGGUF(args[0])
+	if err != nil {
+		return err
+	}
+	defer a.Close()
+
+	b, err := openGGUF(args[1])
+	if err != nil {
+		return err
+	}
+	defer b.Close()
+
+	all, _ := cmd.Flags().GetBool("all")
+	diffGGUF(a, b, all, os.Stdout)
+	return nil
+}
+
+// parseGGUFValue parses s as a value of the same type as v. If v is nil, the
+// type is inferred from s: booleans, integers that fit in a uint32 or int32,
+// floats, JSON arrays of strings or numbers, and otherwise strings.
+func parseGGUFValue(s string, v any) (any, error) {
+	switch v := v.(type) {
+	case nil:
+		if s == "true" || s == "false" {
+			return s == "true", nil
+		} else if u, err := strconv.ParseUint(s, 10, 32); err == nil {
+			return uint32(u), nil
+		} else if i, err := strconv.ParseInt(s, 10, 32); err == nil {
+			return int32(i), nil
+		} else if f, err := strconv.ParseFloat(s, 32); err == nil {
+			return float32(f), nil
+		} else if strings.HasPrefix(s, "[") {
+			for _, v := range []any{[]string{}, []int32{}, []float32{}} {
+				if v, err := parseGGUFValue(s, v); err == nil {
+					return v, nil
+				}
+			}
+		}
+		return s, nil
+	case string:
+		return s, nil
+	case bool:
+		return strconv.ParseBool(s)
+	case uint8, uint16, uint32, uint64:
+		u, err := strconv.ParseUint(s, 10, int(reflect.TypeOf(v).Size())*8)
+		if err != nil {
+			return nil, err
+		}
+		return reflect.ValueOf(u).Convert(reflect.TypeOf(v)).Interface(), nil
+	case int8, int16, int32, int64:
+		i, err := strconv.ParseInt(s, 10, int(reflect.TypeOf(v).Size())*8)
+		if err != nil {
+			return nil, err
+		}
+		return reflect.ValueOf(i).Convert(reflect.TypeOf(v)).Interface(), nil
+	case float32, float64:
+		f, err := strconv.ParseFloat(s, int(reflect.TypeOf(v).Size())*8)
+		if err != nil {
+			return nil, err
+		}
+		return reflect.ValueOf(f).Convert(reflect.TypeOf(v)).Interface(), nil
+	default:
+		if reflect.TypeOf(v).Kind() != reflect.Slice {
+			return nil, fmt.Errorf("unsupported type %T", v)
+		}
+
+		var elems []json.RawMessage
+		if err := json.Unmarshal([]byte(s), &elems); err != nil {
+			return nil, fmt.Errorf("expected a JSON array: %w", err)
+		}
+
+		t := reflect.TypeOf(v)
+		values := reflect.MakeSlice(t, len(elems), len(elems))
+		for i, elem := range elems {
+			s := string(elem)
+			if t.Elem().Kind() == reflect.String {
+				if err := json.Unmarshal(elem, &s); err != nil {
+					return nil, err
+				}
+			}
+
+			e, err := parseGGUFValue(s, reflect.Zero(t.Elem()).Interface())
+			if err != nil {
+				return nil, err
+			}
+			values.Index(i).Set(reflect.ValueOf(e))
+		}
+		return values.Interface(), nil
+	}
+}
+
+// ggufTensor writes the data of a tensor read from a GGUF file
+type ggufTensor struct {
+	io.Reader
+}
+
+func (t ggufTensor) WriteTo(w io.Writer) (int64, error) {
+	return io.Copy(w, t.Reader)
+}
+
+// editGGUF writes f to path with the key values in set changed or added and
+// the keys in remove removed
+func editGGUF(f *gguf.File, path string, set map[string]string, remove []string) error {
+	kv := make(fsggml.KV)
+	for _, e := range f.KeyValues() {
+		kv[e.Key] = e.Any()
+	}
+
+	for _, k := range remove {
+		if _, ok := kv[k]; !ok {
+			return fmt.Errorf("key %s not found", k)
+		}
+		delete(kv, k)
+	}
+
+	for _, k := range slices.Sorted(maps.Keys(set)) {
+		v, err := parseGGUFValue(set[k], kv[k])
+		if err != nil {
+			return fmt.Errorf("invalid value for %s: %w", k, err)
+		}
+		kv[k] = v
+	}
+
+	var infos []gguf.TensorInfo
+	for _, t := range f.TensorInfos() {
+		infos = append(infos, t)
+	}
+
+	// readers are created up front since tensors are written concurrently
+	var ts []*fsggml.Tensor
+	for _, t := range infos {
+		_, r, err := f.TensorReader(t.Name)
+		if err != nil {
+			return err
+		}
+
+		ts = append(ts, &fsggml.Tensor{Name: t.Name, Kind: uint32(t.Type), Shape: t.Shape, WriterTo: ggufTensor{r}})
+	}
+
+	// write to a temporary file first so path may be the file being read
+	temp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+	defer temp.Close()
+
+	if err := fsggml.WriteGGUFKeys(temp, kv, ts); err != nil {
+		return err
+	}
+
+	if err := temp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(temp.Name(), path)
+}
+
+func GGUFEditHandler(cmd *cobra.Command, args []string) error {
+	output, _ := cmd.Flags().GetString("output")
+	if output == "" {
+		return errors.New("--output is required")
+	}
+
+	values, _ := cmd.Flags().GetStringArray("set")
+	set := make(map[string]string)
+	for _, value := range values {
+		k, v, ok := strings.Cut(value, "=")
+		if !ok || k == "" {
+			return fmt.Errorf("invalid --set %q, expected KEY=VALUE", value)
+		}
+		set[k] = v
+	}
+
+	remove, _ := cmd.Flags().GetStringArray("remove")
+	if len(set) == 0 && len(remove) == 0 {
+		return errors.New("nothing to change, use --set or --remove")
+	}
+
+	f, err := openGGUF(args[0])
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return editGGUF(f, output, set, remove)
+}
+
+func newGGUFCmd() *cobra.Command {
+	ggufCmd := &cobra.Command{
+		Use:   "gguf",
+		Short: "Inspect and edit GGUF files",
+	}
+
+	showCmd := &cobra.Command{
+		Use:   "show MODEL|FILE",
+		Short: "Show the metadata and tensors of a GGUF file",
+		Args:  cobra.ExactArgs(1),
+		RunE:  GGUFShowHandler,
+	}
+
+	showCmd.Flags().Bool("kv", false, "Show only the metadata")
+	showCmd.Flags().Bool("tensors", false, "Show only the tensors")
+	showCmd.Flags().Bool("all", false, "Show all elements of arrays")
+
+	diffCmd := &cobra.Command{
+		Use:   "diff MODEL|FILE MODEL|FILE",
+		Short: "Show the differences between the metadata and tensors of two GGUF files",
+		Args:  cobra.ExactArgs(2),
+		RunE:  GGUFDiffHandler,
+	}
+
+	diffCmd.Flags().Bool("all", false, "Show all elements of arrays")
+
+	editCmd := &cobra.Command{
+		Use:   "edit MODEL|FILE",
+		Short: "Write a GGUF file with changed metadata",
+		Args:  cobra.ExactArgs(1),
+		RunE:  GGUFEditHandler,
+	}
+
+	editCmd.Flags().StringP("output", "o", "", "File to write to")
+	editCmd.Flags().StringArray("set", nil, "Set the metadata KEY=VALUE")
+	editCmd.Flags().StringArray("remove", nil, "Remove the metadata KEY")
+
+	ggufCmd.AddCommand(showCmd, diffCmd, editCmd)
+	return ggufCmd
+}
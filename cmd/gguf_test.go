@@ -0,0 +1,248 @@
+package cmd
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	fsggml "github.com/ollama/ollama/fs/ggml"
+	"github.com/ollama/ollama/fs/gguf"
+)
+
+func writeTestGGUF(t *testing.T, kv fsggml.KV) string {
+	t.Helper()
+
+	f, err := os.Create(filepath.Join(t.TempDir(), "model.gguf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if err := fsggml.WriteGGUF(f, kv, []*fsggml.Tensor{
+		{Name: "token_embd.weight", Kind: uint32(fsggml.TensorTypeF32), Shape: []uint64{2, 3}, WriterTo: bytes.NewReader(bytes.Repeat([]byte{1}, 4*2*3))},
+		{Name: "blk.0.attn_q.weight", Kind: uint32(fsggml.TensorTypeF16), Shape: []uint64{2, 2}, WriterTo: bytes.NewReader(bytes.Repeat([]byte{2}, 2*2*2))},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	return f.Name()
+}
+
+func openTestGGUF(t *testing.T, path string) *gguf.File {
+	t.Helper()
+
+	f, err := gguf.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { f.Close() })
+	return f
+}
+
+func testKV() fsggml.KV {
+	return fsggml.KV{
+		"general.architecture":  "llama",
+		"llama.context_length":  uint32(4096),
+		"tokenizer.ggml.tokens": []string{"a", "b", "c", "d", "e", "f", "g", "h", "i", "j"},
+	}
+}
+
+func TestShowGGUF(t *testing.T) {
+	f := openTestGGUF(t, writeTestGGUF(t, testKV()))
+
+	var b bytes.Buffer
+	showGGUF(f, true, true, false, &b)
+
+	expect := `KEY                      TYPE        VALUE
+general.architecture     string      "llama"
+llama.context_length     uint32      4096
+tokenizer.ggml.tokens    []string    ["a" "b" "c" "d" "e" "f" "g" "h" ... (10 total)]
+
+NAME                   TYPE    SHAPE    OFFSET
+blk.0.attn_q.weight    F16     [2 2]    0
+token_embd.weight      F32     [2 3]    32
+`
+
+	var got strings.Builder
+	for line := range strings.Lines(b.String()) {
+		got.WriteString(strings.TrimRight(line, " \n") + "\n")
+	}
+
+	if diff := cmp.Diff(expect, got.String()); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestDiffGGUF(t *testing.T) {
+	a := openTestGGUF(t, writeTestGGUF(t, testKV()))
+
+	t.Run("same", func(t *testing.T) {
+		b := openTestGGUF(t, writeTestGGUF(t, testKV()))
+
+		var w bytes.Buffer
+		if diffGGUF(a, b, false, &w) {
+			t.Errorf("expected no differences, got:\n%s", w.String())
+		}
+	})
+
+	t.Run("different", func(t *testing.T) {
+		kv := testKV()
+		kv["llama.context_length"] = uint32(8192)
+		kv["llama.block_count"] = uint32(1)
+		kv["tokenizer.ggml.tokens"] = []string{"a", "b", "c", "d", "e", "f", "g", "h", "i", "k"}
+		b := openTestGGUF(t, writeTestGGUF(t, kv))
+
+		var w bytes.Buffer
+		if !diffGGUF(a, b, false, &w) {
+			t.Fatal("expected differences")
+		}
+
+		expect := `+ llama.block_count uint32 1
+- llama.context_length uint32 4096
++ llama.context_length uint32 8192
+- tokenizer.ggml.tokens []string ["a" "b" "c" "d" "e" "f" "g" "h" "i" "j"]
++ tokenizer.ggml.tokens []string ["a" "b" "c" "d" "e" "f" "g" "h" "i" "k"]
+`
+
+		if diff := cmp.Diff(expect, w.String()); diff != "" {
+			t.Errorf("mismatch (-want +got):\n%s", diff)
+		}
+	})
+}
+
+func TestParseGGUFValue(t *testing.T) {
+	cases := []struct {
+		s       string
+		v       any
+		want    any
+		wantErr bool
+	}{
+		{s: "true", want: true},
+		{s: "1", want: uint32(1)},
+		{s: "-1", want: int32(-1)},
+		{s: "0.5", want: float32(0.5)},
+		{s: "hello", want: "hello"},
+		{s: `["a", "b"]`, want: []string{"a", "b"}},
+		{s: "[1, -2]", want: []int32{1, -2}},
+		{s: "[0.5]", want: []float32{0.5}},
+		{s: "1", v: "", want: "1"},
+		{s: "1", v: false, want: true},
+		{s: "255", v: uint8(0), want: uint8(255)},
+		{s: "256", v: uint8(0), wantErr: true},
+		{s: "-3", v: int64(0), want: int64(-3)},
+		{s: "0.25", v: float64(0), want: float64(0.25)},
+		{s: "[1, 2]", v: []uint8{}, want: []uint8{1, 2}},
+		{s: `["<s>", "</s>"]`, v: []string{}, want: []string{"<s>", "</s>"}},
+		{s: "[1.5]", v: []int32{}, wantErr: true},
+		{s: "1", v: []int32{}, wantErr: true},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.s, func(t *testing.T) {
+			got, err := parseGGUFValue(tt.s, tt.v)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("expected error, got %v", got)
+				}
+				return
+			} else if err != nil {
+				t.Fatal(err)
+			}
+
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestEditGGUF(t *testing.T) {
+	path := writeTestGGUF(t, testKV())
+	f := openTestGGUF(t, path)
+
+	output := filepath.Join(t.TempDir(), "edited.gguf")
+	if err := editGGUF(f, output, map[string]string{
+		"llama.context_length": "8192",
+		"general.name":         "edited",
+	}, []string{"tokenizer.ggml.tokens"}); err != nil {
+		t.Fatal(err)
+	}
+
+	edited := openTestGGUF(t, output)
+
+	kv := make(map[string]any)
+	for _, e := range edited.KeyValues() {
+		kv[e.Key] = e.Any()
+	}
+
+	if diff := cmp.Diff(map[string]any{
+		"general.architecture": "llama",
+		"general.name":         "edited",
+		"llama.context_length": uint32(8192),
+	}, kv); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+
+	original := openTestGGUF(t, path)
+	for _, name := range []string{"token_embd.weight", "blk.0.attn_q.weight"} {
+		var want, got bytes.Buffer
+		_, r, err := original.TensorReader(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want.ReadFrom(r)
+
+		_, r, err = edited.TensorReader(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got.ReadFrom(r)
+
+		if !bytes.Equal(want.Bytes(), got.Bytes()) {
+			t.Errorf("tensor %s changed", name)
+		}
+	}
+
+	t.Run("unprefixed key", func(t *testing.T) {
+		if err := editGGUF(openTestGGUF(t, path), output, map[string]string{"custom.key": "value"}, nil); err != nil {
+			t.Fatal(err)
+		}
+
+		// edit the result again so the key is read back and rewritten
+		roundTrip := filepath.Join(t.TempDir(), "round-trip.gguf")
+		if err := editGGUF(openTestGGUF(t, output), roundTrip, map[string]string{"general.name": "round trip"}, nil); err != nil {
+			t.Fatal(err)
+		}
+
+		kv := make(map[string]any)
+		for _, e := range openTestGGUF(t, roundTrip).KeyValues() {
+			kv[e.Key] = e.Any()
+		}
+
+		if diff := cmp.Diff(map[string]any{
+			"general.architecture":  "llama",
+			"general.name":          "round trip",
+			"llama.context_length":  uint32(4096),
+			"tokenizer.ggml.tokens": []string{"a", "b", "c", "d", "e", "f", "g", "h", "i", "j"},
+			"custom.key":            "value",
+		}, kv); diff != "" {
+			t.Errorf("mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("missing key", func(t *testing.T) {
+		if err := editGGUF(openTestGGUF(t, path), output, nil, []string{"missing"}); err == nil {
+			t.Error("expected error")
+		}
+	})
+
+	t.Run("invalid value", func(t *testing.T) {
+		if err := editGGUF(openTestGGUF(t, path), output, map[string]string{"llama.context_length": "long"}, nil); err == nil {
+			t.Error("expected error")
+		}
+	})
+}
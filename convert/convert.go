@@ -194,6 +194,8 @@ func ConvertModel(fsys fs.FS, f *os.File) error {
 		conv = &gemma3nModel{}
 	case "Phi3ForCausalLM":
 		conv = &phi3Model{}
+	case "PhimoeForCausalLM":
+		conv = &phimoeModel{}
 	case "Qwen2ForCausalLM":
 		conv = &qwen2Model{}
 	case "Qwen2_5_VLForConditionalGeneration":
@@ -206,6 +208,14 @@ func ConvertModel(fsys fs.FS, f *os.File) error {
 		conv = &commandrModel{}
 	case "GptOssForCausalLM":
 		conv = &gptossModel{}
+	case "DeepseekV2ForCausalLM", "DeepseekV3ForCausalLM":
+		conv = &deepseek2Model{}
+	case "GraniteForCausalLM", "GraniteMoeForCausalLM":
+		conv = &graniteModel{}
+	case "Olmo2ForCausalLM":
+		conv = &olmo2Model{}
+	case "MambaForCausalLM", "Mamba2ForCausalLM":
+		conv = &mambaModel{}
 	default:
 		return fmt.Errorf("unsupported architecture %q", p.Architectures[0])
 	}
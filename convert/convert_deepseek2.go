@@ -0,0 +1,246 @@
+package convert
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/binary"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/ollama/ollama/fs/ggml"
+)
+
+type deepseek2Model struct {
+	ModelParameters
+	MaxPositionEmbeddings uint32  `json:"max_position_embeddings"`
+	HiddenSize            uint32  `json:"hidden_size"`
+	HiddenLayers          uint32  `json:"num_hidden_layers"`
+	IntermediateSize      uint32  `json:"intermediate_size"`
+	MoEIntermediateSize   uint32  `json:"moe_intermediate_size"`
+	NumAttentionHeads     uint32  `json:"num_attention_heads"`
+	NumKeyValueHeads      uint32  `json:"num_key_value_heads"`
+	QLoraRank             uint32  `json:"q_lora_rank"`
+	KVLoraRank            uint32  `json:"kv_lora_rank"`
+	QKNopeHeadDim         uint32  `json:"qk_nope_head_dim"`
+	QKRopeHeadDim         uint32  `json:"qk_rope_head_dim"`
+	VHeadDim              uint32  `json:"v_head_dim"`
+	NRoutedExperts        uint32  `json:"n_routed_experts"`
+	NSharedExperts        uint32  `json:"n_shared_experts"`
+	NumExpertsPerToken    uint32  `json:"num_experts_per_tok"`
+	FirstKDenseReplace    uint32  `json:"first_k_dense_replace"`
+	RoutedScalingFactor   float32 `json:"routed_scaling_factor"`
+	NormTopKProb          bool    `json:"norm_topk_prob"`
+	ScoringFunc           string  `json:"scoring_func"`
+	RMSNormEPS            float32 `json:"rms_norm_eps"`
+	RopeTheta             float32 `json:"rope_theta"`
+	RopeScaling           struct {
+		Type                          string  `json:"type"`
+		Factor                        float32 `json:"factor"`
+		OriginalMaxPositionEmbeddings uint32  `json:"original_max_position_embeddings"`
+		MScaleAllDim                  float32 `json:"mscale_all_dim"`
+	} `json:"rope_scaling"`
+	QuantizationConfig struct {
+		QuantMethod     string   `json:"quant_method"`
+		WeightBlockSize []uint64 `json:"weight_block_size"`
+	} `json:"quantization_config"`
+}
+
+var _ ModelConverter = (*deepseek2Model)(nil)
+
+func (p *deepseek2Model) KV(t *Tokenizer) ggml.KV {
+	kv := p.ModelParameters.KV(t)
+	kv["general.architecture"] = "deepseek2"
+	kv["block_count"] = p.HiddenLayers
+	kv["context_length"] = p.MaxPositionEmbeddings
+	kv["embedding_length"] = p.HiddenSize
+	kv["feed_forward_length"] = p.IntermediateSize
+	kv["expert_feed_forward_length"] = p.MoEIntermediateSize
+	kv["attention.head_count"] = p.NumAttentionHeads
+	kv["attention.head_count_kv"] = cmp.Or(p.NumKeyValueHeads, p.NumAttentionHeads)
+	kv["attention.key_length"] = p.QKNopeHeadDim + p.QKRopeHeadDim
+	kv["attention.value_length"] = p.VHeadDim
+	kv["attention.kv_lora_rank"] = p.KVLoraRank
+	kv["attention.layer_norm_rms_epsilon"] = p.RMSNormEPS
+	kv["rope.dimension_count"] = p.QKRopeHeadDim
+	kv["rope.freq_base"] = cmp.Or(p.RopeTheta, 10000)
+
+	// DeepSeek-V2-Lite has no q_lora_rank and projects q directly
+	if p.QLoraRank > 0 {
+		kv["attention.q_lora_rank"] = p.QLoraRank
+	}
+
+	kv["leading_dense_block_count"] = p.FirstKDenseReplace
+	kv["expert_count"] = p.NRoutedExperts
+	kv["expert_used_count"] = p.NumExpertsPerToken
+	kv["expert_shared_count"] = p.NSharedExperts
+	kv["expert_weights_scale"] = cmp.Or(p.RoutedScalingFactor, 1)
+	kv["expert_weights_norm"] = p.NormTopKProb
+
+	switch p.ScoringFunc {
+	case "", "softmax":
+		kv["expert_gating_func"] = uint32(1)
+	case "sigmoid":
+		kv["expert_gating_func"] = uint32(2)
+	default:
+		panic("unknown scoring function")
+	}
+
+	switch p.RopeScaling.Type {
+	case "":
+		// no scaling
+	case "yarn":
+		kv["rope.scaling.type"] = p.RopeScaling.Type
+		kv["rope.scaling.factor"] = p.RopeScaling.Factor
+		kv["rope.scaling.original_context_length"] = p.RopeScaling.OriginalMaxPositionEmbeddings
+		kv["rope.scaling.yarn_log_multiplier"] = 0.1 * p.RopeScaling.MScaleAllDim
+	default:
+		panic("unknown rope scaling type")
+	}
+
+	return kv
+}
+
+func (p *deepseek2Model) Tensors(ts []Tensor) []*ggml.Tensor {
+	ts = p.dequantize(ts)
+
+	merges := make([]merge, 0, p.HiddenLayers*3)
+	for i := range p.HiddenLayers {
+		merges = append(merges, merge{
+			fmt.Sprintf("blk.%d.*.gate_proj.weight", i),
+			fmt.Sprintf("blk.%d.ffn_gate_exps.weight", i),
+		}, merge{
+			fmt.Sprintf("blk.%d.*.up_proj.weight", i),
+			fmt.Sprintf("blk.%d.ffn_up_exps.weight", i),
+		}, merge{
+			fmt.Sprintf("blk.%d.*.down_proj.weight", i),
+			fmt.Sprintf("blk.%d.ffn_down_exps.weight", i),
+		})
+	}
+
+	out, ts := mergeTensors(ts, merges...)
+	for _, t := range ts {
+		// DeepSeek-V3 appends multi-token prediction layers which aren't
+		// used for inference
+		if name, ok := strings.CutPrefix(t.Name(), "blk."); ok {
+			n, _, _ := strings.Cut(name, ".")
+			if i, err := strconv.Atoi(n); err == nil && uint32(i) >= p.HiddenLayers {
+				continue
+			}
+		}
+
+		out = append(out, &ggml.Tensor{
+			Name:     t.Name(),
+			Kind:     t.Kind(),
+			Shape:    t.Shape(),
+			WriterTo: t,
+		})
+	}
+
+	return out
+}
+
+// dequantize removes the inverse scales of DeepSeek-V3's FP8 weights from ts
+// and multiplies each block of a weight by its scale as it's written. A scale
+// without a weight fails to be written.
+func (p *deepseek2Model) dequantize(ts []Tensor) []Tensor {
+	scales := make(map[string]Tensor)
+	for _, t := range ts {
+		if name, ok := strings.CutSuffix(t.Name(), "_scale_inv"); ok {
+			scales[name] = t
+		}
+	}
+
+	if len(scales) == 0 {
+		return ts
+	}
+
+	block := []uint64{128, 128}
+	if len(p.QuantizationConfig.WeightBlockSize) == 2 {
+		block = p.QuantizationConfig.WeightBlockSize
+	}
+
+	scaled := make(map[string]bool)
+	for _, t := range ts {
+		if scale, ok := scales[t.Name()]; ok {
+			t.SetRepacker(func(name string, data []float32, shape []uint64) ([]float32, error) {
+				return scaleBlocks(name, data, shape, scale, block)
+			})
+			scaled[scale.Name()] = true
+		}
+	}
+
+	return slices.DeleteFunc(ts, func(t Tensor) bool {
+		if scaled[t.Name()] {
+			return true
+		}
+
+		if strings.HasSuffix(t.Name(), "_scale_inv") {
+			t.SetRepacker(func(name string, _ []float32, _ []uint64) ([]float32, error) {
+				return nil, fmt.Errorf("unsupported tensor %s: no weight for the scale", name)
+			})
+		}
+		return false
+	})
+}
+
+// scaleBlocks multiplies each block of the 2D weight in data by the matching
+// value of scale
+func scaleBlocks(name string, data []float32, shape []uint64, scale Tensor, block []uint64) ([]float32, error) {
+	var b bytes.Buffer
+	if _, err := scale.WriteTo(&b); err != nil {
+		return nil, err
+	}
+
+	scales := make([]float32, b.Len()/4)
+	if err := binary.Read(&b, binary.LittleEndian, scales); err != nil {
+		return nil, err
+	}
+
+	if len(shape) != 2 {
+		return nil, fmt.Errorf("%s: unsupported shape %v for a scaled weight", name, shape)
+	}
+
+	rows, cols := shape[0], shape[1]
+	scaleCols := (cols + block[1] - 1) / block[1]
+	if uint64(len(scales)) != (rows+block[0]-1)/block[0]*scaleCols {
+		return nil, fmt.Errorf("%s: scale shape %v doesn't match weight shape %v", name, scale.Shape(), shape)
+	}
+
+	for r := range rows {
+		for c := range cols {
+			data[r*cols+c] *= scales[r/block[0]*scaleCols+c/block[1]]
+		}
+	}
+
+	return data, nil
+}
+
+func (p *deepseek2Model) Replacements() []string {
+	return []string{
+		"lm_head", "output",
+		"model.embed_tokens", "token_embd",
+		"model.norm", "output_norm",
+		"model.layers", "blk",
+		"input_layernorm", "attn_norm",
+		"self_attn.q_proj", "attn_q",
+		"self_attn.q_a_proj", "attn_q_a",
+		"self_attn.q_a_layernorm", "attn_q_a_norm",
+		"self_attn.q_b_proj", "attn_q_b",
+		"self_attn.kv_a_proj_with_mqa", "attn_kv_a_mqa",
+		"self_attn.kv_a_layernorm", "attn_kv_a_norm",
+		"self_attn.kv_b_proj", "attn_kv_b",
+		"self_attn.o_proj", "attn_output",
+		"mlp.shared_experts.gate_proj", "ffn_gate_shexp",
+		"mlp.shared_experts.up_proj", "ffn_up_shexp",
+		"mlp.shared_experts.down_proj", "ffn_down_shexp",
+		"mlp.gate.e_score_correction_bias", "exp_probs_b.bias",
+		"mlp.gate.weight", "ffn_gate_inp.weight",
+		"mlp.gate_proj", "ffn_gate",
+		"mlp.up_proj", "ffn_up",
+		"mlp.down_proj", "ffn_down",
+		"mlp.experts.", "",
+		"post_attention_layernorm", "ffn_norm",
+	}
+}
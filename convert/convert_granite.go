@@ -0,0 +1,80 @@
+package convert
+
+import (
+	"strings"
+
+	"github.com/ollama/ollama/fs/ggml"
+)
+
+type graniteModel struct {
+	llamaModel
+	EmbeddingMultiplier float32 `json:"embedding_multiplier"`
+	ResidualMultiplier  float32 `json:"residual_multiplier"`
+	AttentionMultiplier float32 `json:"attention_multiplier"`
+	LogitsScaling       float32 `json:"logits_scaling"`
+
+	// NumLocalExperts and NumExpertsPerToken are set for Granite MoE models
+	NumLocalExperts    uint32 `json:"num_local_experts"`
+	NumExpertsPerToken uint32 `json:"num_experts_per_tok"`
+}
+
+var _ ModelConverter = (*graniteModel)(nil)
+
+func (p *graniteModel) KV(t *Tokenizer) ggml.KV {
+	arch := "granite"
+	if p.NumLocalExperts > 0 {
+		arch += "moe"
+	}
+
+	kv := p.ModelParameters.KV(t)
+	kv["general.architecture"] = arch
+
+	for k, v := range p.llamaModel.KV(t) {
+		if strings.HasPrefix(k, "llama.") {
+			kv[strings.Replace(k, "llama.", arch+".", 1)] = v
+		}
+	}
+
+	kv[arch+".embedding_scale"] = p.EmbeddingMultiplier
+	kv[arch+".residual_scale"] = p.ResidualMultiplier
+	kv[arch+".attention.scale"] = p.AttentionMultiplier
+	kv[arch+".logit_scale"] = p.LogitsScaling
+
+	if p.NumLocalExperts > 0 {
+		kv[arch+".expert_count"] = p.NumLocalExperts
+		kv[arch+".expert_used_count"] = p.NumExpertsPerToken
+	}
+
+	return kv
+}
+
+func (p *graniteModel) Tensors(ts []Tensor) []*ggml.Tensor {
+	var out []*ggml.Tensor
+	var rest []Tensor
+	for _, t := range ts {
+		if strings.HasSuffix(t.Name(), ".ffn_gate_up_exps.weight") {
+			// the expert gate and up projections are stored together,
+			// gate first
+			for t := range splitDim(t, 1,
+				split{Replacer: strings.NewReplacer("gate_up", "gate")},
+				split{Replacer: strings.NewReplacer("gate_up", "up")},
+			) {
+				out = append(out, t)
+			}
+			continue
+		}
+
+		rest = append(rest, t)
+	}
+
+	return append(out, p.llamaModel.Tensors(rest)...)
+}
+
+func (p *graniteModel) Replacements() []string {
+	return append(
+		p.llamaModel.Replacements(),
+		"block_sparse_moe.router.layer", "ffn_gate_inp",
+		"block_sparse_moe.input_linear", "ffn_gate_up_exps",
+		"block_sparse_moe.output_linear", "ffn_down_exps",
+	)
+}
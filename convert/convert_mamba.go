@@ -0,0 +1,119 @@
+package convert
+
+import (
+	"cmp"
+	"math"
+	"strings"
+
+	"github.com/ollama/ollama/fs/ggml"
+)
+
+// mambaModel converts Mamba and Mamba2 state space models. Mamba2 models
+// are recognized by their heads.
+type mambaModel struct {
+	ModelParameters
+	HiddenSize       uint32  `json:"hidden_size"`
+	HiddenLayers     uint32  `json:"num_hidden_layers"`
+	IntermediateSize uint32  `json:"intermediate_size"`
+	StateSize        uint32  `json:"state_size"`
+	ConvKernel       uint32  `json:"conv_kernel"`
+	Expand           uint32  `json:"expand"`
+	TimeStepRank     uint32  `json:"time_step_rank"`
+	NumHeads         uint32  `json:"num_heads"`
+	NumGroups        uint32  `json:"n_groups"`
+	LayerNormEPS     float32 `json:"layer_norm_epsilon"`
+}
+
+var _ ModelConverter = (*mambaModel)(nil)
+
+func (p *mambaModel) arch() string {
+	if p.NumHeads > 0 {
+		return "mamba2"
+	}
+	return "mamba"
+}
+
+func (p *mambaModel) innerSize() uint32 {
+	return cmp.Or(p.IntermediateSize, cmp.Or(p.Expand, 2)*p.HiddenSize)
+}
+
+func (p *mambaModel) KV(t *Tokenizer) ggml.KV {
+	kv := p.ModelParameters.KV(t)
+	kv["general.architecture"] = p.arch()
+	kv["block_count"] = p.HiddenLayers
+	// state space models don't have a maximum context length
+	kv["context_length"] = uint32(1 << 20)
+	kv["embedding_length"] = p.HiddenSize
+	kv["feed_forward_length"] = uint32(0)
+	kv["attention.head_count"] = uint32(0)
+	kv["attention.layer_norm_rms_epsilon"] = cmp.Or(p.LayerNormEPS, 1e-5)
+	kv["ssm.conv_kernel"] = cmp.Or(p.ConvKernel, 4)
+	kv["ssm.inner_size"] = p.innerSize()
+	kv["ssm.state_size"] = cmp.Or(p.StateSize, 16)
+
+	if p.NumHeads > 0 {
+		kv["ssm.time_step_rank"] = p.NumHeads
+		kv["ssm.group_count"] = cmp.Or(p.NumGroups, 1)
+	} else {
+		kv["ssm.time_step_rank"] = cmp.Or(p.TimeStepRank, (p.HiddenSize+15)/16)
+		kv["ssm.dt_b_c_rms"] = false
+	}
+
+	return kv
+}
+
+func (p *mambaModel) Tensors(ts []Tensor) []*ggml.Tensor {
+	out := make([]*ggml.Tensor, 0, len(ts))
+	for _, t := range ts {
+		shape := t.Shape()
+		switch {
+		case strings.HasSuffix(t.Name(), ".ssm_conv1d.weight"):
+			// drop the single input channel of the depthwise convolution
+			shape = []uint64{shape[0], shape[len(shape)-1]}
+		case strings.HasSuffix(t.Name(), ".ssm_a"):
+			// A is stored as log(-A)
+			t.SetRepacker(func(_ string, data []float32, _ []uint64) ([]float32, error) {
+				for i := range data {
+					data[i] = -float32(math.Exp(float64(data[i])))
+				}
+				return data, nil
+			})
+			fallthrough
+		case strings.HasSuffix(t.Name(), ".ssm_d"):
+			if p.NumHeads > 0 {
+				shape = []uint64{shape[0], 1}
+			}
+		case strings.HasSuffix(t.Name(), ".ssm_norm.weight"):
+			groups := uint64(cmp.Or(p.NumGroups, 1))
+			shape = []uint64{groups, shape[0] / groups}
+		}
+
+		out = append(out, &ggml.Tensor{
+			Name:     t.Name(),
+			Kind:     t.Kind(),
+			Shape:    shape,
+			WriterTo: t,
+		})
+	}
+
+	return out
+}
+
+func (p *mambaModel) Replacements() []string {
+	return []string{
+		"lm_head", "output",
+		"backbone.embeddings", "token_embd",
+		"backbone.norm_f", "output_norm",
+		"backbone.layers", "blk",
+		"mixer.in_proj", "ssm_in",
+		"mixer.conv1d", "ssm_conv1d",
+		"mixer.x_proj", "ssm_x",
+		"mixer.dt_proj", "ssm_dt",
+		"mixer.dt_bias", "ssm_dt.bias",
+		"mixer.A_log", "ssm_a",
+		"mixer.D", "ssm_d",
+		"mixer.norm", "ssm_norm",
+		"mixer.out_proj", "ssm_out",
+		"norm.weight", "attn_norm.weight",
+	}
+}
@@ -33,6 +33,7 @@ type phi3Model struct {
 	MaxPositionEmbeddings         uint32  `json:"max_position_embeddings"`
 	OriginalMaxPositionEmbeddings uint32  `json:"original_max_position_embeddings"`
 	SlidingWindow                 uint32  `json:"sliding_window"`
+	PartialRotaryFactor           float32 `json:"partial_rotary_factor"`
 }
 
 var _ ModelConverter = (*phi3Model)(nil)
@@ -47,7 +48,7 @@ func (p *phi3Model) KV(t *Tokenizer) ggml.KV {
 	kv["phi3.attention.head_count"] = cmp.Or(p.NumAttentionHeads, p.NHead)
 	kv["phi3.attention.head_count_kv"] = cmp.Or(p.NumKeyValueHeads, p.NHeadKV)
 	kv["phi3.attention.layer_norm_rms_epsilon"] = p.RMSNormEPS
-	kv["phi3.rope.dimension_count"] = p.HiddenSize / cmp.Or(p.NumAttentionHeads, p.NHead)
+	kv["phi3.rope.dimension_count"] = uint32(float32(p.HiddenSize/cmp.Or(p.NumAttentionHeads, p.NHead)) * cmp.Or(p.PartialRotaryFactor, 1))
 	kv["phi3.rope.freq_base"] = p.RopeTheta
 	kv["phi3.rope.scaling.original_context_length"] = p.OriginalMaxPositionEmbeddings
 	kv["phi3.attention.sliding_window"] = p.SlidingWindow
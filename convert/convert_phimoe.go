@@ -0,0 +1,61 @@
+package convert
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ollama/ollama/fs/ggml"
+)
+
+type phimoeModel struct {
+	phi3Model
+	NumLocalExperts    uint32 `json:"num_local_experts"`
+	NumExpertsPerToken uint32 `json:"num_experts_per_tok"`
+}
+
+var _ ModelConverter = (*phimoeModel)(nil)
+
+func (p *phimoeModel) KV(t *Tokenizer) ggml.KV {
+	kv := p.ModelParameters.KV(t)
+	kv["general.architecture"] = "phimoe"
+
+	for k, v := range p.phi3Model.KV(t) {
+		if strings.HasPrefix(k, "phi3.") {
+			kv[strings.Replace(k, "phi3.", "phimoe.", 1)] = v
+		}
+	}
+
+	kv["phimoe.expert_count"] = p.NumLocalExperts
+	kv["phimoe.expert_used_count"] = p.NumExpertsPerToken
+	return kv
+}
+
+func (p *phimoeModel) Tensors(ts []Tensor) []*ggml.Tensor {
+	merges := make([]merge, 0, p.NumHiddenLayers*3)
+	for i := range p.NumHiddenLayers {
+		merges = append(merges, merge{
+			fmt.Sprintf("blk.%d.*.w1.weight", i),
+			fmt.Sprintf("blk.%d.ffn_gate_exps.weight", i),
+		}, merge{
+			fmt.Sprintf("blk.%d.*.w2.weight", i),
+			fmt.Sprintf("blk.%d.ffn_down_exps.weight", i),
+		}, merge{
+			fmt.Sprintf("blk.%d.*.w3.weight", i),
+			fmt.Sprintf("blk.%d.ffn_up_exps.weight", i),
+		})
+	}
+
+	out, ts := mergeTensors(ts, merges...)
+	return append(out, p.phi3Model.Tensors(ts)...)
+}
+
+func (p *phimoeModel) Replacements() []string {
+	return append(
+		p.phi3Model.Replacements(),
+		"self_attn.q_proj", "attn_q",
+		"self_attn.k_proj", "attn_k",
+		"self_attn.v_proj", "attn_v",
+		"block_sparse_moe.gate", "ffn_gate_inp",
+		"block_sparse_moe.experts.", "",
+	)
+}
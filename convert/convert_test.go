@@ -12,14 +12,17 @@ import (
 	"io/fs"
 	"log/slog"
 	"maps"
+	"math"
 	"os"
 	"path/filepath"
 	"slices"
 	"strings"
 	"testing"
+	"testing/fstest"
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/ollama/ollama/fs/ggml"
+	"github.com/x448/float16"
 )
 
 type tensorData struct {
@@ -467,3 +470,572 @@ func generateLoraTestData(t *testing.T, tempDir string) {
 		t.Fatal(err)
 	}
 }
+
+// generateModelTestData writes a model with config to dir. Its tensors are
+// named and shaped as in shapes and filled with ones.
+func generateModelTestData(t *testing.T, dir, config string, shapes map[string][]int) {
+	t.Helper()
+
+	td := make(map[string]*tensorData, len(shapes))
+	var offset int
+	for _, name := range slices.Sorted(maps.Keys(shapes)) {
+		n := 4
+		for _, dim := range shapes[name] {
+			n *= dim
+		}
+
+		td[name] = &tensorData{Offsets: []int{offset, offset + n}, Type: "F32", Shape: shapes[name]}
+		offset += n
+	}
+
+	header, err := json.Marshal(td)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := binary.Write(&buf, binary.LittleEndian, int64(len(header))); err != nil {
+		t.Fatal(err)
+	}
+	buf.Write(header)
+
+	ones := slices.Repeat([]float32{1}, offset/4)
+	if err := binary.Write(&buf, binary.LittleEndian, ones); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, b := range map[string][]byte{
+		"model-00001-of-00001.safetensors": buf.Bytes(),
+		"config.json":                      []byte(config),
+		"tokenizer.json":                   []byte("{}"),
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), b, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestConvertArchitectures(t *testing.T) {
+	cases := []struct {
+		name    string
+		config  string
+		tensors map[string][]int
+
+		// wantKV are some of the expected KVs. wantTensors are all of the
+		// expected tensors with their GGUF shapes.
+		wantKV      map[string]any
+		wantTensors map[string][]uint64
+	}{
+		{
+			name: "phimoe",
+			config: `{
+				"architectures": ["PhimoeForCausalLM"],
+				"hidden_size": 8,
+				"intermediate_size": 4,
+				"num_hidden_layers": 1,
+				"num_attention_heads": 2,
+				"num_key_value_heads": 1,
+				"num_local_experts": 2,
+				"num_experts_per_tok": 1,
+				"max_position_embeddings": 64,
+				"original_max_position_embeddings": 32,
+				"rope_scaling": {"type": "longrope", "long_factor": [1, 1], "short_factor": [1, 1]},
+				"rms_norm_eps": 1e-5
+			}`,
+			tensors: map[string][]int{
+				"model.embed_tokens.weight":                           {16, 8},
+				"model.norm.weight":                                   {8},
+				"model.norm.bias":                                     {8},
+				"lm_head.weight":                                      {16, 8},
+				"lm_head.bias":                                        {16},
+				"model.layers.0.input_layernorm.weight":               {8},
+				"model.layers.0.input_layernorm.bias":                 {8},
+				"model.layers.0.self_attn.q_proj.weight":              {8, 8},
+				"model.layers.0.self_attn.q_proj.bias":                {8},
+				"model.layers.0.self_attn.k_proj.weight":              {4, 8},
+				"model.layers.0.self_attn.k_proj.bias":                {4},
+				"model.layers.0.self_attn.v_proj.weight":              {4, 8},
+				"model.layers.0.self_attn.v_proj.bias":                {4},
+				"model.layers.0.self_attn.o_proj.weight":              {8, 8},
+				"model.layers.0.self_attn.o_proj.bias":                {8},
+				"model.layers.0.post_attention_layernorm.weight":      {8},
+				"model.layers.0.post_attention_layernorm.bias":        {8},
+				"model.layers.0.block_sparse_moe.gate.weight":         {2, 8},
+				"model.layers.0.block_sparse_moe.experts.0.w1.weight": {4, 8},
+				"model.layers.0.block_sparse_moe.experts.0.w2.weight": {8, 4},
+				"model.layers.0.block_sparse_moe.experts.0.w3.weight": {4, 8},
+				"model.layers.0.block_sparse_moe.experts.1.w1.weight": {4, 8},
+				"model.layers.0.block_sparse_moe.experts.1.w2.weight": {8, 4},
+				"model.layers.0.block_sparse_moe.experts.1.w3.weight": {4, 8},
+			},
+			wantKV: map[string]any{
+				"general.architecture":           "phimoe",
+				"phimoe.block_count":             uint32(1),
+				"phimoe.embedding_length":        uint32(8),
+				"phimoe.attention.head_count_kv": uint32(1),
+				"phimoe.rope.dimension_count":    uint32(4),
+				"phimoe.expert_count":            uint32(2),
+				"phimoe.expert_used_count":       uint32(1),
+			},
+			wantTensors: map[string][]uint64{
+				"token_embd.weight":          {8, 16},
+				"output_norm.weight":         {8},
+				"output_norm.bias":           {8},
+				"output.weight":              {8, 16},
+				"output.bias":                {16},
+				"rope_factors_long.weight":   {2},
+				"rope_factors_short.weight":  {2},
+				"blk.0.attn_norm.weight":     {8},
+				"blk.0.attn_norm.bias":       {8},
+				"blk.0.attn_q.weight":        {8, 8},
+				"blk.0.attn_q.bias":          {8},
+				"blk.0.attn_k.weight":        {8, 4},
+				"blk.0.attn_k.bias":          {4},
+				"blk.0.attn_v.weight":        {8, 4},
+				"blk.0.attn_v.bias":          {4},
+				"blk.0.attn_output.weight":   {8, 8},
+				"blk.0.attn_output.bias":     {8},
+				"blk.0.ffn_norm.weight":      {8},
+				"blk.0.ffn_norm.bias":        {8},
+				"blk.0.ffn_gate_inp.weight":  {8, 2},
+				"blk.0.ffn_gate_exps.weight": {8, 4, 2},
+				"blk.0.ffn_down_exps.weight": {4, 8, 2},
+				"blk.0.ffn_up_exps.weight":   {8, 4, 2},
+			},
+		},
+		{
+			name: "deepseek2",
+			config: `{
+				"architectures": ["DeepseekV3ForCausalLM"],
+				"hidden_size": 8,
+				"intermediate_size": 6,
+				"moe_intermediate_size": 4,
+				"num_hidden_layers": 2,
+				"num_nextn_predict_layers": 1,
+				"num_attention_heads": 2,
+				"num_key_value_heads": 2,
+				"q_lora_rank": 4,
+				"kv_lora_rank": 4,
+				"qk_nope_head_dim": 2,
+				"qk_rope_head_dim": 2,
+				"v_head_dim": 2,
+				"n_routed_experts": 2,
+				"n_shared_experts": 1,
+				"num_experts_per_tok": 1,
+				"first_k_dense_replace": 1,
+				"routed_scaling_factor": 2.5,
+				"norm_topk_prob": true,
+				"scoring_func": "sigmoid",
+				"max_position_embeddings": 640,
+				"rope_theta": 10000,
+				"rope_scaling": {"type": "yarn", "factor": 40, "original_max_position_embeddings": 16, "mscale_all_dim": 1.0},
+				"rms_norm_eps": 1e-6
+			}`,
+			tensors: map[string][]int{
+				"model.embed_tokens.weight":                          {16, 8},
+				"model.norm.weight":                                  {8},
+				"lm_head.weight":                                     {16, 8},
+				"model.layers.0.input_layernorm.weight":              {8},
+				"model.layers.0.self_attn.q_a_proj.weight":           {4, 8},
+				"model.layers.0.self_attn.q_a_layernorm.weight":      {4},
+				"model.layers.0.self_attn.q_b_proj.weight":           {8, 4},
+				"model.layers.0.self_attn.kv_a_proj_with_mqa.weight": {6, 8},
+				"model.layers.0.self_attn.kv_a_layernorm.weight":     {4},
+				"model.layers.0.self_attn.kv_b_proj.weight":          {8, 4},
+				"model.layers.0.self_attn.o_proj.weight":             {8, 4},
+				"model.layers.0.post_attention_layernorm.weight":     {8},
+				"model.layers.0.mlp.gate_proj.weight":                {6, 8},
+				"model.layers.0.mlp.up_proj.weight":                  {6, 8},
+				"model.layers.0.mlp.down_proj.weight":                {8, 6},
+				"model.layers.1.mlp.gate.weight":                     {2, 8},
+				"model.layers.1.mlp.gate.e_score_correction_bias":    {2},
+				"model.layers.1.mlp.experts.0.gate_proj.weight":      {4, 8},
+				"model.layers.1.mlp.experts.0.up_proj.weight":        {4, 8},
+				"model.layers.1.mlp.experts.0.down_proj.weight":      {8, 4},
+				"model.layers.1.mlp.experts.1.gate_proj.weight":      {4, 8},
+				"model.layers.1.mlp.experts.1.up_proj.weight":        {4, 8},
+				"model.layers.1.mlp.experts.1.down_proj.weight":      {8, 4},
+				"model.layers.1.mlp.shared_experts.gate_proj.weight": {4, 8},
+				"model.layers.1.mlp.shared_experts.up_proj.weight":   {4, 8},
+				"model.layers.1.mlp.shared_experts.down_proj.weight": {8, 4},
+				"model.layers.2.input_layernorm.weight":              {8},
+				"model.layers.2.mlp.experts.0.gate_proj.weight":      {4, 8},
+			},
+			wantKV: map[string]any{
+				"general.architecture":                       "deepseek2",
+				"deepseek2.block_count":                      uint32(2),
+				"deepseek2.leading_dense_block_count":        uint32(1),
+				"deepseek2.attention.key_length":             uint32(4),
+				"deepseek2.attention.value_length":           uint32(2),
+				"deepseek2.attention.q_lora_rank":            uint32(4),
+				"deepseek2.attention.kv_lora_rank":           uint32(4),
+				"deepseek2.rope.dimension_count":             uint32(2),
+				"deepseek2.expert_count":                     uint32(2),
+				"deepseek2.expert_shared_count":              uint32(1),
+				"deepseek2.expert_weights_scale":             float32(2.5),
+				"deepseek2.expert_weights_norm":              true,
+				"deepseek2.expert_gating_func":               uint32(2),
+				"deepseek2.rope.scaling.factor":              float32(40),
+				"deepseek2.rope.scaling.yarn_log_multiplier": float32(0.1),
+			},
+			wantTensors: map[string][]uint64{
+				"token_embd.weight":           {8, 16},
+				"output_norm.weight":          {8},
+				"output.weight":               {8, 16},
+				"blk.0.attn_norm.weight":      {8},
+				"blk.0.attn_q_a.weight":       {8, 4},
+				"blk.0.attn_q_a_norm.weight":  {4},
+				"blk.0.attn_q_b.weight":       {4, 8},
+				"blk.0.attn_kv_a_mqa.weight":  {8, 6},
+				"blk.0.attn_kv_a_norm.weight": {4},
+				"blk.0.attn_kv_b.weight":      {4, 8},
+				"blk.0.attn_output.weight":    {4, 8},
+				"blk.0.ffn_norm.weight":       {8},
+				"blk.0.ffn_gate.weight":       {8, 6},
+				"blk.0.ffn_up.weight":         {8, 6},
+				"blk.0.ffn_down.weight":       {6, 8},
+				"blk.1.ffn_gate_inp.weight":   {8, 2},
+				"blk.1.exp_probs_b.bias":      {2},
+				"blk.1.ffn_gate_exps.weight":  {8, 4, 2},
+				"blk.1.ffn_up_exps.weight":    {8, 4, 2},
+				"blk.1.ffn_down_exps.weight":  {4, 8, 2},
+				"blk.1.ffn_gate_shexp.weight": {8, 4},
+				"blk.1.ffn_up_shexp.weight":   {8, 4},
+				"blk.1.ffn_down_shexp.weight": {4, 8},
+			},
+		},
+		{
+			name: "granitemoe",
+			config: `{
+				"architectures": ["GraniteMoeForCausalLM"],
+				"hidden_size": 8,
+				"intermediate_size": 4,
+				"num_hidden_layers": 1,
+				"num_attention_heads": 2,
+				"num_key_value_heads": 2,
+				"num_local_experts": 2,
+				"num_experts_per_tok": 1,
+				"embedding_multiplier": 12,
+				"residual_multiplier": 0.22,
+				"attention_multiplier": 0.0078125,
+				"logits_scaling": 6,
+				"rms_norm_eps": 1e-6
+			}`,
+			tensors: map[string][]int{
+				"model.embed_tokens.weight":                            {16, 8},
+				"model.norm.weight":                                    {8},
+				"model.layers.0.input_layernorm.weight":                {8},
+				"model.layers.0.self_attn.q_proj.weight":               {8, 8},
+				"model.layers.0.self_attn.k_proj.weight":               {8, 8},
+				"model.layers.0.self_attn.v_proj.weight":               {8, 8},
+				"model.layers.0.self_attn.o_proj.weight":               {8, 8},
+				"model.layers.0.post_attention_layernorm.weight":       {8},
+				"model.layers.0.block_sparse_moe.router.layer.weight":  {2, 8},
+				"model.layers.0.block_sparse_moe.input_linear.weight":  {2, 8, 8},
+				"model.layers.0.block_sparse_moe.output_linear.weight": {2, 8, 4},
+			},
+			wantKV: map[string]any{
+				"general.architecture":         "granitemoe",
+				"granitemoe.block_count":       uint32(1),
+				"granitemoe.embedding_scale":   float32(12),
+				"granitemoe.residual_scale":    float32(0.22),
+				"granitemoe.attention.scale":   float32(0.0078125),
+				"granitemoe.logit_scale":       float32(6),
+				"granitemoe.expert_count":      uint32(2),
+				"granitemoe.expert_used_count": uint32(1),
+			},
+			wantTensors: map[string][]uint64{
+				"token_embd.weight":          {8, 16},
+				"output_norm.weight":         {8},
+				"blk.0.attn_norm.weight":     {8},
+				"blk.0.attn_q.weight":        {8, 8},
+				"blk.0.attn_k.weight":        {8, 8},
+				"blk.0.attn_v.weight":        {8, 8},
+				"blk.0.attn_output.weight":   {8, 8},
+				"blk.0.ffn_norm.weight":      {8},
+				"blk.0.ffn_gate_inp.weight":  {8, 2},
+				"blk.0.ffn_gate_exps.weight": {8, 4, 2},
+				"blk.0.ffn_up_exps.weight":   {8, 4, 2},
+				"blk.0.ffn_down_exps.weight": {4, 8, 2},
+			},
+		},
+		{
+			name: "olmo2",
+			config: `{
+				"architectures": ["Olmo2ForCausalLM"],
+				"hidden_size": 8,
+				"intermediate_size": 4,
+				"num_hidden_layers": 1,
+				"num_attention_heads": 2,
+				"num_key_value_heads": 2,
+				"max_position_embeddings": 64,
+				"rope_theta": 500000,
+				"rms_norm_eps": 1e-6
+			}`,
+			tensors: map[string][]int{
+				"model.embed_tokens.weight":                        {16, 8},
+				"model.norm.weight":                                {8},
+				"lm_head.weight":                                   {16, 8},
+				"model.layers.0.self_attn.q_proj.weight":           {8, 8},
+				"model.layers.0.self_attn.k_proj.weight":           {8, 8},
+				"model.layers.0.self_attn.v_proj.weight":           {8, 8},
+				"model.layers.0.self_attn.o_proj.weight":           {8, 8},
+				"model.layers.0.self_attn.q_norm.weight":           {8},
+				"model.layers.0.self_attn.k_norm.weight":           {8},
+				"model.layers.0.post_attention_layernorm.weight":   {8},
+				"model.layers.0.mlp.gate_proj.weight":              {4, 8},
+				"model.layers.0.mlp.up_proj.weight":                {4, 8},
+				"model.layers.0.mlp.down_proj.weight":              {8, 4},
+				"model.layers.0.post_feedforward_layernorm.weight": {8},
+			},
+			wantKV: map[string]any{
+				"general.architecture":      "olmo2",
+				"olmo2.block_count":         uint32(1),
+				"olmo2.context_length":      uint32(64),
+				"olmo2.feed_forward_length": uint32(4),
+				"olmo2.rope.freq_base":      float32(500000),
+			},
+			wantTensors: map[string][]uint64{
+				"token_embd.weight":                {8, 16},
+				"output_norm.weight":               {8},
+				"output.weight":                    {8, 16},
+				"blk.0.attn_q.weight":              {8, 8},
+				"blk.0.attn_k.weight":              {8, 8},
+				"blk.0.attn_v.weight":              {8, 8},
+				"blk.0.attn_output.weight":         {8, 8},
+				"blk.0.attn_q_norm.weight":         {8},
+				"blk.0.attn_k_norm.weight":         {8},
+				"blk.0.post_attention_norm.weight": {8},
+				"blk.0.ffn_gate.weight":            {8, 4},
+				"blk.0.ffn_up.weight":              {8, 4},
+				"blk.0.ffn_down.weight":            {4, 8},
+				"blk.0.post_ffw_norm.weight":       {8},
+			},
+		},
+		{
+			name: "mamba",
+			config: `{
+				"architectures": ["MambaForCausalLM"],
+				"hidden_size": 8,
+				"intermediate_size": 16,
+				"state_size": 4,
+				"time_step_rank": 1,
+				"conv_kernel": 4,
+				"num_hidden_layers": 1,
+				"layer_norm_epsilon": 1e-5
+			}`,
+			tensors: map[string][]int{
+				"backbone.embeddings.weight":              {16, 8},
+				"backbone.norm_f.weight":                  {8},
+				"backbone.layers.0.norm.weight":           {8},
+				"backbone.layers.0.mixer.in_proj.weight":  {32, 8},
+				"backbone.layers.0.mixer.conv1d.weight":   {16, 1, 4},
+				"backbone.layers.0.mixer.conv1d.bias":     {16},
+				"backbone.layers.0.mixer.x_proj.weight":   {9, 16},
+				"backbone.layers.0.mixer.dt_proj.weight":  {16, 1},
+				"backbone.layers.0.mixer.dt_proj.bias":    {16},
+				"backbone.layers.0.mixer.A_log":           {16, 4},
+				"backbone.layers.0.mixer.D":               {16},
+				"backbone.layers.0.mixer.out_proj.weight": {8, 16},
+			},
+			wantKV: map[string]any{
+				"general.architecture":     "mamba",
+				"mamba.block_count":        uint32(1),
+				"mamba.ssm.conv_kernel":    uint32(4),
+				"mamba.ssm.inner_size":     uint32(16),
+				"mamba.ssm.state_size":     uint32(4),
+				"mamba.ssm.time_step_rank": uint32(1),
+			},
+			wantTensors: map[string][]uint64{
+				"token_embd.weight":       {8, 16},
+				"output_norm.weight":      {8},
+				"blk.0.attn_norm.weight":  {8},
+				"blk.0.ssm_in.weight":     {8, 32},
+				"blk.0.ssm_conv1d.weight": {4, 16},
+				"blk.0.ssm_conv1d.bias":   {16},
+				"blk.0.ssm_x.weight":      {16, 9},
+				"blk.0.ssm_dt.weight":     {1, 16},
+				"blk.0.ssm_dt.bias":       {16},
+				"blk.0.ssm_a":             {4, 16},
+				"blk.0.ssm_d":             {16},
+				"blk.0.ssm_out.weight":    {16, 8},
+			},
+		},
+		{
+			name: "mamba2",
+			config: `{
+				"architectures": ["Mamba2ForCausalLM"],
+				"hidden_size": 8,
+				"expand": 2,
+				"state_size": 4,
+				"n_groups": 1,
+				"num_heads": 4,
+				"head_dim": 4,
+				"conv_kernel": 4,
+				"num_hidden_layers": 1,
+				"layer_norm_epsilon": 1e-5
+			}`,
+			tensors: map[string][]int{
+				"backbone.embeddings.weight":              {16, 8},
+				"backbone.norm_f.weight":                  {8},
+				"lm_head.weight":                          {16, 8},
+				"backbone.layers.0.norm.weight":           {8},
+				"backbone.layers.0.mixer.in_proj.weight":  {44, 8},
+				"backbone.layers.0.mixer.conv1d.weight":   {24, 1, 4},
+				"backbone.layers.0.mixer.conv1d.bias":     {24},
+				"backbone.layers.0.mixer.dt_bias":         {4},
+				"backbone.layers.0.mixer.A_log":           {4},
+				"backbone.layers.0.mixer.D":               {4},
+				"backbone.layers.0.mixer.norm.weight":     {16},
+				"backbone.layers.0.mixer.out_proj.weight": {8, 16},
+			},
+			wantKV: map[string]any{
+				"general.architecture":      "mamba2",
+				"mamba2.ssm.inner_size":     uint32(16),
+				"mamba2.ssm.time_step_rank": uint32(4),
+				"mamba2.ssm.group_count":    uint32(1),
+			},
+			wantTensors: map[string][]uint64{
+				"token_embd.weight":       {8, 16},
+				"output_norm.weight":      {8},
+				"output.weight":           {8, 16},
+				"blk.0.attn_norm.weight":  {8},
+				"blk.0.ssm_in.weight":     {8, 44},
+				"blk.0.ssm_conv1d.weight": {4, 24},
+				"blk.0.ssm_conv1d.bias":   {24},
+				"blk.0.ssm_dt.bias":       {4},
+				"blk.0.ssm_a":             {1, 4},
+				"blk.0.ssm_d":             {1, 4},
+				"blk.0.ssm_norm.weight":   {16, 1},
+				"blk.0.ssm_out.weight":    {16, 8},
+			},
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			generateModelTestData(t, dir, tt.config, tt.tensors)
+
+			f, kv, tensors := convertFull(t, os.DirFS(dir))
+
+			gotKV := make(map[string]any, len(tt.wantKV))
+			for k := range tt.wantKV {
+				gotKV[k] = kv[k]
+			}
+
+			if diff := cmp.Diff(tt.wantKV, gotKV); diff != "" {
+				t.Errorf("kv mismatch (-want +got):\n%s", diff)
+			}
+
+			gotTensors := make(map[string][]uint64)
+			for _, tensor := range tensors.Items() {
+				gotTensors[tensor.Name] = tensor.Shape
+			}
+
+			if diff := cmp.Diff(tt.wantTensors, gotTensors); diff != "" {
+				t.Errorf("tensors mismatch (-want +got):\n%s", diff)
+			}
+
+			// A is stored as log(-A) and converted to A
+			for _, tensor := range tensors.Items() {
+				if !strings.HasSuffix(tensor.Name, ".ssm_a") {
+					continue
+				}
+
+				if tensor.Kind != tensorKindFP32 {
+					t.Errorf("%s: kind %d, want F32", tensor.Name, tensor.Kind)
+				}
+
+				var a float32
+				if err := binary.Read(io.NewSectionReader(f, int64(tensors.Offset+tensor.Offset), 4), binary.LittleEndian, &a); err != nil {
+					t.Fatal(err)
+				}
+
+				if want := -float32(math.E); a != want {
+					t.Errorf("%s: got %v, want %v", tensor.Name, a, want)
+				}
+			}
+		})
+	}
+}
+
+func TestConvertDeepSeek2FP8(t *testing.T) {
+	// a 2x4 FP8 weight in blocks of 2x2, so with a 1x2 scale
+	weight := []byte{
+		0x38, 0x40, 0x38, 0x40, // 1, 2, 1, 2
+		0xb8, 0x38, 0x01, 0x7e, // -1, 1, 2^-9, 448
+	}
+
+	var data bytes.Buffer
+	data.Write(weight)
+	if err := binary.Write(&data, binary.LittleEndian, []float32{0.5, 2, 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	header, err := json.Marshal(map[string]*tensorData{
+		"model.layers.0.self_attn.o_proj.weight":           {Offsets: []int{0, 8}, Type: "F8_E4M3", Shape: []int{2, 4}},
+		"model.layers.0.self_attn.o_proj.weight_scale_inv": {Offsets: []int{8, 16}, Type: "F32", Shape: []int{1, 2}},
+		"model.layers.0.self_attn.q_proj.weight_scale_inv": {Offsets: []int{16, 20}, Type: "F32", Shape: []int{1, 1}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var b bytes.Buffer
+	if err := binary.Write(&b, binary.LittleEndian, int64(len(header))); err != nil {
+		t.Fatal(err)
+	}
+	b.Write(header)
+	b.Write(data.Bytes())
+
+	p := &deepseek2Model{HiddenLayers: 1}
+	p.QuantizationConfig.WeightBlockSize = []uint64{2, 2}
+
+	ts, err := parseSafetensors(fstest.MapFS{"model.safetensors": {Data: b.Bytes()}}, strings.NewReplacer(p.Replacements()...), "model.safetensors")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := p.Tensors(ts)
+	if len(out) != 2 {
+		t.Fatalf("expected the weight and the scale without a weight, got %d tensors", len(out))
+	}
+
+	t.Run("weight", func(t *testing.T) {
+		i := slices.IndexFunc(out, func(t *ggml.Tensor) bool { return t.Name == "blk.0.attn_output.weight" })
+		if i < 0 {
+			t.Fatal("weight not found")
+		}
+
+		var b bytes.Buffer
+		if _, err := out[i].WriteTo(&b); err != nil {
+			t.Fatal(err)
+		}
+
+		f16s := make([]uint16, b.Len()/2)
+		if err := binary.Read(&b, binary.LittleEndian, f16s); err != nil {
+			t.Fatal(err)
+		}
+
+		got := make([]float32, len(f16s))
+		for i := range f16s {
+			got[i] = float16.Frombits(f16s[i]).Float32()
+		}
+
+		if diff := cmp.Diff([]float32{0.5, 1, 2, 4, -0.5, 0.5, 0x1p-8, 896}, got); diff != "" {
+			t.Errorf("dequantized weight mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("scale without weight", func(t *testing.T) {
+		i := slices.IndexFunc(out, func(t *ggml.Tensor) bool { return t.Name == "blk.0.attn_q.weight_scale_inv" })
+		if i < 0 {
+			t.Fatal("scale not found")
+		}
+
+		if _, err := out[i].WriteTo(io.Discard); err == nil || !strings.Contains(err.Error(), "unsupported tensor blk.0.attn_q.weight_scale_inv") {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+}
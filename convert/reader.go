@@ -40,6 +40,10 @@ const (
 func (t tensorBase) Kind() uint32 {
 	if strings.HasSuffix(t.name, ".ffn_gate_inp.weight") ||
 		strings.HasSuffix(t.name, ".bias") ||
+		strings.HasSuffix(t.name, ".ssm_conv1d.weight") ||
+		strings.HasSuffix(t.name, ".ssm_a") ||
+		strings.HasSuffix(t.name, ".ssm_d") ||
+		strings.HasSuffix(t.name, ".weight_scale_inv") ||
 		t.name == "token_types.weight" ||
 		t.name == "v.positional_embedding_vlm" ||
 		t.name == "v.tile_position_embd.weight" ||
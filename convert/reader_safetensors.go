@@ -10,6 +10,7 @@ import (
 	"io"
 	"io/fs"
 	"maps"
+	"math"
 	"slices"
 	"strings"
 
@@ -175,6 +176,16 @@ func (st safetensor) WriteTo(w io.Writer) (int64, error) {
 		}
 
 		f32s = bfloat16.DecodeFloat32(u8s)
+	case "F8_E4M3":
+		u8s := make([]uint8, st.size)
+		if err = binary.Read(br, binary.LittleEndian, u8s); err != nil {
+			return 0, err
+		}
+
+		f32s = make([]float32, len(u8s))
+		for i := range u8s {
+			f32s[i] = float8E4M3(u8s[i])
+		}
 	default:
 		return 0, fmt.Errorf("unknown data type: %s", st.dtype)
 	}
@@ -203,3 +214,23 @@ func (st safetensor) WriteTo(w io.Writer) (int64, error) {
 		return 0, fmt.Errorf("unknown storage type: %d", st.Kind())
 	}
 }
+
+// float8E4M3 decodes an FP8 number with a 4 bit exponent and a 3 bit
+// mantissa. It has no infinities, and NaN has all exponent and mantissa bits
+// set.
+func float8E4M3(b uint8) float32 {
+	sign := float32(1)
+	if b&0x80 != 0 {
+		sign = -1
+	}
+
+	exp, mant := int(b>>3&0xf), float64(b&0x7)
+	switch {
+	case exp == 0xf && mant == 0x7:
+		return float32(math.NaN())
+	case exp == 0:
+		return sign * float32(math.Ldexp(mant/8, -6))
+	default:
+		return sign * float32(math.Ldexp(1+mant/8, exp-7))
+	}
+}
@@ -97,6 +97,22 @@ func Models() string {
 	return filepath.Join(home, ".ollama", "models")
 }
 
+// TrustedKeys returns the path to the file listing the public keys trusted to sign models. TrustedKeys can be
+// configured via the OLLAMA_TRUSTED_KEYS environment variable.
+// Default is $HOME/.ollama/trusted_keys
+func TrustedKeys() string {
+	if s := Var("OLLAMA_TRUSTED_KEYS"); s != "" {
+		return s
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		panic(err)
+	}
+
+	return filepath.Join(home, ".ollama", "trusted_keys")
+}
+
 // KeepAlive returns the duration that models stay loaded in memory. KeepAlive can be configured via the OLLAMA_KEEP_ALIVE environment variable.
 // Negative values are treated as infinite. Zero is treated as no keep alive.
 // Default is 5 minutes.
@@ -137,6 +153,21 @@ func LoadTimeout() (loadTimeout time.Duration) {
 	return loadTimeout
 }
 
+// HuggingFaceEndpoint returns the base URL of the Hugging Face Hub used to pull hf.co models. HuggingFaceEndpoint
+// can be configured via the HF_ENDPOINT environment variable.
+// Default is "https://huggingface.co"
+func HuggingFaceEndpoint() *url.URL {
+	if s := Var("HF_ENDPOINT"); s != "" {
+		if u, err := url.Parse(strings.TrimSuffix(s, "/")); err == nil && u.Scheme != "" && u.Host != "" {
+			return u
+		}
+
+		slog.Warn("invalid HF_ENDPOINT, using default", "value", s)
+	}
+
+	return &url.URL{Scheme: "https", Host: "huggingface.co"}
+}
+
 func Remotes() []string {
 	var r []string
 	raw := strings.TrimSpace(Var("OLLAMA_REMOTES"))
@@ -202,6 +233,8 @@ var (
 	MultiUserCache = Bool("OLLAMA_MULTIUSER_CACHE")
 	// Enable the new Ollama engine
 	NewEngine = Bool("OLLAMA_NEW_ENGINE")
+	// PagedKVCache stores the K/V cache in blocks that can be shared between sequences
+	PagedKVCache = Bool("OLLAMA_PAGED_KV_CACHE")
 	// ContextLength sets the default context length
 	ContextLength = Uint("OLLAMA_CONTEXT_LENGTH", 4096)
 	// Auth enables authentication between the Ollama client and server
@@ -223,6 +256,9 @@ var (
 	VkVisibleDevices      = String("GGML_VK_VISIBLE_DEVICES")
 	GpuDeviceOrdinal      = String("GPU_DEVICE_ORDINAL")
 	HsaOverrideGfxVersion = String("HSA_OVERRIDE_GFX_VERSION")
+
+	// HuggingFaceToken authenticates pulls of private and gated Hugging Face repositories
+	HuggingFaceToken = String("HF_TOKEN")
 )
 
 func Uint(key string, defaultValue uint) func() uint {
@@ -292,7 +328,10 @@ func AsMap() map[string]EnvVar {
 		"OLLAMA_MULTIUSER_CACHE":   {"OLLAMA_MULTIUSER_CACHE", MultiUserCache(), "Optimize prompt caching for multi-user scenarios"},
 		"OLLAMA_CONTEXT_LENGTH":    {"OLLAMA_CONTEXT_LENGTH", ContextLength(), "Context length to use unless otherwise specified (default: 4096)"},
 		"OLLAMA_NEW_ENGINE":        {"OLLAMA_NEW_ENGINE", NewEngine(), "Enable the new Ollama engine"},
+		"OLLAMA_PAGED_KV_CACHE":    {"OLLAMA_PAGED_KV_CACHE", PagedKVCache(), "Share K/V cache blocks between sequences with a common prefix"},
 		"OLLAMA_REMOTES":           {"OLLAMA_REMOTES", Remotes(), "Allowed hosts for remote models (default \"ollama.com\")"},
+		"OLLAMA_TRUSTED_KEYS":      {"OLLAMA_TRUSTED_KEYS", TrustedKeys(), "File listing the public keys trusted to sign models in each namespace"},
+		"HF_ENDPOINT":              {"HF_ENDPOINT", HuggingFaceEndpoint(), "Hugging Face Hub used to pull hf.co models (default \"https://huggingface.co\")"},
 
 		// Informational
 		"HTTP_PROXY":  {"HTTP_PROXY", String("HTTP_PROXY")(), "HTTP proxy"},
@@ -252,6 +252,21 @@ func (kv KV) OllamaEngineRequired() bool {
 	}, kv.Architecture())
 }
 
+// RoPEScalingTypes returns the RoPE scaling types the Ollama engine
+// implements for the model's architecture
+func (kv KV) RoPEScalingTypes() []string {
+	switch kv.Architecture() {
+	case "llama", "qwen2":
+		return []string{"none", "linear", "yarn", "static-ntk"}
+	case "qwen3", "qwen3moe":
+		return []string{"none", "linear", "yarn"}
+	case "gemma2", "gemma3n", "llama4", "mistral3", "mllama":
+		return []string{"none", "linear"}
+	default:
+		return nil
+	}
+}
+
 type valueTypes interface {
 	uint8 | int8 | uint16 | int16 |
 		uint32 | int32 | uint64 | int64 |
@@ -372,7 +387,7 @@ func (t TensorType) BlockSize() uint64 {
 		TensorTypeQ5_1,
 		TensorTypeQ8_0,
 		TensorTypeQ8_1,
-		tensorTypeIQ4_NL,
+		TensorTypeIQ4_NL,
 		4, TensorTypeMXFP4:
 		return 32
 	default:
@@ -416,21 +431,21 @@ func (t TensorType) TypeSize() uint64 {
 		return blockSize/2 + blockSize/4 + blockSize/16 + 2
 	case TensorTypeQ8_K:
 		return 4 + blockSize + 2*blockSize/16
-	case tensorTypeIQ2_XXS:
+	case TensorTypeIQ2_XXS:
 		return 2 + 2*blockSize/8
-	case tensorTypeIQ2_XS:
+	case TensorTypeIQ2_XS:
 		return 2 + 2*blockSize/8 + blockSize/32
-	case tensorTypeIQ3_XXS:
+	case TensorTypeIQ3_XXS:
 		return 2 + blockSize/4 + blockSize/8
 	case tensorTypeIQ1_S:
 		return 2 + blockSize/8 + blockSize/16
-	case tensorTypeIQ4_NL:
+	case TensorTypeIQ4_NL:
 		return 2 + blockSize/2
-	case tensorTypeIQ3_S:
+	case TensorTypeIQ3_S:
 		return 2 + blockSize/4 + blockSize/8 + blockSize/32 + 4
-	case tensorTypeIQ2_S:
+	case TensorTypeIQ2_S:
 		return 2 + blockSize/4 + blockSize/16
-	case tensorTypeIQ4_XS:
+	case TensorTypeIQ4_XS:
 		return 2 + 2 + blockSize/2 + blockSize/64
 	case TensorTypeI8:
 		return 1
@@ -563,7 +578,12 @@ func (f GGML) GraphSize(context, batch uint64, numParallel int, kvCacheType stri
 
 	layers := f.Tensors().GroupLayers()
 
-	bytesPerElement := kvCacheBytesPerElement(kvCacheType)
+	// kvBytes is the size of a single cache entry for one KV head in the given layer
+	cacheType, _ := ParseKVCacheType(kvCacheType)
+	kvBytes := func(layer int) float64 {
+		k, v := cacheType.Layer(layer, int(f.KV().BlockCount()))
+		return float64(embeddingHeadsK)*kvCacheBytesPerElement(k) + float64(embeddingHeadsV)*kvCacheBytesPerElement(v)
+	}
 
 	// Default for models unless special-cased below. These defaults mirror the
 	// cache usage in llama.cpp under the assumption that models without special
@@ -585,7 +605,7 @@ func (f GGML) GraphSize(context, batch uint64, numParallel int, kvCacheType stri
 		if headsL > 0 && headsKVL > 0 {
 			// full attention layer
 			// NOTE: Assumes uniform values for all attn layers
-			kv[i] = uint64(float64(context*(embeddingHeadsK+embeddingHeadsV)*headsKVL) * bytesPerElement)
+			kv[i] = uint64(float64(context*headsKVL) * kvBytes(i))
 			kvSizeAttn += kv[i]
 		} else {
 			// recurrent layer
@@ -700,7 +720,7 @@ func (f GGML) GraphSize(context, batch uint64, numParallel int, kvCacheType stri
 				// Every 6th layer is a global layer, which is the full context size that has already been set. The other
 				// layers are the smaller local (sliding) layers.
 				if (i+1)%gemma3GlobalCacheCount != 0 {
-					kv[i] = uint64(float64(slidingWindow*(embeddingHeadsK+embeddingHeadsV)*headsKV) * bytesPerElement)
+					kv[i] = uint64(float64(slidingWindow*headsKV) * kvBytes(i))
 				}
 			}
 		}
@@ -779,7 +799,7 @@ func (f GGML) GraphSize(context, batch uint64, numParallel int, kvCacheType stri
 	case "gptoss", "gpt-oss":
 		kv = make([]uint64, f.KV().BlockCount())
 		for i := range kv {
-			kv[i] = uint64(float64((embeddingHeadsK+embeddingHeadsV)*headsKV) * bytesPerElement)
+			kv[i] = uint64(float64(headsKV) * kvBytes(i))
 			if i%2 == 0 {
 				kv[i] *= (uint64(numParallel)*4096 + batch)
 			} else {
@@ -866,11 +886,8 @@ func (llm GGML) VisionGraphSize() (weights, graphSize uint64) {
 
 // SupportsKVCacheType checks if the requested cache type is supported
 func (f GGML) SupportsKVCacheType(cacheType string) bool {
-	if cacheType == "" || cacheType == "f16" {
-		return true
-	}
-
-	return slices.Contains([]string{"q8_0", "q4_0"}, cacheType)
+	_, err := ParseKVCacheType(cacheType)
+	return err == nil
 }
 
 // SupportsFlashAttention checks if the model supports flash attention
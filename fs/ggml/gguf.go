@@ -514,6 +514,18 @@ func WriteGGUF(f *os.File, kv KV, ts []*Tensor) error {
 		return fmt.Errorf("architecture not set")
 	}
 
+	return writeGGUFFile(f, arch, kv, ts)
+}
+
+// WriteGGUFKeys writes kv and ts to f like WriteGGUF, except that keys are
+// written exactly as given rather than prefixed with the architecture when
+// they're outside of the known namespaces
+func WriteGGUFKeys(f *os.File, kv KV, ts []*Tensor) error {
+	return writeGGUFFile(f, "", kv, ts)
+}
+
+// writeGGUFFile writes a GGUF file, prefixing keys with arch unless it's empty
+func writeGGUFFile(f *os.File, arch string, kv KV, ts []*Tensor) error {
 	if err := binary.Write(f, binary.LittleEndian, []byte("GGUF")); err != nil {
 		return err
 	}
@@ -580,10 +592,13 @@ func WriteGGUF(f *os.File, kv KV, ts []*Tensor) error {
 }
 
 func ggufWriteKV(ws io.WriteSeeker, arch, k string, v any) error {
-	if !strings.HasPrefix(k, arch+".") &&
+	if arch != "" &&
+		!strings.HasPrefix(k, arch+".") &&
 		!strings.HasPrefix(k, "general.") &&
 		!strings.HasPrefix(k, "adapter.") &&
-		!strings.HasPrefix(k, "tokenizer.") {
+		!strings.HasPrefix(k, "tokenizer.") &&
+		!strings.HasPrefix(k, "split.") &&
+		!strings.HasPrefix(k, "quantize.") {
 		k = arch + "." + k
 	}
 
@@ -598,16 +613,44 @@ func ggufWriteKV(ws io.WriteSeeker, arch, k string, v any) error {
 
 	var err error
 	switch v := v.(type) {
+	case uint8:
+		err = writeGGUF(ws, ggufTypeUint8, v)
+	case int8:
+		err = writeGGUF(ws, ggufTypeInt8, v)
+	case uint16:
+		err = writeGGUF(ws, ggufTypeUint16, v)
+	case int16:
+		err = writeGGUF(ws, ggufTypeInt16, v)
 	case uint32, FileType:
 		err = writeGGUF(ws, ggufTypeUint32, v)
+	case int32:
+		err = writeGGUF(ws, ggufTypeInt32, v)
 	case uint64:
 		err = writeGGUF(ws, ggufTypeUint64, v)
+	case int64:
+		err = writeGGUF(ws, ggufTypeInt64, v)
 	case float32:
 		err = writeGGUF(ws, ggufTypeFloat32, v)
+	case float64:
+		err = writeGGUF(ws, ggufTypeFloat64, v)
 	case bool:
 		err = writeGGUF(ws, ggufTypeBool, v)
 	case string:
 		err = writeGGUFString(ws, v)
+	case []uint8:
+		err = writeGGUFArray(ws, ggufTypeUint8, v)
+	case []int8:
+		err = writeGGUFArray(ws, ggufTypeInt8, v)
+	case []uint16:
+		err = writeGGUFArray(ws, ggufTypeUint16, v)
+	case []int16:
+		err = writeGGUFArray(ws, ggufTypeInt16, v)
+	case []uint64:
+		err = writeGGUFArray(ws, ggufTypeUint64, v)
+	case []int64:
+		err = writeGGUFArray(ws, ggufTypeInt64, v)
+	case []float64:
+		err = writeGGUFArray(ws, ggufTypeFloat64, v)
 	case []int32:
 		err = writeGGUFArray(ws, ggufTypeInt32, v)
 	case *array[int32]:
@@ -8,6 +8,8 @@ import (
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
+
+	fsgguf "github.com/ollama/ollama/fs/gguf"
 )
 
 func TestWriteGGUF(t *testing.T) {
@@ -91,3 +93,59 @@ func TestWriteGGUF(t *testing.T) {
 		})
 	}
 }
+
+func TestWriteGGUFKeyValues(t *testing.T) {
+	kv := KV{
+		"general.architecture":  "test",
+		"test.uint8":            uint8(1),
+		"test.int8":             int8(-1),
+		"test.uint16":           uint16(2),
+		"test.int16":            int16(-2),
+		"test.uint32":           uint32(3),
+		"test.int32":            int32(-3),
+		"test.uint64":           uint64(4),
+		"test.int64":            int64(-4),
+		"test.float32":          float32(0.5),
+		"test.float64":          float64(0.25),
+		"test.bool":             true,
+		"test.uint8s":           []uint8{1, 2},
+		"test.int8s":            []int8{-1, -2},
+		"test.uint16s":          []uint16{1, 2},
+		"test.int16s":           []int16{-1, -2},
+		"test.uint32s":          []uint32{1, 2},
+		"test.int32s":           []int32{-1, -2},
+		"test.uint64s":          []uint64{1, 2},
+		"test.int64s":           []int64{-1, -2},
+		"test.float32s":         []float32{0.5, 0.25},
+		"test.float64s":         []float64{0.5, 0.25},
+		"test.bools":            []bool{true, false},
+		"test.strings":          []string{"a", "b"},
+		"split.count":           uint16(1),
+		"quantize.imatrix.file": "imatrix.gguf",
+	}
+
+	w, err := os.CreateTemp(t.TempDir(), "*.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	if err := WriteGGUF(w, kv, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := fsgguf.Open(w.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	got := make(KV)
+	for _, kv := range f.KeyValues() {
+		got[kv.Key] = kv.Any()
+	}
+
+	if diff := cmp.Diff(kv, got); diff != "" {
+		t.Errorf("Mismatch (-want +got):\n%s", diff)
+	}
+}
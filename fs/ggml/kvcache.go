@@ -0,0 +1,124 @@
+package ggml
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// KVCacheType describes the data types used to store keys and values in each
+// layer of the K/V cache. It is written as a comma separated list where each
+// element is one of:
+//
+//	<type>      type for both keys and values, e.g. q8_0
+//	k=<type>    type for keys
+//	v=<type>    type for values
+//	first=<n>   number of layers at the start of the model kept as f16
+//	last=<n>    number of layers at the end of the model kept as f16
+//
+// For example, "q4_0,k=q8_0,first=2,last=2" stores keys as q8_0 and values
+// as q4_0, except for the first two and last two layers which remain f16.
+type KVCacheType struct {
+	// K and V are the types of keys and values in quantized layers
+	K, V string
+
+	// First and Last are the number of layers at the start and end
+	// of the model that are kept as f16
+	First, Last int
+}
+
+// ParseKVCacheType parses a K/V cache type specification. An empty string
+// is the default f16 cache.
+func ParseKVCacheType(s string) (KVCacheType, error) {
+	t := KVCacheType{K: "f16", V: "f16"}
+
+	s = strings.TrimSpace(strings.ToLower(s))
+	if s == "" {
+		return t, nil
+	}
+
+	for part := range strings.SplitSeq(s, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			key, value = "", key
+		}
+
+		key = strings.TrimSpace(key)
+		value = strings.TrimSpace(value)
+
+		switch key {
+		case "", "k", "v":
+			if !slices.Contains(kvCacheTypes, value) {
+				return KVCacheType{}, fmt.Errorf("unknown kv cache type %q", value)
+			}
+
+			if key != "v" {
+				t.K = value
+			}
+			if key != "k" {
+				t.V = value
+			}
+		case "first", "last":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 {
+				return KVCacheType{}, fmt.Errorf("invalid number of %s layers %q", key, value)
+			}
+
+			if key == "first" {
+				t.First = n
+			} else {
+				t.Last = n
+			}
+		default:
+			return KVCacheType{}, fmt.Errorf("unknown kv cache option %q", key)
+		}
+	}
+
+	return t, nil
+}
+
+var kvCacheTypes = []string{"f16", "q8_0", "q4_0"}
+
+// String returns the canonical form of the specification, which is empty
+// for the default f16 cache.
+func (t KVCacheType) String() string {
+	var parts []string
+	switch {
+	case t.K == t.V && t.K != "f16":
+		parts = append(parts, t.K)
+	case t.K != t.V:
+		parts = append(parts, "k="+t.K, "v="+t.V)
+	}
+
+	if t.Quantized() {
+		if t.First > 0 {
+			parts = append(parts, "first="+strconv.Itoa(t.First))
+		}
+		if t.Last > 0 {
+			parts = append(parts, "last="+strconv.Itoa(t.Last))
+		}
+	}
+
+	return strings.Join(parts, ",")
+}
+
+// Quantized reports whether any layer uses a type other than f16
+func (t KVCacheType) Quantized() bool {
+	return t.K != "f16" || t.V != "f16"
+}
+
+// PerLayer reports whether the types vary between layers
+func (t KVCacheType) PerLayer() bool {
+	return t.Quantized() && (t.First > 0 || t.Last > 0)
+}
+
+// Layer returns the types of keys and values for the given layer in a model
+// with numLayers layers.
+func (t KVCacheType) Layer(layer, numLayers int) (k, v string) {
+	if layer < t.First || layer >= numLayers-t.Last {
+		return "f16", "f16"
+	}
+
+	return t.K, t.V
+}
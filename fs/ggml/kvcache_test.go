@@ -0,0 +1,86 @@
+package ggml
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseKVCacheType(t *testing.T) {
+	cases := []struct {
+		input string
+		want  KVCacheType
+		str   string
+		err   bool
+	}{
+		{input: "", want: KVCacheType{K: "f16", V: "f16"}},
+		{input: "f16", want: KVCacheType{K: "f16", V: "f16"}},
+		{input: "Q8_0", want: KVCacheType{K: "q8_0", V: "q8_0"}, str: "q8_0"},
+		{input: "q4_0,k=q8_0", want: KVCacheType{K: "q8_0", V: "q4_0"}, str: "k=q8_0,v=q4_0"},
+		{input: "v=q4_0", want: KVCacheType{K: "f16", V: "q4_0"}, str: "k=f16,v=q4_0"},
+		{input: "q8_0, first=2, last=1", want: KVCacheType{K: "q8_0", V: "q8_0", First: 2, Last: 1}, str: "q8_0,first=2,last=1"},
+		{input: "first=2", want: KVCacheType{K: "f16", V: "f16", First: 2}},
+		{input: "q2_k", err: true},
+		{input: "k=", err: true},
+		{input: "q8_0,first=-1", err: true},
+		{input: "q8_0,last=x", err: true},
+		{input: "q8_0,middle=1", err: true},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := ParseKVCacheType(tt.input)
+			if tt.err {
+				if err == nil {
+					t.Fatalf("expected error, got %+v", got)
+				}
+				return
+			} else if err != nil {
+				t.Fatal(err)
+			}
+
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("unexpected type (-want +got):\n%s", diff)
+			}
+
+			if got.String() != tt.str {
+				t.Errorf("unexpected string: got=%q want=%q", got.String(), tt.str)
+			}
+
+			roundtrip, err := ParseKVCacheType(got.String())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if roundtrip.String() != got.String() {
+				t.Errorf("string does not round trip: got=%q want=%q", roundtrip.String(), got.String())
+			}
+		})
+	}
+}
+
+func TestKVCacheTypeLayer(t *testing.T) {
+	cacheType, err := ParseKVCacheType("q4_0,k=q8_0,first=1,last=2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got [][2]string
+	for layer := range 6 {
+		k, v := cacheType.Layer(layer, 6)
+		got = append(got, [2]string{k, v})
+	}
+
+	want := [][2]string{
+		{"f16", "f16"},
+		{"q8_0", "q4_0"},
+		{"q8_0", "q4_0"},
+		{"q8_0", "q4_0"},
+		{"f16", "f16"},
+		{"f16", "f16"},
+	}
+
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("unexpected layer types (-want +got):\n%s", diff)
+	}
+}
@@ -39,6 +39,11 @@ func values[T any](v Value, kinds ...reflect.Kind) (ts []T) {
 	return
 }
 
+// Any returns the underlying value of Value.
+func (v Value) Any() any {
+	return v.value
+}
+
 // Int returns Value as a signed integer. If it is not a signed integer, it returns 0.
 func (v Value) Int() int64 {
 	return value[int64](v, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64)
@@ -268,6 +268,9 @@ type HarmonyMessageHandler struct {
 	FunctionNameMap *FunctionNameMap
 	toolAccumulator *HarmonyToolCallAccumulator
 	convertedTools  map[string]struct{}
+	deltas          []api.ToolCallDelta
+	// set when a prefilled final message is being continued
+	answering bool
 }
 
 // NewHarmonyMessageHandler creates a new message handler
@@ -401,6 +404,7 @@ func (h *HarmonyMessageHandler) Init(tools []api.Tool, lastMessage *api.Message)
 	}
 
 	// Handle prefill for chat mode
+	h.answering = lastMessage != nil && lastMessage.Role == "assistant" && lastMessage.Content != ""
 	if lastMessage != nil {
 		h.HarmonyParser.AddImplicitStartOrPrefill(lastMessage)
 	} else {
@@ -428,8 +432,10 @@ func (h *HarmonyMessageHandler) Init(tools []api.Tool, lastMessage *api.Message)
 
 // Add implements the Parser interface - processes streamed content and extracts content, thinking, and tool calls
 func (h *HarmonyMessageHandler) Add(s string, done bool) (content string, thinking string, calls []api.ToolCall, err error) {
+	h.deltas = nil
 	content, thinking, toolContent := h.AddContent(s, h.toolAccumulator)
 	if toolContent != "" {
+		h.streamToolContent(toolContent)
 		h.toolAccumulator.Add(toolContent)
 	}
 
@@ -451,6 +457,52 @@ func (h *HarmonyMessageHandler) Add(s string, done bool) (content string, thinki
 	return content, thinking, calls, nil
 }
 
+// streamToolContent emits the raw arguments of a call to one of the
+// user-specified functions as they are generated. The first delta of a call
+// carries its name
+func (h *HarmonyMessageHandler) streamToolContent(toolContent string) {
+	toolName := h.toolAccumulator.currentToolName
+	if toolName == nil {
+		return
+	}
+
+	converted, ok := strings.CutPrefix(*toolName, "functions.")
+	if !ok {
+		return
+	}
+
+	name := h.FunctionNameMap.OriginalFromConverted(converted)
+	if _, ok := h.convertedTools[name]; !ok {
+		return
+	}
+
+	delta := api.ToolCallDelta{Arguments: toolContent}
+	if h.toolAccumulator.Content() == "" {
+		delta.Name = name
+	}
+	h.deltas = append(h.deltas, delta)
+}
+
+// ToolCallPrefix returns the header of a message calling the function name,
+// as returned by Init, whose arguments follow as JSON
+func (h *HarmonyMessageHandler) ToolCallPrefix(name string) string {
+	return "<|channel|>commentary to=functions." + name + " <|constrain|>json<|message|>"
+}
+
+// AnswerStart returns the header of the final message, which has the answer
+// after the model's analysis
+func (h *HarmonyMessageHandler) AnswerStart() string {
+	if h.answering {
+		return ""
+	}
+	return "<|channel|>final<|message|>"
+}
+
+// ToolCallDeltas returns the tool call deltas emitted by the last call to Add
+func (h *HarmonyMessageHandler) ToolCallDeltas() []api.ToolCallDelta {
+	return h.deltas
+}
+
 // HasToolSupport implements the Parser interface
 func (h *HarmonyMessageHandler) HasToolSupport() bool {
 	return true
@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"reflect"
 	"testing"
+
+	"github.com/ollama/ollama/api"
 )
 
 func TestHeaderParsing(t *testing.T) {
@@ -536,3 +538,40 @@ func TestFunctionConvertAndAdd(t *testing.T) {
 		})
 	}
 }
+
+func TestHarmonyMessageHandlerToolCallDeltas(t *testing.T) {
+	h := NewHarmonyMessageHandler()
+	h.Init([]api.Tool{{Type: "function", Function: api.ToolFunction{Name: "get weather"}}}, nil)
+
+	chunks := []string{
+		"<|channel|>analysis<|message|>Need weather.<|end|>",
+		"<|start|>assistant<|channel|>commentary to=functions.get_weather <|constrain|>json<|message|>",
+		`{"city":`,
+		` "Paris"}`,
+		"",
+	}
+
+	var deltas []api.ToolCallDelta
+	var calls []api.ToolCall
+	for i, chunk := range chunks {
+		_, _, c, err := h.Add(chunk, i == len(chunks)-1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		deltas = append(deltas, h.ToolCallDeltas()...)
+		calls = append(calls, c...)
+	}
+
+	wantDeltas := []api.ToolCallDelta{
+		{Name: "get weather", Arguments: `{"city":`},
+		{Arguments: ` "Paris"}`},
+	}
+	if !reflect.DeepEqual(deltas, wantDeltas) {
+		t.Errorf("deltas = %#v, want %#v", deltas, wantDeltas)
+	}
+
+	wantCalls := []api.ToolCall{{Function: api.ToolCallFunction{Name: "get weather", Arguments: api.ToolCallFunctionArguments{"city": "Paris"}}}}
+	if !reflect.DeepEqual(calls, wantCalls) {
+		t.Errorf("calls = %#v, want %#v", calls, wantCalls)
+	}
+}
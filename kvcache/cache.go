@@ -45,11 +45,11 @@ type Cache interface {
 
 	// Init sets up runtime parameters.
 	// backend: Used to allocate cache data storage and execute management operations (such as defrag)
-	// dtype: The data type for storing cache entries
+	// dtype: The data types for storing cache entries in each layer
 	// maxSequences: The maximum number of sequences stored in the cache - across all batches
 	// capacity: The number of cache entries to store, per sequence
 	// maxBatch: The maximum number of tokens that can occur in a single batch
-	Init(backend ml.Backend, dtype ml.DType, maxSequences, capacity, maxBatch int)
+	Init(backend ml.Backend, dtype DType, maxSequences, capacity, maxBatch int)
 
 	// Close closes the cache and frees resources associated with it
 	Close()
@@ -75,3 +75,13 @@ type Cache interface {
 	// removed by calling Remove(seq, 0, math.MaxInt32)
 	Remove(seq int, beginIndex, endIndex int32) error
 }
+
+// DType returns the data types used to store keys and values in a layer
+type DType func(layer int) (k, v ml.DType)
+
+// UniformDType stores keys and values in all layers using the same data type
+func UniformDType(dtype ml.DType) DType {
+	return func(int) (ml.DType, ml.DType) {
+		return dtype, dtype
+	}
+}
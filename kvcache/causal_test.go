@@ -25,7 +25,7 @@ func TestStore(t *testing.T) {
 	cache := NewCausalCache(nil)
 	defer cache.Close()
 
-	cache.Init(backend, ml.DTypeF16, 1, 16, 16)
+	cache.Init(backend, UniformDType(ml.DTypeF16), 1, 16, 16)
 
 	tests := []testCase{
 		{
@@ -58,7 +58,7 @@ func TestSWA(t *testing.T) {
 	cache := NewSWACache(1, nil)
 	defer cache.Close()
 
-	cache.Init(backend, ml.DTypeF16, 1, 16, 16)
+	cache.Init(backend, UniformDType(ml.DTypeF16), 1, 16, 16)
 
 	x := float32(math.Inf(-1))
 
@@ -101,7 +101,7 @@ func TestSWASeparateBatches(t *testing.T) {
 	cache := NewSWACache(1, nil)
 	defer cache.Close()
 
-	cache.Init(backend, ml.DTypeF16, 2, 16, 2)
+	cache.Init(backend, UniformDType(ml.DTypeF16), 2, 16, 2)
 
 	x := float32(math.Inf(-1))
 
@@ -181,7 +181,7 @@ func TestSWAMem(t *testing.T) {
 	cache := NewSWAMemCache(1, 3, nil)
 	defer cache.Close()
 
-	cache.Init(backend, ml.DTypeF16, 1, 16, 16)
+	cache.Init(backend, UniformDType(ml.DTypeF16), 1, 16, 16)
 
 	x := float32(math.Inf(-1))
 
@@ -224,7 +224,7 @@ func TestChunkedAttention(t *testing.T) {
 	defer cache.Close()
 
 	var b testBackend
-	cache.Init(&b, ml.DTypeF16, 1, 16, 16)
+	cache.Init(&b, UniformDType(ml.DTypeF16), 1, 16, 16)
 
 	x := float32(math.Inf(-1))
 
@@ -282,7 +282,7 @@ func TestSequences(t *testing.T) {
 	cache := NewCausalCache(nil)
 	defer cache.Close()
 
-	cache.Init(backend, ml.DTypeF16, 1, 16, 16)
+	cache.Init(backend, UniformDType(ml.DTypeF16), 1, 16, 16)
 
 	tests := []testCase{
 		{
@@ -317,7 +317,7 @@ func TestRemove(t *testing.T) {
 	})
 	defer cache.Close()
 
-	cache.Init(backend, ml.DTypeF16, 1, 16, 16)
+	cache.Init(backend, UniformDType(ml.DTypeF16), 1, 16, 16)
 
 	tests := []testCase{
 		{
@@ -382,7 +382,7 @@ func TestDefrag(t *testing.T) {
 	})
 	defer cache.Close()
 
-	cache.Init(backend, ml.DTypeF16, 1, 16, 16)
+	cache.Init(backend, UniformDType(ml.DTypeF16), 1, 16, 16)
 
 	tests := []testCase{
 		{
@@ -430,7 +430,7 @@ func TestCopy(t *testing.T) {
 	cache := NewCausalCache(func(ctx ml.Context, layer int, key, shift ml.Tensor) (ml.Tensor, error) { return key, nil })
 	defer cache.Close()
 
-	cache.Init(backend, ml.DTypeF16, 1, 16, 16)
+	cache.Init(backend, UniformDType(ml.DTypeF16), 1, 16, 16)
 
 	tests := []testCase{
 		{
@@ -505,7 +505,7 @@ func TestCanResume(t *testing.T) {
 	cache := NewSWACache(windowSize, nil)
 	defer cache.Close()
 
-	cache.Init(backend, ml.DTypeF16, 1, 16, 16)
+	cache.Init(backend, UniformDType(ml.DTypeF16), 1, 16, 16)
 
 	context := backend.NewContext()
 	defer context.Close()
@@ -580,7 +580,7 @@ func TestCanResumeSWAMem(t *testing.T) {
 	cache := NewSWAMemCache(windowSize, memSize, nil)
 	defer cache.Close()
 
-	cache.Init(backend, ml.DTypeF16, 1, 16, 16)
+	cache.Init(backend, UniformDType(ml.DTypeF16), 1, 16, 16)
 
 	context := backend.NewContext()
 	defer context.Close()
@@ -0,0 +1,605 @@
+package kvcache
+
+import (
+	"fmt"
+	"math"
+	"slices"
+
+	"github.com/ollama/ollama/envconfig"
+	"github.com/ollama/ollama/ml"
+	"github.com/ollama/ollama/model/input"
+)
+
+// DefaultBlockSize is the number of cells in a block of a paged cache. It
+// divides the cache padding required by the backends so that blocks never
+// straddle a padding boundary.
+const DefaultBlockSize = 32
+
+// Paged cache stores K and V tensors in fixed-size blocks of cells. Each
+// sequence has a table of the blocks that hold its history, in order, so
+// blocks can be allocated anywhere in the cache and freed individually
+// without needing to defragment. Blocks holding a common prefix are shared
+// between sequences by CopyPrefix and are copied the first time a sequence
+// writes to a shared block (copy-on-write).
+//
+// The cache only supports full causal attention. Removing tokens from the
+// middle of a sequence is not supported, which causes the caller to
+// reprocess the sequence instead.
+//
+// The tensors are of shape embed dim, kv heads, history size
+// The mask is of shape history size, batch size
+type Paged struct {
+	DType DType
+
+	blockSize int
+
+	// config controls mostly backend-specific optimizations
+	config *ml.CacheConfig
+
+	// ** current forward pass **
+
+	// the active layer for Get and Put
+	curLayer int
+
+	// size of the current batch
+	curBatchSize int
+
+	// locations in the cache where each entry in the batch is stored
+	curLocs []int
+
+	// mask of the cache as used by this batch
+	curMask ml.Tensor
+
+	// locations in the cache that are needed for this batch
+	curCellRange cellRange
+
+	// curSequences is the sequences corresponding to this pass's entries in the cache
+	curSequences []int
+
+	// curPositions is the positions corresponding to this pass's entries in the cache
+	curPositions []int32
+
+	// ** cache metadata **
+
+	// for each possible location in the cache, stores the position and set of sequences
+	// that reference the data there
+	cells []cacheCell
+
+	// number of block tables that reference each block
+	refs []int
+
+	// blocks that are not referenced by any sequence
+	free []int
+
+	// maps from sequence to its block table
+	tables map[int]*blockTable
+
+	// ** cache data storage **
+
+	backend      ml.Backend
+	ctxs         map[int]ml.Context
+	keys, values map[int]ml.Tensor
+}
+
+// blockTable is the list of blocks holding the history of a sequence. The
+// i-th entry in the sequence is stored in cell i%blockSize of blocks[i/blockSize].
+type blockTable struct {
+	blocks []int
+	length int
+}
+
+// NewFullAttentionCache returns a cache for models where every layer attends
+// to the entire history. This is a paged cache if OLLAMA_PAGED_KV_CACHE is set
+// and a causal cache otherwise.
+func NewFullAttentionCache(shift shiftFn) Cache {
+	if envconfig.PagedKVCache() {
+		return NewPagedCache(DefaultBlockSize)
+	}
+
+	return NewCausalCache(shift)
+}
+
+func NewPagedCache(blockSize int) *Paged {
+	if blockSize <= 0 {
+		blockSize = DefaultBlockSize
+	}
+
+	return &Paged{
+		blockSize: blockSize,
+		ctxs:      make(map[int]ml.Context),
+		keys:      make(map[int]ml.Tensor),
+		values:    make(map[int]ml.Tensor),
+	}
+}
+
+func (c *Paged) Init(backend ml.Backend, dtype DType, maxSequences, capacity, maxBatch int) {
+	if c.config == nil {
+		var config ml.CacheConfig
+		if cc, ok := backend.(ml.BackendCacheConfig); ok {
+			config = cc.CacheConfig()
+		}
+		c.config = &config
+	}
+
+	if c.config.CachePadding == 0 {
+		c.config.CachePadding = 1
+	}
+
+	if c.config.MaskBatchPadding == 0 {
+		c.config.MaskBatchPadding = 1
+	}
+
+	if c.config.MaskDType == ml.DTypeOther {
+		c.config.MaskDType = ml.DTypeF32
+	}
+
+	// Each sequence may leave up to one block partially filled, so round
+	// capacity up to whole blocks. The total is also padded for the
+	// backend, which may add more blocks.
+	cacheSize := maxSequences * roundUp(capacity, c.blockSize)
+	for cacheSize%c.config.CachePadding != 0 {
+		cacheSize += c.blockSize
+	}
+
+	numBlocks := cacheSize / c.blockSize
+	c.cells = make([]cacheCell, cacheSize)
+	c.refs = make([]int, numBlocks)
+	c.free = make([]int, numBlocks)
+	for i := range c.free {
+		// allocated from the end, so lower blocks are used first
+		c.free[i] = numBlocks - i - 1
+	}
+
+	c.DType = dtype
+	c.tables = make(map[int]*blockTable)
+	c.backend = backend
+}
+
+func (c *Paged) SetConfig(config ml.CacheConfig) {
+	if c.config != nil {
+		panic("config cannot be changed after being previously set, either by the model or backend")
+	}
+
+	c.config = &config
+}
+
+func (c *Paged) Close() {
+	for _, ctx := range c.ctxs {
+		ctx.Close()
+	}
+}
+
+func (c *Paged) StartForward(ctx ml.Context, batch input.Batch, reserve bool) error {
+	c.curBatchSize = len(batch.Positions)
+	c.curSequences = batch.Sequences
+	c.curPositions = batch.Positions
+	c.curLocs = make([]int, c.curBatchSize)
+
+	if !reserve {
+		if err := c.checkSpace(); err != nil {
+			return err
+		}
+
+		for i, pos := range batch.Positions {
+			seq := batch.Sequences[i]
+
+			table, ok := c.tables[seq]
+			if !ok {
+				table = &blockTable{}
+				c.tables[seq] = table
+			}
+
+			offset := table.length % c.blockSize
+			if offset == 0 {
+				table.blocks = append(table.blocks, c.allocBlock())
+			} else if last := len(table.blocks) - 1; c.refs[table.blocks[last]] > 1 {
+				c.copyOnWrite(ctx, seq, last)
+			}
+
+			loc := table.blocks[len(table.blocks)-1]*c.blockSize + offset
+			c.cells[loc] = cacheCell{pos: pos, sequences: []int{seq}}
+			c.curLocs[i] = loc
+			table.length++
+		}
+
+		c.curCellRange = newRange()
+		for _, seq := range c.curSequences {
+			table := c.tables[seq]
+			for i, block := range table.blocks {
+				c.curCellRange.min = min(c.curCellRange.min, block*c.blockSize)
+				c.curCellRange.max = max(c.curCellRange.max, block*c.blockSize+min(c.blockSize, table.length-i*c.blockSize)-1)
+			}
+		}
+	} else {
+		// If we are reserving memory, don't update any of the cache metadata but set the size
+		// to the worst case.
+		for i := range c.curLocs {
+			c.curLocs[i] = i
+		}
+		c.curCellRange.min = 0
+		c.curCellRange.max = len(c.cells) - 1
+	}
+
+	c.curMask = c.buildMask(ctx)
+
+	return nil
+}
+
+// checkSpace returns an error if there are not enough free blocks to store
+// the current batch, including copies of any shared blocks that are written
+func (c *Paged) checkSpace() error {
+	counts := make(map[int]int)
+	for _, seq := range c.curSequences {
+		counts[seq]++
+	}
+
+	var needed int
+	for seq, count := range counts {
+		var length int
+		if table, ok := c.tables[seq]; ok {
+			length = table.length
+
+			if length%c.blockSize != 0 && c.refs[table.blocks[len(table.blocks)-1]] > 1 {
+				needed++
+			}
+		}
+
+		needed += roundUp(length+count, c.blockSize)/c.blockSize - roundUp(length, c.blockSize)/c.blockSize
+	}
+
+	if needed > len(c.free) {
+		return fmt.Errorf("%w (cache: %v batch: %v free blocks: %v needed: %v)", ErrKvCacheFull, len(c.cells), c.curBatchSize, len(c.free), needed)
+	}
+
+	return nil
+}
+
+func (c *Paged) allocBlock() int {
+	block := c.free[len(c.free)-1]
+	c.free = c.free[:len(c.free)-1]
+	c.refs[block] = 1
+	return block
+}
+
+func (c *Paged) releaseBlock(block int) {
+	c.refs[block]--
+	if c.refs[block] == 0 {
+		for i := block * c.blockSize; i < (block+1)*c.blockSize; i++ {
+			c.cells[i] = cacheCell{}
+		}
+
+		// keep the free list sorted so that lower blocks are used first
+		i, _ := slices.BinarySearchFunc(c.free, block, func(a, b int) int { return b - a })
+		c.free = slices.Insert(c.free, i, block)
+	}
+}
+
+// copyOnWrite gives seq its own copy of the block at index i of its block table
+func (c *Paged) copyOnWrite(ctx ml.Context, seq int, i int) {
+	table := c.tables[seq]
+	src := table.blocks[i]
+	dst := c.allocBlock()
+
+	length := min(c.blockSize, table.length-i*c.blockSize)
+	for j := range length {
+		srcCell := &c.cells[src*c.blockSize+j]
+		srcCell.sequences = slices.DeleteFunc(srcCell.sequences, func(s int) bool { return s == seq })
+		c.cells[dst*c.blockSize+j] = cacheCell{pos: srcCell.pos, sequences: []int{seq}}
+	}
+
+	c.copyCells(ctx, src*c.blockSize, dst*c.blockSize, length)
+
+	table.blocks[i] = dst
+	c.releaseBlock(src)
+}
+
+func (c *Paged) copyCells(ctx ml.Context, src, dst, length int) {
+	for i, key := range c.keys {
+		if key == nil {
+			continue
+		}
+
+		kHeadDim := key.Dim(0)
+		numKVHeads := key.Dim(1)
+		rowSize := key.Stride(2)
+
+		kSrcView := key.View(ctx, rowSize*src, kHeadDim*numKVHeads*length)
+		kDstView := key.View(ctx, rowSize*dst, kHeadDim*numKVHeads*length)
+
+		value := c.values[i]
+		var vSrcView, vDstView ml.Tensor
+		if c.config.PermutedV {
+			vHeadDim := value.Dim(1)
+			elemSize := value.Stride(0)
+
+			vSrcView = value.View(ctx, elemSize*src, length, len(c.cells)*elemSize, vHeadDim*numKVHeads)
+			vDstView = value.View(ctx, elemSize*dst, length, len(c.cells)*elemSize, vHeadDim*numKVHeads)
+		} else {
+			vHeadDim := value.Dim(0)
+			rowSize := value.Stride(2)
+
+			vSrcView = value.View(ctx, rowSize*src, vHeadDim*numKVHeads*length)
+			vDstView = value.View(ctx, rowSize*dst, vHeadDim*numKVHeads*length)
+		}
+
+		ctx.Forward(
+			kSrcView.Copy(ctx, kDstView),
+			vSrcView.Copy(ctx, vDstView),
+		)
+	}
+}
+
+// Builds a mask of history x batch indicating whether for each token in the batch the
+// token in the history should apply. This is based on both the sequence and causality (the
+// position of the history is not ahead of the token in the batch).
+func (c *Paged) buildMask(ctx ml.Context) ml.Tensor {
+	// Align and pad the two dimensions as required by the backend
+	batchSize := roundUp(c.curBatchSize, c.config.MaskBatchPadding)
+
+	c.curCellRange.min = roundDown(c.curCellRange.min, c.config.CachePadding)
+	c.curCellRange.max = roundUp(c.curCellRange.max+1, c.config.CachePadding) - 1
+
+	length := c.curCellRange.max - c.curCellRange.min + 1
+
+	mask := make([]float32, batchSize*length)
+
+	for i := range c.curBatchSize {
+		for j := c.curCellRange.min; j <= c.curCellRange.max; j++ {
+			if !slices.Contains(c.cells[j].sequences, c.curSequences[i]) || c.cells[j].pos > c.curPositions[i] {
+				mask[i*length+(j-c.curCellRange.min)] = float32(math.Inf(-1))
+			}
+		}
+	}
+
+	// Mask out any padding tokens we added. For padding that we added to the cache history, this
+	// has already been masked out because the sequence doesn't match.
+	for i := c.curBatchSize * length; i < len(mask); i++ {
+		mask[i] = float32(math.Inf(-1))
+	}
+
+	maskTensor := ctx.Input().FromFloats(mask, length, batchSize)
+
+	if c.config.MaskDType != ml.DTypeF32 {
+		maskTensor = maskTensor.Cast(ctx, c.config.MaskDType)
+	}
+
+	return maskTensor
+}
+
+func (c *Paged) SetLayer(layer int) {
+	c.curLayer = layer
+}
+
+func (c *Paged) Get(ctx ml.Context) (ml.Tensor, ml.Tensor, ml.Tensor) {
+	key := c.keys[c.curLayer]
+	value := c.values[c.curLayer]
+
+	kHeadDim := key.Dim(0)
+	numKVHeads := key.Dim(1)
+	rowSize := key.Stride(2)
+	cachedSize := c.curMask.Dim(0)
+
+	key = key.View(ctx, rowSize*c.curCellRange.min,
+		kHeadDim, key.Stride(1),
+		numKVHeads, key.Stride(2),
+		cachedSize,
+	)
+
+	if c.config.PermutedV {
+		vHeadDim := value.Dim(1)
+		elemSize := value.Stride(0)
+
+		value = value.View(ctx, elemSize*c.curCellRange.min,
+			cachedSize, value.Stride(1),
+			vHeadDim, value.Stride(2),
+			numKVHeads,
+		)
+	} else {
+		vHeadDim := value.Dim(0)
+		rowSize := value.Stride(2)
+
+		value = value.View(ctx, rowSize*c.curCellRange.min,
+			vHeadDim, value.Stride(1),
+			numKVHeads, value.Stride(2),
+			cachedSize,
+		)
+	}
+
+	return key, value, c.curMask
+}
+
+func (c *Paged) Put(ctx ml.Context, key, value ml.Tensor) {
+	kHeadDim := key.Dim(0)
+	vHeadDim := value.Dim(0)
+	numKVHeads := key.Dim(1)
+	batchSize := key.Dim(2)
+
+	if c.curBatchSize != batchSize {
+		panic(fmt.Errorf("inconsistent batch sizes (layer: %v, batch size: %v layer batch size: %v)", c.curLayer, c.curBatchSize, batchSize))
+	}
+
+	if _, ok := c.ctxs[c.curLayer]; !ok {
+		c.ctxs[c.curLayer] = c.backend.NewContextSize(2).Layer(c.curLayer)
+	}
+
+	kDType, vDType := c.DType(c.curLayer)
+
+	if _, ok := c.keys[c.curLayer]; !ok {
+		c.keys[c.curLayer] = c.ctxs[c.curLayer].Zeros(kDType, kHeadDim, numKVHeads, len(c.cells))
+	}
+
+	if _, ok := c.values[c.curLayer]; !ok {
+		if c.config.PermutedV {
+			c.values[c.curLayer] = c.ctxs[c.curLayer].Zeros(vDType, len(c.cells), vHeadDim, numKVHeads)
+		} else {
+			c.values[c.curLayer] = c.ctxs[c.curLayer].Zeros(vDType, vHeadDim, numKVHeads, len(c.cells))
+		}
+	}
+
+	if c.config.PermutedV {
+		value = value.Permute(ctx, 1, 2, 0, 3)
+	}
+
+	// Entries in the batch are stored in runs of consecutive cells, which are
+	// usually long as blocks are allocated in order
+	for start := 0; start < batchSize; {
+		end := start + 1
+		for end < batchSize && c.curLocs[end] == c.curLocs[end-1]+1 {
+			end++
+		}
+
+		c.putRun(ctx, key, value, start, end)
+		start = end
+	}
+}
+
+// putRun stores the entries [start, end) of the batch in consecutive cells
+func (c *Paged) putRun(ctx ml.Context, key, value ml.Tensor, start, end int) {
+	length := end - start
+	loc := c.curLocs[start]
+
+	kHeadDim := key.Dim(0)
+	numKVHeads := key.Dim(1)
+
+	if length != key.Dim(2) {
+		key = key.View(ctx, key.Stride(2)*start,
+			kHeadDim, key.Stride(1),
+			numKVHeads, key.Stride(2),
+			length,
+		)
+	}
+
+	rowSize := c.keys[c.curLayer].Stride(2)
+	ctx.Forward(key.Copy(ctx, c.keys[c.curLayer].View(ctx, rowSize*loc, kHeadDim*numKVHeads*length)))
+
+	if c.config.PermutedV {
+		vHeadDim := value.Dim(1)
+		if length != value.Dim(0) {
+			value = value.View(ctx, value.Stride(0)*start,
+				length, value.Stride(1),
+				vHeadDim, value.Stride(2),
+				numKVHeads,
+			)
+		}
+
+		elemSize := c.values[c.curLayer].Stride(0)
+		ctx.Forward(value.Copy(ctx, c.values[c.curLayer].View(ctx, elemSize*loc, length, len(c.cells)*elemSize, vHeadDim*numKVHeads)))
+	} else {
+		vHeadDim := value.Dim(0)
+		if length != value.Dim(2) {
+			value = value.View(ctx, value.Stride(2)*start,
+				vHeadDim, value.Stride(1),
+				numKVHeads, value.Stride(2),
+				length,
+			)
+		}
+
+		rowSize := c.values[c.curLayer].Stride(2)
+		ctx.Forward(value.Copy(ctx, c.values[c.curLayer].View(ctx, rowSize*loc, vHeadDim*numKVHeads*length)))
+	}
+}
+
+// CopyPrefix shares the blocks holding tokens in the range [0, len) of
+// srcSeq with dstSeq. No data is copied until one of the sequences writes
+// to a shared block.
+func (c *Paged) CopyPrefix(srcSeq, dstSeq int, len int32) {
+	c.removeTable(dstSeq)
+
+	src, ok := c.tables[srcSeq]
+	if !ok {
+		return
+	}
+
+	var length int
+	for i := range src.length {
+		if c.cells[c.cell(src, i)].pos >= len {
+			break
+		}
+		length = i + 1
+	}
+
+	if length == 0 {
+		return
+	}
+
+	dst := &blockTable{
+		blocks: slices.Clone(src.blocks[:roundUp(length, c.blockSize)/c.blockSize]),
+		length: length,
+	}
+
+	for _, block := range dst.blocks {
+		c.refs[block]++
+	}
+
+	for i := range dst.length {
+		cell := &c.cells[c.cell(dst, i)]
+		cell.sequences = append(cell.sequences, dstSeq)
+	}
+
+	c.tables[dstSeq] = dst
+}
+
+// cell returns the location in the cache of the i-th entry of a block table
+func (c *Paged) cell(table *blockTable, i int) int {
+	return table.blocks[i/c.blockSize]*c.blockSize + i%c.blockSize
+}
+
+func (c *Paged) CanResume(seq int, pos int32) bool {
+	return true
+}
+
+func (c *Paged) Remove(seq int, beginIndex, endIndex int32) error {
+	if endIndex != math.MaxInt32 {
+		return ErrNotSupported
+	}
+
+	table, ok := c.tables[seq]
+	if !ok {
+		return nil
+	}
+
+	// entries are stored in order of position, so everything after the
+	// first removed entry is removed
+	length := table.length
+	for i := range table.length {
+		if c.cells[c.cell(table, i)].pos >= beginIndex {
+			length = i
+			break
+		}
+	}
+
+	if length == 0 {
+		c.removeTable(seq)
+		return nil
+	}
+
+	c.truncate(seq, length)
+	return nil
+}
+
+// truncate removes the entries of seq starting at index length of its block table
+func (c *Paged) truncate(seq int, length int) {
+	table := c.tables[seq]
+
+	for i := length; i < table.length; i++ {
+		cell := &c.cells[c.cell(table, i)]
+		cell.sequences = slices.DeleteFunc(cell.sequences, func(s int) bool { return s == seq })
+	}
+
+	numBlocks := roundUp(length, c.blockSize) / c.blockSize
+	for _, block := range table.blocks[numBlocks:] {
+		c.releaseBlock(block)
+	}
+
+	table.blocks = table.blocks[:numBlocks]
+	table.length = length
+}
+
+func (c *Paged) removeTable(seq int) {
+	if _, ok := c.tables[seq]; !ok {
+		return
+	}
+
+	c.truncate(seq, 0)
+	delete(c.tables, seq)
+}
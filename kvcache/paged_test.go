@@ -0,0 +1,283 @@
+package kvcache
+
+import (
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/ollama/ollama/ml"
+	"github.com/ollama/ollama/model/input"
+)
+
+func TestPagedStore(t *testing.T) {
+	backend := &testBackend{}
+	cache := NewPagedCache(2)
+	defer cache.Close()
+
+	cache.Init(backend, UniformDType(ml.DTypeF16), 1, 16, 16)
+
+	tests := []testCase{
+		{
+			name:          "FirstBatch",
+			in:            []float32{111, 211, 121, 221, 131, 231, 112, 212, 122, 222, 132, 232, 113, 213, 123, 223, 133, 233, 114, 214, 124, 224, 134, 234},
+			inShape:       []int{2, 3, 4},
+			seqs:          []int{0, 0, 0, 0},
+			pos:           []int32{0, 1, 2, 3},
+			expected:      []float32{111, 211, 121, 221, 131, 231, 112, 212, 122, 222, 132, 232, 113, 213, 123, 223, 133, 233, 114, 214, 124, 224, 134, 234},
+			expectedShape: []int{2, 3, 4},
+			expectedMask:  []float32{0, float32(math.Inf(-1)), float32(math.Inf(-1)), float32(math.Inf(-1)), 0, 0, float32(math.Inf(-1)), float32(math.Inf(-1)), 0, 0, 0, float32(math.Inf(-1)), 0, 0, 0, 0},
+		},
+		{
+			name:          "SecondBatch",
+			in:            []float32{115, 215, 125, 225, 135, 235},
+			inShape:       []int{2, 3, 1},
+			seqs:          []int{0},
+			pos:           []int32{4},
+			expected:      []float32{111, 211, 121, 221, 131, 231, 112, 212, 122, 222, 132, 232, 113, 213, 123, 223, 133, 233, 114, 214, 124, 224, 134, 234, 115, 215, 125, 225, 135, 235},
+			expectedShape: []int{2, 3, 5},
+			expectedMask:  []float32{0, 0, 0, 0, 0},
+		},
+	}
+
+	testCache(t, backend, cache, tests)
+}
+
+func TestPagedSequences(t *testing.T) {
+	backend := &testBackend{}
+	cache := NewPagedCache(2)
+	defer cache.Close()
+
+	cache.Init(backend, UniformDType(ml.DTypeF16), 2, 16, 16)
+
+	x := float32(math.Inf(-1))
+
+	tests := []testCase{
+		{
+			name:          "FirstBatch",
+			in:            []float32{1, 2, 3, 4},
+			inShape:       []int{1, 1, 4},
+			seqs:          []int{0, 0, 1, 1},
+			pos:           []int32{0, 1, 0, 1},
+			expected:      []float32{1, 2, 3, 4},
+			expectedShape: []int{1, 1, 4},
+			expectedMask: []float32{
+				0, x, x, x,
+				0, 0, x, x,
+				x, x, 0, x,
+				x, x, 0, 0,
+			},
+		},
+		{
+			// each sequence starts a new block, so the entries are not contiguous
+			name:          "SecondBatch",
+			in:            []float32{5, 6},
+			inShape:       []int{1, 1, 2},
+			seqs:          []int{0, 1},
+			pos:           []int32{2, 2},
+			expected:      []float32{1, 2, 3, 4, 5, 0, 6},
+			expectedShape: []int{1, 1, 7},
+			expectedMask: []float32{
+				0, 0, x, x, 0, x, x,
+				x, x, 0, 0, x, x, 0,
+			},
+		},
+	}
+
+	testCache(t, backend, cache, tests)
+}
+
+func TestPagedRemove(t *testing.T) {
+	backend := &testBackend{}
+	cache := NewPagedCache(2)
+	defer cache.Close()
+
+	cache.Init(backend, UniformDType(ml.DTypeF16), 1, 16, 16)
+
+	x := float32(math.Inf(-1))
+
+	tests := []testCase{
+		{
+			name:          "FirstBatch",
+			in:            []float32{1, 2, 3, 4},
+			inShape:       []int{1, 1, 4},
+			seqs:          []int{0, 0, 0, 0},
+			pos:           []int32{0, 1, 2, 3},
+			expected:      []float32{1, 2, 3, 4},
+			expectedShape: []int{1, 1, 4},
+			expectedMask: []float32{
+				0, x, x, x,
+				0, 0, x, x,
+				0, 0, 0, x,
+				0, 0, 0, 0,
+			},
+		},
+	}
+
+	testCache(t, backend, cache, tests)
+
+	if err := cache.Remove(0, 0, 1); !errors.Is(err, ErrNotSupported) {
+		t.Fatalf("Remove from middle: have %v; want %v", err, ErrNotSupported)
+	}
+
+	if err := cache.Remove(0, 1, math.MaxInt32); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(cache.free) != 7 {
+		t.Errorf("free blocks after removal: have %v; want 7", len(cache.free))
+	}
+
+	tests = []testCase{
+		{
+			// the partially filled first block is reused and the second block
+			// is allocated again
+			name:          "RemoveEnd",
+			in:            []float32{5, 6},
+			inShape:       []int{1, 1, 2},
+			seqs:          []int{0, 0},
+			pos:           []int32{1, 2},
+			expected:      []float32{1, 5, 6},
+			expectedShape: []int{1, 1, 3},
+			expectedMask: []float32{
+				0, 0, x,
+				0, 0, 0,
+			},
+		},
+	}
+
+	testCache(t, backend, cache, tests)
+
+	if err := cache.Remove(0, 0, math.MaxInt32); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(cache.free) != 8 || len(cache.tables) != 0 {
+		t.Errorf("after removing sequence: have %v free blocks and %v tables; want 8 and 0", len(cache.free), len(cache.tables))
+	}
+}
+
+func TestPagedCopyOnWrite(t *testing.T) {
+	backend := &testBackend{}
+	cache := NewPagedCache(2)
+	defer cache.Close()
+
+	cache.Init(backend, UniformDType(ml.DTypeF16), 2, 16, 16)
+
+	x := float32(math.Inf(-1))
+
+	tests := []testCase{
+		{
+			name:          "FirstBatch",
+			in:            []float32{1, 2, 3, 4},
+			inShape:       []int{1, 1, 4},
+			seqs:          []int{0, 0, 0, 0},
+			pos:           []int32{0, 1, 2, 3},
+			expected:      []float32{1, 2, 3, 4},
+			expectedShape: []int{1, 1, 4},
+			expectedMask: []float32{
+				0, x, x, x,
+				0, 0, x, x,
+				0, 0, 0, x,
+				0, 0, 0, 0,
+			},
+		},
+	}
+
+	testCache(t, backend, cache, tests)
+
+	cache.CopyPrefix(0, 1, 3)
+
+	if cache.refs[0] != 2 || cache.refs[1] != 2 {
+		t.Fatalf("shared block references: have %v; want [2 2 ...]", cache.refs)
+	}
+
+	tests = []testCase{
+		{
+			// the second block is shared and partially used by seq 1, so it is
+			// copied before writing
+			name:          "Copy",
+			in:            []float32{5, 6},
+			inShape:       []int{1, 1, 2},
+			seqs:          []int{1, 1},
+			pos:           []int32{3, 4},
+			expected:      []float32{1, 2, 3, 4, 3, 5, 6},
+			expectedShape: []int{1, 1, 7},
+			expectedMask: []float32{
+				0, 0, x, x, 0, 0, x,
+				0, 0, x, x, 0, 0, 0,
+			},
+		},
+		{
+			// seq 0 still has its original data
+			name:          "Original",
+			in:            []float32{7},
+			inShape:       []int{1, 1, 1},
+			seqs:          []int{0},
+			pos:           []int32{4},
+			expected:      []float32{1, 2, 3, 4, 3, 5, 6, 0, 7},
+			expectedShape: []int{1, 1, 9},
+			expectedMask:  []float32{0, 0, 0, 0, x, x, x, x, 0},
+		},
+	}
+
+	testCache(t, backend, cache, tests)
+
+	if cache.refs[0] != 2 || cache.refs[1] != 1 {
+		t.Fatalf("block references after copy: have %v; want [2 1 ...]", cache.refs)
+	}
+
+	// removing the original sequence keeps the shared prefix for the copy
+	if err := cache.Remove(0, 0, math.MaxInt32); err != nil {
+		t.Fatal(err)
+	}
+
+	if cache.refs[0] != 1 || cache.refs[1] != 0 || cache.refs[4] != 0 {
+		t.Fatalf("block references after remove: have %v; want [1 0 1 1 0 ...]", cache.refs)
+	}
+
+	tests = []testCase{
+		{
+			name:          "AfterRemove",
+			in:            []float32{8},
+			inShape:       []int{1, 1, 1},
+			seqs:          []int{1},
+			pos:           []int32{5},
+			expected:      []float32{1, 2, 3, 4, 3, 5, 6, 8},
+			expectedShape: []int{1, 1, 8},
+			expectedMask:  []float32{0, 0, x, x, 0, 0, 0, 0},
+		},
+	}
+
+	testCache(t, backend, cache, tests)
+}
+
+func TestPagedFull(t *testing.T) {
+	backend := &testBackend{}
+	cache := NewPagedCache(2)
+	defer cache.Close()
+
+	cache.Init(backend, UniformDType(ml.DTypeF16), 1, 4, 4)
+
+	context := backend.NewContext()
+	defer context.Close()
+
+	err := cache.StartForward(context, input.Batch{
+		Positions: []int32{0, 1, 2, 3, 4},
+		Sequences: []int{0, 0, 0, 0, 0},
+	}, false)
+	if !errors.Is(err, ErrKvCacheFull) {
+		t.Fatalf("StartForward: have %v; want %v", err, ErrKvCacheFull)
+	}
+
+	if len(cache.free) != 2 || len(cache.tables) != 0 {
+		t.Errorf("failed batch modified the cache: have %v free blocks and %v tables", len(cache.free), len(cache.tables))
+	}
+
+	err = cache.StartForward(context, input.Batch{
+		Positions: []int32{0, 1, 2, 3},
+		Sequences: []int{0, 0, 0, 0},
+	}, false)
+	if err != nil {
+		t.Fatalf("StartForward failed: %v", err)
+	}
+}
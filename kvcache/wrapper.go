@@ -23,7 +23,7 @@ func NewWrapperCache(caches ...Cache) *WrapperCache {
 	}
 }
 
-func (c *WrapperCache) Init(backend ml.Backend, dtype ml.DType, maxSequences, capacity, maxBatch int) {
+func (c *WrapperCache) Init(backend ml.Backend, dtype DType, maxSequences, capacity, maxBatch int) {
 	for _, cache := range c.caches {
 		cache.Init(backend, dtype, maxSequences, capacity, maxBatch)
 	}
@@ -0,0 +1,103 @@
+package llama
+
+/*
+#include <stdlib.h>
+#include "ggml.h"
+#include "llama.h"
+#include "imatrix_ext.h"
+*/
+import "C"
+
+import (
+	"errors"
+	"runtime"
+	"unsafe"
+)
+
+// ComputeImatrix runs text through the model at modelPath and returns the
+// importance matrix of each weight, keyed by tensor name. Importances are
+// the mean squared activations of each input column, with one row per
+// expert for mixture of experts weights. progress, if not nil, is called
+// with the fraction of text that has been processed.
+func ComputeImatrix(modelPath, text string, numCtx int, progress func(float32)) (map[string][]float32, error) {
+	m, err := LoadModelFromFile(modelPath, ModelParams{UseMmap: true})
+	if err != nil {
+		return nil, err
+	}
+	defer FreeModel(m)
+
+	tokens, err := m.Tokenize(text, false, false)
+	if err != nil {
+		return nil, err
+	}
+
+	bos := C.llama_vocab_bos(m.Vocab())
+	addBOS := m.AddBOSToken() && bos != C.LLAMA_TOKEN_NULL
+	if addBOS {
+		numCtx--
+	}
+
+	if len(tokens) < numCtx {
+		return nil, errors.New("calibration text is too short, need at least one full context")
+	}
+
+	collector := C.imatrix_collector_init()
+	defer C.imatrix_collector_free(collector)
+
+	params := NewContextParams(numCtx+1, numCtx+1, 1, runtime.NumCPU(), false, "")
+	params.c.n_ubatch = params.c.n_batch
+	params.c.embeddings = C.bool(false)
+	params.c.cb_eval = C.ggml_backend_sched_eval_callback(C.imatrix_collector_eval)
+	params.c.cb_eval_user_data = unsafe.Pointer(collector)
+
+	lc, err := NewContextWithModel(m, params)
+	if err != nil {
+		return nil, err
+	}
+	defer lc.Free()
+
+	batch, err := NewBatch(numCtx+1, 1, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer batch.Free()
+
+	chunks := len(tokens) / numCtx
+	for i := range chunks {
+		lc.KvCacheClear()
+		batch.Clear()
+
+		pos := 0
+		if addBOS {
+			batch.Add(int(bos), nil, pos, false, 0)
+			pos++
+		}
+
+		for _, t := range tokens[i*numCtx : (i+1)*numCtx] {
+			batch.Add(t, nil, pos, false, 0)
+			pos++
+		}
+
+		if err := lc.Decode(batch); err != nil {
+			return nil, err
+		}
+
+		if progress != nil {
+			progress(float32(i+1) / float32(chunks))
+		}
+	}
+
+	n := int(C.imatrix_collector_count(collector))
+	imatrix := make(map[string][]float32, n)
+	for i := range n {
+		values := make([]float32, C.imatrix_collector_size(collector, C.size_t(i)))
+		if len(values) == 0 {
+			continue
+		}
+
+		C.imatrix_collector_values(collector, C.size_t(i), (*C.float)(&values[0]))
+		imatrix[C.GoString(C.imatrix_collector_name(collector, C.size_t(i)))] = values
+	}
+
+	return imatrix, nil
+}
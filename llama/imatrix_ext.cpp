@@ -0,0 +1,145 @@
+// TODO: this is a temporary wrapper to allow calling C++ code from CGo
+#include "imatrix_ext.h"
+#include "ggml.h"
+#include "ggml-backend.h"
+
+#include <cstring>
+#include <map>
+#include <mutex>
+#include <string>
+#include <vector>
+
+struct imatrix_stats {
+    std::vector<float> values;
+    std::vector<int64_t> counts;
+};
+
+struct imatrix_collector {
+    std::map<std::string, imatrix_stats> stats;
+    std::vector<std::string> names;
+    std::vector<char> buf;
+    std::mutex mutex;
+};
+
+struct imatrix_collector *imatrix_collector_init(void) {
+    return new imatrix_collector;
+}
+
+void imatrix_collector_free(struct imatrix_collector *c) {
+    delete c;
+}
+
+// Adapted from collect_imatrix in llama.cpp's tools/imatrix/imatrix.cpp
+bool imatrix_collector_eval(struct ggml_tensor *t, bool ask, void *user_data) {
+    auto *c = (imatrix_collector *)user_data;
+
+    const struct ggml_tensor *src0 = t->src[0];
+    const struct ggml_tensor *src1 = t->src[1];
+
+    if (ask) {
+        if (t->op == GGML_OP_MUL_MAT_ID) {
+            return true;
+        }
+        if (t->op != GGML_OP_MUL_MAT) {
+            return false;
+        }
+        // only model weights, and skip the small batches of single token
+        // generation
+        return src1->ne[1] >= 16 && src1->type == GGML_TYPE_F32 && strncmp(src0->name, "blk.", 4) == 0;
+    }
+
+    std::lock_guard<std::mutex> lock(c->mutex);
+
+    const bool is_host = src1->buffer == nullptr || ggml_backend_buffer_is_host(src1->buffer);
+    if (!is_host) {
+        c->buf.resize(ggml_nbytes(src1));
+        ggml_backend_tensor_get(src1, c->buf.data(), 0, ggml_nbytes(src1));
+    }
+
+    const char *data = is_host ? (const char *)src1->data : c->buf.data();
+    GGML_ASSERT(src1->nb[0] == ggml_element_size(src1));
+
+    std::string name = src0->name;
+    auto it = c->stats.find(name);
+    if (it == c->stats.end()) {
+        c->names.push_back(name);
+    }
+    auto &e = c->stats[name];
+
+    if (t->op == GGML_OP_MUL_MAT_ID) {
+        const int n_as = src0->ne[2];
+        const struct ggml_tensor *ids = t->src[2];
+        std::vector<char> ids_host(ggml_nbytes(ids));
+        ggml_backend_tensor_get(ids, ids_host.data(), 0, ggml_nbytes(ids));
+
+        if (e.values.empty()) {
+            e.values.resize(src1->ne[0] * n_as, 0);
+            e.counts.resize(n_as, 0);
+        } else if (e.values.size() != (size_t)(src1->ne[0] * n_as)) {
+            // returning false would abort the graph computation
+            return true;
+        }
+
+        for (int64_t row = 0; row < ids->ne[1]; ++row) {
+            for (int64_t k = 0; k < ids->ne[0]; ++k) {
+                const int32_t ex = *(const int32_t *)(ids_host.data() + row * ids->nb[1] + k * ids->nb[0]);
+                if (ex < 0 || ex >= n_as) {
+                    continue;
+                }
+
+                const int64_t i11 = k % src1->ne[1];
+                const int64_t i12 = row;
+                const float *x = (const float *)(data + i11 * src1->nb[1] + i12 * src1->nb[2]);
+
+                for (int64_t j = 0; j < src1->ne[0]; ++j) {
+                    e.values[ex * src1->ne[0] + j] += x[j] * x[j];
+                }
+                e.counts[ex]++;
+            }
+        }
+    } else {
+        if (e.values.empty()) {
+            e.values.resize(src1->ne[0], 0);
+            e.counts.resize(1, 0);
+        } else if (e.values.size() != (size_t)src1->ne[0]) {
+            return true;
+        }
+
+        for (int64_t i3 = 0; i3 < src1->ne[3]; ++i3) {
+            for (int64_t i2 = 0; i2 < src1->ne[2]; ++i2) {
+                for (int64_t row = 0; row < src1->ne[1]; ++row) {
+                    const float *x = (const float *)(data + row * src1->nb[1] + i2 * src1->nb[2] + i3 * src1->nb[3]);
+                    for (int64_t j = 0; j < src1->ne[0]; ++j) {
+                        e.values[j] += x[j] * x[j];
+                    }
+                    e.counts[0]++;
+                }
+            }
+        }
+    }
+
+    return true;
+}
+
+size_t imatrix_collector_count(struct imatrix_collector *c) {
+    return c->names.size();
+}
+
+const char *imatrix_collector_name(struct imatrix_collector *c, size_t i) {
+    return c->names[i].c_str();
+}
+
+size_t imatrix_collector_size(struct imatrix_collector *c, size_t i) {
+    return c->stats[c->names[i]].values.size();
+}
+
+void imatrix_collector_values(struct imatrix_collector *c, size_t i, float *values) {
+    const auto &e = c->stats[c->names[i]];
+    const size_t n = e.values.size() / e.counts.size();
+    for (size_t ex = 0; ex < e.counts.size(); ++ex) {
+        for (size_t j = 0; j < n; ++j) {
+            // unused experts get equal importance
+            values[ex * n + j] = e.counts[ex] > 0 ? e.values[ex * n + j] / e.counts[ex] : 1.0f;
+        }
+    }
+}
@@ -0,0 +1,38 @@
+// TODO: this is a temporary wrapper to allow calling C++ code from CGo
+#ifndef IMATRIX_EXT_H
+#define IMATRIX_EXT_H
+
+#include <stdbool.h>
+#include <stddef.h>
+
+#ifdef __cplusplus
+extern "C"
+{
+#endif
+
+    struct ggml_tensor;
+
+    // imatrix_collector accumulates the squared activations that flow into
+    // each weight matrix, as llama.cpp's imatrix tool does
+    struct imatrix_collector;
+
+    struct imatrix_collector *imatrix_collector_init(void);
+    void imatrix_collector_free(struct imatrix_collector *c);
+
+    // imatrix_collector_eval is a ggml_backend_sched_eval_callback. user_data
+    // must point to an imatrix_collector
+    bool imatrix_collector_eval(struct ggml_tensor *t, bool ask, void *user_data);
+
+    size_t imatrix_collector_count(struct imatrix_collector *c);
+    const char *imatrix_collector_name(struct imatrix_collector *c, size_t i);
+
+    // imatrix_collector_values writes the averaged importances of entry i
+    // into values, which must hold imatrix_collector_size(c, i) floats
+    size_t imatrix_collector_size(struct imatrix_collector *c, size_t i);
+    void imatrix_collector_values(struct imatrix_collector *c, size_t i, float *values);
+
+#ifdef __cplusplus
+}
+#endif
+
+#endif // IMATRIX_EXT_H
@@ -39,6 +39,7 @@ import (
 	"sync"
 	"unsafe"
 
+	fsggml "github.com/ollama/ollama/fs/ggml"
 	_ "github.com/ollama/ollama/llama/llama.cpp/common"
 	_ "github.com/ollama/ollama/llama/llama.cpp/src"
 	_ "github.com/ollama/ollama/llama/llama.cpp/tools/mtmd"
@@ -123,8 +124,11 @@ func NewContextParams(numCtx int, batchSize int, numSeqMax int, threads int, fla
 	} else {
 		params.flash_attn_type = C.LLAMA_FLASH_ATTN_TYPE_DISABLED
 	}
-	params.type_k = kvCacheTypeFromStr(strings.ToLower(kvCacheType))
-	params.type_v = kvCacheTypeFromStr(strings.ToLower(kvCacheType))
+
+	// llama.cpp uses the same types in every layer so any per-layer policy is ignored
+	cacheType, _ := fsggml.ParseKVCacheType(kvCacheType)
+	params.type_k = kvCacheTypeFromStr(cacheType.K)
+	params.type_v = kvCacheTypeFromStr(cacheType.V)
 
 	return ContextParams{c: params}
 }
@@ -170,6 +174,10 @@ func (c *Context) Decode(batch *Batch) error {
 	return nil
 }
 
+func (c *Context) Free() {
+	C.llama_free(c.c)
+}
+
 func (c *Context) Model() *Model {
 	return &Model{c: C.llama_get_model(c.c)}
 }
@@ -0,0 +1,232 @@
+package llm
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp/syntax"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/ollama/ollama/llama"
+)
+
+// formatGrammar returns the grammar for a request's format, which is "json", a
+// JSON schema, a regex format ({"type": "regex", "pattern": ...}) or a choice
+// format ({"type": "choice", "values": [...]}). It returns "" if the format
+// isn't set.
+func formatGrammar(format json.RawMessage) (string, error) {
+	switch string(format) {
+	case "", `null`, `""`:
+		// Field was set, but "missing" a value. We accept
+		// these as "not set".
+		return "", nil
+	case `"json"`:
+		return grammarJSON, nil
+	}
+
+	if format[0] != '{' {
+		return "", fmt.Errorf("invalid format: %q; expected \"json\" or a valid JSON Schema object, regex format or choice format", format)
+	}
+
+	var f struct {
+		Type    any      `json:"type"`
+		Pattern *string  `json:"pattern"`
+		Values  []string `json:"values"`
+	}
+	if err := json.Unmarshal(format, &f); err != nil {
+		return "", fmt.Errorf("invalid format: %w", err)
+	}
+
+	switch f.Type {
+	case "regex":
+		if f.Pattern == nil {
+			return "", errors.New("regex format requires a pattern")
+		}
+		return regexGrammar(*f.Pattern)
+	case "choice":
+		return choiceGrammar(f.Values)
+	}
+
+	// User provided a JSON schema
+	g := llama.SchemaToGrammar(format)
+	if g == nil {
+		return "", errors.New("invalid JSON schema in format")
+	}
+	return string(g), nil
+}
+
+// choiceGrammar returns a grammar matching exactly one of values
+func choiceGrammar(values []string) (string, error) {
+	if len(values) == 0 {
+		return "", errors.New("choice format requires values")
+	}
+
+	alternatives := make([]string, len(values))
+	for i, v := range values {
+		alternatives[i] = GrammarLiteral(v)
+	}
+	return "root ::= " + strings.Join(alternatives, " | ") + "\n", nil
+}
+
+// regexGrammar returns a grammar matching the whole output against the RE2
+// pattern. Anchors are allowed but redundant, as the grammar always matches
+// from the start to the end of the output.
+func regexGrammar(pattern string) (string, error) {
+	re, err := syntax.Parse(pattern, syntax.Perl)
+	if err != nil {
+		return "", fmt.Errorf("invalid regex format: %w", err)
+	}
+
+	var sb strings.Builder
+	sb.WriteString("root ::= ")
+	if err := writeRegex(&sb, re); err != nil {
+		return "", fmt.Errorf("invalid regex format: %w", err)
+	}
+	sb.WriteString("\n")
+	return sb.String(), nil
+}
+
+func writeRegex(sb *strings.Builder, re *syntax.Regexp) error {
+	switch re.Op {
+	case syntax.OpNoMatch:
+		return errors.New("pattern can't match anything")
+	case syntax.OpEmptyMatch, syntax.OpBeginLine, syntax.OpEndLine, syntax.OpBeginText, syntax.OpEndText:
+		sb.WriteString(`""`)
+	case syntax.OpWordBoundary, syntax.OpNoWordBoundary:
+		return errors.New("word boundaries aren't supported")
+	case syntax.OpLiteral:
+		if re.Flags&syntax.FoldCase == 0 {
+			sb.WriteString(GrammarLiteral(string(re.Rune)))
+			return nil
+		}
+
+		sb.WriteString("(")
+		for i, r := range re.Rune {
+			if i > 0 {
+				sb.WriteString(" ")
+			}
+			writeCharClass(sb, foldRune(r))
+		}
+		sb.WriteString(")")
+	case syntax.OpCharClass:
+		if len(re.Rune) == 0 {
+			return errors.New("pattern can't match anything")
+		}
+		writeCharClass(sb, re.Rune)
+	case syntax.OpAnyCharNotNL:
+		sb.WriteString(`[^\n]`)
+	case syntax.OpAnyChar:
+		writeCharClass(sb, []rune{0, unicode.MaxRune})
+	case syntax.OpCapture:
+		return writeRegex(sb, re.Sub[0])
+	case syntax.OpStar, syntax.OpPlus, syntax.OpQuest, syntax.OpRepeat:
+		sb.WriteString("(")
+		if err := writeRegex(sb, re.Sub[0]); err != nil {
+			return err
+		}
+		sb.WriteString(")")
+
+		switch re.Op {
+		case syntax.OpStar:
+			sb.WriteString("*")
+		case syntax.OpPlus:
+			sb.WriteString("+")
+		case syntax.OpQuest:
+			sb.WriteString("?")
+		default:
+			sb.WriteString("{" + strconv.Itoa(re.Min))
+			if re.Max != re.Min {
+				sb.WriteString(",")
+				if re.Max >= 0 {
+					sb.WriteString(strconv.Itoa(re.Max))
+				}
+			}
+			sb.WriteString("}")
+		}
+	case syntax.OpConcat, syntax.OpAlternate:
+		separator := " "
+		if re.Op == syntax.OpAlternate {
+			separator = " | "
+		}
+
+		sb.WriteString("(")
+		for i, sub := range re.Sub {
+			if i > 0 {
+				sb.WriteString(separator)
+			}
+			if err := writeRegex(sb, sub); err != nil {
+				return err
+			}
+		}
+		sb.WriteString(")")
+	default:
+		return fmt.Errorf("unsupported regex operator %v", re.Op)
+	}
+
+	return nil
+}
+
+// foldRune returns the character class of r and the runes that are equal to
+// it under case folding
+func foldRune(r rune) []rune {
+	runes := []rune{r, r}
+	for f := unicode.SimpleFold(r); f != r; f = unicode.SimpleFold(f) {
+		runes = append(runes, f, f)
+	}
+	return runes
+}
+
+// writeCharClass writes a character class with the ranges in pairs of runes
+func writeCharClass(sb *strings.Builder, ranges []rune) {
+	sb.WriteString("[")
+	for i := 0; i+1 < len(ranges); i += 2 {
+		sb.WriteString(grammarRune(ranges[i], true))
+		if ranges[i+1] != ranges[i] {
+			sb.WriteString("-" + grammarRune(ranges[i+1], true))
+		}
+	}
+	sb.WriteString("]")
+}
+
+// GrammarLiteral quotes s as a GBNF string literal
+func GrammarLiteral(s string) string {
+	var sb strings.Builder
+	sb.WriteString(`"`)
+	for _, r := range s {
+		sb.WriteString(grammarRune(r, false))
+	}
+	sb.WriteString(`"`)
+	return sb.String()
+}
+
+// grammarRune escapes r for a string literal or, if class is set, a character
+// class
+func grammarRune(r rune, class bool) string {
+	switch {
+	case r == '\\':
+		return `\\`
+	case r == '"' && !class:
+		return `\"`
+	case class && (r == ']' || r == '['):
+		return `\` + string(r)
+	case class && (r == '-' || r == '^'):
+		// these have no escape of their own
+		return fmt.Sprintf(`\x%02X`, r)
+	case r == '\n':
+		return `\n`
+	case r == '\r':
+		return `\r`
+	case r == '\t':
+		return `\t`
+	case r < 0x20 || r == 0x7f:
+		return fmt.Sprintf(`\x%02X`, r)
+	case r > 0xffff:
+		return fmt.Sprintf(`\U%08X`, r)
+	case r > 0x7f && !unicode.IsPrint(r):
+		return fmt.Sprintf(`\u%04X`, r)
+	default:
+		return string(r)
+	}
+}
@@ -0,0 +1,154 @@
+package llm
+
+import (
+	"encoding/json"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/ollama/ollama/llama"
+)
+
+// grammarAccepts reports whether grammar matches all of s, feeding it one
+// rune at a time
+func grammarAccepts(t *testing.T, grammar, s string) bool {
+	t.Helper()
+
+	runes := []rune(s)
+	ids := map[rune]uint32{}
+	vocabIds := []uint32{0}
+	vocabValues := []string{"</s>"}
+	for _, r := range runes {
+		if _, ok := ids[r]; !ok {
+			ids[r] = uint32(len(vocabIds))
+			vocabIds = append(vocabIds, ids[r])
+			vocabValues = append(vocabValues, string(r))
+		}
+	}
+
+	g := llama.NewGrammar(grammar, vocabIds, vocabValues, []int32{0})
+	if g == nil {
+		t.Fatalf("invalid grammar:\n%s", grammar)
+	}
+	defer g.Free()
+
+	allowed := func(id uint32) bool {
+		tokens := []llama.TokenData{{ID: int32(id), Logit: 1}}
+		g.Apply(tokens)
+		return !math.IsInf(float64(tokens[0].Logit), -1)
+	}
+
+	for _, r := range runes {
+		if !allowed(ids[r]) {
+			return false
+		}
+		g.Accept(int32(ids[r]))
+	}
+
+	return allowed(0)
+}
+
+func TestFormatGrammar(t *testing.T) {
+	cases := []struct {
+		name   string
+		format string
+		accept []string
+		reject []string
+	}{
+		{
+			name:   "choice",
+			format: `{"type": "choice", "values": ["positive", "negative", "say \"neutral\""]}`,
+			accept: []string{"positive", "negative", `say "neutral"`},
+			reject: []string{"positiv", "Positive", "positive ", ""},
+		},
+		{
+			name:   "regex",
+			format: `{"type": "regex", "pattern": "^\\d{3}-\\d{4}$"}`,
+			accept: []string{"555-1234"},
+			reject: []string{"5555-1234", "555-123", "555 1234"},
+		},
+		{
+			name:   "regex alternation and repetition",
+			format: `{"type": "regex", "pattern": "(yes|no)(, (yes|no))*"}`,
+			accept: []string{"yes", "no, yes, yes"},
+			reject: []string{"yes,", "maybe"},
+		},
+		{
+			name:   "regex classes",
+			format: `{"type": "regex", "pattern": "[A-Z][^0-9\\]]+\\.?"}`,
+			accept: []string{"Hello", "Hi there.", "Naïve"},
+			reject: []string{"hello", "H1", "H]"},
+		},
+		{
+			name:   "regex case insensitive",
+			format: `{"type": "regex", "pattern": "(?i)ok"}`,
+			accept: []string{"ok", "OK", "oK"},
+			reject: []string{"okay"},
+		},
+		{
+			name:   "regex optional and any",
+			format: `{"type": "regex", "pattern": "a.?b{2,}c{1,2}"}`,
+			accept: []string{"abbc", "a-bbbcc", "a\"bbc"},
+			reject: []string{"abc", "abbccc", "a\nbbc"},
+		},
+		{
+			name:   "json",
+			format: `"json"`,
+			accept: []string{`{"answer": 42}`},
+			reject: []string{"42"},
+		},
+		{
+			name:   "json schema",
+			format: `{"type": "object", "properties": {"answer": {"type": "integer"}}, "required": ["answer"]}`,
+			accept: []string{`{"answer": 42}`},
+			reject: []string{`{"answer": "42"}`},
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			grammar, err := formatGrammar(json.RawMessage(tt.format))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, s := range tt.accept {
+				if !grammarAccepts(t, grammar, s) {
+					t.Errorf("expected grammar to accept %q:\n%s", s, grammar)
+				}
+			}
+
+			for _, s := range tt.reject {
+				if grammarAccepts(t, grammar, s) {
+					t.Errorf("expected grammar to reject %q:\n%s", s, grammar)
+				}
+			}
+		})
+	}
+}
+
+func TestFormatGrammarErrors(t *testing.T) {
+	cases := []struct {
+		format string
+		err    string
+	}{
+		{`{"type": "choice", "values": []}`, "choice format requires values"},
+		{`{"type": "regex"}`, "regex format requires a pattern"},
+		{`{"type": "regex", "pattern": "(unclosed"}`, "invalid regex format"},
+		{`{"type": "regex", "pattern": "\\bword\\b"}`, "word boundaries aren't supported"},
+		{`"xml"`, "invalid format"},
+	}
+
+	for _, tt := range cases {
+		_, err := formatGrammar(json.RawMessage(tt.format))
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("format %s: expected error containing %q, got %v", tt.format, tt.err, err)
+		}
+	}
+
+	for _, format := range []string{"", "null", `""`} {
+		if g, err := formatGrammar(json.RawMessage(format)); g != "" || err != nil {
+			t.Errorf("expected no grammar for %q, got %q and %v", format, g, err)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package llm
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+)
+
+// ImatrixStatus is written by the runner as a line of JSON while it
+// computes an importance matrix
+type ImatrixStatus struct {
+	Progress float32 `json:"progress,omitempty"`
+	Error    string  `json:"error,omitempty"`
+}
+
+// ComputeImatrix runs the calibration text in textPath through the model at
+// modelPath in a runner process, in chunks of numCtx tokens, and writes the
+// importance matrix to outPath in llama.cpp's legacy imatrix format. fn, if
+// not nil, is called with the fraction of the text that has been processed.
+// The runner is a separate process so that a crash while loading or running
+// the model doesn't take down the server.
+func ComputeImatrix(modelPath, textPath, outPath string, numCtx int, fn func(float32)) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("unable to lookup executable path: %w", err)
+	}
+
+	if eval, err := filepath.EvalSymlinks(exe); err == nil {
+		exe = eval
+	}
+
+	in, err := os.Open(textPath)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	cmd := exec.Command(exe, "runner", "--imatrix", "--model", modelPath, "--ctx", strconv.Itoa(numCtx), "--output", outPath)
+	cmd.Stdin = in
+	status := NewStatusWriter(os.Stderr)
+	cmd.Stderr = status
+	cmd.SysProcAttr = LlamaServerSysProcAttr
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("error starting runner: %w", err)
+	}
+
+	var runnerErr error
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		var s ImatrixStatus
+		if err := json.Unmarshal(scanner.Bytes(), &s); err != nil {
+			continue
+		}
+
+		if s.Error != "" {
+			runnerErr = errors.New(s.Error)
+		} else if fn != nil {
+			fn(s.Progress)
+		}
+	}
+
+	if err := cmd.Wait(); err != nil {
+		if runnerErr != nil {
+			return runnerErr
+		} else if status.LastErrMsg != "" {
+			return errors.New(status.LastErrMsg)
+		}
+		return fmt.Errorf("runner: %w", err)
+	}
+
+	return runnerErr
+}
@@ -216,13 +216,8 @@ func estimateGPULayers(gpus []ml.DeviceInfo, f *ggml.GGML, projectors []string,
 		ml.FlashAttentionSupported(gpus) &&
 		f.SupportsFlashAttention()
 
-	var kvct string
-	if useFlashAttention {
-		requested := strings.ToLower(envconfig.KvCacheType())
-		if f.SupportsKVCacheType(requested) {
-			kvct = requested
-		}
-	}
+	// estimates are only used by the llama engine
+	kvct, _ := kvCacheType(f, opts, useFlashAttention, false)
 
 	kv, graphPartialOffload, graphFullOffload := f.GraphSize(uint64(opts.NumCtx), uint64(min(opts.NumCtx, opts.NumBatch)), numParallel, kvct, useFlashAttention)
 
@@ -3,6 +3,7 @@ package llm
 import (
 	"bufio"
 	"bytes"
+	"cmp"
 	"context"
 	"encoding/json"
 	"errors"
@@ -34,6 +35,7 @@ import (
 	"github.com/ollama/ollama/logutil"
 	"github.com/ollama/ollama/ml"
 	"github.com/ollama/ollama/model"
+	"github.com/ollama/ollama/runner/common"
 )
 
 type filteredEnv []string
@@ -76,6 +78,8 @@ type LlamaServer interface {
 	VRAMSize() uint64 // Total VRAM across all GPUs
 	TotalSize() uint64
 	VRAMByGPU(id ml.DeviceID) uint64
+	KVCacheType() string
+	KVCacheSize() uint64
 	Pid() int
 	GetPort() int
 	GetDeviceInfos(ctx context.Context) []ml.DeviceInfo
@@ -167,8 +171,17 @@ func NewLlamaServer(systemInfo ml.SystemInfo, gpus []ml.DeviceInfo, modelPath st
 		}
 	}
 
+	scaling, err := ropeScaling(f, opts, textProcessor != nil)
+	if err != nil {
+		return nil, err
+	}
+
 	// Verify the requested context size is <= the model training size
 	trainCtx := f.KV().ContextLength()
+	if scaling.Type != "" {
+		trainCtx = uint64(float32(scaling.OriginalContextLength) * scaling.Factor)
+	}
+
 	if opts.NumCtx > int(trainCtx) && trainCtx > 0 {
 		slog.Warn("requested context size too large for model", "num_ctx", opts.NumCtx, "n_ctx_train", trainCtx)
 		opts.NumCtx = int(trainCtx)
@@ -176,7 +189,7 @@ func NewLlamaServer(systemInfo ml.SystemInfo, gpus []ml.DeviceInfo, modelPath st
 
 	opts.NumBatch = min(opts.NumBatch, opts.NumCtx)
 
-	loadRequest := LoadRequest{LoraPath: adapters, KvSize: opts.NumCtx * numParallel, BatchSize: opts.NumBatch, Parallel: numParallel, MultiUserCache: envconfig.MultiUserCache()}
+	loadRequest := LoadRequest{LoraPath: adapters, KvSize: opts.NumCtx * numParallel, BatchSize: opts.NumBatch, Parallel: numParallel, MultiUserCache: envconfig.MultiUserCache(), RoPEScaling: scaling}
 
 	defaultThreads := systemInfo.ThreadCount
 	if opts.NumThread > 0 {
@@ -209,21 +222,17 @@ func NewLlamaServer(systemInfo ml.SystemInfo, gpus []ml.DeviceInfo, modelPath st
 		fa = false
 	}
 
-	kvct := strings.ToLower(envconfig.KvCacheType())
-
 	if fa {
 		slog.Info("enabling flash attention")
 		loadRequest.FlashAttention = true
+	}
 
-		// Flash Attention also supports kv cache quantization
-		// Enable if the requested and kv cache type is supported by the model
-		if f.SupportsKVCacheType(kvct) {
-			loadRequest.KvCacheType = kvct
-		} else {
-			slog.Warn("kv cache type not supported by model", "type", kvct)
-		}
-	} else if kvct != "" && kvct != "f16" {
-		slog.Warn("quantized kv cache requested but flash attention disabled", "type", kvct)
+	// Flash Attention also supports kv cache quantization
+	loadRequest.KvCacheType, err = kvCacheType(f, opts, fa, textProcessor != nil)
+	if err != nil {
+		slog.Warn("using default kv cache type", "type", requestedKVCacheType(opts), "error", err)
+	} else if t, _ := ggml.ParseKVCacheType(requestedKVCacheType(opts)); t.PerLayer() && textProcessor == nil {
+		slog.Warn("per-layer kv cache types not supported by this model, quantizing all layers", "type", loadRequest.KvCacheType)
 	}
 
 	gpuLibs := ml.LibraryPaths(gpus)
@@ -287,6 +296,101 @@ func NewLlamaServer(systemInfo ml.SystemInfo, gpus []ml.DeviceInfo, modelPath st
 	}
 }
 
+// requestedKVCacheType returns the K/V cache type set for the model, falling
+// back to the server default
+func requestedKVCacheType(opts api.Options) string {
+	if opts.KvCacheType != "" {
+		return opts.KvCacheType
+	}
+
+	return envconfig.KvCacheType()
+}
+
+// kvCacheType resolves the requested K/V cache type into the canonical form
+// passed to the runner. Quantized types require flash attention and per-layer
+// policies are only supported by the Ollama engine. An empty string is the
+// default f16 cache.
+func kvCacheType(f *ggml.GGML, opts api.Options, flashAttention, ollamaEngine bool) (string, error) {
+	requested := requestedKVCacheType(opts)
+	if !f.SupportsKVCacheType(requested) {
+		return "", fmt.Errorf("kv cache type not supported by model: %q", requested)
+	}
+
+	t, err := ggml.ParseKVCacheType(requested)
+	if err != nil {
+		return "", err
+	}
+
+	if !t.Quantized() {
+		return "", nil
+	}
+
+	if !flashAttention {
+		return "", errors.New("quantized kv cache requested but flash attention disabled")
+	}
+
+	if !ollamaEngine {
+		t.First, t.Last = 0, 0
+	}
+
+	return t.String(), nil
+}
+
+// ropeScaling validates the RoPE scaling options against the model metadata
+// and the scaling types the model's architecture implements. Unset fields
+// default to the model's own scaling and, if no factor is given, the factor
+// needed to reach the requested context size. A zero value means the model's
+// own scaling is used unchanged.
+func ropeScaling(f *ggml.GGML, opts api.Options, ollamaEngine bool) (ml.RoPEScaling, error) {
+	if opts.RopeScalingType == "" && opts.RopeScalingFactor == 0 && opts.RopeOriginalContext == 0 {
+		return ml.RoPEScaling{}, nil
+	}
+
+	kv := f.KV()
+	scaling := ml.RoPEScaling{
+		Type:                  cmp.Or(opts.RopeScalingType, kv.String("rope.scaling.type"), "linear"),
+		Factor:                opts.RopeScalingFactor,
+		OriginalContextLength: cmp.Or(opts.RopeOriginalContext, int(kv.Uint("rope.scaling.original_context_length")), int(kv.ContextLength())),
+	}
+
+	switch scaling.Type {
+	case "none", "linear", "yarn", "static-ntk":
+	default:
+		return ml.RoPEScaling{}, fmt.Errorf("unsupported rope scaling type: %q", scaling.Type)
+	}
+
+	if !ollamaEngine {
+		return ml.RoPEScaling{}, errors.New("rope scaling options are only supported on the Ollama engine")
+	}
+
+	if !slices.Contains(kv.RoPEScalingTypes(), scaling.Type) {
+		return ml.RoPEScaling{}, fmt.Errorf("rope scaling type %q is not supported by %s models", scaling.Type, kv.Architecture())
+	}
+
+	if scaling.OriginalContextLength <= 0 {
+		return ml.RoPEScaling{}, errors.New("rope scaling requires the model's original context length")
+	}
+
+	if trainCtx := int(kv.ContextLength()); trainCtx > 0 && scaling.OriginalContextLength > trainCtx {
+		return ml.RoPEScaling{}, fmt.Errorf("rope original context %d exceeds the model context length %d", scaling.OriginalContextLength, trainCtx)
+	}
+
+	if scaling.Type == "none" {
+		scaling.Factor = 1
+		return scaling, nil
+	}
+
+	if scaling.Factor == 0 {
+		scaling.Factor = max(1, float32(opts.NumCtx)/float32(scaling.OriginalContextLength))
+	}
+
+	if scaling.Factor < 1 {
+		return ml.RoPEScaling{}, fmt.Errorf("rope scaling factor must be at least 1: %v", scaling.Factor)
+	}
+
+	return scaling, nil
+}
+
 func StartRunner(ollamaEngine bool, modelPath string, gpuLibs []string, out io.Writer, extraEnvs map[string]string) (cmd *exec.Cmd, port int, err error) {
 	var exe string
 	exe, err = os.Executable()
@@ -446,6 +550,7 @@ type LoadRequest struct {
 	FlashAttention bool
 	KvSize         int
 	KvCacheType    string
+	RoPEScaling    ml.RoPEScaling
 	NumThreads     int
 	GPULayers      ml.GPULayersList
 	MultiUserCache bool
@@ -1362,6 +1467,10 @@ type CompletionRequest struct {
 	Grammar  string // set before sending the request to the subprocess
 	Shift    bool
 	Truncate bool
+
+	// GrammarTrigger defers the grammar until the model generates it, so a
+	// thinking model can think freely before its constrained answer
+	GrammarTrigger string
 }
 
 // DoneReason represents the reason why a completion response is done
@@ -1387,6 +1496,11 @@ func (d DoneReason) String() string {
 	}
 }
 
+// ErrGrammarNotTriggered is returned when a response with a deferred grammar
+// ends before the grammar's trigger, which for thinking models means the
+// model didn't finish thinking
+var ErrGrammarNotTriggered = errors.New("the model stopped before starting its answer, so the answer couldn't be constrained")
+
 type CompletionResponse struct {
 	Content            string        `json:"content"`
 	DoneReason         DoneReason    `json:"done_reason"`
@@ -1395,6 +1509,8 @@ type CompletionResponse struct {
 	PromptEvalDuration time.Duration `json:"prompt_eval_duration"`
 	EvalCount          int           `json:"eval_count"`
 	EvalDuration       time.Duration `json:"eval_duration"`
+	TimeToFirstToken   time.Duration `json:"time_to_first_token"`
+	InterTokenLatency  time.Duration `json:"inter_token_latency"`
 }
 
 func (s *llmServer) Completion(ctx context.Context, req CompletionRequest, fn func(CompletionResponse)) error {
@@ -1402,24 +1518,12 @@ func (s *llmServer) Completion(ctx context.Context, req CompletionRequest, fn fu
 	logutil.Trace("completion request", "prompt", req.Prompt)
 
 	if len(req.Format) > 0 {
-		switch string(req.Format) {
-		case `null`, `""`:
-			// Field was set, but "missing" a value. We accept
-			// these as "not set".
-			break
-		case `"json"`:
-			req.Grammar = grammarJSON
-		default:
-			if req.Format[0] != '{' {
-				return fmt.Errorf("invalid format: %q; expected \"json\" or a valid JSON Schema object", req.Format)
-			}
-
-			// User provided a JSON schema
-			g := llama.SchemaToGrammar(req.Format)
-			if g == nil {
-				return fmt.Errorf("invalid JSON schema in format")
-			}
-			req.Grammar = string(g)
+		g, err := formatGrammar(req.Format)
+		if err != nil {
+			return err
+		}
+		if g != "" {
+			req.Grammar = g
 		}
 	}
 
@@ -1490,6 +1594,13 @@ func (s *llmServer) Completion(ctx context.Context, req CompletionRequest, fn fu
 	buf := make([]byte, 0, maxBufferSize)
 	scanner.Buffer(buf, maxBufferSize)
 
+	// a deferred grammar only constrains what's generated after its
+	// trigger, so a response that ends before the trigger isn't constrained
+	var trigger *common.Trigger
+	if req.Grammar != "" && req.GrammarTrigger != "" {
+		trigger = common.NewTrigger(req.GrammarTrigger)
+	}
+
 	// keep track of the last token generated, this is used to abort if the model starts looping
 	var lastToken string
 	var tokenRepeat int
@@ -1529,12 +1640,23 @@ func (s *llmServer) Completion(ctx context.Context, req CompletionRequest, fn fu
 			}
 
 			if c.Content != "" {
+				if trigger != nil && trigger.Add(c.Content) {
+					trigger = nil
+				}
+
 				fn(CompletionResponse{
 					Content: c.Content,
 				})
 			}
 
 			if c.Done {
+				if trigger != nil {
+					if c.DoneReason == DoneReasonLength {
+						return fmt.Errorf("%w: num_predict was reached first", ErrGrammarNotTriggered)
+					}
+					return ErrGrammarNotTriggered
+				}
+
 				fn(c)
 				return nil
 			}
@@ -1731,6 +1853,16 @@ func (s *llamaServer) VRAMByGPU(id ml.DeviceID) uint64 {
 	return 0
 }
 
+// KVCacheType returns the layout of the K/V cache requested from the runner,
+// which is empty for the default f16 cache
+func (s *llmServer) KVCacheType() string {
+	return s.loadRequest.KvCacheType
+}
+
+func (s *llamaServer) KVCacheSize() uint64 {
+	return s.estimate.kv
+}
+
 func (s *llamaServer) GetDeviceInfos(ctx context.Context) []ml.DeviceInfo {
 	slog.Debug("llamarunner free vram reporting not supported")
 	return nil
@@ -1778,6 +1910,24 @@ func (s *ollamaServer) TotalSize() uint64 {
 	return mem
 }
 
+func (s *ollamaServer) KVCacheSize() uint64 {
+	if s.mem == nil {
+		return 0
+	}
+
+	var mem uint64
+	for _, c := range s.mem.CPU.Cache {
+		mem += c
+	}
+	for _, g := range s.mem.GPUs {
+		for _, c := range g.Cache {
+			mem += c
+		}
+	}
+
+	return mem
+}
+
 func (s *ollamaServer) VRAMByGPU(id ml.DeviceID) uint64 {
 	if s.mem == nil {
 		return 0
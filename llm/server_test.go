@@ -2,13 +2,23 @@ package llm
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"os/exec"
+	"strconv"
 	"strings"
 	"testing"
 
+	"github.com/google/go-cmp/cmp"
+
 	"github.com/ollama/ollama/api"
 	"github.com/ollama/ollama/format"
+	"github.com/ollama/ollama/fs/ggml"
 	"github.com/ollama/ollama/ml"
 	"golang.org/x/sync/semaphore"
 )
@@ -232,6 +242,10 @@ func TestLLMServerCompletionFormat(t *testing.T) {
 		// JSON
 		`"json"`,
 		`{"type":"object"}`,
+
+		// regex and choice
+		`{"type":"regex","pattern":"[0-9]+"}`,
+		`{"type":"choice","values":["yes","no"]}`,
 	}
 	for _, valid := range valids {
 		err := s.Completion(ctx, CompletionRequest{
@@ -247,3 +261,200 @@ func TestLLMServerCompletionFormat(t *testing.T) {
 	}, nil)
 	checkValid(err)
 }
+
+func TestLLMServerCompletionGrammarTrigger(t *testing.T) {
+	var responses []CompletionResponse
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /health", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(ServerStatusResponse{Status: ServerStatusReady})
+	})
+	mux.HandleFunc("POST /completion", func(w http.ResponseWriter, r *http.Request) {
+		for _, cr := range responses {
+			json.NewEncoder(w).Encode(cr)
+		}
+	})
+
+	ts := httptest.NewServer(mux)
+	t.Cleanup(ts.Close)
+
+	u, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	port, err := strconv.Atoi(u.Port())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &llmServer{
+		port: port,
+		cmd:  &exec.Cmd{},
+		sem:  semaphore.NewWeighted(1),
+	}
+
+	for _, tt := range []struct {
+		name      string
+		responses []CompletionResponse
+		err       string
+	}{
+		{
+			name: "triggered",
+			responses: []CompletionResponse{
+				{Content: "hmm</th"},
+				{Content: "ink>{}"},
+				{Done: true, DoneReason: DoneReasonStop},
+			},
+		},
+		{
+			name: "stopped while thinking",
+			responses: []CompletionResponse{
+				{Content: "hmm"},
+				{Done: true, DoneReason: DoneReasonStop},
+			},
+			err: ErrGrammarNotTriggered.Error(),
+		},
+		{
+			name: "length while thinking",
+			responses: []CompletionResponse{
+				{Content: "hmm"},
+				{Done: true, DoneReason: DoneReasonLength},
+			},
+			err: "num_predict",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			responses = tt.responses
+
+			var done bool
+			err := s.Completion(t.Context(), CompletionRequest{
+				Options:        new(api.Options),
+				Grammar:        `root ::= "{}"`,
+				GrammarTrigger: "</think>",
+			}, func(cr CompletionResponse) {
+				done = done || cr.Done
+			})
+
+			if tt.err == "" {
+				if err != nil || !done {
+					t.Errorf("expected a complete response, got %v", err)
+				}
+			} else if err == nil || !strings.Contains(err.Error(), tt.err) || done {
+				t.Errorf("expected an error containing %q, got %v", tt.err, err)
+			}
+		})
+	}
+}
+
+func TestKVCacheType(t *testing.T) {
+	tests := []struct {
+		name           string
+		env            string
+		option         string
+		flashAttention bool
+		ollamaEngine   bool
+		expected       string
+		expectedErr    bool
+	}{
+		{name: "Default", flashAttention: true, ollamaEngine: true},
+		{name: "Environment", env: "q8_0", flashAttention: true, ollamaEngine: true, expected: "q8_0"},
+		{name: "Option Overrides Environment", env: "q8_0", option: "q4_0", flashAttention: true, ollamaEngine: true, expected: "q4_0"},
+		{name: "Per Layer", option: "q4_0,k=q8_0,first=2,last=2", flashAttention: true, ollamaEngine: true, expected: "k=q8_0,v=q4_0,first=2,last=2"},
+		{name: "Per Layer Llama Engine", option: "q8_0,first=2", flashAttention: true, expected: "q8_0"},
+		{name: "No Flash Attention", option: "q8_0", ollamaEngine: true, expectedErr: true},
+		{name: "F16 Without Flash Attention", option: "f16", ollamaEngine: true},
+		{name: "Unknown", option: "q2_k", flashAttention: true, ollamaEngine: true, expectedErr: true},
+	}
+
+	f := &ggml.GGML{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("OLLAMA_KV_CACHE_TYPE", tt.env)
+
+			opts := api.DefaultOptions()
+			opts.KvCacheType = tt.option
+
+			kvct, err := kvCacheType(f, opts, tt.flashAttention, tt.ollamaEngine)
+			if (err != nil) != tt.expectedErr {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if kvct != tt.expected {
+				t.Errorf("kvCacheType: have %q; want %q", kvct, tt.expected)
+			}
+		})
+	}
+}
+
+func TestRoPEScaling(t *testing.T) {
+	models := make(map[string]*ggml.GGML)
+	for _, arch := range []string{"llama", "qwen3", "gemma3"} {
+		f, err := os.CreateTemp(t.TempDir(), "model")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+
+		if err := ggml.WriteGGUF(f, ggml.KV{
+			"general.architecture":   arch,
+			arch + ".context_length": uint32(8192),
+		}, nil); err != nil {
+			t.Fatal(err)
+		}
+
+		models[arch], err = LoadModel(f.Name(), 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name         string
+		arch         string
+		legacyEngine bool
+		numCtx       int
+		typ          string
+		factor       float32
+		original     int
+		expected     ml.RoPEScaling
+		expectedErr  bool
+	}{
+		{name: "Unset", numCtx: 32768},
+		{name: "Factor", factor: 2, expected: ml.RoPEScaling{Type: "linear", Factor: 2, OriginalContextLength: 8192}},
+		{name: "Factor From Context", numCtx: 32768, typ: "yarn", expected: ml.RoPEScaling{Type: "yarn", Factor: 4, OriginalContextLength: 8192}},
+		{name: "Original Context", numCtx: 32768, typ: "static-ntk", original: 4096, expected: ml.RoPEScaling{Type: "static-ntk", Factor: 8, OriginalContextLength: 4096}},
+		{name: "Smaller Context", numCtx: 2048, typ: "yarn", expected: ml.RoPEScaling{Type: "yarn", Factor: 1, OriginalContextLength: 8192}},
+		{name: "None", numCtx: 32768, typ: "none", expected: ml.RoPEScaling{Type: "none", Factor: 1, OriginalContextLength: 8192}},
+		{name: "Unknown Type", typ: "longrope", expectedErr: true},
+		{name: "Factor Too Small", factor: 0.5, expectedErr: true},
+		{name: "Original Context Too Large", typ: "yarn", original: 16384, expectedErr: true},
+		{name: "Partial Support", arch: "qwen3", numCtx: 32768, typ: "yarn", expected: ml.RoPEScaling{Type: "yarn", Factor: 4, OriginalContextLength: 8192}},
+		{name: "Type Not Implemented", arch: "qwen3", numCtx: 32768, typ: "static-ntk", expectedErr: true},
+		{name: "Architecture Not Supported", arch: "gemma3", numCtx: 32768, typ: "linear", expectedErr: true},
+		{name: "Legacy Engine", legacyEngine: true, numCtx: 32768, typ: "linear", expectedErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := api.DefaultOptions()
+			opts.NumCtx = tt.numCtx
+			opts.RopeScalingType = tt.typ
+			opts.RopeScalingFactor = tt.factor
+			opts.RopeOriginalContext = tt.original
+
+			arch := tt.arch
+			if arch == "" {
+				arch = "llama"
+			}
+
+			scaling, err := ropeScaling(models[arch], opts, !tt.legacyEngine)
+			if (err != nil) != tt.expectedErr {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if diff := cmp.Diff(tt.expected, scaling); diff != "" {
+				t.Errorf("ropeScaling mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
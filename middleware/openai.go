@@ -7,6 +7,7 @@ import (
 	"io"
 	"math/rand"
 	"net/http"
+	"slices"
 	"strings"
 
 	"github.com/gin-gonic/gin"
@@ -24,6 +25,7 @@ type ChatWriter struct {
 	streamOptions *openai.StreamOptions
 	id            string
 	toolCallSent  bool
+	streamedCalls map[string]struct{}
 	BaseWriter
 }
 
@@ -74,6 +76,19 @@ func (w *ChatWriter) writeResponse(data []byte) (int, error) {
 
 	// chat chunk
 	if w.stream {
+		// calls whose arguments were streamed as deltas have already been
+		// sent in full
+		for _, d := range chatResponse.Message.ToolCallDeltas {
+			if w.streamedCalls == nil {
+				w.streamedCalls = make(map[string]struct{})
+			}
+			w.streamedCalls[d.ID] = struct{}{}
+		}
+		chatResponse.Message.ToolCalls = slices.DeleteFunc(chatResponse.Message.ToolCalls, func(tc api.ToolCall) bool {
+			_, ok := w.streamedCalls[tc.ID]
+			return ok
+		})
+
 		c := openai.ToChunk(w.id, chatResponse, w.toolCallSent)
 		d, err := json.Marshal(c)
 		if err != nil {
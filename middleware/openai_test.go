@@ -515,6 +515,97 @@ func TestChatMiddleware(t *testing.T) {
 				Stream: &True,
 			},
 		},
+		{
+			name: "chat handler with tool_choice",
+			body: `{
+				"model": "test-model",
+				"messages": [
+					{"role": "user", "content": "What's the weather like in Paris?"}
+				],
+				"tools": [{
+					"type": "function",
+					"function": {
+						"name": "get_weather",
+						"parameters": {"type": "object"}
+					}
+				}],
+				"tool_choice": {"type": "function", "function": {"name": "get_weather"}}
+			}`,
+			req: api.ChatRequest{
+				Model: "test-model",
+				Messages: []api.Message{
+					{
+						Role:    "user",
+						Content: "What's the weather like in Paris?",
+					},
+				},
+				Tools: []api.Tool{
+					{
+						Type: "function",
+						Function: api.ToolFunction{
+							Name:       "get_weather",
+							Parameters: api.ToolFunctionParameters{Type: "object"},
+						},
+					},
+				},
+				ToolChoice: &api.ToolChoice{Function: "get_weather"},
+				Options: map[string]any{
+					"temperature": 1.0,
+					"top_p":       1.0,
+				},
+				Stream: &False,
+			},
+		},
+		{
+			name: "chat handler with regex response_format",
+			body: `{
+				"model": "test-model",
+				"messages": [
+					{"role": "user", "content": "What's the area code of Seattle?"}
+				],
+				"response_format": {"type": "regex", "pattern": "[0-9]{3}"}
+			}`,
+			req: api.ChatRequest{
+				Model: "test-model",
+				Messages: []api.Message{
+					{
+						Role:    "user",
+						Content: "What's the area code of Seattle?",
+					},
+				},
+				Options: map[string]any{
+					"temperature": 1.0,
+					"top_p":       1.0,
+				},
+				Format: json.RawMessage(`{"pattern":"[0-9]{3}","type":"regex"}`),
+				Stream: &False,
+			},
+		},
+		{
+			name: "chat handler with choice response_format",
+			body: `{
+				"model": "test-model",
+				"messages": [
+					{"role": "user", "content": "Is this review positive? I loved it."}
+				],
+				"response_format": {"type": "choice", "values": ["yes", "no"]}
+			}`,
+			req: api.ChatRequest{
+				Model: "test-model",
+				Messages: []api.Message{
+					{
+						Role:    "user",
+						Content: "Is this review positive? I loved it.",
+					},
+				},
+				Options: map[string]any{
+					"temperature": 1.0,
+					"top_p":       1.0,
+				},
+				Format: json.RawMessage(`{"type":"choice","values":["yes","no"]}`),
+				Stream: &False,
+			},
+		},
 		{
 			name: "chat handler error forwarding",
 			body: `{
@@ -927,3 +1018,152 @@ func TestRetrieveMiddleware(t *testing.T) {
 		}
 	}
 }
+
+func TestChatWriterToolCallDeltas(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	rec := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(rec)
+	w := &ChatWriter{stream: true, id: "chatcmpl-1", BaseWriter: BaseWriter{ResponseWriter: c.Writer}}
+
+	responses := []api.ChatResponse{
+		{Message: api.Message{Role: "assistant", ToolCallDeltas: []api.ToolCallDelta{{ID: "call_1", Name: "get_weather", Arguments: `{"city": `}}}},
+		{
+			Message: api.Message{
+				Role:           "assistant",
+				ToolCallDeltas: []api.ToolCallDelta{{ID: "call_1", Arguments: `"Paris"}`}},
+				ToolCalls: []api.ToolCall{
+					{ID: "call_1", Function: api.ToolCallFunction{Name: "get_weather", Arguments: api.ToolCallFunctionArguments{"city": "Paris"}}},
+					{ID: "call_2", Function: api.ToolCallFunction{Index: 1, Name: "other", Arguments: api.ToolCallFunctionArguments{}}},
+				},
+			},
+			Done:       true,
+			DoneReason: "stop",
+		},
+	}
+
+	for _, r := range responses {
+		bts, err := json.Marshal(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(bts); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var chunks []openai.ChatCompletionChunk
+	for _, line := range strings.Split(rec.Body.String(), "\n") {
+		data, ok := strings.CutPrefix(line, "data: ")
+		if !ok || data == "[DONE]" {
+			continue
+		}
+		var chunk openai.ChatCompletionChunk
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			t.Fatal(err)
+		}
+		chunks = append(chunks, chunk)
+	}
+
+	if len(chunks) != 2 {
+		t.Fatalf("expected 2 chunks, got %d", len(chunks))
+	}
+
+	var ids []string
+	var args strings.Builder
+	for _, chunk := range chunks {
+		for _, tc := range chunk.Choices[0].Delta.ToolCalls {
+			if tc.ID != "" {
+				ids = append(ids, tc.ID)
+			}
+			if tc.Index == 0 {
+				args.WriteString(tc.Function.Arguments)
+			}
+		}
+	}
+
+	// the streamed call isn't repeated, the other one is sent whole
+	if diff := cmp.Diff([]string{"call_1", "call_2"}, ids); diff != "" {
+		t.Errorf("tool call ids mismatch (-want +got):\n%s", diff)
+	}
+
+	if args.String() != `{"city": "Paris"}` {
+		t.Errorf("unexpected arguments %q", args.String())
+	}
+
+	if reason := chunks[1].Choices[0].FinishReason; reason == nil || *reason != "tool_calls" {
+		t.Errorf("expected tool_calls finish reason, got %v", reason)
+	}
+}
+
+func TestChatWriterToolCallErrors(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	r := api.ChatResponse{
+		Message: api.Message{
+			Role: "assistant",
+			ToolCalls: []api.ToolCall{{
+				ID:       "call_1",
+				Function: api.ToolCallFunction{Name: "get_weather", Arguments: api.ToolCallFunctionArguments{"days": "many"}},
+				Errors:   []api.ToolCallError{{Path: "/days", Message: "expected integer"}},
+			}},
+		},
+		Done:       true,
+		DoneReason: "stop",
+	}
+
+	bts, err := json.Marshal(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []api.ToolCallError{{Path: "/days", Message: "expected integer"}}
+
+	t.Run("completion", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(rec)
+		w := &ChatWriter{id: "chatcmpl-1", BaseWriter: BaseWriter{ResponseWriter: c.Writer}}
+		if _, err := w.Write(bts); err != nil {
+			t.Fatal(err)
+		}
+
+		var completion openai.ChatCompletion
+		if err := json.Unmarshal(rec.Body.Bytes(), &completion); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(completion.Choices) != 1 || len(completion.Choices[0].Message.ToolCalls) != 1 {
+			t.Fatalf("expected one tool call, got %+v", completion.Choices)
+		}
+
+		if diff := cmp.Diff(want, completion.Choices[0].Message.ToolCalls[0].Errors); diff != "" {
+			t.Errorf("tool call errors mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("chunk", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(rec)
+		w := &ChatWriter{stream: true, id: "chatcmpl-1", BaseWriter: BaseWriter{ResponseWriter: c.Writer}}
+		if _, err := w.Write(bts); err != nil {
+			t.Fatal(err)
+		}
+
+		data, ok := strings.CutPrefix(strings.Split(rec.Body.String(), "\n")[0], "data: ")
+		if !ok {
+			t.Fatalf("unexpected response %q", rec.Body.String())
+		}
+
+		var chunk openai.ChatCompletionChunk
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(chunk.Choices) != 1 || len(chunk.Choices[0].Delta.ToolCalls) != 1 {
+			t.Fatalf("expected one tool call, got %+v", chunk.Choices)
+		}
+
+		if diff := cmp.Diff(want, chunk.Choices[0].Delta.ToolCalls[0].Errors); diff != "" {
+			t.Errorf("tool call errors mismatch (-want +got):\n%s", diff)
+		}
+	})
+}
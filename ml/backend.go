@@ -75,6 +75,23 @@ type BackendParams struct {
 
 	// FlashAttention indicates that we should use a fused flash attention kernel
 	FlashAttention bool
+
+	// RoPEScaling overrides the RoPE scaling in the model metadata
+	RoPEScaling RoPEScaling
+}
+
+// RoPEScaling describes how rotary position embeddings are extended beyond
+// the context length the model was trained on. The zero value uses the
+// settings from the model metadata.
+type RoPEScaling struct {
+	// Type is one of "none", "linear", "yarn" or "static-ntk"
+	Type string
+
+	// Factor is the ratio of the extended context length to OriginalContextLength
+	Factor float32
+
+	// OriginalContextLength is the context length the model was trained on
+	OriginalContextLength int
 }
 
 var backends = make(map[string]func(string, BackendParams) (Backend, error))
@@ -132,6 +132,15 @@ func New(modelPath string, params ml.BackendParams) (ml.Backend, error) {
 		return nil, err
 	}
 
+	// Models read RoPE scaling from their metadata so overrides are applied there
+	if rs := params.RoPEScaling; rs.Type != "" {
+		kv := meta.KV()
+		arch := kv.Architecture()
+		kv[arch+".rope.scaling.type"] = rs.Type
+		kv[arch+".rope.scaling.factor"] = rs.Factor
+		kv[arch+".rope.scaling.original_context_length"] = uint32(rs.OriginalContextLength)
+	}
+
 	once.Do(func() {
 		slog.Info(
 			"",
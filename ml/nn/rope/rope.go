@@ -1,6 +1,11 @@
 package rope
 
-import "github.com/ollama/ollama/ml"
+import (
+	"math"
+
+	"github.com/ollama/ollama/fs"
+	"github.com/ollama/ollama/ml"
+)
 
 // Options contains optional parameters for RoPE function
 type Options struct {
@@ -70,3 +75,53 @@ func WithInterleaveMRoPE(sections []int) func(*Options) {
 		opts.MRoPE.Sections = sections
 	}
 }
+
+// Scaling describes how RoPE is extended beyond the context length that a
+// model was trained on
+type Scaling struct {
+	// Type is one of "none", "linear", "yarn" or "static-ntk". If empty, Factor
+	// is applied as linear scaling.
+	Type                  string
+	Factor                float32
+	OriginalContextLength int
+}
+
+// ScalingFromConfig reads the RoPE scaling from the model metadata
+func ScalingFromConfig(c fs.Config) Scaling {
+	return Scaling{
+		Type:                  c.String("rope.scaling.type"),
+		Factor:                c.Float("rope.scaling.factor", 1),
+		OriginalContextLength: int(c.Uint("rope.scaling.original_context_length", c.Uint("context_length"))),
+	}
+}
+
+// Apply returns the base frequency, frequency scale and options to pass to
+// RoPE for dim rotary dimensions of a model with the given base frequency
+func (s Scaling) Apply(base float32, dim int) (float32, float32, []func(*Options)) {
+	factor := s.Factor
+	if factor <= 0 {
+		factor = 1
+	}
+
+	switch s.Type {
+	case "none":
+		return base, 1, nil
+	case "yarn":
+		return base, 1 / factor, []func(*Options){
+			WithOriginalContextLength(s.OriginalContextLength),
+			WithExtrapolationFactor(1),
+		}
+	case "static-ntk":
+		// NTK-aware scaling increases the base frequency so that the lowest
+		// frequency covers the extended context. The base is fixed for the
+		// extended length. Dynamic NTK scaling, which recomputes it from the
+		// length of each sequence, isn't implemented since keys in the cache
+		// would have been rotated with a different base.
+		if dim > 2 {
+			base *= float32(math.Pow(float64(factor), float64(dim)/float64(dim-2)))
+		}
+		return base, 1, nil
+	default:
+		return base, 1 / factor, nil
+	}
+}
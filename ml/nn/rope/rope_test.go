@@ -0,0 +1,45 @@
+package rope
+
+import (
+	"math"
+	"testing"
+)
+
+func TestScalingApply(t *testing.T) {
+	tests := []struct {
+		name     string
+		scaling  Scaling
+		base     float32
+		scale    float32
+		original int
+	}{
+		{name: "Unset", base: 10000, scale: 1},
+		{name: "None", scaling: Scaling{Type: "none", Factor: 4}, base: 10000, scale: 1},
+		{name: "Linear", scaling: Scaling{Type: "linear", Factor: 4}, base: 10000, scale: 0.25},
+		{name: "Legacy", scaling: Scaling{Factor: 2}, base: 10000, scale: 0.5},
+		{name: "YaRN", scaling: Scaling{Type: "yarn", Factor: 4, OriginalContextLength: 8192}, base: 10000, scale: 0.25, original: 8192},
+		{name: "Static NTK", scaling: Scaling{Type: "static-ntk", Factor: 4}, base: float32(10000 * math.Pow(4, 128./126.)), scale: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			base, scale, fns := tt.scaling.Apply(10000, 128)
+			if math.Abs(float64(base-tt.base)) > 1e-3*float64(tt.base) {
+				t.Errorf("base: have %v; want %v", base, tt.base)
+			}
+
+			if scale != tt.scale {
+				t.Errorf("scale: have %v; want %v", scale, tt.scale)
+			}
+
+			var opts Options
+			for _, fn := range fns {
+				fn(&opts)
+			}
+
+			if opts.YaRN.OriginalContextLength != tt.original {
+				t.Errorf("original context length: have %v; want %v", opts.YaRN.OriginalContextLength, tt.original)
+			}
+		})
+	}
+}
@@ -17,7 +17,8 @@ import (
 type Options struct {
 	hiddenSize, numHeads, numKVHeads int
 	headDim, ropeDim                 int
-	eps, ropeBase, ropeScale         float32
+	eps, ropeBase                    float32
+	ropeScaling                      rope.Scaling
 }
 
 type Model struct {
@@ -88,18 +89,18 @@ func New(c fs.Config) (model.Model, error) {
 		TextProcessor: processor,
 		Layers:        make([]Layer, c.Uint("block_count")),
 		Options: Options{
-			hiddenSize: int(c.Uint("embedding_length")),
-			numHeads:   int(c.Uint("attention.head_count")),
-			numKVHeads: int(c.Uint("attention.head_count_kv")),
-			headDim:    int(c.Uint("attention.key_length")),
-			ropeDim:    int(c.Uint("rope.dimension_count")),
-			eps:        c.Float("attention.layer_norm_rms_epsilon"),
-			ropeBase:   c.Float("rope.freq_base", 1e5),
-			ropeScale:  c.Float("rope.scaling.factor", 1),
+			hiddenSize:  int(c.Uint("embedding_length")),
+			numHeads:    int(c.Uint("attention.head_count")),
+			numKVHeads:  int(c.Uint("attention.head_count_kv")),
+			headDim:     int(c.Uint("attention.key_length")),
+			ropeDim:     int(c.Uint("rope.dimension_count")),
+			eps:         c.Float("attention.layer_norm_rms_epsilon"),
+			ropeBase:    c.Float("rope.freq_base", 1e5),
+			ropeScaling: rope.ScalingFromConfig(c),
 		},
 	}
 
-	m.Cache = kvcache.NewCausalCache(m.Shift)
+	m.Cache = kvcache.NewFullAttentionCache(m.Shift)
 
 	return &m, nil
 }
@@ -126,8 +127,8 @@ func (sa *SelfAttention) Forward(ctx ml.Context, hiddenState, positions ml.Tenso
 	value := sa.Value.Forward(ctx, hiddenState)
 	value = value.Reshape(ctx, headDim, opts.numKVHeads, batchSize)
 
-	query = fast.RoPE(ctx, query, positions, ropeDim, opts.ropeBase, 1./opts.ropeScale, rope.WithFactors(sa.RopeFactors))
-	key = fast.RoPE(ctx, key, positions, ropeDim, opts.ropeBase, 1./opts.ropeScale, rope.WithFactors(sa.RopeFactors))
+	query = opts.applyRotaryPositionEmbeddings(ctx, query, positions, ropeDim, sa.RopeFactors)
+	key = opts.applyRotaryPositionEmbeddings(ctx, key, positions, ropeDim, sa.RopeFactors)
 
 	attention := nn.Attention(ctx, query, key, value, 1.0/math.Sqrt(float64(headDim)), cache)
 	attention = attention.Reshape(ctx, headDim*opts.numHeads, batchSize)
@@ -136,7 +137,12 @@ func (sa *SelfAttention) Forward(ctx ml.Context, hiddenState, positions ml.Tenso
 
 func (m *Model) Shift(ctx ml.Context, layer int, key, shift ml.Tensor) (ml.Tensor, error) {
 	ropeDim := cmp.Or(m.ropeDim, m.hiddenSize/m.numHeads)
-	return fast.RoPE(ctx, key, shift, ropeDim, m.ropeBase, 1./m.ropeScale, rope.WithFactors(m.Layers[layer].SelfAttention.RopeFactors)), nil
+	return m.applyRotaryPositionEmbeddings(ctx, key, shift, ropeDim, m.Layers[layer].SelfAttention.RopeFactors), nil
+}
+
+func (o Options) applyRotaryPositionEmbeddings(ctx ml.Context, states, positions ml.Tensor, ropeDim int, factors ml.Tensor) ml.Tensor {
+	base, scale, opts := o.ropeScaling.Apply(o.ropeBase, ropeDim)
+	return fast.RoPE(ctx, states, positions, ropeDim, base, scale, append(opts, rope.WithFactors(factors))...)
 }
 
 type MLP struct {
@@ -252,7 +252,7 @@ func New(c fs.Config) (model.Model, error) {
 		},
 	}
 
-	m.Cache = kvcache.NewCausalCache(m.Shift)
+	m.Cache = kvcache.NewFullAttentionCache(m.Shift)
 	return &m, nil
 }
 
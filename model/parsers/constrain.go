@@ -0,0 +1,78 @@
+package parsers
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/harmony"
+)
+
+// ToolCallSyntax describes how a model writes a call to a tool: the literal
+// text before and after the call and the JSON schema of what's in between
+type ToolCallSyntax struct {
+	Prefix string
+	Schema json.RawMessage
+	Suffix string
+}
+
+// ToolCallConstrainer is implemented by parsers whose tool calls can be
+// enforced with a grammar, such as when a request requires a tool call
+type ToolCallConstrainer interface {
+	// ToolCallSyntax returns the syntax of a call to each of tools, which are
+	// the tools returned by Init. It's called after Init, so the syntax can
+	// depend on the parser's state, and returns nil if the parser's tool calls
+	// can't be described as JSON
+	ToolCallSyntax(tools []api.Tool) []ToolCallSyntax
+}
+
+// ToolCallSyntaxFor returns the syntax of a call to each of tools for p, or
+// nil if p's tool calls can't be constrained
+func ToolCallSyntaxFor(p Parser, tools []api.Tool) []ToolCallSyntax {
+	switch p := p.(type) {
+	case ToolCallConstrainer:
+		return p.ToolCallSyntax(tools)
+	case *harmony.HarmonyMessageHandler:
+		syntax := make([]ToolCallSyntax, len(tools))
+		for i, tool := range tools {
+			syntax[i] = ToolCallSyntax{
+				Prefix: p.ToolCallPrefix(tool.Function.Name),
+				Schema: argumentsSchema(tool),
+			}
+		}
+		return syntax
+	default:
+		return nil
+	}
+}
+
+// JSONToolCallSyntax returns the syntax of tool calls written as a JSON object
+// with the function's name and its arguments under argsKey, between prefix
+// and suffix
+func JSONToolCallSyntax(prefix, suffix, argsKey string, tools []api.Tool) []ToolCallSyntax {
+	key, _ := json.Marshal(argsKey)
+
+	syntax := make([]ToolCallSyntax, len(tools))
+	for i, tool := range tools {
+		name, _ := json.Marshal(tool.Function.Name)
+
+		// the schema is written by hand as a map wouldn't keep the order of
+		// the properties, which is the order the model writes them in
+		schema := fmt.Sprintf(`{"type":"object","properties":{"name":{"const":%s},%s:%s},"required":["name",%s]}`, name, key, argumentsSchema(tool), key)
+		syntax[i] = ToolCallSyntax{Prefix: prefix, Schema: json.RawMessage(schema), Suffix: suffix}
+	}
+	return syntax
+}
+
+// argumentsSchema returns the JSON schema of the arguments of a call to tool
+func argumentsSchema(tool api.Tool) json.RawMessage {
+	if tool.Function.Parameters.Type == "" && len(tool.Function.Parameters.Properties) == 0 {
+		return json.RawMessage(`{"type":"object"}`)
+	}
+
+	bts, err := json.Marshal(tool.Function.Parameters)
+	if err != nil {
+		return json.RawMessage(`{"type":"object"}`)
+	}
+	return bts
+}
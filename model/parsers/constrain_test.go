@@ -0,0 +1,99 @@
+package parsers
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/ollama/ollama/api"
+)
+
+// TestToolCallSyntax checks that a call written in the syntax each parser
+// reports, after the parser's answer start when the call is in the answer, is
+// parsed back by the parser
+func TestToolCallSyntax(t *testing.T) {
+	cases := []struct {
+		parser string
+		start  string
+		prefix string
+		suffix string
+		body   string
+	}{
+		{parser: "hermes", prefix: "<tool_call>", suffix: "</tool_call>", body: `{"name": "get_weather", "arguments": {"city": "Paris"}}`},
+		{parser: "qwen3-vl-instruct", prefix: "<tool_call>\n", suffix: "\n</tool_call>", body: `{"name": "get_weather", "arguments": {"city": "Paris"}}`},
+		{parser: "qwen3-vl-thinking", start: "</think>", prefix: "<tool_call>\n", suffix: "\n</tool_call>", body: `{"name": "get_weather", "arguments": {"city": "Paris"}}`},
+		{parser: "llama3", body: `{"name": "get_weather", "parameters": {"city": "Paris"}}`},
+		{parser: "mistral", prefix: "[TOOL_CALLS][", suffix: "]", body: `{"name": "get_weather", "arguments": {"city": "Paris"}}`},
+		{parser: "deepseek", prefix: "<｜tool▁calls▁begin｜><｜tool▁call▁begin｜>get_weather<｜tool▁sep｜>", suffix: "<｜tool▁call▁end｜><｜tool▁calls▁end｜>", body: `{"city": "Paris"}`},
+		{parser: "harmony", prefix: "<|channel|>commentary to=functions.get_weather <|constrain|>json<|message|>", body: `{"city": "Paris"}`},
+		{parser: "thinking_end </think>\nthinking_open true\ntool_call_start <tool_call>\ntool_call_end </tool_call>", start: "</think>", prefix: "<tool_call>", suffix: "</tool_call>", body: `{"name": "get_weather", "arguments": {"city": "Paris"}}`},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.parser, func(t *testing.T) {
+			p := ParserForName(tt.parser)
+			tools := p.Init(weatherTools, nil)
+
+			syntax := ToolCallSyntaxFor(p, tools)
+			if len(syntax) != 1 {
+				t.Fatalf("expected syntax for 1 tool, got %d", len(syntax))
+			}
+
+			if syntax[0].Prefix != tt.prefix || syntax[0].Suffix != tt.suffix {
+				t.Errorf("got prefix %q and suffix %q, want %q and %q", syntax[0].Prefix, syntax[0].Suffix, tt.prefix, tt.suffix)
+			}
+
+			if tt.parser != "harmony" {
+				if start := p.(AnswerStarter).AnswerStart(); start != tt.start {
+					t.Errorf("got answer start %q, want %q", start, tt.start)
+				}
+			}
+
+			if !json.Valid(syntax[0].Schema) {
+				t.Fatalf("invalid schema %s", syntax[0].Schema)
+			}
+
+			testParser(t, func() Parser { return ParserForName(tt.parser) }, []parserTest{{
+				name:  "round trip",
+				tools: weatherTools,
+				input: tt.start + syntax[0].Prefix + tt.body + syntax[0].Suffix,
+				calls: indexed(weatherCall(map[string]any{"city": "Paris"})),
+			}})
+		})
+	}
+
+	for _, name := range []string{"qwen3-coder", "gemma3", "passthrough"} {
+		p := ParserForName(name)
+		if syntax := ToolCallSyntaxFor(p, p.Init(weatherTools, nil)); syntax != nil {
+			t.Errorf("expected no syntax for %s, got %v", name, syntax)
+		}
+	}
+}
+
+func TestJSONToolCallSyntax(t *testing.T) {
+	tools := []api.Tool{weatherTools[0], {Type: "function", Function: api.ToolFunction{Name: `say "hi"`}}}
+
+	syntax := JSONToolCallSyntax("<tool_call>", "</tool_call>", "arguments", tools)
+	if len(syntax) != 2 {
+		t.Fatalf("expected 2 tool call syntaxes, got %d", len(syntax))
+	}
+
+	expected := `{"type":"object","properties":{"name":{"const":"say \"hi\""},"arguments":{"type":"object"}},"required":["name","arguments"]}`
+	if string(syntax[1].Schema) != expected {
+		t.Errorf("got schema %s, want %s", syntax[1].Schema, expected)
+	}
+
+	var schema struct {
+		Properties struct {
+			Arguments struct {
+				Properties map[string]any `json:"properties"`
+			} `json:"arguments"`
+		} `json:"properties"`
+	}
+	if err := json.Unmarshal(syntax[0].Schema, &schema); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(schema.Properties.Arguments.Properties) != 2 {
+		t.Errorf("expected the tool's parameters in the schema, got %s", syntax[0].Schema)
+	}
+}
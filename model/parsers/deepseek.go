@@ -0,0 +1,96 @@
+package parsers
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/ollama/ollama/api"
+)
+
+const (
+	deepseekToolCallsBegin = "<｜tool▁calls▁begin｜>"
+	deepseekToolCallBegin  = "<｜tool▁call▁begin｜>"
+	deepseekToolCallEnd    = "<｜tool▁call▁end｜>"
+	deepseekToolCallsEnd   = "<｜tool▁calls▁end｜>"
+	deepseekToolSep        = "<｜tool▁sep｜>"
+)
+
+var deepseekToolCallRegex = regexp.MustCompile(`(?s)<｜tool▁call▁begin｜>(.*?)(?:<｜tool▁call▁end｜>|$)`)
+
+// NewDeepSeekParser returns a parser for DeepSeek V3 and R1. Thinking is in
+// <think></think> tags at the start of the response and tool calls use
+// DeepSeek's tool call tokens, either as
+// function<｜tool▁sep｜>name followed by a fenced JSON block (V3) or as
+// name<｜tool▁sep｜>{...} (V3.1).
+func NewDeepSeekParser() *SpecParser {
+	p := NewSpecParser(Spec{
+		ThinkingStart:  "<think>",
+		ThinkingEnd:    "</think>",
+		ToolCallStart:  deepseekToolCallsBegin,
+		ToolCallEnd:    deepseekToolCallsEnd,
+		ToolCallFormat: "json",
+	})
+	p.decodeToolCalls = parseDeepSeekToolCalls
+	p.newArgsScanner = func(tools []api.Tool) argsScanner {
+		return &sepArgsScanner{tools: tools, sep: deepseekToolSep, name: deepseekToolCallName}
+	}
+	p.toolCallSyntax = func(tools []api.Tool) []ToolCallSyntax {
+		syntax := make([]ToolCallSyntax, len(tools))
+		for i, tool := range tools {
+			syntax[i] = ToolCallSyntax{
+				Prefix: deepseekToolCallsBegin + deepseekToolCallBegin + tool.Function.Name + deepseekToolSep,
+				Schema: argumentsSchema(tool),
+				Suffix: deepseekToolCallEnd + deepseekToolCallsEnd,
+			}
+		}
+		return syntax
+	}
+	return p
+}
+
+// splitDeepSeekToolCall splits the text of a call after
+// <｜tool▁call▁begin｜> into its name and arguments
+func splitDeepSeekToolCall(s string) (name, args string, ok bool) {
+	name, args, ok = strings.Cut(s, deepseekToolSep)
+	if strings.TrimSpace(name) == "function" {
+		name, args, _ = strings.Cut(args, "\n")
+	}
+
+	args = strings.TrimSpace(args)
+	args = strings.TrimPrefix(args, "```json")
+	args = strings.TrimSuffix(args, "```")
+	return strings.TrimSpace(name), args, ok
+}
+
+func deepseekToolCallName(head string) string {
+	if i := strings.LastIndex(head, deepseekToolCallBegin); i >= 0 {
+		head = head[i+len(deepseekToolCallBegin):]
+	}
+	name, _, _ := splitDeepSeekToolCall(head)
+	return name
+}
+
+func parseDeepSeekToolCalls(raw string, _ []api.Tool) ([]api.ToolCall, error) {
+	var calls []api.ToolCall
+	for _, m := range deepseekToolCallRegex.FindAllStringSubmatch(raw, -1) {
+		name, args, ok := splitDeepSeekToolCall(m[1])
+		if !ok {
+			return nil, fmt.Errorf("tool call is missing %s", deepseekToolSep)
+		}
+
+		var arguments api.ToolCallFunctionArguments
+		if err := json.Unmarshal([]byte(args), &arguments); err != nil {
+			return nil, err
+		}
+
+		calls = append(calls, api.ToolCall{Function: api.ToolCallFunction{Name: name, Arguments: arguments}})
+	}
+
+	if len(calls) == 0 {
+		return nil, fmt.Errorf("no tool calls")
+	}
+
+	return calls, nil
+}
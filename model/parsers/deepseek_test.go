@@ -0,0 +1,43 @@
+package parsers
+
+import (
+	"testing"
+)
+
+func TestDeepSeekParser(t *testing.T) {
+	testParser(t, func() Parser { return NewDeepSeekParser() }, []parserTest{
+		{
+			name:    "content",
+			input:   "Hello, world!",
+			content: "Hello, world!",
+		},
+		{
+			name:     "thinking",
+			input:    "<think>\nTwo plus two is four.\n</think>\n\nThe answer is 4.",
+			thinking: "Two plus two is four.",
+			content:  "The answer is 4.",
+		},
+		{
+			name:     "v3 tool calls",
+			tools:    weatherTools,
+			input:    "<think>I should check.</think>Checking.<｜tool▁calls▁begin｜><｜tool▁call▁begin｜>function<｜tool▁sep｜>get_weather\n```json\n{\"city\": \"Paris\"}\n```<｜tool▁call▁end｜>\n<｜tool▁call▁begin｜>function<｜tool▁sep｜>get_weather\n```json\n{\"city\": \"London\"}\n```<｜tool▁call▁end｜><｜tool▁calls▁end｜>",
+			thinking: "I should check.",
+			content:  "Checking.",
+			calls:    indexed(weatherCall(map[string]any{"city": "Paris"}), weatherCall(map[string]any{"city": "London"})),
+		},
+		{
+			name:    "v3.1 tool calls",
+			tools:   weatherTools,
+			input:   "<｜tool▁calls▁begin｜><｜tool▁call▁begin｜>get_weather<｜tool▁sep｜>{\"city\": \"Paris\", \"days\": 2}<｜tool▁call▁end｜><｜tool▁call▁begin｜>get_weather<｜tool▁sep｜>{\"city\": \"London\"}<｜tool▁call▁end｜><｜tool▁calls▁end｜>",
+			content: "",
+			calls:   indexed(weatherCall(map[string]any{"city": "Paris", "days": float64(2)}), weatherCall(map[string]any{"city": "London"})),
+		},
+		{
+			name:    "unterminated tool calls",
+			tools:   weatherTools,
+			input:   "<｜tool▁calls▁begin｜><｜tool▁call▁begin｜>get_weather<｜tool▁sep｜>{\"city\": \"Paris\"}",
+			content: "",
+			calls:   indexed(weatherCall(map[string]any{"city": "Paris"})),
+		},
+	})
+}
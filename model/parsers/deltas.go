@@ -0,0 +1,478 @@
+package parsers
+
+import (
+	"encoding/json"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/ollama/ollama/api"
+)
+
+// argsScanner finds tool calls in the partial text of a tool call region so
+// their arguments can be streamed before the calls are complete. Calls are
+// only streamed if they're for one of the tools given to the scanner.
+type argsScanner interface {
+	// scan is given the text of the region so far and returns deltas for
+	// any arguments that haven't been returned yet
+	scan(raw string) []api.ToolCallDelta
+
+	// reset starts a new region, the first call of which has the given index
+	reset(index int)
+}
+
+// switchArgsScanner streams calls with one of several scanners, chosen by how
+// the region starts
+type switchArgsScanner struct {
+	scanners []argsScanner
+	// choose returns the scanner for a region and the offset of the calls
+	// in it, or nil if it can't tell yet
+	choose func(raw string) (argsScanner, int)
+
+	active argsScanner
+	offset int
+}
+
+func (s *switchArgsScanner) reset(index int) {
+	for _, scanner := range s.scanners {
+		scanner.reset(index)
+	}
+	s.active, s.offset = nil, 0
+}
+
+func (s *switchArgsScanner) scan(raw string) []api.ToolCallDelta {
+	if s.active == nil {
+		if s.active, s.offset = s.choose(raw); s.active == nil {
+			return nil
+		}
+	}
+
+	return s.active.scan(raw[s.offset:])
+}
+
+// jsonArgsScanner streams the arguments of JSON tool calls in the form
+// {"name": "get_weather", "arguments": {...}}, which may also be in a list.
+// Arguments are streamed as they're written once the call's name is known,
+// and are decoded first if they're a JSON string.
+type jsonArgsScanner struct {
+	tools []api.Tool
+	index int
+
+	pos      int
+	depth    int
+	inString bool
+	escape   bool
+
+	// the current call object, which is at callDepth
+	inCall    bool
+	callDepth int
+	expectKey bool
+	capture   bool
+	str       strings.Builder
+	key       string
+	name      string
+	started   bool
+
+	// argsStart is where the arguments not yet streamed start
+	inArgs       bool
+	inStringArgs bool
+	argsStart    int
+}
+
+func newJSONArgsScanner(tools []api.Tool) *jsonArgsScanner {
+	return &jsonArgsScanner{tools: tools}
+}
+
+func (s *jsonArgsScanner) reset(index int) {
+	*s = jsonArgsScanner{tools: s.tools, index: index}
+}
+
+func (s *jsonArgsScanner) scan(raw string) []api.ToolCallDelta {
+	var deltas []api.ToolCallDelta
+	for ; s.pos < len(raw); s.pos++ {
+		c := raw[s.pos]
+		if s.inString {
+			switch {
+			case s.escape:
+				s.escape = false
+			case c == '\\':
+				s.escape = true
+			case c == '"':
+				s.inString = false
+				if s.inStringArgs {
+					deltas = s.appendDelta(deltas, decodeJSONString(raw[s.argsStart:s.pos]))
+					s.inStringArgs = false
+				}
+				if s.capture {
+					s.endString()
+				}
+				continue
+			}
+
+			if s.capture {
+				s.str.WriteByte(c)
+			}
+			continue
+		}
+
+		switch c {
+		case '"':
+			s.inString = true
+			s.capture = s.inCall && !s.inArgs && s.depth == s.callDepth
+			s.str.Reset()
+			if s.capture && !s.expectKey && s.isArgs() {
+				s.inStringArgs, s.argsStart = true, s.pos+1
+			}
+		case ',':
+			if s.inCall && s.depth == s.callDepth {
+				s.expectKey = true
+			}
+		case '{', '[':
+			s.depth++
+			if c == '{' && !s.inCall {
+				s.inCall, s.callDepth, s.expectKey = true, s.depth, true
+				s.key, s.name, s.started = "", "", false
+			} else if c == '{' && s.inCall && !s.inArgs && s.depth == s.callDepth+1 && s.isArgs() {
+				s.inArgs, s.argsStart = true, s.pos
+			}
+		case '}', ']':
+			s.depth--
+			if s.inArgs && s.depth == s.callDepth {
+				deltas = s.appendDelta(deltas, raw[s.argsStart:s.pos+1])
+				s.inArgs = false
+			}
+
+			if s.inCall && s.depth < s.callDepth {
+				s.inCall = false
+				if s.name != "" {
+					s.index++
+				}
+			}
+		}
+	}
+
+	switch {
+	case s.inArgs && s.argsStart < len(raw):
+		deltas = s.appendDelta(deltas, raw[s.argsStart:])
+		s.argsStart = len(raw)
+	case s.inStringArgs:
+		if end := s.argsStart + decodableLen(raw[s.argsStart:]); end > s.argsStart {
+			deltas = s.appendDelta(deltas, decodeJSONString(raw[s.argsStart:end]))
+			s.argsStart = end
+		}
+	}
+
+	return deltas
+}
+
+// isArgs reports whether the current key is for the arguments of a call to
+// a known tool
+func (s *jsonArgsScanner) isArgs() bool {
+	return (s.key == "arguments" || s.key == "parameters") && findTool(s.tools, s.name) != nil
+}
+
+func (s *jsonArgsScanner) endString() {
+	s.capture = false
+	if s.expectKey {
+		s.key, s.expectKey = s.str.String(), false
+	} else if s.key == "name" {
+		s.name = s.str.String()
+	}
+}
+
+func (s *jsonArgsScanner) appendDelta(deltas []api.ToolCallDelta, args string) []api.ToolCallDelta {
+	delta := api.ToolCallDelta{Index: s.index, Arguments: args}
+	if !s.started {
+		delta.Name, s.started = s.name, true
+	}
+	return append(deltas, delta)
+}
+
+// decodeJSONString decodes the contents of a JSON string
+func decodeJSONString(s string) string {
+	var decoded string
+	if err := json.Unmarshal([]byte(`"`+s+`"`), &decoded); err != nil {
+		return ""
+	}
+	return decoded
+}
+
+// decodableLen returns the length of the prefix of the partial JSON string
+// contents s that doesn't end in an incomplete escape or surrogate pair
+func decodableLen(s string) int {
+	n := 0
+	for i := 0; i < len(s); {
+		switch {
+		case s[i] != '\\':
+			i++
+		case i+1 >= len(s):
+			return n
+		case s[i+1] != 'u':
+			i += 2
+		case i+6 > len(s):
+			return n
+		default:
+			if r, err := strconv.ParseUint(s[i+2:i+6], 16, 16); err == nil && r >= 0xd800 && r < 0xdc00 {
+				if i+12 > len(s) {
+					return n
+				}
+				i += 6
+			}
+			i += 6
+		}
+		n = i
+	}
+	return n
+}
+
+// sepArgsScanner streams the arguments of tool calls written as a name, a
+// separator and a JSON object, such as get_weather[ARGS]{"city": "Paris"}
+type sepArgsScanner struct {
+	tools []api.Tool
+	sep   string
+	// name returns the name of a call given the text before its arguments
+	name func(head string) string
+
+	index int
+
+	pos      int
+	inArgs   bool
+	current  string
+	started  bool
+	depth    int
+	inString bool
+	escape   bool
+}
+
+func (s *sepArgsScanner) reset(index int) {
+	*s = sepArgsScanner{tools: s.tools, sep: s.sep, name: s.name, index: index}
+}
+
+func (s *sepArgsScanner) scan(raw string) []api.ToolCallDelta {
+	var deltas []api.ToolCallDelta
+	for s.pos < len(raw) {
+		if !s.inArgs {
+			i := strings.Index(raw[s.pos:], s.sep)
+			if i < 0 {
+				break
+			}
+
+			j := strings.IndexByte(raw[s.pos+i+len(s.sep):], '{')
+			if j < 0 {
+				break
+			}
+
+			brace := s.pos + i + len(s.sep) + j
+			s.current, s.started = s.name(raw[s.pos:brace]), false
+			s.inArgs, s.depth, s.pos = true, 0, brace
+		}
+
+		start := s.pos
+		for ; s.pos < len(raw) && s.inArgs; s.pos++ {
+			c := raw[s.pos]
+			switch {
+			case s.inString && s.escape:
+				s.escape = false
+			case s.inString && c == '\\':
+				s.escape = true
+			case c == '"':
+				s.inString = !s.inString
+			case s.inString:
+			case c == '{' || c == '[':
+				s.depth++
+			case c == '}' || c == ']':
+				s.depth--
+				s.inArgs = s.depth > 0
+			}
+		}
+
+		if findTool(s.tools, s.current) != nil {
+			delta := api.ToolCallDelta{Index: s.index, Arguments: raw[start:s.pos]}
+			if !s.started {
+				delta.Name, s.started = s.current, true
+			}
+			deltas = append(deltas, delta)
+		}
+
+		if !s.inArgs {
+			s.index++
+		}
+	}
+
+	return deltas
+}
+
+var (
+	xmlFunctionStartRegex = regexp.MustCompile(`<function=([^>]+)>`)
+	xmlParameterRegex     = regexp.MustCompile(`(?s)<parameter=([^>]+)>(.*?)</parameter>`)
+)
+
+// xmlArgsScanner streams the arguments of tool calls in the format used by
+// Qwen3-Coder a parameter at a time, converting them to JSON the same way
+// parseToolCall does
+type xmlArgsScanner struct {
+	tools []api.Tool
+	index int
+
+	pos    int
+	inCall bool
+	tool   *api.Tool
+	params int
+}
+
+func newXMLArgsScanner(tools []api.Tool) *xmlArgsScanner {
+	return &xmlArgsScanner{tools: tools}
+}
+
+func (s *xmlArgsScanner) reset(index int) {
+	*s = xmlArgsScanner{tools: s.tools, index: index}
+}
+
+func (s *xmlArgsScanner) scan(raw string) []api.ToolCallDelta {
+	var deltas []api.ToolCallDelta
+	for {
+		rest := raw[s.pos:]
+		if !s.inCall {
+			m := xmlFunctionStartRegex.FindStringSubmatchIndex(rest)
+			if m == nil {
+				return deltas
+			}
+
+			name := rest[m[2]:m[3]]
+			s.inCall, s.tool, s.params = true, findTool(s.tools, name), 0
+			s.pos += m[1]
+			if s.tool != nil {
+				deltas = append(deltas, api.ToolCallDelta{Index: s.index, Name: name, Arguments: "{"})
+			}
+			continue
+		}
+
+		end := strings.Index(rest, "</function>")
+		if m := xmlParameterRegex.FindStringSubmatchIndex(rest); m != nil && (end < 0 || m[0] < end) {
+			key := rest[m[2]:m[3]]
+			value, err := json.Marshal(parseValue(rest[m[4]:m[5]], paramType(s.tool, key)))
+			if err != nil {
+				value = []byte("null")
+			}
+
+			if s.tool != nil {
+				var sb strings.Builder
+				if s.params > 0 {
+					sb.WriteString(", ")
+				}
+				k, _ := json.Marshal(key)
+				sb.Write(k)
+				sb.WriteString(": ")
+				sb.Write(value)
+				deltas = append(deltas, api.ToolCallDelta{Index: s.index, Arguments: sb.String()})
+			}
+
+			s.params++
+			s.pos += m[1]
+			continue
+		}
+
+		if end >= 0 {
+			if s.tool != nil {
+				deltas = append(deltas, api.ToolCallDelta{Index: s.index, Arguments: "}"})
+			}
+			s.inCall = false
+			s.index++
+			s.pos += end + len("</function>")
+			continue
+		}
+
+		return deltas
+	}
+}
+
+// pythonArgsScanner streams the arguments of Python style tool calls an
+// argument at a time, once each argument is complete
+type pythonArgsScanner struct {
+	tools []api.Tool
+	index int
+
+	pos    int
+	inCall bool
+	known  bool
+	params int
+}
+
+func newPythonArgsScanner(tools []api.Tool) *pythonArgsScanner {
+	return &pythonArgsScanner{tools: tools}
+}
+
+func (s *pythonArgsScanner) reset(index int) {
+	*s = pythonArgsScanner{tools: s.tools, index: index}
+}
+
+func (s *pythonArgsScanner) scan(raw string) []api.ToolCallDelta {
+	var deltas []api.ToolCallDelta
+	for {
+		p := pythonParser{s: raw, i: s.pos}
+		if !s.inCall {
+			// skip to the start of the next call in a list or on a new line
+			for !p.eof() && strings.IndexByte(" \t\r\n[,", p.s[p.i]) >= 0 {
+				p.i++
+			}
+
+			name := p.name()
+			if name == "" || !p.accept('(') {
+				return deltas
+			}
+
+			s.inCall, s.known, s.params = true, findTool(s.tools, name) != nil, 0
+			s.pos = p.i
+			if s.known {
+				deltas = append(deltas, api.ToolCallDelta{Index: s.index, Name: name, Arguments: "{"})
+			}
+			continue
+		}
+
+		p.skipSpace()
+		if p.accept(')') {
+			if s.known {
+				deltas = append(deltas, api.ToolCallDelta{Index: s.index, Arguments: "}"})
+			}
+			s.inCall = false
+			s.index++
+			s.pos = p.i
+			continue
+		}
+
+		key := p.name()
+		if key == "" || !p.accept('=') {
+			return deltas
+		}
+
+		v, err := p.value()
+		if err != nil {
+			return deltas
+		}
+
+		// the value is only known to be complete once something follows it
+		if p.skipSpace(); p.eof() {
+			return deltas
+		}
+		p.accept(',')
+
+		if s.known {
+			var sb strings.Builder
+			if s.params > 0 {
+				sb.WriteString(", ")
+			}
+			k, _ := json.Marshal(key)
+			value, err := json.Marshal(v)
+			if err != nil {
+				value = []byte("null")
+			}
+			sb.Write(k)
+			sb.WriteString(": ")
+			sb.Write(value)
+			deltas = append(deltas, api.ToolCallDelta{Index: s.index, Arguments: sb.String()})
+		}
+
+		s.params++
+		s.pos = p.i
+	}
+}
@@ -0,0 +1,96 @@
+package parsers
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/ollama/ollama/api"
+)
+
+func TestToolCallDeltas(t *testing.T) {
+	cases := []struct {
+		name     string
+		parser   Parser
+		chunks   []string
+		expected []api.ToolCallDelta
+	}{
+		{
+			name:   "json",
+			parser: NewHermesParser(),
+			chunks: []string{"<tool_call>\n{\"name\": \"get_weather\", \"arg", "uments\": {\"city\": \"Par", "is\"}}\n</tool_call>"},
+			expected: []api.ToolCallDelta{
+				{Index: 0, Name: "get_weather", Arguments: `{"city": "Par`},
+				{Index: 0, Arguments: `is"}`},
+			},
+		},
+		{
+			name:   "json string",
+			parser: NewHermesParser(),
+			chunks: []string{"<tool_call>{\"name\": \"get_weather\", \"arguments\": \"{\\\"city\\\": \\\"Par", "is\\u00", "21\\\"}\"}</tool_call>"},
+			expected: []api.ToolCallDelta{
+				{Index: 0, Name: "get_weather", Arguments: `{"city": "Par`},
+				{Index: 0, Arguments: `is`},
+				{Index: 0, Arguments: `!"}`},
+			},
+		},
+		{
+			name:   "unknown tool",
+			parser: NewHermesParser(),
+			chunks: []string{"<tool_call>{\"name\": \"get_time\", \"arguments\": {}}</tool_call><tool_call>{\"name\": \"get_weather\", \"arguments\": {}}</tool_call>"},
+			expected: []api.ToolCallDelta{
+				{Index: 1, Name: "get_weather", Arguments: `{}`},
+			},
+		},
+		{
+			name:   "args",
+			parser: NewMistralParser(),
+			chunks: []string{`[TOOL_CALLS]get_weather[ARGS]{"city": "Paris"}[TOOL_CALLS]get_`, `weather[ARGS]{"city": `, `"London"}`},
+			expected: []api.ToolCallDelta{
+				{Index: 0, Name: "get_weather", Arguments: `{"city": "Paris"}`},
+				{Index: 1, Name: "get_weather", Arguments: `{"city": `},
+				{Index: 1, Arguments: `"London"}`},
+			},
+		},
+		{
+			name:   "xml",
+			parser: &Qwen3CoderParser{},
+			chunks: []string{"<tool_call>\n<function=get_weather>\n<parameter=city>\nParis\n</parameter>\n<param", "eter=days>\n3\n</parameter>\n</function>\n</tool_call>"},
+			expected: []api.ToolCallDelta{
+				{Index: 0, Name: "get_weather", Arguments: `{`},
+				{Index: 0, Arguments: `"city": "Paris"`},
+				{Index: 0, Arguments: `, "days": 3`},
+				{Index: 0, Arguments: `}`},
+			},
+		},
+		{
+			name:   "python",
+			parser: NewGemma3Parser(),
+			chunks: []string{"```tool_code\nget_weather(city=\"Paris\", days=1", "2)\n```"},
+			expected: []api.ToolCallDelta{
+				{Index: 0, Name: "get_weather", Arguments: `{`},
+				{Index: 0, Arguments: `"city": "Paris"`},
+				{Index: 0, Arguments: `, "days": 12`},
+				{Index: 0, Arguments: `}`},
+			},
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.parser.Init(weatherTools, nil)
+
+			var deltas []api.ToolCallDelta
+			for i, chunk := range tt.chunks {
+				if _, _, _, err := tt.parser.Add(chunk, i == len(tt.chunks)-1); err != nil {
+					t.Fatal(err)
+				}
+				deltas = append(deltas, tt.parser.(ToolCallStreamer).ToolCallDeltas()...)
+			}
+
+			if diff := cmp.Diff(tt.expected, deltas); diff != "" {
+				t.Errorf("mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
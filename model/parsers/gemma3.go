@@ -0,0 +1,11 @@
+package parsers
+
+// NewGemma3Parser returns a parser for Gemma 3, which calls tools with Python
+// function calls in a ```tool_code block
+func NewGemma3Parser() *SpecParser {
+	return NewSpecParser(Spec{
+		ToolCallStart:  "```tool_code",
+		ToolCallEnd:    "```",
+		ToolCallFormat: "python",
+	})
+}
@@ -0,0 +1,30 @@
+package parsers
+
+import (
+	"testing"
+)
+
+func TestGemma3Parser(t *testing.T) {
+	testParser(t, func() Parser { return NewGemma3Parser() }, []parserTest{
+		{
+			name:    "content",
+			tools:   weatherTools,
+			input:   "Here is some code:\n```python\nprint(1)\n```",
+			content: "Here is some code:\n```python\nprint(1)\n```",
+		},
+		{
+			name:    "tool call",
+			tools:   weatherTools,
+			input:   "```tool_code\nget_weather(city=\"Paris\", days=2)\n```",
+			content: "",
+			calls:   indexed(weatherCall(map[string]any{"city": "Paris", "days": 2})),
+		},
+		{
+			name:    "multiple tool calls",
+			tools:   weatherTools,
+			input:   "Let me check.\n```tool_code\nget_weather(city=\"Paris\")\nget_weather(city=\"London\")\n```",
+			content: "Let me check.",
+			calls:   indexed(weatherCall(map[string]any{"city": "Paris"}), weatherCall(map[string]any{"city": "London"})),
+		},
+	})
+}
@@ -0,0 +1,12 @@
+package parsers
+
+// NewHermesParser returns a parser for the Hermes tool calling format used by
+// Hermes 2 Pro and later and many other fine-tunes, where each call is a JSON
+// object in <tool_call></tool_call> tags
+func NewHermesParser() *SpecParser {
+	return NewSpecParser(Spec{
+		ToolCallStart:  "<tool_call>",
+		ToolCallEnd:    "</tool_call>",
+		ToolCallFormat: "json",
+	})
+}
@@ -0,0 +1,42 @@
+package parsers
+
+import (
+	"testing"
+)
+
+func TestHermesParser(t *testing.T) {
+	testParser(t, func() Parser { return NewHermesParser() }, []parserTest{
+		{
+			name:    "content",
+			tools:   weatherTools,
+			input:   "Hello, world!",
+			content: "Hello, world!",
+		},
+		{
+			name:    "tool call",
+			tools:   weatherTools,
+			input:   "<tool_call>\n{\"name\": \"get_weather\", \"arguments\": {\"city\": \"Paris\"}}\n</tool_call>",
+			content: "",
+			calls:   indexed(weatherCall(map[string]any{"city": "Paris"})),
+		},
+		{
+			name:    "content and tool calls",
+			tools:   weatherTools,
+			input:   "Let me check both.\n<tool_call>\n{\"name\": \"get_weather\", \"arguments\": {\"city\": \"Paris\", \"days\": 2}}\n</tool_call>\n<tool_call>\n{\"name\": \"get_weather\", \"arguments\": {\"city\": \"London\"}}\n</tool_call>",
+			content: "Let me check both.",
+			calls:   indexed(weatherCall(map[string]any{"city": "Paris", "days": float64(2)}), weatherCall(map[string]any{"city": "London"})),
+		},
+		{
+			name:    "string arguments",
+			tools:   weatherTools,
+			input:   "<tool_call>\n{\"name\": \"get_weather\", \"arguments\": \"{\\\"city\\\": \\\"S\\u00e3o Paulo \\ud83c\\udf27\\\"}\"}\n</tool_call>",
+			content: "",
+			calls:   indexed(weatherCall(map[string]any{"city": "São Paulo 🌧"})),
+		},
+		{
+			name:    "tags in content",
+			input:   "Use <tool> and <tool_ tags",
+			content: "Use <tool> and <tool_ tags",
+		},
+	})
+}
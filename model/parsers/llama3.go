@@ -0,0 +1,59 @@
+package parsers
+
+import (
+	"strings"
+	"unicode"
+
+	"github.com/ollama/ollama/api"
+)
+
+const llama3PythonTag = "<|python_tag|>"
+
+// NewLlama3Parser returns a parser for Llama 3.1 and later. Tool calls are
+// JSON objects with a name and parameters at the start of the response,
+// optionally after <|python_tag|> and separated by semicolons. Llama 3.2's
+// Python style list of calls is also accepted.
+func NewLlama3Parser() *SpecParser {
+	p := NewSpecParser(Spec{ToolCallFormat: "json"})
+	p.decodeToolCalls = parseLlama3ToolCalls
+	p.toolCallPrefixes = []string{llama3PythonTag, "{", "["}
+	p.newArgsScanner = func(tools []api.Tool) argsScanner {
+		jsonScanner, pythonScanner := newJSONArgsScanner(tools), newPythonArgsScanner(tools)
+		return &switchArgsScanner{
+			scanners: []argsScanner{jsonScanner, pythonScanner},
+			choose: func(raw string) (argsScanner, int) {
+				offset := len(raw) - len(strings.TrimLeftFunc(raw, unicode.IsSpace))
+				if strings.HasPrefix(raw[offset:], llama3PythonTag) {
+					offset += len(llama3PythonTag)
+				}
+
+				switch trimmed := strings.TrimSpace(raw[offset:]); {
+				case trimmed == "", trimmed == "[":
+					return nil, 0
+				case trimmed[0] == '{', strings.HasPrefix(strings.TrimSpace(trimmed[1:]), "{"):
+					return jsonScanner, offset
+				default:
+					return pythonScanner, offset
+				}
+			},
+		}
+	}
+	p.toolCallSyntax = func(tools []api.Tool) []ToolCallSyntax {
+		return JSONToolCallSyntax("", "", "parameters", tools)
+	}
+	return p
+}
+
+func parseLlama3ToolCalls(raw string, _ []api.Tool) ([]api.ToolCall, error) {
+	raw = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(raw), llama3PythonTag))
+
+	calls, err := parseJSONToolCalls(raw)
+	if err != nil {
+		if calls, perr := parsePythonToolCalls(raw); perr == nil {
+			return calls, nil
+		}
+		return nil, err
+	}
+
+	return calls, nil
+}
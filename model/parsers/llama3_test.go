@@ -0,0 +1,54 @@
+package parsers
+
+import (
+	"testing"
+)
+
+func TestLlama3Parser(t *testing.T) {
+	testParser(t, func() Parser { return NewLlama3Parser() }, []parserTest{
+		{
+			name:    "content",
+			tools:   weatherTools,
+			input:   "It's sunny in Paris.",
+			content: "It's sunny in Paris.",
+		},
+		{
+			name:    "json tool call",
+			tools:   weatherTools,
+			input:   `{"name": "get_weather", "parameters": {"city": "Paris"}}`,
+			content: "",
+			calls:   indexed(weatherCall(map[string]any{"city": "Paris"})),
+		},
+		{
+			name:    "python tag",
+			tools:   weatherTools,
+			input:   `<|python_tag|>{"type": "function", "name": "get_weather", "parameters": {"city": "Paris"}}; {"name": "get_weather", "parameters": {"city": "Lyon; France"}}`,
+			content: "",
+			calls:   indexed(weatherCall(map[string]any{"city": "Paris"}), weatherCall(map[string]any{"city": "Lyon; France"})),
+		},
+		{
+			name:    "python list",
+			tools:   weatherTools,
+			input:   `[get_weather(city="Paris", days=2), get_weather(city="London")]`,
+			content: "",
+			calls:   indexed(weatherCall(map[string]any{"city": "Paris", "days": 2}), weatherCall(map[string]any{"city": "London"})),
+		},
+		{
+			name:    "json content",
+			tools:   weatherTools,
+			input:   `{"answer": 4}`,
+			content: `{"answer": 4}`,
+		},
+		{
+			name:    "json later in content",
+			tools:   weatherTools,
+			input:   `Call it like {"name": "get_weather"}`,
+			content: `Call it like {"name": "get_weather"}`,
+		},
+		{
+			name:    "without tools",
+			input:   `{"name": "get_weather", "parameters": {"city": "Paris"}}`,
+			content: `{"name": "get_weather", "parameters": {"city": "Paris"}}`,
+		},
+	})
+}
@@ -0,0 +1,91 @@
+package parsers
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/ollama/ollama/api"
+)
+
+const mistralToolCallsTag = "[TOOL_CALLS]"
+
+// NewMistralParser returns a parser for Mistral models. Tool calls follow
+// [TOOL_CALLS], either as a JSON list of calls or, with newer tokenizers, as
+// name[ARGS]{...} with each call having its own [TOOL_CALLS] token.
+func NewMistralParser() *SpecParser {
+	p := NewSpecParser(Spec{
+		ToolCallStart:  mistralToolCallsTag,
+		ToolCallFormat: "json",
+	})
+	p.decodeToolCalls = parseMistralToolCalls
+	p.newArgsScanner = func(tools []api.Tool) argsScanner {
+		jsonScanner := newJSONArgsScanner(tools)
+		args := &sepArgsScanner{tools: tools, sep: "[ARGS]", name: mistralToolCallName}
+		return &switchArgsScanner{
+			scanners: []argsScanner{jsonScanner, args},
+			choose: func(raw string) (argsScanner, int) {
+				switch trimmed := strings.TrimSpace(raw); {
+				case trimmed == "":
+					return nil, 0
+				case trimmed[0] == '[' || trimmed[0] == '{':
+					return jsonScanner, 0
+				default:
+					return args, 0
+				}
+			},
+		}
+	}
+	p.toolCallSyntax = func(tools []api.Tool) []ToolCallSyntax {
+		return JSONToolCallSyntax(mistralToolCallsTag+"[", "]", "arguments", tools)
+	}
+	return p
+}
+
+// mistralToolCallName returns the name of a call written as
+// name[CALL_ID]id[ARGS], which may be preceded by [TOOL_CALLS]
+func mistralToolCallName(head string) string {
+	head, _, _ = strings.Cut(head, "[ARGS]")
+	if i := strings.LastIndex(head, mistralToolCallsTag); i >= 0 {
+		head = head[i+len(mistralToolCallsTag):]
+	}
+	head, _, _ = strings.Cut(head, "[CALL_ID]")
+	return strings.TrimSpace(head)
+}
+
+func parseMistralToolCalls(raw string, _ []api.Tool) ([]api.ToolCall, error) {
+	var calls []api.ToolCall
+	for _, part := range strings.Split(raw, mistralToolCallsTag) {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		name, args, ok := strings.Cut(part, "[ARGS]")
+		if !ok {
+			c, err := parseJSONToolCalls(part)
+			if err != nil {
+				return nil, err
+			}
+			calls = append(calls, c...)
+			continue
+		}
+
+		if name = mistralToolCallName(name); name == "" {
+			return nil, fmt.Errorf("tool call is missing a name")
+		}
+
+		var arguments api.ToolCallFunctionArguments
+		if err := json.Unmarshal([]byte(args), &arguments); err != nil {
+			return nil, err
+		}
+
+		calls = append(calls, api.ToolCall{Function: api.ToolCallFunction{Name: name, Arguments: arguments}})
+	}
+
+	if len(calls) == 0 {
+		return nil, fmt.Errorf("no tool calls")
+	}
+
+	return calls, nil
+}
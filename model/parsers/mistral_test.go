@@ -0,0 +1,39 @@
+package parsers
+
+import (
+	"testing"
+)
+
+func TestMistralParser(t *testing.T) {
+	testParser(t, func() Parser { return NewMistralParser() }, []parserTest{
+		{
+			name:    "content",
+			tools:   weatherTools,
+			input:   "The weather is [mostly] sunny.",
+			content: "The weather is [mostly] sunny.",
+		},
+		{
+			name:    "json tool calls",
+			tools:   weatherTools,
+			input:   `[TOOL_CALLS][{"name": "get_weather", "arguments": {"city": "Paris"}}, {"name": "get_weather", "arguments": {"city": "London", "days": 3}}]`,
+			content: "",
+			calls:   indexed(weatherCall(map[string]any{"city": "Paris"}), weatherCall(map[string]any{"city": "London", "days": float64(3)})),
+		},
+		{
+			name:    "args tool calls",
+			tools:   weatherTools,
+			input:   `Checking.[TOOL_CALLS]get_weather[ARGS]{"city": "Paris"}[TOOL_CALLS]get_weather[CALL_ID]a1b2c3d4e[ARGS]{"city": "London"}`,
+			content: "Checking.",
+			calls:   indexed(weatherCall(map[string]any{"city": "Paris"}), weatherCall(map[string]any{"city": "London"})),
+		},
+	})
+}
+
+func TestMistralParserError(t *testing.T) {
+	p := NewMistralParser()
+	p.Init(weatherTools, nil)
+
+	if _, _, _, err := p.Add(`[TOOL_CALLS]get_weather[ARGS]{"city": `, true); err == nil {
+		t.Error("expected error")
+	}
+}
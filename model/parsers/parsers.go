@@ -1,6 +1,8 @@
 package parsers
 
 import (
+	"log/slog"
+
 	"github.com/ollama/ollama/api"
 	"github.com/ollama/ollama/harmony"
 )
@@ -16,6 +18,23 @@ type Parser interface {
 	HasThinkingSupport() bool
 }
 
+// ToolCallStreamer is implemented by parsers that stream the arguments of tool
+// calls while they're being generated. The complete calls returned by Add have
+// the same indexes as their deltas.
+type ToolCallStreamer interface {
+	// ToolCallDeltas returns the deltas parsed by the last call to Add
+	ToolCallDeltas() []api.ToolCallDelta
+}
+
+// AnswerStarter is implemented by parsers that know the text a thinking model
+// writes when it starts its answer, so the answer can be constrained on its own
+type AnswerStarter interface {
+	// AnswerStart returns the text that starts the answer, or "" if it isn't
+	// known to come next, such as when the model isn't thinking. It's called
+	// after Init.
+	AnswerStart() string
+}
+
 type ParserConstructor func() Parser
 
 type ParserRegistry struct {
@@ -48,11 +67,29 @@ func ParserForName(name string) Parser {
 	case "qwen3-vl-thinking":
 		parser := &Qwen3VLParser{hasThinkingSupport: true}
 		return parser
+	case "llama3":
+		return NewLlama3Parser()
+	case "mistral":
+		return NewMistralParser()
+	case "hermes":
+		return NewHermesParser()
+	case "deepseek":
+		return NewDeepSeekParser()
+	case "gemma3":
+		return NewGemma3Parser()
 	case "passthrough":
 		return &PassthroughParser{}
 	case "harmony":
 		return harmony.NewHarmonyMessageHandler()
 	default:
+		if IsSpec(name) {
+			spec, err := ParseSpec(name)
+			if err != nil {
+				slog.Warn("invalid parser spec", "error", err)
+				return nil
+			}
+			return NewSpecParser(*spec)
+		}
 		return nil
 	}
 }
@@ -1,8 +1,12 @@
 package parsers
 
 import (
+	"encoding/json"
+	"strings"
 	"testing"
 
+	"github.com/google/go-cmp/cmp"
+
 	"github.com/ollama/ollama/api"
 )
 
@@ -55,6 +59,11 @@ func TestBuiltInParsersStillWork(t *testing.T) {
 		{"passthrough"},
 		{"qwen3-coder"},
 		{"harmony"},
+		{"llama3"},
+		{"mistral"},
+		{"hermes"},
+		{"deepseek"},
+		{"gemma3"},
 	}
 
 	for _, tt := range tests {
@@ -95,3 +104,171 @@ func TestUnknownParserReturnsNil(t *testing.T) {
 		t.Error("expected nil for unknown parser")
 	}
 }
+
+type parserTest struct {
+	name     string
+	tools    []api.Tool
+	last     *api.Message
+	input    string
+	content  string
+	thinking string
+	calls    []api.ToolCall
+}
+
+// testParser runs each test's input through a new parser whole, split in two
+// at every byte boundary and one byte at a time, expecting the same result
+// each time
+func testParser(t *testing.T, newParser func() Parser, tests []parserTest) {
+	t.Helper()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			splits := [][]string{{tt.input}}
+			for i := 1; i < len(tt.input); i++ {
+				splits = append(splits, []string{tt.input[:i], tt.input[i:]})
+			}
+
+			bytewise := make([]string, len(tt.input))
+			for i := 0; i < len(tt.input); i++ {
+				bytewise[i] = tt.input[i : i+1]
+			}
+			splits = append(splits, bytewise)
+
+			for _, chunks := range splits {
+				p := newParser()
+				p.Init(tt.tools, tt.last)
+
+				var content, thinking strings.Builder
+				var calls []api.ToolCall
+				var deltas []api.ToolCallDelta
+				for i, chunk := range chunks {
+					c, th, tc, err := p.Add(chunk, i == len(chunks)-1)
+					if err != nil {
+						t.Fatalf("chunks %q: %v", chunks, err)
+					}
+					content.WriteString(c)
+					thinking.WriteString(th)
+					calls = append(calls, tc...)
+					if s, ok := p.(ToolCallStreamer); ok {
+						deltas = append(deltas, s.ToolCallDeltas()...)
+					}
+				}
+
+				if _, ok := p.(ToolCallStreamer); ok {
+					checkDeltas(t, chunks, calls, deltas)
+				}
+
+				if diff := cmp.Diff(tt.content, content.String()); diff != "" {
+					t.Fatalf("content mismatch, chunks %q (-want +got):\n%s", chunks, diff)
+				}
+
+				if diff := cmp.Diff(tt.thinking, thinking.String()); diff != "" {
+					t.Fatalf("thinking mismatch, chunks %q (-want +got):\n%s", chunks, diff)
+				}
+
+				if diff := cmp.Diff(tt.calls, calls); diff != "" {
+					t.Fatalf("tool calls mismatch, chunks %q (-want +got):\n%s", chunks, diff)
+				}
+			}
+		})
+	}
+}
+
+// checkDeltas checks that the deltas streamed for each call add up to its
+// arguments
+func checkDeltas(t *testing.T, chunks []string, calls []api.ToolCall, deltas []api.ToolCallDelta) {
+	t.Helper()
+
+	names := make(map[int]string)
+	args := make(map[int]string)
+	for _, d := range deltas {
+		if _, ok := args[d.Index]; !ok {
+			names[d.Index] = d.Name
+		} else if d.Name != "" {
+			t.Fatalf("chunks %q: name in later delta %+v", chunks, d)
+		}
+		args[d.Index] += d.Arguments
+	}
+
+	for _, call := range calls {
+		if call.Function.Name != "get_weather" {
+			continue
+		}
+
+		if names[call.Function.Index] != call.Function.Name {
+			t.Fatalf("chunks %q: expected deltas for call %d named %q, got %q", chunks, call.Function.Index, call.Function.Name, names[call.Function.Index])
+		}
+
+		var streamed api.ToolCallFunctionArguments
+		if err := json.Unmarshal([]byte(args[call.Function.Index]), &streamed); err != nil {
+			t.Fatalf("chunks %q: streamed arguments %q: %v", chunks, args[call.Function.Index], err)
+		}
+
+		want, _ := json.Marshal(call.Function.Arguments)
+		got, _ := json.Marshal(streamed)
+		if diff := cmp.Diff(string(want), string(got)); diff != "" {
+			t.Fatalf("chunks %q: streamed arguments mismatch (-want +got):\n%s", chunks, diff)
+		}
+	}
+}
+
+// indexed sets the indexes of calls in order
+func indexed(calls ...api.ToolCall) []api.ToolCall {
+	for i := range calls {
+		calls[i].Function.Index = i
+	}
+	return calls
+}
+
+// weatherTools is a get_weather tool shared by the parser tests
+var weatherTools = []api.Tool{
+	{
+		Type: "function",
+		Function: api.ToolFunction{
+			Name: "get_weather",
+			Parameters: api.ToolFunctionParameters{
+				Type: "object",
+				Properties: map[string]api.ToolProperty{
+					"city": {Type: api.PropertyType{"string"}},
+					"days": {Type: api.PropertyType{"integer"}},
+				},
+			},
+		},
+	},
+}
+
+func weatherCall(args api.ToolCallFunctionArguments) api.ToolCall {
+	return api.ToolCall{Function: api.ToolCallFunction{Name: "get_weather", Arguments: args}}
+}
+
+func TestAnswerStart(t *testing.T) {
+	prefill := &api.Message{Role: "assistant", Content: "The answer is"}
+
+	cases := []struct {
+		parser      string
+		lastMessage *api.Message
+		expected    string
+	}{
+		{parser: "qwen3-vl-thinking", expected: "</think>"},
+		{parser: "qwen3-vl-thinking", lastMessage: prefill},
+		{parser: "qwen3-vl-instruct"},
+		{parser: "harmony", expected: "<|channel|>final<|message|>"},
+		{parser: "harmony", lastMessage: &api.Message{Role: "assistant", Thinking: "Let me think"}, expected: "<|channel|>final<|message|>"},
+		{parser: "harmony", lastMessage: prefill},
+		{parser: "thinking_end </think>\nthinking_open true", expected: "</think>"},
+		{parser: "thinking_start <think>\nthinking_end </think>"},
+	}
+
+	for _, tt := range cases {
+		p := ParserForName(tt.parser)
+		p.Init(nil, tt.lastMessage)
+
+		starter, ok := p.(AnswerStarter)
+		if !ok {
+			t.Fatalf("expected %q to implement AnswerStarter", tt.parser)
+		}
+
+		if actual := starter.AnswerStart(); actual != tt.expected {
+			t.Errorf("%q with last message %v: got %q, want %q", tt.parser, tt.lastMessage, actual, tt.expected)
+		}
+	}
+}
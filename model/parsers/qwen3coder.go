@@ -33,6 +33,10 @@ type Qwen3CoderParser struct {
 	state qwenParserState
 	acc   strings.Builder
 	tools []api.Tool
+
+	scanner *xmlArgsScanner
+	deltas  []api.ToolCallDelta
+	index   int
 }
 
 func (p *Qwen3CoderParser) HasToolSupport() bool {
@@ -45,11 +49,23 @@ func (p *Qwen3CoderParser) HasThinkingSupport() bool {
 
 func (p *Qwen3CoderParser) Init(tools []api.Tool, lastMessage *api.Message) []api.Tool {
 	p.tools = tools
+	p.scanner = newXMLArgsScanner(tools)
 	return tools // Qwen doesn't modify tools
 }
 
+func (p *Qwen3CoderParser) ToolCallDeltas() []api.ToolCallDelta {
+	return p.deltas
+}
+
+func (p *Qwen3CoderParser) scanArgs(raw string) {
+	if p.scanner != nil {
+		p.deltas = append(p.deltas, p.scanner.scan(raw)...)
+	}
+}
+
 func (p *Qwen3CoderParser) Add(s string, done bool) (content string, thinking string, calls []api.ToolCall, err error) {
 	p.acc.WriteString(s)
+	p.deltas = nil
 
 	events := p.parseEvents()
 
@@ -58,11 +74,17 @@ func (p *Qwen3CoderParser) Add(s string, done bool) (content string, thinking st
 	for _, event := range events {
 		switch event := event.(type) {
 		case qwenEventRawToolCall:
+			p.scanArgs(event.raw)
 			toolCall, err := parseToolCall(event, p.tools)
 			if err != nil {
 				slog.Warn("qwen tool call parsing failed", "error", err)
 				return "", "", nil, err
 			}
+			toolCall.Function.Index = p.index
+			p.index++
+			if p.scanner != nil {
+				p.scanner.reset(p.index)
+			}
 			toolCalls = append(toolCalls, toolCall)
 		case qwenEventContent:
 			// TODO(drifkin): if the same turn contains multiple interleaved content
@@ -72,6 +94,11 @@ func (p *Qwen3CoderParser) Add(s string, done bool) (content string, thinking st
 		}
 	}
 
+	// stream the arguments of a tool call that isn't complete yet
+	if p.state == qwenParserState_CollectingToolContent {
+		p.scanArgs(p.acc.String())
+	}
+
 	return sb.String(), "", toolCalls, nil
 }
 
@@ -262,35 +289,47 @@ func parseToolCall(raw qwenEventRawToolCall, tools []api.Tool) (api.ToolCall, er
 	}
 
 	// Find the matching tool to get parameter types
-	var matchedTool *api.Tool
-	for i := range tools {
-		if tools[i].Function.Name == functionCall.Name {
-			matchedTool = &tools[i]
-			break
-		}
-	}
+	matchedTool := findTool(tools, functionCall.Name)
 
 	toolCall.Function.Arguments = make(api.ToolCallFunctionArguments)
 	for _, parameter := range functionCall.Parameters {
-		// Look up the parameter type if we found the tool
-		var paramType api.PropertyType
-		if matchedTool != nil && matchedTool.Function.Parameters.Properties != nil {
-			if prop, ok := matchedTool.Function.Parameters.Properties[parameter.Name]; ok {
-				// Handle anyOf by collecting all types from the union
-				if len(prop.AnyOf) > 0 {
-					for _, anyOfProp := range prop.AnyOf {
-						paramType = append(paramType, anyOfProp.Type...)
-					}
-				} else {
-					paramType = prop.Type
-				}
-			}
+		toolCall.Function.Arguments[parameter.Name] = parseValue(parameter.Value, paramType(matchedTool, parameter.Name))
+	}
+
+	return toolCall, nil
+}
+
+func findTool(tools []api.Tool, name string) *api.Tool {
+	for i := range tools {
+		if tools[i].Function.Name == name {
+			return &tools[i]
 		}
+	}
+	return nil
+}
 
-		toolCall.Function.Arguments[parameter.Name] = parseValue(parameter.Value, paramType)
+// paramType returns the type of a tool's parameter, or nil if tool is nil or
+// doesn't have the parameter
+func paramType(tool *api.Tool, name string) api.PropertyType {
+	if tool == nil || tool.Function.Parameters.Properties == nil {
+		return nil
 	}
 
-	return toolCall, nil
+	prop, ok := tool.Function.Parameters.Properties[name]
+	if !ok {
+		return nil
+	}
+
+	// Handle anyOf by collecting all types from the union
+	if len(prop.AnyOf) > 0 {
+		var types api.PropertyType
+		for _, anyOfProp := range prop.AnyOf {
+			types = append(types, anyOfProp.Type...)
+		}
+		return types
+	}
+
+	return prop.Type
 }
 
 // parseValue converts a raw string value to the appropriate type based on the parameter type specification.
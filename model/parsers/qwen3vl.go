@@ -29,6 +29,10 @@ type Qwen3VLParser struct {
 	buffer             strings.Builder
 	tools              []api.Tool
 	hasThinkingSupport bool
+
+	scanner *jsonArgsScanner
+	deltas  []api.ToolCallDelta
+	index   int
 }
 
 func (p *Qwen3VLParser) HasToolSupport() bool {
@@ -56,10 +60,37 @@ func (p *Qwen3VLParser) setInitialState(lastMessage *api.Message) {
 
 func (p *Qwen3VLParser) Init(tools []api.Tool, lastMessage *api.Message) []api.Tool {
 	p.tools = tools
+	p.scanner = newJSONArgsScanner(tools)
 	p.setInitialState(lastMessage)
 	return tools
 }
 
+// ToolCallSyntax returns the syntax of tool calls. A call forced while
+// thinking follows the end of the thinking section, which is returned by
+// AnswerStart.
+func (p *Qwen3VLParser) ToolCallSyntax(tools []api.Tool) []ToolCallSyntax {
+	return JSONToolCallSyntax(toolOpenTag+"\n", "\n"+toolCloseTag, "arguments", tools)
+}
+
+// AnswerStart returns the tag that closes the thinking section when the model
+// is thinking
+func (p *Qwen3VLParser) AnswerStart() string {
+	if p.state == CollectingThinkingContent {
+		return thinkingCloseTag
+	}
+	return ""
+}
+
+func (p *Qwen3VLParser) ToolCallDeltas() []api.ToolCallDelta {
+	return p.deltas
+}
+
+func (p *Qwen3VLParser) scanArgs(raw string) {
+	if p.scanner != nil {
+		p.deltas = append(p.deltas, p.scanner.scan(raw)...)
+	}
+}
+
 type qwenEventThinkingContent struct {
 	content string
 }
@@ -68,6 +99,7 @@ func (qwenEventThinkingContent) isQwenEvent() {}
 
 func (p *Qwen3VLParser) Add(s string, done bool) (content string, thinking string, calls []api.ToolCall, err error) {
 	p.buffer.WriteString(s)
+	p.deltas = nil
 	events := p.parseEvents()
 
 	var toolCalls []api.ToolCall
@@ -76,11 +108,17 @@ func (p *Qwen3VLParser) Add(s string, done bool) (content string, thinking strin
 	for _, event := range events {
 		switch event := event.(type) {
 		case qwenEventRawToolCall:
+			p.scanArgs(event.raw)
 			toolCall, err := parseJSONToolCall(event, p.tools)
 			if err != nil {
 				slog.Warn("qwen tool call parsing failed", "error", err)
 				return "", "", nil, err
 			}
+			toolCall.Function.Index = p.index
+			p.index++
+			if p.scanner != nil {
+				p.scanner.reset(p.index)
+			}
 			toolCalls = append(toolCalls, toolCall)
 		case qwenEventThinkingContent:
 			thinkingSb.WriteString(event.content)
@@ -91,6 +129,11 @@ func (p *Qwen3VLParser) Add(s string, done bool) (content string, thinking strin
 		}
 	}
 
+	// stream the arguments of a tool call that isn't complete yet
+	if p.state == CollectingToolContent {
+		p.scanArgs(p.buffer.String())
+	}
+
 	return contentSb.String(), thinkingSb.String(), toolCalls, nil
 }
 
@@ -0,0 +1,759 @@
+package parsers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/ollama/ollama/api"
+)
+
+// Spec describes how a model formats its thinking and tool calls so that its
+// output can be parsed without a dedicated parser. It is set with the
+// Modelfile PARSER instruction, either as JSON or as one "key value" pair per
+// line using the same keys.
+type Spec struct {
+	// ThinkingStart and ThinkingEnd delimit the model's thinking, which is
+	// only recognized at the start of the output
+	ThinkingStart string `json:"thinking_start,omitempty"`
+	ThinkingEnd   string `json:"thinking_end,omitempty"`
+
+	// ThinkingOpen is set when the template opens the thinking block at the
+	// end of the prompt, so the output starts with thinking
+	ThinkingOpen bool `json:"thinking_open,omitempty"`
+
+	// ToolCallStart and ToolCallEnd delimit tool calls. Without a start
+	// marker, tool calls are only recognized at the start of the output and
+	// without an end marker they continue to the end of the output.
+	ToolCallStart string `json:"tool_call_start,omitempty"`
+	ToolCallEnd   string `json:"tool_call_end,omitempty"`
+
+	// ToolCallFormat is how tool calls are encoded: "json" for objects with a
+	// name and arguments, "xml" for <function=name><parameter=key> tags or
+	// "python" for Python function calls
+	ToolCallFormat string `json:"tool_call_format,omitempty"`
+}
+
+var toolCallFormats = []string{"json", "xml", "python"}
+
+// IsSpec reports whether s is a parser spec rather than the name of a parser
+func IsSpec(s string) bool {
+	return strings.ContainsAny(strings.TrimSpace(s), " \t\n{")
+}
+
+// ParseSpec parses a parser spec written as JSON or as "key value" lines
+func ParseSpec(s string) (*Spec, error) {
+	var spec Spec
+
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(s, "{") {
+		d := json.NewDecoder(strings.NewReader(s))
+		d.DisallowUnknownFields()
+		if err := d.Decode(&spec); err != nil {
+			return nil, fmt.Errorf("invalid parser spec: %w", err)
+		}
+	} else {
+		for line := range strings.Lines(s) {
+			line = strings.TrimSpace(line)
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+
+			key, value, _ := strings.Cut(line, " ")
+			value = strings.TrimSpace(value)
+			if strings.HasPrefix(value, `"`) {
+				var err error
+				if value, err = strconv.Unquote(value); err != nil {
+					return nil, fmt.Errorf("invalid parser spec value for %s: %w", key, err)
+				}
+			}
+
+			switch key {
+			case "thinking_start":
+				spec.ThinkingStart = value
+			case "thinking_end":
+				spec.ThinkingEnd = value
+			case "thinking_open":
+				b, err := strconv.ParseBool(value)
+				if err != nil {
+					return nil, fmt.Errorf("invalid parser spec value for %s: %q", key, value)
+				}
+				spec.ThinkingOpen = b
+			case "tool_call_start":
+				spec.ToolCallStart = value
+			case "tool_call_end":
+				spec.ToolCallEnd = value
+			case "tool_call_format":
+				spec.ToolCallFormat = value
+			default:
+				return nil, fmt.Errorf("invalid parser spec: unknown key %q", key)
+			}
+		}
+	}
+
+	if (spec.ThinkingStart != "" || spec.ThinkingOpen) && spec.ThinkingEnd == "" {
+		return nil, errors.New("invalid parser spec: thinking_end is required for thinking")
+	}
+
+	if spec.ToolCallFormat == "" && (spec.ToolCallStart != "" || spec.ToolCallEnd != "") {
+		spec.ToolCallFormat = "json"
+	}
+
+	if spec.ToolCallFormat != "" && !slices.Contains(toolCallFormats, spec.ToolCallFormat) {
+		return nil, fmt.Errorf("invalid parser spec: tool_call_format must be one of %s", strings.Join(toolCallFormats, ", "))
+	}
+
+	if spec.ThinkingEnd == "" && spec.ToolCallFormat == "" {
+		return nil, errors.New("invalid parser spec: nothing to parse")
+	}
+
+	return &spec, nil
+}
+
+type specParserState int
+
+const (
+	specParserStateStart specParserState = iota
+	specParserStateThinking
+	specParserStateContent
+	specParserStateToolCall
+)
+
+// SpecParser parses thinking and tool calls as described by a Spec
+type SpecParser struct {
+	spec  Spec
+	tools []api.Tool
+
+	// decodeToolCalls and toolCallPrefixes are set by parsers for model
+	// families whose tool calls can't be described by a Spec alone
+	decodeToolCalls  func(raw string, tools []api.Tool) ([]api.ToolCall, error)
+	toolCallPrefixes []string
+	newArgsScanner   func(tools []api.Tool) argsScanner
+	toolCallSyntax   func(tools []api.Tool) []ToolCallSyntax
+
+	state  specParserState
+	buffer string
+
+	// trimLeft is set when leading whitespace should be dropped from the
+	// rest of the output, such as after the end of thinking
+	trimLeft bool
+
+	thinkingDone bool
+
+	// scanner streams the arguments of tool calls as deltas, and index is
+	// the index of the next tool call
+	scanner argsScanner
+	deltas  []api.ToolCallDelta
+	index   int
+}
+
+func NewSpecParser(spec Spec) *SpecParser {
+	return &SpecParser{spec: spec}
+}
+
+func (p *SpecParser) HasToolSupport() bool {
+	return p.spec.ToolCallFormat != ""
+}
+
+func (p *SpecParser) HasThinkingSupport() bool {
+	return p.spec.ThinkingEnd != ""
+}
+
+// AnswerStart returns the end of the thinking section when it's already open.
+// A model that may open it isn't known to write the end next.
+func (p *SpecParser) AnswerStart() string {
+	if p.state == specParserStateThinking {
+		return p.spec.ThinkingEnd
+	}
+	return ""
+}
+
+func (p *SpecParser) Init(tools []api.Tool, lastMessage *api.Message) []api.Tool {
+	p.tools = tools
+	p.buffer = ""
+	p.trimLeft = true
+	p.thinkingDone = false
+	p.index = 0
+
+	switch {
+	case p.newArgsScanner != nil:
+		p.scanner = p.newArgsScanner(tools)
+	case p.spec.ToolCallFormat == "json":
+		p.scanner = newJSONArgsScanner(tools)
+	case p.spec.ToolCallFormat == "xml":
+		p.scanner = newXMLArgsScanner(tools)
+	case p.spec.ToolCallFormat == "python":
+		p.scanner = newPythonArgsScanner(tools)
+	default:
+		p.scanner = nil
+	}
+
+	switch {
+	case lastMessage != nil && lastMessage.Role == "assistant" && lastMessage.Content != "":
+		// continuing a prefilled response
+		p.state = specParserStateContent
+		p.trimLeft = false
+	case p.spec.ThinkingOpen:
+		p.state = specParserStateThinking
+	default:
+		p.state = specParserStateStart
+	}
+
+	return tools
+}
+
+func (p *SpecParser) Add(s string, done bool) (content string, thinking string, calls []api.ToolCall, err error) {
+	p.buffer += s
+	p.deltas = nil
+
+	var contentSb, thinkingSb strings.Builder
+	for {
+		more, err := p.eat(done, &contentSb, &thinkingSb, &calls)
+		if err != nil {
+			return "", "", nil, err
+		}
+
+		if !more {
+			break
+		}
+	}
+
+	return contentSb.String(), thinkingSb.String(), calls, nil
+}
+
+// eat consumes as much of the buffer as is unambiguous in the current state
+// and reports whether the state changed, in which case it should be called
+// again
+func (p *SpecParser) eat(done bool, content, thinking *strings.Builder, calls *[]api.ToolCall) (bool, error) {
+	if p.trimLeft {
+		p.buffer = strings.TrimLeftFunc(p.buffer, unicode.IsSpace)
+		if p.buffer == "" {
+			return false, nil
+		}
+		p.trimLeft = false
+	}
+
+	switch p.state {
+	case specParserStateStart:
+		if start := p.spec.ThinkingStart; start != "" && !p.thinkingDone {
+			if strings.HasPrefix(p.buffer, start) {
+				p.buffer = p.buffer[len(start):]
+				p.state, p.trimLeft = specParserStateThinking, true
+				return true, nil
+			} else if strings.HasPrefix(start, p.buffer) && !done {
+				return false, nil
+			}
+		}
+
+		// without a start marker, tool calls can only be told apart from
+		// content by how the output starts
+		if p.spec.ToolCallStart == "" && p.HasToolSupport() && len(p.tools) > 0 {
+			prefixes := p.toolCallPrefixes
+			if prefixes == nil {
+				prefixes = toolCallPrefixes[p.spec.ToolCallFormat]
+			}
+
+			for _, prefix := range prefixes {
+				if strings.HasPrefix(p.buffer, prefix) {
+					p.state = specParserStateToolCall
+					return true, nil
+				} else if strings.HasPrefix(prefix, p.buffer) && !done {
+					return false, nil
+				}
+			}
+		}
+
+		p.state = specParserStateContent
+		return true, nil
+	case specParserStateThinking:
+		end := p.spec.ThinkingEnd
+		if i := strings.Index(p.buffer, end); i >= 0 {
+			thinking.WriteString(strings.TrimRightFunc(p.buffer[:i], unicode.IsSpace))
+			p.buffer = p.buffer[i+len(end):]
+			p.state, p.thinkingDone, p.trimLeft = specParserStateStart, true, true
+			return true, nil
+		}
+
+		p.buffer = emitUnambiguous(thinking, p.buffer, end, done)
+		return false, nil
+	case specParserStateContent:
+		start := p.spec.ToolCallStart
+		if start != "" {
+			if i := strings.Index(p.buffer, start); i >= 0 {
+				content.WriteString(strings.TrimRightFunc(p.buffer[:i], unicode.IsSpace))
+				p.buffer = p.buffer[i+len(start):]
+				p.state = specParserStateToolCall
+				return true, nil
+			}
+		}
+
+		p.buffer = emitUnambiguous(content, p.buffer, start, done)
+		return false, nil
+	case specParserStateToolCall:
+		var raw string
+		if i := strings.Index(p.buffer, p.spec.ToolCallEnd); p.spec.ToolCallEnd != "" && i >= 0 {
+			raw, p.buffer = p.buffer[:i], p.buffer[i+len(p.spec.ToolCallEnd):]
+		} else if done {
+			raw, p.buffer = p.buffer, ""
+		} else {
+			// tool calls are only parsed once they're complete, but their
+			// arguments can be streamed before then
+			p.scanArgs(p.buffer)
+			return false, nil
+		}
+
+		p.scanArgs(raw)
+		if p.scanner != nil {
+			defer func() { p.scanner.reset(p.index) }()
+		}
+
+		toolCalls, err := p.parseToolCalls(raw)
+		if err != nil {
+			if p.spec.ToolCallStart == "" {
+				// it only looked like a tool call
+				content.WriteString(raw)
+				p.state = specParserStateContent
+				return true, nil
+			}
+			return false, err
+		}
+
+		for i := range toolCalls {
+			toolCalls[i].Function.Index = p.index
+			p.index++
+		}
+
+		*calls = append(*calls, toolCalls...)
+		p.state, p.trimLeft = specParserStateContent, true
+		return true, nil
+	default:
+		panic("unreachable")
+	}
+}
+
+func (p *SpecParser) scanArgs(raw string) {
+	if p.scanner != nil {
+		p.deltas = append(p.deltas, p.scanner.scan(raw)...)
+	}
+}
+
+// ToolCallDeltas returns the tool call arguments streamed by the last call
+// to Add
+func (p *SpecParser) ToolCallDeltas() []api.ToolCallDelta {
+	return p.deltas
+}
+
+// ToolCallSyntax returns the syntax of tool calls in the json format. A call
+// forced while thinking follows the end of the thinking section, which is
+// returned by AnswerStart.
+func (p *SpecParser) ToolCallSyntax(tools []api.Tool) []ToolCallSyntax {
+	switch {
+	case p.toolCallSyntax != nil:
+		return p.toolCallSyntax(tools)
+	case p.spec.ToolCallFormat == "json":
+		return JSONToolCallSyntax(p.spec.ToolCallStart, p.spec.ToolCallEnd, "arguments", tools)
+	default:
+		return nil
+	}
+}
+
+// emitUnambiguous writes the part of buffer that can't be the start of delim
+// or trailing whitespace before it to sb, and returns the rest
+func emitUnambiguous(sb *strings.Builder, buffer, delim string, done bool) string {
+	if done {
+		sb.WriteString(buffer)
+		return ""
+	}
+
+	n := len(buffer)
+	if delim != "" {
+		n -= overlap(buffer, delim)
+	}
+	n -= trailingWhitespaceLen(buffer[:n])
+
+	sb.WriteString(buffer[:n])
+	return buffer[n:]
+}
+
+// toolCallPrefixes are how tool calls without a start marker begin
+var toolCallPrefixes = map[string][]string{
+	"json":   {"{", "["},
+	"xml":    {"<function="},
+	"python": {"["},
+}
+
+func (p *SpecParser) parseToolCalls(raw string) ([]api.ToolCall, error) {
+	if p.decodeToolCalls != nil {
+		return p.decodeToolCalls(raw, p.tools)
+	}
+
+	switch p.spec.ToolCallFormat {
+	case "xml":
+		return parseXMLToolCalls(raw, p.tools)
+	case "python":
+		return parsePythonToolCalls(raw)
+	default:
+		return parseJSONToolCalls(raw)
+	}
+}
+
+type jsonToolCall struct {
+	Name       string          `json:"name"`
+	Arguments  json.RawMessage `json:"arguments"`
+	Parameters json.RawMessage `json:"parameters"`
+}
+
+// parseJSONToolCalls parses one or more JSON tool calls, each of which may
+// also be a list of calls. Arguments may also be called parameters.
+func parseJSONToolCalls(raw string) ([]api.ToolCall, error) {
+	var calls []api.ToolCall
+
+	for {
+		// calls can be separated by whitespace or semicolons
+		raw = strings.TrimLeftFunc(raw, func(r rune) bool { return r == ';' || unicode.IsSpace(r) })
+		if raw == "" {
+			break
+		}
+
+		d := json.NewDecoder(strings.NewReader(raw))
+		var msg json.RawMessage
+		if err := d.Decode(&msg); err != nil {
+			return nil, err
+		}
+		raw = raw[d.InputOffset():]
+
+		var jcs []jsonToolCall
+		if strings.HasPrefix(string(msg), "[") {
+			if err := json.Unmarshal(msg, &jcs); err != nil {
+				return nil, err
+			}
+		} else {
+			var jc jsonToolCall
+			if err := json.Unmarshal(msg, &jc); err != nil {
+				return nil, err
+			}
+			jcs = append(jcs, jc)
+		}
+
+		for _, jc := range jcs {
+			if jc.Name == "" {
+				return nil, errors.New("tool call has no name")
+			}
+
+			arguments := jc.Arguments
+			if len(arguments) == 0 {
+				arguments = jc.Parameters
+			}
+
+			// some models encode the arguments as a JSON string
+			var s string
+			if err := json.Unmarshal(arguments, &s); err == nil {
+				arguments = json.RawMessage(s)
+			}
+
+			args := make(api.ToolCallFunctionArguments)
+			if len(arguments) > 0 && string(arguments) != "null" {
+				if err := json.Unmarshal(arguments, &args); err != nil {
+					return nil, fmt.Errorf("invalid arguments for %s: %w", jc.Name, err)
+				}
+			}
+
+			calls = append(calls, api.ToolCall{Function: api.ToolCallFunction{Name: jc.Name, Arguments: args}})
+		}
+	}
+
+	if len(calls) == 0 {
+		return nil, errors.New("no tool calls found")
+	}
+
+	return calls, nil
+}
+
+var xmlFunctionRegex = regexp.MustCompile(`(?s)<function=.*?</function>`)
+
+// parseXMLToolCalls parses one or more tool calls in the format used by
+// Qwen3-Coder
+func parseXMLToolCalls(raw string, tools []api.Tool) ([]api.ToolCall, error) {
+	var calls []api.ToolCall
+	for _, match := range xmlFunctionRegex.FindAllString(raw, -1) {
+		call, err := parseToolCall(qwenEventRawToolCall{raw: match}, tools)
+		if err != nil {
+			return nil, err
+		}
+		calls = append(calls, call)
+	}
+
+	if len(calls) == 0 {
+		return nil, errors.New("no tool calls found")
+	}
+
+	return calls, nil
+}
+
+// parsePythonToolCalls parses tool calls written as Python function calls
+// with keyword arguments, optionally inside a list:
+//
+//	[get_weather(city="Paris", unit="celsius"), get_time()]
+func parsePythonToolCalls(raw string) ([]api.ToolCall, error) {
+	p := pythonParser{s: strings.TrimSpace(raw)}
+
+	bracketed := p.accept('[')
+
+	var calls []api.ToolCall
+	for {
+		p.skipSpace()
+		if bracketed && p.accept(']') || !bracketed && p.eof() {
+			break
+		}
+
+		name := p.name()
+		if name == "" {
+			return nil, p.errorf("expected function name")
+		}
+
+		if !p.accept('(') {
+			return nil, p.errorf("expected (")
+		}
+
+		args := make(api.ToolCallFunctionArguments)
+		for {
+			p.skipSpace()
+			if p.accept(')') {
+				break
+			}
+
+			key := p.name()
+			if key == "" || !p.accept('=') {
+				return nil, p.errorf("expected keyword argument")
+			}
+
+			v, err := p.value()
+			if err != nil {
+				return nil, err
+			}
+			args[key] = v
+
+			p.skipSpace()
+			if !p.accept(',') {
+				p.skipSpace()
+				if !p.accept(')') {
+					return nil, p.errorf("expected )")
+				}
+				break
+			}
+		}
+
+		calls = append(calls, api.ToolCall{Function: api.ToolCallFunction{Name: name, Arguments: args}})
+
+		// calls are separated by commas, or newlines outside of a list
+		p.skipSpace()
+		if !p.accept(',') && bracketed {
+			if !p.accept(']') {
+				return nil, p.errorf("expected ]")
+			}
+			break
+		}
+	}
+
+	if p.skipSpace(); !p.eof() {
+		return nil, p.errorf("unexpected %q", p.s[p.i:])
+	}
+
+	if len(calls) == 0 {
+		return nil, errors.New("no tool calls found")
+	}
+
+	return calls, nil
+}
+
+type pythonParser struct {
+	s string
+	i int
+}
+
+func (p *pythonParser) errorf(format string, args ...any) error {
+	return fmt.Errorf("invalid tool call at offset %d: %s", p.i, fmt.Sprintf(format, args...))
+}
+
+func (p *pythonParser) eof() bool {
+	return p.i >= len(p.s)
+}
+
+func (p *pythonParser) skipSpace() {
+	for !p.eof() && unicode.IsSpace(rune(p.s[p.i])) {
+		p.i++
+	}
+}
+
+func (p *pythonParser) accept(c byte) bool {
+	if !p.eof() && p.s[p.i] == c {
+		p.i++
+		return true
+	}
+	return false
+}
+
+// name reads a possibly dotted identifier
+func (p *pythonParser) name() string {
+	p.skipSpace()
+	start := p.i
+	for !p.eof() {
+		c := p.s[p.i]
+		if c == '_' || c == '.' || c == '-' || unicode.IsLetter(rune(c)) || (p.i > start && unicode.IsDigit(rune(c))) {
+			p.i++
+			continue
+		}
+		break
+	}
+	return p.s[start:p.i]
+}
+
+// value reads a Python literal
+func (p *pythonParser) value() (any, error) {
+	p.skipSpace()
+	if p.eof() {
+		return nil, p.errorf("expected value")
+	}
+
+	switch c := p.s[p.i]; {
+	case c == '"' || c == '\'':
+		return p.string()
+	case c == '[' || c == '(':
+		end := byte(']')
+		if c == '(' {
+			end = ')'
+		}
+		p.i++
+
+		items := []any{}
+		for {
+			p.skipSpace()
+			if p.accept(end) {
+				return items, nil
+			}
+
+			v, err := p.value()
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, v)
+
+			p.skipSpace()
+			if !p.accept(',') {
+				if !p.accept(end) {
+					return nil, p.errorf("expected %c", end)
+				}
+				return items, nil
+			}
+		}
+	case c == '{':
+		p.i++
+
+		m := make(map[string]any)
+		for {
+			p.skipSpace()
+			if p.accept('}') {
+				return m, nil
+			}
+
+			k, err := p.value()
+			if err != nil {
+				return nil, err
+			}
+
+			p.skipSpace()
+			if !p.accept(':') {
+				return nil, p.errorf("expected :")
+			}
+
+			v, err := p.value()
+			if err != nil {
+				return nil, err
+			}
+			m[fmt.Sprint(k)] = v
+
+			p.skipSpace()
+			if !p.accept(',') {
+				if !p.accept('}') {
+					return nil, p.errorf("expected }")
+				}
+				return m, nil
+			}
+		}
+	case c == '-' || c == '+' || c == '.' || unicode.IsDigit(rune(c)):
+		start := p.i
+		p.i++
+		for !p.eof() && strings.ContainsRune("0123456789._eE+-", rune(p.s[p.i])) {
+			p.i++
+		}
+
+		s := strings.ReplaceAll(p.s[start:p.i], "_", "")
+		if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return int(i), nil
+		}
+		if f, err := strconv.ParseFloat(s, 64); err == nil {
+			return f, nil
+		}
+		return nil, p.errorf("invalid number %q", s)
+	default:
+		switch name := p.name(); name {
+		case "True", "true":
+			return true, nil
+		case "False", "false":
+			return false, nil
+		case "None", "null":
+			return nil, nil
+		default:
+			return nil, p.errorf("unexpected %q", name)
+		}
+	}
+}
+
+func (p *pythonParser) string() (string, error) {
+	quote := p.s[p.i]
+	p.i++
+
+	var sb strings.Builder
+	for !p.eof() {
+		c := p.s[p.i]
+		switch {
+		case c == quote:
+			p.i++
+			return sb.String(), nil
+		case c == '\\' && p.i+1 < len(p.s):
+			p.i++
+			switch e := p.s[p.i]; e {
+			case 'n':
+				sb.WriteByte('\n')
+			case 't':
+				sb.WriteByte('\t')
+			case 'r':
+				sb.WriteByte('\r')
+			case 'u':
+				if p.i+4 < len(p.s) {
+					if r, err := strconv.ParseUint(p.s[p.i+1:p.i+5], 16, 32); err == nil {
+						sb.WriteRune(rune(r))
+						p.i += 4
+						break
+					}
+				}
+				sb.WriteString(`\u`)
+			case '\\', '\'', '"':
+				sb.WriteByte(e)
+			default:
+				sb.WriteByte('\\')
+				sb.WriteByte(e)
+			}
+		default:
+			sb.WriteByte(c)
+		}
+		p.i++
+	}
+
+	return "", p.errorf("unterminated string")
+}
@@ -0,0 +1,268 @@
+package parsers
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/ollama/ollama/api"
+)
+
+func TestParseSpec(t *testing.T) {
+	cases := []struct {
+		name     string
+		input    string
+		expected *Spec
+		err      string
+	}{
+		{
+			name:     "json",
+			input:    `{"thinking_start": "<think>", "thinking_end": "</think>", "tool_call_start": "<tool_call>", "tool_call_end": "</tool_call>"}`,
+			expected: &Spec{ThinkingStart: "<think>", ThinkingEnd: "</think>", ToolCallStart: "<tool_call>", ToolCallEnd: "</tool_call>", ToolCallFormat: "json"},
+		},
+		{
+			name: "lines",
+			input: `
+# Qwen3-Coder
+thinking_end </think>
+thinking_open true
+tool_call_start <tool_call>
+tool_call_end "</tool_call>\n"
+tool_call_format xml
+`,
+			expected: &Spec{ThinkingEnd: "</think>", ThinkingOpen: true, ToolCallStart: "<tool_call>", ToolCallEnd: "</tool_call>\n", ToolCallFormat: "xml"},
+		},
+		{
+			name:     "python",
+			input:    "tool_call_format python",
+			expected: &Spec{ToolCallFormat: "python"},
+		},
+		{
+			name:  "unknown json field",
+			input: `{"thinking_end": "</think>", "tool_format": "json"}`,
+			err:   `unknown field "tool_format"`,
+		},
+		{
+			name:  "unknown key",
+			input: "tool_format json",
+			err:   `unknown key "tool_format"`,
+		},
+		{
+			name:  "unknown format",
+			input: "tool_call_format yaml",
+			err:   "tool_call_format must be one of json, xml, python",
+		},
+		{
+			name:  "missing thinking end",
+			input: "thinking_start <think>",
+			err:   "thinking_end is required",
+		},
+		{
+			name:  "empty",
+			input: "{}",
+			err:   "nothing to parse",
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			if !IsSpec(tt.input) {
+				t.Fatalf("expected %q to be a spec", tt.input)
+			}
+
+			spec, err := ParseSpec(tt.input)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected error containing %q, got %v", tt.err, err)
+				}
+				return
+			} else if err != nil {
+				t.Fatal(err)
+			}
+
+			if diff := cmp.Diff(tt.expected, spec); diff != "" {
+				t.Errorf("mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestSpecParser(t *testing.T) {
+	tools := []api.Tool{
+		{
+			Type: "function",
+			Function: api.ToolFunction{
+				Name: "get_weather",
+				Parameters: api.ToolFunctionParameters{
+					Type: "object",
+					Properties: map[string]api.ToolProperty{
+						"city": {Type: api.PropertyType{"string"}},
+						"days": {Type: api.PropertyType{"integer"}},
+					},
+				},
+			},
+		},
+	}
+
+	hermes := Spec{ThinkingStart: "<think>", ThinkingEnd: "</think>", ToolCallStart: "<tool_call>", ToolCallEnd: "</tool_call>", ToolCallFormat: "json"}
+
+	weather := func(args api.ToolCallFunctionArguments) api.ToolCall {
+		return api.ToolCall{Function: api.ToolCallFunction{Name: "get_weather", Arguments: args}}
+	}
+
+	cases := []struct {
+		name     string
+		spec     Spec
+		tools    []api.Tool
+		last     *api.Message
+		input    string
+		content  string
+		thinking string
+		calls    []api.ToolCall
+	}{
+		{
+			name:    "content",
+			spec:    hermes,
+			input:   "Hello, world!",
+			content: "Hello, world!",
+		},
+		{
+			name:     "thinking",
+			spec:     hermes,
+			input:    "<think>\nLet me think.\n</think>\n\nThe answer is 4.",
+			thinking: "Let me think.",
+			content:  "The answer is 4.",
+		},
+		{
+			name:     "thinking open",
+			spec:     Spec{ThinkingEnd: "</think>", ThinkingOpen: true},
+			input:    "Let me think.</think>The answer is 4.",
+			thinking: "Let me think.",
+			content:  "The answer is 4.",
+		},
+		{
+			name:     "unterminated thinking",
+			spec:     hermes,
+			input:    "<think>Let me",
+			thinking: "Let me",
+		},
+		{
+			name:    "thinking tag later",
+			spec:    hermes,
+			input:   "Use <think> tags",
+			content: "Use <think> tags",
+		},
+		{
+			name:    "prefill",
+			spec:    hermes,
+			last:    &api.Message{Role: "assistant", Content: "Once"},
+			input:   " upon a <think>time",
+			content: " upon a <think>time",
+		},
+		{
+			name:    "json tool call",
+			spec:    hermes,
+			tools:   tools,
+			input:   "Let me check.\n<tool_call>\n{\"name\": \"get_weather\", \"arguments\": {\"city\": \"Paris\"}}\n</tool_call>\n<tool_call>\n{\"name\": \"get_weather\", \"arguments\": \"{\\\"city\\\": \\\"London\\\"}\"}\n</tool_call>",
+			content: "Let me check.",
+			calls:   indexed(weather(map[string]any{"city": "Paris"}), weather(map[string]any{"city": "London"})),
+		},
+		{
+			name:    "json tool call list",
+			spec:    Spec{ToolCallStart: "[TOOL_CALLS]", ToolCallFormat: "json"},
+			tools:   tools,
+			input:   `[TOOL_CALLS][{"name": "get_weather", "arguments": {"city": "Paris", "days": 2}}, {"name": "get_weather", "parameters": {"city": "London"}}]`,
+			calls:   indexed(weather(map[string]any{"city": "Paris", "days": float64(2)}), weather(map[string]any{"city": "London"})),
+			content: "",
+		},
+		{
+			name:    "json tool call without start",
+			spec:    Spec{ToolCallFormat: "json"},
+			tools:   tools,
+			input:   `{"name": "get_weather", "parameters": {"city": "Paris"}}`,
+			calls:   indexed(weather(map[string]any{"city": "Paris"})),
+			content: "",
+		},
+		{
+			name:    "json content without start",
+			spec:    Spec{ToolCallFormat: "json"},
+			tools:   tools,
+			input:   `{"answer": 4}`,
+			content: `{"answer": 4}`,
+		},
+		{
+			name:    "json without tools",
+			spec:    Spec{ToolCallFormat: "json"},
+			input:   `{"name": "get_weather", "parameters": {"city": "Paris"}}`,
+			content: `{"name": "get_weather", "parameters": {"city": "Paris"}}`,
+		},
+		{
+			name:    "xml tool call",
+			spec:    Spec{ToolCallStart: "<tool_call>", ToolCallEnd: "</tool_call>", ToolCallFormat: "xml"},
+			tools:   tools,
+			input:   "Checking.\n<tool_call>\n<function=get_weather>\n<parameter=city>\nParis\n</parameter>\n<parameter=days>\n3\n</parameter>\n</function>\n</tool_call>\nDone.",
+			content: "Checking.Done.",
+			calls:   indexed(weather(map[string]any{"city": "Paris", "days": 3})),
+		},
+		{
+			name:    "python tool calls",
+			spec:    Spec{ToolCallFormat: "python"},
+			tools:   tools,
+			input:   `[get_weather(city="Paris", days=2), get_weather(city='St. Ives', days=None, tags=["a", 'b'], opts={"x": 1.5, "y": True})]`,
+			calls:   indexed(weather(map[string]any{"city": "Paris", "days": 2}), weather(map[string]any{"city": "St. Ives", "days": nil, "tags": []any{"a", "b"}, "opts": map[string]any{"x": 1.5, "y": true}})),
+			content: "",
+		},
+		{
+			name:    "python tool call with markers",
+			spec:    Spec{ToolCallStart: "<|python_start|>", ToolCallEnd: "<|python_end|>", ToolCallFormat: "python"},
+			tools:   tools,
+			input:   "Sure. <|python_start|>get_weather(city=\"Paris\")<|python_end|>",
+			content: "Sure.",
+			calls:   indexed(weather(map[string]any{"city": "Paris"})),
+		},
+		{
+			name:    "python content",
+			spec:    Spec{ToolCallFormat: "python"},
+			tools:   tools,
+			input:   "[1] is a citation",
+			content: "[1] is a citation",
+		},
+	}
+
+	for _, tt := range cases {
+		testParser(t, func() Parser { return NewSpecParser(tt.spec) }, []parserTest{{
+			name:     tt.name,
+			tools:    tt.tools,
+			last:     tt.last,
+			input:    tt.input,
+			content:  tt.content,
+			thinking: tt.thinking,
+			calls:    tt.calls,
+		}})
+	}
+}
+
+func TestSpecParserError(t *testing.T) {
+	p := NewSpecParser(Spec{ToolCallStart: "<tool_call>", ToolCallEnd: "</tool_call>", ToolCallFormat: "json"})
+	p.Init(nil, nil)
+
+	if _, _, _, err := p.Add("<tool_call>{\"name\": </tool_call>", true); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestParserForNameSpec(t *testing.T) {
+	p := ParserForName("thinking_end </think>\nthinking_open true")
+	if p == nil {
+		t.Fatal("expected parser for spec")
+	}
+
+	if !p.HasThinkingSupport() || p.HasToolSupport() {
+		t.Errorf("unexpected support: thinking=%v tools=%v", p.HasThinkingSupport(), p.HasToolSupport())
+	}
+
+	if p := ParserForName("tool_call_format yaml"); p != nil {
+		t.Error("expected no parser for invalid spec")
+	}
+}
@@ -0,0 +1,83 @@
+package renderers
+
+import (
+	"strings"
+
+	"github.com/ollama/ollama/api"
+)
+
+// DeepSeekRenderer renders prompts for DeepSeek V3 and R1. System messages
+// are concatenated at the start of the prompt and tools are described after
+// them in the format of DeepSeek V3.1's chat template.
+type DeepSeekRenderer struct{}
+
+func (r *DeepSeekRenderer) Render(messages []api.Message, tools []api.Tool, think *api.ThinkValue) (string, error) {
+	var sb strings.Builder
+
+	var system []string
+	for _, message := range messages {
+		if message.Role == "system" {
+			system = append(system, message.Content)
+		}
+	}
+	sb.WriteString(strings.Join(system, "\n\n"))
+
+	if len(tools) > 0 {
+		if len(system) > 0 {
+			sb.WriteString("\n\n")
+		}
+		sb.WriteString("## Tools\nYou have access to the following tools:\n")
+		for _, tool := range tools {
+			b, err := marshalWithSpaces(tool.Function.Parameters)
+			if err != nil {
+				return "", err
+			}
+			sb.WriteString("\n### " + tool.Function.Name + "\nDescription: " + tool.Function.Description + "\n\nParameters: " + string(b) + "\n")
+		}
+		sb.WriteString("\nIMPORTANT: ALWAYS adhere to this exact format for tool use:\n")
+		sb.WriteString("<｜tool▁calls▁begin｜><｜tool▁call▁begin｜>tool_call_name<｜tool▁sep｜>tool_call_arguments<｜tool▁call▁end｜>{{additional_tool_calls}}<｜tool▁calls▁end｜>\n\n")
+		sb.WriteString("Where:\n\n")
+		sb.WriteString("- `tool_call_name` must be an exact match to one of the available tools\n")
+		sb.WriteString("- `tool_call_arguments` must be valid JSON that strictly follows the tool's Parameters Schema\n")
+		sb.WriteString("- For multiple tool calls, chain them directly without separators or spaces\n")
+	}
+
+	for i, message := range messages {
+		prefill := i == len(messages)-1 && message.Role == "assistant" && len(message.ToolCalls) == 0
+
+		switch message.Role {
+		case "user":
+			sb.WriteString("<｜User｜>" + message.Content)
+		case "assistant":
+			// thinking from earlier turns isn't included in the prompt
+			sb.WriteString("<｜Assistant｜>" + message.Content)
+			if len(message.ToolCalls) > 0 {
+				sb.WriteString("<｜tool▁calls▁begin｜>")
+				for _, toolCall := range message.ToolCalls {
+					b, err := marshalWithSpaces(toolCall.Function.Arguments)
+					if err != nil {
+						return "", err
+					}
+					sb.WriteString("<｜tool▁call▁begin｜>" + toolCall.Function.Name + "<｜tool▁sep｜>" + string(b) + "<｜tool▁call▁end｜>")
+				}
+				sb.WriteString("<｜tool▁calls▁end｜>")
+			}
+
+			if !prefill {
+				sb.WriteString("<｜end▁of▁sentence｜>")
+			}
+		case "tool":
+			sb.WriteString("<｜tool▁output▁begin｜>" + message.Content + "<｜tool▁output▁end｜>")
+		}
+
+		if i == len(messages)-1 && !prefill {
+			sb.WriteString("<｜Assistant｜>")
+			// an empty thinking block keeps the model from thinking
+			if think != nil && !think.Bool() {
+				sb.WriteString("<think>\n\n</think>\n\n")
+			}
+		}
+	}
+
+	return sb.String(), nil
+}
@@ -0,0 +1,80 @@
+package renderers
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/ollama/ollama/api"
+)
+
+func TestDeepSeekRenderer(t *testing.T) {
+	tests := []struct {
+		name     string
+		msgs     []api.Message
+		tools    []api.Tool
+		think    *api.ThinkValue
+		expected string
+	}{
+		{
+			name: "basic",
+			msgs: []api.Message{
+				{Role: "system", Content: "Be brief."},
+				{Role: "user", Content: "What is 2 + 2?"},
+				{Role: "assistant", Thinking: "Two plus two is four.", Content: "4"},
+				{Role: "user", Content: "And 3 + 3?"},
+			},
+			expected: "Be brief.<｜User｜>What is 2 + 2?<｜Assistant｜>4<｜end▁of▁sentence｜><｜User｜>And 3 + 3?<｜Assistant｜>",
+		},
+		{
+			name: "think false",
+			msgs: []api.Message{
+				{Role: "user", Content: "What is 2 + 2?"},
+			},
+			think:    &api.ThinkValue{Value: false},
+			expected: "<｜User｜>What is 2 + 2?<｜Assistant｜><think>\n\n</think>\n\n",
+		},
+		{
+			name: "prefill",
+			msgs: []api.Message{
+				{Role: "user", Content: "Tell me a story"},
+				{Role: "assistant", Content: "Once upon a time"},
+			},
+			expected: "<｜User｜>Tell me a story<｜Assistant｜>Once upon a time",
+		},
+		{
+			name: "tool calls",
+			msgs: []api.Message{
+				{Role: "user", Content: "Weather in Paris and London?"},
+				{Role: "assistant", ToolCalls: []api.ToolCall{weatherCall("Paris"), weatherCall("London")}},
+				{Role: "tool", Content: "18C"},
+				{Role: "tool", Content: "14C"},
+			},
+			tools: []api.Tool{weatherTool},
+			expected: "## Tools\nYou have access to the following tools:\n" +
+				"\n### get_weather\nDescription: Get the weather\n\nParameters: {\"type\": \"object\", \"required\": [\"city\"], \"properties\": {\"city\": {\"type\": \"string\"}}}\n" +
+				"\nIMPORTANT: ALWAYS adhere to this exact format for tool use:\n" +
+				"<｜tool▁calls▁begin｜><｜tool▁call▁begin｜>tool_call_name<｜tool▁sep｜>tool_call_arguments<｜tool▁call▁end｜>{{additional_tool_calls}}<｜tool▁calls▁end｜>\n\n" +
+				"Where:\n\n" +
+				"- `tool_call_name` must be an exact match to one of the available tools\n" +
+				"- `tool_call_arguments` must be valid JSON that strictly follows the tool's Parameters Schema\n" +
+				"- For multiple tool calls, chain them directly without separators or spaces\n" +
+				"<｜User｜>Weather in Paris and London?<｜Assistant｜><｜tool▁calls▁begin｜>" +
+				"<｜tool▁call▁begin｜>get_weather<｜tool▁sep｜>{\"city\": \"Paris\"}<｜tool▁call▁end｜>" +
+				"<｜tool▁call▁begin｜>get_weather<｜tool▁sep｜>{\"city\": \"London\"}<｜tool▁call▁end｜>" +
+				"<｜tool▁calls▁end｜><｜end▁of▁sentence｜>" +
+				"<｜tool▁output▁begin｜>18C<｜tool▁output▁end｜><｜tool▁output▁begin｜>14C<｜tool▁output▁end｜><｜Assistant｜>",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rendered, err := (&DeepSeekRenderer{}).Render(tt.msgs, tt.tools, tt.think)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(tt.expected, rendered); diff != "" {
+				t.Errorf("mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,125 @@
+package renderers
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/ollama/ollama/api"
+)
+
+// Gemma3Renderer renders prompts for Gemma 3. Gemma has no system role, so the
+// system prompt and any tools are prepended to the first user turn. Tools are
+// called with Python in ```tool_code blocks and their results are returned in
+// ```tool_output blocks.
+type Gemma3Renderer struct{}
+
+func (r *Gemma3Renderer) Render(messages []api.Message, tools []api.Tool, _ *api.ThinkValue) (string, error) {
+	var sb strings.Builder
+
+	var preamble strings.Builder
+	if len(messages) > 0 && messages[0].Role == "system" {
+		preamble.WriteString(messages[0].Content + "\n\n")
+		messages = messages[1:]
+	}
+
+	if len(tools) > 0 {
+		preamble.WriteString("You have access to functions. If you decide to invoke any of the function(s), you MUST put it in the format of\n")
+		preamble.WriteString("```tool_code\nfunction_name(parameter_name=value)\n```\n")
+		preamble.WriteString("You SHOULD NOT include any other text in the response if you call a function\n")
+		b, err := marshalIndent(tools)
+		if err != nil {
+			return "", err
+		}
+		preamble.Write(b)
+		preamble.WriteString("\n\n")
+	}
+
+	for i, message := range messages {
+		prefill := i == len(messages)-1 && message.Role == "assistant" && len(message.ToolCalls) == 0
+
+		switch message.Role {
+		case "user", "system":
+			sb.WriteString("<start_of_turn>user\n" + preamble.String() + message.Content + "<end_of_turn>\n")
+			preamble.Reset()
+		case "assistant":
+			sb.WriteString("<start_of_turn>model\n" + message.Content)
+			if len(message.ToolCalls) > 0 {
+				if message.Content != "" {
+					sb.WriteString("\n")
+				}
+				sb.WriteString("```tool_code\n")
+				for _, toolCall := range message.ToolCalls {
+					sb.WriteString(toolCall.Function.Name + "(")
+					for j, k := range slices.Sorted(maps.Keys(toolCall.Function.Arguments)) {
+						if j > 0 {
+							sb.WriteString(", ")
+						}
+						sb.WriteString(k + "=" + pythonLiteral(toolCall.Function.Arguments[k]))
+					}
+					sb.WriteString(")\n")
+				}
+				sb.WriteString("```")
+			}
+
+			if !prefill {
+				sb.WriteString("<end_of_turn>\n")
+			}
+		case "tool":
+			if i == 0 || messages[i-1].Role != "tool" {
+				sb.WriteString("<start_of_turn>user\n")
+			} else {
+				sb.WriteString("\n")
+			}
+			sb.WriteString("```tool_output\n" + message.Content + "\n```")
+			if i == len(messages)-1 || messages[i+1].Role != "tool" {
+				sb.WriteString("<end_of_turn>\n")
+			}
+		}
+
+		if i == len(messages)-1 && !prefill {
+			sb.WriteString("<start_of_turn>model\n")
+		}
+	}
+
+	return sb.String(), nil
+}
+
+// pythonLiteral formats v as a Python literal
+func pythonLiteral(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return "None"
+	case bool:
+		if v {
+			return "True"
+		}
+		return "False"
+	case string:
+		return strconv.Quote(v)
+	case int:
+		return strconv.Itoa(v)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case []any:
+		s := make([]string, len(v))
+		for i, e := range v {
+			s[i] = pythonLiteral(e)
+		}
+		return "[" + strings.Join(s, ", ") + "]"
+	case map[string]any:
+		var s []string
+		for _, k := range slices.Sorted(maps.Keys(v)) {
+			s = append(s, strconv.Quote(k)+": "+pythonLiteral(v[k]))
+		}
+		return "{" + strings.Join(s, ", ") + "}"
+	default:
+		if b, err := json.Marshal(v); err == nil {
+			return string(b)
+		}
+		return fmt.Sprint(v)
+	}
+}
@@ -0,0 +1,107 @@
+package renderers
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/ollama/ollama/api"
+)
+
+func TestGemma3Renderer(t *testing.T) {
+	tests := []struct {
+		name     string
+		msgs     []api.Message
+		tools    []api.Tool
+		expected string
+	}{
+		{
+			name: "basic",
+			msgs: []api.Message{
+				{Role: "system", Content: "Be brief."},
+				{Role: "user", Content: "Hello!"},
+				{Role: "assistant", Content: "Hi!"},
+				{Role: "user", Content: "How are you?"},
+			},
+			expected: `<start_of_turn>user
+Be brief.
+
+Hello!<end_of_turn>
+<start_of_turn>model
+Hi!<end_of_turn>
+<start_of_turn>user
+How are you?<end_of_turn>
+<start_of_turn>model
+`,
+		},
+		{
+			name: "prefill",
+			msgs: []api.Message{
+				{Role: "user", Content: "Tell me a story"},
+				{Role: "assistant", Content: "Once upon a time"},
+			},
+			expected: `<start_of_turn>user
+Tell me a story<end_of_turn>
+<start_of_turn>model
+Once upon a time`,
+		},
+		{
+			name: "tool calls",
+			msgs: []api.Message{
+				{Role: "user", Content: "Weather in Paris and London?"},
+				{Role: "assistant", Content: "Let me check.", ToolCalls: []api.ToolCall{
+					weatherCall("Paris"),
+					{Function: api.ToolCallFunction{Name: "get_weather", Arguments: api.ToolCallFunctionArguments{"city": "London", "days": float64(2), "metric": true, "tags": []any{"a", nil}}}},
+				}},
+				{Role: "tool", Content: "18C"},
+				{Role: "tool", Content: "14C"},
+			},
+			tools: []api.Tool{weatherTool},
+			expected: "<start_of_turn>user\n" +
+				"You have access to functions. If you decide to invoke any of the function(s), you MUST put it in the format of\n" +
+				"```tool_code\nfunction_name(parameter_name=value)\n```\n" +
+				"You SHOULD NOT include any other text in the response if you call a function\n" +
+				`[
+    {
+        "type": "function",
+        "function": {
+            "name": "get_weather",
+            "description": "Get the weather",
+            "parameters": {
+                "type": "object",
+                "required": [
+                    "city"
+                ],
+                "properties": {
+                    "city": {
+                        "type": "string"
+                    }
+                }
+            }
+        }
+    }
+]
+
+Weather in Paris and London?<end_of_turn>
+<start_of_turn>model
+Let me check.
+` + "```tool_code\n" +
+				`get_weather(city="Paris")
+get_weather(city="London", days=2, metric=True, tags=["a", None])
+` + "```<end_of_turn>\n" +
+				"<start_of_turn>user\n```tool_output\n18C\n```\n```tool_output\n14C\n```<end_of_turn>\n" +
+				"<start_of_turn>model\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rendered, err := (&Gemma3Renderer{}).Render(tt.msgs, tt.tools, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(tt.expected, rendered); diff != "" {
+				t.Errorf("mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,85 @@
+package renderers
+
+import (
+	"strings"
+
+	"github.com/ollama/ollama/api"
+)
+
+// HermesRenderer renders prompts in ChatML with the Hermes tool format, where
+// tools are listed in the system message and calls are JSON objects in
+// <tool_call> tags. Images aren't rendered since the server puts their
+// placeholders in the message content.
+type HermesRenderer struct{}
+
+// writeHermesTools writes the system message listing tools, starting with the
+// content of the first message if it's a system message
+func writeHermesTools(sb *strings.Builder, messages []api.Message, tools []api.Tool) {
+	sb.WriteString(imStartTag + "system\n")
+	if len(messages) > 0 && messages[0].Role == "system" {
+		sb.WriteString(messages[0].Content + "\n\n")
+	}
+	sb.WriteString("# Tools\n\nYou may call one or more functions to assist with the user query.\n\nYou are provided with function signatures within <tools></tools> XML tags:\n<tools>")
+	for _, tool := range tools {
+		sb.WriteString("\n")
+		if b, err := marshalWithSpaces(tool); err == nil {
+			sb.Write(b)
+		}
+	}
+	sb.WriteString("\n</tools>\n\nFor each function call, return a json object with function name and arguments within <tool_call></tool_call> XML tags:\n<tool_call>\n{\"name\": <function-name>, \"arguments\": <args-json-object>}\n</tool_call>" + imEndTag + "\n")
+}
+
+func (r *HermesRenderer) Render(messages []api.Message, tools []api.Tool, _ *api.ThinkValue) (string, error) {
+	var sb strings.Builder
+
+	if len(tools) > 0 {
+		writeHermesTools(&sb, messages, tools)
+	} else if len(messages) > 0 && messages[0].Role == "system" {
+		sb.WriteString(imStartTag + "system\n" + messages[0].Content + imEndTag + "\n")
+	}
+
+	for i, message := range messages {
+		lastMessage := i == len(messages)-1
+		prefill := lastMessage && message.Role == "assistant"
+
+		switch message.Role {
+		case "user", "system":
+			if i > 0 || message.Role == "user" {
+				sb.WriteString(imStartTag + message.Role + "\n" + message.Content + imEndTag + "\n")
+			}
+		case "assistant":
+			sb.WriteString(imStartTag + "assistant\n" + message.Content)
+			for j, toolCall := range message.ToolCalls {
+				if j > 0 || message.Content != "" {
+					sb.WriteString("\n")
+				}
+
+				sb.WriteString("<tool_call>\n{\"name\": \"" + toolCall.Function.Name + "\", \"arguments\": ")
+				b, err := marshalWithSpaces(toolCall.Function.Arguments)
+				if err != nil {
+					return "", err
+				}
+				sb.Write(b)
+				sb.WriteString("}\n</tool_call>")
+			}
+
+			if !prefill {
+				sb.WriteString(imEndTag + "\n")
+			}
+		case "tool":
+			if i == 0 || messages[i-1].Role != "tool" {
+				sb.WriteString(imStartTag + "user")
+			}
+			sb.WriteString("\n<tool_response>\n" + message.Content + "\n</tool_response>")
+			if lastMessage || messages[i+1].Role != "tool" {
+				sb.WriteString(imEndTag + "\n")
+			}
+		}
+
+		if lastMessage && !prefill {
+			sb.WriteString(imStartTag + "assistant\n")
+		}
+	}
+
+	return sb.String(), nil
+}
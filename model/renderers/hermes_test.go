@@ -0,0 +1,90 @@
+package renderers
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/ollama/ollama/api"
+)
+
+func TestHermesRenderer(t *testing.T) {
+	tests := []struct {
+		name     string
+		msgs     []api.Message
+		tools    []api.Tool
+		expected string
+	}{
+		{
+			name: "basic",
+			msgs: []api.Message{
+				{Role: "system", Content: "Be brief."},
+				{Role: "user", Content: "Hello!"},
+			},
+			expected: "<|im_start|>system\nBe brief.<|im_end|>\n<|im_start|>user\nHello!<|im_end|>\n<|im_start|>assistant\n",
+		},
+		{
+			name: "prefill",
+			msgs: []api.Message{
+				{Role: "user", Content: "Tell me a story"},
+				{Role: "assistant", Content: "Once upon a time"},
+			},
+			expected: "<|im_start|>user\nTell me a story<|im_end|>\n<|im_start|>assistant\nOnce upon a time",
+		},
+		{
+			// the server puts the image placeholders in the content, so no
+			// model specific image tokens are added
+			name: "image",
+			msgs: []api.Message{
+				{Role: "user", Content: "[img-0]What's in this picture?", Images: []api.ImageData{api.ImageData("image")}},
+			},
+			expected: "<|im_start|>user\n[img-0]What's in this picture?<|im_end|>\n<|im_start|>assistant\n",
+		},
+		{
+			name: "tool calls",
+			msgs: []api.Message{
+				{Role: "system", Content: "Be brief."},
+				{Role: "user", Content: "Weather in Paris and London?"},
+				{Role: "assistant", ToolCalls: []api.ToolCall{weatherCall("Paris"), weatherCall("London")}},
+				{Role: "tool", Content: "18C"},
+				{Role: "tool", Content: "12C"},
+			},
+			tools: []api.Tool{weatherTool},
+			expected: "<|im_start|>system\nBe brief.\n\n# Tools\n\nYou may call one or more functions to assist with the user query.\n\nYou are provided with function signatures within <tools></tools> XML tags:\n<tools>\n" +
+				`{"type": "function", "function": {"name": "get_weather", "description": "Get the weather", "parameters": {"type": "object", "required": ["city"], "properties": {"city": {"type": "string"}}}}}` +
+				"\n</tools>\n\nFor each function call, return a json object with function name and arguments within <tool_call></tool_call> XML tags:\n<tool_call>\n{\"name\": <function-name>, \"arguments\": <args-json-object>}\n</tool_call><|im_end|>\n" +
+				"<|im_start|>user\nWeather in Paris and London?<|im_end|>\n" +
+				"<|im_start|>assistant\n<tool_call>\n{\"name\": \"get_weather\", \"arguments\": {\"city\": \"Paris\"}}\n</tool_call>\n<tool_call>\n{\"name\": \"get_weather\", \"arguments\": {\"city\": \"London\"}}\n</tool_call><|im_end|>\n" +
+				"<|im_start|>user\n<tool_response>\n18C\n</tool_response>\n<tool_response>\n12C\n</tool_response><|im_end|>\n" +
+				"<|im_start|>assistant\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rendered, err := (&HermesRenderer{}).Render(tt.msgs, tt.tools, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(tt.expected, rendered); diff != "" {
+				t.Errorf("mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestHermesRendererImgTags(t *testing.T) {
+	// the server sets RenderImgTags, which only applies to renderers of
+	// vision models
+	RenderImgTags = true
+	t.Cleanup(func() { RenderImgTags = false })
+
+	msgs := []api.Message{{Role: "user", Content: "[img-0]Describe this.", Images: []api.ImageData{api.ImageData("image")}}}
+	rendered, err := RenderWithRenderer("hermes", msgs, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := "<|im_start|>user\n[img-0]Describe this.<|im_end|>\n<|im_start|>assistant\n"; rendered != want {
+		t.Errorf("rendered = %q, want %q", rendered, want)
+	}
+}
@@ -0,0 +1,189 @@
+package renderers
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/fs/gguf"
+	"github.com/ollama/ollama/template/jinja"
+)
+
+// JinjaRenderer renders prompts by executing a Hugging Face style Jinja chat
+// template, usually the one stored in the model's tokenizer.chat_template
+type JinjaRenderer struct {
+	template *jinja.Template
+
+	bosToken string
+	eosToken string
+
+	// trimBOS is the BOS token, if the runner adds it when tokenizing
+	trimBOS string
+}
+
+func NewJinjaRenderer(chatTemplate, bosToken, eosToken string) (*JinjaRenderer, error) {
+	tmpl, err := jinja.Parse(chatTemplate)
+	if err != nil {
+		return nil, err
+	}
+
+	return &JinjaRenderer{template: tmpl, bosToken: bosToken, eosToken: eosToken}, nil
+}
+
+// jinjaRenderers caches the renderers of model files by path
+var jinjaRenderers sync.Map
+
+// JinjaRendererForModel returns the renderer for the chat template stored in
+// the model file at modelPath
+func JinjaRendererForModel(modelPath string) (*JinjaRenderer, error) {
+	if r, ok := jinjaRenderers.Load(modelPath); ok {
+		return r.(*JinjaRenderer), nil
+	}
+
+	f, err := gguf.Open(modelPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	chatTemplate := f.KeyValue("tokenizer.chat_template").String()
+	if chatTemplate == "" {
+		return nil, errors.New("model has no chat template")
+	}
+
+	tokens := f.KeyValue("tokenizer.ggml.tokens").Strings()
+	token := func(key string) string {
+		if kv := f.KeyValue(key); kv.Valid() && kv.Uint() < uint64(len(tokens)) {
+			return tokens[kv.Uint()]
+		}
+		return ""
+	}
+
+	bos, eos := token("tokenizer.ggml.bos_token_id"), token("tokenizer.ggml.eos_token_id")
+	r, err := NewJinjaRenderer(chatTemplate, bos, eos)
+	if err != nil {
+		return nil, fmt.Errorf("invalid chat template: %w", err)
+	}
+
+	if kv := f.KeyValue("tokenizer.ggml.add_bos_token"); !kv.Valid() || kv.Bool() {
+		r.trimBOS = bos
+	}
+
+	v, _ := jinjaRenderers.LoadOrStore(modelPath, r)
+	return v.(*JinjaRenderer), nil
+}
+
+// Vars returns the names of the variables the chat template references
+func (r *JinjaRenderer) Vars() []string {
+	return r.template.Vars()
+}
+
+func (r *JinjaRenderer) Render(messages []api.Message, tools []api.Tool, think *api.ThinkValue) (string, error) {
+	vars := map[string]any{
+		"bos_token": r.bosToken,
+		"eos_token": r.eosToken,
+		"tools":     nil,
+	}
+
+	msgs := make([]any, len(messages))
+	for i, m := range messages {
+		msg, err := jinjaMessage(m)
+		if err != nil {
+			return "", err
+		}
+		msgs[i] = msg
+	}
+	vars["messages"] = msgs
+
+	if len(tools) > 0 {
+		v, err := jinja.FromGo(tools)
+		if err != nil {
+			return "", err
+		}
+		vars["tools"] = v
+	}
+
+	if think != nil {
+		vars["enable_thinking"] = think.Bool()
+		if think.IsString() {
+			vars["reasoning_effort"] = think.String()
+		}
+	}
+
+	// a final assistant message is continued rather than followed by a new
+	// one, like continue_final_message in transformers
+	var prefill string
+	if n := len(messages); n > 0 && messages[n-1].Role == "assistant" && len(messages[n-1].ToolCalls) == 0 {
+		prefill = strings.TrimSpace(messages[n-1].Content)
+	}
+	vars["add_generation_prompt"] = prefill == ""
+
+	var sb strings.Builder
+	if err := r.template.Execute(&sb, vars); err != nil {
+		return "", err
+	}
+
+	s := sb.String()
+
+	// chat templates usually start with the BOS token, which would be
+	// added a second time when the prompt is tokenized
+	if r.trimBOS != "" {
+		s = strings.TrimPrefix(s, r.trimBOS)
+	}
+
+	if prefill != "" {
+		if i := strings.LastIndex(s, prefill); i >= 0 {
+			s = s[:i+len(prefill)]
+		}
+	}
+
+	return s, nil
+}
+
+// jinjaMessage converts m to the message format chat templates expect
+func jinjaMessage(m api.Message) (*jinja.Dict, error) {
+	msg := jinja.NewDict()
+	msg.Set("role", m.Role)
+	msg.Set("content", m.Content)
+
+	if m.Thinking != "" {
+		msg.Set("thinking", m.Thinking)
+		msg.Set("reasoning_content", m.Thinking)
+	}
+
+	if len(m.ToolCalls) > 0 {
+		calls := make([]any, len(m.ToolCalls))
+		for i, tc := range m.ToolCalls {
+			arguments, err := jinja.FromGo(tc.Function.Arguments)
+			if err != nil {
+				return nil, err
+			}
+
+			function := jinja.NewDict()
+			function.Set("name", tc.Function.Name)
+			function.Set("arguments", arguments)
+
+			call := jinja.NewDict()
+			if tc.ID != "" {
+				call.Set("id", tc.ID)
+			}
+			call.Set("type", "function")
+			call.Set("function", function)
+			calls[i] = call
+		}
+		msg.Set("tool_calls", calls)
+	}
+
+	if m.ToolName != "" {
+		msg.Set("tool_name", m.ToolName)
+		msg.Set("name", m.ToolName)
+	}
+
+	if m.ToolCallID != "" {
+		msg.Set("tool_call_id", m.ToolCallID)
+	}
+
+	return msg, nil
+}
@@ -0,0 +1,193 @@
+package renderers
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/ollama/ollama/api"
+)
+
+// TestJinjaRenderer checks that the Jinja chat templates the Go renderers were
+// written from render the same prompts as the Go renderers
+func TestJinjaRenderer(t *testing.T) {
+	tools := []api.Tool{
+		{
+			Type: "function",
+			Function: api.ToolFunction{
+				Name:        "get_weather",
+				Description: "Get the current weather in a given location",
+				Parameters: api.ToolFunctionParameters{
+					Type:     "object",
+					Required: []string{"unit"},
+					Properties: map[string]api.ToolProperty{
+						"unit": {Type: api.PropertyType{"string"}, Enum: []any{"celsius"}, Description: "The unit of temperature"},
+					},
+				},
+			},
+		},
+	}
+
+	toolCall := api.ToolCall{
+		Function: api.ToolCallFunction{
+			Name:      "get_weather",
+			Arguments: api.ToolCallFunctionArguments{"unit": "celsius"},
+		},
+	}
+
+	cases := []struct {
+		name  string
+		msgs  []api.Message
+		tools []api.Tool
+	}{
+		{
+			name: "basic",
+			msgs: []api.Message{
+				{Role: "system", Content: "You are a helpful assistant."},
+				{Role: "user", Content: "Hello, how are you?"},
+			},
+		},
+		{
+			name: "multiple turns",
+			msgs: []api.Message{
+				{Role: "user", Content: "Hello, how are you?"},
+				{Role: "assistant", Content: "I'm doing great. How can I help you today?"},
+				{Role: "user", Content: "I'd like to show off how chat templating works!"},
+			},
+		},
+		{
+			name: "prefill",
+			msgs: []api.Message{
+				{Role: "user", Content: "Tell me a story"},
+				{Role: "assistant", Content: "Once upon a time"},
+			},
+		},
+		{
+			name: "tools",
+			msgs: []api.Message{
+				{Role: "system", Content: "You are a helpful assistant with access to tools."},
+				{Role: "user", Content: "What is the weather like in Paris?"},
+			},
+			tools: tools,
+		},
+		{
+			name: "tool calls",
+			msgs: []api.Message{
+				{Role: "user", Content: "What is the weather like in Paris and London?"},
+				{Role: "assistant", Content: "Let me check.", ToolCalls: []api.ToolCall{toolCall, toolCall}},
+				{Role: "tool", Content: "{\"temperature\": 18}", ToolName: "get_weather"},
+				{Role: "tool", Content: "{\"temperature\": 14}", ToolName: "get_weather"},
+				{Role: "user", Content: "Which is warmer?"},
+			},
+			tools: tools,
+		},
+		{
+			name: "thinking",
+			msgs: []api.Message{
+				{Role: "user", Content: "What is 2 + 2?"},
+				{Role: "assistant", Thinking: "Two plus two is four.", Content: "4"},
+				{Role: "user", Content: "And 3 + 3?"},
+				{Role: "assistant", Thinking: "Three plus three is six.", Content: "6"},
+			},
+		},
+	}
+
+	renderers := []struct {
+		name     string
+		file     string
+		think    *api.ThinkValue
+		renderer Renderer
+	}{
+		{"qwen3-coder", "qwen3coder.jinja", nil, &Qwen3CoderRenderer{}},
+		{"qwen3-vl-instruct", "qwen3vl.jinja", &api.ThinkValue{Value: false}, &Qwen3VLRenderer{isThinking: false}},
+		{"qwen3-vl-thinking", "qwen3vl.jinja", &api.ThinkValue{Value: true}, &Qwen3VLRenderer{isThinking: true}},
+	}
+
+	for _, r := range renderers {
+		t.Run(r.name, func(t *testing.T) {
+			bts, err := os.ReadFile(filepath.Join("testdata", r.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			jinja, err := NewJinjaRenderer(string(bts), "", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, tt := range cases {
+				t.Run(tt.name, func(t *testing.T) {
+					expected, err := r.renderer.Render(tt.msgs, tt.tools, r.think)
+					if err != nil {
+						t.Fatal(err)
+					}
+
+					actual, err := jinja.Render(tt.msgs, tt.tools, r.think)
+					if err != nil {
+						t.Fatal(err)
+					}
+
+					if diff := cmp.Diff(expected, actual); diff != "" {
+						t.Errorf("mismatch (-go +jinja):\n%s", diff)
+					}
+				})
+			}
+		})
+	}
+}
+
+func TestJinjaRendererVariables(t *testing.T) {
+	r, err := NewJinjaRenderer(`{{ bos_token }}{% for m in messages %}[{{ m.role }}:{{ m.content }}{% if m.reasoning_content %}|{{ m.reasoning_content }}{% endif %}]{% endfor %}{{ enable_thinking }}/{{ reasoning_effort }}/{{ tools is none }}{% if add_generation_prompt %}>{% endif %}{{ eos_token }}`, "<s>", "</s>")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name     string
+		msgs     []api.Message
+		think    *api.ThinkValue
+		expected string
+	}{
+		{
+			name:     "no think",
+			msgs:     []api.Message{{Role: "user", Content: "hi"}},
+			expected: "<s>[user:hi]//True></s>",
+		},
+		{
+			name:     "think",
+			msgs:     []api.Message{{Role: "user", Content: "hi"}},
+			think:    &api.ThinkValue{Value: true},
+			expected: "<s>[user:hi]True//True></s>",
+		},
+		{
+			name:     "think level",
+			msgs:     []api.Message{{Role: "user", Content: "hi"}},
+			think:    &api.ThinkValue{Value: "high"},
+			expected: "<s>[user:hi]True/high/True></s>",
+		},
+		{
+			name:     "reasoning content",
+			msgs:     []api.Message{{Role: "user", Content: "hi"}, {Role: "assistant", Thinking: "hmm", Content: "hello"}, {Role: "user", Content: "bye"}},
+			expected: "<s>[user:hi][assistant:hello|hmm][user:bye]//True></s>",
+		},
+		{
+			name:     "prefill",
+			msgs:     []api.Message{{Role: "user", Content: "hi"}, {Role: "assistant", Content: "hel"}},
+			expected: "<s>[user:hi][assistant:hel",
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			actual, err := r.Render(tt.msgs, nil, tt.think)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if diff := cmp.Diff(tt.expected, actual); diff != "" {
+				t.Errorf("mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package renderers
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/ollama/ollama/api"
+)
+
+// Llama3Renderer renders prompts for Llama 3.1 and later following their
+// Hugging Face chat template. Tools are described in the first user message
+// and tool results are sent with the ipython role.
+type Llama3Renderer struct {
+	// now returns the date given in the system prompt, time.Now if nil
+	now func() time.Time
+}
+
+func llama3Header(role string) string {
+	return "<|start_header_id|>" + role + "<|end_header_id|>\n\n"
+}
+
+// marshalIndent marshals v like Python's json.dumps(v, indent=4)
+func marshalIndent(v any) ([]byte, error) {
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "    ")
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(b.Bytes(), []byte("\n")), nil
+}
+
+func (r *Llama3Renderer) Render(messages []api.Message, tools []api.Tool, _ *api.ThinkValue) (string, error) {
+	var sb strings.Builder
+
+	var system string
+	if len(messages) > 0 && messages[0].Role == "system" {
+		system = strings.TrimSpace(messages[0].Content)
+		messages = messages[1:]
+	}
+
+	now := time.Now
+	if r.now != nil {
+		now = r.now
+	}
+
+	sb.WriteString(llama3Header("system"))
+	if len(tools) > 0 {
+		sb.WriteString("Environment: ipython\n")
+	}
+	sb.WriteString("Cutting Knowledge Date: December 2023\n")
+	sb.WriteString("Today Date: " + now().Format("02 Jan 2006") + "\n\n")
+	sb.WriteString(system + "<|eot_id|>")
+
+	toolsPending := len(tools) > 0
+	for i, message := range messages {
+		prefill := i == len(messages)-1 && message.Role == "assistant" && len(message.ToolCalls) == 0
+
+		switch {
+		case message.Role == "user" && toolsPending:
+			toolsPending = false
+			sb.WriteString(llama3Header("user"))
+			sb.WriteString("Given the following functions, please respond with a JSON for a function call with its proper arguments that best answers the given prompt.\n\n")
+			sb.WriteString(`Respond in the format {"name": function name, "parameters": dictionary of argument name and its value}.`)
+			sb.WriteString("Do not use variables.\n\n")
+			for _, tool := range tools {
+				b, err := marshalIndent(tool)
+				if err != nil {
+					return "", err
+				}
+				sb.Write(b)
+				sb.WriteString("\n\n")
+			}
+			sb.WriteString(strings.TrimSpace(message.Content) + "<|eot_id|>")
+		case message.Role == "assistant" && len(message.ToolCalls) > 0:
+			sb.WriteString(llama3Header("assistant"))
+			for j, toolCall := range message.ToolCalls {
+				if j > 0 {
+					sb.WriteString("; ")
+				}
+
+				b, err := marshalWithSpaces(toolCall.Function.Arguments)
+				if err != nil {
+					return "", err
+				}
+				sb.WriteString(`{"name": "` + toolCall.Function.Name + `", "parameters": ` + string(b) + "}")
+			}
+			sb.WriteString("<|eot_id|>")
+		case message.Role == "tool":
+			sb.WriteString(llama3Header("ipython") + strings.TrimSpace(message.Content) + "<|eot_id|>")
+		case prefill:
+			sb.WriteString(llama3Header("assistant") + strings.TrimSpace(message.Content))
+		default:
+			sb.WriteString(llama3Header(message.Role) + strings.TrimSpace(message.Content) + "<|eot_id|>")
+		}
+
+		if i == len(messages)-1 && !prefill {
+			sb.WriteString(llama3Header("assistant"))
+		}
+	}
+
+	return sb.String(), nil
+}
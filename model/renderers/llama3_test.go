@@ -0,0 +1,136 @@
+package renderers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/ollama/ollama/api"
+)
+
+// weatherTool and weatherCall are shared by the renderer tests
+var weatherTool = api.Tool{
+	Type: "function",
+	Function: api.ToolFunction{
+		Name:        "get_weather",
+		Description: "Get the weather",
+		Parameters: api.ToolFunctionParameters{
+			Type:     "object",
+			Required: []string{"city"},
+			Properties: map[string]api.ToolProperty{
+				"city": {Type: api.PropertyType{"string"}},
+			},
+		},
+	},
+}
+
+func weatherCall(city string) api.ToolCall {
+	return api.ToolCall{Function: api.ToolCallFunction{Name: "get_weather", Arguments: api.ToolCallFunctionArguments{"city": city}}}
+}
+
+func TestLlama3Renderer(t *testing.T) {
+	tests := []struct {
+		name     string
+		msgs     []api.Message
+		tools    []api.Tool
+		expected string
+	}{
+		{
+			name: "basic",
+			msgs: []api.Message{
+				{Role: "system", Content: "Be brief."},
+				{Role: "user", Content: "Hello!"},
+			},
+			expected: `<|start_header_id|>system<|end_header_id|>
+
+Cutting Knowledge Date: December 2023
+Today Date: 26 Jul 2025
+
+Be brief.<|eot_id|><|start_header_id|>user<|end_header_id|>
+
+Hello!<|eot_id|><|start_header_id|>assistant<|end_header_id|>
+
+`,
+		},
+		{
+			name: "prefill",
+			msgs: []api.Message{
+				{Role: "user", Content: "Tell me a story"},
+				{Role: "assistant", Content: "Once upon a time"},
+			},
+			expected: `<|start_header_id|>system<|end_header_id|>
+
+Cutting Knowledge Date: December 2023
+Today Date: 26 Jul 2025
+
+<|eot_id|><|start_header_id|>user<|end_header_id|>
+
+Tell me a story<|eot_id|><|start_header_id|>assistant<|end_header_id|>
+
+Once upon a time`,
+		},
+		{
+			name: "tool calls",
+			msgs: []api.Message{
+				{Role: "user", Content: "Weather in Paris and London?"},
+				{Role: "assistant", ToolCalls: []api.ToolCall{weatherCall("Paris"), weatherCall("London")}},
+				{Role: "tool", Content: "18C"},
+				{Role: "tool", Content: "14C"},
+			},
+			tools: []api.Tool{weatherTool},
+			expected: `<|start_header_id|>system<|end_header_id|>
+
+Environment: ipython
+Cutting Knowledge Date: December 2023
+Today Date: 26 Jul 2025
+
+<|eot_id|><|start_header_id|>user<|end_header_id|>
+
+Given the following functions, please respond with a JSON for a function call with its proper arguments that best answers the given prompt.
+
+Respond in the format {"name": function name, "parameters": dictionary of argument name and its value}.Do not use variables.
+
+{
+    "type": "function",
+    "function": {
+        "name": "get_weather",
+        "description": "Get the weather",
+        "parameters": {
+            "type": "object",
+            "required": [
+                "city"
+            ],
+            "properties": {
+                "city": {
+                    "type": "string"
+                }
+            }
+        }
+    }
+}
+
+Weather in Paris and London?<|eot_id|><|start_header_id|>assistant<|end_header_id|>
+
+{"name": "get_weather", "parameters": {"city": "Paris"}}; {"name": "get_weather", "parameters": {"city": "London"}}<|eot_id|><|start_header_id|>ipython<|end_header_id|>
+
+18C<|eot_id|><|start_header_id|>ipython<|end_header_id|>
+
+14C<|eot_id|><|start_header_id|>assistant<|end_header_id|>
+
+`,
+		},
+	}
+
+	r := &Llama3Renderer{now: func() time.Time { return time.Date(2025, 7, 26, 0, 0, 0, 0, time.UTC) }}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rendered, err := r.Render(tt.msgs, tt.tools, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(tt.expected, rendered); diff != "" {
+				t.Errorf("mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,76 @@
+package renderers
+
+import (
+	"strings"
+
+	"github.com/ollama/ollama/api"
+)
+
+// MistralRenderer renders prompts for Mistral models in the format of the v7
+// tokenizer. Tools are listed before the last user message.
+type MistralRenderer struct{}
+
+type mistralToolCall struct {
+	Name      string                        `json:"name"`
+	Arguments api.ToolCallFunctionArguments `json:"arguments"`
+	ID        string                        `json:"id,omitempty"`
+}
+
+type mistralToolResult struct {
+	Content string `json:"content"`
+	CallID  string `json:"call_id,omitempty"`
+}
+
+func (r *MistralRenderer) Render(messages []api.Message, tools []api.Tool, _ *api.ThinkValue) (string, error) {
+	var sb strings.Builder
+
+	lastUser := -1
+	for i, message := range messages {
+		if message.Role == "user" {
+			lastUser = i
+		}
+	}
+
+	for i, message := range messages {
+		switch message.Role {
+		case "system":
+			sb.WriteString("[SYSTEM_PROMPT]" + message.Content + "[/SYSTEM_PROMPT]")
+		case "user":
+			if i == lastUser && len(tools) > 0 {
+				b, err := marshalWithSpaces(tools)
+				if err != nil {
+					return "", err
+				}
+				sb.WriteString("[AVAILABLE_TOOLS]" + string(b) + "[/AVAILABLE_TOOLS]")
+			}
+			sb.WriteString("[INST]" + message.Content + "[/INST]")
+		case "assistant":
+			sb.WriteString(message.Content)
+			if len(message.ToolCalls) > 0 {
+				calls := make([]mistralToolCall, len(message.ToolCalls))
+				for j, toolCall := range message.ToolCalls {
+					calls[j] = mistralToolCall{Name: toolCall.Function.Name, Arguments: toolCall.Function.Arguments, ID: toolCall.ID}
+				}
+
+				b, err := marshalWithSpaces(calls)
+				if err != nil {
+					return "", err
+				}
+				sb.WriteString("[TOOL_CALLS]" + string(b))
+			}
+
+			// a final assistant message without tool calls is continued
+			if i < len(messages)-1 || len(message.ToolCalls) > 0 {
+				sb.WriteString("</s>")
+			}
+		case "tool":
+			b, err := marshalWithSpaces(mistralToolResult{Content: message.Content, CallID: message.ToolCallID})
+			if err != nil {
+				return "", err
+			}
+			sb.WriteString("[TOOL_RESULTS]" + string(b) + "[/TOOL_RESULTS]")
+		}
+	}
+
+	return sb.String(), nil
+}
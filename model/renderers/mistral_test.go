@@ -0,0 +1,60 @@
+package renderers
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/ollama/ollama/api"
+)
+
+func TestMistralRenderer(t *testing.T) {
+	tests := []struct {
+		name     string
+		msgs     []api.Message
+		tools    []api.Tool
+		expected string
+	}{
+		{
+			name: "basic",
+			msgs: []api.Message{
+				{Role: "system", Content: "Be brief."},
+				{Role: "user", Content: "Hello!"},
+				{Role: "assistant", Content: "Hi!"},
+				{Role: "user", Content: "How are you?"},
+			},
+			expected: `[SYSTEM_PROMPT]Be brief.[/SYSTEM_PROMPT][INST]Hello![/INST]Hi!</s>[INST]How are you?[/INST]`,
+		},
+		{
+			name: "prefill",
+			msgs: []api.Message{
+				{Role: "user", Content: "Tell me a story"},
+				{Role: "assistant", Content: "Once upon a time"},
+			},
+			expected: `[INST]Tell me a story[/INST]Once upon a time`,
+		},
+		{
+			name: "tool calls",
+			msgs: []api.Message{
+				{Role: "user", Content: "Weather in Paris?"},
+				{Role: "assistant", ToolCalls: []api.ToolCall{{ID: "a1b2c3d4e", Function: weatherCall("Paris").Function}}},
+				{Role: "tool", Content: "18C", ToolCallID: "a1b2c3d4e"},
+				{Role: "assistant", Content: "It's 18C."},
+				{Role: "user", Content: "And London?"},
+			},
+			tools:    []api.Tool{weatherTool},
+			expected: `[INST]Weather in Paris?[/INST][TOOL_CALLS][{"name": "get_weather", "arguments": {"city": "Paris"}, "id": "a1b2c3d4e"}]</s>[TOOL_RESULTS]{"content": "18C", "call_id": "a1b2c3d4e"}[/TOOL_RESULTS]It's 18C.</s>[AVAILABLE_TOOLS][{"type": "function", "function": {"name": "get_weather", "description": "Get the weather", "parameters": {"type": "object", "required": ["city"], "properties": {"city": {"type": "string"}}}}}][/AVAILABLE_TOOLS][INST]And London?[/INST]`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rendered, err := (&MistralRenderer{}).Render(tt.msgs, tt.tools, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(tt.expected, rendered); diff != "" {
+				t.Errorf("mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -75,18 +75,7 @@ func (r *Qwen3VLRenderer) Render(messages []api.Message, tools []api.Tool, _ *ap
 	var sb strings.Builder
 
 	if len(tools) > 0 {
-		sb.WriteString(imStartTag + "system\n")
-		if len(messages) > 0 && messages[0].Role == "system" {
-			sb.WriteString(messages[0].Content + "\n\n")
-		}
-		sb.WriteString("# Tools\n\nYou may call one or more functions to assist with the user query.\n\nYou are provided with function signatures within <tools></tools> XML tags:\n<tools>")
-		for _, tool := range tools {
-			sb.WriteString("\n")
-			if b, err := marshalWithSpaces(tool); err == nil {
-				sb.Write(b)
-			}
-		}
-		sb.WriteString("\n</tools>\n\nFor each function call, return a json object with function name and arguments within <tool_call></tool_call> XML tags:\n<tool_call>\n{\"name\": <function-name>, \"arguments\": <args-json-object>}\n</tool_call><|im_end|>\n")
+		writeHermesTools(&sb, messages, tools)
 	} else if len(messages) > 0 && messages[0].Role == "system" {
 		sb.WriteString("<|im_start|>system\n" + messages[0].Content + "<|im_end|>\n")
 	}
@@ -42,6 +42,29 @@ func RenderWithRenderer(name string, msgs []api.Message, tools []api.Tool, think
 	return renderer.Render(msgs, tools, think)
 }
 
+// RenderForModel renders a prompt with the renderer named name, which may be
+// configured by the model file at modelPath
+func RenderForModel(name, modelPath string, msgs []api.Message, tools []api.Tool, think *api.ThinkValue) (string, error) {
+	renderer, err := rendererForModel(name, modelPath)
+	if err != nil {
+		return "", err
+	}
+	return renderer.Render(msgs, tools, think)
+}
+
+func rendererForModel(name, modelPath string) (Renderer, error) {
+	switch name {
+	case "jinja":
+		return JinjaRendererForModel(modelPath)
+	}
+
+	renderer := rendererForName(name)
+	if renderer == nil {
+		return nil, fmt.Errorf("unknown renderer %q", name)
+	}
+	return renderer, nil
+}
+
 func rendererForName(name string) Renderer {
 	if constructor, ok := registry.renderers[name]; ok {
 		return constructor()
@@ -56,6 +79,16 @@ func rendererForName(name string) Renderer {
 	case "qwen3-vl-thinking":
 		renderer := &Qwen3VLRenderer{isThinking: true, useImgTags: RenderImgTags}
 		return renderer
+	case "llama3":
+		return &Llama3Renderer{}
+	case "mistral":
+		return &MistralRenderer{}
+	case "hermes":
+		return &HermesRenderer{}
+	case "deepseek":
+		return &DeepSeekRenderer{}
+	case "gemma3":
+		return &Gemma3Renderer{}
 	default:
 		return nil
 	}
@@ -65,3 +65,13 @@ func TestUnknownRendererReturnsError(t *testing.T) {
 		t.Error("expected error for unknown renderer")
 	}
 }
+
+func TestBuiltInRenderers(t *testing.T) {
+	for _, name := range []string{"llama3", "mistral", "hermes", "deepseek", "gemma3"} {
+		t.Run(name, func(t *testing.T) {
+			if rendererForName(name) == nil {
+				t.Fatalf("expected built-in renderer %q to exist", name)
+			}
+		})
+	}
+}
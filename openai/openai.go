@@ -67,6 +67,12 @@ type Usage struct {
 type ResponseFormat struct {
 	Type       string      `json:"type"`
 	JsonSchema *JsonSchema `json:"json_schema,omitempty"`
+
+	// Pattern and Values are extensions for the "regex" and "choice" types,
+	// which constrain the response to match a regular expression or to be one
+	// of a list of values
+	Pattern string   `json:"pattern,omitempty"`
+	Values  []string `json:"values,omitempty"`
 }
 
 type JsonSchema struct {
@@ -102,6 +108,7 @@ type ChatCompletionRequest struct {
 	TopP             *float64        `json:"top_p"`
 	ResponseFormat   *ResponseFormat `json:"response_format"`
 	Tools            []api.Tool      `json:"tools"`
+	ToolChoice       *api.ToolChoice `json:"tool_choice,omitempty"`
 	Reasoning        *Reasoning      `json:"reasoning,omitempty"`
 	ReasoningEffort  *string         `json:"reasoning_effort,omitempty"`
 	DebugRenderOnly  bool            `json:"_debug_render_only"`
@@ -166,13 +173,17 @@ type CompletionChunk struct {
 }
 
 type ToolCall struct {
-	ID       string `json:"id"`
+	ID       string `json:"id,omitempty"`
 	Index    int    `json:"index"`
-	Type     string `json:"type"`
+	Type     string `json:"type,omitempty"`
 	Function struct {
-		Name      string `json:"name"`
+		Name      string `json:"name,omitempty"`
 		Arguments string `json:"arguments"`
 	} `json:"function"`
+
+	// Errors lists the arguments of a call to a strict function that don't
+	// match its parameters. It is an Ollama extension.
+	Errors []api.ToolCallError `json:"errors,omitempty"`
 }
 
 type Model struct {
@@ -236,6 +247,7 @@ func ToToolCalls(tc []api.ToolCall) []ToolCall {
 		toolCalls[i].Type = "function"
 		toolCalls[i].Function.Name = tc.Function.Name
 		toolCalls[i].Index = tc.Function.Index
+		toolCalls[i].Errors = tc.Errors
 
 		args, err := json.Marshal(tc.Function.Arguments)
 		if err != nil {
@@ -248,6 +260,22 @@ func ToToolCalls(tc []api.ToolCall) []ToolCall {
 	return toolCalls
 }
 
+// ToToolCallDeltas converts streamed tool call deltas to chunk tool calls. Only
+// the first chunk of a call carries its id, type and name
+func ToToolCallDeltas(deltas []api.ToolCallDelta) []ToolCall {
+	toolCalls := make([]ToolCall, len(deltas))
+	for i, d := range deltas {
+		toolCalls[i].Index = d.Index
+		if d.Name != "" {
+			toolCalls[i].ID = d.ID
+			toolCalls[i].Type = "function"
+			toolCalls[i].Function.Name = d.Name
+		}
+		toolCalls[i].Function.Arguments = d.Arguments
+	}
+	return toolCalls
+}
+
 // ToChatCompletion converts an api.ChatResponse to ChatCompletion
 func ToChatCompletion(id string, r api.ChatResponse) ChatCompletion {
 	toolCalls := ToToolCalls(r.Message.ToolCalls)
@@ -276,7 +304,7 @@ func ToChatCompletion(id string, r api.ChatResponse) ChatCompletion {
 
 // ToChunk converts an api.ChatResponse to ChatCompletionChunk
 func ToChunk(id string, r api.ChatResponse, toolCallSent bool) ChatCompletionChunk {
-	toolCalls := ToToolCalls(r.Message.ToolCalls)
+	toolCalls := append(ToToolCallDeltas(r.Message.ToolCallDeltas), ToToolCalls(r.Message.ToolCalls)...)
 	return ChatCompletionChunk{
 		Id:                id,
 		Object:            "chat.completion.chunk",
@@ -572,6 +600,10 @@ func FromChatRequest(r ChatCompletionRequest) (*api.ChatRequest, error) {
 			if r.ResponseFormat.JsonSchema != nil {
 				format = r.ResponseFormat.JsonSchema.Schema
 			}
+		case "regex":
+			format, _ = json.Marshal(map[string]any{"type": "regex", "pattern": r.ResponseFormat.Pattern})
+		case "choice":
+			format, _ = json.Marshal(map[string]any{"type": "choice", "values": r.ResponseFormat.Values})
 		}
 	}
 
@@ -603,6 +635,7 @@ func FromChatRequest(r ChatCompletionRequest) (*api.ChatRequest, error) {
 		Options:         options,
 		Stream:          &r.Stream,
 		Tools:           r.Tools,
+		ToolChoice:      r.ToolChoice,
 		Think:           think,
 		DebugRenderOnly: r.DebugRenderOnly,
 	}, nil
@@ -2,6 +2,7 @@ package openai
 
 import (
 	"encoding/base64"
+	"encoding/json"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
@@ -189,7 +190,7 @@ func TestToToolCallsPreservesIDs(t *testing.T) {
 			Type:  "function",
 			Index: 2,
 			Function: struct {
-				Name      string `json:"name"`
+				Name      string `json:"name,omitempty"`
 				Arguments string `json:"arguments"`
 			}{
 				Name:      "get_weather",
@@ -201,7 +202,7 @@ func TestToToolCallsPreservesIDs(t *testing.T) {
 			Type:  "function",
 			Index: 7,
 			Function: struct {
-				Name      string `json:"name"`
+				Name      string `json:"name,omitempty"`
 				Arguments string `json:"arguments"`
 			}{
 				Name:      "get_time",
@@ -218,3 +219,34 @@ func TestToToolCallsPreservesIDs(t *testing.T) {
 		t.Errorf("input tool calls mutated (-want +got):\n%s", diff)
 	}
 }
+
+func TestToChunkToolCallDeltas(t *testing.T) {
+	r := api.ChatResponse{
+		Model: "test",
+		Message: api.Message{
+			Role: "assistant",
+			ToolCallDeltas: []api.ToolCallDelta{
+				{ID: "call_abc123", Name: "get_weather", Arguments: `{"location": "Sea`},
+				{ID: "call_abc123", Arguments: `ttle"}`},
+				{ID: "call_def456", Index: 1, Name: "get_time", Arguments: `{`},
+			},
+		},
+	}
+
+	c := ToChunk("chatcmpl-1", r, false)
+	got, err := json.Marshal(c.Choices[0].Delta.ToolCalls)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `[{"id":"call_abc123","index":0,"type":"function","function":{"name":"get_weather","arguments":"{\"location\": \"Sea"}},` +
+		`{"index":0,"function":{"arguments":"ttle\"}"}},` +
+		`{"id":"call_def456","index":1,"type":"function","function":{"name":"get_time","arguments":"{"}}]`
+	if diff := cmp.Diff(expected, string(got)); diff != "" {
+		t.Errorf("tool call deltas mismatch (-want +got):\n%s", diff)
+	}
+
+	if c.Choices[0].FinishReason != nil {
+		t.Errorf("expected no finish reason, got %q", *c.Choices[0].FinishReason)
+	}
+}
@@ -11,6 +11,7 @@ import (
 	"os"
 	"os/user"
 	"path/filepath"
+	"regexp"
 	"runtime"
 	"slices"
 	"strconv"
@@ -82,7 +83,7 @@ func (f Modelfile) CreateRequest(relativeDir string) (*api.CreateRequest, error)
 					req.Files[k] = v
 				}
 			}
-		case "adapter":
+		case "adapter", "merge_adapter":
 			path, err := expandPath(c.Args, relativeDir)
 			if err != nil {
 				return nil, err
@@ -94,6 +95,31 @@ func (f Modelfile) CreateRequest(relativeDir string) (*api.CreateRequest, error)
 			}
 
 			req.Adapters = digestMap
+			req.MergeAdapters = c.Name == "merge_adapter"
+		case "imatrix":
+			path, err := expandPath(c.Args, relativeDir)
+			if err != nil {
+				return nil, err
+			}
+
+			digestMap, err := fileDigestMap(path)
+			if err != nil {
+				return nil, err
+			}
+
+			req.Imatrix = digestMap
+		case "quantize":
+			for line := range strings.Lines(c.Args) {
+				switch fields := strings.Fields(line); len(fields) {
+				case 0:
+				case 1:
+					req.Quantize = fields[0]
+				case 2:
+					req.QuantizeRules = append(req.QuantizeRules, api.QuantizeRule{Pattern: fields[0], Type: fields[1]})
+				default:
+					return nil, fmt.Errorf("invalid quantize rule %q: must be a pattern followed by a type", strings.TrimSpace(line))
+				}
+			}
 		case "template":
 			req.Template = c.Args
 		case "system":
@@ -327,7 +353,7 @@ func (c Command) String() string {
 	switch c.Name {
 	case "model":
 		fmt.Fprintf(&sb, "FROM %s", c.Args)
-	case "license", "template", "system", "adapter", "renderer", "parser":
+	case "license", "template", "system", "adapter", "merge_adapter", "imatrix", "quantize", "renderer", "parser":
 		fmt.Fprintf(&sb, "%s %s", strings.ToUpper(c.Name), quote(c.Args))
 	case "message":
 		role, message, _ := strings.Cut(c.Args, ": ")
@@ -353,29 +379,226 @@ const (
 var (
 	errMissingFrom        = errors.New("no FROM line")
 	errInvalidMessageRole = errors.New("message role must be one of \"system\", \"user\", or \"assistant\"")
-	errInvalidCommand     = errors.New("command must be one of \"from\", \"license\", \"template\", \"system\", \"adapter\", \"renderer\", \"parser\", \"parameter\", or \"message\"")
+	errInvalidCommand     = errors.New("command must be one of \"from\", \"license\", \"template\", \"system\", \"adapter\", \"merge_adapter\", \"imatrix\", \"quantize\", \"renderer\", \"parser\", \"parameter\", \"message\", \"include\", or \"arg\"")
+	errIncludeCycle       = errors.New("include cycle")
 )
 
 type ParserError struct {
+	// Filename is the file the error occurred in. It is empty for errors
+	// in a Modelfile read without a name.
+	Filename   string
 	LineNumber int
 	Msg        string
 }
 
 func (e *ParserError) Error() string {
+	var sb strings.Builder
+	if e.Filename != "" {
+		sb.WriteString(e.Filename)
+		sb.WriteString(" ")
+	}
+
 	if e.LineNumber > 0 {
-		return fmt.Sprintf("(line %d): %s", e.LineNumber, e.Msg)
+		fmt.Fprintf(&sb, "(line %d): ", e.LineNumber)
+	} else if e.Filename != "" {
+		sb.WriteString(": ")
 	}
-	return e.Msg
+
+	sb.WriteString(e.Msg)
+	return sb.String()
+}
+
+// ParseOptions configures [ParseFileWithOptions].
+type ParseOptions struct {
+	// Filename is the path of the Modelfile. INCLUDE paths are resolved
+	// relative to its directory.
+	Filename string
+
+	// BuildArgs sets the values of ARGs. They take precedence over the
+	// environment and the defaults in the Modelfile.
+	BuildArgs map[string]string
 }
 
 func ParseFile(r io.Reader) (*Modelfile, error) {
+	return ParseFileWithOptions(r, ParseOptions{})
+}
+
+// ParseFileWithOptions parses the Modelfile read from r, flattening INCLUDEs
+// and substituting ARGs. The returned Modelfile contains neither.
+func ParseFileWithOptions(r io.Reader, opts ParseOptions) (*Modelfile, error) {
+	p := preprocessor{
+		opts: opts,
+		args: make(map[string]string),
+	}
+
+	if opts.Filename != "" {
+		path, err := filepath.Abs(opts.Filename)
+		if err != nil {
+			return nil, err
+		}
+
+		p.dir = filepath.Dir(path)
+		p.stack = []string{path}
+	}
+
+	var f Modelfile
+	if err := p.parse(r, opts.Filename, p.dir, &f); err != nil {
+		return nil, err
+	}
+
+	if !slices.ContainsFunc(f.Commands, func(c Command) bool { return c.Name == "model" }) {
+		return nil, errMissingFrom
+	}
+
+	return &f, nil
+}
+
+// preprocessor flattens INCLUDEs and substitutes ARGs
+type preprocessor struct {
+	opts ParseOptions
+
+	// dir is the directory of the top level Modelfile
+	dir string
+
+	// stack is the absolute paths of the files being parsed, used to
+	// detect include cycles
+	stack []string
+
+	args map[string]string
+}
+
+var argRegexp = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// expand substitutes ${NAME} in s for each declared ARG. References to
+// undeclared names are left as is.
+func (p *preprocessor) expand(s string) string {
+	return argRegexp.ReplaceAllStringFunc(s, func(m string) string {
+		if v, ok := p.args[m[2:len(m)-1]]; ok {
+			return v
+		}
+
+		return m
+	})
+}
+
+// parse appends the commands read from r to f. name is used in errors and
+// dir is the directory relative paths are resolved against.
+func (p *preprocessor) parse(r io.Reader, name, dir string, f *Modelfile) error {
+	cmds, lines, err := parseCommands(r)
+	var pErr *ParserError
+	if errors.As(err, &pErr) {
+		pErr.Filename = name
+		return pErr
+	} else if err != nil {
+		if name != "" {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		return err
+	}
+
+	for i, c := range cmds {
+		switch c.Name {
+		case "arg":
+			k, v, err := p.arg(c.Args)
+			if err != nil {
+				return &ParserError{Filename: name, LineNumber: lines[i], Msg: err.Error()}
+			}
+
+			p.args[k] = v
+		case "include":
+			path, err := expandPath(p.expand(c.Args), dir)
+			if err != nil {
+				return &ParserError{Filename: name, LineNumber: lines[i], Msg: err.Error()}
+			}
+
+			if slices.Contains(p.stack, path) {
+				return &ParserError{Filename: name, LineNumber: lines[i], Msg: fmt.Sprintf("%s: %s", errIncludeCycle, c.Args)}
+			}
+
+			if err := p.include(path, f); errors.As(err, &pErr) {
+				return err
+			} else if err != nil {
+				return &ParserError{Filename: name, LineNumber: lines[i], Msg: err.Error()}
+			}
+		default:
+			c.Args = p.expand(c.Args)
+
+			// paths in included files are relative to the file they're in,
+			// which may not be the directory the Modelfile is created from
+			if (c.Name == "model" || c.Name == "adapter" || c.Name == "merge_adapter" || c.Name == "imatrix") && dir != p.dir {
+				if path, err := expandPath(c.Args, dir); err == nil {
+					if _, err := os.Stat(path); err == nil {
+						c.Args = path
+					}
+				}
+			}
+
+			f.Commands = append(f.Commands, c)
+		}
+	}
+
+	return nil
+}
+
+func (p *preprocessor) include(path string, f *Modelfile) error {
+	r, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	// show included files relative to the top level Modelfile if possible
+	name := path
+	if rel, err := filepath.Rel(p.dir, path); err == nil && filepath.IsLocal(rel) {
+		name = rel
+	}
+
+	p.stack = append(p.stack, path)
+	defer func() { p.stack = p.stack[:len(p.stack)-1] }()
+
+	return p.parse(r, name, filepath.Dir(path), f)
+}
+
+// arg parses the declaration "NAME" or "NAME=default" and returns the name
+// and value of the ARG
+func (p *preprocessor) arg(s string) (string, string, error) {
+	k, v, hasDefault := strings.Cut(s, "=")
+	k = strings.TrimSpace(k)
+	if !argRegexp.MatchString("${" + k + "}") {
+		return "", "", fmt.Errorf("invalid ARG name %q", k)
+	}
+
+	if v, ok := p.opts.BuildArgs[k]; ok {
+		return k, v, nil
+	}
+
+	if v, ok := os.LookupEnv(k); ok {
+		return k, v, nil
+	}
+
+	if !hasDefault {
+		return "", "", fmt.Errorf("ARG %s has no value; set it with --build-arg %s=<value> or the environment", k, k)
+	}
+
+	v, ok := unquote(strings.TrimSpace(v))
+	if !ok {
+		return "", "", fmt.Errorf("invalid default for ARG %s", k)
+	}
+
+	return k, p.expand(v), nil
+}
+
+// parseCommands parses the commands read from r without any processing. It
+// also returns the line each command starts on.
+func parseCommands(r io.Reader) ([]Command, []int, error) {
 	var cmd Command
 	var curr state
 	var currLine int = 1
 	var b bytes.Buffer
 	var role string
 
-	var f Modelfile
+	var cmds []Command
+	var lines []int
 
 	tr := unicode.BOMOverride(unicode.UTF8.NewDecoder())
 	br := bufio.NewReader(transform.NewReader(r, tr))
@@ -385,7 +608,7 @@ func ParseFile(r io.Reader) (*Modelfile, error) {
 		if errors.Is(err, io.EOF) {
 			break
 		} else if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 
 		if isNewline(r) {
@@ -394,9 +617,9 @@ func ParseFile(r io.Reader) (*Modelfile, error) {
 
 		next, r, err := parseRuneForState(r, curr)
 		if errors.Is(err, io.ErrUnexpectedEOF) {
-			return nil, fmt.Errorf("%w: %s", err, b.String())
+			return nil, nil, fmt.Errorf("%w: %s", err, b.String())
 		} else if err != nil {
-			return nil, &ParserError{
+			return nil, nil, &ParserError{
 				LineNumber: currLine,
 				Msg:        err.Error(),
 			}
@@ -407,7 +630,7 @@ func ParseFile(r io.Reader) (*Modelfile, error) {
 			switch curr {
 			case stateName:
 				if !isValidCommand(b.String()) {
-					return nil, &ParserError{
+					return nil, nil, &ParserError{
 						LineNumber: currLine,
 						Msg:        errInvalidCommand.Error(),
 					}
@@ -431,20 +654,24 @@ func ParseFile(r io.Reader) (*Modelfile, error) {
 				cmd.Name = b.String()
 			case stateMessage:
 				if !isValidMessageRole(b.String()) {
-					return nil, &ParserError{
+					return nil, nil, &ParserError{
 						LineNumber: currLine,
 						Msg:        errInvalidMessageRole.Error(),
 					}
 				}
 
 				role = b.String()
-			case stateComment, stateNil:
+			case stateNil:
+				if next == stateName {
+					lines = append(lines, currLine)
+				}
+			case stateComment:
 				// pass
 			case stateValue:
 				s, ok := unquote(strings.TrimSpace(b.String()))
 				if !ok || isSpace(r) {
 					if _, err := b.WriteRune(r); err != nil {
-						return nil, err
+						return nil, nil, err
 					}
 
 					continue
@@ -456,7 +683,7 @@ func ParseFile(r io.Reader) (*Modelfile, error) {
 				}
 
 				cmd.Args = s
-				f.Commands = append(f.Commands, cmd)
+				cmds = append(cmds, cmd)
 			}
 
 			b.Reset()
@@ -465,7 +692,7 @@ func ParseFile(r io.Reader) (*Modelfile, error) {
 
 		if strconv.IsPrint(r) {
 			if _, err := b.WriteRune(r); err != nil {
-				return nil, err
+				return nil, nil, err
 			}
 		}
 	}
@@ -477,7 +704,7 @@ func ParseFile(r io.Reader) (*Modelfile, error) {
 	case stateValue:
 		s, ok := unquote(strings.TrimSpace(b.String()))
 		if !ok {
-			return nil, io.ErrUnexpectedEOF
+			return nil, nil, io.ErrUnexpectedEOF
 		}
 
 		if role != "" {
@@ -485,18 +712,12 @@ func ParseFile(r io.Reader) (*Modelfile, error) {
 		}
 
 		cmd.Args = s
-		f.Commands = append(f.Commands, cmd)
+		cmds = append(cmds, cmd)
 	default:
-		return nil, io.ErrUnexpectedEOF
-	}
-
-	for _, cmd := range f.Commands {
-		if cmd.Name == "model" {
-			return &f, nil
-		}
+		return nil, nil, io.ErrUnexpectedEOF
 	}
 
-	return nil, errMissingFrom
+	return cmds, lines, nil
 }
 
 func parseRuneForState(r rune, cs state) (state, rune, error) {
@@ -512,7 +733,7 @@ func parseRuneForState(r rune, cs state) (state, rune, error) {
 		}
 	case stateName:
 		switch {
-		case isAlpha(r):
+		case isAlpha(r), r == '_':
 			return stateName, r, nil
 		case isSpace(r):
 			return stateValue, 0, nil
@@ -613,7 +834,7 @@ func isValidMessageRole(role string) bool {
 
 func isValidCommand(cmd string) bool {
 	switch strings.ToLower(cmd) {
-	case "from", "license", "template", "system", "adapter", "renderer", "parser", "parameter", "message":
+	case "from", "license", "template", "system", "adapter", "merge_adapter", "imatrix", "quantize", "renderer", "parser", "parameter", "message", "include", "arg":
 		return true
 	default:
 		return false
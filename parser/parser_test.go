@@ -228,6 +228,55 @@ PARSER parser1
 	assert.Equal(t, []Command{{Name: "model", Args: "foo"}, {Name: "parser", Args: "parser1"}}, modelfile.Commands)
 }
 
+func TestParseFileParserSpec(t *testing.T) {
+	input := `
+FROM foo
+PARSER """
+thinking_end </think>
+tool_call_start <tool_call>
+"""
+`
+
+	reader := strings.NewReader(input)
+
+	modelfile, err := ParseFile(reader)
+	require.NoError(t, err)
+
+	spec := "\nthinking_end </think>\ntool_call_start <tool_call>\n"
+	assert.Equal(t, []Command{{Name: "model", Args: "foo"}, {Name: "parser", Args: spec}}, modelfile.Commands)
+
+	// the spec round trips through ollama show --modelfile
+	modelfile, err = ParseFile(strings.NewReader(modelfile.String()))
+	require.NoError(t, err)
+	assert.Equal(t, spec, modelfile.Commands[1].Args)
+}
+
+func TestParseFileImatrix(t *testing.T) {
+	input := `
+FROM foo
+IMATRIX ./imatrix.gguf
+`
+
+	modelfile, err := ParseFile(strings.NewReader(input))
+	require.NoError(t, err)
+
+	assert.Equal(t, []Command{{Name: "model", Args: "foo"}, {Name: "imatrix", Args: "./imatrix.gguf"}}, modelfile.Commands)
+	assert.Equal(t, "FROM foo\nIMATRIX ./imatrix.gguf\n", modelfile.String())
+}
+
+func TestParseFileMergeAdapter(t *testing.T) {
+	input := `
+FROM foo
+MERGE_ADAPTER ./adapter.gguf
+`
+
+	modelfile, err := ParseFile(strings.NewReader(input))
+	require.NoError(t, err)
+
+	assert.Equal(t, []Command{{Name: "model", Args: "foo"}, {Name: "merge_adapter", Args: "./adapter.gguf"}}, modelfile.Commands)
+	assert.Equal(t, "FROM foo\nMERGE_ADAPTER ./adapter.gguf\n", modelfile.String())
+}
+
 func TestParseFileMessages(t *testing.T) {
 	cases := []struct {
 		input    string
@@ -756,6 +805,28 @@ MESSAGE assistant Hi! How are you?
 				},
 			},
 		},
+		{
+			`FROM test
+QUANTIZE q4_K_M
+QUANTIZE """
+blk.*.attn_v.weight   q6_K
+blk.*.ffn_down.weight q6_K
+
+token_embd.weight     q8_0
+"""
+QUANTIZE *_exps.weight q4_K
+`,
+			&api.CreateRequest{
+				From:     "test",
+				Quantize: "q4_K_M",
+				QuantizeRules: []api.QuantizeRule{
+					{Pattern: "blk.*.attn_v.weight", Type: "q6_K"},
+					{Pattern: "blk.*.ffn_down.weight", Type: "q6_K"},
+					{Pattern: "token_embd.weight", Type: "q8_0"},
+					{Pattern: "*_exps.weight", Type: "q4_K"},
+				},
+			},
+		},
 	}
 
 	for _, c := range cases {
@@ -833,6 +904,14 @@ func TestCreateRequestFiles(t *testing.T) {
 			fmt.Sprintf("FROM %s\nFROM %s", n1, n2),
 			&api.CreateRequest{Files: map[string]string{n1: d1, n2: d2}},
 		},
+		{
+			fmt.Sprintf("FROM %s\nIMATRIX %s", n1, n2),
+			&api.CreateRequest{Files: map[string]string{n1: d1}, Imatrix: map[string]string{n2: d2}},
+		},
+		{
+			fmt.Sprintf("FROM %s\nMERGE_ADAPTER %s", n1, n2),
+			&api.CreateRequest{Files: map[string]string{n1: d1}, Adapters: map[string]string{n2: d2}, MergeAdapters: true},
+		},
 	}
 
 	for _, c := range cases {
@@ -1126,3 +1205,157 @@ func TestFilesForModel(t *testing.T) {
 		})
 	}
 }
+
+func TestParseFileInclude(t *testing.T) {
+	dir := t.TempDir()
+	writeFile := func(name, content string) string {
+		t.Helper()
+		p := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+
+	writeFile("base/model.gguf", "")
+	writeFile("base/Modelfile", `FROM ./model.gguf
+INCLUDE params.Modelfile
+`)
+	writeFile("base/params.Modelfile", `PARAMETER temperature 0.5
+SYSTEM You are ${NAME}.
+`)
+
+	t.Run("nested", func(t *testing.T) {
+		name := writeFile("variant/Modelfile", `ARG NAME=Mario
+INCLUDE ../base/Modelfile
+PARAMETER temperature 1
+`)
+
+		f, err := os.Open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+
+		modelfile, err := ParseFileWithOptions(f, ParseOptions{Filename: name})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want := []Command{
+			{Name: "model", Args: filepath.Join(dir, "base", "model.gguf")},
+			{Name: "temperature", Args: "0.5"},
+			{Name: "system", Args: "You are Mario."},
+			{Name: "temperature", Args: "1"},
+		}
+
+		if diff := cmp.Diff(want, modelfile.Commands); diff != "" {
+			t.Errorf("mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("cycle", func(t *testing.T) {
+		name := writeFile("cycle/a.Modelfile", "FROM foo\nINCLUDE b.Modelfile\n")
+		writeFile("cycle/b.Modelfile", "\n\nINCLUDE a.Modelfile\n")
+
+		_, err := ParseFileWithOptions(strings.NewReader("FROM foo\nINCLUDE a.Modelfile\n"), ParseOptions{Filename: name})
+
+		var pErr *ParserError
+		if !errors.As(err, &pErr) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if pErr.Filename != name || pErr.LineNumber != 2 || !strings.Contains(pErr.Msg, errIncludeCycle.Error()) {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("error in included file", func(t *testing.T) {
+		name := writeFile("bad/Modelfile", "FROM foo\nINCLUDE sub/bad.Modelfile\n")
+		writeFile("bad/sub/bad.Modelfile", "# comment\n\nBADCOMMAND foo\n")
+
+		_, err := ParseFileWithOptions(strings.NewReader("FROM foo\nINCLUDE sub/bad.Modelfile\n"), ParseOptions{Filename: name})
+		if want := filepath.Join("sub", "bad.Modelfile") + " (line 3): " + errInvalidCommand.Error(); err == nil || err.Error() != want {
+			t.Errorf("unexpected error: %v; want %s", err, want)
+		}
+	})
+
+	t.Run("missing file", func(t *testing.T) {
+		_, err := ParseFile(strings.NewReader("FROM foo\n\nINCLUDE " + filepath.Join(dir, "missing") + "\n"))
+
+		var pErr *ParserError
+		if !errors.As(err, &pErr) || pErr.LineNumber != 3 {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+}
+
+func TestParseFileArg(t *testing.T) {
+	input := `ARG MODEL=llama3.2
+ARG TAG
+ARG SYSTEM="${MODEL}:${TAG}"
+FROM ${MODEL}:${TAG}
+SYSTEM ${SYSTEM} ${UNDECLARED}
+`
+
+	cases := []struct {
+		name      string
+		env       map[string]string
+		buildArgs map[string]string
+		want      []Command
+		err       bool
+	}{
+		{
+			name: "missing",
+			err:  true,
+		},
+		{
+			name: "environment",
+			env:  map[string]string{"TAG": "1b"},
+			want: []Command{
+				{Name: "model", Args: "llama3.2:1b"},
+				{Name: "system", Args: "llama3.2:1b ${UNDECLARED}"},
+			},
+		},
+		{
+			name:      "build args",
+			env:       map[string]string{"TAG": "1b", "MODEL": "qwen3"},
+			buildArgs: map[string]string{"TAG": "3b", "SYSTEM": "hello"},
+			want: []Command{
+				{Name: "model", Args: "qwen3:3b"},
+				{Name: "system", Args: "hello ${UNDECLARED}"},
+			},
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, k := range []string{"MODEL", "TAG", "SYSTEM"} {
+				t.Setenv(k, "")
+				os.Unsetenv(k)
+			}
+
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+
+			modelfile, err := ParseFileWithOptions(strings.NewReader(input), ParseOptions{BuildArgs: tt.buildArgs})
+			if tt.err {
+				var pErr *ParserError
+				if !errors.As(err, &pErr) || pErr.LineNumber != 2 {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			} else if err != nil {
+				t.Fatal(err)
+			}
+
+			if diff := cmp.Diff(tt.want, modelfile.Commands); diff != "" {
+				t.Errorf("mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
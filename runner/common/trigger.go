@@ -0,0 +1,36 @@
+package common
+
+import (
+	"strings"
+)
+
+// Trigger watches generated pieces for a text, such as the one that starts
+// the part of a response a grammar applies to. The text can span pieces.
+type Trigger struct {
+	text string
+	tail string
+}
+
+func NewTrigger(text string) *Trigger {
+	return &Trigger{text: text}
+}
+
+// Add adds a generated piece and reports whether the text has been generated.
+// It keeps reporting true once it has.
+func (t *Trigger) Add(piece string) bool {
+	if t.text == "" {
+		return true
+	}
+
+	t.tail += piece
+	if strings.Contains(t.tail, t.text) {
+		t.text, t.tail = "", ""
+		return true
+	}
+
+	// only the end of what's been generated can be the start of the text
+	if n := len(t.tail) - len(t.text); n > 0 {
+		t.tail = t.tail[n:]
+	}
+	return false
+}
@@ -0,0 +1,50 @@
+package common
+
+import (
+	"testing"
+)
+
+func TestTrigger(t *testing.T) {
+	tests := []struct {
+		name     string
+		text     string
+		pieces   []string
+		expected []bool
+	}{
+		{
+			name:     "Single piece",
+			text:     "</think>",
+			pieces:   []string{"Let me think", "</think>", "{"},
+			expected: []bool{false, true, true},
+		},
+		{
+			name:     "Across pieces",
+			text:     "<|channel|>final<|message|>",
+			pieces:   []string{"<|start|>assistant", "<|channel|>", "final", "<|message|>", "{"},
+			expected: []bool{false, false, false, true, true},
+		},
+		{
+			name:     "Inside a piece",
+			text:     "</think>",
+			pieces:   []string{"done.</th", "ink>\n\n", "{"},
+			expected: []bool{false, true, true},
+		},
+		{
+			name:     "Not generated",
+			text:     "</think>",
+			pieces:   []string{"</thin", "king", "</think"},
+			expected: []bool{false, false, false},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			trigger := NewTrigger(tt.text)
+			for i, piece := range tt.pieces {
+				if got := trigger.Add(piece); got != tt.expected[i] {
+					t.Errorf("Add(%q) = %v, want %v", piece, got, tt.expected[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,102 @@
+package llamarunner
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"os"
+	"slices"
+
+	"github.com/ollama/ollama/envconfig"
+	"github.com/ollama/ollama/llama"
+	"github.com/ollama/ollama/llm"
+	"github.com/ollama/ollama/logutil"
+)
+
+// ExecuteImatrix computes the importance matrix of a model from calibration
+// text read from stdin. Progress and errors are written to stdout as
+// [llm.ImatrixStatus] lines.
+func ExecuteImatrix(args []string) error {
+	fs := flag.NewFlagSet("runner", flag.ExitOnError)
+	mpath := fs.String("model", "", "Path to model binary file")
+	numCtx := fs.Int("ctx", 512, "Number of tokens in each chunk of calibration text")
+	output := fs.String("output", "", "Path to write the importance matrix to")
+
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Runner usage\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	slog.SetDefault(logutil.NewLogger(os.Stderr, envconfig.LogLevel()))
+
+	enc := json.NewEncoder(os.Stdout)
+	if err := computeImatrix(*mpath, *numCtx, *output, func(progress float32) {
+		enc.Encode(llm.ImatrixStatus{Progress: progress}) //nolint:errcheck
+	}); err != nil {
+		enc.Encode(llm.ImatrixStatus{Error: err.Error()}) //nolint:errcheck
+		return err
+	}
+
+	return nil
+}
+
+func computeImatrix(modelPath string, numCtx int, output string, fn func(float32)) error {
+	if modelPath == "" || output == "" {
+		return errors.New("model and output are required")
+	}
+
+	text, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		return err
+	}
+
+	llama.BackendInit()
+	im, err := llama.ComputeImatrix(modelPath, string(text), numCtx, fn)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Create(output)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	if err := writeImatrixLegacy(w, im); err != nil {
+		return err
+	}
+
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	return f.Close()
+}
+
+// writeImatrixLegacy writes im in the binary format written by older
+// versions of llama.cpp. The values are already means, so each is written as
+// the sum of a single call.
+func writeImatrixLegacy(w io.Writer, im map[string][]float32) error {
+	if err := binary.Write(w, binary.LittleEndian, int32(len(im))); err != nil {
+		return err
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(im)) {
+		for _, v := range []any{int32(len(name)), []byte(name), int32(1), int32(len(im[name])), im[name]} {
+			if err := binary.Write(w, binary.LittleEndian, v); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
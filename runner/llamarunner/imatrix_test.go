@@ -0,0 +1,32 @@
+package llamarunner
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func TestWriteImatrixLegacy(t *testing.T) {
+	var b bytes.Buffer
+	if err := writeImatrixLegacy(&b, map[string][]float32{
+		"blk.0.ffn_down.weight": {1, 2},
+		"blk.0.attn_q.weight":   {3, 4, 5},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	var expected bytes.Buffer
+	for _, v := range []any{
+		int32(2),
+		int32(19), []byte("blk.0.attn_q.weight"), int32(1), int32(3), []float32{3, 4, 5},
+		int32(21), []byte("blk.0.ffn_down.weight"), int32(1), int32(2), []float32{1, 2},
+	} {
+		if err := binary.Write(&expected, binary.LittleEndian, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if !bytes.Equal(b.Bytes(), expected.Bytes()) {
+		t.Errorf("unexpected imatrix\nhave %x\nwant %x", b.Bytes(), expected.Bytes())
+	}
+}
@@ -67,6 +67,11 @@ type Sequence struct {
 
 	samplingCtx *llama.SamplingContext
 
+	// watches for the text that activates the grammar in grammarParams, nil
+	// if the grammar isn't deferred
+	grammarTrigger *common.Trigger
+	grammarParams  *llama.SamplingParams
+
 	// channel to send back the embedding if embedding only
 	embedding chan []float32
 
@@ -99,6 +104,9 @@ type NewSequenceParams struct {
 	embedding      bool
 	shift          bool
 	truncate       bool
+
+	// text that activates the grammar in samplingParams once it's generated
+	grammarTrigger string
 }
 
 var errorInputTooLong = errors.New("the input length exceeds the context length")
@@ -137,9 +145,18 @@ func (s *Server) NewSequence(prompt string, images []llm.ImageData, params NewSe
 		inputs = newInputs
 	}
 
+	samplingParams := params.samplingParams
+	var trigger *common.Trigger
+	if samplingParams != nil && samplingParams.Grammar != "" && params.grammarTrigger != "" {
+		trigger = common.NewTrigger(params.grammarTrigger)
+		withoutGrammar := *samplingParams
+		withoutGrammar.Grammar = ""
+		samplingParams = &withoutGrammar
+	}
+
 	var sc *llama.SamplingContext
-	if params.samplingParams != nil {
-		sc, err = llama.NewSamplingContext(s.model, *params.samplingParams)
+	if samplingParams != nil {
+		sc, err = llama.NewSamplingContext(s.model, *samplingParams)
 		if err != nil {
 			return nil, err
 		}
@@ -159,6 +176,8 @@ func (s *Server) NewSequence(prompt string, images []llm.ImageData, params NewSe
 		quit:             make(chan bool, 1),
 		embedding:        make(chan []float32, 1),
 		samplingCtx:      sc,
+		grammarTrigger:   trigger,
+		grammarParams:    params.samplingParams,
 		embeddingOnly:    params.embedding,
 		stop:             params.stop,
 		numKeep:          params.numKeep,
@@ -166,6 +185,27 @@ func (s *Server) NewSequence(prompt string, images []llm.ImageData, params NewSe
 	}, nil
 }
 
+// activateGrammar replaces the sampling context of a sequence with a deferred
+// grammar by one with the grammar, as a grammar can't be added to an existing
+// context. The new context accepts the sequence so far for its penalties.
+func (s *Server) activateGrammar(seq *Sequence, token int) error {
+	sc, err := llama.NewSamplingContext(s.model, *seq.grammarParams)
+	if err != nil {
+		return err
+	}
+
+	for _, input := range seq.cache.Inputs {
+		if input.embed == nil {
+			sc.Accept(input.token, false)
+		}
+	}
+	sc.Accept(token, false)
+
+	seq.samplingCtx = sc
+	seq.grammarTrigger, seq.grammarParams = nil, nil
+	return nil
+}
+
 // inputs processes the prompt and images into a list of inputs
 // by splitting the prompt on [img-<n>] tags, tokenizing text and
 // generating image embeddings for each image
@@ -514,6 +554,12 @@ func (s *Server) processBatch(tokenBatch *llama.Batch, embedBatch *llama.Batch)
 		seq.samplingCtx.Accept(token, true)
 		piece := s.model.TokenToPiece(token)
 
+		if seq.grammarTrigger != nil && seq.grammarTrigger.Add(piece) {
+			if err := s.activateGrammar(seq, token); err != nil {
+				return err
+			}
+		}
+
 		seq.numPredicted++
 
 		// if it's an end of sequence token, break
@@ -618,6 +664,7 @@ func (s *Server) completion(w http.ResponseWriter, r *http.Request) {
 		embedding:      false,
 		shift:          req.Shift,
 		truncate:       req.Truncate,
+		grammarTrigger: req.GrammarTrigger,
 	})
 	if err != nil {
 		if errors.Is(err, errorInputTooLong) {
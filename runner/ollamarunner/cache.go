@@ -7,6 +7,7 @@ import (
 	"math"
 	"time"
 
+	"github.com/ollama/ollama/fs/ggml"
 	"github.com/ollama/ollama/kvcache"
 	"github.com/ollama/ollama/ml"
 	"github.com/ollama/ollama/model"
@@ -44,9 +45,18 @@ func NewInputCache(model model.Model, kvCacheType string, kvSize int32, numSlots
 		slots[i] = InputCacheSlot{Id: i}
 	}
 
+	cacheType, err := ggml.ParseKVCacheType(kvCacheType)
+	if err != nil {
+		return nil, err
+	}
+
 	cache := model.Config().Cache
 	if cache != nil {
-		cache.Init(model.Backend(), kvCacheTypeFromStr(kvCacheType), numSlots, int(numCtx), batchSize)
+		numLayers := int(model.Backend().Config().Uint("block_count"))
+		cache.Init(model.Backend(), func(layer int) (ml.DType, ml.DType) {
+			k, v := cacheType.Layer(layer, numLayers)
+			return kvCacheTypeFromStr(k), kvCacheTypeFromStr(v)
+		}, numSlots, int(numCtx), batchSize)
 	}
 
 	return &InputCache{
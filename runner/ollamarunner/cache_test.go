@@ -7,6 +7,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/ollama/ollama/kvcache"
 	"github.com/ollama/ollama/ml"
 	"github.com/ollama/ollama/model/input"
 )
@@ -517,15 +518,15 @@ func (m *mockCache) Remove(seq int, beginIndex, endIndex int32) error {
 }
 
 // Stub implementations for other interface methods
-func (m *mockCache) SetLayer(layer int)                                                            {}
-func (m *mockCache) Get(ctx ml.Context) (ml.Tensor, ml.Tensor, ml.Tensor)                          { return nil, nil, nil }
-func (m *mockCache) Put(ctx ml.Context, key, value ml.Tensor)                                      {}
-func (m *mockCache) Init(backend ml.Backend, dtype ml.DType, maxSequences, capacity, maxBatch int) {}
-func (m *mockCache) Close()                                                                        {}
-func (m *mockCache) StartForward(ctx ml.Context, batch input.Batch, reserve bool) error            { return nil }
-func (m *mockCache) CopyPrefix(srcSeq, dstSeq int, len int32)                                      {}
-func (m *mockCache) SetConfig(ml.CacheConfig)                                                      {}
-func (m *mockCache) CanResume(seq int, pos int32) bool                                             { return true }
+func (m *mockCache) SetLayer(layer int)                                                 {}
+func (m *mockCache) Get(ctx ml.Context) (ml.Tensor, ml.Tensor, ml.Tensor)               { return nil, nil, nil }
+func (m *mockCache) Put(ctx ml.Context, key, value ml.Tensor)                           {}
+func (m *mockCache) Init(ml.Backend, kvcache.DType, int, int, int)                      {}
+func (m *mockCache) Close()                                                             {}
+func (m *mockCache) StartForward(ctx ml.Context, batch input.Batch, reserve bool) error { return nil }
+func (m *mockCache) CopyPrefix(srcSeq, dstSeq int, len int32)                           {}
+func (m *mockCache) SetConfig(ml.CacheConfig)                                           {}
+func (m *mockCache) CanResume(seq int, pos int32) bool                                  { return true }
 
 func TestShiftCacheSlot(t *testing.T) {
 	tests := []struct {
@@ -11,6 +11,7 @@ import (
 	"image"
 	"log"
 	"log/slog"
+	"math"
 	"net"
 	"net/http"
 	"os"
@@ -76,6 +77,10 @@ type Sequence struct {
 	// sampler with transforms to run on generated logits
 	sampler sample.Sampler
 
+	// watches for the text that activates the sampler's grammar, nil if the
+	// grammar isn't deferred
+	grammarTrigger *common.Trigger
+
 	// channel to send back the embedding if embedding only
 	embedding chan []float32
 
@@ -91,14 +96,25 @@ type Sequence struct {
 	// shift if context window is exceeded
 	shift bool
 
+	// maximum number of tokens to draft from the sequence's own history
+	// for speculative decoding, 0 if disabled
+	numDraft int
+
+	// drafted inputs that follow the next input to be sampled and that
+	// need to be verified once the batch containing them is computed
+	draft []*input.Input
+
 	doneReason llm.DoneReason
 
 	// Metrics
+	createdAt                time.Time
 	startedAt, lastUpdatedAt time.Time
 	processingDuration       time.Duration
 	samplingDuration         time.Duration
 	numPredicted             int
 	numPromptInputs          int
+	numDrafted               int
+	numDraftAccepted         int
 }
 
 type NewSequenceParams struct {
@@ -109,6 +125,10 @@ type NewSequenceParams struct {
 	embedding  bool
 	shift      bool
 	truncate   bool
+	numDraft   int
+
+	// text that activates the sampler's grammar once it's generated
+	grammarTrigger string
 }
 
 var errorInputTooLong = errors.New("the input length exceeds the context length")
@@ -174,7 +194,20 @@ func (s *Server) NewSequence(prompt string, images []llm.ImageData, params NewSe
 
 	// TODO(jessegross): Ingest cached history for grammar
 
+	// Verifying drafts relies on keeping the accepted portion in the cache
+	if !s.cache.enabled || params.embedding {
+		params.numDraft = 0
+	}
+	params.numDraft = min(params.numDraft, maxNumDraft)
+
+	var trigger *common.Trigger
+	if params.grammarTrigger != "" {
+		trigger = common.NewTrigger(params.grammarTrigger)
+		params.sampler.DeferGrammar()
+	}
+
 	return &Sequence{
+		createdAt:        time.Now(),
 		ctxs:             ctxs,
 		mmStore:          mmStore,
 		inputs:           inputs,
@@ -185,10 +218,12 @@ func (s *Server) NewSequence(prompt string, images []llm.ImageData, params NewSe
 		quit:             make(chan bool, 1),
 		embedding:        make(chan []float32, 1),
 		sampler:          params.sampler,
+		grammarTrigger:   trigger,
 		embeddingOnly:    params.embedding,
 		stop:             params.stop,
 		numKeep:          params.numKeep,
 		shift:            params.shift,
+		numDraft:         params.numDraft,
 	}, nil
 }
 
@@ -355,6 +390,10 @@ type Server struct {
 	// next sequence for prompt processing to avoid starvation
 	nextSeq int
 
+	// nextSeq was unable to add anything to the previous batch and
+	// should be scheduled ahead of generating sequences
+	nextSeqStarved bool
+
 	// multimodalHash generates hashes for comparing equality
 	// of non-text data
 	multimodalHash maphash.Hash
@@ -407,6 +446,78 @@ func (s *Server) removeSequence(seqIndex int, reason llm.DoneReason) {
 	s.seqsSem.Release(1)
 }
 
+// generating reports whether the only inputs left for the sequence are its
+// last sampled token (and any draft), as opposed to a prompt to process
+func (seq *Sequence) generating() bool {
+	return seq.numPredicted > 0 && len(seq.inputs) <= len(seq.draft)+1
+}
+
+// batchOrder returns the indices of the active sequences in the order that they
+// should be added to the next batch. Sequences that are generating go first so
+// that they always make progress, and prompts are processed in chunks using the
+// space that remains in the batch. Otherwise, sequences are taken round-robin
+// starting with next. If next was starved of space in the previous batch, it
+// goes ahead of everything else so that inputs which must be processed together
+// are able to fit.
+func batchOrder(seqs []*Sequence, next int, starved bool) []int {
+	var generating, prompts []int
+	for i := range seqs {
+		seqIdx := (next + i) % len(seqs)
+		seq := seqs[seqIdx]
+		switch {
+		case seq == nil:
+		case starved && seqIdx == next:
+			// this is always the first sequence visited
+			generating = append(generating, seqIdx)
+		case seq.generating():
+			generating = append(generating, seqIdx)
+		default:
+			prompts = append(prompts, seqIdx)
+		}
+	}
+
+	return append(generating, prompts...)
+}
+
+// batchInputCount returns the number of inputs of a sequence that fit in a
+// batch of batchSize already holding used inputs. Inputs that must be
+// processed together extend the batch size as needed. Prompts also leave a
+// slot for each of the reserved generating sequences that are added after
+// them, unless the batch is empty, so that they always make progress.
+func batchInputCount(inputs []*input.Input, used, batchSize, reserved int, generating bool) int {
+	if generating {
+		reserved = 0
+	}
+
+	// required is the number of inputs that have to go in the same batch as
+	// the ones that were already added
+	var n, required int
+	for i, inp := range inputs {
+		// If we are required to put following inputs into a single batch then extend the
+		// batch size. Since we are only extending the size the minimum amount possible, this
+		// will cause a break if we have existing inputs.
+		minBatch := 1 + inp.SameBatch
+		if minBatch > batchSize {
+			batchSize = minBatch
+		}
+
+		// Stop if the required batch would put us over the total batch size (including tokens
+		// added by other sequences).
+		if used+n+minBatch > batchSize {
+			break
+		}
+
+		if i >= required && used+n > 0 && used+n+minBatch > batchSize-reserved {
+			break
+		}
+
+		required = max(required, i+minBatch)
+		n++
+	}
+
+	return n
+}
+
 // track batch state between forwardBatch, computeBatch and predictForwardBatch
 
 func (s *Server) run(ctx context.Context) {
@@ -426,6 +537,13 @@ func (s *Server) run(ctx context.Context) {
 				panic(err)
 			}
 
+			if nextBatch.ctx == nil {
+				// Nothing could be scheduled, which happens when sequences are waiting on the
+				// results of the pending batch (such as to verify drafts). Wait for it to finish
+				// rather than spinning.
+				<-nextBatch.inputsReadyCh
+			}
+
 			if supportsAsync {
 				go s.computeBatch(nextBatch)
 			} else {
@@ -477,13 +595,24 @@ func (s *Server) forwardBatch(pendingBatch batchState) (nextBatch batchState, er
 	var batchOutputs []int32
 	var batch input.Batch
 
+	order := batchOrder(s.seqs, s.nextSeq, s.nextSeqStarved)
+
+	// generating sequences each keep a slot in the batch, so a starved prompt
+	// that goes ahead of them can't take all of it
+	var reserved int
+	for _, seqIdx := range order {
+		if s.seqs[seqIdx].generating() {
+			reserved++
+		}
+	}
+
 	resumeSeq := -1
-	seqIdx := s.nextSeq - 1
-	for range s.seqs {
-		seqIdx = (seqIdx + 1) % len(s.seqs)
+	for _, seqIdx := range order {
 		seq := s.seqs[seqIdx]
-		if seq == nil {
-			continue
+
+		generating := seq.generating()
+		if generating {
+			reserved--
 		}
 
 		// if past the num predict limit
@@ -498,27 +627,16 @@ func (s *Server) forwardBatch(pendingBatch batchState) (nextBatch batchState, er
 			seq.cache.Inputs = []*input.Input{}
 		}
 
-		batchSize := s.batchSize
+		// If we haven't been able to add anything then pick up here again for the next
+		// batch to avoid starvation, though we can opportunistically check if other
+		// sequences can still squeeze something in.
+		n := batchInputCount(seq.inputs, len(batchInputs), s.batchSize, reserved, generating)
+		if n == 0 && len(seq.inputs) > 0 && len(seq.pendingInputs) == 0 && resumeSeq == -1 {
+			resumeSeq = seqIdx
+		}
 
-		for i, inp := range seq.inputs {
-			// If we are required to put following inputs into a single batch then extend the
-			// batch size. Since we are only extending the size the minimum amount possible, this
-			// will cause a break if we have existing inputs.
+		for i, inp := range seq.inputs[:n] {
 			minBatch := 1 + inp.SameBatch
-			if minBatch > batchSize {
-				batchSize = minBatch
-			}
-
-			// Stop if the required batch would put us over the total batch size (including tokens
-			// added by other sequences). If we haven't been able to add anything yet then pick up
-			// here again for the next batch to avoid starvation, though we can opportunistically
-			// check if other sequences can still squeeze something in.
-			if len(batchInputs)+minBatch > batchSize {
-				if len(seq.pendingInputs) == 0 && resumeSeq == -1 {
-					resumeSeq = seqIdx
-				}
-				break
-			}
 
 			// If the sum of our working set (already processed tokens, tokens we added to this
 			// batch, required following tokens) exceeds the context size, then trigger a shift
@@ -563,8 +681,9 @@ func (s *Server) forwardBatch(pendingBatch batchState) (nextBatch batchState, er
 			batch.Positions = append(batch.Positions, int32(len(seq.cache.Inputs)+len(seq.pendingInputs)))
 			batch.Sequences = append(batch.Sequences, seq.cache.Id)
 
+			// Drafted inputs each need an output to be verified, in addition to the one before them
 			seq.iBatch = len(batchOutputs)
-			if i+1 == len(seq.inputs) || seq.embeddingOnly {
+			if i+len(seq.draft)+1 >= len(seq.inputs) || seq.embeddingOnly {
 				batchOutputs = append(batchOutputs, int32(len(batchInputs)-1))
 			}
 			logutil.Trace("forwardBatch iBatch", "batchID", s.batchID, "seqIdx", seqIdx, "seq.iBatch", seq.iBatch, "i+1", i+1, "len(seq.inputs)", len(seq.inputs))
@@ -583,9 +702,8 @@ func (s *Server) forwardBatch(pendingBatch batchState) (nextBatch batchState, er
 
 	if resumeSeq != -1 {
 		s.nextSeq = resumeSeq
-	} else {
-		s.nextSeq = seqIdx + 1
 	}
+	s.nextSeqStarved = resumeSeq != -1
 
 	if len(batchInputs) == 0 {
 		logutil.Trace("forwardBatch no batchInputs, going idle", "batchID", s.batchID)
@@ -683,7 +801,13 @@ func (s *Server) computeBatch(activeBatch batchState) {
 
 		seq.numPredicted++
 		nextToken := &input.Input{Token: 0} // placeholder we'll fill in after Compute/Floats
-		seq.inputs = []*input.Input{nextToken}
+		if seq.numDraft > 0 {
+			// Drafts can only be proposed once we know the sampled token, so this sequence
+			// sits out the next batch and is queued again after decoding
+			seq.inputs = []*input.Input{}
+		} else {
+			seq.inputs = []*input.Input{nextToken}
+		}
 		nextBatchTokens[i] = nextToken
 		iBatches[i] = seq.iBatch
 	}
@@ -729,77 +853,163 @@ func (s *Server) computeBatch(activeBatch batchState) {
 		// sample a token
 		vocabSize := len(outputs) / activeBatch.batch.Outputs.Dim(0)
 		logutil.Trace("computeBatch: vocab details", "batchID", activeBatch.id, "seqIdx", i, "len(logits)", len(outputs), "len(activeBatch.batch.Outputs)", activeBatch.batch.Outputs.Dim(0), "vocabSize", vocabSize, "iBatches", iBatches)
+		if seq.numDraft > 0 {
+			s.decodeDraft(i, seq, outputs, vocabSize, iBatches[i], nextBatchTokens[i])
+			continue
+		}
+
 		token, err := seq.sampler.Sample(outputs[iBatches[i]*vocabSize : (iBatches[i]+1)*vocabSize])
 		if err != nil {
 			panic("failed to sample token")
 		}
 
 		nextBatchTokens[i].Token = token
+		s.decodeToken(i, seq, token)
+	}
 
-		// if it's an end of sequence token, break
-		if s.model.(model.TextProcessor).Is(token, model.SpecialEOS) {
-			// TODO (jmorganca): we should send this back
-			// as it's important for the /api/generate context
-			// seq.responses <- piece
-			logutil.Trace("computeBatch: EOS", "batchID", activeBatch.id, "seqIdx", i)
-			s.removeSequence(i, llm.DoneReasonStop)
-			continue
+	samplingDuration := time.Since(t)
+	for i, seq := range s.seqs {
+		if seq != nil && nextBatchTokens[i] != nil {
+			s.seqs[i].samplingDuration += samplingDuration
 		}
+	}
+}
 
-		piece, err := s.model.(model.TextProcessor).Decode([]int32{token})
-		if err != nil {
-			panic("failed to decode token")
+// decodeToken processes a newly sampled token for the sequence at seqIdx,
+// checking for end of sequence and stop sequences and sending any completed
+// text back. The token must not yet be in the sequence's cache. The sequence
+// is removed if generation is complete.
+func (s *Server) decodeToken(seqIdx int, seq *Sequence, token int32) {
+	// if it's an end of sequence token, break
+	if s.model.(model.TextProcessor).Is(token, model.SpecialEOS) {
+		// TODO (jmorganca): we should send this back
+		// as it's important for the /api/generate context
+		// seq.responses <- piece
+		logutil.Trace("computeBatch: EOS", "seqIdx", seqIdx)
+		s.removeSequence(seqIdx, llm.DoneReasonStop)
+		return
+	}
+
+	piece, err := s.model.(model.TextProcessor).Decode([]int32{token})
+	if err != nil {
+		panic("failed to decode token")
+	}
+
+	if seq.grammarTrigger != nil && seq.grammarTrigger.Add(piece) {
+		seq.sampler.ActivateGrammar()
+		seq.grammarTrigger = nil
+	}
+
+	seq.pendingResponses = append(seq.pendingResponses, piece)
+	sequence := strings.Join(seq.pendingResponses, "")
+
+	if ok, stop := common.FindStop(sequence, seq.stop); ok {
+		slog.Debug("hit stop token", "pending", seq.pendingResponses, "stop", stop)
+
+		var tokenTruncated bool
+		origLen := len(seq.pendingResponses)
+		seq.pendingResponses, tokenTruncated = common.TruncateStop(seq.pendingResponses, stop)
+		newLen := len(seq.pendingResponses)
+
+		// Update the cache based on the tokens that will be returned:
+		// - We have 1 token more than is currently in the cache because
+		// the last one generated wasn't submitted to Decode
+		// - Remove any stop sequences that we stripped out
+		// - If truncateStop removed a portion of a token, drop that
+		// - As defense-in-depth, if truncatedToken didn't find a stop token
+		// remove the extra one that we added to the cache len
+		tokenLen := len(seq.cache.Inputs) + 1
+		tokenLen -= origLen - newLen
+		if tokenTruncated || origLen == newLen {
+			tokenLen--
 		}
 
-		seq.pendingResponses = append(seq.pendingResponses, piece)
-		sequence := strings.Join(seq.pendingResponses, "")
-
-		if ok, stop := common.FindStop(sequence, seq.stop); ok {
-			slog.Debug("hit stop token", "pending", seq.pendingResponses, "stop", stop)
-
-			var tokenTruncated bool
-			origLen := len(seq.pendingResponses)
-			seq.pendingResponses, tokenTruncated = common.TruncateStop(seq.pendingResponses, stop)
-			newLen := len(seq.pendingResponses)
-
-			// Update the cache based on the tokens that will be returned:
-			// - We have 1 token more than is currently in the cache because
-			// the last one generated wasn't submitted to Decode
-			// - Remove any stop sequences that we stripped out
-			// - If truncateStop removed a portion of a token, drop that
-			// - As defense-in-depth, if truncatedToken didn't find a stop token
-			// remove the extra one that we added to the cache len
-			tokenLen := len(seq.cache.Inputs) + 1
-			tokenLen -= origLen - newLen
-			if tokenTruncated || origLen == newLen {
-				tokenLen--
-			}
+		seq.cache.Inputs = seq.cache.Inputs[:tokenLen]
 
-			seq.cache.Inputs = seq.cache.Inputs[:tokenLen]
+		s.removeSequence(seqIdx, llm.DoneReasonStop)
+		return
+	}
 
-			s.removeSequence(i, llm.DoneReasonStop)
-			continue
+	if common.ContainsStopSuffix(sequence, seq.stop) {
+		return
+	}
+
+	if common.IncompleteUnicode(sequence) {
+		return
+	}
+
+	if !flushPending(seq) {
+		s.removeSequence(seqIdx, llm.DoneReasonConnectionClosed)
+	}
+}
+
+// decodeDraft verifies the drafted inputs of a speculative sequence against the
+// logits of its last batch, decoding each accepted token and proposing a new
+// draft for the next batch. iBatch is the output of the last drafted input.
+func (s *Server) decodeDraft(seqIdx int, seq *Sequence, outputs []float32, vocabSize int, iBatch int, next *input.Input) {
+	draft := seq.draft
+	seq.draft = nil
+
+	first := iBatch - len(draft)
+	tokens, err := verifyDraft(&seq.sampler, outputs[first*vocabSize:(iBatch+1)*vocabSize], vocabSize, draft)
+	if err != nil {
+		panic("failed to sample token")
+	}
+
+	accepted := len(tokens) - 1
+	seq.numDraftAccepted += accepted
+
+	// All drafts were added to the cache with the batch. Rewind to just before them and
+	// add back the accepted ones as they are decoded, since decodeToken expects the
+	// token that it is processing to not be in the cache yet.
+	base := len(seq.cache.Inputs) - len(draft)
+	if len(draft) > 0 {
+		seq.cache.Inputs[base-1].SameBatch = 0
+	}
+	seq.cache.Inputs = seq.cache.Inputs[:base]
+
+	if accepted < len(draft) {
+		// Removing the end of a sequence is always supported by the cache
+		if err := s.cache.cache.Remove(seq.cache.Id, int32(base+accepted), math.MaxInt32); err != nil {
+			panic(fmt.Errorf("failed to remove rejected draft: %w", err))
 		}
+	}
 
-		if common.ContainsStopSuffix(sequence, seq.stop) {
-			continue
+	for j, token := range tokens {
+		if j > 0 {
+			seq.numPredicted++
 		}
 
-		if common.IncompleteUnicode(sequence) {
-			continue
+		s.decodeToken(seqIdx, seq, token)
+		if s.seqs[seqIdx] != seq {
+			return
 		}
 
-		if !flushPending(seq) {
-			s.removeSequence(i, llm.DoneReasonConnectionClosed)
+		if j < accepted {
+			seq.cache.Inputs = append(seq.cache.Inputs, draft[j])
 		}
 	}
 
-	samplingDuration := time.Since(t)
-	for i, seq := range s.seqs {
-		if seq != nil && nextBatchTokens[i] != nil {
-			s.seqs[i].samplingDuration += samplingDuration
-		}
+	next.Token = tokens[accepted]
+
+	limit := seq.numDraft
+	limit = min(limit, s.batchSize-1)
+	limit = min(limit, int(s.cache.numCtx)-len(seq.cache.Inputs)-1)
+	if seq.numPredict > 0 {
+		limit = min(limit, seq.numPredict-seq.numPredicted-1)
 	}
+	// drafts sampled before a deferred grammar is activated wouldn't be
+	// checked against it, so drafting waits until it is
+	if seq.grammarTrigger != nil {
+		limit = 0
+	}
+
+	seq.draft = proposeDraft(seq.cache.Inputs, next, limit)
+	seq.numDrafted += len(seq.draft)
+
+	// The draft must be verified in the same batch as the input it follows
+	next.SameBatch = len(seq.draft)
+	seq.inputs = append([]*input.Input{next}, seq.draft...)
 }
 
 func (s *Server) completion(w http.ResponseWriter, r *http.Request) {
@@ -825,6 +1035,7 @@ func (s *Server) completion(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var grammar *sample.GrammarSampler
+	var grammarTrigger string
 	var err error
 	if req.Grammar != "" {
 		grammar, err = sample.NewGrammarSampler(s.model.(model.TextProcessor), req.Grammar)
@@ -833,6 +1044,7 @@ func (s *Server) completion(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		defer grammar.Free()
+		grammarTrigger = req.GrammarTrigger
 	}
 
 	sampler := sample.NewSampler(
@@ -852,6 +1064,9 @@ func (s *Server) completion(w http.ResponseWriter, r *http.Request) {
 		embedding:  false,
 		shift:      req.Shift,
 		truncate:   req.Truncate,
+		numDraft:   req.Options.NumDraft,
+
+		grammarTrigger: grammarTrigger,
 	})
 	if err != nil {
 		if errors.Is(err, errorInputTooLong) {
@@ -915,6 +1130,19 @@ func (s *Server) completion(w http.ResponseWriter, r *http.Request) {
 
 				flusher.Flush()
 			} else {
+				if seq.numDrafted > 0 {
+					slog.Debug("speculative decoding", "drafted", seq.numDrafted, "accepted", seq.numDraftAccepted)
+				}
+
+				// startedAt is reset to the time of the first token once it has been generated
+				var timeToFirstToken, interTokenLatency time.Duration
+				if seq.numPredicted > 0 {
+					timeToFirstToken = seq.startedAt.Sub(seq.createdAt)
+				}
+				if seq.numPredicted > 1 {
+					interTokenLatency = seq.lastUpdatedAt.Sub(seq.startedAt) / time.Duration(seq.numPredicted-1)
+				}
+
 				if err := json.NewEncoder(w).Encode(&llm.CompletionResponse{
 					Done:               true,
 					DoneReason:         seq.doneReason,
@@ -922,6 +1150,8 @@ func (s *Server) completion(w http.ResponseWriter, r *http.Request) {
 					PromptEvalDuration: seq.processingDuration,
 					EvalCount:          seq.numPredicted,
 					EvalDuration:       seq.lastUpdatedAt.Sub(seq.startedAt) - seq.samplingDuration,
+					TimeToFirstToken:   timeToFirstToken,
+					InterTokenLatency:  interTokenLatency,
 				}); err != nil {
 					http.Error(w, fmt.Sprintf("failed to encode final response: %v", err), http.StatusInternalServerError)
 				}
@@ -1093,8 +1323,14 @@ func (s *Server) reserveWorstCaseGraph(prompt bool) error {
 		batch.Positions[i] = int32(i)
 	}
 
+	// Each sequence may need outputs for drafted tokens as well as its next token
+	numOutputs := s.parallel
+	if prompt {
+		numOutputs = min(batchSize, s.parallel*(maxNumDraft+1))
+	}
+
 	batch.Inputs = ctx.Input().FromInts(batchInputs, len(batchInputs))
-	batch.Outputs = ctx.Input().Empty(ml.DTypeI32, s.parallel)
+	batch.Outputs = ctx.Input().Empty(ml.DTypeI32, numOutputs)
 
 	cache := s.model.Config().Cache
 	if cache != nil {
@@ -1242,6 +1478,7 @@ func (s *Server) load(w http.ResponseWriter, r *http.Request) {
 			NumThreads:     req.NumThreads,
 			GPULayers:      req.GPULayers,
 			FlashAttention: req.FlashAttention,
+			RoPEScaling:    req.RoPEScaling,
 		}
 
 		s.batchSize = req.BatchSize
@@ -0,0 +1,185 @@
+package ollamarunner
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/ollama/ollama/model/input"
+)
+
+func TestBatchOrder(t *testing.T) {
+	prompt := func() *Sequence {
+		return &Sequence{inputs: inputsFromTokens(1, 2, 3, 4)}
+	}
+
+	generating := func() *Sequence {
+		return &Sequence{inputs: inputsFromTokens(1), numPredicted: 1}
+	}
+
+	drafting := func() *Sequence {
+		return &Sequence{inputs: inputsFromTokens(1, 2, 3), draft: inputsFromTokens(2, 3), numPredicted: 2}
+	}
+
+	reprocessing := func() *Sequence {
+		return &Sequence{inputs: inputsFromTokens(1, 2, 3), numPredicted: 2}
+	}
+
+	tests := []struct {
+		name     string
+		seqs     []*Sequence
+		next     int
+		starved  bool
+		expected []int
+	}{
+		{
+			name:     "Empty",
+			seqs:     []*Sequence{nil, nil},
+			expected: nil,
+		},
+		{
+			name:     "Round Robin",
+			seqs:     []*Sequence{prompt(), prompt(), prompt()},
+			next:     1,
+			expected: []int{1, 2, 0},
+		},
+		{
+			name:     "Generating First",
+			seqs:     []*Sequence{prompt(), generating(), nil, prompt(), generating()},
+			expected: []int{1, 4, 0, 3},
+		},
+		{
+			name:     "Generating Round Robin",
+			seqs:     []*Sequence{generating(), prompt(), generating(), prompt()},
+			next:     1,
+			expected: []int{2, 0, 1, 3},
+		},
+		{
+			name:     "Drafts",
+			seqs:     []*Sequence{prompt(), drafting()},
+			expected: []int{1, 0},
+		},
+		{
+			name:     "Reprocessing",
+			seqs:     []*Sequence{reprocessing(), generating()},
+			expected: []int{1, 0},
+		},
+		{
+			name:     "Waiting On Results",
+			seqs:     []*Sequence{prompt(), {inputs: []*input.Input{}, numPredicted: 1}},
+			expected: []int{1, 0},
+		},
+		{
+			name:     "Starved",
+			seqs:     []*Sequence{generating(), prompt(), generating(), prompt()},
+			next:     3,
+			starved:  true,
+			expected: []int{3, 0, 2, 1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := batchOrder(tt.seqs, tt.next, tt.starved)
+			if !slices.Equal(result, tt.expected) {
+				t.Errorf("batchOrder: have %v; want %v", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestBatchInputCount(t *testing.T) {
+	image := func(n int) []*input.Input {
+		inputs := inputsFromTokens(make([]int32, n)...)
+		inputs[0].SameBatch = n - 1
+		return inputs
+	}
+
+	tests := []struct {
+		name       string
+		inputs     []*input.Input
+		used       int
+		reserved   int
+		generating bool
+		expected   int
+	}{
+		{name: "Fits", inputs: inputsFromTokens(1, 2, 3), expected: 3},
+		{name: "Full", inputs: inputsFromTokens(make([]int32, 10)...), used: 2, expected: 6},
+		{name: "Reserved", inputs: inputsFromTokens(make([]int32, 10)...), reserved: 2, expected: 6},
+		{name: "Reserved Generating", inputs: inputsFromTokens(1), used: 7, reserved: 2, generating: true, expected: 1},
+		{name: "Reserved Empty Batch", inputs: inputsFromTokens(1, 2), reserved: 8, expected: 1},
+		{name: "Same Batch", inputs: image(12), expected: 12},
+		{name: "Same Batch Reserved", inputs: append(inputsFromTokens(1, 2), image(6)...), reserved: 2, expected: 2},
+		{name: "Same Batch Existing", inputs: image(4), used: 6, expected: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if n := batchInputCount(tt.inputs, tt.used, 8, tt.reserved, tt.generating); n != tt.expected {
+				t.Errorf("batchInputCount: have %d; want %d", n, tt.expected)
+			}
+		})
+	}
+}
+
+// TestBatchStarvation schedules batches for two prompts that are longer than
+// the batch and a sequence that is generating, which must make progress in
+// every batch
+func TestBatchStarvation(t *testing.T) {
+	const batchSize = 8
+
+	seqs := []*Sequence{
+		{inputs: inputsFromTokens(make([]int32, 100)...)},
+		{inputs: inputsFromTokens(make([]int32, 100)...)},
+		{inputs: inputsFromTokens(1), numPredicted: 1},
+	}
+
+	var next int
+	var starved bool
+	for batch := range 10 {
+		order := batchOrder(seqs, next, starved)
+
+		var reserved int
+		for _, seqIdx := range order {
+			if seqs[seqIdx].generating() {
+				reserved++
+			}
+		}
+
+		used, resume := 0, -1
+		counts := make([]int, len(seqs))
+		for _, seqIdx := range order {
+			seq := seqs[seqIdx]
+
+			generating := seq.generating()
+			if generating {
+				reserved--
+			}
+
+			n := batchInputCount(seq.inputs, used, batchSize, reserved, generating)
+			if n == 0 && len(seq.inputs) > 0 && resume == -1 {
+				resume = seqIdx
+			}
+
+			counts[seqIdx] = n
+			used += n
+			if !generating {
+				seq.inputs = seq.inputs[n:]
+			}
+		}
+
+		if counts[2] != 1 {
+			t.Fatalf("batch %d: generating sequence was starved: %v", batch, counts)
+		}
+
+		if resume != -1 {
+			next = resume
+		}
+		starved = resume != -1
+	}
+
+	for i, seq := range seqs[:2] {
+		if len(seq.inputs) == 100 {
+			t.Errorf("prompt %d made no progress", i)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package ollamarunner
+
+import (
+	"github.com/ollama/ollama/model/input"
+	"github.com/ollama/ollama/sample"
+)
+
+// Prompt lookup decoding is a form of speculative decoding that doesn't require
+// a draft model. When generating, the output often repeats spans of the input
+// (e.g. code editing or extraction), so the tokens that followed an earlier
+// occurrence of the most recent n-gram are a good guess for what comes next.
+// These guesses are appended to the next batch and verified in a single forward
+// pass by sampling at each drafted position and keeping them only as long as
+// they match what would have been sampled anyway.
+
+const (
+	// maxNumDraft is the largest number of tokens that can be drafted in a
+	// single step. Memory is reserved for verifying this many outputs.
+	maxNumDraft = 16
+
+	// draftNgramMax and draftNgramMin bound the length of the suffix of the
+	// sequence history that is matched when looking for a draft. Longer
+	// matches are tried first.
+	draftNgramMax = 3
+	draftNgramMin = 1
+)
+
+// proposeDraft looks for the most recent earlier occurrence of the trailing
+// n-gram of history followed by last and returns up to limit inputs that
+// followed it. It returns nil if nothing matches.
+func proposeDraft(history []*input.Input, last *input.Input, limit int) []*input.Input {
+	if limit <= 0 || last.Multimodal != nil {
+		return nil
+	}
+
+	length := len(history) + 1
+	at := func(i int) *input.Input {
+		if i == len(history) {
+			return last
+		}
+		return history[i]
+	}
+
+	matches := func(start, suffix, n int) bool {
+		for i := range n {
+			a, b := at(start+i), at(suffix+i)
+			if a.Multimodal != nil || b.Multimodal != nil || a.Token != b.Token {
+				return false
+			}
+		}
+		return true
+	}
+
+	for n := min(draftNgramMax, length-1); n >= draftNgramMin; n-- {
+		suffix := length - n
+		for start := suffix - 1; start >= 0; start-- {
+			if !matches(start, suffix, n) {
+				continue
+			}
+
+			var draft []*input.Input
+			for i := start + n; i < length && len(draft) < limit; i++ {
+				if at(i).Multimodal != nil {
+					break
+				}
+				draft = append(draft, &input.Input{Token: at(i).Token})
+			}
+
+			if len(draft) > 0 {
+				return draft
+			}
+		}
+	}
+
+	return nil
+}
+
+// verifyDraft samples from the logits of the input preceding each drafted token
+// in order and stops at the first sampled token that differs from the draft.
+// logits must contain len(draft)+1 rows of vocabSize. The returned tokens are
+// the accepted drafts followed by one additional token, either the one sampled
+// at the mismatch or the one following the last draft.
+func verifyDraft(sampler *sample.Sampler, logits []float32, vocabSize int, draft []*input.Input) ([]int32, error) {
+	tokens := make([]int32, 0, len(draft)+1)
+	for i := 0; i <= len(draft); i++ {
+		token, err := sampler.Sample(logits[i*vocabSize : (i+1)*vocabSize])
+		if err != nil {
+			return nil, err
+		}
+
+		tokens = append(tokens, token)
+		if i == len(draft) || token != draft[i].Token {
+			break
+		}
+	}
+
+	return tokens, nil
+}
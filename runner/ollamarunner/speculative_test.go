@@ -0,0 +1,245 @@
+package ollamarunner
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/ollama/ollama/model/input"
+	"github.com/ollama/ollama/sample"
+)
+
+func inputsFromTokens(tokens ...int32) []*input.Input {
+	inputs := make([]*input.Input, len(tokens))
+	for i, t := range tokens {
+		inputs[i] = &input.Input{Token: t}
+	}
+	return inputs
+}
+
+func tokensFromInputs(inputs []*input.Input) []int32 {
+	tokens := make([]int32, len(inputs))
+	for i, inp := range inputs {
+		tokens[i] = inp.Token
+	}
+	return tokens
+}
+
+func TestProposeDraft(t *testing.T) {
+	image := []input.Multimodal{{}}
+
+	tests := []struct {
+		name     string
+		history  []*input.Input
+		last     *input.Input
+		limit    int
+		expected []int32
+	}{
+		{
+			name:     "Trigram",
+			history:  inputsFromTokens(1, 2, 3, 4, 5, 6, 9, 2, 3),
+			last:     &input.Input{Token: 4},
+			limit:    3,
+			expected: []int32{5, 6, 9},
+		},
+		{
+			name:     "Limit",
+			history:  inputsFromTokens(1, 2, 3, 4, 5, 6, 9, 2, 3),
+			last:     &input.Input{Token: 4},
+			limit:    1,
+			expected: []int32{5},
+		},
+		{
+			name:     "Most Recent",
+			history:  inputsFromTokens(1, 2, 7, 1, 2, 8, 1),
+			last:     &input.Input{Token: 2},
+			limit:    1,
+			expected: []int32{8},
+		},
+		{
+			name:     "Longest Match First",
+			history:  inputsFromTokens(1, 2, 3, 7, 9, 3, 8, 1, 2),
+			last:     &input.Input{Token: 3},
+			limit:    1,
+			expected: []int32{7},
+		},
+		{
+			name:     "Unigram",
+			history:  inputsFromTokens(5, 6, 7, 8),
+			last:     &input.Input{Token: 6},
+			limit:    4,
+			expected: []int32{7, 8, 6},
+		},
+		{
+			name:    "No Match",
+			history: inputsFromTokens(1, 2, 3),
+			last:    &input.Input{Token: 4},
+			limit:   4,
+		},
+		{
+			name:    "Empty History",
+			history: []*input.Input{},
+			last:    &input.Input{Token: 4},
+			limit:   4,
+		},
+		{
+			name:    "Disabled",
+			history: inputsFromTokens(1, 2, 3, 1, 2),
+			last:    &input.Input{Token: 3},
+			limit:   0,
+		},
+		{
+			name:    "Stops At Multimodal",
+			history: []*input.Input{{Token: 1}, {Token: 2}, {Multimodal: image}, {Token: 3}, {Token: 1}},
+			last:    &input.Input{Token: 2},
+			limit:   4,
+		},
+		{
+			name:     "Skips Multimodal Match",
+			history:  []*input.Input{{Token: 1}, {Token: 2}, {Token: 5}, {Multimodal: image}, {Token: 1}},
+			last:     &input.Input{Token: 2},
+			limit:    2,
+			expected: []int32{5},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			draft := proposeDraft(tt.history, tt.last, tt.limit)
+			if tokens := tokensFromInputs(draft); !slices.Equal(tokens, tt.expected) {
+				t.Errorf("proposeDraft: have %v; want %v", tokens, tt.expected)
+			}
+		})
+	}
+}
+
+// oneHot returns logits for each token where the given token is the most likely
+func oneHot(vocabSize int, tokens ...int32) []float32 {
+	logits := make([]float32, vocabSize*len(tokens))
+	for i, t := range tokens {
+		logits[i*vocabSize+int(t)] = 1
+	}
+	return logits
+}
+
+func TestVerifyDraft(t *testing.T) {
+	const vocabSize = 8
+
+	tests := []struct {
+		name     string
+		logits   []float32
+		draft    []int32
+		expected []int32
+	}{
+		{
+			name:     "No Draft",
+			logits:   oneHot(vocabSize, 3),
+			expected: []int32{3},
+		},
+		{
+			name:     "All Accepted",
+			logits:   oneHot(vocabSize, 1, 2, 3),
+			draft:    []int32{1, 2},
+			expected: []int32{1, 2, 3},
+		},
+		{
+			name:     "Partially Accepted",
+			logits:   oneHot(vocabSize, 1, 5, 3),
+			draft:    []int32{1, 2},
+			expected: []int32{1, 5},
+		},
+		{
+			name:     "Rejected",
+			logits:   oneHot(vocabSize, 4, 2, 3),
+			draft:    []int32{1, 2},
+			expected: []int32{4},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sampler := sample.NewSampler(0, 0, 0, 0, -1, nil)
+			tokens, err := verifyDraft(&sampler, tt.logits, vocabSize, inputsFromTokens(tt.draft...))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !slices.Equal(tokens, tt.expected) {
+				t.Errorf("verifyDraft: have %v; want %v", tokens, tt.expected)
+			}
+		})
+	}
+}
+
+// TestSpeculativeGreedy checks that drafting and verifying produces exactly
+// the same output as generating one token at a time under greedy sampling.
+func TestSpeculativeGreedy(t *testing.T) {
+	const vocabSize = 16
+
+	// A toy model that mostly copies a pattern from its history with an
+	// occasional deviation, so that drafts are sometimes accepted and
+	// sometimes rejected.
+	next := func(history []int32) int32 {
+		n := len(history)
+		switch {
+		case n%7 == 0:
+			return int32(n % vocabSize)
+		case n >= 5:
+			return history[n-5]
+		default:
+			return history[n-1] + 1
+		}
+	}
+
+	forward := func(history []int32, positions int) []float32 {
+		var tokens []int32
+		for i := len(history) - positions; i < len(history); i++ {
+			tokens = append(tokens, next(history[:i+1]))
+		}
+		return oneHot(vocabSize, tokens...)
+	}
+
+	prompt := []int32{1, 2, 3, 4, 5, 6}
+	const numPredict = 40
+
+	// one token at a time
+	expected := slices.Clone(prompt)
+	for len(expected) < len(prompt)+numPredict {
+		expected = append(expected, next(expected))
+	}
+
+	for _, numDraft := range []int{1, 2, 4, 8} {
+		sampler := sample.NewSampler(0, 0, 0, 0, -1, nil)
+		history := inputsFromTokens(prompt...)
+
+		// the first token comes from the prompt without any drafts
+		tokens, err := verifyDraft(&sampler, forward(tokensFromInputs(history), 1), vocabSize, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var steps int
+		for len(history) < len(expected) {
+			last := &input.Input{Token: tokens[len(tokens)-1]}
+			draft := proposeDraft(history, last, min(numDraft, len(expected)-len(history)-1))
+
+			history = append(history, last)
+			batch := append(slices.Clone(history), draft...)
+
+			tokens, err = verifyDraft(&sampler, forward(tokensFromInputs(batch), len(draft)+1), vocabSize, draft)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			history = append(history, draft[:len(tokens)-1]...)
+			steps++
+		}
+
+		if actual := tokensFromInputs(history); !slices.Equal(actual, expected) {
+			t.Errorf("numDraft %v: have %v; want %v", numDraft, actual, expected)
+		}
+
+		if steps >= numPredict {
+			t.Errorf("numDraft %v: expected drafts to reduce steps, have %v", numDraft, steps)
+		}
+	}
+}
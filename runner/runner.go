@@ -10,6 +10,10 @@ func Execute(args []string) error {
 		args = args[1:]
 	}
 
+	if args[0] == "--imatrix" {
+		return llamarunner.ExecuteImatrix(args[1:])
+	}
+
 	var newRunner bool
 	if args[0] == "--ollama-engine" {
 		args = args[1:]
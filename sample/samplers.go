@@ -23,6 +23,23 @@ type Sampler struct {
 	minP        float32
 	temperature float32
 	grammar     *GrammarSampler
+
+	// grammarDeferred is set while the grammar waits to be activated
+	grammarDeferred bool
+}
+
+// DeferGrammar stops the sampler's grammar from constraining tokens until
+// ActivateGrammar is called, such as while a model thinks before giving a
+// structured answer
+func (s *Sampler) DeferGrammar() {
+	s.grammarDeferred = true
+}
+
+// ActivateGrammar starts constraining tokens with the sampler's grammar from
+// the next sampled token. The grammar only matches tokens sampled after it's
+// activated.
+func (s *Sampler) ActivateGrammar() {
+	s.grammarDeferred = false
 }
 
 func (s *Sampler) Sample(logits []float32) (int32, error) {
@@ -41,7 +58,7 @@ func (s *Sampler) Sample(logits []float32) (int32, error) {
 		return -1, err
 	}
 
-	if s.grammar != nil {
+	if s.grammar != nil && !s.grammarDeferred {
 		// optimization: first check if the max logit is accepted by the grammar
 		// if the max logit is rejected, apply the grammar to all logits (slower)
 		top := []token{t}
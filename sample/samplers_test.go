@@ -6,6 +6,8 @@ import (
 	"math/rand/v2"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
 
 	"github.com/ollama/ollama/model"
@@ -149,6 +151,46 @@ func TestGrammar(t *testing.T) {
 	}
 }
 
+func TestDeferGrammar(t *testing.T) {
+	tokenizer := modelHelper(t)
+
+	grammar, err := NewGrammarSampler(tokenizer, `root ::= "{}"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer grammar.Free()
+
+	values := tokenizer.Vocabulary().Values
+	hello := slices.Index(values, "hello")
+	if hello < 0 {
+		t.Fatal("expected hello in the vocabulary")
+	}
+
+	logits := make([]float32, len(values))
+	logits[hello] = 10
+
+	sampler := NewSampler(0, 0, 0, 0, 0, grammar)
+	sampler.DeferGrammar()
+
+	got, err := sampler.Sample(logits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != int32(hello) {
+		t.Errorf("expected the deferred grammar to allow %d, got %d", hello, got)
+	}
+
+	sampler.ActivateGrammar()
+
+	got, err = sampler.Sample(logits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if piece := values[got]; piece == "" || !strings.HasPrefix("{}", piece) {
+		t.Errorf("expected the activated grammar to only allow the start of {}, got %q", piece)
+	}
+}
+
 func BenchmarkSample(b *testing.B) {
 	samplers := map[string]Sampler{
 		"Greedy":   NewSampler(0, 0, 0, 0, 0, nil), // Use NewSampler with temp=0 for greedy
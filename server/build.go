@@ -0,0 +1,76 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+
+	"github.com/ollama/ollama/envconfig"
+	"github.com/ollama/ollama/types/model"
+)
+
+// buildRecord is the source digest of a created model. It's kept next to the
+// models rather than in their config, so recording it doesn't change the
+// digest of the model. See [api.CreateRequest.SourceDigest].
+type buildRecord struct {
+	// Manifest is the digest of the manifest the record applies to, so the
+	// record is ignored once the model is replaced by a pull or copy
+	Manifest     string `json:"manifest"`
+	SourceDigest string `json:"source_digest"`
+}
+
+func buildRecordPath(n model.Name) string {
+	return filepath.Join(envconfig.Models(), "builds", n.Filepath())
+}
+
+func writeBuildRecord(n model.Name, sourceDigest string) error {
+	m, err := ParseNamedManifest(n)
+	if err != nil {
+		return err
+	}
+
+	b, err := json.Marshal(buildRecord{Manifest: m.digest, SourceDigest: sourceDigest})
+	if err != nil {
+		return err
+	}
+
+	p := buildRecordPath(n)
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return err
+	}
+
+	return os.WriteFile(p, b, 0o644)
+}
+
+// sourceDigest returns the source digest recorded when the model named n with
+// manifest m was created, or an empty string if there is none
+func sourceDigest(n model.Name, m *Manifest) (string, error) {
+	b, err := os.ReadFile(buildRecordPath(n))
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+
+	var r buildRecord
+	if err := json.Unmarshal(b, &r); err != nil {
+		return "", err
+	}
+
+	if r.Manifest != m.digest {
+		return "", nil
+	}
+
+	return r.SourceDigest, nil
+}
+
+func removeBuildRecord(n model.Name) error {
+	if err := os.Remove(buildRecordPath(n)); errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	return PruneDirectory(filepath.Join(envconfig.Models(), "builds"))
+}
@@ -0,0 +1,254 @@
+package server
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/ollama/ollama/types/model"
+)
+
+// A bundle is a tar archive holding the manifests of one or more models and
+// the blobs they reference, laid out like the models directory:
+//
+//	blobs/sha256-<hex>
+//	manifests/<host>/<namespace>/<model>/<tag>
+//
+// Blobs are written before manifests so that a bundle can be imported in a
+// single pass without buffering blobs.
+
+var errInvalidBundle = errors.New("invalid model bundle")
+
+var bundleBlobRegexp = regexp.MustCompile(`^blobs/sha256-[0-9a-f]{64}$`)
+
+// maxBundleManifestSize limits the size of manifests read from a bundle
+const maxBundleManifestSize = 1 << 20
+
+// ExportModels writes a bundle containing the models names to w.
+func ExportModels(w io.Writer, names ...model.Name) error {
+	type entry struct {
+		name     model.Name
+		manifest []byte
+	}
+
+	var entries []entry
+	var digests []string
+	seen := make(map[string]bool)
+	for _, n := range names {
+		m, err := ParseNamedManifest(n)
+		if err != nil {
+			return fmt.Errorf("%s: %w", n.DisplayShortest(), err)
+		}
+
+		b, err := os.ReadFile(m.filepath)
+		if err != nil {
+			return err
+		}
+
+		entries = append(entries, entry{n, b})
+		for _, layer := range append([]Layer{m.Config}, m.Layers...) {
+			if layer.Digest != "" && !seen[layer.Digest] {
+				seen[layer.Digest] = true
+				digests = append(digests, layer.Digest)
+			}
+		}
+	}
+
+	tw := tar.NewWriter(w)
+	for _, digest := range digests {
+		if err := exportBlob(tw, digest); err != nil {
+			return err
+		}
+	}
+
+	for _, e := range entries {
+		if err := tw.WriteHeader(&tar.Header{
+			Name:     path.Join("manifests", e.name.Host, e.name.Namespace, e.name.Model, e.name.Tag),
+			Mode:     0o644,
+			Size:     int64(len(e.manifest)),
+			Typeflag: tar.TypeReg,
+		}); err != nil {
+			return err
+		}
+
+		if _, err := tw.Write(e.manifest); err != nil {
+			return err
+		}
+	}
+
+	return tw.Close()
+}
+
+func exportBlob(tw *tar.Writer, digest string) error {
+	p, err := GetBlobsPath(digest)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(p)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	if err := tw.WriteHeader(&tar.Header{
+		Name:     path.Join("blobs", filepath.Base(p)),
+		Mode:     0o644,
+		Size:     fi.Size(),
+		ModTime:  fi.ModTime(),
+		Typeflag: tar.TypeReg,
+	}); err != nil {
+		return err
+	}
+
+	_, err = io.Copy(tw, f)
+	return err
+}
+
+// ImportModels reads a bundle written by [ExportModels] from r and adds its
+// models to the models directory. Blobs are verified against their digests
+// and manifests are only written once all of the blobs they reference are
+// present, so a truncated or corrupt bundle does not leave broken models
+// behind. It returns the names of the imported models.
+func ImportModels(r io.Reader) ([]model.Name, error) {
+	type entry struct {
+		name     model.Name
+		manifest []byte
+		layers   []Layer
+	}
+
+	var entries []entry
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case hdr.Typeflag == tar.TypeDir:
+		case hdr.Typeflag != tar.TypeReg:
+			return nil, fmt.Errorf("%w: unexpected entry %q", errInvalidBundle, hdr.Name)
+		case bundleBlobRegexp.MatchString(hdr.Name):
+			if err := importBlob(tr, strings.Replace(path.Base(hdr.Name), "-", ":", 1)); err != nil {
+				return nil, err
+			}
+		case strings.HasPrefix(hdr.Name, "manifests/"):
+			parts := strings.Split(strings.TrimPrefix(hdr.Name, "manifests/"), "/")
+			if len(parts) != 4 {
+				return nil, fmt.Errorf("%w: unexpected entry %q", errInvalidBundle, hdr.Name)
+			}
+
+			n := model.Name{Host: parts[0], Namespace: parts[1], Model: parts[2], Tag: parts[3]}
+			if !n.IsFullyQualified() {
+				return nil, fmt.Errorf("%w: invalid model name %q", errInvalidBundle, hdr.Name)
+			}
+
+			b, err := io.ReadAll(io.LimitReader(tr, maxBundleManifestSize))
+			if err != nil {
+				return nil, err
+			}
+
+			var m Manifest
+			if err := json.Unmarshal(b, &m); err != nil {
+				return nil, fmt.Errorf("%w: %s: %w", errInvalidBundle, n.DisplayShortest(), err)
+			}
+
+			entries = append(entries, entry{n, b, append([]Layer{m.Config}, m.Layers...)})
+		default:
+			return nil, fmt.Errorf("%w: unexpected entry %q", errInvalidBundle, hdr.Name)
+		}
+	}
+
+	for _, e := range entries {
+		for _, layer := range e.layers {
+			if layer.Digest == "" {
+				continue
+			}
+
+			p, err := GetBlobsPath(layer.Digest)
+			if err != nil {
+				return nil, err
+			}
+
+			if fi, err := os.Stat(p); err != nil {
+				return nil, fmt.Errorf("%w: %s: missing blob %s", errInvalidBundle, e.name.DisplayShortest(), layer.Digest)
+			} else if fi.Size() != layer.Size {
+				return nil, fmt.Errorf("%w: %s: blob %s has size %d, want %d", errInvalidBundle, e.name.DisplayShortest(), layer.Digest, fi.Size(), layer.Size)
+			}
+		}
+	}
+
+	manifests, err := GetManifestPath()
+	if err != nil {
+		return nil, err
+	}
+
+	var names []model.Name
+	for _, e := range entries {
+		p := filepath.Join(manifests, e.name.Filepath())
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			return nil, err
+		}
+
+		if err := os.WriteFile(p, e.manifest, 0o644); err != nil {
+			return nil, err
+		}
+
+		names = append(names, e.name)
+	}
+
+	return names, nil
+}
+
+// importBlob copies r into the blob digest, verifying its contents. Blobs
+// that already exist are left untouched.
+func importBlob(r io.Reader, digest string) error {
+	p, err := GetBlobsPath(digest)
+	if err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(p); err == nil {
+		_, err := io.Copy(io.Discard, r)
+		return err
+	}
+
+	temp, err := os.CreateTemp(filepath.Dir(p), "sha256-")
+	if err != nil {
+		return err
+	}
+	defer temp.Close()
+	defer os.Remove(temp.Name())
+
+	sha256sum := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(temp, sha256sum), r); err != nil {
+		return err
+	}
+
+	if got := "sha256:" + hex.EncodeToString(sha256sum.Sum(nil)); got != digest {
+		return fmt.Errorf("%w: %w: want %s, got %s", errInvalidBundle, errDigestMismatch, digest, got)
+	}
+
+	if err := temp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(temp.Name(), p)
+}
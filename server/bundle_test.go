@@ -0,0 +1,209 @@
+package server
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"log/slog"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/server/internal/cache/blob"
+	"github.com/ollama/ollama/server/internal/registry"
+	"github.com/ollama/ollama/types/model"
+)
+
+// createBundleTestModel writes a model with a config and a single layer
+// to the models directory
+func createBundleTestModel(t *testing.T, name, data string) *Manifest {
+	t.Helper()
+
+	layer, err := NewLayer(strings.NewReader(data), "application/vnd.ollama.image.model")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := NewLayer(strings.NewReader(`{"model_format":"gguf"}`), "application/vnd.docker.container.image.v1+json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n := model.ParseName(name)
+	if err := WriteManifest(n, config, []Layer{layer}); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := ParseNamedManifest(n)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return m
+}
+
+func TestExportImportModels(t *testing.T) {
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+	want := map[model.Name]*Manifest{
+		model.ParseName("a"):                    createBundleTestModel(t, "a", "model a"),
+		model.ParseName("example.com/ns/b:tag"): createBundleTestModel(t, "example.com/ns/b:tag", "model b"),
+	}
+
+	var buf bytes.Buffer
+	if err := ExportModels(&buf, model.ParseName("a"), model.ParseName("example.com/ns/b:tag")); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+	names, err := ImportModels(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff([]model.Name{model.ParseName("a"), model.ParseName("example.com/ns/b:tag")}, names); diff != "" {
+		t.Errorf("names mismatch (-want +got):\n%s", diff)
+	}
+
+	for n, m := range want {
+		got, err := ParseNamedManifest(n)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got.digest != m.digest {
+			t.Errorf("%s: manifest digest: have %s; want %s", n, got.digest, m.digest)
+		}
+
+		for _, layer := range append(got.Layers, got.Config) {
+			if err := verifyBlob(layer.Digest); err != nil {
+				t.Errorf("%s: layer %s: %v", n, layer.Digest, err)
+			}
+		}
+	}
+
+	// importing again is a no-op
+	if _, err := ImportModels(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestImportModelsInvalid(t *testing.T) {
+	data := "model data"
+	digest := blob.DigestFromBytes(data).String()
+	manifest := `{"schemaVersion":2,"layers":[{"digest":"` + digest + `","size":10}]}`
+
+	bundle := func(t *testing.T, files ...string) []byte {
+		t.Helper()
+
+		var buf bytes.Buffer
+		tw := tar.NewWriter(&buf)
+		for i := 0; i < len(files); i += 2 {
+			if err := tw.WriteHeader(&tar.Header{Name: files[i], Size: int64(len(files[i+1])), Mode: 0o644, Typeflag: tar.TypeReg}); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := tw.Write([]byte(files[i+1])); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if err := tw.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		return buf.Bytes()
+	}
+
+	blobName := "blobs/" + strings.Replace(digest, ":", "-", 1)
+	manifestName := "manifests/registry.ollama.ai/library/a/latest"
+
+	cases := []struct {
+		name  string
+		files []string
+	}{
+		{"corrupt blob", []string{blobName, "corrupt", manifestName, manifest}},
+		{"missing blob", []string{manifestName, manifest}},
+		{"path traversal", []string{"../evil", "evil"}},
+		{"bad manifest name", []string{"manifests/../../x/y", manifest}},
+		{"bad manifest", []string{blobName, data, manifestName, "not json"}},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+			_, err := ImportModels(bytes.NewReader(bundle(t, tt.files...)))
+			if !errors.Is(err, errInvalidBundle) {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			ms, err := Manifests(false)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(ms) != 0 {
+				t.Errorf("manifests were written: %v", ms)
+			}
+		})
+	}
+
+	t.Run("valid", func(t *testing.T) {
+		t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+		if _, err := ImportModels(bytes.NewReader(bundle(t, blobName, data, manifestName, manifest))); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func TestPullModelFromMirror(t *testing.T) {
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+	want := createBundleTestModel(t, "smol", "a very small model")
+
+	c, err := blob.Open(os.Getenv("OLLAMA_MODELS"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := httptest.NewServer(&registry.Mirror{Cache: c, Logger: slog.New(slog.DiscardHandler), Host: DefaultRegistry})
+	t.Cleanup(s.Close)
+
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+	name := u.Host + "/library/smol:latest"
+	if err := PullModel(t.Context(), name, &registryOptions{Insecure: true}, func(api.ProgressResponse) {}); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := ParseNamedManifest(model.ParseName(name))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff(want.Layers, got.Layers, cmp.AllowUnexported(Layer{})); diff != "" {
+		t.Errorf("layers mismatch (-want +got):\n%s", diff)
+	}
+
+	blobs, err := filepath.Glob(filepath.Join(os.Getenv("OLLAMA_MODELS"), "blobs", "sha256-*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(blobs) != 2 {
+		t.Errorf("blobs: have %d; want 2", len(blobs))
+	}
+}
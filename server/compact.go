@@ -0,0 +1,231 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/llm"
+	"github.com/ollama/ollama/model/parsers"
+	"github.com/ollama/ollama/thinking"
+	"github.com/ollama/ollama/types/model"
+)
+
+const summaryInstructions = `You summarize conversations between a user and an assistant so the assistant can continue them without the original messages. Write a concise summary that keeps the facts, decisions, requests, names, numbers, file paths, tool results and open tasks the assistant will need. Write only the summary.`
+
+// summaryHeading starts the system message that replaces summarized messages
+const summaryHeading = "Summary of the earlier conversation:\n\n"
+
+// summaryBudget is the share of the context window kept for the summary of
+// compacted messages
+const summaryBudget = 8
+
+// countMessages returns the number of messages in msgs that aren't system
+// messages
+func countMessages(msgs []api.Message) int {
+	var n int
+	for _, msg := range msgs {
+		if msg.Role != "system" {
+			n++
+		}
+	}
+	return n
+}
+
+// transcriptEntry writes msg as a paragraph of a transcript for the
+// summarizer
+func transcriptEntry(msg api.Message) string {
+	var sb strings.Builder
+	sb.WriteString(msg.Role)
+	if msg.ToolName != "" {
+		sb.WriteString(" (" + msg.ToolName + ")")
+	}
+	sb.WriteString(": ")
+	sb.WriteString(msg.Content)
+	for range msg.Images {
+		sb.WriteString(" [image]")
+	}
+	for _, call := range msg.ToolCalls {
+		fmt.Fprintf(&sb, "\n[called %s with %s]", call.Function.Name, call.Function.Arguments)
+	}
+	return sb.String()
+}
+
+// summaryPrompt renders the prompt asking m to summarize entries, continuing
+// the summary of the messages before them if there is one
+func summaryPrompt(m *Model, summary string, entries []string) (string, error) {
+	var sb strings.Builder
+	if summary != "" {
+		sb.WriteString("Summary of the conversation so far:\n\n" + summary + "\n\nUpdate the summary with the rest of the conversation:\n\n")
+	} else {
+		sb.WriteString("Conversation:\n\n")
+	}
+	sb.WriteString(strings.Join(entries, "\n\n"))
+
+	var think *api.ThinkValue
+	if slices.Contains(m.Capabilities(), model.CapabilityThinking) {
+		think = &api.ThinkValue{Value: false}
+	}
+
+	return renderPrompt(m, []api.Message{
+		{Role: "system", Content: summaryInstructions},
+		{Role: "user", Content: sb.String()},
+	}, nil, think)
+}
+
+// summarizeMessages asks the model of r to summarize msgs in at most
+// numPredict tokens. Messages that don't fit in the model's context window
+// together are summarized in parts, each part updating the summary of the
+// parts before it.
+func summarizeMessages(ctx context.Context, r llm.LlamaServer, m *Model, opts api.Options, numPredict int, msgs []api.Message) (string, error) {
+	opts.NumPredict = numPredict
+	limit := opts.NumCtx - numPredict
+
+	fits := func(summary string, entries []string) (bool, error) {
+		p, err := summaryPrompt(m, summary, entries)
+		if err != nil {
+			return false, err
+		}
+
+		tokens, err := r.Tokenize(ctx, p)
+		if err != nil {
+			return false, err
+		}
+
+		return len(tokens) <= limit, nil
+	}
+
+	var summary string
+	var entries []string
+	for _, msg := range msgs {
+		entry := transcriptEntry(msg)
+		for {
+			ok, err := fits(summary, append(entries, entry))
+			if err != nil {
+				return "", err
+			}
+
+			if ok {
+				entries = append(entries, entry)
+				break
+			}
+
+			if len(entries) > 0 {
+				summary, err = summarizePart(ctx, r, m, &opts, summary, entries)
+				if err != nil {
+					return "", err
+				}
+				entries = nil
+				continue
+			}
+
+			// the message doesn't fit on its own, so only its end is kept
+			runes := []rune(entry)
+			if len(runes) < 2 {
+				return "", errors.New("summary prompt exceeds the context length")
+			}
+			entry = string(runes[len(runes)/2:])
+		}
+	}
+
+	if len(entries) > 0 {
+		return summarizePart(ctx, r, m, &opts, summary, entries)
+	}
+	return summary, nil
+}
+
+// summarizePart generates the summary of entries, updating summary
+func summarizePart(ctx context.Context, r llm.LlamaServer, m *Model, opts *api.Options, summary string, entries []string) (string, error) {
+	p, err := summaryPrompt(m, summary, entries)
+	if err != nil {
+		return "", err
+	}
+
+	var sb strings.Builder
+	if err := r.Completion(ctx, llm.CompletionRequest{
+		Prompt:   p,
+		Options:  opts,
+		Truncate: true,
+	}, func(cr llm.CompletionResponse) {
+		sb.WriteString(cr.Content)
+	}); err != nil {
+		return "", err
+	}
+
+	content := sb.String()
+	parser := m.Config.Parser
+	if shouldUseHarmony(m) && parser == "" {
+		parser = "harmony"
+	}
+
+	if bp := parsers.ParserForName(parser); bp != nil {
+		bp.Init(nil, nil)
+		if content, _, _, err = bp.Add(content, true); err != nil {
+			return "", err
+		}
+	} else if openingTag, closingTag := thinking.InferTags(m.Template.Template); openingTag != "" && closingTag != "" {
+		t := thinking.Parser{OpeningTag: openingTag, ClosingTag: closingTag}
+		_, content = t.AddContent(content)
+	}
+
+	content = strings.TrimSpace(content)
+	if content == "" {
+		return "", errors.New("summary is empty")
+	}
+	return content, nil
+}
+
+// maxSummaryAttempts is the number of times compacted messages are summarized
+// before giving up on a summary that doesn't fit in the space kept for it
+const maxSummaryAttempts = 3
+
+var errSummaryTooLong = errors.New("summary exceeds the space kept for it in the context window")
+
+// compactMessages summarizes the messages of msgs that don't fit in the
+// context window, reserving part of it for the summary. summarize is called
+// with the evicted messages and the number of tokens the summary may take; if
+// the summary still doesn't fit, it's written again with half the tokens. It
+// returns the messages with the summary in their place and the compaction, or
+// msgs and nil if they all fit.
+func compactMessages(ctx context.Context, m *Model, tokenize tokenizeFunc, opts *api.Options, msgs []api.Message, tools []api.Tool, think *api.ThinkValue, summarize func([]api.Message, int) (string, error)) ([]api.Message, *api.CompactionResult, error) {
+	numPredict := opts.NumCtx / summaryBudget
+	n, err := fitMessages(ctx, m, tokenize, opts.NumCtx-numPredict, msgs, tools, think, true)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	evicted := slices.DeleteFunc(slices.Clone(msgs[:n]), func(msg api.Message) bool { return msg.Role == "system" })
+	if len(evicted) == 0 {
+		return msgs, nil, nil
+	}
+
+	for range maxSummaryAttempts {
+		summary, err := summarize(evicted, numPredict)
+		if err != nil {
+			return nil, nil, fmt.Errorf("summarize messages: %w", err)
+		}
+
+		result := &api.CompactionResult{
+			Strategy: "summarize",
+			Messages: len(evicted),
+			Summary:  summaryHeading + summary,
+		}
+
+		compacted := result.Apply(msgs)
+		n, err := fitMessages(ctx, m, tokenize, opts.NumCtx, compacted, tools, think, true)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if countMessages(compacted[:n]) == 0 {
+			return compacted, result, nil
+		}
+
+		numPredict = max(numPredict/2, 1)
+	}
+
+	return nil, nil, errSummaryTooLong
+}
@@ -0,0 +1,440 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/fs/ggml"
+	"github.com/ollama/ollama/llm"
+	"github.com/ollama/ollama/ml"
+	"github.com/ollama/ollama/template"
+)
+
+func TestSummarizeMessages(t *testing.T) {
+	tmpl, err := template.Parse(`{{- range .Messages }}{{ .Role }}: {{ .Content }} {{ end }}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var prompts []string
+	mock := mockRunner{
+		CompletionFn: func(_ context.Context, r llm.CompletionRequest, fn func(llm.CompletionResponse)) error {
+			prompts = append(prompts, r.Prompt)
+			fn(llm.CompletionResponse{Content: fmt.Sprintf(" summary %d\n", len(prompts)), Done: true})
+			return nil
+		},
+	}
+
+	msgs := make([]api.Message, 6)
+	for i := range msgs {
+		msgs[i] = api.Message{Role: "user", Content: fmt.Sprintf("message %d %s", i, strings.Repeat("word ", 20))}
+	}
+
+	// the instructions take about 60 tokens, so the messages don't fit in
+	// one part
+	opts := api.DefaultOptions()
+	opts.NumCtx = 130
+
+	summary, err := summarizeMessages(t.Context(), &mock, &Model{Template: tmpl}, opts, 10, msgs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(prompts) < 2 {
+		t.Fatalf("expected the messages to be summarized in parts, got %d", len(prompts))
+	}
+
+	if expected := fmt.Sprintf("summary %d", len(prompts)); summary != expected {
+		t.Errorf("expected the summary of the last part %q, got %q", expected, summary)
+	}
+
+	// each message is summarized once, in order, and each part continues
+	// the summary of the parts before it
+	next := 0
+	for i, p := range prompts {
+		if i > 0 && !strings.Contains(p, fmt.Sprintf("summary %d", i)) {
+			t.Errorf("expected part %d to continue the summary before it, got %q", i, p)
+		}
+
+		for next < len(msgs) && strings.Contains(p, fmt.Sprintf("message %d ", next)) {
+			next++
+		}
+	}
+
+	if next != len(msgs) {
+		t.Errorf("expected every message to be summarized in order, got through message %d", next)
+	}
+
+	if mock.CompletionRequest.Options.NumPredict != 10 {
+		t.Errorf("expected the summary to be limited to 10 tokens, got %d", mock.CompletionRequest.Options.NumPredict)
+	}
+}
+
+func TestChatCompaction(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+	var summaryPrompts []string
+	var summaryNumPredict []int
+	summaryContent := "The user asked about the weather."
+	mock := mockRunner{
+		CompletionFn: func(_ context.Context, r llm.CompletionRequest, fn func(llm.CompletionResponse)) error {
+			content := "Arr, it's sunny."
+			if strings.Contains(r.Prompt, summaryInstructions) {
+				summaryPrompts = append(summaryPrompts, r.Prompt)
+				summaryNumPredict = append(summaryNumPredict, r.Options.NumPredict)
+				content = summaryContent
+			}
+
+			fn(llm.CompletionResponse{Content: content, Done: true, DoneReason: llm.DoneReasonStop})
+			return nil
+		},
+	}
+
+	s := Server{
+		sched: &Scheduler{
+			pendingReqCh:    make(chan *LlmRequest, 1),
+			finishedReqCh:   make(chan *LlmRequest, 1),
+			expiredCh:       make(chan *runnerRef, 1),
+			unloadedCh:      make(chan any, 1),
+			loaded:          make(map[string]*runnerRef),
+			newServerFn:     newMockServer(&mock),
+			getGpuFn:        getGpuFn,
+			getSystemInfoFn: getSystemInfoFn,
+			waitForRecovery: 250 * time.Millisecond,
+			loadFn: func(req *LlmRequest, _ *ggml.GGML, _ ml.SystemInfo, _ []ml.DeviceInfo, _ bool) bool {
+				req.successCh <- &runnerRef{
+					llama: &mock,
+				}
+				return false
+			},
+		},
+	}
+	t.Cleanup(func() {
+		if s.sessions != nil {
+			s.sessions.Close()
+		}
+	})
+
+	go s.sched.Run(t.Context())
+
+	_, digest := createBinFile(t, ggml.KV{
+		"general.architecture":          "llama",
+		"llama.block_count":             uint32(1),
+		"llama.context_length":          uint32(8192),
+		"llama.embedding_length":        uint32(4096),
+		"llama.attention.head_count":    uint32(32),
+		"llama.attention.head_count_kv": uint32(8),
+		"tokenizer.ggml.tokens":         []string{""},
+		"tokenizer.ggml.scores":         []float32{0},
+		"tokenizer.ggml.token_type":     []int32{0},
+	}, []*ggml.Tensor{
+		{Name: "token_embd.weight", Shape: []uint64{1}, WriterTo: bytes.NewReader(make([]byte, 4))},
+		{Name: "blk.0.attn_norm.weight", Shape: []uint64{1}, WriterTo: bytes.NewReader(make([]byte, 4))},
+		{Name: "blk.0.ffn_down.weight", Shape: []uint64{1}, WriterTo: bytes.NewReader(make([]byte, 4))},
+		{Name: "blk.0.ffn_gate.weight", Shape: []uint64{1}, WriterTo: bytes.NewReader(make([]byte, 4))},
+		{Name: "blk.0.ffn_up.weight", Shape: []uint64{1}, WriterTo: bytes.NewReader(make([]byte, 4))},
+		{Name: "blk.0.ffn_norm.weight", Shape: []uint64{1}, WriterTo: bytes.NewReader(make([]byte, 4))},
+		{Name: "blk.0.attn_k.weight", Shape: []uint64{1}, WriterTo: bytes.NewReader(make([]byte, 4))},
+		{Name: "blk.0.attn_output.weight", Shape: []uint64{1}, WriterTo: bytes.NewReader(make([]byte, 4))},
+		{Name: "blk.0.attn_q.weight", Shape: []uint64{1}, WriterTo: bytes.NewReader(make([]byte, 4))},
+		{Name: "blk.0.attn_v.weight", Shape: []uint64{1}, WriterTo: bytes.NewReader(make([]byte, 4))},
+		{Name: "output.weight", Shape: []uint64{1}, WriterTo: bytes.NewReader(make([]byte, 4))},
+	})
+
+	w := createRequest(t, s.CreateHandler, api.CreateRequest{
+		Model: "test",
+		Files: map[string]string{"file.gguf": digest},
+		Template: `
+{{- range .Messages }}
+{{- .Role }}: {{ .Content }}
+{{ end }}`,
+		Stream: &stream,
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+
+	// each turn is about 20 tokens, so with the space kept for a summary
+	// only the last few fit
+	history := []api.Message{{Role: "system", Content: "You are a pirate."}}
+	for i := range 6 {
+		history = append(history,
+			api.Message{Role: "user", Content: fmt.Sprintf("question %d %s", i, strings.Repeat("word ", 8))},
+			api.Message{Role: "assistant", Content: fmt.Sprintf("answer %d %s", i, strings.Repeat("word ", 8))},
+		)
+	}
+	history = append(history, api.Message{Role: "user", Content: "What's the weather?"})
+
+	// long is the same kind of history, long enough to overflow larger
+	// contexts
+	var long []api.Message
+	for i := range 20 {
+		long = append(long,
+			api.Message{Role: "user", Content: fmt.Sprintf("question %d %s", i, strings.Repeat("word ", 8))},
+			api.Message{Role: "assistant", Content: fmt.Sprintf("answer %d %s", i, strings.Repeat("word ", 8))},
+		)
+	}
+
+	chat := func(t *testing.T, req api.ChatRequest) api.ChatResponse {
+		t.Helper()
+		req.Model = "test"
+		req.Stream = &stream
+		if req.Options == nil {
+			req.Options = map[string]any{"num_ctx": 80}
+		}
+
+		w := createRequest(t, s.ChatHandler, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body)
+		}
+
+		var resp api.ChatResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	t.Run("summarize", func(t *testing.T) {
+		summaryPrompts = nil
+		resp := chat(t, api.ChatRequest{
+			Messages:   history,
+			Compaction: &api.Compaction{Strategy: "summarize"},
+		})
+
+		if resp.Compaction == nil {
+			t.Fatal("expected the compaction to be reported")
+		}
+
+		if resp.Compaction.Strategy != "summarize" || resp.Compaction.Messages == 0 || resp.Compaction.Summary != summaryHeading+"The user asked about the weather." {
+			t.Errorf("unexpected compaction %+v", resp.Compaction)
+		}
+
+		if len(summaryPrompts) == 0 {
+			t.Fatal("expected a summary")
+		}
+
+		if !strings.Contains(summaryPrompts[0], "user: question 0 ") || strings.Contains(summaryPrompts[0], "You are a pirate.") {
+			t.Errorf("expected the summary of the oldest messages without the system message, got %q", summaryPrompts[0])
+		}
+
+		// the prompt is the history shortened the way clients are told to
+		// shorten it, with the template joining the system messages
+		compacted := resp.Compaction.Apply(history)
+		expected := fmt.Sprintf("system: %s\n\n%s\n", compacted[0].Content, compacted[1].Content)
+		for _, msg := range compacted[2:] {
+			expected += fmt.Sprintf("%s: %s\n", msg.Role, msg.Content)
+		}
+
+		if diff := cmp.Diff(expected, mock.CompletionRequest.Prompt); diff != "" {
+			t.Errorf("prompt mismatch (-want +got):\n%s", diff)
+		}
+
+		if compacted[1].Content != resp.Compaction.Summary {
+			t.Errorf("expected the summary after the system message, got %v", compacted[1])
+		}
+	})
+
+	t.Run("summary model", func(t *testing.T) {
+		w := createRequest(t, s.CreateHandler, api.CreateRequest{
+			Model:    "test-small",
+			Files:    map[string]string{"file.gguf": digest},
+			Template: `{{ range .Messages }}{{ .Content }} {{ end }}`,
+			Stream:   &stream,
+		})
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d", w.Code)
+		}
+
+		summaryPrompts = nil
+		resp := chat(t, api.ChatRequest{
+			Messages:   history,
+			Compaction: &api.Compaction{Strategy: "summarize", Model: "test-small"},
+		})
+
+		if resp.Compaction == nil || resp.Message.Content != "Arr, it's sunny." {
+			t.Fatalf("unexpected response %+v", resp)
+		}
+
+		// the summary is written with the summary model's template
+		if len(summaryPrompts) == 0 || !strings.HasPrefix(summaryPrompts[0], summaryInstructions+" ") {
+			t.Errorf("expected a summary prompt from test-small, got %q", summaryPrompts)
+		}
+	})
+
+	t.Run("summary model with a smaller context", func(t *testing.T) {
+		for _, req := range []api.CreateRequest{
+			{Model: "test-large", Parameters: map[string]any{"num_ctx": 400}},
+			{Model: "test-tiny", Parameters: map[string]any{"num_ctx": 100}},
+		} {
+			req.Files = map[string]string{"file.gguf": digest}
+			req.Template = `{{ range .Messages }}{{ .Content }} {{ end }}`
+			req.Stream = &stream
+			if w := createRequest(t, s.CreateHandler, req); w.Code != http.StatusOK {
+				t.Fatalf("expected status 200, got %d", w.Code)
+			}
+		}
+
+		summaryNumPredict = nil
+		w := createRequest(t, s.ChatHandler, api.ChatRequest{
+			Model:      "test-large",
+			Messages:   long,
+			Compaction: &api.Compaction{Strategy: "summarize", Model: "test-tiny"},
+			Stream:     &stream,
+		})
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body)
+		}
+
+		if len(summaryNumPredict) == 0 {
+			t.Fatal("expected a summary")
+		}
+
+		// the summary fits in the summary model's context, not the chat's
+		for _, n := range summaryNumPredict {
+			if n != 100/summaryBudget {
+				t.Errorf("expected the summary to be limited to %d tokens, got %d", 100/summaryBudget, n)
+			}
+		}
+	})
+
+	t.Run("summary too long", func(t *testing.T) {
+		summaryContent = strings.Repeat("word ", 40)
+		t.Cleanup(func() { summaryContent = "The user asked about the weather." })
+
+		summaryNumPredict = nil
+		w := createRequest(t, s.ChatHandler, api.ChatRequest{
+			Model:      "test",
+			Messages:   long,
+			Compaction: &api.Compaction{Strategy: "summarize"},
+			Options:    map[string]any{"num_ctx": 160},
+			Stream:     &stream,
+		})
+		if w.Code != http.StatusInternalServerError || !strings.Contains(w.Body.String(), errSummaryTooLong.Error()) {
+			t.Errorf("expected status 500 with %q, got %d: %s", errSummaryTooLong, w.Code, w.Body)
+		}
+
+		// the summary, written in parts, is written again with less space
+		// before giving up
+		if diff := cmp.Diff([]int{20, 10, 5}, slices.Compact(summaryNumPredict)); diff != "" {
+			t.Errorf("summary lengths mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("missing summary model", func(t *testing.T) {
+		w := createRequest(t, s.ChatHandler, api.ChatRequest{
+			Model:      "test",
+			Messages:   history,
+			Compaction: &api.Compaction{Strategy: "summarize", Model: "missing"},
+			Options:    map[string]any{"num_ctx": 80},
+			Stream:     &stream,
+		})
+		if w.Code != http.StatusNotFound {
+			t.Errorf("expected status 404, got %d: %s", w.Code, w.Body)
+		}
+	})
+
+	t.Run("everything fits", func(t *testing.T) {
+		summaryPrompts = nil
+		resp := chat(t, api.ChatRequest{
+			Messages:   history,
+			Compaction: &api.Compaction{Strategy: "summarize"},
+			Options:    map[string]any{"num_ctx": 8192},
+		})
+
+		if resp.Compaction != nil || len(summaryPrompts) != 0 {
+			t.Errorf("expected no compaction, got %+v", resp.Compaction)
+		}
+	})
+
+	t.Run("truncate", func(t *testing.T) {
+		summaryPrompts = nil
+		resp := chat(t, api.ChatRequest{
+			Messages:   history,
+			Compaction: &api.Compaction{Strategy: "truncate"},
+		})
+
+		if resp.Compaction == nil || resp.Compaction.Strategy != "truncate" || resp.Compaction.Messages == 0 || resp.Compaction.Summary != "" {
+			t.Fatalf("unexpected compaction %+v", resp.Compaction)
+		}
+
+		if len(summaryPrompts) != 0 {
+			t.Errorf("expected no summary, got %d", len(summaryPrompts))
+		}
+
+		kept := resp.Compaction.Apply(history)
+		if !strings.HasPrefix(mock.CompletionRequest.Prompt, fmt.Sprintf("system: You are a pirate.\n%s: %s\n", kept[1].Role, kept[1].Content)) {
+			t.Errorf("expected the prompt to start with the first kept message %v, got %q", kept[1], mock.CompletionRequest.Prompt)
+		}
+	})
+
+	t.Run("not truncated", func(t *testing.T) {
+		summaryPrompts = nil
+		truncate := false
+		resp := chat(t, api.ChatRequest{
+			Messages:   history,
+			Compaction: &api.Compaction{Strategy: "summarize"},
+			Truncate:   &truncate,
+		})
+
+		if resp.Compaction != nil || len(summaryPrompts) != 0 {
+			t.Errorf("expected no compaction, got %+v", resp.Compaction)
+		}
+	})
+
+	t.Run("invalid strategy", func(t *testing.T) {
+		w := createRequest(t, s.ChatHandler, api.ChatRequest{
+			Model:      "test",
+			Messages:   history,
+			Compaction: &api.Compaction{Strategy: "forget"},
+			Stream:     &stream,
+		})
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("expected status 400, got %d", w.Code)
+		}
+	})
+
+	t.Run("session", func(t *testing.T) {
+		w := createRequest(t, s.CreateSessionHandler, api.CreateSessionRequest{Messages: history[:len(history)-1]})
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body)
+		}
+
+		var session api.Session
+		if err := json.NewDecoder(w.Body).Decode(&session); err != nil {
+			t.Fatal(err)
+		}
+
+		resp := chat(t, api.ChatRequest{
+			SessionID:  session.ID,
+			Messages:   history[len(history)-1:],
+			Compaction: &api.Compaction{Strategy: "summarize"},
+		})
+		if resp.Compaction == nil {
+			t.Fatal("expected the compaction to be reported")
+		}
+
+		got, err := s.sessions.get(session.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// the session keeps the summary so it isn't summarized again
+		expected := append(resp.Compaction.Apply(history), api.Message{Role: "assistant", Content: "Arr, it's sunny."})
+		if diff := cmp.Diff(expected, got.Messages); diff != "" {
+			t.Errorf("messages mismatch (-want +got):\n%s", diff)
+		}
+	})
+}
@@ -27,6 +27,7 @@ import (
 	"github.com/ollama/ollama/envconfig"
 	"github.com/ollama/ollama/format"
 	"github.com/ollama/ollama/fs/ggml"
+	"github.com/ollama/ollama/model/parsers"
 	"github.com/ollama/ollama/template"
 	"github.com/ollama/ollama/types/errtypes"
 	"github.com/ollama/ollama/types/model"
@@ -35,6 +36,7 @@ import (
 var (
 	errNoFilesProvided         = errors.New("no files provided to convert")
 	errOnlyOneAdapterSupported = errors.New("only one adapter is currently supported")
+	errOnlyOneImatrixSupported = errors.New("only one importance matrix is currently supported")
 	errOnlyGGUFSupported       = errors.New("supplied file was not in GGUF format")
 	errUnknownType             = errors.New("unknown type")
 	errNeitherFromOrFiles      = errors.New("neither 'from' or 'files' was specified")
@@ -62,6 +64,13 @@ func (s *Server) CreateHandler(c *gin.Context) {
 	config.Renderer = r.Renderer
 	config.Parser = r.Parser
 
+	if parsers.IsSpec(r.Parser) {
+		if _, err := parsers.ParseSpec(r.Parser); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
 	for v := range r.Files {
 		if !fs.ValidPath(v) {
 			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": errFilePath.Error()})
@@ -241,6 +250,17 @@ func (s *Server) CreateHandler(c *gin.Context) {
 			}
 		}
 
+		digest, err := r.SourceDigest()
+		if err != nil {
+			ch <- gin.H{"error": err.Error()}
+			return
+		}
+
+		if err := writeBuildRecord(name, digest); err != nil {
+			ch <- gin.H{"error": err.Error()}
+			return
+		}
+
 		ch <- api.ProgressResponse{Status: "success"}
 	}()
 
@@ -460,10 +480,26 @@ func kvFromLayers(baseLayers []*layerGGML) (ggml.KV, error) {
 }
 
 func createModel(r api.CreateRequest, name model.Name, baseLayers []*layerGGML, config *ConfigV2, fn func(resp api.ProgressResponse)) (err error) {
+	rules, err := parseQuantizeRules(r.QuantizeRules)
+	if err != nil {
+		return err
+	}
+
+	quantType := strings.ToUpper(cmp.Or(r.Quantize, r.Quantization))
+	if len(rules) > 0 && quantType == "" {
+		return errors.New("quantize rules require a quantization type")
+	}
+
+	if r.MergeAdapters {
+		baseLayers, err = mergeAdapters(baseLayers, quantType != "", fn)
+		if err != nil {
+			return err
+		}
+	}
+
 	var layers []Layer
 	for _, layer := range baseLayers {
 		if layer.GGML != nil {
-			quantType := strings.ToUpper(cmp.Or(r.Quantize, r.Quantization))
 			if quantType != "" && layer.GGML.Name() == "gguf" && layer.MediaType == "application/vnd.ollama.image.model" {
 				want, err := ggml.ParseFileType(quantType)
 				if err != nil {
@@ -473,8 +509,8 @@ func createModel(r api.CreateRequest, name model.Name, baseLayers []*layerGGML,
 				ft := layer.GGML.KV().FileType()
 				if !slices.Contains([]string{"F16", "F32"}, ft.String()) {
 					return errors.New("quantization is only supported for F16 and F32 models")
-				} else if ft != want {
-					layer, err = quantizeLayer(layer, quantType, fn)
+				} else if ft != want || len(rules) > 0 {
+					layer, err = quantizeLayer(layer, quantType, r.Imatrix, rules, fn)
 					if err != nil {
 						return err
 					}
@@ -558,7 +594,7 @@ func createModel(r api.CreateRequest, name model.Name, baseLayers []*layerGGML,
 	return nil
 }
 
-func quantizeLayer(layer *layerGGML, quantizeType string, fn func(resp api.ProgressResponse)) (*layerGGML, error) {
+func quantizeLayer(layer *layerGGML, quantizeType string, imatrixFiles map[string]string, rules []quantizeRule, fn func(resp api.ProgressResponse)) (*layerGGML, error) {
 	ft := layer.GGML.KV().FileType()
 	var doneBytes atomic.Uint64
 	totalBytes := uint64(layer.Size) - layer.GGML.Tensors().Offset
@@ -572,10 +608,32 @@ func quantizeLayer(layer *layerGGML, quantizeType string, fn func(resp api.Progr
 		return nil, err
 	}
 
+	if len(imatrixFiles) > 1 {
+		return nil, errOnlyOneImatrixSupported
+	} else if len(imatrixFiles) == 0 && ftype.RequiresImatrix() {
+		return nil, fmt.Errorf("quantizing to %s requires an importance matrix", ftype)
+	}
+
 	blob, err := GetBlobsPath(layer.Digest)
 	if err != nil {
 		return nil, err
 	}
+
+	var im imatrix
+	for _, digest := range imatrixFiles {
+		imatrixPath, err := GetBlobsPath(digest)
+		if err != nil {
+			return nil, err
+		}
+
+		im, err = loadImatrix(imatrixPath, blob, func(progress float32) {
+			fn(api.ProgressResponse{Status: "computing importance matrix", Digest: digest, Total: 100, Completed: int64(progress * 100)})
+		})
+		if err != nil {
+			return nil, fmt.Errorf("importance matrix: %w", err)
+		}
+	}
+
 	fp, err := os.Open(blob)
 	if err != nil {
 		return nil, err
@@ -589,7 +647,7 @@ func quantizeLayer(layer *layerGGML, quantizeType string, fn func(resp api.Progr
 	defer temp.Close()
 	defer os.Remove(temp.Name())
 
-	if err := quantize(fp, temp, layer.GGML, ftype, fnWrap); err != nil {
+	if err := quantize(fp, temp, layer.GGML, ftype, im, rules, fnWrap); err != nil {
 		return nil, err
 	}
 	temp.Seek(0, io.SeekStart)
@@ -607,6 +665,8 @@ func quantizeLayer(layer *layerGGML, quantizeType string, fn func(resp api.Progr
 		slog.Error(fmt.Sprintf("error decoding ggml: %s\n", err))
 		return nil, err
 	}
+
+	fn(api.ProgressResponse{Status: "tensor types: " + tensorTypeCounts(f.Tensors().Items())})
 	return &layerGGML{newLayer, f}, nil
 }
 
@@ -263,6 +263,12 @@ func (b *blobDownload) run(ctx context.Context, requestURL *url.URL, opts *regis
 			if resp.StatusCode != http.StatusTemporaryRedirect && resp.StatusCode != http.StatusOK {
 				return nil, fmt.Errorf("unexpected status code %d", resp.StatusCode)
 			}
+
+			// registries that serve blobs directly, such as mirrors,
+			// don't redirect to a download URL
+			if resp.StatusCode == http.StatusOK && resp.Header.Get("Location") == "" {
+				return requestURL, nil
+			}
 			return resp.Location()
 		}
 	}()
@@ -0,0 +1,132 @@
+package server
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/ollama/ollama/api"
+)
+
+func TestDownloadBlobServedDirectly(t *testing.T) {
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+	data := []byte("a blob served by the registry itself")
+	digest := fmt.Sprintf("sha256:%x", sha256.Sum256(data))
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v2/library/direct/blobs/"+digest {
+			http.NotFound(w, r)
+			return
+		}
+
+		serveBlob(w, r, data)
+	}))
+	defer s.Close()
+
+	testDownloadBlob(t, s.URL, "library/direct", digest, data)
+}
+
+func TestDownloadBlobRedirectGetOnly(t *testing.T) {
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+	data := []byte("a blob behind a url signed for GET")
+	digest := fmt.Sprintf("sha256:%x", sha256.Sum256(data))
+
+	var mu sync.Mutex
+	var rejected []string
+	storage := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// presigned urls are only valid for the method they were signed for
+		if signed := r.URL.Query().Get("method"); r.Method != signed {
+			mu.Lock()
+			rejected = append(rejected, r.Method+" "+signed)
+			mu.Unlock()
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		serveBlob(w, r, data)
+	}))
+	defer storage.Close()
+
+	// the storage is on another host so the redirect to it isn't followed
+	storageURL, err := url.Parse(storage.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	storageURL.Host = "localhost:" + storageURL.Port()
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v2/library/redirect/blobs/"+digest {
+			http.NotFound(w, r)
+			return
+		}
+
+		http.Redirect(w, r, storageURL.String()+"/signed?method="+r.Method, http.StatusTemporaryRedirect)
+	}))
+	defer s.Close()
+
+	testDownloadBlob(t, s.URL, "library/redirect", digest, data)
+
+	if len(rejected) > 0 {
+		t.Errorf("signed url was requested with another method: %s", strings.Join(rejected, ", "))
+	}
+}
+
+// serveBlob writes data, or the part of it requested by a Range header.
+func serveBlob(w http.ResponseWriter, r *http.Request, data []byte) {
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	if r.Method != http.MethodGet {
+		return
+	}
+
+	var start, end int
+	if _, err := fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-%d", &start, &end); err == nil {
+		w.Header().Set("Content-Length", strconv.Itoa(end-start+1))
+		w.WriteHeader(http.StatusPartialContent)
+		w.Write(data[start : end+1])
+		return
+	}
+	w.Write(data)
+}
+
+// testDownloadBlob downloads digest of name from the registry at rawURL and
+// checks that the stored blob is data.
+func testDownloadBlob(t *testing.T, rawURL, name, digest string, data []byte) {
+	t.Helper()
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := downloadBlob(t.Context(), downloadOpts{
+		mp:      ParseModelPath(u.Host + "/" + name),
+		digest:  digest,
+		regOpts: &registryOptions{Insecure: true},
+		fn:      func(api.ProgressResponse) {},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := GetBlobsPath(digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := os.ReadFile(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(got) != string(data) {
+		t.Errorf("blob = %q; want %q", got, data)
+	}
+}
@@ -0,0 +1,430 @@
+package server
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/envconfig"
+	"github.com/ollama/ollama/template"
+	"github.com/ollama/ollama/types/model"
+)
+
+var (
+	errNoHuggingFaceModel = errors.New("no GGUF or safetensors files found in repository")
+	errSplitGGUF          = errors.New("split GGUF files are not supported")
+)
+
+// defaultHuggingFaceQuant is the GGUF preferred when a repository has more
+// than one and no quantization is requested
+const defaultHuggingFaceQuant = "Q4_K_M"
+
+var splitGGUFRegexp = regexp.MustCompile(`-\d{5}-of-\d{5}\.gguf$`)
+
+// hfFile is an entry in the Hugging Face tree API response
+type hfFile struct {
+	Type string `json:"type"`
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+	LFS  *hfLFS `json:"lfs,omitempty"`
+}
+
+// hfLFS describes a file stored with Git LFS. Oid is the sha256 of the file.
+type hfLFS struct {
+	Oid  string `json:"oid"`
+	Size int64  `json:"size"`
+}
+
+// digest returns the sha256 digest of the file if it is known before
+// downloading. Only files stored with LFS are hashed with sha256.
+func (f hfFile) digest() string {
+	if f.LFS != nil && f.LFS.Oid != "" {
+		return "sha256:" + f.LFS.Oid
+	}
+
+	return ""
+}
+
+// isHuggingFace reports whether mp refers to a Hugging Face repository
+func isHuggingFace(mp ModelPath) bool {
+	return mp.Registry == "hf.co" || mp.Registry == "huggingface.co"
+}
+
+// pullHuggingFace pulls a model from the files of a Hugging Face repository,
+// for repositories that Hugging Face's registry doesn't serve. The tag
+// selects the quantization of a GGUF repository. Safetensors repositories are
+// converted and quantized to the tag if it is not "latest". Blobs in
+// deleteMap and the downloaded safetensors are removed if no longer used.
+func pullHuggingFace(ctx context.Context, mp ModelPath, deleteMap map[string]struct{}, fn func(api.ProgressResponse)) error {
+	repo := mp.GetNamespaceRepository()
+
+	fn(api.ProgressResponse{Status: "pulling file list"})
+	files, err := hfListFiles(ctx, repo)
+	if err != nil {
+		return fmt.Errorf("pull file list: %w", err)
+	}
+
+	var layers []*layerGGML
+	var quantize string
+	if gguf, projector, err := hfSelectGGUF(files, mp.Tag); err == nil {
+		layers, err = hfPullGGUF(ctx, repo, gguf, fn)
+		if err != nil {
+			return err
+		}
+
+		if projector != nil {
+			projectorLayers, err := hfPullGGUF(ctx, repo, *projector, fn)
+			if err != nil {
+				return err
+			}
+
+			layers = append(layers, projectorLayers...)
+		}
+
+		if !slices.ContainsFunc(layers, func(l *layerGGML) bool { return l.MediaType == "application/vnd.ollama.image.template" }) {
+			templateLayers, err := hfTemplate(ctx, repo, files)
+			if err != nil {
+				return err
+			}
+
+			layers = append(layers, templateLayers...)
+		}
+	} else if !errors.Is(err, errNoHuggingFaceModel) {
+		return err
+	} else {
+		safetensors := hfSelectSafetensors(files)
+		if len(safetensors) == 0 {
+			return errNoHuggingFaceModel
+		}
+
+		blobs := make(map[string]string, len(safetensors))
+		for _, f := range safetensors {
+			digest, err := hfDownload(ctx, repo, f, fn)
+			if err != nil {
+				return err
+			}
+
+			blobs[f.Path] = digest
+			deleteMap[digest] = struct{}{}
+		}
+
+		layers, err = convertModelFromFiles(blobs, nil, false, fn)
+		if err != nil {
+			return err
+		}
+
+		if mp.Tag != DefaultTag {
+			quantize = mp.Tag
+		}
+	}
+
+	config := &ConfigV2{
+		OS:           "linux",
+		Architecture: "amd64",
+		RootFS: RootFS{
+			Type: "layers",
+		},
+	}
+
+	name := model.ParseName(mp.GetFullTagname())
+	if err := createModel(api.CreateRequest{Quantize: quantize}, name, layers, config, fn); err != nil {
+		return err
+	}
+
+	if !envconfig.NoPrune() && len(deleteMap) > 0 {
+		fn(api.ProgressResponse{Status: "removing unused layers"})
+		if err := deleteUnusedLayers(deleteMap); err != nil {
+			fn(api.ProgressResponse{Status: fmt.Sprintf("couldn't remove unused layers: %v", err)})
+		}
+	}
+
+	fn(api.ProgressResponse{Status: "success"})
+	return nil
+}
+
+// hfSelectGGUF returns the GGUF file matching the quantization in tag and a
+// multimodal projector if the repository has one
+func hfSelectGGUF(files []hfFile, tag string) (hfFile, *hfFile, error) {
+	var models, projectors []hfFile
+	for _, f := range files {
+		if f.Type != "file" || !strings.HasSuffix(strings.ToLower(f.Path), ".gguf") {
+			continue
+		}
+
+		if strings.Contains(strings.ToLower(path.Base(f.Path)), "mmproj") {
+			projectors = append(projectors, f)
+		} else {
+			models = append(models, f)
+		}
+	}
+
+	if len(models) == 0 {
+		return hfFile{}, nil, errNoHuggingFaceModel
+	}
+
+	quant := strings.ToUpper(tag)
+	if quant == strings.ToUpper(DefaultTag) {
+		quant = defaultHuggingFaceQuant
+	}
+
+	i := slices.IndexFunc(models, func(f hfFile) bool { return hfMatchQuant(f.Path, quant) })
+	switch {
+	case i < 0 && tag != DefaultTag:
+		return hfFile{}, nil, fmt.Errorf("no GGUF file found for quantization %q", tag)
+	case i < 0:
+		// no default quantization, so use the first file
+		i = 0
+	}
+
+	if splitGGUFRegexp.MatchString(models[i].Path) {
+		return hfFile{}, nil, errSplitGGUF
+	}
+
+	if len(projectors) == 0 {
+		return models[i], nil, nil
+	}
+
+	// prefer the unquantized projector since they are small
+	j := slices.IndexFunc(projectors, func(f hfFile) bool {
+		return hfMatchQuant(f.Path, "F16") || hfMatchQuant(f.Path, "BF16")
+	})
+	return models[i], &projectors[max(j, 0)], nil
+}
+
+// hfMatchQuant reports whether the file name contains quant delimited by
+// '-', '.' or '_'
+func hfMatchQuant(name, quant string) bool {
+	name = strings.ToUpper(strings.TrimSuffix(path.Base(name), path.Ext(name)))
+	for i := strings.Index(name, quant); i >= 0; {
+		end := i + len(quant)
+		if (i == 0 || strings.ContainsRune("-._", rune(name[i-1]))) &&
+			(end == len(name) || strings.ContainsRune("-.", rune(name[end]))) {
+			return true
+		}
+
+		next := strings.Index(name[i+1:], quant)
+		if next < 0 {
+			break
+		}
+		i += next + 1
+	}
+
+	return false
+}
+
+// hfSelectSafetensors returns the files needed to convert a safetensors model
+func hfSelectSafetensors(files []hfFile) []hfFile {
+	if !slices.ContainsFunc(files, func(f hfFile) bool { return strings.HasSuffix(f.Path, ".safetensors") }) {
+		return nil
+	}
+
+	return slices.DeleteFunc(slices.Clone(files), func(f hfFile) bool {
+		if f.Type != "file" || strings.Contains(f.Path, "/") {
+			return true
+		}
+
+		return !strings.HasSuffix(f.Path, ".safetensors") &&
+			!strings.HasSuffix(f.Path, ".json") &&
+			f.Path != "tokenizer.model"
+	})
+}
+
+// hfTemplate returns template and parameter layers derived from the chat
+// template in tokenizer_config.json
+func hfTemplate(ctx context.Context, repo string, files []hfFile) ([]*layerGGML, error) {
+	if !slices.ContainsFunc(files, func(f hfFile) bool { return f.Path == "tokenizer_config.json" }) {
+		return nil, nil
+	}
+
+	resp, err := hfRequest(ctx, hfURL("resolve", repo, "tokenizer_config.json"))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var config struct {
+		ChatTemplate json.RawMessage `json:"chat_template"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&config); err != nil {
+		return nil, err
+	}
+
+	// chat_template is either a string or a list of named templates
+	var chatTemplate string
+	if err := json.Unmarshal(config.ChatTemplate, &chatTemplate); err != nil {
+		var named []struct {
+			Name     string `json:"name"`
+			Template string `json:"template"`
+		}
+		if err := json.Unmarshal(config.ChatTemplate, &named); err == nil {
+			for _, t := range named {
+				if t.Name == "default" {
+					chatTemplate = t.Template
+				}
+			}
+		}
+	}
+
+	if chatTemplate == "" {
+		return nil, nil
+	}
+
+	t, err := template.Named(chatTemplate)
+	if err != nil {
+		slog.Debug("template detection", "error", err, "template", chatTemplate)
+		return nil, nil
+	}
+
+	layer, err := NewLayer(t.Reader(), "application/vnd.ollama.image.template")
+	if err != nil {
+		return nil, err
+	}
+
+	layer.status = fmt.Sprintf("using autodetected template %s", t.Name)
+	layers := []*layerGGML{{layer, nil}}
+
+	if t.Parameters != nil {
+		b, err := json.Marshal(t.Parameters)
+		if err != nil {
+			return nil, err
+		}
+
+		layer, err := NewLayer(strings.NewReader(string(b)), "application/vnd.ollama.image.params")
+		if err != nil {
+			return nil, err
+		}
+
+		layers = append(layers, &layerGGML{layer, nil})
+	}
+
+	return layers, nil
+}
+
+func hfPullGGUF(ctx context.Context, repo string, f hfFile, fn func(api.ProgressResponse)) ([]*layerGGML, error) {
+	digest, err := hfDownload(ctx, repo, f, fn)
+	if err != nil {
+		return nil, err
+	}
+
+	return ggufLayers(digest, fn)
+}
+
+func hfListFiles(ctx context.Context, repo string) ([]hfFile, error) {
+	u := envconfig.HuggingFaceEndpoint().JoinPath("api", "models", repo, "tree", "main")
+	u.RawQuery = url.Values{"recursive": {"true"}}.Encode()
+
+	resp, err := hfRequest(ctx, u)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var files []hfFile
+	if err := json.NewDecoder(resp.Body).Decode(&files); err != nil {
+		return nil, err
+	}
+
+	return files, nil
+}
+
+// hfDownload downloads f into the blob store and returns its digest. Files
+// already in the blob store are not downloaded again.
+func hfDownload(ctx context.Context, repo string, f hfFile, fn func(api.ProgressResponse)) (string, error) {
+	want := f.digest()
+	total := f.Size
+	if f.LFS != nil {
+		total = cmp.Or(f.LFS.Size, total)
+	}
+	if want != "" {
+		if p, err := GetBlobsPath(want); err == nil {
+			if fi, err := os.Stat(p); err == nil && fi.Size() == total {
+				fn(api.ProgressResponse{Status: fmt.Sprintf("pulling %s", want[7:19]), Digest: want, Total: total, Completed: total})
+				return want, nil
+			}
+		}
+	}
+
+	resp, err := hfRequest(ctx, hfURL("resolve", repo, f.Path))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	status := fmt.Sprintf("pulling %s", path.Base(f.Path))
+	if want != "" {
+		status = fmt.Sprintf("pulling %s", want[7:19])
+	}
+
+	layer, err := NewLayer(&progressReader{r: resp.Body, fn: func(completed int64) {
+		fn(api.ProgressResponse{Status: status, Digest: want, Total: total, Completed: completed})
+	}}, "")
+	if err != nil {
+		return "", err
+	}
+
+	if want != "" && layer.Digest != want {
+		if err := layer.Remove(); err != nil {
+			slog.Info("couldn't remove file with digest mismatch", "digest", layer.Digest, "error", err)
+		}
+		return "", fmt.Errorf("%s: %w", f.Path, errDigestMismatch)
+	}
+
+	return layer.Digest, nil
+}
+
+func hfURL(kind, repo, file string) *url.URL {
+	return envconfig.HuggingFaceEndpoint().JoinPath(repo, kind, "main", file)
+}
+
+func hfRequest(ctx context.Context, u *url.URL) (*http.Response, error) {
+	resp, err := makeRequest(ctx, http.MethodGet, u, nil, nil, &registryOptions{Token: envconfig.HuggingFaceToken()})
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized, resp.StatusCode == http.StatusForbidden:
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s: %w; set HF_TOKEN to pull private or gated repositories", u.Path, errUnauthorized)
+	case resp.StatusCode == http.StatusNotFound:
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s: %w", u.Path, os.ErrNotExist)
+	case resp.StatusCode >= http.StatusBadRequest:
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s: %s: %s", u.Path, resp.Status, body)
+	}
+
+	return resp, nil
+}
+
+// progressReader reports the number of bytes read from r
+type progressReader struct {
+	r         io.Reader
+	n         int64
+	fn        func(int64)
+	lastShown int64
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n += int64(n)
+	// avoid flooding the progress channel with tiny updates
+	if r.n-r.lastShown >= 1<<20 || (err != nil && r.n > r.lastShown) {
+		r.lastShown = r.n
+		r.fn(r.n)
+	}
+	return n, err
+}
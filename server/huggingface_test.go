@@ -0,0 +1,316 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/fs/ggml"
+	"github.com/ollama/ollama/types/model"
+)
+
+// hfTestServer serves files in the layout of the Hugging Face Hub API
+func hfTestServer(t *testing.T, repo string, files map[string][]byte) *httptest.Server {
+	t.Helper()
+
+	var tree []hfFile
+	for name, b := range files {
+		f := hfFile{Type: "file", Path: name, Size: int64(len(b))}
+		if strings.HasSuffix(name, ".gguf") || strings.HasSuffix(name, ".safetensors") {
+			sum := sha256.Sum256(b)
+			f.LFS = &hfLFS{Oid: hex.EncodeToString(sum[:]), Size: int64(len(b))}
+		}
+		tree = append(tree, f)
+	}
+
+	slices.SortFunc(tree, func(a, b hfFile) int { return strings.Compare(a.Path, b.Path) })
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/models/"+repo+"/tree/main", func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewEncoder(w).Encode(tree); err != nil {
+			t.Error(err)
+		}
+	})
+	mux.HandleFunc("GET /"+repo+"/resolve/main/{file...}", func(w http.ResponseWriter, r *http.Request) {
+		b, ok := files[r.PathValue("file")]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(b)
+	})
+
+	s := httptest.NewServer(mux)
+	t.Cleanup(s.Close)
+	t.Setenv("HF_ENDPOINT", s.URL)
+
+	// requests to Hugging Face's registry are sent to the server too, which
+	// doesn't serve the repository so its files are pulled
+	dialHuggingFace(t, s.Listener.Addr().String())
+	return s
+}
+
+// dialHuggingFace sends requests to hf.co to addr
+func dialHuggingFace(t *testing.T, addr string) {
+	t.Helper()
+	testMakeRequestDialContext = func(ctx context.Context, network, a string) (net.Conn, error) {
+		if host, _, _ := net.SplitHostPort(a); host == "hf.co" {
+			a = addr
+		}
+
+		var d net.Dialer
+		return d.DialContext(ctx, network, a)
+	}
+	t.Cleanup(func() { testMakeRequestDialContext = nil })
+}
+
+func hfTestGGUF(t *testing.T, kv ggml.KV) []byte {
+	t.Helper()
+
+	f, err := os.CreateTemp(t.TempDir(), "*.gguf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	base := ggml.KV{"general.architecture": "test"}
+	for k, v := range kv {
+		base[k] = v
+	}
+
+	if err := ggml.WriteGGUF(f, base, []*ggml.Tensor{
+		{Name: "token_embd.weight", Shape: []uint64{1}, WriterTo: bytes.NewReader(make([]byte, 4))},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return b
+}
+
+func hfManifestMediaTypes(t *testing.T, name string) []string {
+	t.Helper()
+
+	m, err := ParseNamedManifest(model.ParseName(name))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var mediaTypes []string
+	for _, l := range m.Layers {
+		mediaTypes = append(mediaTypes, l.MediaType)
+	}
+
+	return mediaTypes
+}
+
+func TestPullHuggingFaceGGUF(t *testing.T) {
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+	q4 := hfTestGGUF(t, ggml.KV{"general.file_type": uint32(15)})
+	q8 := hfTestGGUF(t, ggml.KV{"general.file_type": uint32(7)})
+	hfTestServer(t, "org/repo", map[string][]byte{
+		"README.md":             []byte("# repo"),
+		"model-Q4_K_M.gguf":     q4,
+		"model-Q8_0.gguf":       q8,
+		"mmproj-model-f16.gguf": hfTestGGUF(t, ggml.KV{"general.type": "projector"}),
+		"tokenizer_config.json": []byte(`{"chat_template": "{% for message in messages %}{{'<|im_start|>' + message['role'] + '\n' + message['content'] + '<|im_end|>' + '\n'}}{% endfor %}{% if add_generation_prompt %}{{ '<|im_start|>assistant\n' }}{% endif %}"}`),
+	})
+
+	var statuses []string
+	fn := func(r api.ProgressResponse) { statuses = append(statuses, r.Status) }
+
+	t.Run("default", func(t *testing.T) {
+		if err := PullModel(t.Context(), "hf.co/org/repo", &registryOptions{Insecure: true}, fn); err != nil {
+			t.Fatal(err)
+		}
+
+		if diff := cmp.Diff([]string{
+			"application/vnd.ollama.image.model",
+			"application/vnd.ollama.image.projector",
+			"application/vnd.ollama.image.template",
+			"application/vnd.ollama.image.params",
+		}, hfManifestMediaTypes(t, "hf.co/org/repo")); diff != "" {
+			t.Errorf("layers mismatch (-want +got):\n%s", diff)
+		}
+
+		m, err := ParseNamedManifest(model.ParseName("hf.co/org/repo"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		sum := sha256.Sum256(q4)
+		if want := "sha256:" + hex.EncodeToString(sum[:]); m.Layers[0].Digest != want {
+			t.Errorf("model digest: have %s; want %s", m.Layers[0].Digest, want)
+		}
+
+		if !slices.Contains(statuses, "using autodetected template chatml") {
+			t.Errorf("template was not detected: %v", statuses)
+		}
+
+		if statuses[len(statuses)-1] != "success" {
+			t.Errorf("last status: have %q; want success", statuses[len(statuses)-1])
+		}
+	})
+
+	t.Run("quantization", func(t *testing.T) {
+		if err := PullModel(t.Context(), "hf.co/org/repo:q8_0", &registryOptions{Insecure: true}, fn); err != nil {
+			t.Fatal(err)
+		}
+
+		m, err := ParseNamedManifest(model.ParseName("hf.co/org/repo:q8_0"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		sum := sha256.Sum256(q8)
+		if want := "sha256:" + hex.EncodeToString(sum[:]); m.Layers[0].Digest != want {
+			t.Errorf("model digest: have %s; want %s", m.Layers[0].Digest, want)
+		}
+	})
+
+	t.Run("unknown quantization", func(t *testing.T) {
+		err := PullModel(t.Context(), "hf.co/org/repo:q2_k", &registryOptions{Insecure: true}, fn)
+		if err == nil || !strings.Contains(err.Error(), "no GGUF file found") {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		err := PullModel(t.Context(), "hf.co/org/missing", &registryOptions{Insecure: true}, fn)
+		if !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+}
+
+func TestPullHuggingFaceRegistry(t *testing.T) {
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+	_, host := newTestRegistry(t)
+	createBundleTestModel(t, host+"/org/repo", "registry model")
+	if err := PushModel(t.Context(), host+"/org/repo", &registryOptions{Insecure: true}, func(api.ProgressResponse) {}); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+	s := hfTestServer(t, "org/repo", map[string][]byte{"model.gguf": hfTestGGUF(t, nil)})
+	s.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("repository files were requested: %s", r.URL)
+		http.NotFound(w, r)
+	})
+
+	// the registry serves the repository, so its files aren't needed. Like
+	// Hugging Face's, it redirects to where blobs are downloaded from.
+	front := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "http://"+host+r.URL.Path, http.StatusTemporaryRedirect)
+	}))
+	t.Cleanup(front.Close)
+	dialHuggingFace(t, front.Listener.Addr().String())
+	if err := PullModel(t.Context(), "hf.co/org/repo", &registryOptions{Insecure: true}, func(api.ProgressResponse) {}); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := ParseNamedManifest(model.ParseName("hf.co/org/repo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sum := sha256.Sum256([]byte("registry model"))
+	if want := "sha256:" + hex.EncodeToString(sum[:]); len(m.Layers) == 0 || m.Layers[0].Digest != want {
+		t.Errorf("layers = %v; want the model from the registry, %s", m.Layers, want)
+	}
+}
+
+func TestPullHuggingFaceRegistryError(t *testing.T) {
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+	s := hfTestServer(t, "org/repo", map[string][]byte{"model.gguf": hfTestGGUF(t, nil)})
+	s.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/v2/") {
+			http.Error(w, "service unavailable", http.StatusServiceUnavailable)
+			return
+		}
+
+		t.Errorf("repository files were requested: %s", r.URL)
+		http.NotFound(w, r)
+	})
+
+	// only a repository the registry doesn't have is pulled from its files
+	err := PullModel(t.Context(), "hf.co/org/repo", &registryOptions{Insecure: true}, func(api.ProgressResponse) {})
+	if err == nil || !strings.Contains(err.Error(), "service unavailable") {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestPullHuggingFaceDigestMismatch(t *testing.T) {
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+	s := hfTestServer(t, "org/repo", map[string][]byte{"model.gguf": hfTestGGUF(t, nil)})
+
+	// serve a tree that doesn't match the file contents
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/models/org/repo/tree/main", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode([]hfFile{{Type: "file", Path: "model.gguf", LFS: &hfLFS{Oid: strings.Repeat("0", 64)}}})
+	})
+	mux.Handle("/", s.Config.Handler)
+	s.Config.Handler = mux
+
+	err := PullModel(t.Context(), "hf.co/org/repo", &registryOptions{Insecure: true}, func(api.ProgressResponse) {})
+	if !errors.Is(err, errDigestMismatch) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	blobs, err := filepath.Glob(filepath.Join(os.Getenv("OLLAMA_MODELS"), "blobs", "sha256-*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(blobs) != 0 {
+		t.Errorf("blobs were not removed: %v", blobs)
+	}
+}
+
+func TestHFMatchQuant(t *testing.T) {
+	cases := []struct {
+		name, quant string
+		want        bool
+	}{
+		{"Llama-3.2-1B-Instruct-Q4_K_M.gguf", "Q4_K_M", true},
+		{"llama-3.2-1b-instruct.q4_k_m.gguf", "Q4_K_M", true},
+		{"Q8_0/model-Q8_0.gguf", "Q8_0", true},
+		{"Llama-3.2-1B-Instruct-Q4_K_M.gguf", "Q4_K", false},
+		{"Llama-3.2-1B-Instruct-IQ4_XS.gguf", "Q4_XS", false},
+		{"model-BF16.gguf", "F16", false},
+		{"model-f16.gguf", "F16", true},
+	}
+
+	for _, tt := range cases {
+		if got := hfMatchQuant(tt.name, tt.quant); got != tt.want {
+			t.Errorf("hfMatchQuant(%q, %q) = %v; want %v", tt.name, tt.quant, got, tt.want)
+		}
+	}
+}
@@ -25,6 +25,7 @@ import (
 	"github.com/ollama/ollama/envconfig"
 	"github.com/ollama/ollama/fs/gguf"
 	"github.com/ollama/ollama/model/parsers"
+	"github.com/ollama/ollama/model/renderers"
 	"github.com/ollama/ollama/parser"
 	"github.com/ollama/ollama/template"
 	"github.com/ollama/ollama/thinking"
@@ -49,6 +50,9 @@ type registryOptions struct {
 	Password string
 	Token    string
 
+	// Sign signs pushed manifests with the server's key
+	Sign bool
+
 	CheckRedirect func(req *http.Request, via []*http.Request) error
 }
 
@@ -73,12 +77,24 @@ type Model struct {
 func (m *Model) Capabilities() []model.Capability {
 	capabilities := []model.Capability{}
 
+	// jinjaVars are the variables of the model's Jinja chat template when it's
+	// used to render prompts
+	var jinjaVars []string
+
 	// Check for completion capability
 	if m.ModelPath != "" {
 		f, err := gguf.Open(m.ModelPath)
 		if err == nil {
 			defer f.Close()
 
+			if m.Config.Renderer == "jinja" {
+				if r, err := renderers.JinjaRendererForModel(m.ModelPath); err == nil {
+					jinjaVars = r.Vars()
+				} else {
+					slog.Warn("model chat template contains errors", "error", err)
+				}
+			}
+
 			if f.KeyValue("pooling_type").Valid() {
 				capabilities = append(capabilities, model.CapabilityEmbedding)
 			} else {
@@ -109,7 +125,7 @@ func (m *Model) Capabilities() []model.Capability {
 	if err != nil {
 		slog.Warn("model template contains errors", "error", err)
 	}
-	if slices.Contains(v, "tools") || (builtinParser != nil && builtinParser.HasToolSupport()) {
+	if slices.Contains(v, "tools") || (builtinParser != nil && builtinParser.HasToolSupport()) || slices.Contains(jinjaVars, "tools") {
 		capabilities = append(capabilities, model.CapabilityTools)
 	}
 
@@ -132,7 +148,8 @@ func (m *Model) Capabilities() []model.Capability {
 	openingTag, closingTag := thinking.InferTags(m.Template.Template)
 	hasTags := openingTag != "" && closingTag != ""
 	isGptoss := slices.Contains([]string{"gptoss", "gpt-oss"}, m.Config.ModelFamily)
-	if hasTags || isGptoss || (builtinParser != nil && builtinParser.HasThinkingSupport()) {
+	jinjaThinking := slices.Contains(jinjaVars, "enable_thinking") || slices.Contains(jinjaVars, "reasoning_effort")
+	if hasTags || isGptoss || (builtinParser != nil && builtinParser.HasThinkingSupport()) || jinjaThinking {
 		capabilities = append(capabilities, model.CapabilityThinking)
 	}
 
@@ -578,6 +595,9 @@ func PushModel(ctx context.Context, name string, regOpts *registryOptions, fn fu
 		return err
 	}
 
+	// signatures are pushed separately from the manifest they sign
+	manifest.Layers = withoutSignatures(manifest.Layers)
+
 	var layers []Layer
 	layers = append(layers, manifest.Layers...)
 	if manifest.Config.Digest != "" {
@@ -592,25 +612,58 @@ func PushModel(ctx context.Context, name string, regOpts *registryOptions, fn fu
 	}
 
 	fn(api.ProgressResponse{Status: "pushing manifest"})
-	requestURL := mp.BaseURL()
-	requestURL = requestURL.JoinPath("v2", mp.GetNamespaceRepository(), "manifests", mp.Tag)
-
-	manifestJSON, err := json.Marshal(manifest)
+	manifestJSON, err := pushManifest(ctx, mp, mp.Tag, manifest, regOpts)
 	if err != nil {
 		return err
 	}
 
+	if regOpts.Sign {
+		signature, err := pushManifestSignature(ctx, mp, manifestJSON, regOpts, fn)
+		if err != nil {
+			return err
+		}
+
+		// keep the signature with the local model so that it is shown
+		// and exported along with it
+		manifest.Layers = append(manifest.Layers, signature)
+		b, err := json.Marshal(manifest)
+		if err != nil {
+			return err
+		}
+
+		fp, err := mp.GetManifestPath()
+		if err != nil {
+			return err
+		}
+
+		if err := os.WriteFile(fp, b, 0o644); err != nil {
+			return err
+		}
+	}
+
+	fn(api.ProgressResponse{Status: "success"})
+
+	return nil
+}
+
+// pushManifest pushes m to the registry with tag and returns the pushed data
+func pushManifest(ctx context.Context, mp ModelPath, tag string, m *Manifest, regOpts *registryOptions) ([]byte, error) {
+	requestURL := mp.BaseURL().JoinPath("v2", mp.GetNamespaceRepository(), "manifests", tag)
+
+	manifestJSON, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+
 	headers := make(http.Header)
 	headers.Set("Content-Type", "application/vnd.docker.distribution.manifest.v2+json")
 	resp, err := makeRequestWithRetry(ctx, http.MethodPut, requestURL, headers, bytes.NewReader(manifestJSON), regOpts)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer resp.Body.Close()
 
-	fn(api.ProgressResponse{Status: "success"})
-
-	return nil
+	return manifestJSON, nil
 }
 
 func PullModel(ctx context.Context, name string, regOpts *registryOptions, fn func(api.ProgressResponse)) error {
@@ -638,11 +691,22 @@ func PullModel(ctx context.Context, name string, regOpts *registryOptions, fn fu
 
 	fn(api.ProgressResponse{Status: "pulling manifest"})
 
-	manifest, err = pullModelManifest(ctx, mp, regOpts)
-	if err != nil {
+	manifest, manifestJSON, err := pullModelManifest(ctx, mp, regOpts)
+	if errors.Is(err, os.ErrNotExist) && isHuggingFace(mp) {
+		// Hugging Face's registry only serves repositories of GGUF files it
+		// supports, so other repositories are pulled from their files. Any
+		// other error, such as an outage, is returned.
+		slog.Debug("pulling from Hugging Face repository files", "name", name, "error", err)
+		return pullHuggingFace(ctx, mp, deleteMap, fn)
+	} else if err != nil {
 		return fmt.Errorf("pull model manifest: %s", err)
 	}
 
+	signature, err := verifyPulledManifest(ctx, mp, manifestDigest(manifestJSON), regOpts)
+	if err != nil {
+		return err
+	}
+
 	var layers []Layer
 	layers = append(layers, manifest.Layers...)
 	if manifest.Config.Digest != "" {
@@ -686,9 +750,21 @@ func PullModel(ctx context.Context, name string, regOpts *registryOptions, fn fu
 		}
 	}
 
+	if signature != nil {
+		// keep the signature with the model so that it can be shown and
+		// exported along with it
+		layer, err := NewLayer(bytes.NewReader(signature), mediaTypeSignature)
+		if err != nil {
+			return err
+		}
+
+		manifest.Layers = append(withoutSignatures(manifest.Layers), layer)
+		delete(deleteMap, layer.Digest)
+	}
+
 	fn(api.ProgressResponse{Status: "writing manifest"})
 
-	manifestJSON, err := json.Marshal(manifest)
+	manifestJSON, err = json.Marshal(manifest)
 	if err != nil {
 		return err
 	}
@@ -719,23 +795,30 @@ func PullModel(ctx context.Context, name string, regOpts *registryOptions, fn fu
 	return nil
 }
 
-func pullModelManifest(ctx context.Context, mp ModelPath, regOpts *registryOptions) (*Manifest, error) {
+// pullModelManifest returns the manifest of mp and its data as served by the
+// registry
+func pullModelManifest(ctx context.Context, mp ModelPath, regOpts *registryOptions) (*Manifest, []byte, error) {
 	requestURL := mp.BaseURL().JoinPath("v2", mp.GetNamespaceRepository(), "manifests", mp.Tag)
 
 	headers := make(http.Header)
 	headers.Set("Accept", "application/vnd.docker.distribution.manifest.v2+json")
 	resp, err := makeRequestWithRetry(ctx, http.MethodGet, requestURL, headers, nil, regOpts)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	defer resp.Body.Close()
 
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+
 	var m Manifest
-	if err := json.NewDecoder(resp.Body).Decode(&m); err != nil {
-		return nil, err
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, nil, err
 	}
 
-	return &m, err
+	return &m, b, nil
 }
 
 // GetSHA256Digest returns the SHA256 hash of a given buffer and returns it, and the size of buffer
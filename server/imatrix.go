@@ -0,0 +1,224 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	fsggml "github.com/ollama/ollama/fs/ggml"
+	"github.com/ollama/ollama/llm"
+)
+
+// imatrix maps tensor names to their importance matrix: the mean squared
+// activation of each input column, with one row per expert for 3D tensors.
+type imatrix map[string][]float32
+
+// weights returns the importance matrix for t, or nil if there isn't one.
+func (im imatrix) weights(t *fsggml.Tensor) ([]float32, error) {
+	w, ok := im[t.Name]
+	if !ok {
+		return nil, nil
+	}
+
+	n := t.Shape[0]
+	if len(t.Shape) > 2 {
+		n *= t.Shape[2]
+	}
+
+	if uint64(len(w)) != n {
+		return nil, fmt.Errorf("importance matrix for tensor %s has %d values, expected %d", t.Name, len(w), n)
+	}
+
+	return w, nil
+}
+
+// imatrixCalibrationContext is the number of tokens in each chunk of
+// calibration text, matching llama.cpp's imatrix tool.
+const imatrixCalibrationContext = 512
+
+// loadImatrix reads the importance matrix in path. Both GGUF and legacy
+// llama.cpp imatrix files are accepted. Any other text is treated as
+// calibration data and run through the model at modelPath by a runner to
+// compute one.
+func loadImatrix(path, modelPath string, fn func(float32)) (imatrix, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var magic [4]byte
+	if _, err := io.ReadFull(f, magic[:]); err == nil && bytes.Equal(magic[:], []byte("GGUF")) {
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+
+		return parseImatrixGGUF(f)
+	}
+
+	bts, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if im, err := parseImatrixLegacy(bytes.NewReader(bts)); err == nil {
+		return im, nil
+	}
+
+	if !utf8.Valid(bts) {
+		return nil, errors.New("unsupported importance matrix format")
+	}
+
+	out, err := os.CreateTemp(filepath.Dir(path), "imatrix")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(out.Name())
+	defer out.Close()
+
+	if err := llm.ComputeImatrix(modelPath, path, out.Name(), imatrixCalibrationContext, fn); err != nil {
+		return nil, err
+	}
+
+	return parseImatrixLegacy(bufio.NewReader(out))
+}
+
+// parseImatrixGGUF reads an importance matrix stored as GGUF. Each weight
+// has an <name>.in_sum2 tensor holding sums of squared activations and a
+// <name>.counts tensor holding the number of activations per expert.
+func parseImatrixGGUF(f *os.File) (imatrix, error) {
+	g, err := fsggml.Decode(f, -1)
+	if err != nil {
+		return nil, err
+	}
+
+	if kind := g.KV().Kind(); kind != "imatrix" {
+		return nil, fmt.Errorf("unexpected file type %q, expected imatrix", kind)
+	}
+
+	tensors := make(map[string]*fsggml.Tensor)
+	for _, t := range g.Tensors().Items() {
+		if fsggml.TensorType(t.Kind) != fsggml.TensorTypeF32 {
+			return nil, fmt.Errorf("unexpected type %s for tensor %s", fsggml.TensorType(t.Kind), t.Name)
+		}
+		tensors[t.Name] = t
+	}
+
+	read := func(t *fsggml.Tensor) ([]float32, error) {
+		values := make([]float32, t.Elements())
+		sr := io.NewSectionReader(f, int64(g.Tensors().Offset+t.Offset), int64(t.Size()))
+		if err := binary.Read(sr, binary.LittleEndian, values); err != nil {
+			return nil, err
+		}
+		return values, nil
+	}
+
+	im := make(imatrix)
+	for name, sums := range tensors {
+		name, ok := strings.CutSuffix(name, ".in_sum2")
+		if !ok {
+			continue
+		}
+
+		counts, ok := tensors[name+".counts"]
+		if !ok {
+			return nil, fmt.Errorf("missing counts for tensor %s", name)
+		}
+
+		s, err := read(sums)
+		if err != nil {
+			return nil, err
+		}
+
+		c, err := read(counts)
+		if err != nil {
+			return nil, err
+		}
+
+		n := len(s) / max(1, len(c))
+		if n*len(c) != len(s) {
+			return nil, fmt.Errorf("mismatched counts for tensor %s", name)
+		}
+
+		for i, count := range c {
+			for j := range n {
+				if count > 0 {
+					s[i*n+j] /= count
+				} else {
+					// unused experts get equal importance
+					s[i*n+j] = 1
+				}
+			}
+		}
+
+		im[name] = s
+	}
+
+	return im, nil
+}
+
+// parseImatrixLegacy reads an importance matrix in the binary format
+// written by older versions of llama.cpp.
+func parseImatrixLegacy(r io.Reader) (imatrix, error) {
+	var entries int32
+	if err := binary.Read(r, binary.LittleEndian, &entries); err != nil {
+		return nil, err
+	}
+
+	if entries < 1 {
+		return nil, errors.New("no entries in importance matrix")
+	}
+
+	im := make(imatrix)
+	for range entries {
+		var n int32
+		if err := binary.Read(r, binary.LittleEndian, &n); err != nil {
+			return nil, err
+		}
+
+		if n < 1 || n > 1<<10 {
+			return nil, fmt.Errorf("invalid name length %d", n)
+		}
+
+		name := make([]byte, n)
+		if _, err := io.ReadFull(r, name); err != nil {
+			return nil, err
+		}
+
+		var header struct {
+			Calls, Values int32
+		}
+		if err := binary.Read(r, binary.LittleEndian, &header); err != nil {
+			return nil, err
+		}
+
+		if header.Values < 1 || header.Values > 1<<24 {
+			return nil, fmt.Errorf("invalid number of values %d for tensor %s", header.Values, name)
+		}
+
+		values := make([]float32, header.Values)
+		if err := binary.Read(r, binary.LittleEndian, values); err != nil {
+			return nil, err
+		}
+
+		// values are sums over calls
+		if header.Calls > 0 {
+			for i := range values {
+				values[i] /= float32(header.Calls)
+			}
+		}
+
+		im[string(name)] = values
+	}
+
+	// the last call count and the name of the dataset may follow, but
+	// neither is needed
+	return im, nil
+}
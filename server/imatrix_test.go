@@ -0,0 +1,164 @@
+package server
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	fsggml "github.com/ollama/ollama/fs/ggml"
+)
+
+func TestParseImatrixLegacy(t *testing.T) {
+	var b bytes.Buffer
+	write := func(v any) {
+		if err := binary.Write(&b, binary.LittleEndian, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write(int32(2))
+	for _, e := range []struct {
+		name   string
+		calls  int32
+		values []float32
+	}{
+		{"blk.0.attn_q.weight", 2, []float32{2, 4, 6, 8}},
+		{"blk.0.ffn_down.weight", 0, []float32{1, 2}},
+	} {
+		write(int32(len(e.name)))
+		b.WriteString(e.name)
+		write(e.calls)
+		write(int32(len(e.values)))
+		write(e.values)
+	}
+
+	// last call and dataset name
+	write(int32(10))
+	write(int32(len("calibration.txt")))
+	b.WriteString("calibration.txt")
+
+	im, err := parseImatrixLegacy(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff(imatrix{
+		"blk.0.attn_q.weight":   {1, 2, 3, 4},
+		"blk.0.ffn_down.weight": {1, 2},
+	}, im); diff != "" {
+		t.Errorf("imatrix mismatch (-want +got):\n%s", diff)
+	}
+
+	if _, err := parseImatrixLegacy(strings.NewReader("Once upon a time, there was a calibration text")); err == nil {
+		t.Error("expected error parsing text")
+	}
+}
+
+func TestParseImatrixGGUF(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "imatrix.gguf")
+	f, err := os.Create(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	f32s := func(values ...float32) *bytes.Reader {
+		var b bytes.Buffer
+		if err := binary.Write(&b, binary.LittleEndian, values); err != nil {
+			t.Fatal(err)
+		}
+		return bytes.NewReader(b.Bytes())
+	}
+
+	if err := fsggml.WriteGGUF(f, fsggml.KV{
+		"general.architecture": "llama",
+		"general.type":         "imatrix",
+	}, []*fsggml.Tensor{
+		{Name: "blk.0.attn_q.weight.in_sum2", Kind: uint32(fsggml.TensorTypeF32), Shape: []uint64{2, 1}, WriterTo: f32s(4, 8)},
+		{Name: "blk.0.attn_q.weight.counts", Kind: uint32(fsggml.TensorTypeF32), Shape: []uint64{1, 1}, WriterTo: f32s(4)},
+		{Name: "blk.0.ffn_down_exps.weight.in_sum2", Kind: uint32(fsggml.TensorTypeF32), Shape: []uint64{2, 2}, WriterTo: f32s(3, 6, 5, 5)},
+		{Name: "blk.0.ffn_down_exps.weight.counts", Kind: uint32(fsggml.TensorTypeF32), Shape: []uint64{1, 2}, WriterTo: f32s(3, 0)},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	im, err := loadImatrix(p, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff(imatrix{
+		"blk.0.attn_q.weight":        {1, 2},
+		"blk.0.ffn_down_exps.weight": {1, 2, 1, 1},
+	}, im); diff != "" {
+		t.Errorf("imatrix mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestImatrixWeights(t *testing.T) {
+	im := imatrix{
+		"blk.0.attn_q.weight":        slices.Repeat([]float32{1}, 256),
+		"blk.0.ffn_down_exps.weight": slices.Repeat([]float32{1}, 256*4),
+	}
+
+	for _, tt := range []struct {
+		name  string
+		shape []uint64
+		want  int
+		err   bool
+	}{
+		{"blk.0.attn_q.weight", []uint64{256, 512}, 256, false},
+		{"blk.0.attn_q.weight", []uint64{512, 256}, 0, true},
+		{"blk.0.ffn_down_exps.weight", []uint64{256, 512, 4}, 256 * 4, false},
+		{"blk.0.attn_k.weight", []uint64{256, 512}, 0, false},
+	} {
+		w, err := im.weights(&fsggml.Tensor{Name: tt.name, Shape: tt.shape})
+		if (err != nil) != tt.err {
+			t.Errorf("%s %v: unexpected error %v", tt.name, tt.shape, err)
+		}
+
+		if len(w) != tt.want {
+			t.Errorf("%s %v: got %d weights, want %d", tt.name, tt.shape, len(w), tt.want)
+		}
+	}
+}
+
+func TestQuantizeMissingImatrix(t *testing.T) {
+	p, _ := createBinFile(t, map[string]any{
+		"general.architecture": "foo",
+	}, []*fsggml.Tensor{
+		{
+			Name: "blk.0.attn_q.weight", Kind: uint32(fsggml.TensorTypeF32),
+			Shape:    []uint64{256, 1},
+			WriterTo: bytes.NewReader(quantBytes[fsggml.TensorTypeF32]),
+		},
+	})
+
+	in, err := os.Open(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer in.Close()
+
+	meta, err := fsggml.Decode(in, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := os.Create(filepath.Join(t.TempDir(), "out"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+
+	err = quantize(in, out, meta, fsggml.FileTypeIQ2_XS, nil, nil, func(uint64) {})
+	if err == nil || !strings.Contains(err.Error(), "missing importance matrix") {
+		t.Fatalf("expected missing importance matrix error, got %v", err)
+	}
+}
@@ -231,6 +231,17 @@ type Registry struct {
 	// including the body.
 	// A zero or negative value means there will be no timeout.
 	ReadTimeout time.Duration
+
+	// VerifySignature, if set, is called by Pull before any layers are
+	// downloaded with the name of the model, the digest of its manifest,
+	// and the contents of the manifest's detached signature, or nil if the
+	// manifest is unsigned. Pull fails with any error it returns.
+	VerifySignature func(name string, manifest blob.Digest, signature []byte) error
+
+	// RequiresSignature, if set, is called by Pull with the name of the
+	// model before its signature is fetched. If it returns false, the
+	// signature isn't fetched and VerifySignature isn't called.
+	RequiresSignature func(name string) bool
 }
 
 func (r *Registry) readTimeout() time.Duration {
@@ -467,6 +478,17 @@ func (r *Registry) Pull(ctx context.Context, name string) error {
 		return err
 	}
 
+	if r.VerifySignature != nil && (r.RequiresSignature == nil || r.RequiresSignature(m.Name)) {
+		md := blob.DigestFromBytes(m.Data)
+		sig, err := r.signature(ctx, name, md)
+		if err != nil {
+			return err
+		}
+		if err := r.VerifySignature(m.Name, md, sig); err != nil {
+			return err
+		}
+	}
+
 	// TODO(bmizerany): decide if this should be considered valid. Maybe
 	// server-side we special case '{}' to have some special meaning? Maybe
 	// "archiving" a tag (which is how we reason about it in the registry
@@ -805,6 +827,60 @@ func (r *Registry) Resolve(ctx context.Context, name string) (*Manifest, error)
 	return m, nil
 }
 
+// signature returns the contents of the detached signature of the manifest
+// of name with digest md, or nil if the manifest is unsigned. Signatures are
+// pushed as the only layer of a manifest tagged "sha256-<hex>.sig".
+func (r *Registry) signature(ctx context.Context, name string, md blob.Digest) ([]byte, error) {
+	scheme, n, _, err := r.parseNameExtended(name)
+	if err != nil {
+		return nil, err
+	}
+
+	tag := strings.Replace(md.String(), ":", "-", 1) + ".sig"
+	res, err := r.send(ctx, "GET", fmt.Sprintf("%s://%s/v2/%s/%s/manifests/%s", scheme, n.Host(), n.Namespace(), n.Model(), tag), nil)
+	var e *Error
+	if errors.Is(err, ErrModelNotFound) || errors.As(err, &e) && e.status == 404 {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	const maxSize = 64 << 10
+	var sm struct {
+		Layers []*Layer `json:"layers"`
+	}
+	if err := json.NewDecoder(io.LimitReader(res.Body, maxSize)).Decode(&sm); err != nil {
+		return nil, fmt.Errorf("%s: signature: %w", name, errors.Join(ErrManifestInvalid, err))
+	}
+
+	if len(sm.Layers) == 0 {
+		return nil, nil
+	}
+
+	if len(sm.Layers) != 1 {
+		return nil, fmt.Errorf("%s: signature: %w: want 1 layer, got %d", name, ErrManifestInvalid, len(sm.Layers))
+	}
+
+	l := sm.Layers[0]
+	res, err = r.send(ctx, "GET", fmt.Sprintf("%s://%s/v2/%s/%s/blobs/%s", scheme, n.Host(), n.Namespace(), n.Model(), l.Digest), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(res.Body, maxSize))
+	if err != nil {
+		return nil, err
+	}
+
+	if blob.DigestFromBytes(data) != l.Digest {
+		return nil, fmt.Errorf("%s: signature: digest mismatch", name)
+	}
+
+	return data, nil
+}
+
 type chunksum struct {
 	URL    string
 	Chunk  blob.Chunk
@@ -691,6 +691,91 @@ func TestPullCached(t *testing.T) {
 	check(err)
 }
 
+func TestPullVerifySignature(t *testing.T) {
+	const manifest = `{"layers":[{"size":3,"digest":"sha256:ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"}]}`
+	md := blob.DigestFromBytes(manifest)
+	sigTag := strings.Replace(md.String(), ":", "-", 1) + ".sig"
+
+	const sig = `{"signature":"..."}`
+	sigDigest := blob.DigestFromBytes(sig)
+
+	for _, signed := range []bool{true, false} {
+		t.Run(fmt.Sprint("signed=", signed), func(t *testing.T) {
+			c, ctx := newRegistryClient(t, func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Path {
+				case "/v2/library/abc/manifests/latest":
+					io.WriteString(w, manifest)
+				case "/v2/library/abc/manifests/" + sigTag:
+					if !signed {
+						w.WriteHeader(http.StatusNotFound)
+						io.WriteString(w, `{"errors":[{"code":"MANIFEST_UNKNOWN"}]}`)
+						return
+					}
+					fmt.Fprintf(w, `{"layers":[{"size":%d,"digest":"%s"}]}`, len(sig), sigDigest)
+				case "/v2/library/abc/blobs/" + sigDigest.String():
+					io.WriteString(w, sig)
+				default:
+					t.Errorf("unexpected request: %v", r.URL)
+					http.NotFound(w, r)
+				}
+			})
+
+			errRejected := errors.New("rejected")
+
+			var got []byte
+			c.VerifySignature = func(name string, d blob.Digest, signature []byte) error {
+				if name != "o.com/library/abc:latest" || d != md {
+					t.Errorf("VerifySignature(%q, %v); want %q, %v", name, d, "o.com/library/abc:latest", md)
+				}
+				got = signature
+				return errRejected
+			}
+
+			err := c.Pull(ctx, "http://o.com/library/abc")
+			if !errors.Is(err, errRejected) {
+				t.Fatalf("err = %v; want %v", err, errRejected)
+			}
+
+			if want := map[bool]string{true: sig}[signed]; string(got) != want {
+				t.Errorf("signature = %q; want %q", got, want)
+			}
+
+			if _, err := c.Cache.Resolve("o.com/library/abc:latest"); err == nil {
+				t.Error("rejected model was linked")
+			}
+		})
+	}
+}
+
+func TestPullSignatureNotRequired(t *testing.T) {
+	c, ctx := newRegistryClient(t, func(w http.ResponseWriter, r *http.Request) {
+		checkRequest(t, r, "GET", "/v2/library/abc/manifests/latest")
+		io.WriteString(w, `{"layers":[{"size":3,"digest":"sha256:ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"}]}`)
+	})
+
+	check := testutil.Checker(t)
+
+	d, err := blob.ParseDigest("sha256:ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad")
+	check(err)
+	err = blob.PutBytes(c.Cache, d, []byte("abc"))
+	check(err)
+
+	c.RequiresSignature = func(name string) bool {
+		if name != "o.com/library/abc:latest" {
+			t.Errorf("RequiresSignature(%q); want %q", name, "o.com/library/abc:latest")
+		}
+		return false
+	}
+	c.VerifySignature = func(string, blob.Digest, []byte) error {
+		t.Error("VerifySignature called for a model that doesn't require a signature")
+		return nil
+	}
+
+	// the signature isn't requested, which the handler would report
+	err = c.Pull(ctx, "http://o.com/library/abc")
+	check(err)
+}
+
 func TestPullManifestError(t *testing.T) {
 	c, ctx := newRegistryClient(t, func(w http.ResponseWriter, r *http.Request) {
 		checkRequest(t, r, "GET", "/v2/library/abc/manifests/latest")
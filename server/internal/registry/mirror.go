@@ -0,0 +1,187 @@
+package registry
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"sync"
+
+	"github.com/ollama/ollama/server/internal/cache/blob"
+	"github.com/ollama/ollama/server/internal/client/ollama"
+)
+
+// Mirror implements an http.Handler that serves the models in a local cache
+// using the same registry API that clients pull models with, so that a cache
+// can be pulled from by machines that cannot reach the upstream registry.
+//
+// The handled endpoints are:
+//
+//	GET /v2/<namespace>/<model>/manifests/<tag>
+//	GET /v2/<namespace>/<model>/blobs/<digest>
+//	GET /v2/<namespace>/<model>/chunksums/<digest>
+//
+// Manifests are looked up in the cache under Host, so a request for
+// /v2/library/smol/manifests/latest serves the manifest for
+// <Host>/library/smol:latest.
+type Mirror struct {
+	Cache  *blob.DiskCache // required
+	Logger *slog.Logger    // required
+
+	// Host is the registry host that models are looked up under in the
+	// cache, such as "registry.ollama.ai".
+	Host string // required
+
+	// Upstream, if set, is used to pull models that are not in the cache
+	// from Host before serving them. Upstream must use the same cache.
+	Upstream *ollama.Registry
+
+	// Scheme is the scheme used to pull from Host. If empty, "https" is
+	// used.
+	Scheme string
+
+	initOnce sync.Once
+	mux      *http.ServeMux
+}
+
+func (m *Mirror) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.initOnce.Do(func() {
+		m.mux = http.NewServeMux()
+		m.mux.HandleFunc("GET /v2/{$}", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte("{}"))
+		})
+		m.mux.Handle("GET /v2/{namespace}/{model}/manifests/{tag}", m.handler(m.handleManifest))
+		m.mux.Handle("GET /v2/{namespace}/{model}/blobs/{digest}", m.handler(m.handleBlob))
+		m.mux.Handle("GET /v2/{namespace}/{model}/chunksums/{digest}", m.handler(m.handleChunksums))
+	})
+
+	rec := &statusCodeRecorder{ResponseWriter: w}
+	m.mux.ServeHTTP(rec, r)
+
+	var level slog.Level
+	if rec.status() >= 500 {
+		level = slog.LevelError
+	} else if rec.status() >= 400 {
+		level = slog.LevelWarn
+	}
+
+	m.Logger.LogAttrs(r.Context(), level, "http",
+		slog.Int("status", rec.status()),
+		slog.String("method", r.Method),
+		slog.String("path", r.URL.Path),
+		slog.String("remote", r.RemoteAddr),
+	)
+}
+
+// handler converts errors returned by h into JSON error responses
+func (m *Mirror) handler(h func(http.ResponseWriter, *http.Request) error) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		err := h(w, r)
+		if err == nil {
+			return
+		}
+
+		var e *serverError
+		var upstreamErr *ollama.Error
+		switch {
+		case errors.As(err, &e):
+		case errors.As(err, &upstreamErr):
+			e = &serverError{502, "bad_gateway", err.Error()}
+		case errors.Is(err, fs.ErrNotExist), errors.Is(err, ollama.ErrModelNotFound):
+			e = errModelNotFound
+		case errors.Is(err, ollama.ErrNameInvalid), errors.Is(err, blob.ErrInvalidDigest):
+			e = &serverError{400, "bad_request", err.Error()}
+		default:
+			m.Logger.Error("mirror", "path", r.URL.Path, "error", err)
+			e = errInternalError
+		}
+
+		writeError(w, e)
+	})
+}
+
+func (m *Mirror) name(r *http.Request) string {
+	return fmt.Sprintf("%s/%s/%s:%s", m.Host, r.PathValue("namespace"), r.PathValue("model"), r.PathValue("tag"))
+}
+
+func (m *Mirror) handleManifest(w http.ResponseWriter, r *http.Request) error {
+	name := m.name(r)
+
+	d, err := m.Cache.Resolve(name)
+	if errors.Is(err, fs.ErrNotExist) && m.Upstream != nil {
+		m.Logger.Info("pulling from upstream", "model", name)
+		if err := m.Upstream.Pull(r.Context(), cmp.Or(m.Scheme, "https")+"://"+name); err != nil {
+			return err
+		}
+
+		d, err = m.Cache.Resolve(name)
+	}
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Content-Type", "application/vnd.docker.distribution.manifest.v2+json")
+	w.Header().Set("Docker-Content-Digest", d.String())
+	return m.serveFile(w, r, d)
+}
+
+func (m *Mirror) handleBlob(w http.ResponseWriter, r *http.Request) error {
+	d, err := blob.ParseDigest(r.PathValue("digest"))
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Docker-Content-Digest", d.String())
+	return m.serveFile(w, r, d)
+}
+
+// handleChunksums serves each blob as a single chunk. Mirrors are expected
+// to be on a fast local network, so there is little to gain from splitting
+// blobs into verifiable chunks.
+func (m *Mirror) handleChunksums(w http.ResponseWriter, r *http.Request) error {
+	d, err := blob.ParseDigest(r.PathValue("digest"))
+	if err != nil {
+		return err
+	}
+
+	e, err := m.Cache.Get(d)
+	if err != nil {
+		return err
+	}
+
+	if e.Size == 0 {
+		return errNotFound
+	}
+
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+
+	w.Header().Set("Content-Location", fmt.Sprintf("%s://%s/v2/%s/%s/blobs/%s", scheme, r.Host, r.PathValue("namespace"), r.PathValue("model"), d))
+	w.Header().Set("Content-Type", "text/plain")
+	_, err = fmt.Fprintf(w, "%s 0-%d\n", d, e.Size-1)
+	return err
+}
+
+// serveFile serves the blob d, supporting HEAD and range requests
+func (m *Mirror) serveFile(w http.ResponseWriter, r *http.Request, d blob.Digest) error {
+	f, err := os.Open(m.Cache.GetFile(d))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	http.ServeContent(w, r, "", info.ModTime(), f)
+	return nil
+}
@@ -0,0 +1,159 @@
+package registry
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/ollama/ollama/server/internal/cache/blob"
+	"github.com/ollama/ollama/server/internal/client/ollama"
+	"github.com/ollama/ollama/server/internal/testutil"
+)
+
+// newTestMirror returns a mirror of a cache containing the model
+// <host>/library/smol:latest and the manifest of the model
+func newTestMirror(t *testing.T, host string) (*Mirror, string) {
+	t.Helper()
+	c, err := blob.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := blob.DigestFromBytes("{}")
+	layer := blob.DigestFromBytes(smolData)
+	for d, data := range map[blob.Digest]string{config: "{}", layer: smolData} {
+		if err := blob.PutBytes(c, d, data); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	manifest := fmt.Sprintf(`{"schemaVersion":2,"mediaType":"application/vnd.docker.distribution.manifest.v2+json","config":{"mediaType":"application/vnd.docker.container.image.v1+json","digest":"%s","size":2},"layers":[{"mediaType":"application/vnd.ollama.image.model","digest":"%s","size":%d}]}`, config, layer, len(smolData))
+	md := blob.DigestFromBytes(manifest)
+	if err := blob.PutBytes(c, md, manifest); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Link(host+"/library/smol:latest", md); err != nil {
+		t.Fatal(err)
+	}
+
+	return &Mirror{Cache: c, Logger: testutil.Slogger(t), Host: host}, manifest
+}
+
+const smolData = "this is a very small model"
+
+var smolLayer = blob.DigestFromBytes(smolData).String()
+
+func get(t *testing.T, method, url string, header http.Header) (*http.Response, string) {
+	t.Helper()
+	req, err := http.NewRequestWithContext(t.Context(), method, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res, string(body)
+}
+
+func TestMirror(t *testing.T) {
+	m, want := newTestMirror(t, "example.com")
+	s := httptest.NewServer(m)
+	t.Cleanup(s.Close)
+
+	res, body := get(t, "GET", s.URL+"/v2/library/smol/manifests/latest", nil)
+	if res.StatusCode != 200 || body != want {
+		t.Fatalf("manifest: status = %d, body = %q; want 200, %q", res.StatusCode, body, want)
+	}
+
+	res, _ = get(t, "HEAD", s.URL+"/v2/library/smol/blobs/"+smolLayer, nil)
+	if res.StatusCode != 200 || res.ContentLength != int64(len(smolData)) {
+		t.Errorf("HEAD blob: status = %d, length = %d; want 200, %d", res.StatusCode, res.ContentLength, len(smolData))
+	}
+
+	res, body = get(t, "GET", s.URL+"/v2/library/smol/blobs/"+smolLayer, http.Header{"Range": {"bytes=0-3"}})
+	if res.StatusCode != http.StatusPartialContent || len(body) != 4 {
+		t.Errorf("ranged blob: status = %d, body = %q; want 206 and 4 bytes", res.StatusCode, body)
+	}
+
+	res, body = get(t, "GET", s.URL+"/v2/library/smol/chunksums/"+smolLayer, nil)
+	if res.StatusCode != 200 || body != fmt.Sprintf("%s 0-%d\n", smolLayer, len(smolData)-1) {
+		t.Errorf("chunksums: status = %d, body = %q", res.StatusCode, body)
+	}
+	if got, want := res.Header.Get("Content-Location"), s.URL+"/v2/library/smol/blobs/"+smolLayer; got != want {
+		t.Errorf("chunksums Content-Location = %q; want %q", got, want)
+	}
+
+	res, body = get(t, "GET", s.URL+"/v2/library/missing/manifests/latest", nil)
+	if res.StatusCode != 404 || !strings.Contains(body, "model not found") {
+		t.Errorf("missing manifest: status = %d, body = %q; want 404", res.StatusCode, body)
+	}
+
+	res, _ = get(t, "GET", s.URL+"/v2/library/smol/blobs/sha256:bad", nil)
+	if res.StatusCode != 400 {
+		t.Errorf("bad digest: status = %d; want 400", res.StatusCode)
+	}
+}
+
+func TestMirrorPullThrough(t *testing.T) {
+	// the upstream host is only known once it is listening
+	us := httptest.NewServer(nil)
+	t.Cleanup(us.Close)
+
+	u, err := url.Parse(us.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	upstream, _ := newTestMirror(t, u.Host)
+	us.Config.Handler = upstream
+
+	c, err := blob.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := &Mirror{
+		Cache:    c,
+		Logger:   testutil.Slogger(t),
+		Host:     u.Host,
+		Upstream: &ollama.Registry{Cache: c},
+		Scheme:   "http",
+	}
+	s := httptest.NewServer(m)
+	t.Cleanup(s.Close)
+
+	// pull from the mirror with the registry client to check that the
+	// mirror is compatible with it
+	dst, err := blob.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rc := &ollama.Registry{Cache: dst, ChunkingThreshold: 1}
+	if err := rc.Pull(t.Context(), s.URL+"/library/smol:latest"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []*blob.DiskCache{c, dst} {
+		d, err := blob.ParseDigest(smolLayer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := c.Get(d); err != nil {
+			t.Errorf("layer not cached: %v", err)
+		}
+	}
+}
@@ -106,6 +106,17 @@ func (r *statusCodeRecorder) status() int {
 	return cmp.Or(r._status, 200)
 }
 
+func writeError(w http.ResponseWriter, e *serverError) {
+	data, err := json.Marshal(e)
+	if err != nil {
+		// unreachable
+		panic(err)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(e.Status)
+	w.Write(data)
+}
+
 func (s *Local) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	rec := &statusCodeRecorder{ResponseWriter: w}
 	s.serveHTTP(rec, r)
@@ -140,14 +151,7 @@ func (s *Local) serveHTTP(rec *statusCodeRecorder, r *http.Request) {
 			e = errInternalError
 		}
 
-		data, err := json.Marshal(e)
-		if err != nil {
-			// unreachable
-			panic(err)
-		}
-		rec.Header().Set("Content-Type", "application/json")
-		rec.WriteHeader(e.Status)
-		rec.Write(data)
+		writeError(rec, e)
 
 		// fallthrough to log
 	}
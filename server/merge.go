@@ -0,0 +1,306 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"unsafe"
+
+	"github.com/ollama/ollama/api"
+	fsggml "github.com/ollama/ollama/fs/ggml"
+	"github.com/ollama/ollama/ml/backend/ggml"
+)
+
+var errNoAdaptersToMerge = errors.New("no adapters to merge")
+
+// loraWeights are the low rank matrices an adapter adds to a base tensor.
+// The base tensor is updated by scale * b x a.
+type loraWeights struct {
+	a, b  []float32
+	rank  uint64
+	scale float32
+}
+
+// mergedTensor writes a base tensor with the deltas of adapters added.
+type mergedTensor struct {
+	*os.File
+	offset     uint64
+	from, to   *fsggml.Tensor
+	loras      []loraWeights
+	progressFn func(n uint64)
+}
+
+func (m mergedTensor) WriteTo(w io.Writer) (int64, error) {
+	data := make([]byte, m.from.Size())
+	if _, err := m.ReadAt(data, int64(m.offset)); err != nil {
+		return 0, fmt.Errorf("unable to read tensor %s from %s: %w", m.from.Name, m.Name(), err)
+	}
+
+	weights := tensorF32(data, m.from)
+	for _, l := range m.loras {
+		nIn, nOut := m.from.Shape[0], m.from.Shape[1]
+		for o := range nOut {
+			row := weights[o*nIn : (o+1)*nIn]
+			for r := range l.rank {
+				br := l.scale * l.b[o*l.rank+r]
+				if br == 0 {
+					continue
+				}
+
+				for i, a := range l.a[r*nIn : (r+1)*nIn] {
+					row[i] += br * a
+				}
+			}
+		}
+	}
+
+	n, err := w.Write(ggml.Quantize(fsggml.TensorType(m.to.Kind), weights, m.to.Shape, nil))
+	m.progressFn(m.from.Size())
+	return int64(n), err
+}
+
+// tensorF32 converts the data of t to float32
+func tensorF32(data []byte, t *fsggml.Tensor) []float32 {
+	if fsggml.TensorType(t.Kind) == fsggml.TensorTypeF32 {
+		f32s := make([]float32, t.Elements())
+		copy(f32s, unsafe.Slice((*float32)(unsafe.Pointer(&data[0])), t.Elements()))
+		return f32s
+	}
+
+	return ggml.ConvertToF32(data, t.Kind, t.Elements())
+}
+
+// canMerge reports whether adapters can be merged into tensors of kind
+func canMerge(kind fsggml.TensorType) bool {
+	switch kind {
+	case fsggml.TensorTypeF32, fsggml.TensorTypeF16, fsggml.TensorTypeBF16,
+		fsggml.TensorTypeQ4_0, fsggml.TensorTypeQ4_1, fsggml.TensorTypeQ5_0, fsggml.TensorTypeQ5_1, fsggml.TensorTypeQ8_0,
+		fsggml.TensorTypeQ2_K, fsggml.TensorTypeQ3_K, fsggml.TensorTypeQ4_K, fsggml.TensorTypeQ5_K, fsggml.TensorTypeQ6_K:
+		return true
+	default:
+		return false
+	}
+}
+
+// readLoraWeights reads the tensors of the LoRA adapter layer and returns
+// them by the name of the base tensor they apply to
+func readLoraWeights(layer *layerGGML) (map[string]loraWeights, error) {
+	blob, err := GetBlobsPath(layer.Digest)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(blob)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	kv := layer.KV()
+	alpha, _ := kv["adapter.lora.alpha"].(float32)
+
+	tensors := make(map[string]*fsggml.Tensor)
+	for _, t := range layer.Tensors().Items() {
+		tensors[t.Name] = t
+	}
+
+	read := func(t *fsggml.Tensor) ([]float32, error) {
+		if !canMerge(fsggml.TensorType(t.Kind)) {
+			return nil, fmt.Errorf("unsupported type %s for adapter tensor %s", fsggml.TensorType(t.Kind), t.Name)
+		}
+
+		data := make([]byte, t.Size())
+		if _, err := f.ReadAt(data, int64(layer.Tensors().Offset+t.Offset)); err != nil {
+			return nil, err
+		}
+
+		return tensorF32(data, t), nil
+	}
+
+	loras := make(map[string]loraWeights)
+	for name, a := range tensors {
+		name, ok := strings.CutSuffix(name, ".lora_a")
+		if !ok {
+			continue
+		}
+
+		b, ok := tensors[name+".lora_b"]
+		if !ok {
+			return nil, fmt.Errorf("adapter is missing tensor %s.lora_b", name)
+		}
+
+		if len(a.Shape) != 2 || len(b.Shape) != 2 || a.Shape[1] != b.Shape[0] {
+			return nil, fmt.Errorf("adapter tensors for %s have mismatched shapes %v and %v", name, a.Shape, b.Shape)
+		}
+
+		l := loraWeights{rank: a.Shape[1], scale: 1}
+		if alpha != 0 {
+			l.scale = alpha / float32(l.rank)
+		}
+
+		if l.a, err = read(a); err != nil {
+			return nil, err
+		}
+
+		if l.b, err = read(b); err != nil {
+			return nil, err
+		}
+
+		loras[name] = l
+	}
+
+	return loras, nil
+}
+
+// mergeAdapters folds the LoRA adapters in layers into the weights of the
+// model layer and returns the layers with the adapters removed. Merged
+// tensors keep their type, so quantized tensors are quantized again. If
+// dequantize is set, all quantized tensors are written as F16 instead and the
+// model's file type is changed to match, so it can be quantized afterwards.
+func mergeAdapters(layers []*layerGGML, dequantize bool, fn func(resp api.ProgressResponse)) ([]*layerGGML, error) {
+	var base *layerGGML
+	var adapters []*layerGGML
+	var rest []*layerGGML
+	for _, layer := range layers {
+		switch {
+		case layer.MediaType == "application/vnd.ollama.image.adapter":
+			adapters = append(adapters, layer)
+		case base == nil && layer.MediaType == "application/vnd.ollama.image.model" && layer.GGML != nil:
+			base = layer
+			rest = append(rest, nil)
+		default:
+			rest = append(rest, layer)
+		}
+	}
+
+	if len(adapters) == 0 {
+		return nil, errNoAdaptersToMerge
+	} else if base == nil {
+		return nil, errors.New("no base model to merge adapters into")
+	}
+
+	loras := make(map[string][]loraWeights)
+	for _, adapter := range adapters {
+		ls, err := readLoraWeights(adapter)
+		if err != nil {
+			return nil, err
+		}
+
+		for name, l := range ls {
+			loras[name] = append(loras[name], l)
+		}
+	}
+
+	blob, err := GetBlobsPath(base.Digest)
+	if err != nil {
+		return nil, err
+	}
+
+	in, err := os.Open(blob)
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+
+	var done atomic.Uint64
+	var total uint64
+	progressFn := func(n uint64) {
+		fn(api.ProgressResponse{Status: "merging adapter", Digest: base.Digest, Total: int64(total), Completed: int64(done.Add(n))})
+	}
+
+	kv := maps.Clone(base.KV())
+
+	var tensors []*fsggml.Tensor
+	for _, t := range base.Tensors().Items() {
+		ls, ok := loras[t.Name]
+		if !ok && !(dequantize && fsggml.TensorType(t.Kind).IsQuantized()) {
+			tensors = append(tensors, &fsggml.Tensor{
+				Name:     t.Name,
+				Kind:     t.Kind,
+				Shape:    t.Shape,
+				WriterTo: quantizer{File: in, offset: base.Tensors().Offset + t.Offset, from: t, to: t, progressFn: func(uint64) {}},
+			})
+			continue
+		}
+		delete(loras, t.Name)
+
+		kind := fsggml.TensorType(t.Kind)
+		if !canMerge(kind) {
+			if !ok {
+				return nil, fmt.Errorf("converting %s tensor %s to F16 is not supported", kind, t.Name)
+			}
+			return nil, fmt.Errorf("merging adapters into %s tensor %s is not supported", kind, t.Name)
+		}
+
+		for _, l := range ls {
+			if len(t.Shape) != 2 || uint64(len(l.a)) != l.rank*t.Shape[0] || uint64(len(l.b)) != l.rank*t.Shape[1] {
+				return nil, fmt.Errorf("adapter doesn't match the shape %v of tensor %s", t.Shape, t.Name)
+			}
+		}
+
+		if dequantize && kind.IsQuantized() {
+			kind = fsggml.TensorTypeF16
+			kv["general.file_type"] = fsggml.FileTypeF16
+		}
+
+		to := &fsggml.Tensor{Name: t.Name, Kind: uint32(kind), Shape: t.Shape}
+		to.WriterTo = mergedTensor{
+			File:       in,
+			offset:     base.Tensors().Offset + t.Offset,
+			from:       t,
+			to:         to,
+			loras:      ls,
+			progressFn: progressFn,
+		}
+		tensors = append(tensors, to)
+		total += t.Size()
+	}
+
+	if len(loras) > 0 {
+		names := slices.Sorted(maps.Keys(loras))
+		return nil, fmt.Errorf("adapter tensor %s doesn't match a tensor of the model", names[0])
+	}
+
+	temp, err := os.CreateTemp(filepath.Dir(blob), "merge")
+	if err != nil {
+		return nil, err
+	}
+	defer temp.Close()
+	defer os.Remove(temp.Name())
+
+	if err := fsggml.WriteGGUF(temp, kv, tensors); err != nil {
+		return nil, err
+	}
+
+	if _, err := temp.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	layer, err := NewLayer(temp, base.MediaType)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := temp.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	f, err := fsggml.Decode(temp, -1)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range rest {
+		if rest[i] == nil {
+			rest[i] = &layerGGML{layer, f}
+		}
+	}
+
+	return rest, nil
+}
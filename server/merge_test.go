@@ -0,0 +1,243 @@
+package server
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"os"
+	"slices"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/ollama/ollama/api"
+	fsggml "github.com/ollama/ollama/fs/ggml"
+	"github.com/ollama/ollama/ml/backend/ggml"
+)
+
+func f32Tensor(t *testing.T, name string, shape []uint64, values ...float32) *fsggml.Tensor {
+	t.Helper()
+
+	var b bytes.Buffer
+	if err := binary.Write(&b, binary.LittleEndian, values); err != nil {
+		t.Fatal(err)
+	}
+
+	return &fsggml.Tensor{Name: name, Kind: uint32(fsggml.TensorTypeF32), Shape: shape, WriterTo: &b}
+}
+
+func q8Tensor(t *testing.T, name string, shape []uint64, values ...float32) *fsggml.Tensor {
+	t.Helper()
+
+	data := ggml.Quantize(fsggml.TensorTypeQ8_0, values, shape, nil)
+	return &fsggml.Tensor{Name: name, Kind: uint32(fsggml.TensorTypeQ8_0), Shape: shape, WriterTo: bytes.NewReader(data)}
+}
+
+func readF32Tensors(t *testing.T, layer *layerGGML) map[string][]float32 {
+	t.Helper()
+
+	blob, err := GetBlobsPath(layer.Digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(blob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	tensors := make(map[string][]float32)
+	for _, tensor := range layer.Tensors().Items() {
+		if fsggml.TensorType(tensor.Kind) != fsggml.TensorTypeF32 {
+			t.Fatalf("tensor %s has type %s, expected F32", tensor.Name, fsggml.TensorType(tensor.Kind))
+		}
+
+		values := make([]float32, tensor.Elements())
+		sr := io.NewSectionReader(f, int64(layer.Tensors().Offset+tensor.Offset), int64(tensor.Size()))
+		if err := binary.Read(sr, binary.LittleEndian, values); err != nil {
+			t.Fatal(err)
+		}
+		tensors[tensor.Name] = values
+	}
+
+	return tensors
+}
+
+func TestMergeAdapters(t *testing.T) {
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+	layers := func(t *testing.T, kv map[string]any, tensors ...*fsggml.Tensor) []*layerGGML {
+		t.Helper()
+
+		_, digest := createBinFile(t, kv, tensors)
+		layers, err := ggufLayers(digest, func(api.ProgressResponse) {})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return layers
+	}
+
+	base := func(t *testing.T) []*layerGGML {
+		return layers(t, nil,
+			f32Tensor(t, "blk.0.attn_q.weight", []uint64{4, 2}, 1, 2, 3, 4, 5, 6, 7, 8),
+			f32Tensor(t, "output.weight", []uint64{4, 1}, 1, 1, 1, 1),
+		)
+	}
+
+	t.Run("merge", func(t *testing.T) {
+		adapter := layers(t, map[string]any{"general.type": "adapter", "adapter.lora.alpha": float32(2)},
+			f32Tensor(t, "blk.0.attn_q.weight.lora_a", []uint64{4, 1}, 1, 0, 1, 0),
+			f32Tensor(t, "blk.0.attn_q.weight.lora_b", []uint64{1, 2}, 1, 0.5),
+		)
+
+		merged, err := mergeAdapters(slices.Concat(base(t), adapter), false, func(api.ProgressResponse) {})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(merged) != 1 {
+			t.Fatalf("expected 1 layer, got %d", len(merged))
+		}
+
+		if merged[0].MediaType != "application/vnd.ollama.image.model" {
+			t.Errorf("unexpected media type %s", merged[0].MediaType)
+		}
+
+		if diff := cmp.Diff(map[string][]float32{
+			"blk.0.attn_q.weight": {3, 2, 5, 4, 6, 6, 8, 8},
+			"output.weight":       {1, 1, 1, 1},
+		}, readF32Tensors(t, merged[0])); diff != "" {
+			t.Errorf("mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("no adapters", func(t *testing.T) {
+		if _, err := mergeAdapters(base(t), false, func(api.ProgressResponse) {}); !errors.Is(err, errNoAdaptersToMerge) {
+			t.Errorf("expected %v, got %v", errNoAdaptersToMerge, err)
+		}
+	})
+
+	t.Run("unknown tensor", func(t *testing.T) {
+		adapter := layers(t, map[string]any{"general.type": "adapter"},
+			f32Tensor(t, "blk.1.attn_q.weight.lora_a", []uint64{4, 1}, 1, 0, 1, 0),
+			f32Tensor(t, "blk.1.attn_q.weight.lora_b", []uint64{1, 2}, 1, 0.5),
+		)
+
+		if _, err := mergeAdapters(slices.Concat(base(t), adapter), false, func(api.ProgressResponse) {}); err == nil {
+			t.Error("expected error")
+		}
+	})
+
+	t.Run("mismatched shape", func(t *testing.T) {
+		adapter := layers(t, map[string]any{"general.type": "adapter"},
+			f32Tensor(t, "blk.0.attn_q.weight.lora_a", []uint64{2, 1}, 1, 0),
+			f32Tensor(t, "blk.0.attn_q.weight.lora_b", []uint64{1, 2}, 1, 0.5),
+		)
+
+		if _, err := mergeAdapters(slices.Concat(base(t), adapter), false, func(api.ProgressResponse) {}); err == nil {
+			t.Error("expected error")
+		}
+	})
+
+	t.Run("quantized", func(t *testing.T) {
+		values := make([]float32, 64)
+		for i := range values {
+			values[i] = float32(i%8) / 8
+		}
+
+		quantized := func(t *testing.T) []*layerGGML {
+			return layers(t, map[string]any{"general.file_type": uint32(fsggml.FileTypeQ8_0)},
+				q8Tensor(t, "blk.0.attn_q.weight", []uint64{32, 2}, values...),
+				q8Tensor(t, "output.weight", []uint64{32, 2}, values...),
+			)
+		}
+
+		adapter := func(t *testing.T) []*layerGGML {
+			a := make([]float32, 32)
+			a[0] = 1
+			return layers(t, map[string]any{"general.type": "adapter"},
+				f32Tensor(t, "blk.0.attn_q.weight.lora_a", []uint64{32, 1}, a...),
+				f32Tensor(t, "blk.0.attn_q.weight.lora_b", []uint64{1, 2}, 1, 1),
+			)
+		}
+
+		cases := []struct {
+			name       string
+			dequantize bool
+			fileType   fsggml.FileType
+			kinds      map[string]fsggml.TensorType
+		}{
+			{
+				name:     "keep type",
+				fileType: fsggml.FileTypeQ8_0,
+				kinds: map[string]fsggml.TensorType{
+					"blk.0.attn_q.weight": fsggml.TensorTypeQ8_0,
+					"output.weight":       fsggml.TensorTypeQ8_0,
+				},
+			},
+			{
+				name:       "dequantize",
+				dequantize: true,
+				fileType:   fsggml.FileTypeF16,
+				kinds: map[string]fsggml.TensorType{
+					"blk.0.attn_q.weight": fsggml.TensorTypeF16,
+					"output.weight":       fsggml.TensorTypeF16,
+				},
+			},
+		}
+
+		for _, tt := range cases {
+			t.Run(tt.name, func(t *testing.T) {
+				merged, err := mergeAdapters(slices.Concat(quantized(t), adapter(t)), tt.dequantize, func(api.ProgressResponse) {})
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if ft := merged[0].KV().FileType(); ft != tt.fileType {
+					t.Errorf("expected file type %s, got %s", tt.fileType, ft)
+				}
+
+				blob, err := GetBlobsPath(merged[0].Digest)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				f, err := os.Open(blob)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer f.Close()
+
+				kinds := make(map[string]fsggml.TensorType)
+				for _, tensor := range merged[0].Tensors().Items() {
+					kinds[tensor.Name] = fsggml.TensorType(tensor.Kind)
+
+					data := make([]byte, tensor.Size())
+					if _, err := f.ReadAt(data, int64(merged[0].Tensors().Offset+tensor.Offset)); err != nil {
+						t.Fatal(err)
+					}
+
+					// only the first input of each row is changed by the adapter
+					got := ggml.ConvertToF32(data, tensor.Kind, tensor.Elements())
+					for i, v := range got {
+						want := values[i]
+						if tensor.Name == "blk.0.attn_q.weight" && i%32 == 0 {
+							want++
+						}
+
+						if math.Abs(float64(v-want)) > 0.01 {
+							t.Errorf("%s[%d]: expected %v, got %v", tensor.Name, i, want, v)
+						}
+					}
+				}
+
+				if diff := cmp.Diff(tt.kinds, kinds); diff != "" {
+					t.Errorf("mismatch (-want +got):\n%s", diff)
+				}
+			})
+		}
+	})
+}
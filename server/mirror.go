@@ -0,0 +1,78 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/ollama/ollama/envconfig"
+	"github.com/ollama/ollama/logutil"
+	"github.com/ollama/ollama/server/internal/cache/blob"
+	"github.com/ollama/ollama/server/internal/client/ollama"
+	"github.com/ollama/ollama/server/internal/registry"
+)
+
+// ServeRegistry serves the models in the models directory on ln with the
+// registry API used by pull, so that other machines can pull them from
+// this one. Models are served from the default registry's namespace.
+//
+// If upstream is set, models are served from the namespace of the upstream
+// registry instead, and models that are not in the models directory are
+// pulled from upstream and cached before they are served.
+func ServeRegistry(ln net.Listener, upstream string) error {
+	slog.SetDefault(logutil.NewLogger(os.Stderr, envconfig.LogLevel()))
+
+	c, err := blob.Open(envconfig.Models())
+	if err != nil {
+		return err
+	}
+
+	m := &registry.Mirror{
+		Cache:  c,
+		Logger: slog.Default(),
+		Host:   DefaultRegistry,
+	}
+
+	if upstream != "" {
+		u, err := url.Parse(upstream)
+		if err != nil {
+			return err
+		}
+
+		if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+			return fmt.Errorf("invalid upstream %q: must be an http or https URL", upstream)
+		}
+
+		rc, err := ollama.DefaultRegistry()
+		if err != nil {
+			return err
+		}
+		rc.Cache = c
+
+		m.Host = u.Host
+		m.Scheme = u.Scheme
+		m.Upstream = rc
+	}
+
+	srvr := &http.Server{Handler: m}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-signals
+		srvr.Close()
+	}()
+
+	slog.Info(fmt.Sprintf("Serving registry on %s", ln.Addr()), "models", envconfig.Models(), "upstream", upstream)
+	if err := srvr.Serve(ln); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	return nil
+}
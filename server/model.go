@@ -40,7 +40,8 @@ func parseFromModel(ctx context.Context, name model.Name, fn func(api.ProgressRe
 		return nil, err
 	}
 
-	for _, layer := range m.Layers {
+	// signatures only apply to the model they were pulled with
+	for _, layer := range withoutSignatures(m.Layers) {
 		layer, err := NewLayerFromLayer(layer.Digest, layer.MediaType, name.DisplayShortest())
 		if err != nil {
 			return nil, err
@@ -21,8 +21,60 @@ type tokenizeFunc func(context.Context, string) ([]int, error)
 // chatPrompt truncates any messages that exceed the context window of the model, making sure to always include 1) the
 // latest message and 2) system messages
 func chatPrompt(ctx context.Context, m *Model, tokenize tokenizeFunc, opts *api.Options, msgs []api.Message, tools []api.Tool, think *api.ThinkValue, truncate bool) (prompt string, images []llm.ImageData, _ error) {
-	var system []api.Message
+	n, err := fitMessages(ctx, m, tokenize, opts.NumCtx, msgs, tools, think, truncate)
+	if err != nil {
+		return "", nil, err
+	}
+
+	system := make([]api.Message, 0)
+	for _, msg := range msgs[:n] {
+		if msg.Role == "system" {
+			system = append(system, msg)
+		}
+	}
+
+	currMsgIdx := n
+
+	for cnt, msg := range msgs[currMsgIdx:] {
+		if slices.Contains(m.Config.ModelFamilies, "mllama") && len(msg.Images) > 1 {
+			return "", nil, errors.New("this model only supports one image while more than one image requested")
+		}
+
+		var prefix string
+		prompt := msg.Content
+
+		for _, i := range msg.Images {
+			imgData := llm.ImageData{
+				ID:   len(images),
+				Data: i,
+			}
+
+			imgTag := fmt.Sprintf("[img-%d]", imgData.ID)
+			if !strings.Contains(prompt, "[img]") {
+				prefix += imgTag
+			} else {
+				prompt = strings.Replace(prompt, "[img]", imgTag, 1)
+			}
+
+			images = append(images, imgData)
+		}
+		msgs[currMsgIdx+cnt].Content = prefix + prompt
+	}
+
+	// truncate any messages that do not fit into the context window
+	p, err := renderPrompt(m, append(system, msgs[currMsgIdx:]...), tools, think)
+	if err != nil {
+		return "", nil, err
+	}
 
+	return p, images, nil
+}
+
+// fitMessages returns the index of the first message of msgs to include in a
+// prompt of at most numCtx tokens, along with the system messages before it.
+// The last message is always included, and nothing is left out unless
+// truncate is set.
+func fitMessages(ctx context.Context, m *Model, tokenize tokenizeFunc, numCtx int, msgs []api.Message, tools []api.Tool, think *api.ThinkValue, truncate bool) (int, error) {
 	// TODO: Ideally we would compute this from the projector metadata but some pieces are implementation dependent
 	// Clip images are represented as 768 tokens, each an embedding
 	imageNumTokens := 768
@@ -35,7 +87,7 @@ func chatPrompt(ctx context.Context, m *Model, tokenize tokenizeFunc, opts *api.
 			continue
 		}
 
-		system = make([]api.Message, 0)
+		system := make([]api.Message, 0)
 		for j := range i {
 			if msgs[j].Role == "system" {
 				system = append(system, msgs[j])
@@ -44,12 +96,12 @@ func chatPrompt(ctx context.Context, m *Model, tokenize tokenizeFunc, opts *api.
 
 		p, err := renderPrompt(m, append(system, msgs[i:]...), tools, think)
 		if err != nil {
-			return "", nil, err
+			return 0, err
 		}
 
 		s, err := tokenize(ctx, p)
 		if err != nil {
-			return "", nil, err
+			return 0, err
 		}
 
 		ctxLen := len(s)
@@ -59,7 +111,7 @@ func chatPrompt(ctx context.Context, m *Model, tokenize tokenizeFunc, opts *api.
 			}
 		}
 
-		if truncate && ctxLen > opts.NumCtx {
+		if truncate && ctxLen > numCtx {
 			slog.Debug("truncating input messages which exceed context length", "truncated", len(msgs[i:]))
 			break
 		} else {
@@ -67,46 +119,12 @@ func chatPrompt(ctx context.Context, m *Model, tokenize tokenizeFunc, opts *api.
 		}
 	}
 
-	currMsgIdx := n
-
-	for cnt, msg := range msgs[currMsgIdx:] {
-		if slices.Contains(m.Config.ModelFamilies, "mllama") && len(msg.Images) > 1 {
-			return "", nil, errors.New("this model only supports one image while more than one image requested")
-		}
-
-		var prefix string
-		prompt := msg.Content
-
-		for _, i := range msg.Images {
-			imgData := llm.ImageData{
-				ID:   len(images),
-				Data: i,
-			}
-
-			imgTag := fmt.Sprintf("[img-%d]", imgData.ID)
-			if !strings.Contains(prompt, "[img]") {
-				prefix += imgTag
-			} else {
-				prompt = strings.Replace(prompt, "[img]", imgTag, 1)
-			}
-
-			images = append(images, imgData)
-		}
-		msgs[currMsgIdx+cnt].Content = prefix + prompt
-	}
-
-	// truncate any messages that do not fit into the context window
-	p, err := renderPrompt(m, append(system, msgs[currMsgIdx:]...), tools, think)
-	if err != nil {
-		return "", nil, err
-	}
-
-	return p, images, nil
+	return n, nil
 }
 
 func renderPrompt(m *Model, msgs []api.Message, tools []api.Tool, think *api.ThinkValue) (string, error) {
 	if m.Config.Renderer != "" {
-		rendered, err := renderers.RenderWithRenderer(m.Config.Renderer, msgs, tools, think)
+		rendered, err := renderers.RenderForModel(m.Config.Renderer, m.ModelPath, msgs, tools, think)
 		if err != nil {
 			return "", err
 		}
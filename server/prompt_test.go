@@ -2,12 +2,15 @@ package server
 
 import (
 	"bytes"
+	"maps"
+	"slices"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
 
 	"github.com/ollama/ollama/api"
 	"github.com/ollama/ollama/template"
+	"github.com/ollama/ollama/types/model"
 )
 
 func TestChatPrompt(t *testing.T) {
@@ -264,3 +267,82 @@ func TestChatPrompt(t *testing.T) {
 		})
 	}
 }
+
+func TestRenderPromptJinja(t *testing.T) {
+	chatTemplate := "{{ bos_token }}{% for m in messages %}<{{ m.role }}>{{ m.content }}{{ eos_token }}{% endfor %}{% if tools %}{{ tools | map(attribute='function.name') | join(',') }}{% endif %}{% if add_generation_prompt %}<assistant>{% endif %}"
+
+	cases := []struct {
+		name   string
+		kv     map[string]any
+		expect string
+	}{
+		{
+			name:   "add bos",
+			kv:     map[string]any{},
+			expect: "<user>hello</s>get_weather<assistant>",
+		},
+		{
+			name:   "no bos",
+			kv:     map[string]any{"tokenizer.ggml.add_bos_token": false},
+			expect: "<s><user>hello</s>get_weather<assistant>",
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			kv := map[string]any{
+				"tokenizer.chat_template":     chatTemplate,
+				"tokenizer.ggml.tokens":       []string{"<s>", "</s>", "hello"},
+				"tokenizer.ggml.bos_token_id": uint32(0),
+				"tokenizer.ggml.eos_token_id": uint32(1),
+			}
+			maps.Copy(kv, tt.kv)
+
+			p, _ := createBinFile(t, kv, nil)
+			m := Model{ModelPath: p, Config: ConfigV2{Renderer: "jinja"}, Template: template.DefaultTemplate}
+
+			tools := []api.Tool{{Type: "function", Function: api.ToolFunction{Name: "get_weather"}}}
+			prompt, err := renderPrompt(&m, []api.Message{{Role: "user", Content: "hello"}}, tools, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if diff := cmp.Diff(prompt, tt.expect); diff != "" {
+				t.Errorf("mismatch (-got +want):\n%s", diff)
+			}
+
+			if !slices.Contains(m.Capabilities(), model.CapabilityTools) {
+				t.Error("expected tools capability")
+			}
+		})
+	}
+}
+
+func TestCapabilitiesJinja(t *testing.T) {
+	cases := []struct {
+		name         string
+		chatTemplate string
+		tools        bool
+		thinking     bool
+	}{
+		{"tools", "{% if tools %}{{ tools | tojson }}{% endif %}{% for m in messages %}{{ m.content }}{% endfor %}", true, false},
+		{"tools in text", "You can't use tools.{% for m in messages %}{{ m.tools }}{{ m.content }}{% endfor %}", false, false},
+		{"thinking", "{% for m in messages %}{{ m.content }}{% endfor %}{% if enable_thinking %}<think>{% endif %}", false, true},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			p, _ := createBinFile(t, map[string]any{"tokenizer.chat_template": tt.chatTemplate}, nil)
+			m := Model{ModelPath: p, Config: ConfigV2{Renderer: "jinja"}, Template: template.DefaultTemplate}
+
+			capabilities := m.Capabilities()
+			if got := slices.Contains(capabilities, model.CapabilityTools); got != tt.tools {
+				t.Errorf("expected tools capability %t, got %t", tt.tools, got)
+			}
+
+			if got := slices.Contains(capabilities, model.CapabilityThinking); got != tt.thinking {
+				t.Errorf("expected thinking capability %t, got %t", tt.thinking, got)
+			}
+		})
+	}
+}
@@ -1,14 +1,18 @@
 package server
 
 import (
+	"cmp"
 	"fmt"
 	"io"
 	"log/slog"
 	"maps"
 	"os"
+	"path"
+	"slices"
 	"strings"
 	"unsafe"
 
+	"github.com/ollama/ollama/api"
 	fsggml "github.com/ollama/ollama/fs/ggml"
 	"github.com/ollama/ollama/ml/backend/ggml"
 )
@@ -17,6 +21,7 @@ type quantizer struct {
 	*os.File
 	offset     uint64
 	from, to   *fsggml.Tensor
+	imatrix    []float32
 	progressFn func(n uint64)
 }
 
@@ -40,18 +45,19 @@ func (q quantizer) WriteTo(w io.Writer) (int64, error) {
 	} else {
 		f32s = ggml.ConvertToF32(data, q.from.Kind, q.from.Elements())
 	}
-	data = ggml.Quantize(newType, f32s, q.from.Shape)
+	data = ggml.Quantize(newType, f32s, q.from.Shape, q.imatrix)
 	n, err := w.Write(data)
 	q.progressFn(q.from.Size())
 	return int64(n), err
 }
 
 type quantizeState struct {
-	nAttnV    int  // Number of attn_*v* weight tensors
-	nFfnDown  int  // Number of ffn_down tensors
-	iAttnV    int  // Running counter of number of attn_v tensors that have been processed
-	iFfnDown  int  // Running counter of number of ffn_down tensors that have been processed
-	hasOutput bool // used to figure out if a model shares tok_embd with the output weight
+	nAttnV     int  // Number of attn_*v* weight tensors
+	nFfnDown   int  // Number of ffn_down tensors
+	iAttnV     int  // Running counter of number of attn_v tensors that have been processed
+	iFfnDown   int  // Running counter of number of ffn_down tensors that have been processed
+	hasOutput  bool // used to figure out if a model shares tok_embd with the output weight
+	hasImatrix bool // whether an importance matrix guides the quantization
 }
 
 func useMoreBits(iLayer, nLayers int) bool {
@@ -61,19 +67,78 @@ func useMoreBits(iLayer, nLayers int) bool {
 func getTensorNewType(kv fsggml.KV, qs *quantizeState, newType fsggml.TensorType, name string, shape []uint64, ftype fsggml.FileType) fsggml.TensorType {
 	// Ported from llama_tensor_get_type, removed unsupported quantization types
 	nExperts := max(1, kv.Uint("expert_count", 0))
+	nGQA := uint32(1)
+	if nHead, nHeadKV := kv.Uint("attention.head_count", 0), kv.Uint("attention.head_count_kv", 0); nHead > 0 && nHeadKV > 0 {
+		nGQA = nHead / nHeadKV
+	}
+
+	isIQ2 := ftype == fsggml.FileTypeIQ2_XXS || ftype == fsggml.FileTypeIQ2_XS
 	if name == "output.weight" || name == "output_norm.weight" || (!qs.hasOutput && name == "token_embd.weight") {
 		nx := shape[0]
 		qk_k := newType.BlockSize()
 		if nx%qk_k != 0 {
 			newType = fsggml.TensorTypeQ8_0
+		} else if isIQ2 || ftype == fsggml.FileTypeIQ3_XXS {
+			newType = fsggml.TensorTypeQ5_K
 		} else if newType != fsggml.TensorTypeQ8_0 {
 			newType = fsggml.TensorTypeQ6_K
 		}
+	} else if name == "token_embd.weight" || name == "per_layer_token_embd.weight" {
+		if isIQ2 {
+			newType = fsggml.TensorTypeQ2_K
+		} else if ftype == fsggml.FileTypeIQ3_XXS {
+			newType = fsggml.TensorTypeIQ3_S
+		}
+	} else if isIQ2 {
+		if strings.Contains(name, "attn_v.weight") {
+			if nGQA >= 4 || nExperts >= 4 {
+				newType = fsggml.TensorTypeQ4_K
+			} else {
+				newType = fsggml.TensorTypeQ2_K
+			}
+			qs.iAttnV++
+		} else if nExperts == 8 && strings.Contains(name, "attn_k.weight") {
+			newType = fsggml.TensorTypeQ4_K
+		} else if strings.Contains(name, "ffn_down") {
+			if qs.iFfnDown < qs.nFfnDown/8 {
+				newType = fsggml.TensorTypeQ2_K
+			}
+			qs.iFfnDown++
+		} else if strings.Contains(name, "attn_output.weight") {
+			if nExperts == 8 {
+				newType = fsggml.TensorTypeQ5_K
+			}
+		}
 	} else if strings.Contains(name, "attn_v.weight") {
-		if (ftype == fsggml.FileTypeQ4_K_M) &&
-			useMoreBits(qs.iAttnV, qs.nAttnV) {
+		switch {
+		case ftype == fsggml.FileTypeQ2_K:
+			if nGQA >= 4 {
+				newType = fsggml.TensorTypeQ4_K
+			} else {
+				newType = fsggml.TensorTypeQ3_K
+			}
+		case ftype == fsggml.FileTypeIQ3_XXS:
+			if nGQA >= 4 {
+				newType = fsggml.TensorTypeQ4_K
+			} else if !qs.hasImatrix {
+				newType = fsggml.TensorTypeIQ3_S
+			} else {
+				newType = fsggml.TensorTypeIQ3_XXS
+			}
+		case ftype == fsggml.FileTypeQ3_K_M:
+			if qs.iAttnV < 2 {
+				newType = fsggml.TensorTypeQ5_K
+			} else {
+				newType = fsggml.TensorTypeQ4_K
+			}
+		case ftype == fsggml.FileTypeQ3_K_L:
+			newType = fsggml.TensorTypeQ5_K
+		case (ftype == fsggml.FileTypeIQ4_NL || ftype == fsggml.FileTypeIQ4_XS) && nGQA >= 4:
+			newType = fsggml.TensorTypeQ5_K
+		case (ftype == fsggml.FileTypeQ4_K_M || ftype == fsggml.FileTypeQ5_K_M) &&
+			useMoreBits(qs.iAttnV, qs.nAttnV):
 			newType = fsggml.TensorTypeQ6_K
-		} else if ftype == fsggml.FileTypeQ4_K_S && qs.iAttnV < 4 {
+		case ftype == fsggml.FileTypeQ4_K_S && qs.iAttnV < 4:
 			newType = fsggml.TensorTypeQ5_K
 		}
 
@@ -94,30 +159,82 @@ func getTensorNewType(kv fsggml.KV, qs *quantizeState, newType fsggml.TensorType
 		if nExperts == 8 {
 			// for the 8-expert model, bumping this to Q8_0 trades just ~128MB
 			newType = fsggml.TensorTypeQ8_0
+		} else if ftype == fsggml.FileTypeIQ3_XXS {
+			newType = fsggml.TensorTypeIQ2_S
+		}
+	} else if strings.Contains(name, "attn_q.weight") {
+		if ftype == fsggml.FileTypeIQ3_XXS {
+			newType = fsggml.TensorTypeIQ2_S
 		}
 	} else if strings.Contains(name, "ffn_down") {
 		iLayer := qs.iFfnDown
 		n_layer := qs.nFfnDown
-		if ftype == fsggml.FileTypeQ4_K_M {
+		switch {
+		case ftype == fsggml.FileTypeQ2_K:
+			newType = fsggml.TensorTypeQ3_K
+		case ftype == fsggml.FileTypeIQ3_XXS && !qs.hasImatrix:
+			if iLayer < n_layer/8 {
+				newType = fsggml.TensorTypeQ4_K
+			} else {
+				newType = fsggml.TensorTypeQ3_K
+			}
+		case ftype == fsggml.FileTypeQ3_K_M:
+			if iLayer < n_layer/16 {
+				newType = fsggml.TensorTypeQ5_K
+			} else {
+				newType = fsggml.TensorTypeQ4_K
+			}
+		case ftype == fsggml.FileTypeQ3_K_L:
+			newType = fsggml.TensorTypeQ5_K
+		case ftype == fsggml.FileTypeQ4_K_M:
 			if useMoreBits(iLayer, n_layer) {
 				newType = fsggml.TensorTypeQ6_K
 			}
-		} else if ftype == fsggml.FileTypeQ4_K_S && iLayer < n_layer/8 {
+		case iLayer < n_layer/8 && (ftype == fsggml.FileTypeIQ4_NL || ftype == fsggml.FileTypeIQ4_XS) && !qs.hasImatrix:
+			newType = fsggml.TensorTypeQ5_K
+		case ftype == fsggml.FileTypeQ5_K_M && useMoreBits(iLayer, n_layer):
+			newType = fsggml.TensorTypeQ6_K
+		case ftype == fsggml.FileTypeQ4_K_S && iLayer < n_layer/8:
 			newType = fsggml.TensorTypeQ5_K
 		}
 		qs.iFfnDown++
 	} else if strings.Contains(name, "attn_output.weight") {
 		if nExperts == 8 {
-			if ftype == fsggml.FileTypeQ4_K_S || ftype == fsggml.FileTypeQ4_K_M {
+			switch ftype {
+			case fsggml.FileTypeQ2_K, fsggml.FileTypeIQ3_XXS,
+				fsggml.FileTypeQ3_K_S, fsggml.FileTypeQ3_K_M, fsggml.FileTypeIQ4_NL,
+				fsggml.FileTypeQ4_K_S, fsggml.FileTypeQ4_K_M, fsggml.FileTypeIQ4_XS:
+				newType = fsggml.TensorTypeQ5_K
+			}
+		} else {
+			switch ftype {
+			case fsggml.FileTypeQ2_K:
+				newType = fsggml.TensorTypeQ3_K
+			case fsggml.FileTypeIQ3_XXS:
+				newType = fsggml.TensorTypeIQ3_S
+			case fsggml.FileTypeQ3_K_M:
+				newType = fsggml.TensorTypeQ4_K
+			case fsggml.FileTypeQ3_K_L:
 				newType = fsggml.TensorTypeQ5_K
 			}
 		}
 	} else if strings.Contains(name, "attn_qkv.weight") {
-		if ftype == fsggml.FileTypeQ4_K_M {
+		switch ftype {
+		case fsggml.FileTypeQ3_K_M, fsggml.FileTypeQ3_K_L:
+			newType = fsggml.TensorTypeQ4_K
+		case fsggml.FileTypeQ4_K_M:
 			newType = fsggml.TensorTypeQ5_K
+		case fsggml.FileTypeQ5_K_M:
+			newType = fsggml.TensorTypeQ6_K
 		}
 	}
 
+	return compatibleType(newType, shape)
+}
+
+// compatibleType returns newType, or a fallback type if the rows of a tensor
+// with shape can't be split into blocks of newType.
+func compatibleType(newType fsggml.TensorType, shape []uint64) fsggml.TensorType {
 	if newType.IsQuantized() {
 		nx := shape[0]
 		qk_k := newType.BlockSize()
@@ -129,6 +246,15 @@ func getTensorNewType(kv fsggml.KV, qs *quantizeState, newType fsggml.TensorType
 
 			// Select appropriate fallback based on original type
 			switch newType {
+			case fsggml.TensorTypeIQ2_XXS,
+				fsggml.TensorTypeIQ2_XS,
+				fsggml.TensorTypeIQ2_S,
+				fsggml.TensorTypeIQ3_XXS,
+				fsggml.TensorTypeIQ3_S,
+				fsggml.TensorTypeQ2_K,
+				fsggml.TensorTypeQ3_K,
+				fsggml.TensorTypeIQ4_XS:
+				newType = fsggml.TensorTypeIQ4_NL
 			case fsggml.TensorTypeQ4_K:
 				newType = fsggml.TensorTypeQ5_0
 			case fsggml.TensorTypeQ5_K:
@@ -149,11 +275,62 @@ func getTensorNewType(kv fsggml.KV, qs *quantizeState, newType fsggml.TensorType
 	return newType
 }
 
-func quantize(in, out *os.File, orig *fsggml.GGML, newFileType fsggml.FileType, progressFn func(n uint64)) error {
+// quantizeRule sets the type of the tensors whose names match pattern,
+// overriding the type picked by getTensorNewType.
+type quantizeRule struct {
+	pattern string
+	kind    fsggml.TensorType
+}
+
+func parseQuantizeRules(rules []api.QuantizeRule) ([]quantizeRule, error) {
+	var out []quantizeRule
+	for _, r := range rules {
+		if _, err := path.Match(r.Pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid quantize rule pattern %q: %w", r.Pattern, err)
+		}
+
+		kind, err := fsggml.ParseTensorType(strings.ToUpper(r.Type))
+		if err != nil {
+			return nil, fmt.Errorf("invalid quantize rule for %q: %w", r.Pattern, err)
+		}
+
+		switch kind {
+		case fsggml.TensorTypeQ8_1, fsggml.TensorTypeQ8_K, fsggml.TensorTypeF64, fsggml.TensorTypeMXFP4:
+			return nil, fmt.Errorf("invalid quantize rule for %q: quantizing to %s is not supported", r.Pattern, kind)
+		}
+
+		out = append(out, quantizeRule{pattern: r.Pattern, kind: kind})
+	}
+
+	return out, nil
+}
+
+// tensorTypeCounts returns the number of tensors of each type in ts, most
+// common first, formatted like "Q4_K (193), F32 (65)".
+func tensorTypeCounts(ts []*fsggml.Tensor) string {
+	counts := make(map[fsggml.TensorType]int)
+	for _, t := range ts {
+		counts[fsggml.TensorType(t.Kind)]++
+	}
+
+	kinds := slices.Collect(maps.Keys(counts))
+	slices.SortFunc(kinds, func(a, b fsggml.TensorType) int {
+		return cmp.Or(cmp.Compare(counts[b], counts[a]), cmp.Compare(a.String(), b.String()))
+	})
+
+	s := make([]string, len(kinds))
+	for i, k := range kinds {
+		s[i] = fmt.Sprintf("%s (%d)", k, counts[k])
+	}
+
+	return strings.Join(s, ", ")
+}
+
+func quantize(in, out *os.File, orig *fsggml.GGML, newFileType fsggml.FileType, im imatrix, rules []quantizeRule, progressFn func(n uint64)) error {
 	kv := maps.Clone(orig.KV())
 	kv["general.file_type"] = newFileType
 	// kv["general.quantization_version"] = ggml.QuantizationVersion()
-	qs := &quantizeState{}
+	qs := &quantizeState{hasImatrix: len(im) > 0}
 	// Build up the quantize state so newType can adjust types
 	layerCount := 0
 	for k, l := range orig.Tensors().GroupLayers() {
@@ -176,25 +353,43 @@ func quantize(in, out *os.File, orig *fsggml.GGML, newFileType fsggml.FileType,
 	outputTensors := make([]*fsggml.Tensor, len(origTensors))
 	for i, tensor := range origTensors {
 		tensor := tensor
-		newType := newType(tensor, kv, qs, newFileType)
+		newType := newType(tensor, kv, qs, newFileType, rules)
 		newTensor := &fsggml.Tensor{
 			Name:  tensor.Name,
 			Shape: tensor.Shape,
 			Kind:  uint32(newType),
 		}
+
+		var weights []float32
+		if fsggml.TensorType(tensor.Kind) != newType {
+			var err error
+			weights, err = im.weights(tensor)
+			if err != nil {
+				return err
+			}
+
+			switch newType {
+			case fsggml.TensorTypeIQ2_XXS, fsggml.TensorTypeIQ2_XS, fsggml.TensorTypeIQ2_S:
+				if weights == nil {
+					return fmt.Errorf("missing importance matrix for tensor %s in a very low-bit quantization", tensor.Name)
+				}
+			}
+		}
+
 		outputTensors[i] = newTensor
 		outputTensors[i].WriterTo = quantizer{
 			File:       in,
 			offset:     orig.Tensors().Offset + tensor.Offset,
 			from:       tensor,
 			to:         newTensor,
+			imatrix:    weights,
 			progressFn: progressFn,
 		}
 	}
 	return fsggml.WriteGGUF(out, kv, outputTensors)
 }
 
-func newType(t *fsggml.Tensor, kv fsggml.KV, qs *quantizeState, ftype fsggml.FileType) fsggml.TensorType {
+func newType(t *fsggml.Tensor, kv fsggml.KV, qs *quantizeState, ftype fsggml.FileType, rules []quantizeRule) fsggml.TensorType {
 	defaultType := ftype.ToTensorType()
 	name := t.Name
 	quantize := strings.HasSuffix(name, "weight")
@@ -240,6 +435,14 @@ func newType(t *fsggml.Tensor, kv fsggml.KV, qs *quantizeState, ftype fsggml.Fil
 		if newType != defaultType {
 			slog.Debug("tensor quantization adjusted for better quality", "name", t.Name, "requested", defaultType, "quantization", newType)
 		}
+
+		for _, r := range rules {
+			if ok, _ := path.Match(r.pattern, name); ok {
+				newType = compatibleType(r.kind, t.Shape)
+				slog.Debug("tensor quantization set by rule", "name", t.Name, "pattern", r.pattern, "quantization", newType)
+				break
+			}
+		}
 	}
 	return newType
 }
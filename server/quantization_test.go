@@ -5,9 +5,13 @@ import (
 	"fmt"
 	"math"
 	"os"
+	"slices"
 	"strings"
 	"testing"
 
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/ollama/ollama/api"
 	fsggml "github.com/ollama/ollama/fs/ggml"
 	"github.com/ollama/ollama/ml/backend/ggml"
 )
@@ -140,6 +144,80 @@ func TestGetTensorNewType(t *testing.T) {
 			ftype:       fsggml.FileTypeQ4_K_M,
 			expected:    fsggml.TensorTypeQ5_K,
 		},
+		{
+			name:        "attn_v.weight_q3_k_m",
+			qs:          quantizeState{iAttnV: 1},
+			kv:          map[string]any{},
+			newType:     fsggml.TensorTypeQ3_K,
+			tensor_name: "blk.1.attn_v.weight",
+			shape:       []uint64{256},
+			ftype:       fsggml.FileTypeQ3_K_M,
+			expected:    fsggml.TensorTypeQ5_K,
+		},
+		{
+			name: "attn_v.weight_q2_k_gqa",
+			qs:   quantizeState{},
+			kv: map[string]any{
+				"general.architecture":        "foo",
+				"foo.attention.head_count":    uint32(32),
+				"foo.attention.head_count_kv": uint32(8),
+			},
+			newType:     fsggml.TensorTypeQ2_K,
+			tensor_name: "blk.0.attn_v.weight",
+			shape:       []uint64{256},
+			ftype:       fsggml.FileTypeQ2_K,
+			expected:    fsggml.TensorTypeQ4_K,
+		},
+		{
+			name:        "attn_v.weight_iq3_xxs_imatrix",
+			qs:          quantizeState{hasImatrix: true},
+			kv:          map[string]any{},
+			newType:     fsggml.TensorTypeIQ3_XXS,
+			tensor_name: "blk.0.attn_v.weight",
+			shape:       []uint64{256},
+			ftype:       fsggml.FileTypeIQ3_XXS,
+			expected:    fsggml.TensorTypeIQ3_XXS,
+		},
+		{
+			name:        "attn_v.weight_iq3_xxs",
+			qs:          quantizeState{},
+			kv:          map[string]any{},
+			newType:     fsggml.TensorTypeIQ3_XXS,
+			tensor_name: "blk.0.attn_v.weight",
+			shape:       []uint64{256},
+			ftype:       fsggml.FileTypeIQ3_XXS,
+			expected:    fsggml.TensorTypeIQ3_S,
+		},
+		{
+			name:        "token_embd_iq2_xs",
+			qs:          quantizeState{hasOutput: true},
+			kv:          map[string]any{},
+			newType:     fsggml.TensorTypeIQ2_XS,
+			tensor_name: "token_embd.weight",
+			shape:       []uint64{256, 16},
+			ftype:       fsggml.FileTypeIQ2_XS,
+			expected:    fsggml.TensorTypeQ2_K,
+		},
+		{
+			name:        "output_iq2_xxs",
+			qs:          quantizeState{},
+			kv:          map[string]any{},
+			newType:     fsggml.TensorTypeIQ2_XXS,
+			tensor_name: "output.weight",
+			shape:       []uint64{256, 16},
+			ftype:       fsggml.FileTypeIQ2_XXS,
+			expected:    fsggml.TensorTypeQ5_K,
+		},
+		{
+			name:        "ffn_down_iq4_nl_fallback",
+			qs:          quantizeState{iFfnDown: 4, nFfnDown: 8},
+			kv:          map[string]any{},
+			newType:     fsggml.TensorTypeIQ4_XS,
+			tensor_name: "blk.4.ffn_down.weight",
+			shape:       []uint64{96},
+			ftype:       fsggml.FileTypeIQ4_XS,
+			expected:    fsggml.TensorTypeIQ4_NL,
+		},
 	}
 	for _, tt := range cases {
 		t.Run(tt.name, func(t *testing.T) {
@@ -172,8 +250,80 @@ func TestQuantizeModel(t *testing.T) {
 		kv                  map[string]any
 		tensors             []*fsggml.Tensor
 		newType             string
+		imatrix             imatrix
+		rules               []quantizeRule
 		expectedTensorTypes map[string]fsggml.TensorType
 	}{
+		{
+			name: "f16_q4_k_rules",
+			kv: map[string]any{
+				"general.architecture": "foo",
+			},
+			tensors: []*fsggml.Tensor{
+				{
+					Name: "blk.0.attn_v.weight", Kind: uint32(fsggml.TensorTypeF16),
+					Offset: uint64(0), Shape: []uint64{512, 2},
+					WriterTo: bytes.NewReader(
+						append(append(append(quantBytes[fsggml.TensorTypeF16], quantBytes[fsggml.TensorTypeF16]...), quantBytes[fsggml.TensorTypeF16]...), quantBytes[fsggml.TensorTypeF16]...),
+					),
+				},
+				{
+					Name: "blk.0.ffn_up.weight", Kind: uint32(fsggml.TensorTypeF16),
+					Offset: uint64(0), Shape: []uint64{512, 2},
+					WriterTo: bytes.NewReader(
+						append(append(append(quantBytes[fsggml.TensorTypeF16], quantBytes[fsggml.TensorTypeF16]...), quantBytes[fsggml.TensorTypeF16]...), quantBytes[fsggml.TensorTypeF16]...),
+					),
+				},
+				{
+					Name: "output.weight", Kind: uint32(fsggml.TensorTypeF16),
+					Offset: uint64(0), Shape: []uint64{256, 4},
+					WriterTo: bytes.NewReader(
+						append(append(append(quantBytes[fsggml.TensorTypeF16], quantBytes[fsggml.TensorTypeF16]...), quantBytes[fsggml.TensorTypeF16]...), quantBytes[fsggml.TensorTypeF16]...),
+					),
+				},
+			},
+			newType: "Q4_K_M",
+			rules: []quantizeRule{
+				{pattern: "blk.*.attn_v.weight", kind: fsggml.TensorTypeQ8_0},
+				{pattern: "blk.*.attn_*.weight", kind: fsggml.TensorTypeQ2_K},
+				{pattern: "output.weight", kind: fsggml.TensorTypeF16},
+			},
+			expectedTensorTypes: map[string]fsggml.TensorType{
+				"blk.0.attn_v.weight": fsggml.TensorTypeQ8_0,
+				"blk.0.ffn_up.weight": fsggml.TensorTypeQ4_K,
+				"output.weight":       fsggml.TensorTypeF16,
+			},
+		},
+		{
+			name: "f16_iq4_xs_imatrix",
+			kv: map[string]any{
+				"general.architecture": "foo",
+			},
+			tensors: []*fsggml.Tensor{
+				{
+					Name: "blk.0.attn.weight", Kind: uint32(fsggml.TensorTypeF16),
+					Offset: uint64(0), Shape: []uint64{512, 2},
+					WriterTo: bytes.NewReader(
+						append(append(append(quantBytes[fsggml.TensorTypeF16], quantBytes[fsggml.TensorTypeF16]...), quantBytes[fsggml.TensorTypeF16]...), quantBytes[fsggml.TensorTypeF16]...),
+					),
+				},
+				{
+					Name: "output.weight", Kind: uint32(fsggml.TensorTypeF16),
+					Offset: uint64(0), Shape: []uint64{256, 4},
+					WriterTo: bytes.NewReader(
+						append(append(append(quantBytes[fsggml.TensorTypeF16], quantBytes[fsggml.TensorTypeF16]...), quantBytes[fsggml.TensorTypeF16]...), quantBytes[fsggml.TensorTypeF16]...),
+					),
+				},
+			},
+			newType: "IQ4_XS",
+			imatrix: imatrix{
+				"blk.0.attn.weight": slices.Repeat([]float32{1}, 512),
+			},
+			expectedTensorTypes: map[string]fsggml.TensorType{
+				"blk.0.attn.weight": fsggml.TensorTypeIQ4_XS,
+				"output.weight":     fsggml.TensorTypeQ6_K,
+			},
+		},
 		{
 			name: "f16_q4_k",
 			kv: map[string]any{
@@ -282,7 +432,7 @@ func TestQuantizeModel(t *testing.T) {
 				t.Fatal(err.Error())
 			}
 
-			err = quantize(fp, tmp, meta, ftype, progress)
+			err = quantize(fp, tmp, meta, ftype, tt.imatrix, tt.rules, progress)
 			if err != nil {
 				t.Fatalf("error during quantize: %s", err)
 			}
@@ -311,6 +461,48 @@ func TestQuantizeModel(t *testing.T) {
 	}
 }
 
+func TestParseQuantizeRules(t *testing.T) {
+	rules, err := parseQuantizeRules([]api.QuantizeRule{
+		{Pattern: "blk.*.ffn_down.weight", Type: "q6_K"},
+		{Pattern: "token_embd.weight", Type: "Q8_0"},
+		{Pattern: "*_exps.weight", Type: "iq4_xs"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff([]quantizeRule{
+		{pattern: "blk.*.ffn_down.weight", kind: fsggml.TensorTypeQ6_K},
+		{pattern: "token_embd.weight", kind: fsggml.TensorTypeQ8_0},
+		{pattern: "*_exps.weight", kind: fsggml.TensorTypeIQ4_XS},
+	}, rules, cmp.AllowUnexported(quantizeRule{})); diff != "" {
+		t.Errorf("rules mismatch (-want +got):\n%s", diff)
+	}
+
+	for _, r := range []api.QuantizeRule{
+		{Pattern: "blk.[.weight", Type: "q4_K"},
+		{Pattern: "*.weight", Type: "q4_K_M"},
+		{Pattern: "*.weight", Type: "q8_K"},
+	} {
+		if _, err := parseQuantizeRules([]api.QuantizeRule{r}); err == nil {
+			t.Errorf("expected error for %v", r)
+		}
+	}
+}
+
+func TestTensorTypeCounts(t *testing.T) {
+	got := tensorTypeCounts([]*fsggml.Tensor{
+		{Kind: uint32(fsggml.TensorTypeQ4_K)},
+		{Kind: uint32(fsggml.TensorTypeF32)},
+		{Kind: uint32(fsggml.TensorTypeQ6_K)},
+		{Kind: uint32(fsggml.TensorTypeQ4_K)},
+	})
+
+	if want := "Q4_K (2), F32 (1), Q6_K (1)"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
 func TestConvertToF32(t *testing.T) {
 	expected := make([]float32, 256)
 	for i := range expected {
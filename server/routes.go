@@ -22,6 +22,7 @@ import (
 	"os/signal"
 	"slices"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
@@ -81,6 +82,9 @@ type Server struct {
 	addr    net.Addr
 	sched   *Scheduler
 	lowVRAM bool
+
+	sessionsMu sync.Mutex
+	sessions   *sessionStore
 }
 
 func init() {
@@ -502,12 +506,13 @@ func (s *Server) GenerateHandler(c *gin.Context) {
 		var sb strings.Builder
 		defer close(ch)
 		if err := r.Completion(c.Request.Context(), llm.CompletionRequest{
-			Prompt:   prompt,
-			Images:   images,
-			Format:   req.Format,
-			Options:  opts,
-			Shift:    req.Shift == nil || *req.Shift,
-			Truncate: req.Truncate == nil || *req.Truncate,
+			Prompt:         prompt,
+			Images:         images,
+			Format:         req.Format,
+			Options:        opts,
+			Shift:          req.Shift == nil || *req.Shift,
+			Truncate:       req.Truncate == nil || *req.Truncate,
+			GrammarTrigger: answerStart(builtinParser, thinkingState),
 		}, func(cr llm.CompletionResponse) {
 			res := api.GenerateResponse{
 				Model:     req.Model,
@@ -519,6 +524,8 @@ func (s *Server) GenerateHandler(c *gin.Context) {
 					PromptEvalDuration: cr.PromptEvalDuration,
 					EvalCount:          cr.EvalCount,
 					EvalDuration:       cr.EvalDuration,
+					TimeToFirstToken:   cr.TimeToFirstToken,
+					InterTokenLatency:  cr.InterTokenLatency,
 				},
 			}
 
@@ -899,6 +906,7 @@ func (s *Server) PushHandler(c *gin.Context) {
 
 		regOpts := &registryOptions{
 			Insecure: req.Insecure,
+			Sign:     req.Sign,
 		}
 
 		ctx, cancel := context.WithCancel(c.Request.Context())
@@ -993,6 +1001,11 @@ func (s *Server) DeleteHandler(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+
+	if err := removeBuildRecord(n); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
 }
 
 func (s *Server) ShowHandler(c *gin.Context) {
@@ -1080,6 +1093,16 @@ func GetModelInfo(req api.ShowRequest) (*api.ShowResponse, error) {
 		ModifiedAt:   manifest.fi.ModTime(),
 	}
 
+	resp.SourceDigest, err = sourceDigest(name, manifest)
+	if err != nil {
+		slog.Warn("invalid build record", "model", name, "error", err)
+	}
+
+	resp.Signer, err = manifestSigner(manifest)
+	if err != nil {
+		slog.Warn("invalid model signature", "model", name, "error", err)
+	}
+
 	if m.Config.RemoteHost != "" {
 		resp.RemoteHost = m.Config.RemoteHost
 		resp.RemoteModel = m.Config.RemoteModel
@@ -1498,6 +1521,12 @@ func (s *Server) GenerateRoutes(rc *ollama.Registry) (http.Handler, error) {
 	r.POST("/api/embed", s.EmbedHandler)
 	r.POST("/api/embeddings", s.EmbeddingsHandler)
 
+	// Sessions
+	r.POST("/api/sessions", s.CreateSessionHandler)
+	r.GET("/api/sessions", s.ListSessionsHandler)
+	r.GET("/api/sessions/:id", s.ShowSessionHandler)
+	r.DELETE("/api/sessions/:id", s.DeleteSessionHandler)
+
 	// Inference (OpenAI compatibility)
 	r.POST("/v1/chat/completions", middleware.ChatMiddleware(), s.ChatHandler)
 	r.POST("/v1/completions", middleware.CompletionsMiddleware(), s.GenerateHandler)
@@ -1561,6 +1590,8 @@ func Serve(ln net.Listener) error {
 		if err != nil {
 			return err
 		}
+		rc.VerifySignature = verifyRegistrySignature
+		rc.RequiresSignature = requiresSignature
 	}
 
 	h, err := s.GenerateRoutes(rc)
@@ -1594,6 +1625,7 @@ func Serve(ln net.Listener) error {
 	go func() {
 		<-signals
 		srvr.Close()
+		s.closeSessionStore()
 		schedDone()
 		sched.unloadAllRunners()
 		done()
@@ -1656,7 +1688,7 @@ func waitForStream(c *gin.Context, ch chan any) {
 	c.JSON(http.StatusOK, latest)
 }
 
-func streamResponse(c *gin.Context, ch chan any) {
+func streamResponse(c *gin.Context, ch <-chan any) {
 	c.Header("Content-Type", "application/x-ndjson")
 	c.Stream(func(w io.Writer) bool {
 		val, ok := <-ch
@@ -1790,6 +1822,9 @@ func (s *Server) PsHandler(c *gin.Context) {
 			Digest:    model.Digest,
 			Details:   modelDetails,
 			ExpiresAt: v.expiresAt,
+
+			KVCacheType: cmp.Or(v.kvCacheType, "f16"),
+			SizeKVCache: int64(v.kvCacheSize),
 		}
 		if v.Options != nil {
 			mr.ContextLength = v.Options.NumCtx
@@ -1873,6 +1908,51 @@ func (s *Server) ChatHandler(c *gin.Context) {
 		return
 	}
 
+	if req.Compaction != nil && req.Compaction.Strategy != "truncate" && req.Compaction.Strategy != "summarize" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid compaction strategy %q; expected \"truncate\" or \"summarize\"", req.Compaction.Strategy)})
+		return
+	}
+
+	var sessions *sessionStore
+	var sessionMsgs []api.Message
+	if req.SessionID != "" {
+		if len(req.Messages) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "messages are required with session_id"})
+			return
+		}
+
+		if m.Config.RemoteHost != "" && m.Config.RemoteModel != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "sessions aren't supported with remote models"})
+			return
+		}
+
+		sessions, err = s.sessionStore()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		// the reply is recorded before the handler returns, so the session
+		// is in use until then
+		if err := sessions.acquire(req.SessionID); err != nil {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		defer sessions.release(req.SessionID)
+
+		session, err := sessions.get(req.SessionID)
+		if errors.Is(err, errSessionNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("session %q not found", req.SessionID)})
+			return
+		} else if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		sessionMsgs = req.Messages
+		req.Messages = append(session.Messages, req.Messages...)
+	}
+
 	if m.Config.RemoteHost != "" && m.Config.RemoteModel != "" {
 		origModel := req.Model
 
@@ -1960,6 +2040,26 @@ func (s *Server) ChatHandler(c *gin.Context) {
 		return
 	}
 
+	if req.ToolChoice.IsNone() {
+		// tools the model isn't told about can't be called
+		req.Tools = nil
+	} else if req.ToolChoice.IsRequired() {
+		if len(req.Tools) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "tool_choice requires tools"})
+			return
+		}
+
+		if name := req.ToolChoice.Function; name != "" && !slices.ContainsFunc(req.Tools, func(t api.Tool) bool { return t.Function.Name == name }) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("tool_choice function %q is not in tools", name)})
+			return
+		}
+
+		if req.Format != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "format can't be used with a required tool_choice"})
+			return
+		}
+	}
+
 	caps := []model.Capability{model.CapabilityCompletion}
 	if len(req.Tools) > 0 {
 		caps = append(caps, model.CapabilityTools)
@@ -1978,7 +2078,12 @@ func (s *Server) ChatHandler(c *gin.Context) {
 		}
 	}
 
-	r, m, opts, err := s.scheduleRunner(c.Request.Context(), name.String(), caps, req.Options, req.KeepAlive)
+	// the runner can be released early so another model can summarize the
+	// chat, see compaction below
+	runnerCtx, releaseRunner := context.WithCancel(c.Request.Context())
+	defer func() { releaseRunner() }()
+
+	r, m, opts, err := s.scheduleRunner(runnerCtx, name.String(), caps, req.Options, req.KeepAlive)
 	if errors.Is(err, errCapabilityCompletion) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%q does not support chat", req.Model)})
 		return
@@ -2027,6 +2132,72 @@ func (s *Server) ChatHandler(c *gin.Context) {
 	}
 
 	truncate := req.Truncate == nil || *req.Truncate
+
+	var compaction *api.CompactionResult
+	if req.Compaction != nil && truncate {
+		switch req.Compaction.Strategy {
+		case "truncate":
+			n, err := fitMessages(c.Request.Context(), m, r.Tokenize, opts.NumCtx, msgs, processedTools, req.Think, truncate)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+
+			if evicted := countMessages(msgs[:n]); evicted > 0 {
+				compaction = &api.CompactionResult{Strategy: "truncate", Messages: evicted}
+			}
+		case "summarize":
+			// scheduling failures are reported like those of the chat's model
+			var scheduleErr error
+			var scheduleName string
+			summarize := func(evicted []api.Message, numPredict int) (string, error) {
+				if req.Compaction.Model == "" || model.ParseName(req.Compaction.Model).EqualFold(name) {
+					return summarizeMessages(c.Request.Context(), r, m, *opts, numPredict, evicted)
+				}
+
+				// the models may not fit in memory together, so the chat's
+				// model is released while the summary is written
+				releaseRunner()
+
+				summaryCtx, cancel := context.WithCancel(c.Request.Context())
+				defer cancel()
+
+				sr, sm, sopts, err := s.scheduleRunner(summaryCtx, req.Compaction.Model, []model.Capability{model.CapabilityCompletion}, req.Options, req.KeepAlive)
+				if err != nil {
+					scheduleErr, scheduleName = err, req.Compaction.Model
+					return "", err
+				}
+
+				summary, err := summarizeMessages(summaryCtx, sr, sm, *sopts, min(numPredict, sopts.NumCtx/summaryBudget), evicted)
+				cancel()
+
+				runnerCtx, releaseRunner = context.WithCancel(c.Request.Context())
+				var rerr error
+				if r, _, _, rerr = s.scheduleRunner(runnerCtx, name.String(), caps, req.Options, req.KeepAlive); rerr != nil {
+					scheduleErr, scheduleName = rerr, name.String()
+					return "", rerr
+				}
+				return summary, err
+			}
+
+			// r is replaced when the summary is written by another model
+			tokenize := func(ctx context.Context, s string) ([]int, error) { return r.Tokenize(ctx, s) }
+			msgs, compaction, err = compactMessages(c.Request.Context(), m, tokenize, opts, msgs, processedTools, req.Think, summarize)
+			if scheduleErr != nil {
+				handleScheduleError(c, scheduleName, scheduleErr)
+				return
+			} else if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+		}
+
+		// messages from the modelfile aren't part of the request
+		if compaction != nil {
+			compaction.Messages = max(compaction.Messages-countMessages(m.Messages), 0)
+		}
+	}
+
 	prompt, images, err := chatPrompt(c.Request.Context(), m, r.Tokenize, opts, msgs, processedTools, req.Think, truncate)
 	if err != nil {
 		slog.Error("chat prompt error", "error", err)
@@ -2037,8 +2208,9 @@ func (s *Server) ChatHandler(c *gin.Context) {
 	// If debug mode is enabled, return the rendered template instead of calling the model
 	if req.DebugRenderOnly {
 		c.JSON(http.StatusOK, api.ChatResponse{
-			Model:     req.Model,
-			CreatedAt: time.Now().UTC(),
+			Model:      req.Model,
+			CreatedAt:  time.Now().UTC(),
+			Compaction: compaction,
 			DebugInfo: &api.DebugInfo{
 				RenderedTemplate: prompt,
 				ImageCount:       len(images),
@@ -2071,6 +2243,61 @@ func (s *Server) ChatHandler(c *gin.Context) {
 		toolParser = tools.NewParser(m.Template.Template, req.Tools)
 	}
 
+	// toolCallSyntax returns the syntax of a call to the tool named name, or to
+	// any of the tools if name is empty, for the current parser. It returns
+	// nil if the calls can't be constrained, or if name is set and the call
+	// to it can't be told apart from calls to the other tools.
+	toolCallSyntax := func(name string) []parsers.ToolCallSyntax {
+		var syntax []parsers.ToolCallSyntax
+		callable := processedTools
+		if toolParser != nil {
+			syntax = templateToolCallSyntax(toolParser.Tag(), req.Tools)
+			callable = req.Tools
+		} else {
+			syntax = parsers.ToolCallSyntaxFor(builtinParser, processedTools)
+		}
+
+		if name != "" {
+			i := slices.IndexFunc(callable, func(t api.Tool) bool { return t.Function.Name == name })
+			if i < 0 || len(syntax) != len(callable) {
+				return nil
+			}
+			syntax = syntax[i : i+1]
+		}
+		return syntax
+	}
+
+	// a forced tool call of a thinking model is constrained once thinking
+	// ends, like a format. Harmony models call tools on their own channel
+	// rather than in the answer, so theirs are constrained right away.
+	toolCallTrigger := func() string {
+		if m.Config.Parser == "harmony" {
+			return ""
+		}
+		return answerStart(builtinParser, thinkingState)
+	}
+
+	// a required tool call is enforced with a grammar matching the model's
+	// tool call syntax and the arguments of the allowed tools
+	var grammar string
+	if req.ToolChoice.IsRequired() {
+		syntax := toolCallSyntax(req.ToolChoice.Function)
+		if len(syntax) == 0 {
+			if req.ToolChoice.Function != "" && len(toolCallSyntax("")) > 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%q does not support tool_choice with a function", req.Model)})
+				return
+			}
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%q does not support tool_choice", req.Model)})
+			return
+		}
+
+		grammar, err = toolCallGrammar(syntax)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
 	type structuredOutputsState int
 	const (
 		structuredOutputsState_None structuredOutputsState = iota
@@ -2079,13 +2306,46 @@ func (s *Server) ChatHandler(c *gin.Context) {
 	)
 
 	ch := make(chan any)
+	// parsers that stream tool call deltas number their calls, so the deltas
+	// and the complete call share an id
+	streamer, _ := builtinParser.(parsers.ToolCallStreamer)
+	toolCallIDs := make(map[int]string)
+	indexedToolCallId := func(index int) string {
+		if id, ok := toolCallIDs[index]; ok {
+			return id
+		}
+		id := toolCallId()
+		toolCallIDs[index] = id
+		return id
+	}
+
+	// the format of a thinking model's answer is applied by the runner once
+	// the answer starts when it's known to start after thinking, and
+	// otherwise by generating the answer again after thinking. A model with
+	// tools may call one instead of answering, which the format would
+	// prevent, so its answer is always generated again.
+	var formatTrigger string
+	if req.Format != nil && len(req.Tools) == 0 {
+		formatTrigger = answerStart(builtinParser, thinkingState)
+	}
+
 	go func() {
 		defer close(ch)
 
 		structuredOutputsState := structuredOutputsState_None
+		if formatTrigger != "" {
+			structuredOutputsState = structuredOutputsState_Applying
+		}
+
+		grammarTrigger := formatTrigger
+		if req.ToolChoice.IsRequired() {
+			grammarTrigger = toolCallTrigger()
+		}
+
+		retry := newToolCallRetry(req.Tools, func(name string) bool { return len(toolCallSyntax(name)) > 0 })
 
 		for {
-			var tb strings.Builder
+			var tb, cb strings.Builder
 
 			currentFormat := req.Format
 			// structured outputs via double request is enabled when:
@@ -2103,14 +2363,33 @@ func (s *Server) ChatHandler(c *gin.Context) {
 
 			// sets up new context given parent context per request
 			ctx, cancel := context.WithCancel(c.Request.Context())
+			// stopForRetry sends what was generated before a call that's
+			// generated again and stops the completion
+			stopForRetry := func(res api.ChatResponse) {
+				res, ok := retry.interrupt(res)
+				cb.WriteString(res.Message.Content)
+				if ok {
+					ch <- res
+				}
+				cancel()
+			}
+
 			err := r.Completion(ctx, llm.CompletionRequest{
 				Prompt:   prompt,
 				Images:   images,
 				Format:   currentFormat,
+				Grammar:  grammar,
 				Options:  opts,
 				Shift:    req.Shift == nil || *req.Shift,
 				Truncate: truncate,
+
+				GrammarTrigger: grammarTrigger,
 			}, func(r llm.CompletionResponse) {
+				if retry.pending() != "" {
+					// the completion is being stopped
+					return
+				}
+
 				res := api.ChatResponse{
 					Model:     req.Model,
 					CreatedAt: time.Now().UTC(),
@@ -2121,12 +2400,15 @@ func (s *Server) ChatHandler(c *gin.Context) {
 						PromptEvalDuration: r.PromptEvalDuration,
 						EvalCount:          r.EvalCount,
 						EvalDuration:       r.EvalDuration,
+						TimeToFirstToken:   r.TimeToFirstToken,
+						InterTokenLatency:  r.InterTokenLatency,
 					},
 				}
 				if r.Done {
 					res.DoneReason = r.DoneReason.String()
 					res.TotalDuration = time.Since(checkpointStart)
 					res.LoadDuration = checkpointLoaded.Sub(checkpointStart)
+					res.Compaction = compaction
 				}
 
 				if builtinParser != nil {
@@ -2140,12 +2422,29 @@ func (s *Server) ChatHandler(c *gin.Context) {
 
 					res.Message.Content = content
 					res.Message.Thinking = thinking
+					if streamer != nil {
+						deltas := retry.deltas(streamer.ToolCallDeltas())
+						for i := range deltas {
+							deltas[i].ID = indexedToolCallId(deltas[i].Index)
+						}
+						res.Message.ToolCallDeltas = deltas
+					}
+					retry.offsetCalls(toolCalls)
 					for i := range toolCalls {
-						toolCalls[i].ID = toolCallId()
+						if streamer != nil {
+							toolCalls[i].ID = indexedToolCallId(toolCalls[i].Function.Index)
+						} else {
+							toolCalls[i].ID = toolCallId()
+						}
 					}
-					res.Message.ToolCalls = toolCalls
+					res.Message.ToolCalls = retry.check(toolCalls)
 
 					tb.WriteString(thinking)
+					if retry.pending() != "" {
+						stopForRetry(res)
+						return
+					}
+
 					// we are now receiving content from the model - we should start applying structured outputs
 					if structuredOutputsState == structuredOutputsState_None && req.Format != nil && tb.String() != "" && res.Message.Content != "" {
 						structuredOutputsState = structuredOutputsState_ReadyToApply
@@ -2153,8 +2452,9 @@ func (s *Server) ChatHandler(c *gin.Context) {
 						return
 					}
 
-					if res.Message.Content != "" || res.Message.Thinking != "" || len(res.Message.ToolCalls) > 0 || r.Done {
+					if res.Message.Content != "" || res.Message.Thinking != "" || len(res.Message.ToolCalls) > 0 || len(res.Message.ToolCallDeltas) > 0 || r.Done {
 						slog.Log(context.TODO(), logutil.LevelTrace, "builtin parser output", "parser", m.Config.Parser, "content", content, "thinking", thinking, "toolCalls", toolCalls, "done", r.Done)
+						cb.WriteString(res.Message.Content)
 						ch <- res
 					} else {
 						slog.Log(context.TODO(), logutil.LevelTrace, "builtin parser empty output", "parser", m.Config.Parser)
@@ -2187,10 +2487,11 @@ func (s *Server) ChatHandler(c *gin.Context) {
 					if len(content) > 0 {
 						res.Message.Content = content
 					} else if len(toolCalls) > 0 {
+						retry.offsetCalls(toolCalls)
 						for i := range toolCalls {
 							toolCalls[i].ID = toolCallId()
 						}
-						res.Message.ToolCalls = toolCalls
+						res.Message.ToolCalls = retry.check(toolCalls)
 						res.Message.Content = ""
 					} else if res.Message.Thinking != "" {
 						// don't return
@@ -2203,11 +2504,32 @@ func (s *Server) ChatHandler(c *gin.Context) {
 					}
 				}
 
+				if retry.pending() != "" {
+					stopForRetry(res)
+					return
+				}
+
+				cb.WriteString(res.Message.Content)
 				ch <- res
 			})
 			if err != nil {
-				if structuredOutputsState == structuredOutputsState_ReadyToApply && strings.Contains(err.Error(), "context canceled") && c.Request.Context().Err() == nil {
-					// only ignores error if it's a context cancellation due to setting structured outputs
+				if (structuredOutputsState == structuredOutputsState_ReadyToApply || retry.pending() != "") && strings.Contains(err.Error(), "context canceled") && c.Request.Context().Err() == nil {
+					// only ignores error if it's a context cancellation due to setting structured outputs or retrying a tool call
+				} else if errors.Is(err, llm.ErrGrammarNotTriggered) && retry.sentCalls() > 0 {
+					// a response that ended in a tool call has no answer to
+					// constrain
+					res := api.ChatResponse{
+						Model:      req.Model,
+						CreatedAt:  time.Now().UTC(),
+						Message:    api.Message{Role: "assistant"},
+						Done:       true,
+						DoneReason: llm.DoneReasonStop.String(),
+						Compaction: compaction,
+					}
+					res.TotalDuration = time.Since(checkpointStart)
+					res.LoadDuration = checkpointLoaded.Sub(checkpointStart)
+					ch <- res
+					return
 				} else {
 					var serr api.StatusError
 					if errors.As(err, &serr) {
@@ -2244,16 +2566,66 @@ func (s *Server) ChatHandler(c *gin.Context) {
 				continue
 			}
 
+			// the invalid call is generated again after what was sent, with a
+			// new parser as the old one may have consumed part of the call
+			if retry.pending() != "" {
+				retryTool := retry.start()
+
+				harmony := builtinParser != nil && m.Config.Parser == "harmony"
+				prefilled := tb.Len() > 0 || cb.Len() > 0
+				if prefilled {
+					msgs = append(msgs, api.Message{Role: "assistant", Thinking: tb.String(), Content: cb.String()})
+				}
+
+				if builtinParser != nil {
+					var lastMessage *api.Message
+					// harmony continues with a new message, which its parser
+					// starts without a prefill
+					if len(msgs) > 0 && !harmony {
+						lastMessage = &msgs[len(msgs)-1]
+					}
+					builtinParser = parsers.ParserForName(m.Config.Parser)
+					processedTools = builtinParser.Init(req.Tools, lastMessage)
+					streamer, _ = builtinParser.(parsers.ToolCallStreamer)
+				}
+				if toolParser != nil {
+					toolParser = tools.NewParser(m.Template.Template, req.Tools)
+				}
+
+				prompt, _, err = chatPrompt(c.Request.Context(), m, r.Tokenize, opts, msgs, processedTools, req.Think, truncate)
+				if err != nil {
+					slog.Error("chat prompt error retrying tool call", "error", err)
+					ch <- gin.H{"error": err.Error()}
+					return
+				}
+				if harmony && prefilled {
+					prompt += "<|end|><|start|>assistant"
+				}
+
+				grammar, err = toolCallGrammar(toolCallSyntax(retryTool))
+				if err != nil {
+					ch <- gin.H{"error": err.Error()}
+					return
+				}
+				grammarTrigger = toolCallTrigger()
+				continue
+			}
+
 			break
 		}
 	}()
 
+	var out <-chan any = ch
+	if sessions != nil {
+		out = recordSession(sessions, req.SessionID, req.Messages, sessionMsgs, ch)
+	}
+
 	if req.Stream != nil && !*req.Stream {
 		var resp api.ChatResponse
 		var toolCalls []api.ToolCall
 		var sbThinking strings.Builder
 		var sbContent strings.Builder
-		for rr := range ch {
+		for rr := range out {
 			switch t := rr.(type) {
 			case api.ChatResponse:
 				sbThinking.WriteString(t.Message.Thinking)
@@ -2283,6 +2655,8 @@ func (s *Server) ChatHandler(c *gin.Context) {
 
 		resp.Message.Content = sbContent.String()
 		resp.Message.Thinking = sbThinking.String()
+		// deltas only make sense when streaming
+		resp.Message.ToolCallDeltas = nil
 
 		if len(toolCalls) > 0 {
 			resp.Message.ToolCalls = toolCalls
@@ -2292,7 +2666,7 @@ func (s *Server) ChatHandler(c *gin.Context) {
 		return
 	}
 
-	streamResponse(c, ch)
+	streamResponse(c, out)
 }
 
 func handleScheduleError(c *gin.Context, name string, err error) {
@@ -2310,6 +2684,18 @@ func handleScheduleError(c *gin.Context, name string, err error) {
 	}
 }
 
+// answerStart returns the text after which a thinking model writes its answer,
+// or "" if the answer isn't known to start after thinking
+func answerStart(builtinParser parsers.Parser, thinkingState *thinking.Parser) string {
+	if starter, ok := builtinParser.(parsers.AnswerStarter); ok {
+		return starter.AnswerStart()
+	}
+	if thinkingState != nil {
+		return thinkingState.AnswerStart()
+	}
+	return ""
+}
+
 func filterThinkTags(msgs []api.Message, m *Model) []api.Message {
 	if m.Config.ModelFamily == "qwen3" || model.ParseName(m.Name).Model == "deepseek-r1" {
 		finalUserIndex := -1
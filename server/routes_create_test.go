@@ -254,6 +254,36 @@ func TestCreateFromModelInheritsRendererParser(t *testing.T) {
 	}
 }
 
+func TestCreateParserSpec(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	p := t.TempDir()
+	t.Setenv("OLLAMA_MODELS", p)
+	var s Server
+
+	_, digest := createBinFile(t, nil, nil)
+
+	w := createRequest(t, s.CreateHandler, api.CreateRequest{
+		Name:   "test",
+		Files:  map[string]string{"test.gguf": digest},
+		Parser: "thinking_end </think>\ntool_call_start <tool_call>\ntool_call_end </tool_call>",
+		Stream: &stream,
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d", w.Code)
+	}
+
+	w = createRequest(t, s.CreateHandler, api.CreateRequest{
+		Name:   "test",
+		Files:  map[string]string{"test.gguf": digest},
+		Parser: "tool_call_format yaml",
+		Stream: &stream,
+	})
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected status code 400, actual %d", w.Code)
+	}
+}
+
 func TestCreateRemovesLayers(t *testing.T) {
 	gin.SetMode(gin.TestMode)
 
@@ -954,3 +984,53 @@ func TestDetectModelTypeFromFiles(t *testing.T) {
 		}
 	})
 }
+
+func TestCreateSourceDigest(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	p := t.TempDir()
+	t.Setenv("OLLAMA_MODELS", p)
+	var s Server
+
+	_, digest := createBinFile(t, nil, nil)
+	req := api.CreateRequest{
+		Model:      "test",
+		Files:      map[string]string{"test.gguf": digest},
+		System:     "You are a test.",
+		License:    []string{"MIT"},
+		Parameters: map[string]any{"temperature": float32(0.7), "num_ctx": 4096, "stop": []string{"USER:"}},
+		Messages:   []api.Message{{Role: "user", Content: "hi"}},
+		Stream:     &stream,
+	}
+
+	w := createRequest(t, s.CreateHandler, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d", w.Code)
+	}
+
+	want, err := req.SourceDigest()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w = createRequest(t, s.ShowHandler, api.ShowRequest{Model: "test"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d", w.Code)
+	}
+
+	var resp api.ShowResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.SourceDigest != want {
+		t.Errorf("source digest: have %s; want %s", resp.SourceDigest, want)
+	}
+
+	w = createRequest(t, s.DeleteHandler, api.DeleteRequest{Model: "test"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d", w.Code)
+	}
+
+	checkFileExists(t, filepath.Join(p, "builds", "*"), []string{})
+}
@@ -708,6 +708,270 @@ func TestGenerateChat(t *testing.T) {
 		}
 	})
 
+	t.Run("tool_choice", func(t *testing.T) {
+		tools := []api.Tool{
+			{
+				Type: "function",
+				Function: api.ToolFunction{
+					Name: "get_weather",
+					Parameters: api.ToolFunctionParameters{
+						Type:     "object",
+						Required: []string{"location"},
+						Properties: map[string]api.ToolProperty{
+							"location": {Type: api.PropertyType{"string"}},
+						},
+					},
+				},
+			},
+			{
+				Type: "function",
+				Function: api.ToolFunction{
+					Name: "get_time",
+					Parameters: api.ToolFunctionParameters{
+						Type: "object",
+					},
+				},
+			},
+		}
+
+		var got llm.CompletionRequest
+		mock.CompletionFn = func(ctx context.Context, r llm.CompletionRequest, fn func(r llm.CompletionResponse)) error {
+			got = r
+			fn(llm.CompletionResponse{
+				Content:    `{"name": "get_weather", "arguments": {"location": "Seattle, WA"}}`,
+				Done:       true,
+				DoneReason: llm.DoneReasonStop,
+			})
+			return nil
+		}
+
+		cases := []struct {
+			name       string
+			tools      []api.Tool
+			toolChoice *api.ToolChoice
+			format     json.RawMessage
+			err        string
+			grammar    []string
+			prompt     string
+		}{
+			{
+				name:       "required",
+				tools:      tools,
+				toolChoice: &api.ToolChoice{Mode: "required"},
+				grammar:    []string{`"\"get_weather\""`, `"\"get_time\""`},
+			},
+			{
+				name:       "function",
+				tools:      tools,
+				toolChoice: &api.ToolChoice{Function: "get_weather"},
+				grammar:    []string{`"\"get_weather\""`},
+			},
+			{
+				name:       "none",
+				tools:      tools,
+				toolChoice: &api.ToolChoice{Mode: "none"},
+				prompt:     "system: You are a helpful assistant.\nuser: What's the weather in Seattle?\n",
+			},
+			{
+				name:       "auto",
+				tools:      tools,
+				toolChoice: &api.ToolChoice{Mode: "auto"},
+			},
+			{
+				name:       "required without tools",
+				toolChoice: &api.ToolChoice{Mode: "required"},
+				err:        "tool_choice requires tools",
+			},
+			{
+				name:       "unknown function",
+				tools:      tools,
+				toolChoice: &api.ToolChoice{Function: "get_date"},
+				err:        `tool_choice function \"get_date\" is not in tools`,
+			},
+			{
+				name:       "required with format",
+				tools:      tools,
+				toolChoice: &api.ToolChoice{Mode: "required"},
+				format:     json.RawMessage(`"json"`),
+				err:        "format can't be used with a required tool_choice",
+			},
+		}
+
+		for _, tt := range cases {
+			t.Run(tt.name, func(t *testing.T) {
+				got = llm.CompletionRequest{}
+				w := createRequest(t, s.ChatHandler, api.ChatRequest{
+					Model:      "test-system",
+					Messages:   []api.Message{{Role: "user", Content: "What's the weather in Seattle?"}},
+					Tools:      tt.tools,
+					ToolChoice: tt.toolChoice,
+					Format:     tt.format,
+					Stream:     &stream,
+				})
+
+				if tt.err != "" {
+					if w.Code != http.StatusBadRequest {
+						t.Fatalf("expected status 400, got %d", w.Code)
+					}
+					if diff := cmp.Diff(w.Body.String(), `{"error":"`+tt.err+`"}`); diff != "" {
+						t.Errorf("mismatch (-got +want):\n%s", diff)
+					}
+					return
+				}
+
+				if w.Code != http.StatusOK {
+					t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+				}
+
+				if len(tt.grammar) == 0 && got.Grammar != "" {
+					t.Errorf("expected no grammar, got %s", got.Grammar)
+				}
+
+				for _, s := range tt.grammar {
+					if !strings.Contains(got.Grammar, s) {
+						t.Errorf("expected grammar to contain %s, got %s", s, got.Grammar)
+					}
+				}
+
+				if tt.prompt != "" && got.Prompt != tt.prompt {
+					t.Errorf("expected prompt %q, got %q", tt.prompt, got.Prompt)
+				}
+			})
+		}
+
+		t.Run("function grammar", func(t *testing.T) {
+			w := createRequest(t, s.ChatHandler, api.ChatRequest{
+				Model:      "test-system",
+				Messages:   []api.Message{{Role: "user", Content: "What's the weather in Seattle?"}},
+				Tools:      tools,
+				ToolChoice: &api.ToolChoice{Function: "get_weather"},
+				Stream:     &stream,
+			})
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+			}
+
+			if strings.Contains(got.Grammar, "get_time") {
+				t.Errorf("expected grammar for get_weather only, got %s", got.Grammar)
+			}
+		})
+	})
+
+	t.Run("strict tools", func(t *testing.T) {
+		tools := []api.Tool{
+			{
+				Type: "function",
+				Function: api.ToolFunction{
+					Name:   "get_forecast",
+					Strict: true,
+					Parameters: api.ToolFunctionParameters{
+						Type:     "object",
+						Required: []string{"location", "days"},
+						Properties: map[string]api.ToolProperty{
+							"location": {Type: api.PropertyType{"string"}},
+							"days":     {Type: api.PropertyType{"integer"}},
+						},
+					},
+				},
+			},
+		}
+
+		cases := []struct {
+			name      string
+			responses []string
+			args      map[string]any
+			errors    []api.ToolCallError
+		}{
+			{
+				name:      "valid",
+				responses: []string{`{"name": "get_forecast", "arguments": {"location": "Seattle, WA", "days": 3}}`},
+				args:      map[string]any{"location": "Seattle, WA", "days": float64(3)},
+			},
+			{
+				name:      "coerced",
+				responses: []string{`{"name": "get_forecast", "arguments": {"location": "Seattle, WA", "days": "3"}}`},
+				args:      map[string]any{"location": "Seattle, WA", "days": float64(3)},
+			},
+			{
+				name: "retried",
+				responses: []string{
+					`{"name": "get_forecast", "arguments": {"location": "Seattle, WA", "days": "three"}}`,
+					`{"name": "get_forecast", "arguments": {"location": "Seattle, WA", "days": 3}}`,
+				},
+				args: map[string]any{"location": "Seattle, WA", "days": float64(3)},
+			},
+			{
+				name: "invalid",
+				responses: []string{
+					`{"name": "get_forecast", "arguments": {"location": "Seattle, WA"}}`,
+					`{"name": "get_forecast", "arguments": {"location": "Seattle, WA", "unit": "celsius"}}`,
+				},
+				args: map[string]any{"location": "Seattle, WA", "unit": "celsius"},
+				errors: []api.ToolCallError{
+					{Path: "/days", Message: "is required"},
+					{Path: "/unit", Message: "isn't a parameter of the function"},
+				},
+			},
+		}
+
+		for _, tt := range cases {
+			t.Run(tt.name, func(t *testing.T) {
+				var requests []llm.CompletionRequest
+				mock.CompletionFn = func(ctx context.Context, r llm.CompletionRequest, fn func(r llm.CompletionResponse)) error {
+					requests = append(requests, r)
+					fn(llm.CompletionResponse{
+						Content:    tt.responses[len(requests)-1],
+						Done:       true,
+						DoneReason: llm.DoneReasonStop,
+					})
+					return nil
+				}
+
+				w := createRequest(t, s.ChatHandler, api.ChatRequest{
+					Model:    "test-system",
+					Messages: []api.Message{{Role: "user", Content: "What's the weather in Seattle this week?"}},
+					Tools:    tools,
+					Stream:   &stream,
+				})
+
+				if w.Code != http.StatusOK {
+					t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+				}
+
+				if len(requests) != len(tt.responses) {
+					t.Fatalf("expected %d completion requests, got %d", len(tt.responses), len(requests))
+				}
+
+				if requests[0].Grammar != "" {
+					t.Errorf("expected no grammar on the first request, got %s", requests[0].Grammar)
+				}
+
+				if len(requests) > 1 && !strings.Contains(requests[1].Grammar, `"\"get_forecast\""`) {
+					t.Errorf("expected the retry to be constrained to get_forecast, got %s", requests[1].Grammar)
+				}
+
+				var resp api.ChatResponse
+				if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+					t.Fatal(err)
+				}
+
+				if len(resp.Message.ToolCalls) != 1 {
+					t.Fatalf("expected 1 tool call, got %d", len(resp.Message.ToolCalls))
+				}
+
+				call := resp.Message.ToolCalls[0]
+				if diff := cmp.Diff(map[string]any(call.Function.Arguments), tt.args); diff != "" {
+					t.Errorf("arguments mismatch (-got +want):\n%s", diff)
+				}
+
+				if diff := cmp.Diff(call.Errors, tt.errors); diff != "" {
+					t.Errorf("errors mismatch (-got +want):\n%s", diff)
+				}
+			})
+		}
+	})
+
 	t.Run("status error non-streaming", func(t *testing.T) {
 		mock.CompletionFn = func(ctx context.Context, r llm.CompletionRequest, fn func(r llm.CompletionResponse)) error {
 			return api.StatusError{
@@ -1261,6 +1525,22 @@ func TestChatWithPromptEndingInThinkTag(t *testing.T) {
 			t.Fatalf("expected status 200, got %d", w.Code)
 		}
 
+		// a model that opens thinking itself isn't known to answer after
+		// thinking, so a format is applied by generating the answer again
+		w = createRequest(t, s.CreateHandler, api.CreateRequest{
+			Model: "test-thinking-optional",
+			Files: map[string]string{"file.gguf": digest},
+			Template: `{{- range .Messages }}
+{{- if eq .Role "user" }}user: {{ .Content }}
+{{ else if eq .Role "assistant" }}assistant: {{ if .Thinking }}<think>{{ .Thinking }}</think>{{ end }}{{ .Content }}
+{{ end }}{{ end }}`,
+			Stream: &stream,
+		})
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d", w.Code)
+		}
+
 		return mock, s
 	}
 
@@ -1406,6 +1686,174 @@ func TestChatWithPromptEndingInThinkTag(t *testing.T) {
 		}
 	})
 
+	t.Run("structured outputs after thinking", func(t *testing.T) {
+		var requests []llm.CompletionRequest
+		mock.CompletionFn = func(ctx context.Context, r llm.CompletionRequest, fn func(r llm.CompletionResponse)) error {
+			requests = append(requests, r)
+			fn(llm.CompletionResponse{
+				Content:    " I am thinking through this problem. </think> {\"answer\":\"42\"}",
+				Done:       true,
+				DoneReason: llm.DoneReasonStop,
+			})
+			return nil
+		}
+		defer func() { mock.CompletionFn = nil }()
+
+		format := json.RawMessage(`{"type":"object","properties":{"answer":{"type":"string"}}}`)
+		streamRequest := false
+		w := createRequest(t, s.ChatHandler, api.ChatRequest{
+			Model:    "test-thinking",
+			Messages: []api.Message{{Role: "user", Content: "Please respond in JSON."}},
+			Think:    &api.ThinkValue{Value: true},
+			Stream:   &streamRequest,
+			Format:   format,
+		})
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d", w.Code)
+		}
+
+		if len(requests) != 1 {
+			t.Fatalf("expected one completion call, got %d", len(requests))
+		}
+
+		if !bytes.Equal(format, requests[0].Format) || requests[0].GrammarTrigger != "</think>" {
+			t.Errorf("expected the format to be applied after </think>, got format %s and trigger %q", requests[0].Format, requests[0].GrammarTrigger)
+		}
+
+		var resp api.ChatResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+
+		if resp.Message.Thinking != "I am thinking through this problem. " || resp.Message.Content != `{"answer":"42"}` {
+			t.Errorf("unexpected thinking %q and content %q", resp.Message.Thinking, resp.Message.Content)
+		}
+	})
+
+	t.Run("tool_choice after thinking", func(t *testing.T) {
+		w := createRequest(t, s.CreateHandler, api.CreateRequest{
+			Model:  "test-thinking-tools",
+			From:   "test-thinking",
+			Parser: "thinking_end </think>\nthinking_open true\ntool_call_start <tool_call>\ntool_call_end </tool_call>",
+			Stream: &stream,
+		})
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
+
+		var requests []llm.CompletionRequest
+		mock.CompletionFn = func(ctx context.Context, r llm.CompletionRequest, fn func(r llm.CompletionResponse)) error {
+			requests = append(requests, r)
+			fn(llm.CompletionResponse{
+				Content:    ` I should look up the weather.</think><tool_call>{"name": "get_weather", "arguments": {"city": "Paris"}}</tool_call>`,
+				Done:       true,
+				DoneReason: llm.DoneReasonStop,
+			})
+			return nil
+		}
+		defer func() { mock.CompletionFn = nil }()
+
+		streamRequest := false
+		w = createRequest(t, s.ChatHandler, api.ChatRequest{
+			Model:      "test-thinking-tools",
+			Messages:   []api.Message{{Role: "user", Content: "What's the weather in Paris?"}},
+			Think:      &api.ThinkValue{Value: true},
+			Stream:     &streamRequest,
+			Tools:      []api.Tool{{Type: "function", Function: api.ToolFunction{Name: "get_weather"}}},
+			ToolChoice: &api.ToolChoice{Mode: "required"},
+		})
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
+
+		if len(requests) != 1 {
+			t.Fatalf("expected one completion call, got %d", len(requests))
+		}
+
+		// the call is constrained once thinking ends, rather than ending it
+		if requests[0].GrammarTrigger != "</think>" || strings.Contains(requests[0].Grammar, "</think>") {
+			t.Errorf("expected the grammar to be applied after </think>, got trigger %q and grammar %s", requests[0].GrammarTrigger, requests[0].Grammar)
+		}
+
+		var resp api.ChatResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+
+		if resp.Message.Thinking != "I should look up the weather." || len(resp.Message.ToolCalls) != 1 {
+			t.Errorf("unexpected thinking %q and tool calls %v", resp.Message.Thinking, resp.Message.ToolCalls)
+		}
+	})
+
+	t.Run("format with tools after thinking", func(t *testing.T) {
+		w := createRequest(t, s.CreateHandler, api.CreateRequest{
+			Model:  "test-thinking-tools",
+			From:   "test-thinking",
+			Parser: "thinking_end </think>\nthinking_open true\ntool_call_start <tool_call>\ntool_call_end </tool_call>",
+			Stream: &stream,
+		})
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
+
+		cases := []struct {
+			name string
+			err  error
+		}{
+			{name: "done"},
+			// a runner that applied a deferred grammar fails a response that
+			// ends before it, which a tool call may
+			{name: "grammar not triggered", err: llm.ErrGrammarNotTriggered},
+		}
+
+		for _, tt := range cases {
+			t.Run(tt.name, func(t *testing.T) {
+				var requests []llm.CompletionRequest
+				mock.CompletionFn = func(ctx context.Context, r llm.CompletionRequest, fn func(r llm.CompletionResponse)) error {
+					requests = append(requests, r)
+					fn(llm.CompletionResponse{Content: ` I should look up the weather.</think><tool_call>{"name": "get_weather", "arguments": {"city": "Paris"}}</tool_call>`})
+					if tt.err != nil {
+						return tt.err
+					}
+					fn(llm.CompletionResponse{Done: true, DoneReason: llm.DoneReasonStop})
+					return nil
+				}
+				defer func() { mock.CompletionFn = nil }()
+
+				streamRequest := false
+				w := createRequest(t, s.ChatHandler, api.ChatRequest{
+					Model:    "test-thinking-tools",
+					Messages: []api.Message{{Role: "user", Content: "What's the weather in Paris?"}},
+					Think:    &api.ThinkValue{Value: true},
+					Stream:   &streamRequest,
+					Tools:    []api.Tool{{Type: "function", Function: api.ToolFunction{Name: "get_weather"}}},
+					Format:   json.RawMessage(`{"type":"object","properties":{"weather":{"type":"string"}}}`),
+				})
+
+				if w.Code != http.StatusOK {
+					t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+				}
+
+				// the format isn't applied after </think>, where it would
+				// prevent the tool call
+				if len(requests) != 1 || requests[0].Format != nil || requests[0].GrammarTrigger != "" {
+					t.Fatalf("expected one unconstrained request, got %+v", requests)
+				}
+
+				var resp api.ChatResponse
+				if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+					t.Fatal(err)
+				}
+
+				if !resp.Done || len(resp.Message.ToolCalls) != 1 || resp.Message.ToolCalls[0].Function.Name != "get_weather" {
+					t.Errorf("expected a call to get_weather, got %+v", resp)
+				}
+			})
+		}
+	})
+
 	t.Run("structured outputs restart non-stream", func(t *testing.T) {
 		var (
 			requestsMu sync.Mutex
@@ -1428,7 +1876,7 @@ func TestChatWithPromptEndingInThinkTag(t *testing.T) {
 			switch callNum {
 			case 1:
 				fn(llm.CompletionResponse{
-					Content:            " I am thinking through this problem. </think> {\"answer\":\"42\"}",
+					Content:            "<think> I am thinking through this problem. </think> {\"answer\":\"42\"}",
 					Done:               false,
 					PromptEvalCount:    1,
 					PromptEvalDuration: 1,
@@ -1461,7 +1909,7 @@ func TestChatWithPromptEndingInThinkTag(t *testing.T) {
 		think := true
 		streamRequest := false
 		w := createRequest(t, s.ChatHandler, api.ChatRequest{
-			Model:    "test-thinking",
+			Model:    "test-thinking-optional",
 			Messages: []api.Message{{Role: "user", Content: "Please respond in JSON."}},
 			Think:    &api.ThinkValue{Value: think},
 			Stream:   &streamRequest,
@@ -1531,7 +1979,7 @@ func TestChatWithPromptEndingInThinkTag(t *testing.T) {
 			switch callNum {
 			case 1:
 				fn(llm.CompletionResponse{
-					Content:            " I am thinking through this problem. </think> {\"answer\":\"42\"}",
+					Content:            "<think> I am thinking through this problem. </think> {\"answer\":\"42\"}",
 					Done:               false,
 					PromptEvalCount:    1,
 					PromptEvalDuration: 1,
@@ -1564,7 +2012,7 @@ func TestChatWithPromptEndingInThinkTag(t *testing.T) {
 		think := true
 		streamRequest := true
 		w := createRequest(t, s.ChatHandler, api.ChatRequest{
-			Model:    "test-thinking",
+			Model:    "test-thinking-optional",
 			Messages: []api.Message{{Role: "user", Content: "Please respond in JSON."}},
 			Think:    &api.ThinkValue{Value: think},
 			Stream:   &streamRequest,
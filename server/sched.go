@@ -481,6 +481,8 @@ iGPUScan:
 		discreteGPUs:    discreteGPUs,
 		vramSize:        llama.VRAMSize(),
 		totalSize:       llama.TotalSize(),
+		kvCacheType:     llama.KVCacheType(),
+		kvCacheSize:     llama.KVCacheSize(),
 		loading:         true,
 		pid:             llama.Pid(),
 	}
@@ -580,6 +582,8 @@ type runnerRef struct {
 	discreteGPUs bool          // True if all devices are discrete GPUs - used to skip VRAM recovery check for iGPUs
 	vramSize     uint64
 	totalSize    uint64
+	kvCacheType  string
+	kvCacheSize  uint64
 
 	sessionDuration time.Duration
 	expireTimer     *time.Timer
@@ -679,6 +679,14 @@ func TestSchedNeedsReload(t *testing.T) {
 	req.opts.NumGPU = -1
 	resp = runner.needsReload(ctx, req)
 	require.False(t, resp)
+	req.opts.RopeScalingType = "yarn"
+	req.opts.RopeScalingFactor = 4
+	resp = runner.needsReload(ctx, req)
+	require.True(t, resp)
+	req.opts.RopeScalingType = ""
+	req.opts.RopeScalingFactor = 0
+	resp = runner.needsReload(ctx, req)
+	require.False(t, resp)
 }
 
 func TestSchedUnloadAllRunners(t *testing.T) {
@@ -748,6 +756,8 @@ type mockLlm struct {
 	vramSize          uint64
 	totalSize         uint64
 	vramByGPU         map[ml.DeviceID]uint64
+	kvCacheType       string
+	kvCacheSize       uint64
 }
 
 func (s *mockLlm) ModelPath() string {
@@ -799,6 +809,8 @@ func (s *mockLlm) Close() error {
 func (s *mockLlm) VRAMSize() uint64                                   { return s.vramSize }
 func (s *mockLlm) TotalSize() uint64                                  { return s.totalSize }
 func (s *mockLlm) VRAMByGPU(id ml.DeviceID) uint64                    { return s.vramByGPU[id] }
+func (s *mockLlm) KVCacheType() string                                { return s.kvCacheType }
+func (s *mockLlm) KVCacheSize() uint64                                { return s.kvCacheSize }
 func (s *mockLlm) Pid() int                                           { return -1 }
 func (s *mockLlm) GetPort() int                                       { return -1 }
 func (s *mockLlm) GetDeviceInfos(ctx context.Context) []ml.DeviceInfo { return nil }
@@ -0,0 +1,456 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/envconfig"
+)
+
+var (
+	errSessionNotFound = errors.New("session not found")
+	errSessionBusy     = errors.New("session is in use by another chat")
+)
+
+// currentSessionSchemaVersion is the version of the session database schema.
+// Increment it when making schema changes that require migrations.
+const currentSessionSchemaVersion = 1
+
+// sessionStore persists the message history of chat sessions in SQLite.
+// SQLite serializes writes, but a chat reads a session's history long before
+// it writes the reply, so chats on the same session must also be kept from
+// running at the same time with acquire and release. Otherwise both would
+// continue the same history and one reply would be lost or interleaved.
+type sessionStore struct {
+	conn *sql.DB
+
+	mu   sync.Mutex
+	busy map[string]bool
+}
+
+func newSessionStore(dbPath string) (*sessionStore, error) {
+	conn, err := sql.Open("sqlite3", dbPath+"?_foreign_keys=on&_journal_mode=WAL&_busy_timeout=5000&_txlock=immediate")
+	if err != nil {
+		return nil, fmt.Errorf("open database: %w", err)
+	}
+
+	if err := conn.Ping(); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("ping database: %w", err)
+	}
+
+	st := &sessionStore{conn: conn, busy: make(map[string]bool)}
+	if err := st.init(); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("initialize database: %w", err)
+	}
+
+	return st, nil
+}
+
+func (st *sessionStore) Close() error {
+	_, _ = st.conn.Exec("PRAGMA wal_checkpoint(TRUNCATE);")
+
+	return st.conn.Close()
+}
+
+func (st *sessionStore) init() error {
+	// messages are stored as the JSON of api.Message so they're sent back to
+	// the model exactly as they were received
+	_, err := st.conn.Exec(`
+	CREATE TABLE IF NOT EXISTS sessions (
+		id TEXT PRIMARY KEY,
+		created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE TABLE IF NOT EXISTS session_messages (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		session_id TEXT NOT NULL,
+		message TEXT NOT NULL,
+		FOREIGN KEY (session_id) REFERENCES sessions(id) ON DELETE CASCADE
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_session_messages_session_id ON session_messages(session_id);
+	`)
+	if err != nil {
+		return err
+	}
+
+	if err := st.migrate(); err != nil {
+		return fmt.Errorf("migrate schema: %w", err)
+	}
+
+	return nil
+}
+
+// migrate upgrades the schema of databases written by older versions. The
+// schema version is kept in SQLite's user_version, which is 0 for databases
+// created before it was set.
+func (st *sessionStore) migrate() error {
+	var version int
+	if err := st.conn.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return fmt.Errorf("get schema version: %w", err)
+	}
+
+	if version > currentSessionSchemaVersion {
+		return fmt.Errorf("schema version %d is newer than the supported version %d", version, currentSessionSchemaVersion)
+	}
+
+	for version < currentSessionSchemaVersion {
+		switch version {
+		case 0:
+			// the first schema is the one created by init, so there's
+			// nothing to change
+			version = 1
+		}
+
+		if _, err := st.conn.Exec(fmt.Sprintf("PRAGMA user_version = %d", version)); err != nil {
+			return fmt.Errorf("set schema version: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// acquire marks the session with id as in use by a chat. It returns
+// errSessionBusy if another chat is using it.
+func (st *sessionStore) acquire(id string) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.busy[id] {
+		return errSessionBusy
+	}
+
+	st.busy[id] = true
+	return nil
+}
+
+// release marks the session with id as no longer in use
+func (st *sessionStore) release(id string) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	delete(st.busy, id)
+}
+
+// create creates a session with msgs as its history
+func (st *sessionStore) create(msgs []api.Message) (*api.Session, error) {
+	now := time.Now().UTC()
+	session := api.Session{
+		ID:        uuid.NewString(),
+		CreatedAt: now,
+		UpdatedAt: now,
+		Messages:  msgs,
+	}
+
+	tx, err := st.conn.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("INSERT INTO sessions (id, created_at, updated_at) VALUES (?, ?, ?)", session.ID, now, now); err != nil {
+		return nil, fmt.Errorf("insert session: %w", err)
+	}
+
+	if err := insertSessionMessages(tx, session.ID, msgs); err != nil {
+		return nil, err
+	}
+
+	return &session, tx.Commit()
+}
+
+// get returns a session with its history
+func (st *sessionStore) get(id string) (*api.Session, error) {
+	var session api.Session
+	err := st.conn.QueryRow("SELECT id, created_at, updated_at FROM sessions WHERE id = ?", id).Scan(&session.ID, &session.CreatedAt, &session.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errSessionNotFound
+	} else if err != nil {
+		return nil, fmt.Errorf("query session: %w", err)
+	}
+
+	rows, err := st.conn.Query("SELECT message FROM session_messages WHERE session_id = ? ORDER BY id", id)
+	if err != nil {
+		return nil, fmt.Errorf("query messages: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var bts []byte
+		if err := rows.Scan(&bts); err != nil {
+			return nil, fmt.Errorf("scan message: %w", err)
+		}
+
+		var msg api.Message
+		if err := json.Unmarshal(bts, &msg); err != nil {
+			return nil, fmt.Errorf("decode message: %w", err)
+		}
+		session.Messages = append(session.Messages, msg)
+	}
+
+	return &session, rows.Err()
+}
+
+// list returns the sessions without their history, most recently updated
+// first
+func (st *sessionStore) list() ([]api.Session, error) {
+	rows, err := st.conn.Query("SELECT id, created_at, updated_at FROM sessions ORDER BY updated_at DESC")
+	if err != nil {
+		return nil, fmt.Errorf("query sessions: %w", err)
+	}
+	defer rows.Close()
+
+	sessions := []api.Session{}
+	for rows.Next() {
+		var session api.Session
+		if err := rows.Scan(&session.ID, &session.CreatedAt, &session.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("scan session: %w", err)
+		}
+		sessions = append(sessions, session)
+	}
+
+	return sessions, rows.Err()
+}
+
+// appendMessages adds msgs to the end of a session's history
+func (st *sessionStore) appendMessages(id string, msgs []api.Message) error {
+	tx, err := st.conn.Begin()
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec("UPDATE sessions SET updated_at = ? WHERE id = ?", time.Now().UTC(), id)
+	if err != nil {
+		return fmt.Errorf("update session: %w", err)
+	}
+
+	if n, err := result.RowsAffected(); err != nil {
+		return fmt.Errorf("update session: %w", err)
+	} else if n == 0 {
+		return errSessionNotFound
+	}
+
+	if err := insertSessionMessages(tx, id, msgs); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// replaceMessages replaces a session's history with msgs
+func (st *sessionStore) replaceMessages(id string, msgs []api.Message) error {
+	tx, err := st.conn.Begin()
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec("UPDATE sessions SET updated_at = ? WHERE id = ?", time.Now().UTC(), id)
+	if err != nil {
+		return fmt.Errorf("update session: %w", err)
+	}
+
+	if n, err := result.RowsAffected(); err != nil {
+		return fmt.Errorf("update session: %w", err)
+	} else if n == 0 {
+		return errSessionNotFound
+	}
+
+	if _, err := tx.Exec("DELETE FROM session_messages WHERE session_id = ?", id); err != nil {
+		return fmt.Errorf("delete messages: %w", err)
+	}
+
+	if err := insertSessionMessages(tx, id, msgs); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// delete deletes a session and its history
+func (st *sessionStore) delete(id string) error {
+	result, err := st.conn.Exec("DELETE FROM sessions WHERE id = ?", id)
+	if err != nil {
+		return fmt.Errorf("delete session: %w", err)
+	}
+
+	if n, err := result.RowsAffected(); err != nil {
+		return fmt.Errorf("delete session: %w", err)
+	} else if n == 0 {
+		return errSessionNotFound
+	}
+
+	return nil
+}
+
+func insertSessionMessages(tx *sql.Tx, id string, msgs []api.Message) error {
+	for _, msg := range msgs {
+		bts, err := json.Marshal(msg)
+		if err != nil {
+			return fmt.Errorf("encode message: %w", err)
+		}
+
+		if _, err := tx.Exec("INSERT INTO session_messages (session_id, message) VALUES (?, ?)", id, bts); err != nil {
+			return fmt.Errorf("insert message: %w", err)
+		}
+	}
+	return nil
+}
+
+// sessionStore opens the session database in the models directory the first
+// time it's used. If it can't be opened, it's opened again the next time.
+func (s *Server) sessionStore() (*sessionStore, error) {
+	s.sessionsMu.Lock()
+	defer s.sessionsMu.Unlock()
+
+	if s.sessions == nil {
+		st, err := newSessionStore(filepath.Join(envconfig.Models(), "sessions.db"))
+		if err != nil {
+			return nil, err
+		}
+		s.sessions = st
+	}
+
+	return s.sessions, nil
+}
+
+// closeSessionStore closes the session database if it was opened
+func (s *Server) closeSessionStore() {
+	s.sessionsMu.Lock()
+	defer s.sessionsMu.Unlock()
+
+	if s.sessions != nil {
+		if err := s.sessions.Close(); err != nil {
+			slog.Warn("failed to close session database", "error", err)
+		}
+		s.sessions = nil
+	}
+}
+
+// recordSession passes the responses of a chat in a session through,
+// appending the chat's new messages and the assistant's reply to the session's
+// history before the final response is sent. If the history was summarized
+// to fit the context length, the summary replaces it instead, so it's not
+// summarized again. history is the session's history followed by msgs.
+func recordSession(st *sessionStore, id string, history, msgs []api.Message, in <-chan any) <-chan any {
+	out := make(chan any)
+	go func() {
+		defer close(out)
+
+		reply := api.Message{Role: "assistant"}
+		for rr := range in {
+			if res, ok := rr.(api.ChatResponse); ok {
+				reply.Thinking += res.Message.Thinking
+				reply.Content += res.Message.Content
+				reply.ToolCalls = append(reply.ToolCalls, res.Message.ToolCalls...)
+
+				if res.Done {
+					var err error
+					if res.Compaction != nil && res.Compaction.Summary != "" {
+						err = st.replaceMessages(id, append(res.Compaction.Apply(history), reply))
+					} else {
+						err = st.appendMessages(id, append(msgs, reply))
+					}
+
+					if err != nil {
+						slog.Error("failed to save session", "session", id, "error", err)
+						rr = gin.H{"error": fmt.Sprintf("failed to save session: %v", err)}
+					}
+				}
+			}
+
+			out <- rr
+		}
+	}()
+	return out
+}
+
+func (s *Server) CreateSessionHandler(c *gin.Context) {
+	var req api.CreateSessionRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	st, err := s.sessionStore()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	session, err := st.create(req.Messages)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, session)
+}
+
+func (s *Server) ListSessionsHandler(c *gin.Context) {
+	st, err := s.sessionStore()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	sessions, err := st.list()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, api.ListSessionsResponse{Sessions: sessions})
+}
+
+func (s *Server) ShowSessionHandler(c *gin.Context) {
+	st, err := s.sessionStore()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	session, err := st.get(c.Param("id"))
+	if errors.Is(err, errSessionNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("session %q not found", c.Param("id"))})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, session)
+}
+
+func (s *Server) DeleteSessionHandler(c *gin.Context) {
+	st, err := s.sessionStore()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := st.delete(c.Param("id")); errors.Is(err, errSessionNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("session %q not found", c.Param("id"))})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, nil)
+}
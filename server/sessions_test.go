@@ -0,0 +1,471 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/fs/ggml"
+	"github.com/ollama/ollama/llm"
+	"github.com/ollama/ollama/ml"
+)
+
+func TestSessionStore(t *testing.T) {
+	st, err := newSessionStore(filepath.Join(t.TempDir(), "sessions.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+
+	a, err := st.create([]api.Message{{Role: "system", Content: "You are a pirate."}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := st.create(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msgs := []api.Message{
+		{Role: "user", Content: "What's the weather?"},
+		{Role: "assistant", Thinking: "I should check.", ToolCalls: []api.ToolCall{{Function: api.ToolCallFunction{Name: "get_weather", Arguments: api.ToolCallFunctionArguments{"city": "Paris"}}}}},
+	}
+	if err := st.appendMessages(a.ID, msgs); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := st.get(a.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := append([]api.Message{{Role: "system", Content: "You are a pirate."}}, msgs...)
+	if diff := cmp.Diff(expected, got.Messages); diff != "" {
+		t.Errorf("messages mismatch (-want +got):\n%s", diff)
+	}
+
+	if got.UpdatedAt.Before(got.CreatedAt) {
+		t.Errorf("expected updated_at %v not to be before created_at %v", got.UpdatedAt, got.CreatedAt)
+	}
+
+	sessions, err := st.list()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(sessions) != 2 || sessions[0].ID != a.ID || sessions[1].ID != b.ID {
+		t.Errorf("expected sessions %s and %s, most recently updated first, got %v", a.ID, b.ID, sessions)
+	}
+
+	if err := st.delete(a.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := st.get(a.ID); !errors.Is(err, errSessionNotFound) {
+		t.Errorf("expected errSessionNotFound, got %v", err)
+	}
+
+	if err := st.appendMessages(a.ID, msgs); !errors.Is(err, errSessionNotFound) {
+		t.Errorf("expected errSessionNotFound, got %v", err)
+	}
+
+	if err := st.delete(a.ID); !errors.Is(err, errSessionNotFound) {
+		t.Errorf("expected errSessionNotFound, got %v", err)
+	}
+
+	// messages of deleted sessions are deleted with them
+	var n int
+	if err := st.conn.QueryRow("SELECT COUNT(*) FROM session_messages").Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+
+	if n != 0 {
+		t.Errorf("expected no messages, got %d", n)
+	}
+}
+
+func TestSessionStoreMigrate(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "sessions.db")
+	st, err := newSessionStore(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	session, err := st.create([]api.Message{{Role: "user", Content: "Hello!"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	version := func() int {
+		t.Helper()
+		var v int
+		if err := st.conn.QueryRow("PRAGMA user_version").Scan(&v); err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+
+	if v := version(); v != currentSessionSchemaVersion {
+		t.Errorf("expected schema version %d, got %d", currentSessionSchemaVersion, v)
+	}
+
+	// databases created before the schema was versioned are upgraded with
+	// their sessions kept
+	if _, err := st.conn.Exec("PRAGMA user_version = 0"); err != nil {
+		t.Fatal(err)
+	}
+	st.Close()
+
+	st, err = newSessionStore(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if v := version(); v != currentSessionSchemaVersion {
+		t.Errorf("expected schema version %d, got %d", currentSessionSchemaVersion, v)
+	}
+
+	if _, err := st.get(session.ID); err != nil {
+		t.Errorf("expected the session to be kept, got %v", err)
+	}
+
+	// databases from newer versions aren't opened
+	if _, err := st.conn.Exec(fmt.Sprintf("PRAGMA user_version = %d", currentSessionSchemaVersion+1)); err != nil {
+		t.Fatal(err)
+	}
+	st.Close()
+
+	if st, err := newSessionStore(p); err == nil {
+		st.Close()
+		t.Error("expected an error opening a newer database")
+	}
+}
+
+// sessionRequest calls fn with the session ID as the route's id parameter
+func sessionRequest(t *testing.T, fn func(*gin.Context), id string, body any) *httptest.ResponseRecorder {
+	t.Helper()
+	return createRequest(t, func(c *gin.Context) {
+		c.Params = gin.Params{{Key: "id", Value: id}}
+		fn(c)
+	}, body)
+}
+
+func TestServerSessionStore(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "models")
+	t.Setenv("OLLAMA_MODELS", dir)
+
+	var s Server
+	if _, err := s.sessionStore(); err == nil {
+		t.Fatal("expected an error without a models directory")
+	}
+
+	// the database is opened again once it can be
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	st, err := s.sessionStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := st.create([]api.Message{{Role: "user", Content: "Hello!"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if again, err := s.sessionStore(); err != nil || again != st {
+		t.Errorf("expected the open database, got %v, %v", again, err)
+	}
+
+	// closing checkpoints the write-ahead log into the database
+	s.closeSessionStore()
+	if fi, err := os.Stat(filepath.Join(dir, "sessions.db-wal")); err == nil && fi.Size() > 0 {
+		t.Errorf("expected an empty write-ahead log, got %d bytes", fi.Size())
+	}
+
+	st, err = s.sessionStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.closeSessionStore()
+
+	if sessions, err := st.list(); err != nil || len(sessions) != 1 {
+		t.Errorf("expected the session to be kept, got %v, %v", sessions, err)
+	}
+}
+
+func TestSessionHandlers(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+	var s Server
+	t.Cleanup(func() {
+		if s.sessions != nil {
+			s.sessions.Close()
+		}
+	})
+
+	w := createRequest(t, s.CreateSessionHandler, api.CreateSessionRequest{
+		Messages: []api.Message{{Role: "system", Content: "You are a pirate."}},
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body)
+	}
+
+	var session api.Session
+	if err := json.NewDecoder(w.Body).Decode(&session); err != nil {
+		t.Fatal(err)
+	}
+
+	if session.ID == "" {
+		t.Fatal("expected a session ID")
+	}
+
+	w = sessionRequest(t, s.ShowSessionHandler, session.ID, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body)
+	}
+
+	var shown api.Session
+	if err := json.NewDecoder(w.Body).Decode(&shown); err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff([]api.Message{{Role: "system", Content: "You are a pirate."}}, shown.Messages); diff != "" {
+		t.Errorf("messages mismatch (-want +got):\n%s", diff)
+	}
+
+	w = createRequest(t, s.ListSessionsHandler, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body)
+	}
+
+	var list api.ListSessionsResponse
+	if err := json.NewDecoder(w.Body).Decode(&list); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(list.Sessions) != 1 || list.Sessions[0].ID != session.ID || len(list.Sessions[0].Messages) != 0 {
+		t.Errorf("expected session %s without messages, got %v", session.ID, list.Sessions)
+	}
+
+	w = sessionRequest(t, s.DeleteSessionHandler, session.ID, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body)
+	}
+
+	for _, fn := range []func(*gin.Context){s.ShowSessionHandler, s.DeleteSessionHandler} {
+		w = sessionRequest(t, fn, session.ID, nil)
+		if w.Code != http.StatusNotFound {
+			t.Errorf("expected status 404, got %d", w.Code)
+		}
+	}
+}
+
+func TestChatSession(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+	mock := mockRunner{
+		CompletionResponse: llm.CompletionResponse{
+			Content:            "Arr, it's sunny.",
+			Done:               true,
+			DoneReason:         llm.DoneReasonStop,
+			PromptEvalCount:    1,
+			PromptEvalDuration: 1,
+			EvalCount:          1,
+			EvalDuration:       1,
+		},
+	}
+
+	s := Server{
+		sched: &Scheduler{
+			pendingReqCh:    make(chan *LlmRequest, 1),
+			finishedReqCh:   make(chan *LlmRequest, 1),
+			expiredCh:       make(chan *runnerRef, 1),
+			unloadedCh:      make(chan any, 1),
+			loaded:          make(map[string]*runnerRef),
+			newServerFn:     newMockServer(&mock),
+			getGpuFn:        getGpuFn,
+			getSystemInfoFn: getSystemInfoFn,
+			waitForRecovery: 250 * time.Millisecond,
+			loadFn: func(req *LlmRequest, _ *ggml.GGML, _ ml.SystemInfo, _ []ml.DeviceInfo, _ bool) bool {
+				req.successCh <- &runnerRef{
+					llama: &mock,
+				}
+				return false
+			},
+		},
+	}
+	t.Cleanup(func() {
+		if s.sessions != nil {
+			s.sessions.Close()
+		}
+	})
+
+	go s.sched.Run(t.Context())
+
+	_, digest := createBinFile(t, ggml.KV{
+		"general.architecture":          "llama",
+		"llama.block_count":             uint32(1),
+		"llama.context_length":          uint32(8192),
+		"llama.embedding_length":        uint32(4096),
+		"llama.attention.head_count":    uint32(32),
+		"llama.attention.head_count_kv": uint32(8),
+		"tokenizer.ggml.tokens":         []string{""},
+		"tokenizer.ggml.scores":         []float32{0},
+		"tokenizer.ggml.token_type":     []int32{0},
+	}, []*ggml.Tensor{
+		{Name: "token_embd.weight", Shape: []uint64{1}, WriterTo: bytes.NewReader(make([]byte, 4))},
+		{Name: "blk.0.attn_norm.weight", Shape: []uint64{1}, WriterTo: bytes.NewReader(make([]byte, 4))},
+		{Name: "blk.0.ffn_down.weight", Shape: []uint64{1}, WriterTo: bytes.NewReader(make([]byte, 4))},
+		{Name: "blk.0.ffn_gate.weight", Shape: []uint64{1}, WriterTo: bytes.NewReader(make([]byte, 4))},
+		{Name: "blk.0.ffn_up.weight", Shape: []uint64{1}, WriterTo: bytes.NewReader(make([]byte, 4))},
+		{Name: "blk.0.ffn_norm.weight", Shape: []uint64{1}, WriterTo: bytes.NewReader(make([]byte, 4))},
+		{Name: "blk.0.attn_k.weight", Shape: []uint64{1}, WriterTo: bytes.NewReader(make([]byte, 4))},
+		{Name: "blk.0.attn_output.weight", Shape: []uint64{1}, WriterTo: bytes.NewReader(make([]byte, 4))},
+		{Name: "blk.0.attn_q.weight", Shape: []uint64{1}, WriterTo: bytes.NewReader(make([]byte, 4))},
+		{Name: "blk.0.attn_v.weight", Shape: []uint64{1}, WriterTo: bytes.NewReader(make([]byte, 4))},
+		{Name: "output.weight", Shape: []uint64{1}, WriterTo: bytes.NewReader(make([]byte, 4))},
+	})
+
+	w := createRequest(t, s.CreateHandler, api.CreateRequest{
+		Model: "test",
+		Files: map[string]string{"file.gguf": digest},
+		Template: `
+{{- range .Messages }}
+{{- .Role }}: {{ .Content }}
+{{ end }}`,
+		Stream: &stream,
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+
+	w = createRequest(t, s.CreateSessionHandler, api.CreateSessionRequest{
+		Messages: []api.Message{{Role: "system", Content: "You are a pirate."}},
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body)
+	}
+
+	var session api.Session
+	if err := json.NewDecoder(w.Body).Decode(&session); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("history is sent and recorded", func(t *testing.T) {
+		for _, tt := range []struct {
+			message string
+			prompt  string
+		}{
+			{
+				message: "What's the weather?",
+				prompt:  "system: You are a pirate.\nuser: What's the weather?\n",
+			},
+			{
+				message: "And tomorrow?",
+				prompt:  "system: You are a pirate.\nuser: What's the weather?\nassistant: Arr, it's sunny.\nuser: And tomorrow?\n",
+			},
+		} {
+			w := createRequest(t, s.ChatHandler, api.ChatRequest{
+				Model:     "test",
+				SessionID: session.ID,
+				Messages:  []api.Message{{Role: "user", Content: tt.message}},
+				Stream:    &stream,
+			})
+			if w.Code != http.StatusOK {
+				t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body)
+			}
+
+			if diff := cmp.Diff(tt.prompt, mock.CompletionRequest.Prompt); diff != "" {
+				t.Errorf("prompt mismatch (-want +got):\n%s", diff)
+			}
+		}
+
+		got, err := s.sessions.get(session.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expected := []api.Message{
+			{Role: "system", Content: "You are a pirate."},
+			{Role: "user", Content: "What's the weather?"},
+			{Role: "assistant", Content: "Arr, it's sunny."},
+			{Role: "user", Content: "And tomorrow?"},
+			{Role: "assistant", Content: "Arr, it's sunny."},
+		}
+		if diff := cmp.Diff(expected, got.Messages); diff != "" {
+			t.Errorf("messages mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("in use", func(t *testing.T) {
+		if err := s.sessions.acquire(session.ID); err != nil {
+			t.Fatal(err)
+		}
+
+		w := createRequest(t, s.ChatHandler, api.ChatRequest{
+			Model:     "test",
+			SessionID: session.ID,
+			Messages:  []api.Message{{Role: "user", Content: "Hello!"}},
+			Stream:    &stream,
+		})
+		if w.Code != http.StatusConflict {
+			t.Errorf("expected status 409, got %d", w.Code)
+		}
+
+		s.sessions.release(session.ID)
+
+		w = createRequest(t, s.ChatHandler, api.ChatRequest{
+			Model:     "test",
+			SessionID: session.ID,
+			Messages:  []api.Message{{Role: "user", Content: "Hello!"}},
+			Stream:    &stream,
+		})
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body)
+		}
+
+		// the session is released once the reply is recorded
+		if err := s.sessions.acquire(session.ID); err != nil {
+			t.Errorf("expected the session to be released, got %v", err)
+		}
+		s.sessions.release(session.ID)
+	})
+
+	t.Run("unknown session", func(t *testing.T) {
+		w := createRequest(t, s.ChatHandler, api.ChatRequest{
+			Model:     "test",
+			SessionID: "unknown",
+			Messages:  []api.Message{{Role: "user", Content: "Hello!"}},
+			Stream:    &stream,
+		})
+		if w.Code != http.StatusNotFound {
+			t.Errorf("expected status 404, got %d", w.Code)
+		}
+	})
+
+	t.Run("missing messages", func(t *testing.T) {
+		w := createRequest(t, s.ChatHandler, api.ChatRequest{
+			Model:     "test",
+			SessionID: session.ID,
+			Stream:    &stream,
+		})
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("expected status 400, got %d", w.Code)
+		}
+	})
+}
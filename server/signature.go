@@ -0,0 +1,419 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"slices"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/auth"
+	"github.com/ollama/ollama/envconfig"
+	"github.com/ollama/ollama/server/internal/cache/blob"
+	"github.com/ollama/ollama/types/model"
+)
+
+const mediaTypeSignature = "application/vnd.ollama.image.signature"
+
+var (
+	errUnsigned         = errors.New("model is not signed")
+	errInvalidSignature = errors.New("invalid model signature")
+	errUntrustedSigner  = errors.New("model is not signed by a trusted key")
+)
+
+// maxSignatureSize limits the size of signatures read from a registry
+const maxSignatureSize = 64 << 10
+
+// manifestSignature is a detached signature of the digest of a manifest and
+// the name of the model it was pushed as. It is pushed alongside the manifest
+// it signs with the tag returned by signatureTag, and kept locally as a layer
+// of the signed model.
+type manifestSignature struct {
+	Name   string `json:"name"`
+	Digest string `json:"digest"`
+	// Manifest is the signed manifest as it was pushed, so that the
+	// signature can be checked against local copies of the manifest, which
+	// aren't kept byte for byte
+	Manifest  []byte `json:"manifest"`
+	Signature string `json:"signature"`
+}
+
+// signatureTag returns the tag the signature of the manifest with digest is
+// pushed with
+func signatureTag(digest string) string {
+	return strings.Replace(digest, ":", "-", 1) + ".sig"
+}
+
+func manifestDigest(b []byte) string {
+	sum := sha256.Sum256(b)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// signedName returns the name models named n are signed with. Tags aren't
+// part of it, as every tag of a manifest shares its signature.
+func signedName(n model.Name) string {
+	return strings.ToLower(n.Host + "/" + n.Namespace + "/" + n.Model)
+}
+
+// signedPayload returns the data signed by a signature of the manifest with
+// digest pushed as name
+func signedPayload(name, digest string) []byte {
+	return []byte(name + "@" + digest)
+}
+
+// signManifest signs the manifest data pushed as n
+func signManifest(ctx context.Context, n model.Name, data []byte) (*manifestSignature, error) {
+	name, digest := signedName(n), manifestDigest(data)
+	signature, err := auth.Sign(ctx, signedPayload(name, digest))
+	if err != nil {
+		return nil, err
+	}
+
+	return &manifestSignature{Name: name, Digest: digest, Manifest: data, Signature: signature}, nil
+}
+
+// verify checks that s is a valid signature of the manifest of n with digest
+// and returns the key that made it
+func (s *manifestSignature) verify(n model.Name, digest string) (ssh.PublicKey, error) {
+	if s.Digest != digest || manifestDigest(s.Manifest) != digest {
+		return nil, fmt.Errorf("%w: signature is for %s, not %s", errInvalidSignature, s.Digest, digest)
+	}
+
+	if name := signedName(n); s.Name != name {
+		return nil, fmt.Errorf("%w: signature is for %s, not %s", errInvalidSignature, s.Name, name)
+	}
+
+	key, err := auth.Verify(signedPayload(s.Name, s.Digest), s.Signature)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errInvalidSignature, err)
+	}
+
+	return key, nil
+}
+
+// trustPolicy holds the public keys trusted to sign models in each
+// namespace. It is read from a file with one entry per line:
+//
+//	<host>/<namespace> <public key>
+//
+// where the public key is in authorized_keys format and the namespace may
+// contain wildcards as understood by [path.Match]. Models in a namespace with
+// trusted keys must be signed by one of them. Models in other namespaces
+// don't need to be signed, and their signatures aren't fetched when they're
+// pulled.
+type trustPolicy []trustEntry
+
+type trustEntry struct {
+	pattern string
+	key     ssh.PublicKey
+}
+
+func loadTrustPolicy() (trustPolicy, error) {
+	f, err := os.Open(envconfig.TrustedKeys())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return parseTrustPolicy(f)
+}
+
+func parseTrustPolicy(r io.Reader) (trustPolicy, error) {
+	var p trustPolicy
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		pattern, rest, ok := strings.Cut(line, " ")
+		if !ok {
+			return nil, fmt.Errorf("trusted keys line %d: missing public key", n)
+		}
+
+		pattern = strings.ToLower(pattern)
+		if _, err := path.Match(pattern, ""); err != nil || strings.Count(pattern, "/") != 1 {
+			return nil, fmt.Errorf("trusted keys line %d: invalid namespace %q", n, pattern)
+		}
+
+		key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(strings.TrimSpace(rest)))
+		if err != nil {
+			return nil, fmt.Errorf("trusted keys line %d: %w", n, err)
+		}
+
+		p = append(p, trustEntry{pattern, key})
+	}
+
+	return p, scanner.Err()
+}
+
+// keys returns the keys trusted to sign n
+func (p trustPolicy) keys(n model.Name) (keys []ssh.PublicKey) {
+	namespace := strings.ToLower(n.Host + "/" + n.Namespace)
+	for _, e := range p {
+		if ok, _ := path.Match(e.pattern, namespace); ok {
+			keys = append(keys, e.key)
+		}
+	}
+
+	return keys
+}
+
+// trusted reports whether key is trusted to sign n
+func (p trustPolicy) trusted(n model.Name, key ssh.PublicKey) bool {
+	return slices.ContainsFunc(p.keys(n), func(k ssh.PublicKey) bool {
+		return bytes.Equal(k.Marshal(), key.Marshal())
+	})
+}
+
+// verify checks the signature of the manifest of n with digest against the
+// policy. sig is nil if the manifest is unsigned. It returns the key that
+// signed the manifest, if any.
+func (p trustPolicy) verify(n model.Name, digest string, sig *manifestSignature) (ssh.PublicKey, error) {
+	required := len(p.keys(n)) > 0
+	if sig == nil {
+		if required {
+			return nil, fmt.Errorf("%s: %w", n.DisplayShortest(), errUnsigned)
+		}
+		return nil, nil
+	}
+
+	key, err := sig.verify(n, digest)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", n.DisplayShortest(), err)
+	}
+
+	if required && !p.trusted(n, key) {
+		return nil, fmt.Errorf("%s: %w: signed by %s", n.DisplayShortest(), errUntrustedSigner, ssh.FingerprintSHA256(key))
+	}
+
+	return key, nil
+}
+
+// verifyPulledManifest checks the signature of the manifest of mp with
+// digest against the trust policy. It returns the signature data, or nil if
+// the manifest is unsigned or doesn't need to be signed.
+func verifyPulledManifest(ctx context.Context, mp ModelPath, digest string, regOpts *registryOptions) ([]byte, error) {
+	policy, err := loadTrustPolicy()
+	if err != nil {
+		return nil, err
+	}
+
+	n := model.ParseName(mp.GetFullTagname())
+	if len(policy.keys(n)) == 0 {
+		return nil, nil
+	}
+
+	b, err := pullManifestSignature(ctx, mp, digest, regOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	var sig *manifestSignature
+	if b != nil {
+		sig = new(manifestSignature)
+		if err := json.Unmarshal(b, sig); err != nil {
+			return nil, fmt.Errorf("%w: %w", errInvalidSignature, err)
+		}
+	}
+
+	if _, err := policy.verify(n, digest, sig); err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+// requiresSignature reports whether the model named name must be signed. It
+// is used with [ollama.Registry] to skip fetching signatures that aren't
+// checked.
+func requiresSignature(name string) bool {
+	policy, err := loadTrustPolicy()
+	if err != nil {
+		// the error is reported by verifyRegistrySignature
+		return true
+	}
+
+	return len(policy.keys(model.ParseName(name))) > 0
+}
+
+// verifyRegistrySignature is like verifyPulledManifest for models pulled
+// with [ollama.Registry].
+func verifyRegistrySignature(name string, digest blob.Digest, signature []byte) error {
+	policy, err := loadTrustPolicy()
+	if err != nil {
+		return err
+	}
+
+	var sig *manifestSignature
+	if signature != nil {
+		sig = new(manifestSignature)
+		if err := json.Unmarshal(signature, sig); err != nil {
+			return fmt.Errorf("%w: %w", errInvalidSignature, err)
+		}
+	}
+
+	_, err = policy.verify(model.ParseName(name), digest.String(), sig)
+	return err
+}
+
+// pullManifestSignature fetches the signature of the manifest of mp with
+// digest from the registry. It returns nil if the manifest is unsigned.
+func pullManifestSignature(ctx context.Context, mp ModelPath, digest string, regOpts *registryOptions) ([]byte, error) {
+	requestURL := mp.BaseURL().JoinPath("v2", mp.GetNamespaceRepository(), "manifests", signatureTag(digest))
+
+	headers := make(http.Header)
+	headers.Set("Accept", "application/vnd.docker.distribution.manifest.v2+json")
+	resp, err := makeRequestWithRetry(ctx, http.MethodGet, requestURL, headers, nil, regOpts)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var m Manifest
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxSignatureSize)).Decode(&m); err != nil {
+		return nil, fmt.Errorf("%w: %w", errInvalidSignature, err)
+	}
+
+	// a manifest without a signature doesn't sign anything, which is no
+	// different from not having a signature at all
+	i := slices.IndexFunc(m.Layers, func(l Layer) bool { return l.MediaType == mediaTypeSignature })
+	if i < 0 {
+		return nil, nil
+	}
+
+	requestURL = mp.BaseURL().JoinPath("v2", mp.GetNamespaceRepository(), "blobs", m.Layers[i].Digest)
+	resp, err = makeRequestWithRetry(ctx, http.MethodGet, requestURL, nil, nil, regOpts)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	b, err := io.ReadAll(io.LimitReader(resp.Body, maxSignatureSize))
+	if err != nil {
+		return nil, err
+	}
+
+	if manifestDigest(b) != m.Layers[i].Digest {
+		return nil, fmt.Errorf("%w: %w", errInvalidSignature, errDigestMismatch)
+	}
+
+	return b, nil
+}
+
+// pushManifestSignature signs the manifest data pushed as mp and pushes the
+// signature to the registry. It returns the signature layer.
+func pushManifestSignature(ctx context.Context, mp ModelPath, data []byte, regOpts *registryOptions, fn func(api.ProgressResponse)) (Layer, error) {
+	fn(api.ProgressResponse{Status: "signing manifest"})
+
+	sig, err := signManifest(ctx, model.ParseName(mp.GetFullTagname()), data)
+	if err != nil {
+		return Layer{}, err
+	}
+
+	b, err := json.Marshal(sig)
+	if err != nil {
+		return Layer{}, err
+	}
+
+	layer, err := NewLayer(bytes.NewReader(b), mediaTypeSignature)
+	if err != nil {
+		return Layer{}, err
+	}
+
+	if err := uploadBlob(ctx, mp, layer, regOpts, fn); err != nil {
+		return Layer{}, err
+	}
+
+	if _, err := pushManifest(ctx, mp, signatureTag(sig.Digest), &Manifest{
+		SchemaVersion: 2,
+		MediaType:     "application/vnd.docker.distribution.manifest.v2+json",
+		// registries expect manifests to have a config
+		Config: layer,
+		Layers: []Layer{layer},
+	}, regOpts); err != nil {
+		return Layer{}, err
+	}
+
+	return layer, nil
+}
+
+// withoutSignatures returns the layers of m that are not signatures, which
+// are the layers that were pushed to the registry
+func withoutSignatures(layers []Layer) []Layer {
+	return slices.DeleteFunc(slices.Clone(layers), func(l Layer) bool {
+		return l.MediaType == mediaTypeSignature
+	})
+}
+
+// manifestSigner returns the signer of the local manifest m, verifying the
+// signature kept with it. It returns nil if m is unsigned.
+func manifestSigner(m *Manifest) (*api.ModelSigner, error) {
+	i := slices.IndexFunc(m.Layers, func(l Layer) bool { return l.MediaType == mediaTypeSignature })
+	if i < 0 {
+		return nil, nil
+	}
+
+	p, err := GetBlobsPath(m.Layers[i].Digest)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := os.ReadFile(p)
+	if err != nil {
+		return nil, err
+	}
+
+	var sig manifestSignature
+	if err := json.Unmarshal(b, &sig); err != nil {
+		return nil, err
+	}
+
+	// models may be copied to other names, so the signature is checked
+	// for the name it was made for
+	signedAs := model.ParseName(sig.Name)
+	key, err := sig.verify(signedAs, sig.Digest)
+	if err != nil {
+		return nil, err
+	}
+
+	// the signature applies to m if it has the layers of the signed
+	// manifest
+	var signed Manifest
+	if err := json.Unmarshal(sig.Manifest, &signed); err != nil {
+		return nil, fmt.Errorf("%w: %w", errInvalidSignature, err)
+	}
+
+	sameLayer := func(a, b Layer) bool { return a.MediaType == b.MediaType && a.Digest == b.Digest }
+	if !sameLayer(signed.Config, m.Config) || !slices.EqualFunc(signed.Layers, withoutSignatures(m.Layers), sameLayer) {
+		return nil, fmt.Errorf("%w: signature is for a different manifest", errInvalidSignature)
+	}
+
+	policy, err := loadTrustPolicy()
+	if err != nil {
+		return nil, err
+	}
+
+	return &api.ModelSigner{
+		PublicKey:   strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key))),
+		Fingerprint: ssh.FingerprintSHA256(key),
+		Trusted:     policy.trusted(signedAs, key),
+	}, nil
+}
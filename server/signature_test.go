@@ -0,0 +1,347 @@
+package server
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/types/model"
+)
+
+// testRegistry is a minimal in-memory registry that models can be pushed
+// to and pulled from
+type testRegistry struct {
+	mu        sync.Mutex
+	blobs     map[string][]byte
+	manifests map[string][]byte
+	uploads   map[string]*bytes.Buffer
+
+	// failSignatures makes requests for signatures fail
+	failSignatures bool
+}
+
+func newTestRegistry(t *testing.T) (*testRegistry, string) {
+	t.Helper()
+
+	r := &testRegistry{
+		blobs:     make(map[string][]byte),
+		manifests: make(map[string][]byte),
+		uploads:   make(map[string]*bytes.Buffer),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("HEAD /v2/{namespace}/{model}/blobs/{digest}", r.getBlob)
+	mux.HandleFunc("GET /v2/{namespace}/{model}/blobs/{digest}", r.getBlob)
+	mux.HandleFunc("POST /v2/{namespace}/{model}/blobs/uploads/", func(w http.ResponseWriter, req *http.Request) {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		id := fmt.Sprint(len(r.uploads))
+		r.uploads[id] = new(bytes.Buffer)
+		w.Header().Set("Location", "http://"+req.Host+"/uploads/"+id)
+		w.WriteHeader(http.StatusAccepted)
+	})
+	mux.HandleFunc("PATCH /uploads/{id}", func(w http.ResponseWriter, req *http.Request) {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		io.Copy(r.uploads[req.PathValue("id")], req.Body)
+		w.Header().Set("Location", "http://"+req.Host+req.URL.Path)
+		w.WriteHeader(http.StatusAccepted)
+	})
+	mux.HandleFunc("PUT /uploads/{id}", func(w http.ResponseWriter, req *http.Request) {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.blobs[req.URL.Query().Get("digest")] = r.uploads[req.PathValue("id")].Bytes()
+		w.WriteHeader(http.StatusCreated)
+	})
+	mux.HandleFunc("PUT /v2/{namespace}/{model}/manifests/{tag}", func(w http.ResponseWriter, req *http.Request) {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		b, _ := io.ReadAll(req.Body)
+		r.manifests[req.PathValue("namespace")+"/"+req.PathValue("model")+":"+req.PathValue("tag")] = b
+		w.WriteHeader(http.StatusCreated)
+	})
+	mux.HandleFunc("GET /v2/{namespace}/{model}/manifests/{tag}", func(w http.ResponseWriter, req *http.Request) {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		if r.failSignatures && strings.HasSuffix(req.PathValue("tag"), ".sig") {
+			http.Error(w, "unavailable", http.StatusBadRequest)
+			return
+		}
+
+		b, ok := r.manifests[req.PathValue("namespace")+"/"+req.PathValue("model")+":"+req.PathValue("tag")]
+		if !ok {
+			http.NotFound(w, req)
+			return
+		}
+		w.Write(b)
+	})
+
+	s := httptest.NewServer(mux)
+	t.Cleanup(s.Close)
+
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return r, u.Host
+}
+
+func (r *testRegistry) getBlob(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	b, ok := r.blobs[req.PathValue("digest")]
+	if !ok {
+		http.NotFound(w, req)
+		return
+	}
+	w.Header().Set("Content-Length", fmt.Sprint(len(b)))
+	w.Write(b)
+}
+
+// newTestKey writes a new key to $HOME/.ollama/id_ed25519 and returns its
+// public key in authorized_keys format
+func newTestKey(t *testing.T) string {
+	t.Helper()
+
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("USERPROFILE", os.Getenv("HOME"))
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	block, err := ssh.MarshalPrivateKey(priv, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.MkdirAll(filepath.Join(os.Getenv("HOME"), ".ollama"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(filepath.Join(os.Getenv("HOME"), ".ollama", "id_ed25519"), pem.EncodeToMemory(block), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	sshPub, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return strings.TrimSpace(string(ssh.MarshalAuthorizedKey(sshPub)))
+}
+
+func writeTrustPolicy(t *testing.T, policy string) {
+	t.Helper()
+
+	p := filepath.Join(t.TempDir(), "trusted_keys")
+	if err := os.WriteFile(p, []byte(policy), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("OLLAMA_TRUSTED_KEYS", p)
+}
+
+func TestParseTrustPolicy(t *testing.T) {
+	key := newTestKey(t)
+	other := newTestKey(t)
+
+	p, err := parseTrustPolicy(strings.NewReader(fmt.Sprintf(`
+# comment
+registry.ollama.ai/library %s
+Example.com/* %s
+`, key, other)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name string
+		want int
+	}{
+		{"llama3", 1},
+		{"registry.ollama.ai/other/model", 0},
+		{"example.com/any/model", 1},
+		{"EXAMPLE.COM/Any/model", 1},
+		{"other.com/any/model", 0},
+	}
+
+	for _, tt := range cases {
+		if got := len(p.keys(model.ParseName(tt.name))); got != tt.want {
+			t.Errorf("keys(%q) = %d; want %d", tt.name, got, tt.want)
+		}
+	}
+
+	for _, bad := range []string{
+		"registry.ollama.ai/library",
+		"registry.ollama.ai " + key,
+		"registry.ollama.ai/library/model " + key,
+		"registry.ollama.ai/[ " + key,
+		"registry.ollama.ai/library ssh-ed25519 invalid",
+	} {
+		if _, err := parseTrustPolicy(strings.NewReader(bad)); err == nil {
+			t.Errorf("parseTrustPolicy(%q) succeeded; want error", bad)
+		}
+	}
+}
+
+func TestPushPullSigned(t *testing.T) {
+	key := newTestKey(t)
+	t.Setenv("OLLAMA_TRUSTED_KEYS", filepath.Join(t.TempDir(), "missing"))
+
+	r, host := newTestRegistry(t)
+	fn := func(api.ProgressResponse) {}
+
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+	createBundleTestModel(t, host+"/library/signed", "signed model")
+	createBundleTestModel(t, host+"/library/unsigned", "unsigned model")
+
+	if err := PushModel(t.Context(), host+"/library/signed", &registryOptions{Insecure: true, Sign: true}, fn); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := PushModel(t.Context(), host+"/library/unsigned", &registryOptions{Insecure: true}, fn); err != nil {
+		t.Fatal(err)
+	}
+
+	// the signature is kept with the pushed model
+	n := model.ParseName(host + "/library/signed")
+	m, err := ParseNamedManifest(n)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signer, err := manifestSigner(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if signer == nil || signer.PublicKey != key || signer.Trusted {
+		t.Errorf("signer = %+v; want untrusted %s", signer, key)
+	}
+
+	pull := func(t *testing.T, name string) error {
+		t.Helper()
+		t.Setenv("OLLAMA_MODELS", t.TempDir())
+		return PullModel(t.Context(), host+"/"+name, &registryOptions{Insecure: true}, fn)
+	}
+
+	t.Run("trusted", func(t *testing.T) {
+		writeTrustPolicy(t, host+"/library "+key)
+		if err := pull(t, "library/signed"); err != nil {
+			t.Fatal(err)
+		}
+
+		m, err := ParseNamedManifest(n)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		signer, err := manifestSigner(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if signer == nil || !signer.Trusted {
+			t.Errorf("signer = %+v; want trusted", signer)
+		}
+	})
+
+	t.Run("no policy", func(t *testing.T) {
+		if err := pull(t, "library/unsigned"); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("unsigned", func(t *testing.T) {
+		writeTrustPolicy(t, host+"/library "+key)
+		if err := pull(t, "library/unsigned"); !errors.Is(err, errUnsigned) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("untrusted", func(t *testing.T) {
+		writeTrustPolicy(t, host+"/* "+newTestKey(t))
+		if err := pull(t, "library/signed"); !errors.Is(err, errUntrustedSigner) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("signatures unavailable", func(t *testing.T) {
+		r.mu.Lock()
+		r.failSignatures = true
+		r.mu.Unlock()
+		t.Cleanup(func() {
+			r.mu.Lock()
+			r.failSignatures = false
+			r.mu.Unlock()
+		})
+
+		// signatures aren't fetched for models that don't need them
+		if err := pull(t, "library/signed"); err != nil {
+			t.Fatal(err)
+		}
+
+		writeTrustPolicy(t, host+"/library "+key)
+		if err := pull(t, "library/signed"); err == nil {
+			t.Fatal("expected an error")
+		}
+	})
+
+	t.Run("renamed", func(t *testing.T) {
+		writeTrustPolicy(t, host+"/* "+key)
+
+		// push the signed manifest and its signature under another name
+		r.mu.Lock()
+		signed := r.manifests["library/signed:latest"]
+		tag := signatureTag(manifestDigest(signed))
+		r.manifests["other/signed:latest"] = signed
+		r.manifests["other/signed:"+tag] = r.manifests["library/signed:"+tag]
+		r.mu.Unlock()
+
+		if err := pull(t, "other/signed"); !errors.Is(err, errInvalidSignature) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("mis-signed", func(t *testing.T) {
+		writeTrustPolicy(t, host+"/library "+key)
+
+		// move the signature to a different manifest
+		r.mu.Lock()
+		signed := r.manifests["library/signed:latest"]
+		unsigned := r.manifests["library/unsigned:latest"]
+		r.manifests["library/unsigned:"+signatureTag(manifestDigest(unsigned))] = r.manifests["library/signed:"+signatureTag(manifestDigest(signed))]
+		r.mu.Unlock()
+
+		if err := pull(t, "library/unsigned"); !errors.Is(err, errInvalidSignature) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		ms, err := Manifests(false)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(ms) != 0 {
+			t.Errorf("manifests were written: %v", ms)
+		}
+	})
+}
@@ -0,0 +1,110 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/llama"
+	"github.com/ollama/ollama/llm"
+	"github.com/ollama/ollama/model/parsers"
+)
+
+// templateToolCallSyntax returns the syntax of tool calls for models without
+// a built-in parser, where tool calls are JSON objects after the tag found in
+// the model's template
+func templateToolCallSyntax(tag string, tools []api.Tool) []parsers.ToolCallSyntax {
+	switch tag {
+	case "{":
+		return parsers.JSONToolCallSyntax("", "", "arguments", tools)
+	case "[":
+		return parsers.JSONToolCallSyntax("[", "]", "arguments", tools)
+	default:
+		return parsers.JSONToolCallSyntax(tag, "", "arguments", tools)
+	}
+}
+
+// toolCallGrammar returns a grammar that matches a single tool call written
+// in one of syntax
+func toolCallGrammar(syntax []parsers.ToolCallSyntax) (string, error) {
+	if len(syntax) == 0 {
+		return "", errors.New("no tool call syntax")
+	}
+
+	var root, rules strings.Builder
+	root.WriteString("root ::= ")
+	for i, s := range syntax {
+		g := llama.SchemaToGrammar(s.Schema)
+		if g == nil {
+			return "", fmt.Errorf("invalid JSON schema for tool call: %s", s.Schema)
+		}
+
+		// each schema's grammar has its own root and rules named after its
+		// properties, so they're namespaced to be combined
+		namespace := fmt.Sprintf("call%d-", i)
+		rules.WriteString(namespaceGrammar(string(g), namespace))
+		rules.WriteString("\n")
+
+		if i > 0 {
+			root.WriteString(" | ")
+		}
+		root.WriteString("(")
+		if s.Prefix != "" {
+			root.WriteString(llm.GrammarLiteral(s.Prefix) + ` [ \n]? `)
+		}
+		root.WriteString(namespace + "root")
+		if s.Suffix != "" {
+			root.WriteString(" " + llm.GrammarLiteral(s.Suffix))
+		}
+		root.WriteString(")")
+	}
+
+	return root.String() + "\n" + rules.String(), nil
+}
+
+// namespaceGrammar prefixes the name of every rule in the GBNF grammar g with
+// namespace, leaving literals, character classes, repetitions and comments
+// as they are
+func namespaceGrammar(g, namespace string) string {
+	isNameChar := func(c byte) bool {
+		return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_'
+	}
+
+	// skip returns the index after the end of the token starting at i that
+	// ends with end, handling escapes
+	skip := func(i int, end byte) int {
+		for i++; i < len(g) && g[i] != end; i++ {
+			if g[i] == '\\' {
+				i++
+			}
+		}
+		return min(i+1, len(g))
+	}
+
+	var sb strings.Builder
+	for i := 0; i < len(g); {
+		var j int
+		switch c := g[i]; {
+		case c == '"':
+			j = skip(i, '"')
+		case c == '[':
+			j = skip(i, ']')
+		case c == '{':
+			j = skip(i, '}')
+		case c == '#':
+			j = skip(i, '\n')
+		case isNameChar(c):
+			for j = i; j < len(g) && isNameChar(g[j]); j++ {
+			}
+			sb.WriteString(namespace)
+		default:
+			j = i + 1
+		}
+
+		sb.WriteString(g[i:j])
+		i = j
+	}
+
+	return sb.String()
+}
@@ -0,0 +1,157 @@
+package server
+
+import (
+	"math"
+	"testing"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/llama"
+	"github.com/ollama/ollama/model/parsers"
+)
+
+// grammarAccepts reports whether grammar matches all of s, feeding it one
+// rune at a time
+func grammarAccepts(t *testing.T, grammar, s string) bool {
+	t.Helper()
+
+	runes := []rune(s)
+	ids := map[rune]uint32{}
+	vocabIds := []uint32{0}
+	vocabValues := []string{"</s>"}
+	for _, r := range runes {
+		if _, ok := ids[r]; !ok {
+			ids[r] = uint32(len(vocabIds))
+			vocabIds = append(vocabIds, ids[r])
+			vocabValues = append(vocabValues, string(r))
+		}
+	}
+
+	g := llama.NewGrammar(grammar, vocabIds, vocabValues, []int32{0})
+	if g == nil {
+		t.Fatalf("invalid grammar:\n%s", grammar)
+	}
+	defer g.Free()
+
+	allowed := func(id uint32) bool {
+		tokens := []llama.TokenData{{ID: int32(id), Logit: 1}}
+		g.Apply(tokens)
+		return !math.IsInf(float64(tokens[0].Logit), -1)
+	}
+
+	for _, r := range runes {
+		if !allowed(ids[r]) {
+			return false
+		}
+		g.Accept(int32(ids[r]))
+	}
+
+	return allowed(0)
+}
+
+func TestToolCallGrammar(t *testing.T) {
+	tools := []api.Tool{
+		{
+			Type: "function",
+			Function: api.ToolFunction{
+				Name: "get_weather",
+				Parameters: api.ToolFunctionParameters{
+					Type:     "object",
+					Required: []string{"city"},
+					Properties: map[string]api.ToolProperty{
+						"city": {Type: api.PropertyType{"string"}},
+					},
+				},
+			},
+		},
+		{
+			Type: "function",
+			Function: api.ToolFunction{
+				Name: "get_time",
+				Parameters: api.ToolFunctionParameters{
+					Type:     "object",
+					Required: []string{"city"},
+					Properties: map[string]api.ToolProperty{
+						"city": {Type: api.PropertyType{"integer"}},
+					},
+				},
+			},
+		},
+	}
+
+	grammar, err := toolCallGrammar(parsers.JSONToolCallSyntax("<tool_call>", "</tool_call>", "arguments", tools))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		input  string
+		accept bool
+	}{
+		{`<tool_call>{"name": "get_weather", "arguments": {"city": "Paris"}}</tool_call>`, true},
+		{"<tool_call>\n{\"name\": \"get_time\", \"arguments\": {\"city\": 75}}\n</tool_call>", true},
+		{`<tool_call>{"name": "get_time", "arguments": {"city": "Paris"}}</tool_call>`, false},
+		{`<tool_call>{"name": "get_date", "arguments": {"city": "Paris"}}</tool_call>`, false},
+		{`<tool_call>{"name": "get_weather", "arguments": {}}</tool_call>`, false},
+		{`{"name": "get_weather", "arguments": {"city": "Paris"}}`, false},
+		{`The weather in Paris is sunny.`, false},
+	}
+
+	for _, tt := range cases {
+		if accept := grammarAccepts(t, grammar, tt.input); accept != tt.accept {
+			t.Errorf("grammar accepts %q = %v, want %v", tt.input, accept, tt.accept)
+		}
+	}
+}
+
+func TestToolCallGrammarPerToolPrefix(t *testing.T) {
+	syntax := []parsers.ToolCallSyntax{
+		{Prefix: "<｜tool▁call▁begin｜>get_weather<｜tool▁sep｜>", Schema: []byte(`{"type":"object","properties":{"city":{"type":"string"}},"required":["city"]}`), Suffix: "<｜tool▁call▁end｜>"},
+		{Prefix: "<｜tool▁call▁begin｜>get_time<｜tool▁sep｜>", Schema: []byte(`{"type":"object"}`), Suffix: "<｜tool▁call▁end｜>"},
+	}
+
+	grammar, err := toolCallGrammar(syntax)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, s := range []string{
+		`<｜tool▁call▁begin｜>get_weather<｜tool▁sep｜>{"city": "Paris"}<｜tool▁call▁end｜>`,
+		`<｜tool▁call▁begin｜>get_time<｜tool▁sep｜>{}<｜tool▁call▁end｜>`,
+	} {
+		if !grammarAccepts(t, grammar, s) {
+			t.Errorf("expected grammar to accept %q", s)
+		}
+	}
+
+	if grammarAccepts(t, grammar, `<｜tool▁call▁begin｜>get_weather<｜tool▁sep｜>{}<｜tool▁call▁end｜>`) {
+		t.Error("expected grammar to reject a call without required arguments")
+	}
+}
+
+func TestToolCallGrammarEscapesPrefix(t *testing.T) {
+	// control characters in a tag are escaped in the grammar
+	syntax := []parsers.ToolCallSyntax{{Prefix: "\x01tool\x7fcall\x02", Schema: []byte(`{"type":"object"}`), Suffix: "\x03"}}
+
+	grammar, err := toolCallGrammar(syntax)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !grammarAccepts(t, grammar, "\x01tool\x7fcall\x02{}\x03") {
+		t.Errorf("expected grammar to accept the call, grammar:\n%s", grammar)
+	}
+}
+
+func TestNamespaceGrammar(t *testing.T) {
+	g := `root ::= "{" space name-kv "}" space # root-kv isn't a rule
+name-kv ::= "\"name\"" space ":" space [^"\\\]a-z]{1,15}
+space ::= | " "
+`
+	expected := `ns-root ::= "{" ns-space ns-name-kv "}" ns-space # root-kv isn't a rule
+ns-name-kv ::= "\"name\"" ns-space ":" ns-space [^"\\\]a-z]{1,15}
+ns-space ::= | " "
+`
+	if actual := namespaceGrammar(g, "ns-"); actual != expected {
+		t.Errorf("got\n%s\nwant\n%s", actual, expected)
+	}
+}
@@ -0,0 +1,125 @@
+package server
+
+import (
+	"slices"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/tools"
+)
+
+// validateToolCalls validates the arguments of calls to strict tools,
+// replacing them with the coerced arguments and setting the call's errors. It
+// returns the index of the first call that's still invalid, or -1
+func validateToolCalls(calls []api.ToolCall, available []api.Tool) int {
+	invalid := -1
+	for i := range calls {
+		j := slices.IndexFunc(available, func(t api.Tool) bool { return t.Function.Name == calls[i].Function.Name })
+		if j < 0 || !available[j].Function.Strict {
+			continue
+		}
+
+		calls[i].Function.Arguments, calls[i].Errors = tools.ValidateArguments(available[j], calls[i].Function.Arguments)
+		if len(calls[i].Errors) > 0 && invalid < 0 {
+			invalid = i
+		}
+	}
+	return invalid
+}
+
+// toolCallRetry tracks the calls to strict tools of a chat response. A call
+// with invalid arguments is generated again once, constrained to the tool's
+// parameters, with a new parser that numbers calls from 0, so the calls
+// after a retry are offset by the calls already sent.
+type toolCallRetry struct {
+	tools  []api.Tool
+	strict map[string]bool
+	// retryable reports whether a call to the named tool can be constrained
+	retryable func(name string) bool
+
+	// tool is the name of the tool whose call is generated again
+	tool    string
+	retried bool
+
+	offset, sent int
+	deltaTools   map[int]string
+}
+
+func newToolCallRetry(tools []api.Tool, retryable func(name string) bool) *toolCallRetry {
+	strict := make(map[string]bool)
+	for _, t := range tools {
+		if t.Function.Strict {
+			strict[t.Function.Name] = true
+		}
+	}
+
+	return &toolCallRetry{
+		tools:      tools,
+		strict:     strict,
+		retryable:  retryable,
+		deltaTools: make(map[int]string),
+	}
+}
+
+// pending returns the name of the tool whose call is to be generated again,
+// or "" if there isn't one. The completion is stopped while one is pending.
+func (r *toolCallRetry) pending() string {
+	return r.tool
+}
+
+// offsetCalls numbers calls from the current parser after the calls already
+// sent
+func (r *toolCallRetry) offsetCalls(calls []api.ToolCall) {
+	for i := range calls {
+		calls[i].Function.Index += r.offset
+	}
+}
+
+// deltas numbers deltas from the current parser after the calls already sent
+// and drops the deltas of calls to strict tools, as their arguments may
+// change when they're validated
+func (r *toolCallRetry) deltas(deltas []api.ToolCallDelta) []api.ToolCallDelta {
+	for i := range deltas {
+		deltas[i].Index += r.offset
+	}
+
+	return slices.DeleteFunc(deltas, func(d api.ToolCallDelta) bool {
+		if d.Name != "" {
+			r.deltaTools[d.Index] = d.Name
+		}
+		return r.strict[r.deltaTools[d.Index]]
+	})
+}
+
+// check validates the calls to strict tools and returns the calls to send. If
+// a call is invalid and can be generated again, it and the calls after it are
+// dropped and the call is pending.
+func (r *toolCallRetry) check(calls []api.ToolCall) []api.ToolCall {
+	if i := validateToolCalls(calls, r.tools); i >= 0 && !r.retried && r.retryable(calls[i].Function.Name) {
+		r.tool = calls[i].Function.Name
+		calls = calls[:i]
+	}
+	r.sent += len(calls)
+	return calls
+}
+
+// sentCalls returns the number of calls sent so far
+func (r *toolCallRetry) sentCalls() int {
+	return r.sent
+}
+
+// interrupt returns what's left to send of res when the completion is
+// stopped for a pending call, and whether there's anything to send
+func (r *toolCallRetry) interrupt(res api.ChatResponse) (api.ChatResponse, bool) {
+	res.Done, res.DoneReason = false, ""
+	return res, res.Message.Content != "" || res.Message.Thinking != "" || len(res.Message.ToolCalls) > 0 || len(res.Message.ToolCallDeltas) > 0
+}
+
+// start starts generating the pending call again and returns the name of its
+// tool. Calls aren't generated again more than once.
+func (r *toolCallRetry) start() string {
+	tool := r.tool
+	r.tool = ""
+	r.retried = true
+	r.offset = r.sent
+	return tool
+}
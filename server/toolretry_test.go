@@ -0,0 +1,110 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/ollama/ollama/api"
+)
+
+func TestToolCallRetry(t *testing.T) {
+	tools := []api.Tool{
+		{
+			Type: "function",
+			Function: api.ToolFunction{
+				Name:   "get_weather",
+				Strict: true,
+				Parameters: api.ToolFunctionParameters{
+					Type:       "object",
+					Required:   []string{"location"},
+					Properties: map[string]api.ToolProperty{"location": {Type: api.PropertyType{"string"}}},
+				},
+			},
+		},
+		{Type: "function", Function: api.ToolFunction{Name: "get_time"}},
+	}
+
+	call := func(index int, name string, args api.ToolCallFunctionArguments) api.ToolCall {
+		return api.ToolCall{Function: api.ToolCallFunction{Index: index, Name: name, Arguments: args}}
+	}
+
+	t.Run("retry", func(t *testing.T) {
+		r := newToolCallRetry(tools, func(string) bool { return true })
+
+		calls := []api.ToolCall{
+			call(0, "get_time", nil),
+			call(1, "get_weather", api.ToolCallFunctionArguments{}),
+			call(2, "get_time", nil),
+		}
+		r.offsetCalls(calls)
+		calls = r.check(calls)
+		if len(calls) != 1 || calls[0].Function.Name != "get_time" {
+			t.Fatalf("expected the calls before the invalid call, got %v", calls)
+		}
+
+		if r.pending() != "get_weather" {
+			t.Fatalf("expected get_weather to be pending, got %q", r.pending())
+		}
+
+		if tool := r.start(); tool != "get_weather" || r.pending() != "" {
+			t.Fatalf("expected to start get_weather, got %q with %q pending", tool, r.pending())
+		}
+
+		// the new parser numbers calls from 0, after the call already sent
+		calls = []api.ToolCall{call(0, "get_weather", api.ToolCallFunctionArguments{"location": "Paris"})}
+		r.offsetCalls(calls)
+		calls = r.check(calls)
+		if len(calls) != 1 || calls[0].Function.Index != 1 || len(calls[0].Errors) > 0 {
+			t.Errorf("expected a valid call at index 1, got %v", calls)
+		}
+
+		// calls aren't generated again more than once
+		calls = r.check([]api.ToolCall{call(0, "get_weather", api.ToolCallFunctionArguments{})})
+		if len(calls) != 1 || len(calls[0].Errors) == 0 || r.pending() != "" {
+			t.Errorf("expected the invalid call with its errors, got %v with %q pending", calls, r.pending())
+		}
+	})
+
+	t.Run("not retryable", func(t *testing.T) {
+		r := newToolCallRetry(tools, func(string) bool { return false })
+
+		calls := r.check([]api.ToolCall{call(0, "get_weather", api.ToolCallFunctionArguments{})})
+		if len(calls) != 1 || len(calls[0].Errors) == 0 || r.pending() != "" {
+			t.Errorf("expected the invalid call with its errors, got %v with %q pending", calls, r.pending())
+		}
+	})
+
+	t.Run("deltas", func(t *testing.T) {
+		r := newToolCallRetry(tools, func(string) bool { return true })
+		r.check([]api.ToolCall{call(0, "get_time", nil), call(1, "get_weather", api.ToolCallFunctionArguments{})})
+		r.start()
+
+		deltas := r.deltas([]api.ToolCallDelta{
+			{Index: 0, Name: "get_weather"},
+			{Index: 0, Arguments: `{"location":`},
+			{Index: 1, Name: "get_time"},
+			{Index: 1, Arguments: `{}`},
+		})
+
+		// deltas of calls to strict tools are dropped, and the rest are
+		// offset by the call already sent
+		want := []api.ToolCallDelta{{Index: 2, Name: "get_time"}, {Index: 2, Arguments: `{}`}}
+		if diff := cmp.Diff(want, deltas); diff != "" {
+			t.Errorf("deltas mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("interrupt", func(t *testing.T) {
+		r := newToolCallRetry(tools, func(string) bool { return true })
+
+		res, ok := r.interrupt(api.ChatResponse{Message: api.Message{Content: "Let me check."}, Done: true, DoneReason: "stop"})
+		if !ok || res.Done || res.DoneReason != "" || res.Message.Content != "Let me check." {
+			t.Errorf("expected the content without done, got %+v and %v", res, ok)
+		}
+
+		if _, ok := r.interrupt(api.ChatResponse{Done: true}); ok {
+			t.Error("expected nothing to send")
+		}
+	})
+}
@@ -0,0 +1,949 @@
+package jinja
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+)
+
+var (
+	errBreak    = errors.New("break outside of loop")
+	errContinue = errors.New("continue outside of loop")
+)
+
+type scope struct {
+	vars   map[string]any
+	parent *scope
+}
+
+func newScope(parent *scope) *scope {
+	return &scope{vars: make(map[string]any), parent: parent}
+}
+
+func (s *scope) lookup(name string) any {
+	for ; s != nil; s = s.parent {
+		if v, ok := s.vars[name]; ok {
+			return v
+		}
+	}
+
+	if v, ok := globals[name]; ok {
+		return v
+	}
+
+	return Undefined{Name: name}
+}
+
+// Loop is the loop variable inside for loops
+type Loop struct {
+	items []any
+	index int
+}
+
+func (l *Loop) attr(name string) any {
+	switch name {
+	case "index":
+		return int64(l.index + 1)
+	case "index0":
+		return int64(l.index)
+	case "revindex":
+		return int64(len(l.items) - l.index)
+	case "revindex0":
+		return int64(len(l.items) - l.index - 1)
+	case "first":
+		return l.index == 0
+	case "last":
+		return l.index == len(l.items)-1
+	case "length":
+		return int64(len(l.items))
+	case "previtem":
+		if l.index > 0 {
+			return l.items[l.index-1]
+		}
+	case "nextitem":
+		if l.index < len(l.items)-1 {
+			return l.items[l.index+1]
+		}
+	case "cycle":
+		return Func(func(args []any, _ *Dict) (any, error) {
+			if len(args) == 0 {
+				return nil, errors.New("no items for cycling given")
+			}
+			return args[l.index%len(args)], nil
+		})
+	}
+	return Undefined{Name: name}
+}
+
+type macro struct {
+	*macroNode
+	scope *scope
+}
+
+// maxCallDepth is the maximum depth of nested macro calls, so recursive
+// macros fail instead of overflowing the stack
+const maxCallDepth = 256
+
+type state struct {
+	out   *strings.Builder
+	scope *scope
+
+	// depth is the number of macro calls being evaluated
+	depth int
+}
+
+func (st *state) execute(nodes []node) error {
+	for _, n := range nodes {
+		if err := st.executeNode(n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// capture executes nodes and returns their output instead of writing it
+func (st *state) capture(nodes []node, s *scope) (string, error) {
+	var b strings.Builder
+	sub := &state{out: &b, scope: s, depth: st.depth}
+	if err := sub.execute(nodes); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+func (st *state) executeNode(n node) error {
+	switch n := n.(type) {
+	case *textNode:
+		st.out.WriteString(n.text)
+	case *outputNode:
+		v, err := st.eval(n.expr)
+		if err != nil {
+			return err
+		}
+		st.out.WriteString(toString(v))
+	case *ifNode:
+		for i, cond := range n.conds {
+			v, err := st.eval(cond)
+			if err != nil {
+				return err
+			}
+
+			if truthy(v) {
+				return st.execute(n.bodies[i])
+			}
+		}
+		return st.execute(n.orelse)
+	case *forNode:
+		return st.executeFor(n)
+	case *setNode:
+		var v any
+		if n.body != nil {
+			s, err := st.capture(n.body, st.scope)
+			if err != nil {
+				return err
+			}
+
+			v = s
+			for _, f := range n.filters {
+				if v, err = st.applyFilter(f, v); err != nil {
+					return err
+				}
+			}
+		} else {
+			var err error
+			if v, err = st.eval(n.value); err != nil {
+				return err
+			}
+		}
+
+		if n.attr != "" {
+			ns, ok := st.scope.lookup(n.targets[0]).(*Namespace)
+			if !ok {
+				return fmt.Errorf("can't assign attribute %s of %s, which isn't a namespace", n.attr, n.targets[0])
+			}
+			ns.Set(n.attr, v)
+			return nil
+		}
+
+		return st.assign(n.targets, v)
+	case *macroNode:
+		st.scope.vars[n.name] = &macro{macroNode: n, scope: st.scope}
+	case *callBlockNode:
+		caller := Func(func(args []any, _ *Dict) (any, error) {
+			return st.capture(n.body, newScope(st.scope))
+		})
+
+		v, err := st.evalCall(n.call, caller)
+		if err != nil {
+			return err
+		}
+		st.out.WriteString(toString(v))
+	case *filterBlockNode:
+		s, err := st.capture(n.body, st.scope)
+		if err != nil {
+			return err
+		}
+
+		var v any = s
+		for _, f := range n.filters {
+			if v, err = st.applyFilter(f, v); err != nil {
+				return err
+			}
+		}
+		st.out.WriteString(toString(v))
+	case *breakNode:
+		return errBreak
+	case *continueNode:
+		return errContinue
+	default:
+		return fmt.Errorf("unknown node %T", n)
+	}
+	return nil
+}
+
+func (st *state) assign(targets []string, v any) error {
+	if len(targets) == 1 {
+		st.scope.vars[targets[0]] = v
+		return nil
+	}
+
+	items, err := iterate(v)
+	if err != nil {
+		return err
+	}
+
+	if len(items) != len(targets) {
+		return fmt.Errorf("expected %d values to unpack, got %d", len(targets), len(items))
+	}
+
+	for i, t := range targets {
+		st.scope.vars[t] = items[i]
+	}
+	return nil
+}
+
+func (st *state) executeFor(n *forNode) error {
+	v, err := st.eval(n.iter)
+	if err != nil {
+		return err
+	}
+
+	items, err := iterate(v)
+	if err != nil {
+		return err
+	}
+
+	parent := st.scope
+	defer func() { st.scope = parent }()
+
+	if n.filter != nil {
+		var filtered []any
+		for _, item := range items {
+			st.scope = newScope(parent)
+			if err := st.assign(n.targets, item); err != nil {
+				return err
+			}
+
+			v, err := st.eval(n.filter)
+			if err != nil {
+				return err
+			}
+
+			if truthy(v) {
+				filtered = append(filtered, item)
+			}
+		}
+		items = filtered
+	}
+
+	if len(items) == 0 {
+		st.scope = parent
+		return st.execute(n.orelse)
+	}
+
+	loop := &Loop{items: items}
+	for i, item := range items {
+		loop.index = i
+
+		st.scope = newScope(parent)
+		st.scope.vars["loop"] = loop
+		if err := st.assign(n.targets, item); err != nil {
+			return err
+		}
+
+		if err := st.execute(n.body); errors.Is(err, errBreak) {
+			break
+		} else if errors.Is(err, errContinue) {
+			continue
+		} else if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (st *state) eval(e expr) (any, error) {
+	switch e := e.(type) {
+	case *literalExpr:
+		return e.value, nil
+	case *nameExpr:
+		return st.scope.lookup(e.name), nil
+	case *listExpr:
+		items := make([]any, len(e.items))
+		for i, x := range e.items {
+			v, err := st.eval(x)
+			if err != nil {
+				return nil, err
+			}
+			items[i] = v
+		}
+		return items, nil
+	case *dictExpr:
+		d := NewDict()
+		for i := range e.keys {
+			k, err := st.eval(e.keys[i])
+			if err != nil {
+				return nil, err
+			}
+
+			v, err := st.eval(e.values[i])
+			if err != nil {
+				return nil, err
+			}
+			d.Set(toString(k), v)
+		}
+		return d, nil
+	case *attrExpr:
+		obj, err := st.eval(e.obj)
+		if err != nil {
+			return nil, err
+		}
+		return getattr(obj, e.name), nil
+	case *itemExpr:
+		obj, err := st.eval(e.obj)
+		if err != nil {
+			return nil, err
+		}
+
+		key, err := st.eval(e.key)
+		if err != nil {
+			return nil, err
+		}
+		return getitem(obj, key), nil
+	case *sliceExpr:
+		return st.evalSlice(e)
+	case *callExpr:
+		return st.evalCall(e, nil)
+	case *filterExpr:
+		v, err := st.eval(e.obj)
+		if err != nil {
+			return nil, err
+		}
+		return st.applyFilter(e, v)
+	case *testExpr:
+		v, err := st.eval(e.obj)
+		if err != nil {
+			return nil, err
+		}
+
+		args, err := st.evalArgs(e.args)
+		if err != nil {
+			return nil, err
+		}
+
+		ok, err := st.applyTest(e.name, v, args)
+		if err != nil {
+			return nil, err
+		}
+		return ok != e.negate, nil
+	case *unaryExpr:
+		v, err := st.eval(e.x)
+		if err != nil {
+			return nil, err
+		}
+
+		switch e.op {
+		case "not":
+			return !truthy(v), nil
+		case "-":
+			switch v := v.(type) {
+			case int64:
+				return -v, nil
+			case float64:
+				return -v, nil
+			case bool:
+				return -int64(boolToFloat(v)), nil
+			}
+		case "+":
+			switch v.(type) {
+			case int64, float64:
+				return v, nil
+			}
+		}
+		return nil, fmt.Errorf("bad operand type for unary %s: %s", e.op, typeName(v))
+	case *binaryExpr:
+		x, err := st.eval(e.x)
+		if err != nil {
+			return nil, err
+		}
+
+		switch e.op {
+		case "and":
+			if !truthy(x) {
+				return x, nil
+			}
+			return st.eval(e.y)
+		case "or":
+			if truthy(x) {
+				return x, nil
+			}
+			return st.eval(e.y)
+		}
+
+		y, err := st.eval(e.y)
+		if err != nil {
+			return nil, err
+		}
+		return binaryOp(e.op, x, y)
+	case *compareExpr:
+		x, err := st.eval(e.x)
+		if err != nil {
+			return nil, err
+		}
+
+		for i, op := range e.ops {
+			y, err := st.eval(e.ys[i])
+			if err != nil {
+				return nil, err
+			}
+
+			ok, err := compareOp(op, x, y)
+			if err != nil {
+				return nil, err
+			}
+
+			if !ok {
+				return false, nil
+			}
+			x = y
+		}
+		return true, nil
+	case *condExpr:
+		cond, err := st.eval(e.cond)
+		if err != nil {
+			return nil, err
+		}
+
+		if truthy(cond) {
+			return st.eval(e.x)
+		} else if e.y != nil {
+			return st.eval(e.y)
+		}
+		return Undefined{}, nil
+	default:
+		return nil, fmt.Errorf("unknown expression %T", e)
+	}
+}
+
+func (st *state) evalArgs(exprs []expr) ([]any, error) {
+	args := make([]any, len(exprs))
+	for i, x := range exprs {
+		v, err := st.eval(x)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = v
+	}
+	return args, nil
+}
+
+func (st *state) evalKwargs(kwargs []kwarg) (*Dict, error) {
+	d := NewDict()
+	for _, kw := range kwargs {
+		v, err := st.eval(kw.value)
+		if err != nil {
+			return nil, err
+		}
+		d.Set(kw.name, v)
+	}
+	return d, nil
+}
+
+func (st *state) evalCall(e *callExpr, caller Func) (any, error) {
+	fn, err := st.eval(e.fn)
+	if err != nil {
+		return nil, err
+	}
+
+	args, err := st.evalArgs(e.args)
+	if err != nil {
+		return nil, err
+	}
+
+	kwargs, err := st.evalKwargs(e.kwargs)
+	if err != nil {
+		return nil, err
+	}
+
+	if caller != nil {
+		kwargs.Set("caller", caller)
+	}
+
+	return st.call(fn, args, kwargs)
+}
+
+func (st *state) call(fn any, args []any, kwargs *Dict) (any, error) {
+	switch fn := fn.(type) {
+	case Func:
+		return fn(args, kwargs)
+	case *macro:
+		if st.depth >= maxCallDepth {
+			return nil, fmt.Errorf("maximum macro call depth of %d exceeded", maxCallDepth)
+		}
+
+		s := newScope(fn.scope)
+		for i, name := range fn.params {
+			switch v, ok := kwargs.Get(name); {
+			case i < len(args):
+				s.vars[name] = args[i]
+			case ok:
+				s.vars[name] = v
+			case fn.defaults[i] != nil:
+				v, err := (&state{scope: s, depth: st.depth}).eval(fn.defaults[i])
+				if err != nil {
+					return nil, err
+				}
+				s.vars[name] = v
+			default:
+				s.vars[name] = Undefined{Name: name}
+			}
+		}
+
+		if len(args) > len(fn.params) {
+			s.vars["varargs"] = args[len(fn.params):]
+		} else {
+			s.vars["varargs"] = []any{}
+		}
+
+		if caller, ok := kwargs.Get("caller"); ok {
+			s.vars["caller"] = caller
+		}
+
+		return (&state{depth: st.depth + 1}).capture(fn.body, s)
+	case Undefined:
+		if fn.Name != "" {
+			return nil, fmt.Errorf("%s is undefined", fn.Name)
+		}
+		return nil, errors.New("undefined is not callable")
+	default:
+		return nil, fmt.Errorf("%s is not callable", typeName(fn))
+	}
+}
+
+func (st *state) evalSlice(e *sliceExpr) (any, error) {
+	obj, err := st.eval(e.obj)
+	if err != nil {
+		return nil, err
+	}
+
+	var bounds [3]*int
+	for i, x := range []expr{e.start, e.stop, e.step} {
+		if x == nil {
+			continue
+		}
+
+		v, err := st.eval(x)
+		if err != nil {
+			return nil, err
+		}
+
+		switch v := v.(type) {
+		case nil:
+		case int64:
+			n := int(v)
+			bounds[i] = &n
+		default:
+			return nil, fmt.Errorf("slice indices must be integers or None")
+		}
+	}
+
+	switch obj := obj.(type) {
+	case []any:
+		indices, err := sliceIndices(len(obj), bounds[0], bounds[1], bounds[2])
+		if err != nil {
+			return nil, err
+		}
+
+		items := make([]any, len(indices))
+		for i, j := range indices {
+			items[i] = obj[j]
+		}
+		return items, nil
+	case string:
+		runes := []rune(obj)
+		indices, err := sliceIndices(len(runes), bounds[0], bounds[1], bounds[2])
+		if err != nil {
+			return nil, err
+		}
+
+		s := make([]rune, len(indices))
+		for i, j := range indices {
+			s[i] = runes[j]
+		}
+		return string(s), nil
+	case Undefined:
+		return obj, nil
+	default:
+		return nil, fmt.Errorf("%s is not subscriptable", typeName(obj))
+	}
+}
+
+// sliceIndices returns the indices selected by a Python slice of a sequence
+// of length n
+func sliceIndices(n int, start, stop, step *int) ([]int, error) {
+	s := 1
+	if step != nil {
+		s = *step
+	}
+
+	if s == 0 {
+		return nil, errors.New("slice step cannot be zero")
+	}
+
+	clamp := func(p *int, def, lo, hi int) int {
+		if p == nil {
+			return def
+		}
+
+		i := *p
+		if i < 0 {
+			i += n
+		}
+		return max(lo, min(i, hi))
+	}
+
+	var indices []int
+	if s > 0 {
+		for i := clamp(start, 0, 0, n); i < clamp(stop, n, 0, n); i += s {
+			indices = append(indices, i)
+		}
+	} else {
+		for i := clamp(start, n-1, -1, n-1); i > clamp(stop, -1, -1, n-1); i += s {
+			indices = append(indices, i)
+		}
+	}
+	return indices, nil
+}
+
+// getattr returns the attribute name of v like obj.name in Jinja
+func getattr(v any, name string) any {
+	switch v := v.(type) {
+	case *Loop:
+		return v.attr(name)
+	case *Namespace:
+		if x, ok := v.Get(name); ok {
+			return x
+		}
+	case *Dict:
+		if m := method(v, name); m != nil {
+			return m
+		}
+		if x, ok := v.Get(name); ok {
+			return x
+		}
+	default:
+		if m := method(v, name); m != nil {
+			return m
+		}
+	}
+	return Undefined{Name: name}
+}
+
+// getitem returns the item key of v like obj[key] in Jinja
+func getitem(v any, key any) any {
+	switch v := v.(type) {
+	case *Dict:
+		if k, ok := key.(string); ok {
+			if x, ok := v.Get(k); ok {
+				return x
+			}
+		}
+	case []any:
+		if i, ok := key.(int64); ok {
+			if i < 0 {
+				i += int64(len(v))
+			}
+			if i >= 0 && i < int64(len(v)) {
+				return v[i]
+			}
+		}
+	case string:
+		if i, ok := key.(int64); ok {
+			runes := []rune(v)
+			if i < 0 {
+				i += int64(len(runes))
+			}
+			if i >= 0 && i < int64(len(runes)) {
+				return string(runes[i])
+			}
+		}
+	}
+
+	if k, ok := key.(string); ok {
+		return getattr(v, k)
+	}
+	return Undefined{}
+}
+
+func binaryOp(op string, x, y any) (any, error) {
+	if op == "~" {
+		return toString(x) + toString(y), nil
+	}
+
+	if b, ok := x.(bool); ok {
+		x = int64(boolToFloat(b))
+	}
+	if b, ok := y.(bool); ok {
+		y = int64(boolToFloat(b))
+	}
+
+	switch x := x.(type) {
+	case string:
+		switch op {
+		case "+":
+			if y, ok := y.(string); ok {
+				return x + y, nil
+			}
+		case "*":
+			if n, ok := y.(int64); ok {
+				return repeat(x, n)
+			}
+		case "%":
+			return formatPercent(x, y)
+		}
+	case []any:
+		switch op {
+		case "+":
+			if y, ok := y.([]any); ok {
+				return slices.Concat(x, y), nil
+			}
+		case "*":
+			if n, ok := y.(int64); ok {
+				var items []any
+				for range max(n, 0) {
+					items = append(items, x...)
+				}
+				return items, nil
+			}
+		}
+	case int64:
+		switch y := y.(type) {
+		case int64:
+			switch op {
+			case "+":
+				return x + y, nil
+			case "-":
+				return x - y, nil
+			case "*":
+				return x * y, nil
+			case "/":
+				if y == 0 {
+					return nil, errors.New("division by zero")
+				}
+				return float64(x) / float64(y), nil
+			case "//":
+				if y == 0 {
+					return nil, errors.New("integer division by zero")
+				}
+				q := x / y
+				if (x%y != 0) && ((x < 0) != (y < 0)) {
+					q--
+				}
+				return q, nil
+			case "%":
+				if y == 0 {
+					return nil, errors.New("integer modulo by zero")
+				}
+				m := x % y
+				if m != 0 && ((m < 0) != (y < 0)) {
+					m += y
+				}
+				return m, nil
+			case "**":
+				if y >= 0 {
+					r := int64(1)
+					for range y {
+						r *= x
+					}
+					return r, nil
+				}
+				return math.Pow(float64(x), float64(y)), nil
+			}
+		case string:
+			if op == "*" {
+				return repeat(y, x)
+			}
+		}
+	}
+
+	a, ok1 := toNumber(x)
+	b, ok2 := toNumber(y)
+	if ok1 && ok2 {
+		switch op {
+		case "+":
+			return a + b, nil
+		case "-":
+			return a - b, nil
+		case "*":
+			return a * b, nil
+		case "/":
+			if b == 0 {
+				return nil, errors.New("division by zero")
+			}
+			return a / b, nil
+		case "//":
+			if b == 0 {
+				return nil, errors.New("division by zero")
+			}
+			return math.Floor(a / b), nil
+		case "%":
+			if b == 0 {
+				return nil, errors.New("modulo by zero")
+			}
+			m := math.Mod(a, b)
+			if m != 0 && ((m < 0) != (b < 0)) {
+				m += b
+			}
+			return m, nil
+		case "**":
+			return math.Pow(a, b), nil
+		}
+	}
+
+	return nil, fmt.Errorf("unsupported operand types for %s: %s and %s", op, typeName(x), typeName(y))
+}
+
+func compareOp(op string, x, y any) (bool, error) {
+	switch op {
+	case "==":
+		return equal(x, y), nil
+	case "!=":
+		return !equal(x, y), nil
+	case "in":
+		return contains(y, x)
+	case "not in":
+		ok, err := contains(y, x)
+		return !ok, err
+	}
+
+	c, err := compare(x, y)
+	if err != nil {
+		return false, err
+	}
+
+	switch op {
+	case "<":
+		return c < 0, nil
+	case ">":
+		return c > 0, nil
+	case "<=":
+		return c <= 0, nil
+	case ">=":
+		return c >= 0, nil
+	}
+	return false, fmt.Errorf("unknown operator %s", op)
+}
+
+// contains reports whether x is in container like Python's in
+func contains(container, x any) (bool, error) {
+	switch c := container.(type) {
+	case string:
+		s, ok := x.(string)
+		if !ok {
+			return false, fmt.Errorf("'in <string>' requires string as left operand, not %s", typeName(x))
+		}
+		return strings.Contains(c, s), nil
+	case []any:
+		return slices.ContainsFunc(c, func(v any) bool { return equal(v, x) }), nil
+	case *Namespace:
+		return contains(c.Dict, x)
+	case *Dict:
+		k, ok := x.(string)
+		if !ok {
+			return false, nil
+		}
+		_, ok = c.Get(k)
+		return ok, nil
+	case nil, Undefined:
+		return false, nil
+	default:
+		return false, fmt.Errorf("argument of type %s is not iterable", typeName(container))
+	}
+}
+
+// formatPercent formats args into format like Python's % operator
+func formatPercent(format string, args any) (string, error) {
+	values, ok := args.([]any)
+	if !ok {
+		values = []any{args}
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			b.WriteByte(format[i])
+			continue
+		}
+
+		j := i + 1
+		for j < len(format) && strings.IndexByte("0123456789.-+ #", format[j]) >= 0 {
+			j++
+		}
+
+		if j >= len(format) {
+			return "", errors.New("incomplete format")
+		}
+
+		verb := format[j]
+		if verb == '%' {
+			b.WriteByte('%')
+			i = j
+			continue
+		}
+
+		if len(values) == 0 {
+			return "", errors.New("not enough arguments for format string")
+		}
+		v := values[0]
+		values = values[1:]
+
+		flags := format[i+1 : j]
+		switch verb {
+		case 's':
+			fmt.Fprintf(&b, "%"+flags+"s", toString(v))
+		case 'r':
+			fmt.Fprintf(&b, "%"+flags+"s", repr(v))
+		case 'd', 'i':
+			n, _ := toNumber(v)
+			fmt.Fprintf(&b, "%"+flags+"d", int64(n))
+		case 'f', 'e', 'g', 'x', 'X', 'o':
+			n, _ := toNumber(v)
+			if verb == 'x' || verb == 'X' || verb == 'o' {
+				fmt.Fprintf(&b, "%"+flags+string(verb), int64(n))
+			} else {
+				fmt.Fprintf(&b, "%"+flags+string(verb), n)
+			}
+		default:
+			return "", fmt.Errorf("unsupported format character %q", verb)
+		}
+		i = j
+	}
+
+	if len(values) > 0 {
+		return "", errors.New("not all arguments converted during string formatting")
+	}
+	return b.String(), nil
+}
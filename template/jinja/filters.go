@@ -0,0 +1,1168 @@
+package jinja
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+type filterFunc func(st *state, v any, args []any, kwargs *Dict) (any, error)
+
+// arg returns the argument at position i or named name, or def if neither
+// was given. Keyword-only arguments have a negative position.
+func arg(args []any, kwargs *Dict, i int, name string, def any) any {
+	if i >= 0 && i < len(args) {
+		return args[i]
+	}
+	if kwargs != nil {
+		if v, ok := kwargs.Get(name); ok {
+			return v
+		}
+	}
+	return def
+}
+
+func toInt(v any) (int64, error) {
+	switch v := v.(type) {
+	case int64:
+		return v, nil
+	case float64:
+		return int64(v), nil
+	case bool:
+		return int64(boolToFloat(v)), nil
+	default:
+		return 0, fmt.Errorf("expected an integer, got %s", typeName(v))
+	}
+}
+
+// maxRepeatLength is the maximum length of a string built by repetition
+const maxRepeatLength = 1 << 24
+
+// repeat returns n copies of s, or an empty string if n isn't positive like
+// Python's string multiplication
+func repeat(s string, n int64) (string, error) {
+	if n <= 0 || s == "" {
+		return "", nil
+	}
+
+	if n > maxRepeatLength/int64(len(s)) {
+		return "", fmt.Errorf("repeated string exceeds %d bytes", maxRepeatLength)
+	}
+
+	return strings.Repeat(s, int(n)), nil
+}
+
+// spaces returns a width of n spaces for filters like indent
+func spaces(n int64) (string, error) {
+	if n < 0 {
+		return "", fmt.Errorf("width must not be negative, got %d", n)
+	}
+
+	return repeat(" ", n)
+}
+
+var filters map[string]filterFunc
+
+func init() {
+	filters = map[string]filterFunc{
+		"abs": func(_ *state, v any, _ []any, _ *Dict) (any, error) {
+			switch v := v.(type) {
+			case int64:
+				return max(v, -v), nil
+			case float64:
+				return math.Abs(v), nil
+			}
+			return nil, fmt.Errorf("bad operand type for abs: %s", typeName(v))
+		},
+		"attr": func(_ *state, v any, args []any, kwargs *Dict) (any, error) {
+			return getattr(v, toString(arg(args, kwargs, 0, "name", ""))), nil
+		},
+		"capitalize": func(_ *state, v any, _ []any, _ *Dict) (any, error) {
+			return capitalize(toString(v)), nil
+		},
+		"count":  length,
+		"length": length,
+		"default": func(_ *state, v any, args []any, kwargs *Dict) (any, error) {
+			def := arg(args, kwargs, 0, "default_value", "")
+			if isUndefined(v) || (truthy(arg(args, kwargs, 1, "boolean", false)) && !truthy(v)) {
+				return def, nil
+			}
+			return v, nil
+		},
+		"dictsort": func(_ *state, v any, args []any, kwargs *Dict) (any, error) {
+			d, ok := v.(*Dict)
+			if !ok {
+				return nil, fmt.Errorf("dictsort expects a dict, got %s", typeName(v))
+			}
+
+			items := dictItems(d)
+			byValue := toString(arg(args, kwargs, 1, "by", "key")) == "value"
+			var err error
+			slices.SortStableFunc(items, func(a, b any) int {
+				i := 0
+				if byValue {
+					i = 1
+				}
+
+				x, y := a.([]any)[i], b.([]any)[i]
+				if !truthy(arg(args, kwargs, 0, "case_sensitive", false)) {
+					x, y = lowerIfString(x), lowerIfString(y)
+				}
+
+				c, cerr := compare(x, y)
+				err = cmpErr(err, cerr)
+				return c
+			})
+
+			if truthy(arg(args, kwargs, 2, "reverse", false)) {
+				slices.Reverse(items)
+			}
+			return items, err
+		},
+		"escape": escape,
+		"e":      escape,
+		"first": func(_ *state, v any, _ []any, _ *Dict) (any, error) {
+			items, err := iterate(v)
+			if err != nil || len(items) == 0 {
+				return Undefined{}, err
+			}
+			return items[0], nil
+		},
+		"last": func(_ *state, v any, _ []any, _ *Dict) (any, error) {
+			items, err := iterate(v)
+			if err != nil || len(items) == 0 {
+				return Undefined{}, err
+			}
+			return items[len(items)-1], nil
+		},
+		"float": func(_ *state, v any, args []any, kwargs *Dict) (any, error) {
+			switch v := v.(type) {
+			case int64:
+				return float64(v), nil
+			case float64:
+				return v, nil
+			case bool:
+				return boolToFloat(v), nil
+			case string:
+				if f, err := strconv.ParseFloat(strings.TrimSpace(v), 64); err == nil {
+					return f, nil
+				}
+			}
+			return arg(args, kwargs, 0, "default", 0.0), nil
+		},
+		"int": func(_ *state, v any, args []any, kwargs *Dict) (any, error) {
+			switch v := v.(type) {
+			case int64:
+				return v, nil
+			case float64:
+				return int64(v), nil
+			case bool:
+				return int64(boolToFloat(v)), nil
+			case string:
+				s := strings.TrimSpace(v)
+				if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+					return i, nil
+				} else if f, err := strconv.ParseFloat(s, 64); err == nil {
+					return int64(f), nil
+				}
+			}
+			return arg(args, kwargs, 0, "default", int64(0)), nil
+		},
+		"indent": func(_ *state, v any, args []any, kwargs *Dict) (any, error) {
+			var prefix string
+			switch w := arg(args, kwargs, 0, "width", int64(4)).(type) {
+			case string:
+				prefix = w
+			default:
+				n, err := toInt(w)
+				if err != nil {
+					return nil, err
+				}
+				if prefix, err = spaces(n); err != nil {
+					return nil, err
+				}
+			}
+
+			first := truthy(arg(args, kwargs, 1, "first", false))
+			blank := truthy(arg(args, kwargs, 2, "blank", false))
+
+			lines := strings.Split(toString(v), "\n")
+			for i, line := range lines {
+				if (i > 0 || first) && (blank || strings.TrimSpace(line) != "") {
+					lines[i] = prefix + line
+				}
+			}
+			return strings.Join(lines, "\n"), nil
+		},
+		"items": func(_ *state, v any, _ []any, _ *Dict) (any, error) {
+			switch v := v.(type) {
+			case *Dict:
+				return dictItems(v), nil
+			case nil, Undefined:
+				return []any{}, nil
+			}
+			return nil, fmt.Errorf("items expects a dict, got %s", typeName(v))
+		},
+		"join": func(st *state, v any, args []any, kwargs *Dict) (any, error) {
+			items, err := iterate(v)
+			if err != nil {
+				return nil, err
+			}
+
+			attribute := arg(args, kwargs, 1, "attribute", nil)
+			s := make([]string, len(items))
+			for i, item := range items {
+				if attribute != nil {
+					item = getattrPath(item, attribute)
+				}
+				s[i] = toString(item)
+			}
+			return strings.Join(s, toString(arg(args, kwargs, 0, "d", ""))), nil
+		},
+		"list": func(_ *state, v any, _ []any, _ *Dict) (any, error) {
+			items, err := iterate(v)
+			if err != nil {
+				return nil, err
+			}
+			return slices.Clone(items), nil
+		},
+		"lower": func(_ *state, v any, _ []any, _ *Dict) (any, error) {
+			return strings.ToLower(toString(v)), nil
+		},
+		"upper": func(_ *state, v any, _ []any, _ *Dict) (any, error) {
+			return strings.ToUpper(toString(v)), nil
+		},
+		"map": func(st *state, v any, args []any, kwargs *Dict) (any, error) {
+			items, err := iterate(v)
+			if err != nil {
+				return nil, err
+			}
+
+			out := make([]any, len(items))
+			if attribute, ok := kwargs.Get("attribute"); ok {
+				def, hasDefault := kwargs.Get("default")
+				for i, item := range items {
+					out[i] = getattrPath(item, attribute)
+					if hasDefault && isUndefined(out[i]) {
+						out[i] = def
+					}
+				}
+				return out, nil
+			}
+
+			if len(args) == 0 {
+				return nil, errors.New("map requires a filter name or attribute")
+			}
+
+			f, ok := filters[toString(args[0])]
+			if !ok {
+				return nil, fmt.Errorf("no filter named %q", toString(args[0]))
+			}
+
+			for i, item := range items {
+				if out[i], err = f(st, item, args[1:], kwargs); err != nil {
+					return nil, err
+				}
+			}
+			return out, nil
+		},
+		"max": minmax(1),
+		"min": minmax(-1),
+		"replace": func(_ *state, v any, args []any, kwargs *Dict) (any, error) {
+			n := int64(-1)
+			if c := arg(args, kwargs, 2, "count", nil); c != nil {
+				var err error
+				if n, err = toInt(c); err != nil {
+					return nil, err
+				}
+			}
+			return strings.Replace(toString(v), toString(arg(args, kwargs, 0, "old", "")), toString(arg(args, kwargs, 1, "new", "")), int(n)), nil
+		},
+		"reverse": func(_ *state, v any, _ []any, _ *Dict) (any, error) {
+			if s, ok := v.(string); ok {
+				runes := []rune(s)
+				slices.Reverse(runes)
+				return string(runes), nil
+			}
+
+			items, err := iterate(v)
+			if err != nil {
+				return nil, err
+			}
+
+			items = slices.Clone(items)
+			slices.Reverse(items)
+			return items, nil
+		},
+		"round": func(_ *state, v any, args []any, kwargs *Dict) (any, error) {
+			f, ok := toNumber(v)
+			if !ok {
+				return nil, fmt.Errorf("round expects a number, got %s", typeName(v))
+			}
+
+			precision, err := toInt(arg(args, kwargs, 0, "precision", int64(0)))
+			if err != nil {
+				return nil, err
+			}
+
+			p := math.Pow10(int(precision))
+			switch toString(arg(args, kwargs, 1, "method", "common")) {
+			case "ceil":
+				return math.Ceil(f*p) / p, nil
+			case "floor":
+				return math.Floor(f*p) / p, nil
+			default:
+				return math.Round(f*p) / p, nil
+			}
+		},
+		"safe": func(_ *state, v any, _ []any, _ *Dict) (any, error) {
+			return v, nil
+		},
+		"select":     selectFilter(false, false),
+		"reject":     selectFilter(true, false),
+		"selectattr": selectFilter(false, true),
+		"rejectattr": selectFilter(true, true),
+		"sort": func(_ *state, v any, args []any, kwargs *Dict) (any, error) {
+			items, err := iterate(v)
+			if err != nil {
+				return nil, err
+			}
+
+			items = slices.Clone(items)
+			reverse := truthy(arg(args, kwargs, 0, "reverse", false))
+			caseSensitive := truthy(arg(args, kwargs, 1, "case_sensitive", false))
+			attribute := arg(args, kwargs, 2, "attribute", nil)
+
+			slices.SortStableFunc(items, func(a, b any) int {
+				if attribute != nil {
+					a, b = getattrPath(a, attribute), getattrPath(b, attribute)
+				}
+				if !caseSensitive {
+					a, b = lowerIfString(a), lowerIfString(b)
+				}
+
+				c, cerr := compare(a, b)
+				err = cmpErr(err, cerr)
+				if reverse {
+					return -c
+				}
+				return c
+			})
+			return items, err
+		},
+		"string": func(_ *state, v any, _ []any, _ *Dict) (any, error) {
+			return toString(v), nil
+		},
+		"sum": func(_ *state, v any, args []any, kwargs *Dict) (any, error) {
+			items, err := iterate(v)
+			if err != nil {
+				return nil, err
+			}
+
+			attribute := arg(args, kwargs, 0, "attribute", nil)
+			total := arg(args, kwargs, 1, "start", int64(0))
+			for _, item := range items {
+				if attribute != nil {
+					item = getattrPath(item, attribute)
+				}
+				if total, err = binaryOp("+", total, item); err != nil {
+					return nil, err
+				}
+			}
+			return total, nil
+		},
+		"title": func(_ *state, v any, _ []any, _ *Dict) (any, error) {
+			return title(toString(v)), nil
+		},
+		"tojson": func(_ *state, v any, args []any, kwargs *Dict) (any, error) {
+			var indent string
+			switch i := arg(args, kwargs, 0, "indent", nil).(type) {
+			case nil:
+			case string:
+				indent = i
+			default:
+				n, err := toInt(i)
+				if err != nil {
+					return nil, err
+				}
+				if indent, err = spaces(n); err != nil {
+					return nil, err
+				}
+			}
+
+			var b strings.Builder
+			if err := toJSON(&b, v, indent, 0, truthy(arg(args, kwargs, -1, "sort_keys", false))); err != nil {
+				return nil, err
+			}
+			return b.String(), nil
+		},
+		"trim": func(_ *state, v any, args []any, kwargs *Dict) (any, error) {
+			if chars := arg(args, kwargs, 0, "chars", nil); chars != nil {
+				return strings.Trim(toString(v), toString(chars)), nil
+			}
+			return strings.TrimSpace(toString(v)), nil
+		},
+		"unique": func(_ *state, v any, args []any, kwargs *Dict) (any, error) {
+			items, err := iterate(v)
+			if err != nil {
+				return nil, err
+			}
+
+			caseSensitive := truthy(arg(args, kwargs, 0, "case_sensitive", false))
+			attribute := arg(args, kwargs, 1, "attribute", nil)
+
+			var seen, out []any
+			for _, item := range items {
+				key := item
+				if attribute != nil {
+					key = getattrPath(item, attribute)
+				}
+				if !caseSensitive {
+					key = lowerIfString(key)
+				}
+
+				if !slices.ContainsFunc(seen, func(s any) bool { return equal(s, key) }) {
+					seen = append(seen, key)
+					out = append(out, item)
+				}
+			}
+			return out, nil
+		},
+		"wordcount": func(_ *state, v any, _ []any, _ *Dict) (any, error) {
+			return int64(len(strings.Fields(toString(v)))), nil
+		},
+	}
+}
+
+func (st *state) applyFilter(f *filterExpr, v any) (any, error) {
+	fn, ok := filters[f.name]
+	if !ok {
+		return nil, fmt.Errorf("no filter named %q", f.name)
+	}
+
+	args, err := st.evalArgs(f.args)
+	if err != nil {
+		return nil, err
+	}
+
+	kwargs, err := st.evalKwargs(f.kwargs)
+	if err != nil {
+		return nil, err
+	}
+
+	return fn(st, v, args, kwargs)
+}
+
+func length(_ *state, v any, _ []any, _ *Dict) (any, error) {
+	switch v := v.(type) {
+	case string:
+		return int64(len([]rune(v))), nil
+	case []any:
+		return int64(len(v)), nil
+	case *Dict:
+		return int64(v.Len()), nil
+	case Undefined:
+		return int64(0), nil
+	}
+	return nil, fmt.Errorf("object of type %s has no len()", typeName(v))
+}
+
+func escape(_ *state, v any, _ []any, _ *Dict) (any, error) {
+	return html.EscapeString(toString(v)), nil
+}
+
+func minmax(sign int) filterFunc {
+	return func(_ *state, v any, args []any, kwargs *Dict) (any, error) {
+		items, err := iterate(v)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(items) == 0 {
+			return Undefined{}, nil
+		}
+
+		caseSensitive := truthy(arg(args, kwargs, 0, "case_sensitive", false))
+		attribute := arg(args, kwargs, 1, "attribute", nil)
+		best := items[0]
+		for _, item := range items[1:] {
+			a, b := item, best
+			if attribute != nil {
+				a, b = getattrPath(a, attribute), getattrPath(b, attribute)
+			}
+			if !caseSensitive {
+				a, b = lowerIfString(a), lowerIfString(b)
+			}
+
+			c, err := compare(a, b)
+			if err != nil {
+				return nil, err
+			}
+
+			if c*sign > 0 {
+				best = item
+			}
+		}
+		return best, nil
+	}
+}
+
+// selectFilter returns the select, reject, selectattr and rejectattr filters
+func selectFilter(reject, attr bool) filterFunc {
+	return func(st *state, v any, args []any, kwargs *Dict) (any, error) {
+		items, err := iterate(v)
+		if err != nil {
+			return nil, err
+		}
+
+		var attribute any
+		if attr {
+			if len(args) == 0 {
+				return nil, errors.New("missing attribute")
+			}
+			attribute, args = args[0], args[1:]
+		}
+
+		var out []any
+		for _, item := range items {
+			x := item
+			if attr {
+				x = getattrPath(item, attribute)
+			}
+
+			var ok bool
+			if len(args) == 0 {
+				ok = truthy(x)
+			} else if ok, err = st.applyTest(toString(args[0]), x, args[1:]); err != nil {
+				return nil, err
+			}
+
+			if ok != reject {
+				out = append(out, item)
+			}
+		}
+		return out, nil
+	}
+}
+
+// getattrPath returns the attribute of v named by path, which may contain dots
+// to reach nested attributes or be an integer index
+func getattrPath(v any, path any) any {
+	if i, ok := path.(int64); ok {
+		return getitem(v, i)
+	}
+
+	for _, name := range strings.Split(toString(path), ".") {
+		if i, err := strconv.ParseInt(name, 10, 64); err == nil {
+			v = getitem(v, i)
+		} else {
+			v = getitem(v, name)
+		}
+	}
+	return v
+}
+
+func dictItems(d *Dict) []any {
+	items := make([]any, d.Len())
+	for i, k := range d.Keys() {
+		v, _ := d.Get(k)
+		items[i] = []any{k, v}
+	}
+	return items
+}
+
+func lowerIfString(v any) any {
+	if s, ok := v.(string); ok {
+		return strings.ToLower(s)
+	}
+	return v
+}
+
+func cmpErr(err, cerr error) error {
+	if err != nil {
+		return err
+	}
+	return cerr
+}
+
+func capitalize(s string) string {
+	runes := []rune(strings.ToLower(s))
+	if len(runes) > 0 {
+		runes[0] = unicode.ToUpper(runes[0])
+	}
+	return string(runes)
+}
+
+func title(s string) string {
+	runes := []rune(s)
+	prev := ' '
+	for i, r := range runes {
+		if unicode.IsLetter(prev) || unicode.IsDigit(prev) || prev == '\'' {
+			runes[i] = unicode.ToLower(r)
+		} else {
+			runes[i] = unicode.ToUpper(r)
+		}
+		prev = r
+	}
+	return string(runes)
+}
+
+type testFunc func(v any, args []any) (bool, error)
+
+var tests map[string]testFunc
+
+func init() {
+	compareTest := func(op string) testFunc {
+		return func(v any, args []any) (bool, error) {
+			if len(args) == 0 {
+				return false, errors.New("missing value to compare to")
+			}
+			return compareOp(op, v, args[0])
+		}
+	}
+
+	tests = map[string]testFunc{
+		"defined": func(v any, _ []any) (bool, error) {
+			return !isUndefined(v), nil
+		},
+		"undefined": func(v any, _ []any) (bool, error) {
+			return isUndefined(v), nil
+		},
+		"none": func(v any, _ []any) (bool, error) {
+			return v == nil, nil
+		},
+		"boolean": func(v any, _ []any) (bool, error) {
+			_, ok := v.(bool)
+			return ok, nil
+		},
+		"true": func(v any, _ []any) (bool, error) {
+			b, ok := v.(bool)
+			return ok && b, nil
+		},
+		"false": func(v any, _ []any) (bool, error) {
+			b, ok := v.(bool)
+			return ok && !b, nil
+		},
+		"integer": func(v any, _ []any) (bool, error) {
+			_, ok := v.(int64)
+			return ok, nil
+		},
+		"float": func(v any, _ []any) (bool, error) {
+			_, ok := v.(float64)
+			return ok, nil
+		},
+		"number": func(v any, _ []any) (bool, error) {
+			_, ok := toNumber(v)
+			return ok, nil
+		},
+		"string": func(v any, _ []any) (bool, error) {
+			_, ok := v.(string)
+			return ok, nil
+		},
+		"mapping": func(v any, _ []any) (bool, error) {
+			switch v.(type) {
+			case *Dict, *Namespace:
+				return true, nil
+			}
+			return false, nil
+		},
+		"iterable": func(v any, _ []any) (bool, error) {
+			switch v.(type) {
+			case string, []any, *Dict:
+				return true, nil
+			}
+			return false, nil
+		},
+		"sequence": func(v any, _ []any) (bool, error) {
+			switch v.(type) {
+			case string, []any, *Dict:
+				return true, nil
+			}
+			return false, nil
+		},
+		"callable": func(v any, _ []any) (bool, error) {
+			switch v.(type) {
+			case Func, *macro:
+				return true, nil
+			}
+			return false, nil
+		},
+		"odd": func(v any, _ []any) (bool, error) {
+			i, err := toInt(v)
+			return i%2 != 0, err
+		},
+		"even": func(v any, _ []any) (bool, error) {
+			i, err := toInt(v)
+			return i%2 == 0, err
+		},
+		"divisibleby": func(v any, args []any) (bool, error) {
+			if len(args) == 0 {
+				return false, errors.New("missing divisor")
+			}
+
+			i, err := toInt(v)
+			if err != nil {
+				return false, err
+			}
+
+			d, err := toInt(args[0])
+			if err != nil || d == 0 {
+				return false, err
+			}
+			return i%d == 0, nil
+		},
+		"lower": func(v any, _ []any) (bool, error) {
+			s, ok := v.(string)
+			return ok && s == strings.ToLower(s), nil
+		},
+		"upper": func(v any, _ []any) (bool, error) {
+			s, ok := v.(string)
+			return ok && s == strings.ToUpper(s), nil
+		},
+		"sameas": func(v any, args []any) (bool, error) {
+			if len(args) == 0 {
+				return false, errors.New("missing value to compare to")
+			}
+
+			switch v.(type) {
+			case nil, bool:
+				return v == args[0], nil
+			}
+			return equal(v, args[0]), nil
+		},
+		"in": func(v any, args []any) (bool, error) {
+			if len(args) == 0 {
+				return false, errors.New("missing container")
+			}
+			return contains(args[0], v)
+		},
+		"eq":          compareTest("=="),
+		"equalto":     compareTest("=="),
+		"==":          compareTest("=="),
+		"ne":          compareTest("!="),
+		"!=":          compareTest("!="),
+		"lt":          compareTest("<"),
+		"lessthan":    compareTest("<"),
+		"<":           compareTest("<"),
+		"le":          compareTest("<="),
+		"<=":          compareTest("<="),
+		"gt":          compareTest(">"),
+		"greaterthan": compareTest(">"),
+		">":           compareTest(">"),
+		"ge":          compareTest(">="),
+		">=":          compareTest(">="),
+	}
+}
+
+func (st *state) applyTest(name string, v any, args []any) (bool, error) {
+	fn, ok := tests[name]
+	if !ok {
+		return false, fmt.Errorf("no test named %q", name)
+	}
+	return fn(v, args)
+}
+
+// method returns the method name of v bound to v, or nil if there isn't one
+func method(v any, name string) Func {
+	switch v := v.(type) {
+	case string:
+		return stringMethod(v, name)
+	case *Dict:
+		switch name {
+		case "items":
+			return func([]any, *Dict) (any, error) { return dictItems(v), nil }
+		case "keys":
+			return func([]any, *Dict) (any, error) {
+				keys := make([]any, v.Len())
+				for i, k := range v.Keys() {
+					keys[i] = k
+				}
+				return keys, nil
+			}
+		case "values":
+			return func([]any, *Dict) (any, error) {
+				values := make([]any, v.Len())
+				for i, k := range v.Keys() {
+					values[i], _ = v.Get(k)
+				}
+				return values, nil
+			}
+		case "get":
+			return func(args []any, kwargs *Dict) (any, error) {
+				if x, ok := v.Get(toString(arg(args, kwargs, 0, "key", ""))); ok {
+					return x, nil
+				}
+				return arg(args, kwargs, 1, "default", nil), nil
+			}
+		}
+	case []any:
+		switch name {
+		case "index":
+			return func(args []any, _ *Dict) (any, error) {
+				if len(args) > 0 {
+					if i := slices.IndexFunc(v, func(x any) bool { return equal(x, args[0]) }); i >= 0 {
+						return int64(i), nil
+					}
+				}
+				return nil, errors.New("value is not in list")
+			}
+		case "count":
+			return func(args []any, _ *Dict) (any, error) {
+				var n int64
+				for _, x := range v {
+					if len(args) > 0 && equal(x, args[0]) {
+						n++
+					}
+				}
+				return n, nil
+			}
+		}
+	}
+	return nil
+}
+
+func stringMethod(s, name string) Func {
+	trim := func(fn func(string, string) string, def func(string) string) Func {
+		return func(args []any, kwargs *Dict) (any, error) {
+			if chars := arg(args, kwargs, 0, "chars", nil); chars != nil {
+				return fn(s, toString(chars)), nil
+			}
+			return def(s), nil
+		}
+	}
+
+	affix := func(fn func(string, string) bool) Func {
+		return func(args []any, _ *Dict) (any, error) {
+			if len(args) == 0 {
+				return nil, errors.New("missing argument")
+			}
+
+			if prefixes, ok := args[0].([]any); ok {
+				return slices.ContainsFunc(prefixes, func(p any) bool { return fn(s, toString(p)) }), nil
+			}
+			return fn(s, toString(args[0])), nil
+		}
+	}
+
+	switch name {
+	case "strip":
+		return trim(strings.Trim, strings.TrimSpace)
+	case "lstrip":
+		return trim(strings.TrimLeft, func(s string) string { return strings.TrimLeftFunc(s, unicode.IsSpace) })
+	case "rstrip":
+		return trim(strings.TrimRight, func(s string) string { return strings.TrimRightFunc(s, unicode.IsSpace) })
+	case "startswith":
+		return affix(strings.HasPrefix)
+	case "endswith":
+		return affix(strings.HasSuffix)
+	case "upper":
+		return func([]any, *Dict) (any, error) { return strings.ToUpper(s), nil }
+	case "lower":
+		return func([]any, *Dict) (any, error) { return strings.ToLower(s), nil }
+	case "title":
+		return func([]any, *Dict) (any, error) { return title(s), nil }
+	case "capitalize":
+		return func([]any, *Dict) (any, error) { return capitalize(s), nil }
+	case "replace":
+		return func(args []any, kwargs *Dict) (any, error) {
+			if len(args) < 2 {
+				return nil, errors.New("replace expects 2 arguments")
+			}
+
+			n := int64(-1)
+			if c := arg(args, kwargs, 2, "count", nil); c != nil {
+				var err error
+				if n, err = toInt(c); err != nil {
+					return nil, err
+				}
+			}
+			return strings.Replace(s, toString(args[0]), toString(args[1]), int(n)), nil
+		}
+	case "split", "rsplit":
+		return func(args []any, kwargs *Dict) (any, error) {
+			sep := arg(args, kwargs, 0, "sep", nil)
+			n, err := toInt(arg(args, kwargs, 1, "maxsplit", int64(-1)))
+			if err != nil {
+				return nil, err
+			}
+
+			var parts []string
+			switch {
+			case sep == nil && n < 0:
+				parts = strings.Fields(s)
+			case sep == nil:
+				parts = splitFields(s, int(n), name == "rsplit")
+			case n < 0:
+				parts = strings.Split(s, toString(sep))
+			case name == "rsplit":
+				parts = rsplitN(s, toString(sep), int(n))
+			default:
+				parts = strings.SplitN(s, toString(sep), int(n)+1)
+			}
+
+			items := make([]any, len(parts))
+			for i, p := range parts {
+				items[i] = p
+			}
+			return items, nil
+		}
+	case "splitlines":
+		return func([]any, *Dict) (any, error) {
+			var items []any
+			for line := range strings.Lines(s) {
+				items = append(items, strings.TrimRight(line, "\r\n"))
+			}
+			return items, nil
+		}
+	case "find":
+		return func(args []any, _ *Dict) (any, error) {
+			if len(args) == 0 {
+				return nil, errors.New("missing argument")
+			}
+			return int64(strings.Index(s, toString(args[0]))), nil
+		}
+	case "count":
+		return func(args []any, _ *Dict) (any, error) {
+			if len(args) == 0 {
+				return nil, errors.New("missing argument")
+			}
+			return int64(strings.Count(s, toString(args[0]))), nil
+		}
+	case "join":
+		return func(args []any, _ *Dict) (any, error) {
+			if len(args) == 0 {
+				return nil, errors.New("missing argument")
+			}
+
+			items, err := iterate(args[0])
+			if err != nil {
+				return nil, err
+			}
+
+			parts := make([]string, len(items))
+			for i, item := range items {
+				parts[i] = toString(item)
+			}
+			return strings.Join(parts, s), nil
+		}
+	case "removeprefix":
+		return func(args []any, _ *Dict) (any, error) {
+			if len(args) == 0 {
+				return nil, errors.New("missing argument")
+			}
+			return strings.TrimPrefix(s, toString(args[0])), nil
+		}
+	case "removesuffix":
+		return func(args []any, _ *Dict) (any, error) {
+			if len(args) == 0 {
+				return nil, errors.New("missing argument")
+			}
+			return strings.TrimSuffix(s, toString(args[0])), nil
+		}
+	case "isdigit", "isalpha", "isspace", "isupper", "islower":
+		return func([]any, *Dict) (any, error) {
+			if s == "" {
+				return false, nil
+			}
+
+			switch name {
+			case "isupper":
+				return s == strings.ToUpper(s) && s != strings.ToLower(s), nil
+			case "islower":
+				return s == strings.ToLower(s) && s != strings.ToUpper(s), nil
+			}
+
+			is := map[string]func(rune) bool{"isdigit": unicode.IsDigit, "isalpha": unicode.IsLetter, "isspace": unicode.IsSpace}[name]
+			for _, r := range s {
+				if !is(r) {
+					return false, nil
+				}
+			}
+			return true, nil
+		}
+	}
+	return nil
+}
+
+// splitFields splits s around whitespace at most n times
+func splitFields(s string, n int, fromRight bool) []string {
+	fields := strings.Fields(s)
+	if len(fields) <= n+1 {
+		return fields
+	}
+
+	if fromRight {
+		rest := s
+		for range n {
+			rest = strings.TrimRightFunc(rest, unicode.IsSpace)
+			rest = rest[:strings.LastIndexFunc(rest, unicode.IsSpace)+1]
+		}
+		return append([]string{strings.TrimRightFunc(rest, unicode.IsSpace)}, fields[len(fields)-n:]...)
+	}
+
+	rest := s
+	for range n {
+		rest = strings.TrimLeftFunc(rest, unicode.IsSpace)
+		rest = rest[strings.IndexFunc(rest, unicode.IsSpace):]
+	}
+	return append(fields[:n:n], strings.TrimLeftFunc(rest, unicode.IsSpace))
+}
+
+func rsplitN(s, sep string, n int) []string {
+	var parts []string
+	for range n {
+		i := strings.LastIndex(s, sep)
+		if i < 0 {
+			break
+		}
+		parts = append(parts, s[i+len(sep):])
+		s = s[:i]
+	}
+	parts = append(parts, s)
+	slices.Reverse(parts)
+	return parts
+}
+
+var globals = map[string]any{
+	"range": Func(func(args []any, _ *Dict) (any, error) {
+		var start, stop, step int64 = 0, 0, 1
+		ints := make([]int64, len(args))
+		for i, a := range args {
+			n, err := toInt(a)
+			if err != nil {
+				return nil, err
+			}
+			ints[i] = n
+		}
+
+		switch len(ints) {
+		case 1:
+			stop = ints[0]
+		case 2:
+			start, stop = ints[0], ints[1]
+		case 3:
+			start, stop, step = ints[0], ints[1], ints[2]
+		default:
+			return nil, errors.New("range expects 1 to 3 arguments")
+		}
+
+		if step == 0 {
+			return nil, errors.New("range step must not be zero")
+		}
+
+		var items []any
+		for i := start; (step > 0 && i < stop) || (step < 0 && i > stop); i += step {
+			items = append(items, i)
+		}
+		return items, nil
+	}),
+	"namespace": Func(func(args []any, kwargs *Dict) (any, error) {
+		ns := &Namespace{NewDict()}
+		for _, a := range args {
+			if d, ok := a.(*Dict); ok {
+				for _, k := range d.Keys() {
+					v, _ := d.Get(k)
+					ns.Set(k, v)
+				}
+			}
+		}
+		for _, k := range kwargs.Keys() {
+			v, _ := kwargs.Get(k)
+			ns.Set(k, v)
+		}
+		return ns, nil
+	}),
+	"dict": Func(func(_ []any, kwargs *Dict) (any, error) {
+		d := NewDict()
+		for _, k := range kwargs.Keys() {
+			v, _ := kwargs.Get(k)
+			d.Set(k, v)
+		}
+		return d, nil
+	}),
+	"raise_exception": Func(func(args []any, _ *Dict) (any, error) {
+		if len(args) == 0 {
+			return nil, errors.New("template raised an exception")
+		}
+		return nil, errors.New(toString(args[0]))
+	}),
+	"strftime_now": Func(func(args []any, _ *Dict) (any, error) {
+		if len(args) == 0 {
+			return nil, errors.New("strftime_now expects a format")
+		}
+		return strftime(time.Now(), toString(args[0])), nil
+	}),
+}
+
+// strftime formats t like Python's time.strftime
+func strftime(t time.Time, format string) string {
+	var b strings.Builder
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' || i+1 >= len(format) {
+			b.WriteByte(format[i])
+			continue
+		}
+
+		i++
+		switch format[i] {
+		case 'Y':
+			b.WriteString(t.Format("2006"))
+		case 'y':
+			b.WriteString(t.Format("06"))
+		case 'm':
+			b.WriteString(t.Format("01"))
+		case 'd':
+			b.WriteString(t.Format("02"))
+		case 'e':
+			b.WriteString(t.Format("_2"))
+		case '-':
+			if i+1 < len(format) {
+				i++
+				switch format[i] {
+				case 'd':
+					b.WriteString(strconv.Itoa(t.Day()))
+				case 'm':
+					b.WriteString(strconv.Itoa(int(t.Month())))
+				default:
+					b.WriteString("%-" + string(format[i]))
+				}
+			}
+		case 'B':
+			b.WriteString(t.Format("January"))
+		case 'b':
+			b.WriteString(t.Format("Jan"))
+		case 'A':
+			b.WriteString(t.Format("Monday"))
+		case 'a':
+			b.WriteString(t.Format("Mon"))
+		case 'H':
+			b.WriteString(t.Format("15"))
+		case 'I':
+			b.WriteString(t.Format("03"))
+		case 'M':
+			b.WriteString(t.Format("04"))
+		case 'S':
+			b.WriteString(t.Format("05"))
+		case 'p':
+			b.WriteString(t.Format("PM"))
+		case 'j':
+			fmt.Fprintf(&b, "%03d", t.YearDay())
+		case 'Z':
+			b.WriteString(t.Format("MST"))
+		case 'z':
+			b.WriteString(t.Format("-0700"))
+		case '%':
+			b.WriteByte('%')
+		default:
+			b.WriteByte('%')
+			b.WriteByte(format[i])
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,150 @@
+// Package jinja implements the subset of Jinja2 used by Hugging Face chat
+// templates.
+//
+// Templates are executed the way the transformers library executes them:
+// trim_blocks and lstrip_blocks are enabled, undefined variables are lenient
+// and the tojson filter encodes like Python's json.dumps without escaping
+// non-ASCII characters. Dicts keep their insertion order.
+package jinja
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// Template is a parsed Jinja template
+type Template struct {
+	nodes []node
+}
+
+// Parse parses the template source src
+func Parse(src string) (*Template, error) {
+	segments, err := split(src)
+	if err != nil {
+		return nil, err
+	}
+
+	nodes, err := parse(segments)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Template{nodes: nodes}, nil
+}
+
+// Execute executes the template with the variables vars and writes the output
+// to w. Values which aren't already template values are converted with FromGo.
+func (t *Template) Execute(w io.Writer, vars map[string]any) error {
+	s := newScope(nil)
+	for k, v := range vars {
+		switch v.(type) {
+		case nil, bool, int64, float64, string, []any, *Dict, *Namespace, Func:
+		default:
+			var err error
+			if v, err = FromGo(v); err != nil {
+				return fmt.Errorf("%s: %w", k, err)
+			}
+		}
+		s.vars[k] = v
+	}
+
+	var b strings.Builder
+	st := &state{out: &b, scope: s}
+	if err := st.execute(t.nodes); err != nil {
+		return err
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// Vars returns the sorted names of the variables the template references,
+// including names it assigns itself
+func (t *Template) Vars() []string {
+	vars := make(map[string]struct{})
+	walkNodes(t.nodes, vars)
+	return slices.Sorted(maps.Keys(vars))
+}
+
+func walkNodes(nodes []node, vars map[string]struct{}) {
+	for _, n := range nodes {
+		switch n := n.(type) {
+		case *outputNode:
+			walkExprs(vars, n.expr)
+		case *ifNode:
+			walkExprs(vars, n.conds...)
+			for _, body := range n.bodies {
+				walkNodes(body, vars)
+			}
+			walkNodes(n.orelse, vars)
+		case *forNode:
+			walkExprs(vars, n.iter, n.filter)
+			walkNodes(n.body, vars)
+			walkNodes(n.orelse, vars)
+		case *setNode:
+			walkExprs(vars, n.value)
+			walkNodes(n.body, vars)
+			for _, f := range n.filters {
+				walkExprs(vars, f)
+			}
+		case *macroNode:
+			walkExprs(vars, n.defaults...)
+			walkNodes(n.body, vars)
+		case *callBlockNode:
+			walkExprs(vars, n.call)
+			walkNodes(n.body, vars)
+		case *filterBlockNode:
+			for _, f := range n.filters {
+				walkExprs(vars, f)
+			}
+			walkNodes(n.body, vars)
+		}
+	}
+}
+
+func walkExprs(vars map[string]struct{}, exprs ...expr) {
+	for _, e := range exprs {
+		switch e := e.(type) {
+		case *nameExpr:
+			vars[e.name] = struct{}{}
+		case *listExpr:
+			walkExprs(vars, e.items...)
+		case *dictExpr:
+			walkExprs(vars, e.keys...)
+			walkExprs(vars, e.values...)
+		case *attrExpr:
+			walkExprs(vars, e.obj)
+		case *itemExpr:
+			walkExprs(vars, e.obj, e.key)
+		case *sliceExpr:
+			walkExprs(vars, e.obj, e.start, e.stop, e.step)
+		case *callExpr:
+			walkExprs(vars, e.fn)
+			walkExprs(vars, e.args...)
+			for _, kw := range e.kwargs {
+				walkExprs(vars, kw.value)
+			}
+		case *filterExpr:
+			walkExprs(vars, e.obj)
+			walkExprs(vars, e.args...)
+			for _, kw := range e.kwargs {
+				walkExprs(vars, kw.value)
+			}
+		case *testExpr:
+			walkExprs(vars, e.obj)
+			walkExprs(vars, e.args...)
+		case *unaryExpr:
+			walkExprs(vars, e.x)
+		case *binaryExpr:
+			walkExprs(vars, e.x, e.y)
+		case *compareExpr:
+			walkExprs(vars, e.x)
+			walkExprs(vars, e.ys...)
+		case *condExpr:
+			walkExprs(vars, e.cond, e.x, e.y)
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package jinja
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestExecute(t *testing.T) {
+	cases := []struct {
+		name     string
+		template string
+		vars     map[string]any
+		expected string
+	}{
+		{"text", "hello", nil, "hello"},
+		{"variable", "{{ a }} {{ b }}", map[string]any{"a": "x", "b": 1}, "x 1"},
+		{"undefined", "[{{ missing }}][{{ missing.attr }}]", nil, "[][]"},
+		{"none", "{{ none }} {{ None }} {{ true }} {{ False }}", nil, "None None True False"},
+		{"float", "{{ 1.0 }} {{ 1.5 }} {{ 7 / 2 }} {{ 7 // 2 }} {{ -7 % 3 }}", nil, "1.0 1.5 3.5 3 2"},
+		{"string ops", "{{ 'a' + 'b' }}{{ 'c' ~ 1 }}{{ 'd' * 3 }}", nil, "abc1ddd"},
+		{"precedence", "{{ 1 + 2 * 3 }} {{ (1 + 2) * 3 }} {{ 2 ** 3 ** 2 }} {{ -2 ** 2 }}", nil, "7 9 64 4"},
+		{"comparison", "{{ 1 < 2 < 3 }} {{ 1 < 3 < 2 }} {{ 'a' in 'abc' }} {{ 1 not in [1] }}", nil, "True False True False"},
+		{"logic", "{{ 0 or 'x' }} {{ 1 and 0 }} {{ not none }}", nil, "x 0 True"},
+		{"ternary", "{{ 'yes' if a else 'no' }} {{ 'yes' if b }}", map[string]any{"a": true}, "yes "},
+		{"if", "{% if a %}a{% elif b %}b{% else %}c{% endif %}", map[string]any{"b": true}, "b"},
+		{"for", "{% for x in items %}{{ loop.index }}{{ x }}{% if not loop.last %},{% endif %}{% endfor %}", map[string]any{"items": []string{"a", "b", "c"}}, "1a,2b,3c"},
+		{"for else", "{% for x in [] %}{{ x }}{% else %}empty{% endfor %}", nil, "empty"},
+		{"for filter", "{% for x in range(10) if x is odd %}{{ x }}{% endfor %}", nil, "13579"},
+		{"for unpack", "{% for k, v in d.items() %}{{ k }}={{ v }};{% endfor %}", map[string]any{"d": map[string]int{"a": 1, "b": 2}}, "a=1;b=2;"},
+		{"for dict", "{% for k in d %}{{ k }}{% endfor %}", map[string]any{"d": map[string]int{"a": 1, "b": 2}}, "ab"},
+		{"loop vars", "{% for x in 'abc' %}{{ loop.previtem }}{{ loop.revindex0 }}{{ loop.cycle('+', '-') }}{% endfor %}", nil, "2+a1-b0+"},
+		{"break continue", "{% for x in range(10) %}{% if x == 2 %}{% continue %}{% endif %}{% if x == 5 %}{% break %}{% endif %}{{ x }}{% endfor %}", nil, "0134"},
+		{"set scope", "{% set x = 1 %}{% for i in range(3) %}{% set x = i %}{% endfor %}{{ x }}", nil, "1"},
+		{"namespace", "{% set ns = namespace(x=1) %}{% for i in range(3) %}{% set ns.x = ns.x + i %}{% endfor %}{{ ns.x }}", nil, "4"},
+		{"set block", "{% set x %}a{{ 1 }}b{% endset %}{{ x | upper }}", nil, "A1B"},
+		{"set tuple", "{% set a, b = 1, 2 %}{{ b }}{{ a }}", nil, "21"},
+		{"macro", "{% macro greet(name, greeting='hi') %}{{ greeting }} {{ name }}{% endmacro %}{{ greet('bob') }}, {{ greet('amy', greeting='yo') }}", nil, "hi bob, yo amy"},
+		{"call block", "{% macro wrap() %}[{{ caller() }}]{% endmacro %}{% call wrap() %}x{% endcall %}", nil, "[x]"},
+		{"filter block", "{% filter upper %}abc{% endfilter %}", nil, "ABC"},
+		{"raw", "{% raw %}{{ x }}{% endraw %}", nil, "{{ x }}"},
+		{"comment", "a{# comment #}b", nil, "ab"},
+		{"whitespace control", "a  {{- 'b' -}}  c", nil, "abc"},
+		{"trim blocks", "{% if true %}\na\n{% endif %}\nb", nil, "a\nb"},
+		{"lstrip blocks", "  {% if true %}\n  a\n  {% endif %}\n", nil, "  a\n"},
+		{"keep whitespace", "  {%+ if true +%}\na{% endif %}", nil, "  \na"},
+		{"list", "{{ [1, 'a', none] }} {{ (1, 2) }} {{ [1, 2, 3][-1] }} {{ [1, 2, 3][1:] }} {{ 'abc'[::-1] }}", nil, "[1, 'a', None] [1, 2] 3 [2, 3] cba"},
+		{"dict", "{{ {'a': 1, 'b': [true]} }} {{ d.a }} {{ d['b'] }} {{ d.get('c', 3) }}", map[string]any{"d": map[string]int{"a": 1, "b": 2}}, "{'a': 1, 'b': [True]} 1 2 3"},
+		{"string methods", "{{ ' a b '.strip() }}|{{ 'a,b'.split(',') }}|{{ 'abc'.startswith('ab') }}|{{ 'x'.upper() }}|{{ 'a b c'.split(None, 1) }}", nil, "a b|['a', 'b']|True|X|['a', 'b c']"},
+		{"string repr", `{{ ["it's", 'say "hi"', "a\nb"] }}`, nil, `["it's", 'say "hi"', 'a\nb']`},
+		{"percent format", "{{ '%s is %d' % ('x', 3) }}", nil, "x is 3"},
+		{"tests", "{{ a is defined }} {{ b is undefined }} {{ 1 is number }} {{ 'a' is string }} {{ d is mapping }} {{ 4 is divisibleby 2 }} {{ 1 is not none }}", map[string]any{"a": 1, "d": map[string]any{}}, "True True True True True True True"},
+		{"filters", "{{ 'ab' | length }} {{ [3, 1, 2] | sort | join(',') }} {{ ' x ' | trim }} {{ 'hello world' | title }} {{ [1, 2] | first }} {{ [1, 2] | last }} {{ none | default('d') }} {{ x | default('d') }}", nil, "2 1,2,3 x Hello World 1 2 None d"},
+		{"map select", "{{ items | map(attribute='name') | join(',') }} {{ items | selectattr('ok') | map(attribute='name') | list }} {{ items | rejectattr('name', 'equalto', 'a') | list | length }}", map[string]any{"items": []map[string]any{{"name": "a", "ok": true}, {"name": "b", "ok": false}}}, "a,b ['a'] 1"},
+		{"numbers", "{{ '3' | int + 1 }} {{ 2.5 | round }} {{ 2.567 | round(2) }} {{ [1, 2, 3] | sum }} {{ [1, 5, 3] | max }} {{ -1 | abs }}", nil, "4 3.0 2.57 6 5 1"},
+		{"indent", "{{ 'a\nb\n\nc' | indent(2) }}|{{ 'a\nb' | indent(2, true) }}", nil, "a\n  b\n\n  c|  a\n  b"},
+		{"tojson", "{{ d | tojson }}", map[string]any{"d": map[string]any{"b": []any{1, 1.5, "é\"", nil, true}, "a": map[string]any{}}}, `{"a": {}, "b": [1, 1.5, "é\"", null, true]}`},
+		{"tojson indent", "{{ {'b': 1, 'a': [1, 2]} | tojson(indent=2, sort_keys=true) }}", nil, "{\n  \"a\": [\n    1,\n    2\n  ],\n  \"b\": 1\n}"},
+		{"dictsort", "{% for k, v in {'b': 1, 'a': 2} | dictsort %}{{ k }}{{ v }}{% endfor %}", nil, "a2b1"},
+		{"items order", "{% for k, v in d | items %}{{ k }}{% endfor %}", map[string]any{"d": json.RawMessage(`{"z": 1, "a": 2}`)}, "za"},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpl, err := Parse(tt.template)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var b strings.Builder
+			if err := tmpl.Execute(&b, tt.vars); err != nil {
+				t.Fatal(err)
+			}
+
+			if diff := cmp.Diff(tt.expected, b.String()); diff != "" {
+				t.Errorf("mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestExecuteError(t *testing.T) {
+	cases := []struct {
+		name     string
+		template string
+		expected string
+	}{
+		{"raise exception", "{{ raise_exception('bad roles') }}", "bad roles"},
+		{"unknown filter", "{{ 1 | nope }}", `no filter named "nope"`},
+		{"unknown test", "{{ 1 is nope }}", `no test named "nope"`},
+		{"division by zero", "{{ 1 / 0 }}", "division by zero"},
+		{"recursive macro", "{% macro f(n) %}{{ f(n) }}{% endmacro %}{{ f(1) }}", "maximum macro call depth"},
+		{"negative indent", "{{ 'a\nb' | indent(-5) }}", "width must not be negative"},
+		{"negative tojson indent", "{{ [1] | tojson(indent=-1) }}", "width must not be negative"},
+		{"repeat overflow", "{{ 'ab' * 9223372036854775807 }}", "repeated string exceeds"},
+		{"repeat overflow reversed", "{{ 9223372036854775807 * 'ab' }}", "repeated string exceeds"},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpl, err := Parse(tt.template)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var b strings.Builder
+			if err := tmpl.Execute(&b, nil); err == nil || !strings.Contains(err.Error(), tt.expected) {
+				t.Errorf("expected error containing %q, got %v", tt.expected, err)
+			}
+		})
+	}
+}
+
+func TestParseError(t *testing.T) {
+	for _, s := range []string{
+		"{{ x ",
+		"{% if x %}",
+		"{% for x in y %}{% endif %}",
+		"{% endfor %}",
+		"{{ (1 }}",
+		"{{ 'abc }}",
+		"{% unknown %}",
+	} {
+		t.Run(s, func(t *testing.T) {
+			if _, err := Parse(s); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+}
+
+func TestVars(t *testing.T) {
+	tmpl, err := Parse("{% if tools %}{% for t in tools | selectattr('type') %}{{ t.function.name }}{% endfor %}{% endif %}{% for m in messages %}{{ m.tools }}{{ fmt(m, x=enable_thinking) }}{% endfor %}")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff([]string{"enable_thinking", "fmt", "m", "messages", "t", "tools"}, tmpl.Vars()); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+}
+
+// TestChatTemplates executes the Hugging Face chat templates known to Ollama
+func TestChatTemplates(t *testing.T) {
+	f, err := os.Open(filepath.Join("..", "testdata", "templates.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	messages := []map[string]string{
+		{"role": "user", "content": "Hello, how are you?"},
+		{"role": "assistant", "content": "I'm doing great. How can I help you today?"},
+		{"role": "user", "content": "I'd like to show off how chat templating works!"},
+	}
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		var ss map[string]string
+		if err := json.Unmarshal(scanner.Bytes(), &ss); err != nil {
+			t.Fatal(err)
+		}
+
+		for k, v := range ss {
+			t.Run(k, func(t *testing.T) {
+				tmpl, err := Parse(v)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				var b strings.Builder
+				if err := tmpl.Execute(&b, map[string]any{
+					"messages":              messages,
+					"add_generation_prompt": true,
+					"bos_token":             "<s>",
+					"eos_token":             "</s>",
+				}); err != nil {
+					t.Fatal(err)
+				}
+
+				for _, m := range messages {
+					if !strings.Contains(b.String(), m["content"]) {
+						t.Errorf("expected output to contain %q, got %q", m["content"], b.String())
+					}
+				}
+			})
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+}
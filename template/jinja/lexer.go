@@ -0,0 +1,335 @@
+package jinja
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+type segmentKind int
+
+const (
+	segmentText segmentKind = iota
+	segmentVariable
+	segmentBlock
+	segmentComment
+)
+
+// segment is a piece of template source: either literal text or the contents
+// of a {{ }}, {% %} or {# #} tag
+type segment struct {
+	kind segmentKind
+	text string
+	line int
+
+	// trimLeft and trimRight are set by - inside the delimiters. keepLeft and
+	// keepRight are set by + and disable lstrip_blocks and trim_blocks.
+	trimLeft, trimRight bool
+	keepLeft, keepRight bool
+}
+
+var rawEnd = regexp.MustCompile(`\{%([-+]?)\s*endraw\s*([-+]?)%\}`)
+
+// split splits src into text and tags and applies whitespace control the way
+// Jinja does with trim_blocks and lstrip_blocks enabled
+func split(src string) ([]segment, error) {
+	var segments []segment
+	line := 1
+	for len(src) > 0 {
+		i := indexTagStart(src)
+		if i < 0 {
+			segments = append(segments, segment{kind: segmentText, text: src, line: line})
+			break
+		}
+
+		if i > 0 {
+			segments = append(segments, segment{kind: segmentText, text: src[:i], line: line})
+			line += strings.Count(src[:i], "\n")
+			src = src[i:]
+		}
+
+		s := segment{line: line}
+		var end string
+		switch src[1] {
+		case '{':
+			s.kind, end = segmentVariable, "}}"
+		case '%':
+			s.kind, end = segmentBlock, "%}"
+		case '#':
+			s.kind, end = segmentComment, "#}"
+		}
+
+		body := src[2:]
+		if len(body) > 0 && body[0] == '-' {
+			s.trimLeft, body = true, body[1:]
+		} else if len(body) > 0 && body[0] == '+' {
+			s.keepLeft, body = true, body[1:]
+		}
+
+		j := indexTagEnd(body, end, s.kind != segmentComment)
+		if j < 0 {
+			return nil, fmt.Errorf("line %d: unclosed tag", line)
+		}
+
+		inner := body[:j]
+		if strings.HasSuffix(inner, "-") {
+			s.trimRight, inner = true, inner[:len(inner)-1]
+		} else if strings.HasSuffix(inner, "+") && s.kind != segmentVariable {
+			s.keepRight, inner = true, inner[:len(inner)-1]
+		}
+
+		s.text = strings.TrimSpace(inner)
+		consumed := 2 + (len(src[2:]) - len(body)) + j + len(end)
+		line += strings.Count(src[:consumed], "\n")
+		src = src[consumed:]
+
+		if s.kind == segmentBlock && s.text == "raw" {
+			m := rawEnd.FindStringSubmatchIndex(src)
+			if m == nil {
+				return nil, fmt.Errorf("line %d: missing endraw", line)
+			}
+
+			// the raw block is treated as a block tag followed by text
+			// and another block tag for whitespace control
+			segments = append(segments, segment{kind: segmentComment, line: s.line, trimLeft: s.trimLeft, keepLeft: s.keepLeft, trimRight: s.trimRight, keepRight: s.keepRight})
+			segments = append(segments, segment{kind: segmentText, text: src[:m[0]], line: line})
+			segments = append(segments, segment{kind: segmentComment, line: line, trimLeft: m[3] > m[2] && src[m[2]] == '-', keepLeft: m[3] > m[2] && src[m[2]] == '+', trimRight: m[5] > m[4] && src[m[4]] == '-', keepRight: m[5] > m[4] && src[m[4]] == '+'})
+			line += strings.Count(src[:m[1]], "\n")
+			src = src[m[1]:]
+			continue
+		}
+
+		segments = append(segments, s)
+	}
+
+	for i := range segments {
+		s := &segments[i]
+		if s.kind == segmentText {
+			continue
+		}
+
+		var prev, next *segment
+		if i > 0 && segments[i-1].kind == segmentText {
+			prev = &segments[i-1]
+		}
+		if i+1 < len(segments) && segments[i+1].kind == segmentText {
+			next = &segments[i+1]
+		}
+
+		if prev != nil {
+			if s.trimLeft {
+				prev.text = strings.TrimRightFunc(prev.text, unicode.IsSpace)
+			} else if s.kind != segmentVariable && !s.keepLeft {
+				// lstrip_blocks removes whitespace from the start of the
+				// line up to a block
+				j := strings.LastIndexByte(prev.text, '\n')
+				if rest := prev.text[j+1:]; strings.Trim(rest, " \t") == "" && (j >= 0 || i == 1) {
+					prev.text = prev.text[:j+1]
+				}
+			}
+		}
+
+		if next != nil {
+			if s.trimRight {
+				next.text = strings.TrimLeftFunc(next.text, unicode.IsSpace)
+			} else if s.kind != segmentVariable && !s.keepRight {
+				// trim_blocks removes the first newline after a block
+				if strings.HasPrefix(next.text, "\r\n") {
+					next.text = next.text[2:]
+				} else if strings.HasPrefix(next.text, "\n") {
+					next.text = next.text[1:]
+				}
+			}
+		}
+	}
+
+	return segments, nil
+}
+
+// indexTagStart returns the index of the first tag in s or -1
+func indexTagStart(s string) int {
+	for i := 0; i+1 < len(s); i++ {
+		if s[i] == '{' && (s[i+1] == '{' || s[i+1] == '%' || s[i+1] == '#') {
+			return i
+		}
+	}
+	return -1
+}
+
+// indexTagEnd returns the index of end in s, skipping over string literals if
+// quoted is set, or -1
+func indexTagEnd(s, end string, quoted bool) int {
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0:
+			if c == '\\' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case quoted && (c == '"' || c == '\''):
+			quote = c
+		case strings.HasPrefix(s[i:], end):
+			return i
+		}
+	}
+	return -1
+}
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenName
+	tokenString
+	tokenInt
+	tokenFloat
+	tokenOperator
+)
+
+type token struct {
+	kind  tokenKind
+	value string
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case tokenEOF:
+		return "end of expression"
+	case tokenString:
+		return fmt.Sprintf("string %q", t.value)
+	default:
+		return fmt.Sprintf("%q", t.value)
+	}
+}
+
+// operators are ordered so longer operators are matched first
+var operators = []string{
+	"//", "**", "==", "!=", "<=", ">=",
+	"+", "-", "*", "/", "%", "~", "<", ">", "=", "(", ")", "[", "]", "{", "}", ",", ".", ":", "|",
+}
+
+// tokenize splits the expression or statement src into tokens
+func tokenize(src string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '_' || unicode.IsLetter(rune(c)):
+			j := i + 1
+			for j < len(src) && (src[j] == '_' || unicode.IsLetter(rune(src[j])) || unicode.IsDigit(rune(src[j]))) {
+				j++
+			}
+			tokens = append(tokens, token{tokenName, src[i:j]})
+			i = j
+		case unicode.IsDigit(rune(c)):
+			j := i
+			kind := tokenInt
+			for j < len(src) && (unicode.IsDigit(rune(src[j])) || src[j] == '_') {
+				j++
+			}
+			if j+1 < len(src) && src[j] == '.' && unicode.IsDigit(rune(src[j+1])) {
+				kind = tokenFloat
+				j++
+				for j < len(src) && (unicode.IsDigit(rune(src[j])) || src[j] == '_') {
+					j++
+				}
+			}
+			if j < len(src) && (src[j] == 'e' || src[j] == 'E') {
+				k := j + 1
+				if k < len(src) && (src[k] == '+' || src[k] == '-') {
+					k++
+				}
+				if k < len(src) && unicode.IsDigit(rune(src[k])) {
+					kind = tokenFloat
+					j = k
+					for j < len(src) && unicode.IsDigit(rune(src[j])) {
+						j++
+					}
+				}
+			}
+			tokens = append(tokens, token{kind, strings.ReplaceAll(src[i:j], "_", "")})
+			i = j
+		case c == '"' || c == '\'':
+			s, n, err := unquote(src[i:])
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, token{tokenString, s})
+			i += n
+		default:
+			var op string
+			for _, o := range operators {
+				if strings.HasPrefix(src[i:], o) {
+					op = o
+					break
+				}
+			}
+			if op == "" {
+				return nil, fmt.Errorf("unexpected character %q", c)
+			}
+			tokens = append(tokens, token{tokenOperator, op})
+			i += len(op)
+		}
+	}
+	return tokens, nil
+}
+
+// unquote reads the Python string literal at the start of s and returns its
+// value and length
+func unquote(s string) (string, int, error) {
+	quote := s[0]
+	var b strings.Builder
+	for i := 1; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == quote:
+			return b.String(), i + 1, nil
+		case c == '\\' && i+1 < len(s):
+			i++
+			switch s[i] {
+			case 'n':
+				b.WriteByte('\n')
+			case 't':
+				b.WriteByte('\t')
+			case 'r':
+				b.WriteByte('\r')
+			case '0':
+				b.WriteByte(0)
+			case 'b':
+				b.WriteByte('\b')
+			case 'f':
+				b.WriteByte('\f')
+			case 'u', 'x':
+				n := 4
+				if s[i] == 'x' {
+					n = 2
+				}
+				var r rune
+				if i+n >= len(s) {
+					return "", 0, fmt.Errorf("invalid escape in string")
+				}
+				if _, err := fmt.Sscanf(s[i+1:i+1+n], "%x", &r); err != nil {
+					return "", 0, fmt.Errorf("invalid escape in string: %w", err)
+				}
+				b.WriteRune(r)
+				i += n
+			case '\\', '\'', '"':
+				b.WriteByte(s[i])
+			case '\n':
+				// line continuation
+			default:
+				b.WriteByte('\\')
+				b.WriteByte(s[i])
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", 0, fmt.Errorf("unterminated string")
+}
@@ -0,0 +1,1087 @@
+package jinja
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+)
+
+// statements
+
+type node interface{}
+
+type textNode struct {
+	text string
+}
+
+type outputNode struct {
+	expr expr
+}
+
+type ifNode struct {
+	conds  []expr
+	bodies [][]node
+	orelse []node
+}
+
+type forNode struct {
+	targets []string
+	iter    expr
+	filter  expr
+	body    []node
+	orelse  []node
+}
+
+type setNode struct {
+	targets []string
+
+	// attr is set when assigning to an attribute of a namespace
+	attr string
+
+	value expr
+
+	// body is used instead of value for block assignments
+	body []node
+
+	filters []*filterExpr
+}
+
+type macroNode struct {
+	name     string
+	params   []string
+	defaults []expr
+	body     []node
+}
+
+type callBlockNode struct {
+	call *callExpr
+	body []node
+}
+
+type filterBlockNode struct {
+	filters []*filterExpr
+	body    []node
+}
+
+type breakNode struct{}
+
+type continueNode struct{}
+
+// expressions
+
+type expr interface{}
+
+type literalExpr struct {
+	value any
+}
+
+type nameExpr struct {
+	name string
+}
+
+type listExpr struct {
+	items []expr
+}
+
+type dictExpr struct {
+	keys, values []expr
+}
+
+type attrExpr struct {
+	obj  expr
+	name string
+}
+
+type itemExpr struct {
+	obj, key expr
+}
+
+type sliceExpr struct {
+	obj               expr
+	start, stop, step expr
+}
+
+type kwarg struct {
+	name  string
+	value expr
+}
+
+type callExpr struct {
+	fn     expr
+	args   []expr
+	kwargs []kwarg
+}
+
+type filterExpr struct {
+	obj    expr
+	name   string
+	args   []expr
+	kwargs []kwarg
+}
+
+type testExpr struct {
+	obj    expr
+	name   string
+	args   []expr
+	negate bool
+}
+
+type unaryExpr struct {
+	op string
+	x  expr
+}
+
+type binaryExpr struct {
+	op   string
+	x, y expr
+}
+
+type compareExpr struct {
+	x   expr
+	ops []string
+	ys  []expr
+}
+
+type condExpr struct {
+	cond, x, y expr
+}
+
+type parser struct {
+	segments []segment
+	pos      int
+}
+
+// parse parses the template segments into a list of statements
+func parse(segments []segment) ([]node, error) {
+	p := &parser{segments: segments}
+	nodes, end, err := p.parseBody()
+	if err != nil {
+		return nil, err
+	}
+
+	if end != nil {
+		return nil, fmt.Errorf("line %d: unexpected %q", end.line, end.text)
+	}
+
+	return nodes, nil
+}
+
+// parseBody parses statements until the end of the template or a block tag
+// which isn't the start of a statement, which it returns
+func (p *parser) parseBody(ends ...string) ([]node, *blockTag, error) {
+	var nodes []node
+	for p.pos < len(p.segments) {
+		s := p.segments[p.pos]
+		p.pos++
+
+		switch s.kind {
+		case segmentText:
+			if s.text != "" {
+				nodes = append(nodes, &textNode{s.text})
+			}
+		case segmentComment:
+		case segmentVariable:
+			e, err := parseExpr(s.text)
+			if err != nil {
+				return nil, nil, fmt.Errorf("line %d: %w", s.line, err)
+			}
+			nodes = append(nodes, &outputNode{e})
+		case segmentBlock:
+			tokens, err := tokenize(s.text)
+			if err != nil {
+				return nil, nil, fmt.Errorf("line %d: %w", s.line, err)
+			}
+
+			if len(tokens) == 0 || tokens[0].kind != tokenName {
+				return nil, nil, fmt.Errorf("line %d: expected statement", s.line)
+			}
+
+			tag := &blockTag{name: tokens[0].value, line: s.line, text: s.text, p: &exprParser{tokens: tokens, pos: 1}}
+			if slices.Contains(ends, tag.name) {
+				return nodes, tag, nil
+			}
+
+			n, err := p.parseStatement(tag)
+			if err != nil {
+				return nil, nil, fmt.Errorf("line %d: %w", s.line, err)
+			}
+			nodes = append(nodes, n)
+		}
+	}
+
+	if len(ends) > 0 {
+		return nil, nil, fmt.Errorf("unexpected end of template, expected %q", ends[len(ends)-1])
+	}
+
+	return nodes, nil, nil
+}
+
+type blockTag struct {
+	name string
+	line int
+	text string
+	p    *exprParser
+}
+
+func (p *parser) parseStatement(tag *blockTag) (node, error) {
+	e := tag.p
+	switch tag.name {
+	case "if":
+		var n ifNode
+		for {
+			cond, err := e.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			if err := e.expectEnd(); err != nil {
+				return nil, err
+			}
+
+			body, end, err := p.parseBody("elif", "else", "endif")
+			if err != nil {
+				return nil, err
+			}
+
+			n.conds = append(n.conds, cond)
+			n.bodies = append(n.bodies, body)
+
+			e = end.p
+			switch end.name {
+			case "elif":
+				continue
+			case "else":
+				if err := e.expectEnd(); err != nil {
+					return nil, err
+				}
+
+				n.orelse, end, err = p.parseBody("endif")
+				if err != nil {
+					return nil, err
+				}
+				return &n, end.p.expectEnd()
+			default:
+				return &n, e.expectEnd()
+			}
+		}
+	case "for":
+		var n forNode
+		targets, err := e.parseTargets()
+		if err != nil {
+			return nil, err
+		}
+		n.targets = targets
+
+		if !e.acceptName("in") {
+			return nil, fmt.Errorf("expected 'in', got %s", e.peek())
+		}
+
+		// the iterable can't be a conditional expression since if filters
+		// the loop
+		n.iter, err = e.parseOr()
+		if err != nil {
+			return nil, err
+		}
+
+		if e.acceptName("if") {
+			n.filter, err = e.parseOr()
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		if e.acceptName("recursive") {
+			return nil, fmt.Errorf("recursive loops are not supported")
+		}
+
+		if err := e.expectEnd(); err != nil {
+			return nil, err
+		}
+
+		body, end, err := p.parseBody("else", "endfor")
+		if err != nil {
+			return nil, err
+		}
+		n.body = body
+
+		if end.name == "else" {
+			if err := end.p.expectEnd(); err != nil {
+				return nil, err
+			}
+
+			n.orelse, end, err = p.parseBody("endfor")
+			if err != nil {
+				return nil, err
+			}
+		}
+		return &n, end.p.expectEnd()
+	case "set":
+		var n setNode
+		targets, err := e.parseTargets()
+		if err != nil {
+			return nil, err
+		}
+		n.targets = targets
+
+		if len(targets) == 1 && e.accept(".") {
+			name := e.next()
+			if name.kind != tokenName {
+				return nil, fmt.Errorf("expected attribute name, got %s", name)
+			}
+			n.attr = name.value
+		}
+
+		if e.accept("=") {
+			n.value, err = e.parseTuple()
+			if err != nil {
+				return nil, err
+			}
+			return &n, e.expectEnd()
+		}
+
+		// block assignment
+		for e.accept("|") {
+			f, err := e.parseFilter(nil)
+			if err != nil {
+				return nil, err
+			}
+			n.filters = append(n.filters, f)
+		}
+
+		if err := e.expectEnd(); err != nil {
+			return nil, err
+		}
+
+		body, end, err := p.parseBody("endset")
+		if err != nil {
+			return nil, err
+		}
+		n.body = body
+		return &n, end.p.expectEnd()
+	case "macro":
+		var n macroNode
+		name := e.next()
+		if name.kind != tokenName {
+			return nil, fmt.Errorf("expected macro name, got %s", name)
+		}
+		n.name = name.value
+
+		if err := e.expect("("); err != nil {
+			return nil, err
+		}
+
+		for !e.accept(")") {
+			if len(n.params) > 0 {
+				if err := e.expect(","); err != nil {
+					return nil, err
+				}
+				if e.accept(")") {
+					break
+				}
+			}
+
+			param := e.next()
+			if param.kind != tokenName {
+				return nil, fmt.Errorf("expected parameter name, got %s", param)
+			}
+
+			var def expr
+			if e.accept("=") {
+				var err error
+				def, err = e.parseExpr()
+				if err != nil {
+					return nil, err
+				}
+			}
+
+			n.params = append(n.params, param.value)
+			n.defaults = append(n.defaults, def)
+		}
+
+		if err := e.expectEnd(); err != nil {
+			return nil, err
+		}
+
+		body, end, err := p.parseBody("endmacro")
+		if err != nil {
+			return nil, err
+		}
+		n.body = body
+		return &n, end.p.expectEnd()
+	case "call":
+		x, err := e.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+
+		call, ok := x.(*callExpr)
+		if !ok {
+			return nil, fmt.Errorf("expected macro call")
+		}
+
+		if err := e.expectEnd(); err != nil {
+			return nil, err
+		}
+
+		body, end, err := p.parseBody("endcall")
+		if err != nil {
+			return nil, err
+		}
+		return &callBlockNode{call: call, body: body}, end.p.expectEnd()
+	case "filter":
+		var n filterBlockNode
+		for {
+			f, err := e.parseFilter(nil)
+			if err != nil {
+				return nil, err
+			}
+			n.filters = append(n.filters, f)
+
+			if !e.accept("|") {
+				break
+			}
+		}
+
+		if err := e.expectEnd(); err != nil {
+			return nil, err
+		}
+
+		body, end, err := p.parseBody("endfilter")
+		if err != nil {
+			return nil, err
+		}
+		n.body = body
+		return &n, end.p.expectEnd()
+	case "generation":
+		// generation marks the assistant output for training and has no
+		// effect on rendering
+		if err := e.expectEnd(); err != nil {
+			return nil, err
+		}
+
+		body, end, err := p.parseBody("endgeneration")
+		if err != nil {
+			return nil, err
+		}
+		return &filterBlockNode{body: body}, end.p.expectEnd()
+	case "break":
+		return &breakNode{}, e.expectEnd()
+	case "continue":
+		return &continueNode{}, e.expectEnd()
+	default:
+		return nil, fmt.Errorf("unknown statement %q", tag.name)
+	}
+}
+
+type exprParser struct {
+	tokens []token
+	pos    int
+}
+
+// parseExpr parses the expression src
+func parseExpr(src string) (expr, error) {
+	tokens, err := tokenize(src)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &exprParser{tokens: tokens}
+	e, err := p.parseTuple()
+	if err != nil {
+		return nil, err
+	}
+
+	return e, p.expectEnd()
+}
+
+func (p *exprParser) peek() token {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return token{kind: tokenEOF}
+}
+
+func (p *exprParser) next() token {
+	t := p.peek()
+	if p.pos < len(p.tokens) {
+		p.pos++
+	}
+	return t
+}
+
+func (p *exprParser) accept(op string) bool {
+	if t := p.peek(); t.kind == tokenOperator && t.value == op {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *exprParser) acceptName(name string) bool {
+	if t := p.peek(); t.kind == tokenName && t.value == name {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *exprParser) expect(op string) error {
+	if !p.accept(op) {
+		return fmt.Errorf("expected %q, got %s", op, p.peek())
+	}
+	return nil
+}
+
+func (p *exprParser) expectEnd() error {
+	if t := p.peek(); t.kind != tokenEOF {
+		return fmt.Errorf("unexpected %s", t)
+	}
+	return nil
+}
+
+// parseTargets parses the names assigned to by set and for
+func (p *exprParser) parseTargets() ([]string, error) {
+	paren := p.accept("(")
+
+	var targets []string
+	for {
+		t := p.next()
+		if t.kind != tokenName {
+			return nil, fmt.Errorf("expected name, got %s", t)
+		}
+		targets = append(targets, t.value)
+
+		if !p.accept(",") {
+			break
+		}
+	}
+
+	if paren {
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+	}
+
+	return targets, nil
+}
+
+// parseTuple parses an expression or a tuple of expressions without
+// parentheses
+func (p *exprParser) parseTuple() (expr, error) {
+	x, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+
+	if t := p.peek(); t.kind != tokenOperator || t.value != "," {
+		return x, nil
+	}
+
+	items := []expr{x}
+	for p.accept(",") {
+		if t := p.peek(); t.kind == tokenEOF {
+			break
+		}
+
+		x, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, x)
+	}
+
+	return &listExpr{items}, nil
+}
+
+func (p *exprParser) parseExpr() (expr, error) {
+	x, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.acceptName("if") {
+		cond, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+
+		var y expr
+		if p.acceptName("else") {
+			y, err = p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		x = &condExpr{cond: cond, x: x, y: y}
+	}
+
+	return x, nil
+}
+
+func (p *exprParser) parseOr() (expr, error) {
+	x, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.acceptName("or") {
+		y, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		x = &binaryExpr{op: "or", x: x, y: y}
+	}
+
+	return x, nil
+}
+
+func (p *exprParser) parseAnd() (expr, error) {
+	x, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.acceptName("and") {
+		y, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		x = &binaryExpr{op: "and", x: x, y: y}
+	}
+
+	return x, nil
+}
+
+func (p *exprParser) parseNot() (expr, error) {
+	if p.acceptName("not") {
+		x, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &unaryExpr{op: "not", x: x}, nil
+	}
+
+	return p.parseCompare()
+}
+
+func (p *exprParser) parseCompare() (expr, error) {
+	x, err := p.parseMath1()
+	if err != nil {
+		return nil, err
+	}
+
+	c := &compareExpr{x: x}
+	for {
+		var op string
+		switch t := p.peek(); {
+		case t.kind == tokenOperator && slices.Contains([]string{"==", "!=", "<", ">", "<=", ">="}, t.value):
+			op = t.value
+			p.pos++
+		case t.kind == tokenName && t.value == "in":
+			op = "in"
+			p.pos++
+		case t.kind == tokenName && t.value == "not" && p.pos+1 < len(p.tokens) && p.tokens[p.pos+1].kind == tokenName && p.tokens[p.pos+1].value == "in":
+			op = "not in"
+			p.pos += 2
+		}
+
+		if op == "" {
+			break
+		}
+
+		y, err := p.parseMath1()
+		if err != nil {
+			return nil, err
+		}
+
+		c.ops = append(c.ops, op)
+		c.ys = append(c.ys, y)
+	}
+
+	if len(c.ops) == 0 {
+		return x, nil
+	}
+	return c, nil
+}
+
+func (p *exprParser) parseMath1() (expr, error) {
+	x, err := p.parseConcat()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		t := p.peek()
+		if t.kind != tokenOperator || (t.value != "+" && t.value != "-") {
+			return x, nil
+		}
+		p.pos++
+
+		y, err := p.parseConcat()
+		if err != nil {
+			return nil, err
+		}
+		x = &binaryExpr{op: t.value, x: x, y: y}
+	}
+}
+
+func (p *exprParser) parseConcat() (expr, error) {
+	x, err := p.parseMath2()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.accept("~") {
+		y, err := p.parseMath2()
+		if err != nil {
+			return nil, err
+		}
+		x = &binaryExpr{op: "~", x: x, y: y}
+	}
+
+	return x, nil
+}
+
+func (p *exprParser) parseMath2() (expr, error) {
+	x, err := p.parsePow()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		t := p.peek()
+		if t.kind != tokenOperator || !slices.Contains([]string{"*", "/", "//", "%"}, t.value) {
+			return x, nil
+		}
+		p.pos++
+
+		y, err := p.parsePow()
+		if err != nil {
+			return nil, err
+		}
+		x = &binaryExpr{op: t.value, x: x, y: y}
+	}
+}
+
+func (p *exprParser) parsePow() (expr, error) {
+	x, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.accept("**") {
+		y, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		x = &binaryExpr{op: "**", x: x, y: y}
+	}
+
+	return x, nil
+}
+
+// parseUnary parses a unary expression and the filters and tests applied to
+// it. As in Jinja, filters bind looser than unary operators.
+func (p *exprParser) parseUnary() (expr, error) {
+	x, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+
+	return p.parseFilters(x)
+}
+
+func (p *exprParser) parseOperand() (expr, error) {
+	if t := p.peek(); t.kind == tokenOperator && (t.value == "-" || t.value == "+") {
+		p.pos++
+		x, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		return &unaryExpr{op: t.value, x: x}, nil
+	}
+
+	x, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+
+	return p.parsePostfix(x)
+}
+
+func (p *exprParser) parsePrimary() (expr, error) {
+	t := p.next()
+	switch t.kind {
+	case tokenName:
+		switch t.value {
+		case "true", "True":
+			return &literalExpr{true}, nil
+		case "false", "False":
+			return &literalExpr{false}, nil
+		case "none", "None":
+			return &literalExpr{nil}, nil
+		}
+		return &nameExpr{t.value}, nil
+	case tokenString:
+		s := t.value
+		// adjacent strings are concatenated
+		for p.peek().kind == tokenString {
+			s += p.next().value
+		}
+		return &literalExpr{s}, nil
+	case tokenInt:
+		i, err := strconv.ParseInt(t.value, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		return &literalExpr{i}, nil
+	case tokenFloat:
+		f, err := strconv.ParseFloat(t.value, 64)
+		if err != nil {
+			return nil, err
+		}
+		return &literalExpr{f}, nil
+	case tokenOperator:
+		switch t.value {
+		case "(":
+			if p.accept(")") {
+				return &listExpr{}, nil
+			}
+
+			x, err := p.parseTuple()
+			if err != nil {
+				return nil, err
+			}
+			return x, p.expect(")")
+		case "[":
+			var l listExpr
+			for !p.accept("]") {
+				if len(l.items) > 0 {
+					if err := p.expect(","); err != nil {
+						return nil, err
+					}
+					if p.accept("]") {
+						break
+					}
+				}
+
+				x, err := p.parseExpr()
+				if err != nil {
+					return nil, err
+				}
+				l.items = append(l.items, x)
+			}
+			return &l, nil
+		case "{":
+			var d dictExpr
+			for !p.accept("}") {
+				if len(d.keys) > 0 {
+					if err := p.expect(","); err != nil {
+						return nil, err
+					}
+					if p.accept("}") {
+						break
+					}
+				}
+
+				k, err := p.parseExpr()
+				if err != nil {
+					return nil, err
+				}
+
+				if err := p.expect(":"); err != nil {
+					return nil, err
+				}
+
+				v, err := p.parseExpr()
+				if err != nil {
+					return nil, err
+				}
+
+				d.keys = append(d.keys, k)
+				d.values = append(d.values, v)
+			}
+			return &d, nil
+		}
+	}
+
+	return nil, fmt.Errorf("unexpected %s", t)
+}
+
+func (p *exprParser) parsePostfix(x expr) (expr, error) {
+	for {
+		switch {
+		case p.accept("."):
+			t := p.next()
+			switch t.kind {
+			case tokenName:
+				x = &attrExpr{obj: x, name: t.value}
+			case tokenInt:
+				i, _ := strconv.ParseInt(t.value, 10, 64)
+				x = &itemExpr{obj: x, key: &literalExpr{i}}
+			default:
+				return nil, fmt.Errorf("expected attribute name, got %s", t)
+			}
+		case p.accept("["):
+			x2, err := p.parseSubscript(x)
+			if err != nil {
+				return nil, err
+			}
+			x = x2
+		case p.accept("("):
+			args, kwargs, err := p.parseArgs()
+			if err != nil {
+				return nil, err
+			}
+			x = &callExpr{fn: x, args: args, kwargs: kwargs}
+		default:
+			return x, nil
+		}
+	}
+}
+
+func (p *exprParser) parseSubscript(x expr) (expr, error) {
+	var parts [3]expr
+	var n int
+	isSlice := false
+	for {
+		if t := p.peek(); !(t.kind == tokenOperator && (t.value == ":" || t.value == "]")) {
+			e, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			parts[n] = e
+		}
+
+		if p.accept("]") {
+			break
+		}
+
+		if err := p.expect(":"); err != nil {
+			return nil, err
+		}
+		isSlice = true
+
+		n++
+		if n > 2 {
+			return nil, fmt.Errorf("invalid slice")
+		}
+	}
+
+	if isSlice {
+		return &sliceExpr{obj: x, start: parts[0], stop: parts[1], step: parts[2]}, nil
+	}
+
+	if parts[0] == nil {
+		return nil, fmt.Errorf("expected subscript")
+	}
+	return &itemExpr{obj: x, key: parts[0]}, nil
+}
+
+// parseArgs parses call arguments after the opening parenthesis
+func (p *exprParser) parseArgs() (args []expr, kwargs []kwarg, _ error) {
+	for !p.accept(")") {
+		if len(args)+len(kwargs) > 0 {
+			if err := p.expect(","); err != nil {
+				return nil, nil, err
+			}
+			if p.accept(")") {
+				break
+			}
+		}
+
+		if t := p.peek(); t.kind == tokenName && p.pos+1 < len(p.tokens) && p.tokens[p.pos+1].kind == tokenOperator && p.tokens[p.pos+1].value == "=" {
+			p.pos += 2
+			v, err := p.parseExpr()
+			if err != nil {
+				return nil, nil, err
+			}
+			kwargs = append(kwargs, kwarg{name: t.value, value: v})
+			continue
+		}
+
+		if len(kwargs) > 0 {
+			return nil, nil, fmt.Errorf("positional argument follows keyword argument")
+		}
+
+		v, err := p.parseExpr()
+		if err != nil {
+			return nil, nil, err
+		}
+		args = append(args, v)
+	}
+
+	return args, kwargs, nil
+}
+
+// parseFilter parses a filter name and its arguments after |
+func (p *exprParser) parseFilter(x expr) (*filterExpr, error) {
+	t := p.next()
+	if t.kind != tokenName {
+		return nil, fmt.Errorf("expected filter name, got %s", t)
+	}
+
+	name := t.value
+	for p.accept(".") {
+		t := p.next()
+		if t.kind != tokenName {
+			return nil, fmt.Errorf("expected filter name, got %s", t)
+		}
+		name += "." + t.value
+	}
+
+	f := &filterExpr{obj: x, name: name}
+	if p.accept("(") {
+		var err error
+		if f.args, f.kwargs, err = p.parseArgs(); err != nil {
+			return nil, err
+		}
+	}
+
+	return f, nil
+}
+
+// parseFilters parses filters and tests applied to x
+func (p *exprParser) parseFilters(x expr) (expr, error) {
+	for {
+		switch {
+		case p.accept("|"):
+			f, err := p.parseFilter(x)
+			if err != nil {
+				return nil, err
+			}
+			x = f
+		case p.acceptName("is"):
+			negate := p.acceptName("not")
+
+			t := p.next()
+			if t.kind != tokenName {
+				return nil, fmt.Errorf("expected test name, got %s", t)
+			}
+
+			test := &testExpr{obj: x, name: t.value, negate: negate}
+			if p.accept("(") {
+				args, _, err := p.parseArgs()
+				if err != nil {
+					return nil, err
+				}
+				test.args = args
+			} else if t := p.peek(); t.kind == tokenString || t.kind == tokenInt || t.kind == tokenFloat ||
+				(t.kind == tokenName && !slices.Contains([]string{"and", "or", "if", "else", "in", "not", "is"}, t.value)) {
+				// a test can take a single argument without parentheses
+				arg, err := p.parsePrimary()
+				if err != nil {
+					return nil, err
+				}
+
+				if arg, err = p.parsePostfix(arg); err != nil {
+					return nil, err
+				}
+				test.args = []expr{arg}
+			}
+			x = test
+		default:
+			return x, nil
+		}
+	}
+}
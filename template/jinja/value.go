@@ -0,0 +1,525 @@
+package jinja
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Values in templates are represented by nil (None), Undefined, bool, int64,
+// float64, string, []any, *Dict, *Namespace, *Loop and Func.
+
+// Undefined is the value of variables and attributes that don't exist
+type Undefined struct {
+	Name string
+}
+
+// Dict is a dictionary which keeps the order its keys were inserted in, like
+// a Python dict
+type Dict struct {
+	keys   []string
+	values map[string]any
+}
+
+func NewDict() *Dict {
+	return &Dict{values: make(map[string]any)}
+}
+
+func (d *Dict) Set(key string, value any) {
+	if _, ok := d.values[key]; !ok {
+		d.keys = append(d.keys, key)
+	}
+	d.values[key] = value
+}
+
+func (d *Dict) Get(key string) (any, bool) {
+	v, ok := d.values[key]
+	return v, ok
+}
+
+func (d *Dict) Keys() []string {
+	return d.keys
+}
+
+func (d *Dict) Len() int {
+	return len(d.keys)
+}
+
+// Namespace is an object created with namespace() whose attributes can be
+// assigned to from inside loops
+type Namespace struct {
+	*Dict
+}
+
+// Func is a function which can be called from a template
+type Func func(args []any, kwargs *Dict) (any, error)
+
+// FromGo converts v to a template value by way of its JSON encoding
+func FromGo(v any) (any, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+	return decodeJSON(d)
+}
+
+func decodeJSON(d *json.Decoder) (any, error) {
+	t, err := d.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	switch t := t.(type) {
+	case json.Delim:
+		switch t {
+		case '[':
+			l := []any{}
+			for d.More() {
+				v, err := decodeJSON(d)
+				if err != nil {
+					return nil, err
+				}
+				l = append(l, v)
+			}
+			_, err := d.Token()
+			return l, err
+		case '{':
+			m := NewDict()
+			for d.More() {
+				k, err := d.Token()
+				if err != nil {
+					return nil, err
+				}
+
+				v, err := decodeJSON(d)
+				if err != nil {
+					return nil, err
+				}
+				m.Set(k.(string), v)
+			}
+			_, err := d.Token()
+			return m, err
+		}
+		return nil, fmt.Errorf("unexpected delimiter %v", t)
+	case json.Number:
+		if i, err := t.Int64(); err == nil {
+			return i, nil
+		}
+		return t.Float64()
+	default:
+		return t, nil
+	}
+}
+
+func isUndefined(v any) bool {
+	_, ok := v.(Undefined)
+	return ok
+}
+
+// truthy reports whether v is true in a boolean context
+func truthy(v any) bool {
+	switch v := v.(type) {
+	case nil, Undefined:
+		return false
+	case bool:
+		return v
+	case int64:
+		return v != 0
+	case float64:
+		return v != 0
+	case string:
+		return v != ""
+	case []any:
+		return len(v) > 0
+	case *Dict:
+		return v.Len() > 0
+	default:
+		return true
+	}
+}
+
+// toString converts v to a string like Python's str
+func toString(v any) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case Undefined:
+		return ""
+	default:
+		return repr(v)
+	}
+}
+
+// repr converts v to a string like Python's repr
+func repr(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return "None"
+	case Undefined:
+		return ""
+	case bool:
+		if v {
+			return "True"
+		}
+		return "False"
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return formatFloat(v)
+	case string:
+		return quote(v)
+	case []any:
+		s := make([]string, len(v))
+		for i, e := range v {
+			s[i] = repr(e)
+		}
+		return "[" + strings.Join(s, ", ") + "]"
+	case *Namespace:
+		return "<Namespace " + repr(v.Dict) + ">"
+	case *Dict:
+		s := make([]string, len(v.keys))
+		for i, k := range v.keys {
+			s[i] = quote(k) + ": " + repr(v.values[k])
+		}
+		return "{" + strings.Join(s, ", ") + "}"
+	case *Loop:
+		return "<LoopContext>"
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// quote quotes s like Python's repr of a string
+func quote(s string) string {
+	q := byte('\'')
+	if strings.ContainsRune(s, '\'') && !strings.ContainsRune(s, '"') {
+		q = '"'
+	}
+
+	var b strings.Builder
+	b.WriteByte(q)
+	for _, r := range s {
+		switch {
+		case r == '\\':
+			b.WriteString(`\\`)
+		case r == rune(q):
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r == '\n':
+			b.WriteString(`\n`)
+		case r == '\r':
+			b.WriteString(`\r`)
+		case r == '\t':
+			b.WriteString(`\t`)
+		case r < 0x20 || r == 0x7f:
+			fmt.Fprintf(&b, `\x%02x`, r)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	b.WriteByte(q)
+	return b.String()
+}
+
+// formatFloat formats f like Python's repr of a float
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "inf"
+	case math.IsInf(f, -1):
+		return "-inf"
+	case math.IsNaN(f):
+		return "nan"
+	}
+
+	if abs := math.Abs(f); abs != 0 && (abs < 1e-4 || abs >= 1e16) {
+		s := strconv.FormatFloat(f, 'e', -1, 64)
+		// Python doesn't pad the exponent beyond two digits
+		return s
+	}
+
+	s := strconv.FormatFloat(f, 'f', -1, 64)
+	if !strings.ContainsRune(s, '.') {
+		s += ".0"
+	}
+	return s
+}
+
+// toJSON encodes v like Python's json.dumps with ensure_ascii disabled
+func toJSON(w *strings.Builder, v any, indent string, level int, sortKeys bool) error {
+	newline := func(level int) {
+		if indent != "" {
+			w.WriteByte('\n')
+			w.WriteString(strings.Repeat(indent, level))
+		}
+	}
+
+	itemSep, keySep := ", ", ": "
+	if indent != "" {
+		itemSep = ","
+	}
+
+	switch v := v.(type) {
+	case nil, Undefined:
+		w.WriteString("null")
+	case bool:
+		if v {
+			w.WriteString("true")
+		} else {
+			w.WriteString("false")
+		}
+	case int64:
+		w.WriteString(strconv.FormatInt(v, 10))
+	case float64:
+		switch {
+		case math.IsInf(v, 1):
+			w.WriteString("Infinity")
+		case math.IsInf(v, -1):
+			w.WriteString("-Infinity")
+		case math.IsNaN(v):
+			w.WriteString("NaN")
+		default:
+			w.WriteString(formatFloat(v))
+		}
+	case string:
+		writeJSONString(w, v)
+	case []any:
+		if len(v) == 0 {
+			w.WriteString("[]")
+			return nil
+		}
+
+		w.WriteByte('[')
+		for i, e := range v {
+			if i > 0 {
+				w.WriteString(itemSep)
+			}
+			newline(level + 1)
+			if err := toJSON(w, e, indent, level+1, sortKeys); err != nil {
+				return err
+			}
+		}
+		newline(level)
+		w.WriteByte(']')
+	case *Namespace:
+		return toJSON(w, v.Dict, indent, level, sortKeys)
+	case *Dict:
+		if v.Len() == 0 {
+			w.WriteString("{}")
+			return nil
+		}
+
+		keys := v.keys
+		if sortKeys {
+			keys = slices.Sorted(slices.Values(keys))
+		}
+
+		w.WriteByte('{')
+		for i, k := range keys {
+			if i > 0 {
+				w.WriteString(itemSep)
+			}
+			newline(level + 1)
+			writeJSONString(w, k)
+			w.WriteString(keySep)
+			if err := toJSON(w, v.values[k], indent, level+1, sortKeys); err != nil {
+				return err
+			}
+		}
+		newline(level)
+		w.WriteByte('}')
+	default:
+		return fmt.Errorf("object of type %s is not JSON serializable", typeName(v))
+	}
+	return nil
+}
+
+func writeJSONString(w io.StringWriter, s string) {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '"':
+			b.WriteString(`\"`)
+		case '\\':
+			b.WriteString(`\\`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\t':
+			b.WriteString(`\t`)
+		case '\b':
+			b.WriteString(`\b`)
+		case '\f':
+			b.WriteString(`\f`)
+		default:
+			if r < 0x20 {
+				fmt.Fprintf(&b, `\u%04x`, r)
+			} else {
+				b.WriteRune(r)
+			}
+		}
+	}
+	b.WriteByte('"')
+	w.WriteString(b.String())
+}
+
+func typeName(v any) string {
+	switch v.(type) {
+	case nil:
+		return "NoneType"
+	case Undefined:
+		return "Undefined"
+	case bool:
+		return "bool"
+	case int64:
+		return "int"
+	case float64:
+		return "float"
+	case string:
+		return "str"
+	case []any:
+		return "list"
+	case *Dict:
+		return "dict"
+	case *Namespace:
+		return "Namespace"
+	case Func, *macro:
+		return "function"
+	default:
+		return reflect.TypeOf(v).String()
+	}
+}
+
+// equal reports whether a and b are equal like Python's ==
+func equal(a, b any) bool {
+	switch a := a.(type) {
+	case nil:
+		return b == nil
+	case Undefined:
+		return isUndefined(b)
+	case bool:
+		if b, ok := b.(bool); ok {
+			return a == b
+		}
+		// bools are ints in Python
+		if n, ok := toNumber(b); ok {
+			return boolToFloat(a) == n
+		}
+		return false
+	case int64, float64:
+		x, _ := toNumber(a)
+		if y, ok := toNumber(b); ok {
+			return x == y
+		}
+		if y, ok := b.(bool); ok {
+			return x == boolToFloat(y)
+		}
+		return false
+	case string:
+		b, ok := b.(string)
+		return ok && a == b
+	case []any:
+		b, ok := b.([]any)
+		return ok && slices.EqualFunc(a, b, equal)
+	case *Dict:
+		b, ok := b.(*Dict)
+		if !ok || a.Len() != b.Len() {
+			return false
+		}
+		for _, k := range a.keys {
+			v, ok := b.values[k]
+			if !ok || !equal(a.values[k], v) {
+				return false
+			}
+		}
+		return true
+	default:
+		return a == b
+	}
+}
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func toNumber(v any) (float64, bool) {
+	switch v := v.(type) {
+	case int64:
+		return float64(v), true
+	case float64:
+		return v, true
+	default:
+		return 0, false
+	}
+}
+
+var errNotComparable = errors.New("not comparable")
+
+// compare orders a and b like Python's < for numbers, strings and lists
+func compare(a, b any) (int, error) {
+	if x, ok := toNumber(a); ok {
+		if y, ok := toNumber(b); ok {
+			return cmp.Compare(x, y), nil
+		}
+	}
+
+	switch a := a.(type) {
+	case string:
+		if b, ok := b.(string); ok {
+			return strings.Compare(a, b), nil
+		}
+	case []any:
+		if b, ok := b.([]any); ok {
+			for i := range min(len(a), len(b)) {
+				if c, err := compare(a[i], b[i]); err != nil || c != 0 {
+					return c, err
+				}
+			}
+			return cmp.Compare(len(a), len(b)), nil
+		}
+	case bool:
+		return compare(int64(boolToFloat(a)), b)
+	}
+
+	return 0, fmt.Errorf("%w: %s and %s", errNotComparable, typeName(a), typeName(b))
+}
+
+// iterate returns the items of v when iterated over in a for loop
+func iterate(v any) ([]any, error) {
+	switch v := v.(type) {
+	case nil, Undefined:
+		return nil, nil
+	case []any:
+		return v, nil
+	case *Dict:
+		keys := make([]any, len(v.keys))
+		for i, k := range v.keys {
+			keys[i] = k
+		}
+		return keys, nil
+	case string:
+		var chars []any
+		for _, r := range v {
+			chars = append(chars, string(r))
+		}
+		return chars, nil
+	default:
+		return nil, fmt.Errorf("%s is not iterable", typeName(v))
+	}
+}
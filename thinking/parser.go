@@ -51,6 +51,17 @@ type Parser struct {
 	acc        strings.Builder
 }
 
+// AnswerStart returns the closing tag once thinking has started, as the answer
+// follows it, or "" otherwise
+func (s *Parser) AnswerStart() string {
+	switch s.state {
+	case thinkingState_ThinkingStartedEatingWhitespace, thinkingState_Thinking:
+		return s.ClosingTag
+	default:
+		return ""
+	}
+}
+
 // AddContent returns the thinking content and the non-thinking content that
 // should be immediately sent to the user. It will internally buffer if it needs
 // to see more raw content to disambiguate
@@ -285,3 +285,24 @@ func TestThinkingStreaming(t *testing.T) {
 		}
 	}
 }
+
+func TestAnswerStart(t *testing.T) {
+	parser := Parser{
+		OpeningTag: "<think>",
+		ClosingTag: "</think>",
+	}
+
+	if got := parser.AnswerStart(); got != "" {
+		t.Errorf("expected no answer start before thinking, got %q", got)
+	}
+
+	parser.AddContent("<think>")
+	if got := parser.AnswerStart(); got != "</think>" {
+		t.Errorf("expected answer start %q while thinking, got %q", "</think>", got)
+	}
+
+	parser.AddContent("done</think> answer")
+	if got := parser.AnswerStart(); got != "" {
+		t.Errorf("expected no answer start after thinking, got %q", got)
+	}
+}
@@ -26,6 +26,12 @@ type Parser struct {
 	n      int
 }
 
+// Tag returns the text that starts a tool call, or "{" or "[" when tool
+// calls are bare JSON objects or lists
+func (p *Parser) Tag() string {
+	return p.tag
+}
+
 func (p *Parser) GetBuffer() []byte {
 	return p.buffer
 }
@@ -0,0 +1,261 @@
+package tools
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/ollama/ollama/api"
+)
+
+// ValidateArguments checks the arguments of a call to tool against its
+// parameters, as for a strict function: properties that aren't declared
+// aren't allowed. Arguments that don't have the declared type but convert to
+// it without loss, such as numbers written as strings, are coerced. It
+// returns the coerced arguments and an error for each argument that still
+// doesn't match.
+func ValidateArguments(tool api.Tool, args api.ToolCallFunctionArguments) (api.ToolCallFunctionArguments, []api.ToolCallError) {
+	bts, err := json.Marshal(tool.Function.Parameters)
+	if err != nil {
+		return args, []api.ToolCallError{{Message: err.Error()}}
+	}
+
+	var schema map[string]any
+	if err := json.Unmarshal(bts, &schema); err != nil {
+		return args, []api.ToolCallError{{Message: err.Error()}}
+	}
+
+	var v validator
+	validated := v.validate("", schema, map[string]any(args))
+	if m, ok := validated.(map[string]any); ok {
+		args = m
+	}
+	return args, v.errs
+}
+
+type validator struct {
+	errs []api.ToolCallError
+}
+
+func (v *validator) errorf(path, format string, args ...any) {
+	v.errs = append(v.errs, api.ToolCallError{Path: path, Message: fmt.Sprintf(format, args...)})
+}
+
+// validate checks value against schema, returning value coerced to the
+// schema's type if needed
+func (v *validator) validate(path string, schema map[string]any, value any) any {
+	if anyOf, ok := schema["anyOf"].([]any); ok && len(anyOf) > 0 {
+		for _, s := range anyOf {
+			s, _ := s.(map[string]any)
+
+			var sub validator
+			if coerced := sub.validate(path, s, value); len(sub.errs) == 0 {
+				return coerced
+			}
+		}
+
+		v.errorf(path, "doesn't match any of the allowed types")
+		return value
+	}
+
+	if types := schemaTypes(schema["type"]); len(types) > 0 && !slices.ContainsFunc(types, func(t string) bool { return hasType(value, t) }) {
+		coerced, ok := coerce(value, types)
+		if !ok {
+			v.errorf(path, "must be of type %s, got %s", strings.Join(types, " or "), typeOf(value))
+			return value
+		}
+		value = coerced
+	}
+
+	if enum, ok := schema["enum"].([]any); ok && len(enum) > 0 && !slices.ContainsFunc(enum, func(e any) bool { return jsonEqual(e, value) }) {
+		bts, _ := json.Marshal(enum)
+		v.errorf(path, "must be one of %s", bts)
+	}
+
+	switch value := value.(type) {
+	case map[string]any:
+		properties, _ := schema["properties"].(map[string]any)
+		if required, ok := schema["required"].([]any); ok {
+			for _, name := range required {
+				if name, ok := name.(string); ok {
+					if _, ok := value[name]; !ok {
+						v.errorf(path+"/"+pointerEscape(name), "is required")
+					}
+				}
+			}
+		}
+
+		if properties == nil {
+			return value
+		}
+
+		keys := make([]string, 0, len(value))
+		for k := range value {
+			keys = append(keys, k)
+		}
+		slices.Sort(keys)
+
+		for _, k := range keys {
+			p, ok := properties[k].(map[string]any)
+			if !ok {
+				v.errorf(path+"/"+pointerEscape(k), "isn't a parameter of the function")
+				continue
+			}
+			value[k] = v.validate(path+"/"+pointerEscape(k), p, value[k])
+		}
+	case []any:
+		if items, ok := schema["items"].(map[string]any); ok {
+			for i := range value {
+				value[i] = v.validate(path+"/"+strconv.Itoa(i), items, value[i])
+			}
+		}
+	}
+
+	return value
+}
+
+// schemaTypes returns the types allowed by a schema's type keyword, which is
+// a string or a list of strings
+func schemaTypes(t any) []string {
+	switch t := t.(type) {
+	case string:
+		if t != "" {
+			return []string{t}
+		}
+	case []any:
+		var types []string
+		for _, t := range t {
+			if t, ok := t.(string); ok {
+				types = append(types, t)
+			}
+		}
+		return types
+	}
+	return nil
+}
+
+func hasType(value any, t string) bool {
+	switch t {
+	case "string":
+		_, ok := value.(string)
+		return ok
+	case "integer":
+		switch value := value.(type) {
+		case int, int64:
+			return true
+		case float64:
+			return value == math.Trunc(value) && !math.IsInf(value, 0)
+		}
+		return false
+	case "number":
+		switch value.(type) {
+		case int, int64, float64:
+			return true
+		}
+		return false
+	case "boolean":
+		_, ok := value.(bool)
+		return ok
+	case "object":
+		_, ok := value.(map[string]any)
+		return ok
+	case "array":
+		_, ok := value.([]any)
+		return ok
+	case "null":
+		return value == nil
+	default:
+		// unknown types aren't checked
+		return true
+	}
+}
+
+// coerce converts value to one of types if it can be converted without
+// losing information
+func coerce(value any, types []string) (any, bool) {
+	for _, t := range types {
+		switch value := value.(type) {
+		case string:
+			s := strings.TrimSpace(value)
+			switch t {
+			case "integer":
+				if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+					return int(n), true
+				}
+			case "number":
+				if f, err := strconv.ParseFloat(s, 64); err == nil && !math.IsInf(f, 0) && !math.IsNaN(f) {
+					return f, true
+				}
+			case "boolean":
+				if s == "true" || s == "false" {
+					return s == "true", true
+				}
+			case "object", "array":
+				// models sometimes write objects and arrays as JSON strings
+				var decoded any
+				if err := json.Unmarshal([]byte(s), &decoded); err == nil && hasType(decoded, t) {
+					return decoded, true
+				}
+			}
+		case float64:
+			if t == "string" {
+				return strconv.FormatFloat(value, 'f', -1, 64), true
+			}
+		case int:
+			if t == "string" {
+				return strconv.Itoa(value), true
+			}
+		case int64:
+			if t == "string" {
+				return strconv.FormatInt(value, 10), true
+			}
+		case bool:
+			if t == "string" {
+				return strconv.FormatBool(value), true
+			}
+		}
+	}
+	return value, false
+}
+
+func typeOf(value any) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case string:
+		return "string"
+	case int, int64, float64:
+		return "number"
+	case bool:
+		return "boolean"
+	case map[string]any:
+		return "object"
+	case []any:
+		return "array"
+	default:
+		return reflect.TypeOf(value).String()
+	}
+}
+
+// jsonEqual reports whether a and b have the same JSON encoding, so numbers
+// of different Go types compare equal
+func jsonEqual(a, b any) bool {
+	ab, err := json.Marshal(a)
+	if err != nil {
+		return false
+	}
+	bb, err := json.Marshal(b)
+	if err != nil {
+		return false
+	}
+	return string(ab) == string(bb)
+}
+
+// pointerEscape escapes a property name for a JSON pointer
+func pointerEscape(s string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(s, "~", "~0"), "/", "~1")
+}
@@ -0,0 +1,82 @@
+package tools
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/ollama/ollama/api"
+)
+
+func TestValidateArguments(t *testing.T) {
+	tool := api.Tool{
+		Type: "function",
+		Function: api.ToolFunction{
+			Name:   "book_flight",
+			Strict: true,
+			Parameters: api.ToolFunctionParameters{
+				Type:     "object",
+				Required: []string{"destination", "passengers"},
+				Properties: map[string]api.ToolProperty{
+					"destination": {Type: api.PropertyType{"string"}},
+					"passengers":  {Type: api.PropertyType{"integer"}},
+					"price":       {Type: api.PropertyType{"number"}},
+					"refundable":  {Type: api.PropertyType{"boolean"}},
+					"class":       {Type: api.PropertyType{"string"}, Enum: []any{"economy", "business"}},
+					"dates":       {Type: api.PropertyType{"array"}, Items: map[string]any{"type": "string"}},
+					"seat":        {Type: api.PropertyType{"string", "null"}},
+					"bags":        {AnyOf: []api.ToolProperty{{Type: api.PropertyType{"integer"}}, {Type: api.PropertyType{"boolean"}}}},
+				},
+			},
+		},
+	}
+
+	cases := []struct {
+		name     string
+		args     api.ToolCallFunctionArguments
+		expected api.ToolCallFunctionArguments
+		errs     []api.ToolCallError
+	}{
+		{
+			name:     "valid",
+			args:     api.ToolCallFunctionArguments{"destination": "Paris", "passengers": float64(2), "class": "economy", "dates": []any{"2025-10-01"}, "seat": nil},
+			expected: api.ToolCallFunctionArguments{"destination": "Paris", "passengers": float64(2), "class": "economy", "dates": []any{"2025-10-01"}, "seat": nil},
+		},
+		{
+			name:     "coerced",
+			args:     api.ToolCallFunctionArguments{"destination": float64(1234), "passengers": " 2", "price": "99.5", "refundable": "false", "dates": `["2025-10-01"]`, "bags": "true"},
+			expected: api.ToolCallFunctionArguments{"destination": "1234", "passengers": 2, "price": 99.5, "refundable": false, "dates": []any{"2025-10-01"}, "bags": true},
+		},
+		{
+			name:     "missing required",
+			args:     api.ToolCallFunctionArguments{"destination": "Paris"},
+			expected: api.ToolCallFunctionArguments{"destination": "Paris"},
+			errs:     []api.ToolCallError{{Path: "/passengers", Message: "is required"}},
+		},
+		{
+			name:     "invalid",
+			args:     api.ToolCallFunctionArguments{"destination": "Paris", "passengers": 2.5, "refundable": "yes", "class": "first", "dates": []any{"2025-10-01", true}, "bags": "two", "meal/type": "vegan"},
+			expected: api.ToolCallFunctionArguments{"destination": "Paris", "passengers": 2.5, "refundable": "yes", "class": "first", "dates": []any{"2025-10-01", "true"}, "bags": "two", "meal/type": "vegan"},
+			errs: []api.ToolCallError{
+				{Path: "/bags", Message: "doesn't match any of the allowed types"},
+				{Path: "/class", Message: `must be one of ["economy","business"]`},
+				{Path: "/meal~1type", Message: "isn't a parameter of the function"},
+				{Path: "/passengers", Message: "must be of type integer, got number"},
+				{Path: "/refundable", Message: "must be of type boolean, got string"},
+			},
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			args, errs := ValidateArguments(tool, tt.args)
+			if diff := cmp.Diff(tt.expected, args); diff != "" {
+				t.Errorf("arguments mismatch (-want +got):\n%s", diff)
+			}
+
+			if diff := cmp.Diff(tt.errs, errs); diff != "" {
+				t.Errorf("errors mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}